@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"lc3/pkg/asm"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// asmCommand assembles source files into an object file next to the
+// first, along with its symbol table, debug info and optionally a
+// listing, "lc3 asm [-o object] [-listing] source...".
+func asmCommand(args []string) {
+	flags := flag.NewFlagSet("asm", flag.ExitOnError)
+	output := flags.String("o", "", "write the object to `file`, by default the name of the first source with a .obj extension")
+	var includePath stringList
+	flags.Var(&includePath, "I", "look for included files in `dir`, can be repeated")
+
+	listing := flags.Bool("listing", false, "also write a listing of addresses, words and source lines with a .lst extension")
+
+	dialect := flags.String("dialect", "lc3", "accept the syntax of another assembler, one of "+strings.Join(asm.DialectNames(), ", "))
+
+	trampolines := flags.Bool("trampolines", false, "assemble branches out of reach into trampolines, which overwrite R7")
+
+	mulDiv := flags.Bool("muldiv", false, "assemble the MUL, DIV and MOD instructions of the multiply and divide extension, which lc3 -muldiv runs")
+
+	testAndSet := flags.Bool("tas", false, "assemble the TAS instruction of the test-and-set extension, which lc3 -tas runs")
+
+	wideRegisters := flags.Bool("wide", false, "assemble R8 to R15 of the experimental mode of 16 registers, which lc3 -wide runs")
+
+	format := flags.String("diagnostics", "text", "report errors as `format` text, or json on stdout")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 asm [flags] source-file ...\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	source := flags.Arg(0)
+
+	if *output == "" {
+		*output = strings.TrimSuffix(source, filepath.Ext(source)) + ".obj"
+	}
+
+	if *format != "text" && *format != "json" {
+		fatalf("unknown diagnostics format %s, expected text or json", *format)
+	}
+
+	d, ok := asm.LookupDialect(*dialect)
+	if !ok {
+		fatalf("unknown dialect %s, expected one of %s", *dialect, strings.Join(asm.DialectNames(), ", "))
+	}
+
+	opts := []asm.Option{asm.WithIncludePath(includePath...), asm.WithDialect(d)}
+	if *trampolines {
+		opts = append(opts, asm.WithTrampolines())
+	}
+
+	if *mulDiv {
+		opts = append(opts, asm.WithMulDiv())
+	}
+
+	if *testAndSet {
+		opts = append(opts, asm.WithTestAndSet())
+	}
+
+	if *wideRegisters {
+		opts = append(opts, asm.WithWideRegisters())
+	}
+
+	obj, table, diagnostics, err := asm.AssembleFiles(flags.Args(), opts...)
+	if err != nil {
+		fatalf("failed to assemble: %v", err)
+	}
+
+	if *format == "json" {
+		// an empty list tells scripts the program assembled.
+		if err := diagnostics.WriteJSON(os.Stdout); err != nil {
+			fatal(err)
+		}
+	} else {
+		for _, d := range diagnostics {
+			fmt.Fprint(os.Stderr, d.Format())
+		}
+	}
+
+	if len(diagnostics) > 0 {
+		os.Exit(1)
+	}
+
+	writeFile(*output, func(w io.Writer) error {
+		_, err := obj.WriteTo(w)
+		return err
+	})
+
+	base := strings.TrimSuffix(*output, filepath.Ext(*output))
+
+	writeFile(base+".sym", table.Write)
+
+	// source paths are relative to the debug info, which resolves them
+	// against its own directory.
+	for i, line := range obj.Lines {
+		obj.Lines[i].File = relativePath(filepath.Dir(base), line.File)
+	}
+
+	writeFile(base+".debug", obj.DebugInfo().Write)
+
+	// objects linked with others carry their globals and relocations.
+	if len(obj.Globals) > 0 || len(obj.Relocations) > 0 {
+		writeFile(base+".rel", obj.WriteRelocations)
+	}
+
+	if *listing {
+		writeFile(base+".lst", obj.WriteListing)
+	}
+}
+
+// relativePath returns the path of a file relative to a directory, or
+// its absolute path if there is none.
+func relativePath(dir, file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return abs
+	}
+
+	if rel, err := filepath.Rel(absDir, abs); err == nil {
+		return rel
+	}
+
+	return abs
+}
+
+// writeFile creates a file and writes it with write, exiting on
+// failure.
+func writeFile(filename string, write func(w io.Writer) error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		fatalf("failed to write %s: %v", filename, err)
+	}
+
+	if err := write(file); err != nil {
+		fatalf("failed to write %s: %v", filename, err)
+	}
+
+	if err := file.Close(); err != nil {
+		fatalf("failed to write %s: %v", filename, err)
+	}
+}
+
+// stringList is a flag that can be repeated to collect values.
+type stringList []string
+
+// String returns the values separated by commas.
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set adds a value.
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestAsmCommandFiles runs "lc3 asm -o prog.obj a.asm b.asm", the form
+// the README documents, and checks that both files land in the object
+// and that the symbol table holds the labels of both.
+func TestAsmCommandFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"a.asm": "\t.ORIG x3000\nMAIN\tLD R0, DATA\n\tHALT\n\t.END\n",
+		"b.asm": "\t.ORIG x3002\nDATA\t.FILL x1234\n\t.END\n",
+	}
+
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := filepath.Join(dir, "prog.obj")
+
+	asmCommand([]string{"-o", output, filepath.Join(dir, "a.asm"), filepath.Join(dir, "b.asm")})
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{0x30, 0x00, 0x20, 0x01, 0xF0, 0x25, 0x12, 0x34}
+
+	if !bytes.Equal(data, expected) {
+		t.Errorf("object % X, expected % X", data, expected)
+	}
+
+	table, err := os.ReadFile(filepath.Join(dir, "prog.sym"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, label := range []string{"MAIN", "DATA"} {
+		if !bytes.Contains(table, []byte(label)) {
+			t.Errorf("symbol table lacks %s:\n%s", label, table)
+		}
+	}
+}
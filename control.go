@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"lc3/pkg/control"
+	"os"
+)
+
+// controlCommand serves the JSON-RPC control protocol on stdin and
+// stdout, optionally with an image loaded, "lc3 control [-framed]
+// [-run] [image]".
+func controlCommand(args []string) {
+	flags := flag.NewFlagSet("control", flag.ExitOnError)
+	framed := flags.Bool("framed", false, "multiplex the control protocol, the console and events in length-prefixed frames")
+	run := flags.Bool("run", false, "run the image before the first request")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 control [flags] [image-file]\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() > 1 || (*run && flags.NArg() == 0) {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var opts []control.Option
+
+	if flags.NArg() == 1 {
+		image, err := readImage(flags.Arg(0))
+		if err != nil {
+			fatalf("failed to load image: %s, %v", flags.Arg(0), err)
+		}
+
+		opts = append(opts, control.WithImage(image, 0x3000))
+	}
+
+	if *run {
+		opts = append(opts, control.WithRun())
+	}
+
+	conn := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+
+	serve := control.Serve
+	if *framed {
+		serve = control.ServeFramed
+	}
+
+	if err := serve(conn, opts...); err != nil {
+		fatalf("control server failed %v", err)
+	}
+}
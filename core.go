@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"lc3/pkg/core"
+	"lc3/pkg/golden"
+	"os"
+)
+
+// coreCommand browses a core file written by -core, "lc3 core inspect
+// [-mem address] [-n count] core". It shows the error, the faulting
+// instruction, the registers and the backtrace, and with -mem the
+// memory from an address on.
+func coreCommand(args []string) {
+	flags := flag.NewFlagSet("core", flag.ExitOnError)
+	sym := flags.String("sym", "", "load labels from a symbol table `file`, by default the core name with a .sym extension")
+	mem := flags.String("mem", "", "show memory from `address` on, in hex as x4000, a label or pc")
+	count := flags.Int("n", 16, "show `count` words of memory")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 core inspect [flags] core-file\n")
+		flags.PrintDefaults()
+	}
+
+	if len(args) == 0 || args[0] != "inspect" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	flags.Parse(args[1:])
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	c, err := core.Load(flags.Arg(0))
+	if err != nil {
+		fatalf("failed to load core: %v", err)
+	}
+
+	table, err := findSymbols(flags.Arg(0), *sym)
+	if err != nil {
+		fatalf("failed to load symbols: %v", err)
+	}
+
+	if *mem == "" {
+		if err := c.WriteSummary(os.Stdout, table); err != nil {
+			fatal(err)
+		}
+
+		return
+	}
+
+	address := c.PC
+	if *mem != "pc" {
+		if address, err = golden.ResolveAddress(*mem, table); err != nil {
+			fatal(err)
+		}
+	}
+
+	if err := c.WriteMemory(os.Stdout, address, *count, table); err != nil {
+		fatal(err)
+	}
+}
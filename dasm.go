@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"lc3/pkg/asm"
+	"lc3/pkg/debuginfo"
+	"lc3/pkg/disasm"
+	"lc3/pkg/profile"
+	"lc3/pkg/symbols"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dasmCommand disassembles an object file, or the object assembled
+// from a source file, into assembly language that assembles back into
+// it. Addresses are named after the labels of its symbol table or
+// debug info if there is one, "lc3 dasm [-o source] [-style style]
+// [-verify] [-cfg] [-hexdump] [-profile profile] object".
+func dasmCommand(args []string) {
+	flags := flag.NewFlagSet("dasm", flag.ExitOnError)
+	output := flags.String("o", "", "write the source to `file` instead of stdout")
+	symbolFile := flags.String("sym", "", "name addresses after the labels of a symbol table `file`, by default the object name with a .sym extension")
+	debugInfoFile := flags.String("debuginfo", "", "name addresses after the labels of a debug info `file`, by default the object name with a .debug extension")
+	verify := flags.Bool("verify", false, "check that the disassembly assembles back into the same words instead of writing it")
+	cfg := flags.Bool("cfg", false, "write the control flow graph in Graphviz DOT instead of the source")
+	hexdump := flags.Bool("hexdump", false, "write an annotated hexdump of the words instead of the source")
+	styleName := flags.String("style", "lc3", "write the source in a `style`: "+strings.Join(disasm.StyleNames(), ", "))
+	profileFile := flags.String("profile", "", "annotate the instructions with their execution counts from a profile `file` written by lc3 -profile")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 dasm [flags] [object-file | source-file]\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	style, ok := disasm.LookupStyle(*styleName)
+	if !ok {
+		fatalf("unknown style %s, expected one of %s", *styleName, strings.Join(disasm.StyleNames(), ", "))
+	}
+
+	filename := flags.Arg(0)
+
+	var (
+		obj   *asm.Object
+		table *symbols.Table
+		info  *debuginfo.Info
+		err   error
+	)
+
+	if strings.EqualFold(filepath.Ext(filename), ".asm") {
+		obj, table, info = assembleSource(filename)
+	} else {
+		obj, err = readObject(filename)
+		if err != nil {
+			fatalf("failed to read object: %v", err)
+		}
+
+		table, err = findSymbols(filename, *symbolFile)
+		if err != nil {
+			fatalf("failed to load symbols: %v", err)
+		}
+
+		info, err = findDebugInfo(filename, *debugInfoFile)
+		if err != nil {
+			fatalf("failed to load debug info: %v", err)
+		}
+	}
+
+	opts := []disasm.Option{disasm.WithSymbols(table), disasm.WithDebugInfo(info)}
+
+	if *verify {
+		if err := disasm.Verify(obj.Origin, obj.Words, opts...); err != nil {
+			fatalf("%s: %v", filename, err)
+		}
+
+		fmt.Printf("%s: the disassembly assembles back into the same %d words\n", filename, len(obj.Words))
+
+		return
+	}
+
+	program := disasm.Disassemble(obj.Origin, obj.Words, opts...)
+
+	write := func(w io.Writer) error {
+		return program.WriteStyle(w, style)
+	}
+
+	switch {
+	case *cfg:
+		name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+
+		write = func(w io.Writer) error {
+			return program.ControlFlow().WriteDOT(w, name)
+		}
+	case *hexdump:
+		write = program.WriteHexdump
+	case *profileFile != "":
+		prof, err := profile.Load(*profileFile)
+		if err != nil {
+			fatalf("failed to load profile: %v", err)
+		}
+
+		write = func(w io.Writer) error {
+			return program.WriteProfile(w, prof.Count)
+		}
+	}
+
+	if *output == "" {
+		if err := write(os.Stdout); err != nil {
+			fatal(err)
+		}
+
+		return
+	}
+
+	writeFile(*output, write)
+}
+
+// assembleSource assembles a source file in memory, exiting with its
+// diagnostics if it does not assemble.
+func assembleSource(filename string) (*asm.Object, *symbols.Table, *debuginfo.Info) {
+	obj, table, diagnostics, err := asm.AssembleFile(filename)
+	if err != nil {
+		fatalf("failed to assemble: %v", err)
+	}
+
+	if len(diagnostics) > 0 {
+		for _, d := range diagnostics {
+			fmt.Fprint(os.Stderr, d.Format())
+		}
+
+		os.Exit(1)
+	}
+
+	return obj, table, obj.DebugInfo()
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/dap"
+	"lc3/pkg/debugger"
+	"lc3/pkg/debuginfo"
+	"lc3/pkg/devices"
+	"lc3/pkg/gdbstub"
+	"lc3/pkg/readline"
+	"lc3/pkg/remote"
+	"lc3/pkg/symbols"
+	"lc3/pkg/term"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// debugCommand runs the first image under the interactive debugger.
+func debugCommand(args []string) {
+	flag.CommandLine.Parse(args)
+
+	images := loadArguments(flag.Args())
+	setup := loadSetup()
+
+	// commands typed at a terminal are edited as they are typed.
+	editable := *joystickSource != "keys" && term.IsTerminal(int(os.Stdin.Fd()))
+
+	// the debugger and the program share the console, so both read
+	// from the same buffered reader to avoid losing input.
+	stdin := bufio.NewReader(setup.input)
+	setup.input = stdin
+
+	var (
+		out     io.Writer = os.Stdout
+		console io.Writer = os.Stdout
+		server  *remote.Server
+	)
+
+	if *listenAddress != "" {
+		server = remote.NewServer()
+		out = server.Output()
+		console = io.MultiWriter(os.Stdout, server.Console())
+	}
+
+	newCPU := func() cpu.CPU {
+		return cpu.NewCPU(append(cpuOptions(setup), cpu.WithOutput(console))...)
+	}
+
+	dbg := debugger.New(newCPU, images[0], stdin, out)
+
+	if table := loadSymbols(flag.Arg(0)); table != nil {
+		dbg.SetSymbols(table)
+	}
+
+	info, err := findDebugInfo(flag.Arg(0), *debugInfoFile)
+	if err != nil {
+		fatalf("failed to load debug info: %v", err)
+	}
+
+	dbg.SetDebugInfo(info)
+
+	if *checkpointEvery > 0 {
+		dbg.SetCheckpoints(*checkpointEvery, *checkpointKeep)
+	}
+
+	if *transcriptFile != "" {
+		file, err := os.Create(*transcriptFile)
+		if err != nil {
+			fatalf("failed to create transcript: %v", err)
+		}
+
+		defer file.Close()
+
+		dbg.Record(file)
+	}
+
+	if *commandScript != "" {
+		file, err := os.Open(*commandScript)
+		if err != nil {
+			fatalf("failed to load debugger script: %v", err)
+		}
+
+		err = dbg.Source(file)
+		file.Close()
+
+		if err == debugger.ErrQuit {
+			return
+		}
+
+		if err != nil {
+			fatalf("Debugger script failed %s: %v", *commandScript, err)
+		}
+	}
+
+	if server != nil {
+		server.SetDebugger(dbg)
+
+		logger("debugger").Info("serving the debugger to WebSocket clients", "address", *listenAddress)
+
+		if err := http.ListenAndServe(*listenAddress, server); err != nil {
+			fatalf("Debugger failed %v", err)
+		}
+
+		return
+	}
+
+	if editable {
+		editor := readline.New(int(os.Stdin.Fd()), stdin, os.Stdout, readline.WithCompleter(dbg.Complete))
+
+		if err := editor.LoadHistory(historyPath()); err != nil {
+			logger("debugger").Warn("failed to load the command history", "err", err)
+		}
+
+		dbg.SetLineReader(editor.ReadLine)
+	}
+
+	// Ctrl+C breaks into the prompt while the program runs and quits
+	// at the prompt.
+	dbg.SetInterrupter(term.OnInterrupt)
+
+	if err := dbg.Run(); err != nil {
+		fatalf("Debugger failed %v", err)
+	}
+}
+
+// historyPath returns the file given with -history, or .lc3_history in
+// the home directory.
+func historyPath() string {
+	if *historyFile != "" {
+		return *historyFile
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".lc3_history"
+	}
+
+	return filepath.Join(home, ".lc3_history")
+}
+
+// loadSymbols loads the symbol table given with -sym, or the one next
+// to the image if it exists.
+func loadSymbols(image string) *symbols.Table {
+	table, err := findSymbols(image, *symbolFile)
+	if err != nil {
+		fatalf("failed to load symbols: %v", err)
+	}
+
+	return table
+}
+
+// findSymbols loads the symbol table filename, or if it is empty the
+// one next to the image. It returns nil if there is none.
+func findSymbols(image, filename string) (*symbols.Table, error) {
+	if filename == "" {
+		filename = strings.TrimSuffix(image, filepath.Ext(image)) + ".sym"
+
+		if _, err := os.Stat(filename); err != nil {
+			return nil, nil
+		}
+	}
+
+	return symbols.Load(filename)
+}
+
+// findDebugInfo loads the debug info filename, or if it is empty the
+// one next to the image. It returns nil if there is none.
+func findDebugInfo(image, filename string) (*debuginfo.Info, error) {
+	if filename == "" {
+		filename = strings.TrimSuffix(image, filepath.Ext(image)) + ".debug"
+
+		if _, err := os.Stat(filename); err != nil {
+			return nil, nil
+		}
+	}
+
+	return debuginfo.Load(filename)
+}
+
+// gdbserverCommand serves the first image to a GDB front-end over the
+// remote serial protocol, "lc3 gdbserver [flags] ADDRESS image".
+func gdbserverCommand(args []string) {
+	flag.CommandLine.Parse(args)
+
+	if flag.NArg() < 2 {
+		fatal("lc3 gdbserver [flags] [address] [image-file]")
+	}
+
+	addr := flag.Arg(0)
+	images := loadArguments(flag.Args()[1:])
+	setup := loadSetup()
+
+	newCPU := func() cpu.CPU {
+		return cpu.NewCPU(cpuOptions(setup)...)
+	}
+
+	dbg := debugger.New(newCPU, images[0], nil, io.Discard)
+
+	logger("gdbserver").Info("listening for GDB", "address", addr)
+
+	if err := gdbstub.ListenAndServe(addr, dbg); err != nil {
+		fatalf("GDB server failed %v", err)
+	}
+}
+
+// dapCommand serves the Debug Adapter Protocol on stdin and stdout, or
+// to the first client connecting to an address, "lc3 dap [address]".
+func dapCommand(args []string) {
+	flag.CommandLine.Parse(args)
+
+	var conn io.ReadWriter = struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+
+	if addr := flag.Arg(0); addr != "" {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			fatalf("DAP server failed %v", err)
+		}
+
+		logger("dap").Info("listening for DAP clients", "address", addr)
+
+		c, err := listener.Accept()
+		listener.Close()
+
+		if err != nil {
+			fatalf("DAP server failed %v", err)
+		}
+
+		defer c.Close()
+		conn = c
+	}
+
+	if err := dap.Serve(conn, launchDAP); err != nil {
+		fatalf("DAP server failed %v", err)
+	}
+}
+
+// launchDAP loads a program for a DAP client. Its console output is
+// sent to the client and its input, if any, comes from a key script.
+func launchDAP(args dap.LaunchArguments, stdout, console io.Writer) (*debugger.Debugger, error) {
+	image, err := readImage(args.Program)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []devices.KeyEvent
+	if args.Keys != "" {
+		if events, err = readKeyScript(args.Keys); err != nil {
+			return nil, err
+		}
+	}
+
+	table, err := findSymbols(args.Program, args.Symbols)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := findDebugInfo(args.Program, args.DebugInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	newCPU := func() cpu.CPU {
+		opts := []cpu.Option{
+			cpu.WithDevice(devices.NewRTC(time.Now)),
+			cpu.WithDevice(devices.NewRNG(time.Now().UnixNano())),
+			cpu.WithDevice(devices.NewTerminal(stdout)),
+			cpu.WithInput(strings.NewReader("")),
+			cpu.WithOutput(stdout),
+		}
+
+		if *utf8Output {
+			opts = append(opts, cpu.WithUTF8Output())
+		}
+
+		if events != nil {
+			keyboard := devices.NewScriptedKeyboard(events, time.Now)
+
+			opts = append(opts,
+				cpu.WithDevice(keyboard),
+				cpu.WithInput(keyboard.Input()),
+			)
+		}
+
+		return cpu.NewCPU(opts...)
+	}
+
+	dbg := debugger.New(newCPU, image, nil, console)
+	dbg.SetSymbols(table)
+	dbg.SetDebugInfo(info)
+
+	return dbg, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/difftest"
+	"lc3/pkg/golden"
+	"lc3/pkg/trace"
+	"os"
+	"strings"
+)
+
+// difftestCommand runs a program on this VM and on a reference
+// simulator in lockstep and reports the first instruction after which
+// their registers differ, "lc3 difftest [flags] program command...".
+func difftestCommand(args []string) {
+	flags := flag.NewFlagSet("difftest", flag.ExitOnError)
+	protocolName := flags.String("protocol", "lc3sim", "drive the reference with `protocol`: "+strings.Join(difftest.ProtocolNames(), ", "))
+	step := flags.String("step", "", "step the reference with `command` instead of the one of the protocol")
+	at := flags.String("at", "", "compare only after the instructions at the addresses or labels in `list`, as x3010,LOOP")
+	every := flags.Uint64("every", 1, "compare only every `n` instructions")
+	ignore := flags.String("ignore", "", "leave the registers in `list` out of the comparison, as R7")
+	limit := flags.Uint64("limit", golden.DefaultLimit, "stop after `n` instructions")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 difftest [flags] [program-file] [reference-command] ...\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	protocol, ok := difftest.LookupProtocol(*protocolName)
+	if !ok {
+		fatalf("unknown protocol %s, expected one of %s", *protocolName, strings.Join(difftest.ProtocolNames(), ", "))
+	}
+
+	if *step != "" {
+		custom := *protocol
+		custom.Step = *step
+		protocol = &custom
+	}
+
+	image, table, _, err := golden.LoadProgram(flags.Arg(0))
+	if err != nil {
+		fatalf("failed to load program: %v", err)
+	}
+
+	opts := []difftest.Option{difftest.WithEvery(*every), difftest.WithLimit(*limit)}
+
+	if *at != "" {
+		addresses, _, err := trace.ParseCells(*at, table)
+		if err != nil {
+			fatalf("invalid checkpoints: %v", err)
+		}
+
+		opts = append(opts, difftest.WithCheckpoints(addresses...))
+	}
+
+	for _, name := range strings.Split(*ignore, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		r, ok := golden.LookupRegister(name)
+		if !ok || r > 7 {
+			fatalf("unknown register %s", name)
+		}
+
+		opts = append(opts, difftest.WithIgnored(r))
+	}
+
+	ref, err := difftest.Start(protocol, flags.Args()[1:])
+	if err != nil {
+		fatalf("failed to start the reference: %v", err)
+	}
+
+	vm := cpu.NewCPU(cpu.WithInput(strings.NewReader("")), cpu.WithOutput(io.Discard))
+
+	divergence, steps, err := difftest.Compare(image, vm, ref, opts...)
+	ref.Close()
+
+	if err != nil {
+		fatalf("Comparison failed after %d instructions: %v", steps, err)
+	}
+
+	if divergence != nil {
+		divergence.Write(os.Stdout)
+		os.Exit(1)
+	}
+
+	fmt.Printf("same for %d instructions\n", steps)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"lc3/pkg/grade"
+	"lc3/pkg/htmlreport"
+	"os"
+	"path/filepath"
+)
+
+// gradeCommand scores programs on a rubric, writing a JSON report per
+// program, one per line, "lc3 grade [-o report] rubric program...".
+// Programs are graded in parallel, and a directory stands for every
+// .asm source and .obj image in it.
+func gradeCommand(args []string) {
+	flags := flag.NewFlagSet("grade", flag.ExitOnError)
+	output := flags.String("o", "", "write the reports to `file` instead of stdout")
+	workers := flags.Int("j", 0, "grade `n` programs at a time, by default one per CPU")
+	summary := flags.Bool("summary", false, "log the distribution of the scores and how many programs passed each criterion")
+	htmlFile := flags.String("html", "", "write an HTML report of every program to `file`")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 grade [flags] [rubric-file] [program-file | directory] ...\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	rubric, err := grade.Load(flags.Arg(0))
+	if err != nil {
+		fatalf("failed to load rubric: %v", err)
+	}
+
+	programs, err := findPrograms(flags.Args()[1:])
+	if err != nil {
+		fatal(err)
+	}
+
+	var reports []*grade.Report
+
+	page := htmlreport.New(rubric.Name)
+
+	write := func(w io.Writer) error {
+		return grade.GradeAll(rubric, programs, *workers, func(report *grade.Report) error {
+			logger("grader").Info("graded", "program", report.Program, "score", report.Score, "total", report.Total)
+
+			if *htmlFile != "" {
+				page.AddGrade(report)
+			}
+
+			// the run is let go once reported, so that grading a class
+			// does not keep every CPU alive.
+			report.Execution = nil
+			reports = append(reports, report)
+
+			return report.WriteJSON(w)
+		})
+	}
+
+	if *output != "" {
+		writeFile(*output, write)
+	} else if err := write(os.Stdout); err != nil {
+		fatal(err)
+	}
+
+	if *htmlFile != "" {
+		writeFile(*htmlFile, page.Write)
+	}
+
+	if *summary {
+		grade.Summarize(reports).Write(os.Stderr)
+	}
+}
+
+// findPrograms lists the programs given as arguments, replacing
+// directories with the .asm sources and .obj images in them.
+func findPrograms(args []string) ([]string, error) {
+	var programs []string
+
+	for _, arg := range args {
+		stat, err := os.Stat(arg)
+		if err != nil || !stat.IsDir() {
+			programs = append(programs, arg)
+			continue
+		}
+
+		for _, pattern := range []string{"*.asm", "*.obj"} {
+			matches, err := filepath.Glob(filepath.Join(arg, pattern))
+			if err != nil {
+				return nil, err
+			}
+
+			programs = append(programs, matches...)
+		}
+	}
+
+	return programs, nil
+}
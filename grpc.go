@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"lc3/pkg/grpcserver"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+)
+
+// grpcCommand serves the Machine service of proto/machine.proto over
+// gRPC, optionally with an image loaded, "lc3 grpc [-listen address]
+// [-cert file -key file] [image]".
+func grpcCommand(args []string) {
+	flags := flag.NewFlagSet("grpc", flag.ExitOnError)
+	listen := flags.String("listen", "localhost:50051", "serve gRPC on `address`")
+	certFile := flags.String("cert", "", "serve TLS with the certificate in `file`, by default a self-signed one generated at startup")
+	keyFile := flags.String("key", "", "serve TLS with the private key in `file`")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 grpc [flags] [image-file]\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() > 1 || (*certFile == "") != (*keyFile == "") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	server := grpcserver.New()
+
+	if flags.NArg() == 1 {
+		image, err := readImage(flags.Arg(0))
+		if err != nil {
+			fatalf("failed to load image: %s, %v", flags.Arg(0), err)
+		}
+
+		server.Load(image, 0x3000)
+	}
+
+	httpServer := &http.Server{Addr: *listen, Handler: server}
+
+	if *certFile == "" {
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			fatalf("failed to generate a certificate: %v", err)
+		}
+
+		httpServer.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+		logger("grpc").Info("generated a self-signed certificate", "sha256", fmt.Sprintf("%X", sha256.Sum256(cert.Certificate[0])))
+	}
+
+	logger("grpc").Info("serving the Machine service over gRPC", "address", *listen)
+
+	if err := httpServer.ListenAndServeTLS(*certFile, *keyFile); err != nil {
+		fatalf("failed to serve: %v", err)
+	}
+}
+
+// selfSignedCertificate generates a certificate for localhost valid for
+// a year, for clients that skip verification such as grpcurl -insecure.
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "lc3"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
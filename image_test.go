@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"lc3/pkg/cpu"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	log.SetOutput(io.Discard)
+}
+
+// object encodes an LC-3 object of n words counting up from 1 at an
+// origin.
+func object(origin uint16, n int) []byte {
+	data := binary.BigEndian.AppendUint16(nil, origin)
+	for i := 0; i < n; i++ {
+		data = binary.BigEndian.AppendUint16(data, uint16(i+1))
+	}
+
+	return data
+}
+
+// TestDecodeImage checks that objects land at their origin and that
+// those without an origin, ending in half a word or running past the
+// end of memory are refused.
+func TestDecodeImage(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		origin uint16
+		words  int
+		fails  bool
+	}{
+		{name: "minimal", data: object(0x3000, 0), origin: 0x3000},
+		{name: "one word", data: object(0x3000, 1), origin: 0x3000, words: 1},
+		{name: "last word", data: object(0xFFFF, 1), origin: 0xFFFF, words: 1},
+		{name: "all of memory", data: object(0x0000, cpu.MemoryMax), words: cpu.MemoryMax},
+		{name: "empty", data: nil, fails: true},
+		{name: "half an origin", data: []byte{0x30}, fails: true},
+		{name: "half a word", data: append(object(0x3000, 1), 0x12), fails: true},
+		{name: "past the end", data: object(0xFFFF, 2), fails: true},
+		{name: "more than memory", data: object(0x0000, cpu.MemoryMax+1), fails: true},
+	} {
+		m, err := decodeImage(tc.data)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("%s: decoded, expected an error", tc.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		for address, word := range m {
+			var expected uint16
+			if offset := address - int(tc.origin); offset >= 0 && offset < tc.words {
+				expected = uint16(offset + 1)
+			}
+
+			if word != expected {
+				t.Errorf("%s: x%04X = %d, expected %d", tc.name, address, word, expected)
+				break
+			}
+		}
+	}
+}
+
+// TestReadImage checks that reading an object file names it in errors.
+func TestReadImage(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "prog.obj")
+
+	if err := os.WriteFile(filename, object(0x3000, 3), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := readImage(filename)
+	if err != nil || m[0x3002] != 3 {
+		t.Errorf("read x3002 = %d, %v, expected 3", m[0x3002], err)
+	}
+
+	if err := os.WriteFile(filename, []byte{0x30}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readImage(filename); err == nil {
+		t.Error("read an object without an origin")
+	}
+}
+
+// BenchmarkDecodeImageMinimal measures decoding an object of an origin
+// alone.
+func BenchmarkDecodeImageMinimal(b *testing.B) {
+	benchmarkDecodeImage(b, object(0x3000, 0))
+}
+
+// BenchmarkDecodeImageLarge measures decoding an object filling memory.
+func BenchmarkDecodeImageLarge(b *testing.B) {
+	benchmarkDecodeImage(b, object(0x0000, cpu.MemoryMax))
+}
+
+// benchmarkDecodeImage decodes an object b.N times.
+func benchmarkDecodeImage(b *testing.B, data []byte) {
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeImage(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"lc3/pkg/kernel"
+	"lc3/pkg/sandbox"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// kernelCommand runs the Jupyter kernel on the connection file Jupyter
+// gives it, or installs its kernel spec, "lc3 kernel [-f file]
+// [-install] [-max-instructions n] [-timeout d]".
+func kernelCommand(args []string) {
+	flags := flag.NewFlagSet("kernel", flag.ExitOnError)
+	connectionFile := flags.String("f", "", "serve the notebook on the sockets of the connection `file`")
+	install := flags.Bool("install", false, "install the kernel spec for the user, so that Jupyter lists the kernel")
+	maxInstructions := flags.Uint64("max-instructions", sandbox.DefaultMaxInstructions, "stop cells after `n` instructions")
+	timeout := flags.Duration("timeout", sandbox.DefaultTimeout, "stop cells after `duration`")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 kernel [flags]\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 0 || *install == (*connectionFile != "") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if *install {
+		dir, err := installKernelSpec()
+		if err != nil {
+			fatalf("failed to install the kernel spec: %v", err)
+		}
+
+		logger("kernel").Info("installed the kernel spec", "dir", dir)
+
+		return
+	}
+
+	info, err := kernel.ReadConnectionFile(*connectionFile)
+	if err != nil {
+		fatalf("failed to read the connection file: %v", err)
+	}
+
+	k, err := kernel.New(info, sandbox.WithMaxInstructions(*maxInstructions), sandbox.WithTimeout(*timeout))
+	if err != nil {
+		fatalf("failed to start the kernel: %v", err)
+	}
+
+	if err := k.Serve(); err != nil {
+		fatalf("kernel failed: %v", err)
+	}
+}
+
+// installKernelSpec writes the kernel spec, running this executable,
+// to the kernels directory of the user and returns its directory.
+func installKernelSpec() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := userKernelsDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir = filepath.Join(dir, "lc3")
+
+	spec, err := json.MarshalIndent(map[string]any{
+		"argv":         []string{executable, "kernel", "-f", "{connection_file}"},
+		"display_name": "LC-3",
+		"language":     "lc3",
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	return dir, os.WriteFile(filepath.Join(dir, "kernel.json"), append(spec, '\n'), 0o644)
+}
+
+// userKernelsDir returns the directory Jupyter looks for the kernels
+// of the user in.
+func userKernelsDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "Jupyter", "kernels"), nil
+	case "windows":
+		return filepath.Join(os.Getenv("APPDATA"), "jupyter", "kernels"), nil
+	}
+
+	if data := os.Getenv("XDG_DATA_HOME"); data != "" {
+		return filepath.Join(data, "jupyter", "kernels"), nil
+	}
+
+	return filepath.Join(home, ".local", "share", "jupyter", "kernels"), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"lc3/pkg/lc3x"
+	"lc3/pkg/term"
+	"os"
+)
+
+// lc3xCommand runs an LC-3 object on the experimental 32-bit LC-3X,
+// "lc3 lc3x [-memory words] image-file".
+func lc3xCommand(args []string) {
+	flags := flag.NewFlagSet("lc3x", flag.ExitOnError)
+	memory := flags.Uint("memory", lc3x.DefaultMemory, "the number of 32-bit `words` of memory")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 lc3x [flags] [image-file]\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *memory == 0 || *memory > 1<<32-1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	origin, words, err := readObjectWords(flags.Arg(0))
+	if err != nil {
+		fatalf("failed to load %s: %v", flags.Arg(0), err)
+	}
+
+	machine := lc3x.New(lc3x.NewRAM(uint32(*memory)),
+		lc3x.WithInput(term.NewReader(os.Stdin)),
+		lc3x.WithOutput(os.Stdout))
+
+	if err := machine.Load(uint32(origin), words); err != nil {
+		fatalf("failed to load %s: %v", flags.Arg(0), err)
+	}
+
+	machine.SetPC(uint32(origin))
+
+	if err := machine.Run(); err != nil {
+		fatalf("failed to run %s: %v", flags.Arg(0), err)
+	}
+}
+
+// readObjectWords reads the origin and the words of an LC-3 object.
+func readObjectWords(filename string) (uint16, []uint16, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return decodeObject(data)
+}
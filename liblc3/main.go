@@ -0,0 +1,370 @@
+// Command liblc3 is the VM as a C shared library, for embedding it in
+// C programs, Python through ctypes and any language with a C foreign
+// function interface. Built with
+//
+//	go build -buildmode=c-shared -o liblc3.so ./liblc3
+//
+// it comes with a liblc3.h header declaring:
+//
+//	uintptr_t lc3_new(void)                  creates a machine, returning its handle
+//	void lc3_free(uintptr_t m)               releases a machine
+//	int lc3_load(m, data, len)               loads an object file and points the PC at its origin
+//	int lc3_assemble(m, source)              assembles and loads source text likewise
+//	int lc3_step(m)                          runs an instruction
+//	int lc3_run(m, limit)                    runs until the program halts, waits for input or
+//	                                         has run limit instructions, if limit is not 0
+//	uint16_t lc3_read_mem(m, address)        reads memory without accessing devices
+//	void lc3_write_mem(m, address, value)    writes memory likewise
+//	uint16_t lc3_get_reg(m, r)               reads R0 to R7, the PC as 8 or the condition codes as 9
+//	void lc3_set_reg(m, r, value)            writes a register likewise
+//	int lc3_halted(m)                        reports whether the program halted
+//	void lc3_send_input(m, data, len)        types console input
+//	size_t lc3_read_output(m, buf, len)      takes up to len bytes of console output
+//	const char *lc3_error(m)                 describes the last error, or is NULL if none
+//
+// Loading returns 0, or -1 on failure. Steps and runs return LC3_OK
+// when the limit is reached, LC3_HALTED once the program halts,
+// LC3_WAITING when GETC or IN wait for input that has not been sent,
+// without running them, and LC3_ERROR on failure. The keyboard status
+// register reads no key until input is sent, so polling programs keep
+// running. A machine may be used from one thread at a time.
+package main
+
+/*
+#include <stdint.h>
+#include <stddef.h>
+#include <stdlib.h>
+
+enum {
+	LC3_ERROR = -1,
+	LC3_OK = 0,
+	LC3_HALTED = 1,
+	LC3_WAITING = 2,
+};
+*/
+import "C"
+
+import (
+	"bytes"
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"lc3/pkg/devices"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+	"lc3/pkg/traps"
+	"runtime/cgo"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// keyboard is the console input, fed by lc3_send_input.
+type keyboard struct {
+	// mu guards keys.
+	mu sync.Mutex
+
+	// keys are the keys sent and not yet read.
+	keys []byte
+}
+
+// Read reads a key, or a zero byte, which the keyboard status register
+// reads as no key, if none was sent. Keys are read one at a time so
+// that none are lost to readers that buffer.
+func (k *keyboard) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	p[0] = 0
+
+	if len(k.keys) > 0 {
+		p[0] = k.keys[0]
+		k.keys = k.keys[1:]
+	}
+
+	return 1, nil
+}
+
+// empty reports whether every key sent was read.
+func (k *keyboard) empty() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return len(k.keys) == 0
+}
+
+// console is the console output, taken by lc3_read_output.
+type console struct {
+	// mu guards buf.
+	mu sync.Mutex
+
+	// buf is the output not yet taken.
+	buf bytes.Buffer
+}
+
+// Write appends to the output.
+func (c *console) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.buf.Write(p)
+}
+
+// machine is a VM driven through the library.
+type machine struct {
+	// mu serializes the calls on the machine.
+	mu sync.Mutex
+
+	// cpu is the CPU of the loaded program.
+	cpu cpu.CPU
+
+	// keyboard is the console input.
+	keyboard *keyboard
+
+	// console is the console output.
+	console *console
+
+	// err is the description of the last error, allocated in C.
+	err *C.char
+}
+
+// newMachine creates a machine with nothing loaded.
+func newMachine() *machine {
+	m := &machine{keyboard: &keyboard{}, console: &console{}}
+	m.reset()
+
+	return m
+}
+
+// reset creates a fresh CPU with the console and devices attached.
+func (m *machine) reset() {
+	m.cpu = cpu.NewCPU(
+		cpu.WithInput(m.keyboard),
+		cpu.WithOutput(m.console),
+		cpu.WithDevice(devices.NewTerminal(m.console)),
+		cpu.WithDevice(devices.NewRNG(time.Now().UnixNano())),
+		cpu.WithDevice(devices.NewRTC(time.Now)),
+	)
+}
+
+// fail records an error for lc3_error.
+func (m *machine) fail(err error) {
+	C.free(unsafe.Pointer(m.err))
+	m.err = C.CString(err.Error())
+}
+
+// load loads an object, pointing the PC at its origin.
+func (m *machine) load(obj *asm.Object) {
+	var image [cpu.MemoryMax]uint16
+	copy(image[obj.Origin:], obj.Words)
+
+	m.reset()
+	m.cpu.Load(image)
+	m.cpu.SetRegister(registers.RPC, obj.Origin)
+}
+
+// waiting reports whether the next instruction reads input that has
+// not been sent.
+func (m *machine) waiting() bool {
+	instr := m.cpu.PeekMemory(m.cpu.Register(registers.RPC))
+	if instr>>12 != opcodes.OPTRAP {
+		return false
+	}
+
+	vector := instr & 0xFF
+
+	return (vector == traps.GETC || vector == traps.IN) && m.keyboard.empty()
+}
+
+// execute runs up to limit instructions, or until the program halts
+// or waits for input if limit is 0.
+func (m *machine) execute(limit uint64) C.int {
+	for n := uint64(0); limit == 0 || n < limit; n++ {
+		switch {
+		case m.cpu.Halted():
+			return C.LC3_HALTED
+		case m.waiting():
+			return C.LC3_WAITING
+		}
+
+		if err := m.cpu.Execute(); err != nil {
+			m.fail(err)
+			return C.LC3_ERROR
+		}
+	}
+
+	if m.cpu.Halted() {
+		return C.LC3_HALTED
+	}
+
+	return C.LC3_OK
+}
+
+// get returns the machine of a handle, locked.
+func get(h C.uintptr_t) *machine {
+	m := cgo.Handle(h).Value().(*machine)
+	m.mu.Lock()
+
+	return m
+}
+
+//export lc3_new
+func lc3_new() C.uintptr_t {
+	return C.uintptr_t(cgo.NewHandle(newMachine()))
+}
+
+//export lc3_free
+func lc3_free(h C.uintptr_t) {
+	m := get(h)
+	defer m.mu.Unlock()
+
+	C.free(unsafe.Pointer(m.err))
+	m.err = nil
+
+	cgo.Handle(h).Delete()
+}
+
+//export lc3_load
+func lc3_load(h C.uintptr_t, data *C.uint8_t, n C.size_t) C.int {
+	m := get(h)
+	defer m.mu.Unlock()
+
+	obj, err := asm.ReadObject(bytes.NewReader(C.GoBytes(unsafe.Pointer(data), C.int(n))))
+	if err != nil {
+		m.fail(err)
+		return -1
+	}
+
+	m.load(obj)
+
+	return 0
+}
+
+//export lc3_assemble
+func lc3_assemble(h C.uintptr_t, source *C.char) C.int {
+	m := get(h)
+	defer m.mu.Unlock()
+
+	obj, _, diagnostics, err := asm.Assemble(strings.NewReader(C.GoString(source)))
+	if err == nil && len(diagnostics) > 0 {
+		err = diagnostics
+	}
+
+	if err != nil {
+		m.fail(err)
+		return -1
+	}
+
+	m.load(obj)
+
+	return 0
+}
+
+//export lc3_step
+func lc3_step(h C.uintptr_t) C.int {
+	m := get(h)
+	defer m.mu.Unlock()
+
+	return m.execute(1)
+}
+
+//export lc3_run
+func lc3_run(h C.uintptr_t, limit C.uint64_t) C.int {
+	m := get(h)
+	defer m.mu.Unlock()
+
+	return m.execute(uint64(limit))
+}
+
+//export lc3_read_mem
+func lc3_read_mem(h C.uintptr_t, address C.uint16_t) C.uint16_t {
+	m := get(h)
+	defer m.mu.Unlock()
+
+	return C.uint16_t(m.cpu.PeekMemory(uint16(address)))
+}
+
+//export lc3_write_mem
+func lc3_write_mem(h C.uintptr_t, address, value C.uint16_t) {
+	m := get(h)
+	defer m.mu.Unlock()
+
+	m.cpu.PokeMemory(uint16(address), uint16(value))
+}
+
+//export lc3_get_reg
+func lc3_get_reg(h C.uintptr_t, r C.int) C.uint16_t {
+	m := get(h)
+	defer m.mu.Unlock()
+
+	if r < 0 || r > registers.RCOND {
+		return 0
+	}
+
+	return C.uint16_t(m.cpu.Register(uint16(r)))
+}
+
+//export lc3_set_reg
+func lc3_set_reg(h C.uintptr_t, r C.int, value C.uint16_t) {
+	m := get(h)
+	defer m.mu.Unlock()
+
+	if r >= 0 && r <= registers.RCOND {
+		m.cpu.SetRegister(uint16(r), uint16(value))
+	}
+}
+
+//export lc3_halted
+func lc3_halted(h C.uintptr_t) C.int {
+	m := get(h)
+	defer m.mu.Unlock()
+
+	if m.cpu.Halted() {
+		return 1
+	}
+
+	return 0
+}
+
+//export lc3_send_input
+func lc3_send_input(h C.uintptr_t, data *C.char, n C.size_t) {
+	m := get(h)
+	defer m.mu.Unlock()
+
+	keys := C.GoBytes(unsafe.Pointer(data), C.int(n))
+
+	m.keyboard.mu.Lock()
+	m.keyboard.keys = append(m.keyboard.keys, keys...)
+	m.keyboard.mu.Unlock()
+}
+
+//export lc3_read_output
+func lc3_read_output(h C.uintptr_t, buf *C.char, n C.size_t) C.size_t {
+	m := get(h)
+	defer m.mu.Unlock()
+
+	m.console.mu.Lock()
+	defer m.console.mu.Unlock()
+
+	out := m.console.buf.Next(int(n))
+	if len(out) > 0 {
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(buf)), len(out)), out)
+	}
+
+	return C.size_t(len(out))
+}
+
+//export lc3_error
+func lc3_error(h C.uintptr_t) *C.char {
+	m := get(h)
+	defer m.mu.Unlock()
+
+	return m.err
+}
+
+// main is required of c-shared builds, and never called.
+func main() {}
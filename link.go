@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"lc3/pkg/asm"
+	"lc3/pkg/symbols"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// linkCommand links objects assembled separately into one program,
+// resolving the symbols they declare .EXTERNAL against the .GLOBAL
+// labels of the others, "lc3 link -o program object...".
+func linkCommand(args []string) {
+	flags := flag.NewFlagSet("link", flag.ExitOnError)
+	output := flags.String("o", "", "write the linked program to `file`")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 link -o [program-file] [object-file] ...\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() < 1 || *output == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var objects []*asm.Object
+	table := symbols.New()
+
+	for _, filename := range flags.Args() {
+		obj, err := readObject(filename)
+		if err != nil {
+			fatalf("failed to read %s: %v", filename, err)
+		}
+
+		objects = append(objects, obj)
+
+		// labels of other objects with the same name are left out.
+		sym, err := findSymbols(filename, "")
+		if err != nil {
+			fatalf("failed to load symbols: %v", err)
+		}
+
+		for _, label := range sym.Labels() {
+			if _, ok := table.Address(label); !ok {
+				address, _ := sym.Address(label)
+				table.Add(label, address)
+			}
+		}
+	}
+
+	linked, err := asm.Link(objects...)
+	if err != nil {
+		fatalf("failed to link: %v", err)
+	}
+
+	writeFile(*output, func(w io.Writer) error {
+		_, err := linked.WriteTo(w)
+		return err
+	})
+
+	writeFile(strings.TrimSuffix(*output, filepath.Ext(*output))+".sym", table.Write)
+}
+
+// readObject reads an object along with the relocations next to it, if
+// any.
+func readObject(filename string) (*asm.Object, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	obj, err := asm.ReadObject(file)
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := os.Open(strings.TrimSuffix(filename, filepath.Ext(filename)) + ".rel")
+	if os.IsNotExist(err) {
+		return obj, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer rel.Close()
+
+	return obj, obj.ReadRelocations(rel)
+}
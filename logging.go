@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"lc3/pkg/term"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// setupLogging configures the default logger from the environment, for
+// every command alike: LC3_LOG sets the lowest level logged, debug,
+// info, warn or error, and LC3_LOG_FORMAT writes the messages as text
+// key=value pairs or as json objects instead of log lines.
+func setupLogging() error {
+	var level slog.Level
+
+	if name := os.Getenv("LC3_LOG"); name != "" {
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return fmt.Errorf("LC3_LOG=%s is not a level, expected one of debug, info, warn, error", name)
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+
+	switch format := strings.ToLower(os.Getenv("LC3_LOG_FORMAT")); format {
+	case "":
+		slog.SetLogLoggerLevel(level)
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, options)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, options)))
+	default:
+		return fmt.Errorf("LC3_LOG_FORMAT=%s is not a format, expected text or json", format)
+	}
+
+	return nil
+}
+
+// logger returns the default logger for messages of a component, such
+// as the loader or the cpu.
+func logger(component string) *slog.Logger {
+	return slog.Default().With("component", component)
+}
+
+// hexAddress renders an address for log messages.
+func hexAddress(a uint16) string {
+	return fmt.Sprintf("x%04X", a)
+}
+
+// fatalf logs an error and exits with status 1, restoring the terminal
+// first since exiting skips the deferred restoration of main.
+func fatalf(format string, args ...any) {
+	slog.Error(fmt.Sprintf(format, args...))
+	term.RestoreAll()
+	os.Exit(1)
+}
+
+// fatal is fatalf with the message, or error, given as is.
+func fatal(v any) {
+	fatalf("%v", v)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"lc3/pkg/asm"
+	"lc3/pkg/lsp"
+	"os"
+	"strings"
+)
+
+// lspCommand serves the Language Server Protocol on stdin and stdout,
+// "lc3 lsp [-I dir] [-dialect name]".
+func lspCommand(args []string) {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	var includePath stringList
+	flags.Var(&includePath, "I", "look for included files in `dir`, can be repeated")
+
+	dialect := flags.String("dialect", "lc3", "accept the syntax of another assembler, one of "+strings.Join(asm.DialectNames(), ", "))
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 lsp [flags]\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	d, ok := asm.LookupDialect(*dialect)
+	if !ok {
+		fatalf("unknown dialect %s, expected one of %s", *dialect, strings.Join(asm.DialectNames(), ", "))
+	}
+
+	conn := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+
+	if err := lsp.Serve(conn, asm.WithIncludePath(includePath...), asm.WithDialect(d)); err != nil {
+		fatalf("LSP server failed %v", err)
+	}
+}
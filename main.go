@@ -1,81 +1,373 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"lc3/pkg/callgraph"
+	"lc3/pkg/checkpoint"
+	"lc3/pkg/console"
+	"lc3/pkg/core"
 	"lc3/pkg/cpu"
-	"log"
+	"lc3/pkg/devices"
+	"lc3/pkg/disasm"
+	"lc3/pkg/heatmap"
+	"lc3/pkg/lint"
+	"lc3/pkg/plugin"
+	"lc3/pkg/pprof"
+	"lc3/pkg/profile"
+	"lc3/pkg/script"
+	"lc3/pkg/stacks"
+	"lc3/pkg/stuck"
+	"lc3/pkg/term"
+	"lc3/pkg/timeline"
+	"lc3/pkg/trace"
 	"math"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func readImage(filename string) ([math.MaxUint16 + 1]uint16, error) {
-	m := [math.MaxUint16 + 1]uint16{}
+var (
+	// keyScript is the keystroke script replayed instead of reading
+	// the console.
+	keyScript = flag.String("keys", "", "replay keystrokes from a script `file` instead of the console")
 
-	file, err := os.Open(filename)
+	// utf8Output enables UTF-8 console output.
+	utf8Output = flag.Bool("utf8", false, "write characters above x7F as UTF-8 encoded code points")
+
+	// isaName is the instruction set run.
+	isaName = flag.String("isa", "lc3", "run the instruction set `name`, lc3, lc3b for byte-addressed LC-3b programs, or lc2 for LC-2 programs")
+
+	// mulDiv runs the multiply and divide extension.
+	mulDiv = flag.Bool("muldiv", false, "run the MUL, DIV and MOD instructions of the multiply and divide extension on the reserved opcode")
+
+	// testAndSet runs the test-and-set extension.
+	testAndSet = flag.Bool("tas", false, "run the TAS instruction of the test-and-set extension on the reserved opcode")
+
+	// wideRegisters runs the mode of 16 registers.
+	wideRegisters = flag.Bool("wide", false, "run the experimental mode of 16 registers, R8 to R15 being reached through a prefix on the reserved opcode")
+
+	// harvard splits code and data memory.
+	harvard = flag.Bool("harvard", false, "fetch instructions from a code space of their own, loads and stores touching a separate data space")
+
+	// strictEncoding checks the fixed bits of instructions.
+	strictEncoding = flag.Bool("strict", false, "stop on instructions whose bits the specification fixes are not as specified, such as bits 5 to 0 of NOT")
+
+	// fastMode runs the loop stripped of instrumentation.
+	fastMode = flag.Bool("fast", false, "run in a loop stripped of the checks for hooks, devices and the MMU, unless a flag needs them")
+
+	// blockJIT runs a basic block at a time.
+	blockJIT = flag.Bool("jit", false, "run a basic block at a time, translated into Go closures, rather than an instruction at a time")
+
+	// cores is the number of cores sharing memory.
+	cores = flag.Int("cores", 1, "run the program on `n` cores sharing memory, an instruction each in turn, each starting with its number in R0")
+
+	// separateVMs runs every image on a VM of its own.
+	separateVMs = flag.Bool("vms", false, "run every image at once on a VM of its own, vm1 for the first, sharing the console: output is tagged with the name of the VM and input goes to the VM selected by a line such as @vm2")
+
+	// mailboxDepth is the number of words buffered by the mailboxes
+	// between VMs.
+	mailboxDepth = flag.Int("mailbox", 16, "buffer up to `n` words each way in the mailboxes at xFE18 and xFE1A connecting the VMs of -vms in pairs, vm1 with vm2, vm3 with vm4 and so on")
+
+	// fpu adds the floating-point coprocessor.
+	fpu = flag.Bool("fpu", false, "add a floating-point coprocessor of half and single precision at xFE34 to xFE42")
+
+	// mmu adds the MMU.
+	mmu = flag.Bool("mmu", false, "add an MMU translating addresses through a page table, with page faults and RTI")
+
+	// memorySize is the number of words of memory installed.
+	memorySize = flag.Int("memory", cpu.MemoryMax, "install `words` of memory only, such as 4096 for a 4K machine, the device registers staying reachable")
+
+	// startAddress is the address programs start at.
+	startAddress = flag.String("pc", "", "start the program at `address`, such as x0200, by default x3000, or the origin of the first image if -memory leaves x3000 out")
+
+	// rawMode puts the console into raw mode while running.
+	rawMode = flag.Bool("raw", false, "deliver keystrokes immediately without echo, for games")
+
+	// symbolFile is the symbol table of the first image.
+	symbolFile = flag.String("sym", "", "load labels from a symbol table `file`, by default the image name with a .sym extension")
+
+	// debugInfoFile maps the first image to its assembly source.
+	debugInfoFile = flag.String("debuginfo", "", "load source lines from a debug info `file`, by default the image name with a .debug extension")
+
+	// joystickSource selects what feeds the joystick device.
+	joystickSource = flag.String("joystick", "", "feed the joystick from `source`, either \"keys\" or a gamepad device such as /dev/input/js0")
+
+	// deterministic replaces every source of nondeterminism with a
+	// seeded or virtual one.
+	deterministic = flag.Bool("deterministic", false, "run with a virtual clock advancing a microsecond per instruction and a seeded random number generator, so that runs with the same input are identical")
+
+	// rngSeed seeds the random number generator device.
+	rngSeed = flag.Int64("seed", 0, "seed the random number generator with `n`, by default from the clock unless -deterministic")
+
+	// coreFile records the state of a program that fails.
+	coreFile = flag.String("core", "", "write the registers, memory and call stack of a program that fails to a core `file`, which lc3 core inspect reads")
+
+	// checkpointEvery is the number of instructions between
+	// checkpoints.
+	checkpointEvery = flag.Uint64("checkpoint", 0, "take a checkpoint of the program every `n` instructions, written to -checkpointdir, or to rewind to in the debugger")
+
+	// checkpointKeep is the number of checkpoints kept.
+	checkpointKeep = flag.Int("keep", 10, "keep the last `n` checkpoints")
+
+	// checkpointDir is where checkpoints are written.
+	checkpointDir = flag.String("checkpointdir", "", "write checkpoints to `dir`, which lc3 resume continues from, by default the image name with a .checkpoints extension")
+
+	// scriptFile instruments programs with a Starlark script.
+	scriptFile = flag.String("script", "", "run the Starlark script in `file`, which may hook instructions, memory accesses, traps and the end of the run")
+
+	// stuckDetection stops programs stuck in a loop.
+	stuckDetection = flag.Bool("stuck", false, "stop a program stuck in a loop that changes neither registers nor memory, reporting the loop")
+
+	// lintWarnings warns about suspicious program behavior.
+	lintWarnings = flag.Bool("lint", false, "warn about suspicious behavior: reading KBDR without checking KBSR, storing into code that ran, the stack pointer R6 moving into code and returning through a clobbered R7")
+
+	// lintErrors promotes the warnings of -lint to errors.
+	lintErrors = flag.Bool("linterrors", false, "stop the program on the first -lint warning")
+
+	// commandScript is a debugger script run at startup.
+	commandScript = flag.String("x", "", "run the debugger commands in `file` at startup")
+
+	// transcriptFile records the debugging session.
+	transcriptFile = flag.String("transcript", "", "record the debugging session to a transcript `file`, which -x can replay")
+
+	// historyFile keeps the debugger command history.
+	historyFile = flag.String("history", "", "keep the history of debugger commands in `file`, by default .lc3_history in the home directory")
+
+	// listenAddress serves the debugger over WebSocket.
+	listenAddress = flag.String("listen", "", "serve the debugger to WebSocket clients on `address` instead of the console")
+
+	// profileFile records how many times each instruction runs.
+	profileFile = flag.String("profile", "", "write how many times each instruction ran to a profile `file`, which lc3 dasm -profile annotates")
+
+	// topCount reports the hottest addresses.
+	topCount = flag.Int("top", 0, "report the `n` addresses whose instructions ran most often when the program ends")
+
+	// loopReport reports the loops that ran.
+	loopReport = flag.Bool("loops", false, "report the loops that ran with their trip counts and share of the instructions when the program ends")
+
+	// branchReport reports how the branches behaved.
+	branchReport = flag.Bool("branches", false, "report how often each branch was taken and the condition codes it saw when the program ends")
+
+	// cellList names the memory cells logged.
+	cellList = flag.String("cells", "", "log the memory cells at the addresses or labels in `list` after every instruction, as x4000,COUNT")
+
+	// cellFile records the values of the logged cells.
+	cellFile = flag.String("cellcsv", "", "write the values of the cells given with -cells to a CSV `file`, one row per instruction")
+
+	// callGraphFile records the calls between subroutines.
+	callGraphFile = flag.String("callgraph", "", "write the calls and instruction costs of every subroutine to `file`, in Graphviz DOT if it ends in .dot")
+
+	// heatMapFile records the memory accesses.
+	heatMapFile = flag.String("heatmap", "", "write how often each address was read and written to `file`, as a PNG image or CSV if it ends in .png or .csv, or else as text blocks")
+
+	// coverageFile lists which instructions ran.
+	coverageFile = flag.String("coverage", "", "write the disassembly of the first image annotated with which instructions ran to `file`")
+
+	// minCoverage fails runs that leave too many instructions unrun.
+	minCoverage = flag.Float64("mincoverage", 0, "fail unless at least `percent` of the instructions of the first image ran")
+
+	// pprofFile records the instructions run by call stack for pprof.
+	pprofFile = flag.String("pprof", "", "write the instructions run by call stack to a pprof profile `file`, for go tool pprof")
+
+	// timelineFile records the calls as Chrome trace events.
+	timelineFile = flag.String("timeline", "", "write the subroutine calls as Chrome trace events to `file`, for chrome://tracing or Perfetto")
+
+	// foldedFile records the instructions run by call stack for flame
+	// graphs.
+	foldedFile = flag.String("folded", "", "write the instructions run by chain of subroutines as folded stacks to `file`, for flamegraph.pl or speedscope")
+
+	// traceFile records every instruction run.
+	traceFile = flag.String("trace", "", "write every instruction run with the registers it leaves to a trace `file`")
+
+	// traceFormat is the encoding of the trace.
+	traceFormat = flag.String("traceformat", "text", "encode the trace as `format`, text, jsonl, binary or proto")
+
+	// traceFilter selects the instructions traced.
+	traceFilter = flag.String("tracefilter", "", "trace only the instructions at the addresses, ranges or with the mnemonics in `list`, as x3000-x31FF,TRAP")
+
+	// pluginCommands are the commands of the device plugins.
+	pluginCommands = listFlag("plugin", "attach the device served by the plugin `command`, a program and its arguments, which may be given several times")
+)
+
+// listFlag defines a flag that may be given several times, collecting
+// its values.
+func listFlag(name, usage string) *[]string {
+	var values []string
+
+	flag.Func(name, usage, func(value string) error {
+		values = append(values, value)
+		return nil
+	})
 
+	return &values
+}
+
+// setup holds the resources shared by every CPU that is run.
+type setup struct {
+	// events is the keystroke script, if any.
+	events []devices.KeyEvent
+
+	// joystick is the joystick device, if any.
+	joystick *devices.Joystick
+
+	// input is the console input stream.
+	input io.Reader
+
+	// plugins are the devices served by plugins.
+	plugins []*plugin.Device
+
+	// script is the instrumentation script, if any.
+	script *script.Script
+}
+
+// readImage reads an LC-3 object into memory at its origin.
+func readImage(filename string) ([cpu.MemoryMax]uint16, error) {
+	data, err := os.ReadFile(filename)
 	if err != nil {
-		return m, err
+		return [cpu.MemoryMax]uint16{}, err
 	}
 
-	defer file.Close()
-
-	stats, err := file.Stat()
+	m, err := decodeImage(data)
 	if err != nil {
-		return m, err
+		return m, fmt.Errorf("%s: %w", filename, err)
 	}
 
-	// load the origin
-	var origin uint16
+	return m, nil
+}
+
+// decodeImage decodes an LC-3 object into memory at its origin,
+// checking that it fits.
+func decodeImage(data []byte) ([cpu.MemoryMax]uint16, error) {
+	m := [cpu.MemoryMax]uint16{}
 
-	headerBytes := make([]byte, 2)
-	_, err = file.Read(headerBytes)
+	origin, words, err := decodeObject(data)
 	if err != nil {
 		return m, err
 	}
 
-	headerBuffer := bytes.NewBuffer(headerBytes)
-	err = binary.Read(headerBuffer, binary.BigEndian, &origin)
-	if err != nil {
-		return m, err
+	if int(origin)+len(words) > cpu.MemoryMax {
+		return m, fmt.Errorf("%d words from x%04X run past the end of memory", len(words), origin)
 	}
 
-	log.Printf("Origin memory location: 0x%04X", origin)
-	size := stats.Size()
-	byteArr := make([]byte, size)
+	logger("loader").Info("loaded image", "origin", hexAddress(origin))
 
-	log.Printf("Creating memory buffer: %d bytes", size)
+	copy(m[origin:], words)
+
+	return m, nil
+}
 
-	_, err = file.Read(byteArr)
+// decodeObject decodes the origin and the words of an LC-3 object, big
+// endian words following the origin.
+func decodeObject(data []byte) (uint16, []uint16, error) {
+	if len(data) < 2 {
+		return 0, nil, fmt.Errorf("object of %d bytes holds no origin", len(data))
+	}
+
+	if len(data)%2 != 0 {
+		return 0, nil, fmt.Errorf("object of %d bytes ends in half a word", len(data))
+	}
+
+	words := make([]uint16, len(data)/2-1)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint16(data[2+2*i:])
+	}
+
+	return binary.BigEndian.Uint16(data), words, nil
+}
+
+// selectedISA returns the instruction set given with -isa.
+func selectedISA() cpu.ISA {
+	isa, ok := cpu.LookupISA(*isaName)
+	if !ok {
+		fatalf("unknown instruction set %s, expected one of %s", *isaName, strings.Join(cpu.ISANames(), ", "))
+	}
+
+	return isa
+}
+
+// readLC3bImage reads an LC-3b program into memory, with its words at
+// byte addresses from its origin. Programs are read as objects, or as
+// the hex text of the LC-3b assembler: the origin and then a word per
+// line, such as 0x3000.
+func readLC3bImage(filename string) ([cpu.MemoryMax]uint16, error) {
+	m := [cpu.MemoryMax]uint16{}
+
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return m, err
 	}
 
-	buffer := bytes.NewBuffer(byteArr)
+	var words []uint16
 
-	for i := origin; i < math.MaxUint16; i++ {
-		var val uint16
-		binary.Read(buffer, binary.BigEndian, &val)
-		m[i] = val
+	if text := strings.TrimSpace(string(data)); strings.HasPrefix(strings.ToLower(text), "0x") {
+		for i, line := range strings.Fields(text) {
+			word, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(line), "0x"), 16, 16)
+			if err != nil {
+				return m, fmt.Errorf("word %d: invalid hex %q", i+1, line)
+			}
+
+			words = append(words, uint16(word))
+		}
+	} else {
+		for i := 0; i+1 < len(data); i += 2 {
+			words = append(words, binary.BigEndian.Uint16(data[i:]))
+		}
 	}
 
-	return m, err
-}
+	if len(words) == 0 {
+		return m, fmt.Errorf("%s holds no origin", filename)
+	}
+
+	origin := words[0]
+	if origin&1 != 0 {
+		return m, fmt.Errorf("origin x%04X is not even", origin)
+	}
+
+	logger("loader").Info("loaded image", "origin", hexAddress(origin))
+
+	for i, word := range words[1:] {
+		address := int(origin) + 2*i
+		if address > math.MaxUint16 {
+			break
+		}
+
+		m[address] = word
+	}
 
-func loadArguments() [][math.MaxUint16 + 1]uint16 {
-	args := os.Args[1:]
+	return m, nil
+}
 
+func loadArguments(args []string) [][cpu.MemoryMax]uint16 {
 	if len(args) < 1 {
-		log.Fatal("lc3 [image-file1] ...\n")
+		fatal("lc3 [flags] [image-file1] ...")
 	}
 
-	var images [][math.MaxUint16 + 1]uint16
+	var images [][cpu.MemoryMax]uint16
 
-	for _, arg := range args {
-		image, err := readImage(arg)
+	load := readImage
+	if selectedISA() == cpu.LC3b {
+		load = readLC3bImage
+	}
+
+	for i, arg := range args {
+		image, err := load(arg)
+		if err == nil && selectedISA() != cpu.LC3b {
+			var origin uint16
+
+			origin, err = checkInstalled(arg)
+			if i == 0 {
+				firstOrigin = &origin
+			}
+		}
 
 		if err != nil {
-			log.Fatalf("failed to load image: %s, %v", arg, err)
+			fatalf("failed to load image: %s, %v", arg, err)
 		}
 
 		images = append(images, image)
@@ -84,16 +376,810 @@ func loadArguments() [][math.MaxUint16 + 1]uint16 {
 	return images
 }
 
-func main() {
-	args := loadArguments()
+// firstOrigin is the origin of the first LC-3 image loaded, if any.
+var firstOrigin *uint16
 
-	for _, args := range args {
-		cpu := cpu.NewCPU()
+// checkInstalled checks that an LC-3 object fits in the memory
+// installed with -memory, and returns its origin.
+func checkInstalled(filename string) (uint16, error) {
+	origin, words, err := readObjectWords(filename)
+	if err != nil {
+		return 0, err
+	}
 
-		err := cpu.Run(args)
+	if int(origin)+len(words) > *memorySize {
+		return 0, fmt.Errorf("%d words from x%04X run past the end of the %d words of memory", len(words), origin, *memorySize)
+	}
 
+	return origin, nil
+}
+
+// startPC returns the address given with -pc, or the origin of the
+// first image if -memory leaves x3000 out, and false if programs start
+// at x3000 as usual.
+func startPC() (uint16, bool) {
+	if *startAddress != "" {
+		pc, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(*startAddress), "x"), 16, 16)
 		if err != nil {
-			log.Fatalf("Execution failed %v", err)
+			fatalf("invalid -pc %s, expected a hex address such as x0200", *startAddress)
+		}
+
+		return uint16(pc), true
+	}
+
+	if *memorySize > 0x3000 || firstOrigin == nil {
+		return 0, false
+	}
+
+	return *firstOrigin, true
+}
+
+// loadSetup prepares the resources shared by every CPU.
+func loadSetup() *setup {
+	s := &setup{
+		events: loadKeyScript(),
+		input:  term.NewReader(os.Stdin),
+	}
+
+	if *deterministic && *joystickSource != "" {
+		fatal("the joystick cannot be used with -deterministic, script the keyboard with -keys instead")
+	}
+
+	switch *joystickSource {
+	case "":
+	case "keys":
+		if s.events != nil {
+			fatal("the joystick cannot be fed from keys while replaying a key script")
+		}
+
+		s.joystick = devices.NewJoystick()
+
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(s.joystick.ReadKeys(term.NewReader(os.Stdin), writer))
+		}()
+
+		s.input = reader
+	default:
+		file, err := os.Open(*joystickSource)
+		if err != nil {
+			fatalf("failed to open gamepad: %v", err)
+		}
+
+		s.joystick = devices.NewJoystick()
+
+		go s.joystick.ReadGamepad(file)
+	}
+
+	for _, command := range *pluginCommands {
+		device, err := plugin.Start(command)
+		if err != nil {
+			fatalf("failed to start plugin: %v", err)
+		}
+
+		s.plugins = append(s.plugins, device)
+	}
+
+	if *scriptFile != "" {
+		sc, err := script.Load(*scriptFile, os.Stdout)
+		if err != nil {
+			fatalf("failed to load script: %v", err)
+		}
+
+		s.script = sc
+	}
+
+	return s
+}
+
+// loadKeyScript loads the keystroke script, if one was given.
+func loadKeyScript() []devices.KeyEvent {
+	if *keyScript == "" {
+		return nil
+	}
+
+	events, err := readKeyScript(*keyScript)
+	if err != nil {
+		fatalf("failed to load key script: %v", err)
+	}
+
+	return events
+}
+
+// readKeyScript reads a keystroke script.
+func readKeyScript(filename string) ([]devices.KeyEvent, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return devices.ParseKeyScript(file)
+}
+
+// cpuOptions returns the options every CPU is created with.
+func cpuOptions(s *setup) []cpu.Option {
+	now := time.Now
+
+	seed := *rngSeed
+	if seed == 0 && !*deterministic {
+		seed = time.Now().UnixNano()
+	}
+
+	opts := []cpu.Option{
+		cpu.WithISA(selectedISA()),
+		cpu.WithDevice(devices.NewRNG(seed)),
+		cpu.WithDevice(devices.NewTerminal(os.Stdout)),
+		cpu.WithInput(s.input),
+	}
+
+	if *mulDiv {
+		if selectedISA() != cpu.LC3 {
+			fatal("the multiply and divide extension takes the reserved opcode of the LC-3, it cannot be used with -isa")
+		}
+
+		opts = append(opts, cpu.WithMulDiv())
+	}
+
+	if *testAndSet {
+		if selectedISA() != cpu.LC3 {
+			fatal("the test-and-set extension takes the reserved opcode of the LC-3, it cannot be used with -isa")
+		}
+
+		opts = append(opts, cpu.WithTestAndSet())
+	}
+
+	if *wideRegisters {
+		if selectedISA() != cpu.LC3 {
+			fatal("the mode of 16 registers takes the reserved opcode of the LC-3, it cannot be used with -isa")
 		}
+
+		opts = append(opts, cpu.WithWideRegisters())
+	}
+
+	if *blockJIT {
+		opts = append(opts, cpu.WithBlockJIT(), cpu.WithFusion())
+	}
+
+	if *fastMode {
+		opts = append(opts, cpu.WithFastMode())
+	}
+
+	if *strictEncoding {
+		if selectedISA() != cpu.LC3 {
+			fatal("-strict checks the encodings of the LC-3, it cannot be used with -isa")
+		}
+
+		opts = append(opts, cpu.WithStrictEncoding())
+	}
+
+	if *harvard {
+		opts = append(opts, cpu.WithHarvard())
+	}
+
+	if *mmu {
+		if selectedISA() != cpu.LC3 {
+			fatal("the MMU is an extension of the LC-3, it cannot be used with -isa")
+		}
+
+		opts = append(opts, cpu.WithMMU())
+	}
+
+	if *memorySize < 1 || *memorySize > cpu.MemoryMax {
+		fatalf("-memory %d is not between 1 and %d words, see lc3 lc3x for larger machines", *memorySize, cpu.MemoryMax)
+	}
+
+	opts = append(opts, cpu.WithMemorySize(*memorySize))
+
+	if pc, ok := startPC(); ok {
+		opts = append(opts, cpu.WithPC(pc))
+	}
+
+	if *deterministic {
+		clock := devices.NewClock(devices.ClockEpoch, time.Microsecond)
+		now = clock.Now
+
+		opts = append(opts, cpu.WithDevice(clock))
+	}
+
+	opts = append(opts, cpu.WithDevice(devices.NewRTC(now)))
+
+	if *fpu {
+		opts = append(opts, cpu.WithDevice(devices.NewFPU()))
+	}
+
+	if s.joystick != nil {
+		opts = append(opts, cpu.WithDevice(s.joystick))
+	}
+
+	for _, device := range s.plugins {
+		opts = append(opts, cpu.WithDevice(device))
+	}
+
+	if *utf8Output {
+		opts = append(opts, cpu.WithUTF8Output())
+	}
+
+	if s.events != nil {
+		keyboard := devices.NewScriptedKeyboard(s.events, now)
+
+		opts = append(opts,
+			cpu.WithDevice(keyboard),
+			cpu.WithInput(keyboard.Input()),
+		)
+	}
+
+	if s.script != nil {
+		opts = append(opts, s.script.Options()...)
+	}
+
+	return opts
+}
+
+// enableRawMode puts the console into raw mode if requested and
+// returns a function restoring it.
+func enableRawMode() func() {
+	fd := int(os.Stdin.Fd())
+
+	if !*rawMode || !term.IsTerminal(fd) {
+		return func() {}
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		fatalf("failed to enable raw mode: %v", err)
+	}
+
+	return func() {
+		term.Restore(fd, state)
+	}
+}
+
+// run runs every image in turn, profiling them into one profile,
+// following their calls, mapping their memory accesses and tracing them
+// into one trace, timeline and cell log if requested, then reports the hottest addresses,
+// loops, branches and coverage.
+func run(images [][cpu.MemoryMax]uint16, s *setup) (err error) {
+	var prof *profile.Profile
+	if *profileFile != "" || *topCount > 0 || *loopReport || *coverageFile != "" || *minCoverage > 0 {
+		prof = profile.New()
+	}
+
+	if *profileFile != "" {
+		defer writeFile(*profileFile, prof.Write)
+	}
+
+	var branches *profile.Branches
+	if *branchReport {
+		branches = profile.NewBranches()
+	}
+
+	var calls *callgraph.Graph
+	if *callGraphFile != "" {
+		calls = callgraph.New()
+		defer writeCallGraph(calls)
+	}
+
+	var heat *heatmap.Map
+	if *heatMapFile != "" {
+		heat = heatmap.New()
+		defer writeHeatMap(heat)
+	}
+
+	var samples *stacks.Samples
+	if *pprofFile != "" || *foldedFile != "" {
+		samples = stacks.New()
+		defer writeStacks(samples)
+	}
+
+	var events *timeline.Writer
+	if *timelineFile != "" {
+		file, err := os.Create(*timelineFile)
+		if err != nil {
+			return err
+		}
+
+		events = timeline.NewWriter(file, loadSymbols(flag.Arg(0)).Format)
+
+		defer func() {
+			if closeErr := events.Close(); err == nil {
+				err = closeErr
+			}
+
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	var cells *trace.CellWriter
+	if *cellFile != "" {
+		addresses, names, err := trace.ParseCells(*cellList, loadSymbols(flag.Arg(0)))
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Create(*cellFile)
+		if err != nil {
+			return err
+		}
+
+		cells = trace.NewCellWriter(file, addresses, names)
+
+		defer func() {
+			if flushErr := cells.Flush(); err == nil {
+				err = flushErr
+			}
+
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	var tracer *trace.Writer
+	if *traceFile != "" {
+		format, ok := trace.LookupFormat(*traceFormat)
+		if !ok {
+			return fmt.Errorf("unknown trace format %s, expected one of %s", *traceFormat, strings.Join(trace.FormatNames(), ", "))
+		}
+
+		filter, err := trace.ParseFilter(*traceFilter)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Create(*traceFile)
+		if err != nil {
+			return err
+		}
+
+		tracer = trace.NewWriter(file, trace.WithFormat(format), trace.WithFilter(filter))
+
+		defer func() {
+			if flushErr := tracer.Flush(); err == nil {
+				err = flushErr
+			}
+
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	for i, image := range images {
+		cpu := cpu.NewCPU(cpuOptions(s)...)
+
+		if prof != nil {
+			prof.Record(cpu)
+		}
+
+		if branches != nil {
+			branches.Record(cpu)
+		}
+
+		if calls != nil {
+			calls.Record(cpu)
+		}
+
+		if heat != nil {
+			heat.Record(cpu)
+		}
+
+		if samples != nil {
+			samples.Record(cpu)
+		}
+
+		if events != nil {
+			events.Record(cpu)
+		}
+
+		if cells != nil {
+			cells.Record(cpu)
+		}
+
+		if tracer != nil {
+			tracer.Record(cpu)
+		}
+
+		var checkpoints *checkpoint.Checkpointer
+		if *checkpointEvery > 0 {
+			if checkpoints, err = recordCheckpoints(cpu, flag.Arg(i), len(images) > 1); err != nil {
+				return err
+			}
+		}
+
+		var recorder *core.Recorder
+		if *coreFile != "" {
+			recorder = core.NewRecorder()
+			recorder.Record(cpu)
+		}
+
+		var checks []func() error
+
+		if *stuckDetection {
+			checks = append(checks, detectStuck(cpu))
+		}
+
+		if *lintWarnings || *lintErrors {
+			checks = append(checks, lintProgram(cpu))
+		}
+
+		if s.script != nil {
+			s.script.Attach(cpu)
+			checks = append(checks, s.script.Err)
+		}
+
+		execute := func() error { return cpu.Run(image) }
+		if checks != nil {
+			execute = func() error { return runChecked(cpu, image, checks) }
+		}
+
+		if err := execute(); err != nil {
+			if recorder != nil {
+				writeFile(*coreFile, recorder.Dump(cpu, err).Write)
+				logger("cpu").Info("wrote core", "file", *coreFile)
+			}
+
+			return err
+		}
+
+		if checkpoints != nil && checkpoints.Err() != nil {
+			return fmt.Errorf("failed to write checkpoints: %w", checkpoints.Err())
+		}
+	}
+
+	if s.script != nil {
+		if err := s.script.Exit(); err != nil {
+			return err
+		}
+	}
+
+	if *topCount > 0 {
+		if err := prof.WriteTop(os.Stderr, *topCount, prof.Word); err != nil {
+			return err
+		}
+	}
+
+	if *loopReport {
+		if err := prof.WriteLoops(os.Stderr, prof.Word); err != nil {
+			return err
+		}
+	}
+
+	if branches != nil {
+		if err := branches.Write(os.Stderr); err != nil {
+			return err
+		}
+	}
+
+	if *coverageFile != "" || *minCoverage > 0 {
+		return reportCoverage(prof)
+	}
+
+	return nil
+}
+
+// recordCheckpoints writes checkpoints of a CPU running an image to
+// -checkpointdir, or a directory named after the image. Several images
+// each get a directory of their own.
+func recordCheckpoints(c cpu.CPU, image string, several bool) (*checkpoint.Checkpointer, error) {
+	dir := *checkpointDir
+
+	switch {
+	case dir == "":
+		dir = strings.TrimSuffix(image, filepath.Ext(image)) + ".checkpoints"
+	case several:
+		dir = filepath.Join(dir, strings.TrimSuffix(filepath.Base(image), filepath.Ext(image)))
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	checkpoints := checkpoint.New(*checkpointEvery, *checkpointKeep, checkpoint.WithDirectory(dir))
+	checkpoints.Record(c)
+
+	return checkpoints, nil
+}
+
+// runCores runs every image in turn on cores sharing memory, each with
+// the devices of a CPU of its own on the console.
+func runCores(images [][cpu.MemoryMax]uint16, s *setup) error {
+	for _, image := range images {
+		first := cpu.NewCPU(cpuOptions(s)...)
+		first.Load(image)
+
+		machine := []cpu.CPU{first}
+		for len(machine) < *cores {
+			machine = append(machine, cpu.NewCPU(append(cpuOptions(s), cpu.WithSharedMemory(first))...))
+		}
+
+		if err := cpu.RunCores(machine...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runVMs runs every image at once on a VM of its own, the VMs sharing
+// the console through a multiplexer and connected in pairs by
+// mailboxes.
+func runVMs(images [][cpu.MemoryMax]uint16, s *setup) error {
+	mux := console.NewMux(s.input, os.Stdout)
+
+	mailboxes := make([]*devices.Mailbox, len(images))
+	for i := 0; i+1 < len(images); i += 2 {
+		mailboxes[i], mailboxes[i+1] = devices.NewMailbox(*mailboxDepth)
+	}
+
+	errs := make(chan error, len(images))
+
+	for i, image := range images {
+		port := mux.Attach(fmt.Sprintf("vm%d", i+1))
+
+		// the terminal writes its escape sequences among the
+		// output of its own VM.
+		opts := append(cpuOptions(s),
+			cpu.WithInput(port),
+			cpu.WithOutput(port),
+			cpu.WithDevice(devices.NewTerminal(port)),
+		)
+
+		if mailboxes[i] != nil {
+			opts = append(opts, cpu.WithDevice(mailboxes[i]))
+		}
+
+		c := cpu.NewCPU(opts...)
+
+		go func() {
+			err := c.Run(image)
+			if flushErr := port.Flush(); err == nil {
+				err = flushErr
+			}
+
+			if err != nil {
+				err = fmt.Errorf("%s: %w", port.Name(), err)
+			}
+
+			errs <- err
+		}()
+	}
+
+	var failed []error
+	for range images {
+		if err := <-errs; err != nil {
+			failed = append(failed, err)
+		}
+	}
+
+	return errors.Join(failed...)
+}
+
+// runChecked runs an image like Run, an instruction at a time, stopping
+// with the first error of a check after any instruction.
+func runChecked(c cpu.CPU, image [cpu.MemoryMax]uint16, checks []func() error) error {
+	c.Load(image)
+
+	for !c.Halted() {
+		if err := c.Execute(); err != nil {
+			return err
+		}
+
+		for _, check := range checks {
+			if err := check(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// detectStuck returns a check failing with an error that lists the
+// loop if the program gets stuck in one.
+func detectStuck(c cpu.CPU) func() error {
+	detector := stuck.New()
+	detector.Record(c)
+
+	return func() error {
+		if loop := detector.Loop(); loop != nil {
+			return fmt.Errorf("%w:\n%s", loop, strings.Join(loop.Lines(loadSymbols(flag.Arg(0))), "\n"))
+		}
+
+		return nil
+	}
+}
+
+// lintProgram returns a check logging the warnings about the program,
+// or failing with the first one if -linterrors promotes them.
+func lintProgram(c cpu.CPU) func() error {
+	var failed *lint.Warning
+
+	linter := lint.New(func(w *lint.Warning) {
+		if *lintErrors {
+			if failed == nil {
+				failed = w
+			}
+
+			return
+		}
+
+		logger("lint").Warn(w.Message, "pc", hexAddress(w.PC), "rule", w.Rule.String())
+	})
+
+	linter.Record(c)
+
+	return func() error {
+		if failed != nil {
+			return failed
+		}
+
+		return nil
+	}
+}
+
+// reportCoverage reports how many instructions of the first image ran,
+// writing the annotated disassembly if requested, and fails if fewer
+// than the minimum ran.
+func reportCoverage(prof *profile.Profile) error {
+	obj, err := readObject(flag.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	program := disasm.Disassemble(obj.Origin, obj.Words, disasm.WithSymbols(loadSymbols(flag.Arg(0))))
+
+	covered, total := program.Coverage(prof.Count)
+	share := 0.0
+	if total > 0 {
+		share = 100 * float64(covered) / float64(total)
+	}
+
+	logger("profile").Info("coverage", "covered", covered, "total", total, "percent", math.Round(share*10)/10)
+
+	if *coverageFile != "" {
+		writeFile(*coverageFile, func(w io.Writer) error {
+			return program.WriteCoverage(w, prof.Count)
+		})
+	}
+
+	if share < *minCoverage {
+		return fmt.Errorf("coverage of %.1f%% is below the minimum of %.1f%%", share, *minCoverage)
+	}
+
+	return nil
+}
+
+// writeCallGraph writes the call graph of the run, naming subroutines
+// after the labels of the first image.
+func writeCallGraph(calls *callgraph.Graph) {
+	table := loadSymbols(flag.Arg(0))
+
+	write := func(w io.Writer) error {
+		return calls.WriteText(w, table.Format)
+	}
+
+	if strings.EqualFold(filepath.Ext(*callGraphFile), ".dot") {
+		write = func(w io.Writer) error {
+			return calls.WriteDOT(w, table.Format)
+		}
+	}
+
+	writeFile(*callGraphFile, write)
+}
+
+// writeHeatMap writes the memory heat map of the run in the format its
+// file name asks for.
+func writeHeatMap(heat *heatmap.Map) {
+	write := heat.WriteBlocks
+
+	switch strings.ToLower(filepath.Ext(*heatMapFile)) {
+	case ".png":
+		write = heat.WritePNG
+	case ".csv":
+		write = heat.WriteCSV
+	}
+
+	writeFile(*heatMapFile, write)
+}
+
+// writeStacks writes the samples of the run as a pprof profile and
+// folded stacks as requested, naming subroutines after the labels of
+// the first image and mapping them to its source lines.
+func writeStacks(samples *stacks.Samples) {
+	table := loadSymbols(flag.Arg(0))
+
+	if *foldedFile != "" {
+		writeFile(*foldedFile, func(w io.Writer) error {
+			return samples.WriteFolded(w, table.Format)
+		})
+	}
+
+	if *pprofFile == "" {
+		return
+	}
+
+	info, err := findDebugInfo(flag.Arg(0), *debugInfoFile)
+	if err != nil {
+		fatalf("failed to load debug info: %v", err)
+	}
+
+	writeFile(*pprofFile, func(w io.Writer) error {
+		return pprof.Write(w, samples, table.Format, info)
+	})
+}
+
+// commands maps subcommand names to their implementation, a command
+// line without a subcommand runs the given images.
+var commands = map[string]func(args []string){
+	"asm":       asmCommand,
+	"control":   controlCommand,
+	"core":      coreCommand,
+	"dap":       dapCommand,
+	"dasm":      dasmCommand,
+	"difftest":  difftestCommand,
+	"debug":     debugCommand,
+	"gdbserver": gdbserverCommand,
+	"grade":     gradeCommand,
+	"grpc":      grpcCommand,
+	"kernel":    kernelCommand,
+	"lc3x":      lc3xCommand,
+	"link":      linkCommand,
+	"lsp":       lspCommand,
+	"pennsim":   pennsimCommand,
+	"pgo":       pgoCommand,
+	"resume":    resumeCommand,
+	"save":      saveCommand,
+	"serve":     serveCommand,
+	"ssh":       sshCommand,
+	"state":     stateCommand,
+	"test":      testCommand,
+	"trace":     traceCommand,
+}
+
+func main() {
+	// a terminal left in raw mode is restored however lc3 ends.
+	defer term.Guard()()
+
+	if err := setupLogging(); err != nil {
+		fatal(err)
+	}
+
+	// consoles on Windows handle the escape sequences of games only
+	// once asked to.
+	term.EnableVirtualTerminal(int(os.Stdout.Fd()))
+
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+
+	flag.Parse()
+
+	args := loadArguments(flag.Args())
+	setup := loadSetup()
+
+	runImages := run
+
+	switch {
+	case *separateVMs && *cores > 1:
+		fatal("-vms and -cores cannot be used together")
+	case *separateVMs:
+		runImages = runVMs
+	case *cores > 1:
+		runImages = runCores
+	}
+
+	restore := enableRawMode()
+	err := runImages(args, setup)
+	restore()
+
+	if err != nil {
+		fatalf("Execution failed %v", err)
 	}
 }
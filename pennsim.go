@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"lc3/pkg/pennsim"
+	"lc3/pkg/term"
+	"os"
+)
+
+// pennsimCommand runs PennSim command scripts, "lc3 pennsim script ...",
+// exiting with 1 if a check or a command failed.
+func pennsimCommand(args []string) {
+	flags := flag.NewFlagSet("pennsim", flag.ExitOnError)
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 pennsim [flags] script-file ...\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	session := pennsim.New(os.Stdout, pennsim.WithConsole(term.NewReader(os.Stdin), os.Stdout))
+
+	for _, filename := range flags.Args() {
+		if err := session.RunFile(filename); err != nil {
+			fatalf("failed to run script: %v", err)
+		}
+	}
+
+	made, failed := session.Checks()
+	if failed > 0 || session.Errors() > 0 {
+		fmt.Printf("%d of %d checks failed, %d commands failed\n", failed, made, session.Errors())
+		os.Exit(1)
+	}
+}
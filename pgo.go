@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/workload"
+	"os"
+	"runtime/pprof"
+	"strings"
+	"time"
+)
+
+// pgoCommand profiles the VM running the programs of pkg/workload and
+// writes a Go CPU profile for profile-guided builds, "lc3 pgo [-o file]
+// [-duration d]".
+func pgoCommand(args []string) {
+	flags := flag.NewFlagSet("pgo", flag.ExitOnError)
+	output := flags.String("o", "default.pgo", "write the profile to `file`, which go build uses by default next to main.go")
+	duration := flags.Duration("duration", 10*time.Second, "run the workloads for `duration`")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 pgo [flags]\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var images [][cpu.MemoryMax]uint16
+
+	for _, name := range workload.Halting {
+		memory, err := workload.Image(name)
+		if err != nil {
+			fatal(err)
+		}
+
+		images = append(images, memory)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		fatal(err)
+	}
+
+	if err := pprof.StartCPUProfile(file); err != nil {
+		fatal(err)
+	}
+
+	runs, err := profileWorkloads(images, *duration)
+
+	pprof.StopCPUProfile()
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		fatalf("failed to profile the workloads: %v", err)
+	}
+
+	logger("pgo").Info("profiled the workloads", "runs", runs, "file", *output)
+}
+
+// profileWorkloads runs the workloads in turn until the duration is up,
+// under the interpreter, the block JIT and the fast mode alike, so that
+// the profile covers every loop Run may take. It returns the runs made.
+func profileWorkloads(images [][cpu.MemoryMax]uint16, duration time.Duration) (int, error) {
+	modes := [][]cpu.Option{nil, {cpu.WithBlockJIT(), cpu.WithFusion()}, {cpu.WithFastMode()}}
+
+	runs := 0
+
+	for deadline := time.Now().Add(duration); time.Now().Before(deadline); {
+		for _, opts := range modes {
+			for _, memory := range images {
+				c := cpu.NewCPU(append(opts, cpu.WithInput(strings.NewReader("")), cpu.WithOutput(io.Discard))...)
+
+				if err := c.Run(memory); err != nil {
+					return runs, err
+				}
+
+				runs++
+			}
+		}
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,108 @@
+package asm
+
+import (
+	"io"
+)
+
+// DefinitionKind is the kind of a name defined by a program.
+type DefinitionKind int
+
+const (
+	// DefLabel is a label, whose value is its address.
+	DefLabel DefinitionKind = iota
+
+	// DefConstant is a constant defined with .EQU.
+	DefConstant
+
+	// DefMacro is a macro, whose value is its number of parameters.
+	DefMacro
+)
+
+// definitionKinds names the kinds of definitions.
+var definitionKinds = map[DefinitionKind]string{
+	DefLabel:    "label",
+	DefConstant: "constant",
+	DefMacro:    "macro",
+}
+
+// String returns the name of the kind.
+func (k DefinitionKind) String() string {
+	return definitionKinds[k]
+}
+
+// Definition is a name defined by a program, and where.
+type Definition struct {
+	// Name is the name defined.
+	Name string
+
+	// Kind is the kind of definition.
+	Kind DefinitionKind
+
+	// Value is the address of a label, the value of a constant or the
+	// number of parameters of a macro.
+	Value int
+
+	// File is the file it is defined in, empty for the source itself
+	// when it was given without a name.
+	File string
+
+	// Line is the line it is defined on, starting at 1.
+	Line int
+
+	// Column is the column of the name, starting at 1.
+	Column int
+}
+
+// Analysis is what the assembler found out about a source, whether or
+// not it assembles.
+type Analysis struct {
+	// Definitions are the labels, constants and macros defined by the
+	// source and the files it includes, in order. Labels local to
+	// macro expansions are left out.
+	Definitions []Definition
+
+	// Diagnostics are the errors found in the source.
+	Diagnostics Diagnostics
+}
+
+// Analyze assembles a source for the names it defines and its errors,
+// as editors do while it is edited. The filename names the source in
+// diagnostics and definitions and locates the files it includes, its
+// content is read from r rather than from the file.
+func Analyze(filename string, r io.Reader, opts ...Option) (*Analysis, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// the last assembly is the one that counts with trampolines.
+	var last *assembler
+
+	opts = append(opts, func(a *assembler) {
+		last = a
+	})
+
+	_, _, diagnostics, err := assemble([]source{{name: filename, data: data}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Analysis{Definitions: last.definitions, Diagnostics: diagnostics}, nil
+}
+
+// define records a name defined by the source. Names defined by macro
+// expansions are not part of the source.
+func (a *assembler) define(pos position, name string, kind DefinitionKind, value int) {
+	if a.expanding > 0 {
+		return
+	}
+
+	a.definitions = append(a.definitions, Definition{
+		Name:   name,
+		Kind:   kind,
+		Value:  value,
+		File:   pos.file,
+		Line:   pos.line,
+		Column: pos.col,
+	})
+}
@@ -0,0 +1,1238 @@
+// Package asm assembles LC3 assembly language into object files that
+// the virtual machine loads, so that programs can be built without an
+// external assembler.
+//
+// The assembler makes two passes over the source: the first assigns
+// an address to every label, the second encodes the instructions and
+// data once every label is known.
+package asm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"lc3/pkg/debuginfo"
+	"lc3/pkg/symbols"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Object is an assembled program.
+type Object struct {
+	// Origin is the address the program is loaded at.
+	Origin uint16
+
+	// Words are the words of the program, starting at the origin.
+	Words []uint16
+
+	// Segments are the blocks of words the program is made of, one per
+	// .ORIG. Words covers them all, with the gaps between them filled
+	// with zeros.
+	Segments []Segment
+
+	// Lines are the lines of the source with the words assembled from
+	// them.
+	Lines []Line
+
+	// Globals maps the labels declared .GLOBAL to their addresses, for
+	// other objects to refer to.
+	Globals map[string]uint16
+
+	// Relocations are the words referring to symbols declared
+	// .EXTERNAL, which Link patches.
+	Relocations []Relocation
+
+	// Assertions are the checks written in ASSERT comments, to verify
+	// once the program halts.
+	Assertions []Assertion
+}
+
+// Segment is a block of words loaded at an address.
+type Segment struct {
+	// Origin is the address of the first word.
+	Origin uint16
+
+	// Words are the words of the segment.
+	Words []uint16
+}
+
+// layout lays out segments in memory, returning the lowest origin and
+// the words from there to the end of the highest segment, with the
+// gaps between segments filled with zeros. Segments must not overlap.
+func layout(segments []Segment) (uint16, []uint16, error) {
+	if len(segments) == 0 {
+		return 0, nil, nil
+	}
+
+	sorted := append([]Segment(nil), segments...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Origin < sorted[j].Origin
+	})
+
+	origin := sorted[0].Origin
+	end := int(origin)
+
+	var words []uint16
+
+	for _, segment := range sorted {
+		if int(segment.Origin) < end {
+			return 0, nil, fmt.Errorf("segment at x%04X overlaps the segment before it, which ends at x%04X", segment.Origin, end-1)
+		}
+
+		if int(segment.Origin)+len(segment.Words) > math.MaxUint16+1 {
+			return 0, nil, fmt.Errorf("segment at x%04X does not fit in memory", segment.Origin)
+		}
+
+		words = append(words, make([]uint16, int(segment.Origin)-end)...)
+		words = append(words, segment.Words...)
+		end = int(segment.Origin) + len(segment.Words)
+	}
+
+	return origin, words, nil
+}
+
+// Line is a line of source along with the words assembled from it.
+type Line struct {
+	// File is the name of the file the line was read from, which is
+	// empty for a source given as a reader.
+	File string
+
+	// Number is the line number, starting at 1.
+	Number int
+
+	// Text is the text of the line.
+	Text string
+
+	// Scope is the label the line falls under, the last one defined
+	// before it or on it, if any.
+	Scope string
+
+	// Address is the address of the first word of the line.
+	Address uint16
+
+	// Words are the words assembled from the line, if any.
+	Words []uint16
+}
+
+// DebugInfo returns debug info mapping the address of every word of
+// the object to the source line it was assembled from.
+func (o *Object) DebugInfo() *debuginfo.Info {
+	info := debuginfo.New()
+
+	for _, line := range o.Lines {
+		location := debuginfo.Location{File: line.File, Line: line.Number, Scope: line.Scope}
+
+		for i := range line.Words {
+			info.Add(line.Address+uint16(i), location)
+		}
+	}
+
+	return info
+}
+
+// WriteTo writes the object in .obj format: the origin followed by
+// the words, all big-endian.
+func (o *Object) WriteTo(w io.Writer) (int64, error) {
+	data := binary.BigEndian.AppendUint16(nil, o.Origin)
+	for _, word := range o.Words {
+		data = binary.BigEndian.AppendUint16(data, word)
+	}
+
+	n, err := w.Write(data)
+
+	return int64(n), err
+}
+
+// position is a line of a source file, or a token on it, used to
+// locate errors.
+type position struct {
+	// file is the name of the file, if any.
+	file string
+
+	// line is the line number, starting at 1.
+	line int
+
+	// col is the column of the token, or 0 for the whole line.
+	col int
+
+	// length is the length of the token.
+	length int
+
+	// text is the text of the line.
+	text string
+
+	// index is the index of the line in the source.
+	index int
+}
+
+// statement is an instruction or data directive of the source.
+type statement struct {
+	// line is the index of the source line of the statement.
+	line int
+
+	// pos is the position of the statement.
+	pos position
+
+	// index is the index of the statement in the program.
+	index int
+
+	// segment is the index of the segment of the statement.
+	segment int
+
+	// address is the address of the first word of the statement.
+	address uint16
+
+	// op is the mnemonic or directive.
+	op token
+
+	// operands are the operands, without the commas separating them.
+	operands []token
+}
+
+// assembler holds the state of an assembly.
+type assembler struct {
+	// statements are the statements of the program in order.
+	statements []*statement
+
+	// labels maps labels to addresses.
+	labels map[string]uint16
+
+	// constants maps the names defined with .EQU to their values.
+	constants map[string]int
+
+	// externals holds the symbols declared .EXTERNAL.
+	externals map[string]bool
+
+	// globals are the labels declared .GLOBAL.
+	globals []global
+
+	// relocations are the words referring to external symbols.
+	relocations []Relocation
+
+	// source holds the lines read so far, with included files in
+	// place of their .INCLUDE directive.
+	source []Line
+
+	// including holds the absolute paths of the files being read, to
+	// detect include cycles.
+	including []string
+
+	// includePath are the directories searched for included files.
+	includePath []string
+
+	// scope is the last label defined outside of macro expansions.
+	scope string
+
+	// definitions are the names defined by the source, in order.
+	definitions []Definition
+
+	// segments are the segments started by .ORIG, with the position
+	// of their directive.
+	segments []segment
+
+	// pc is the address of the next statement.
+	pc int
+
+	// started is set once .ORIG has been read.
+	started bool
+
+	// ended is set once .END has been read.
+	ended bool
+
+	// macros maps macro names to their definitions.
+	macros map[string]*macro
+
+	// defining is the macro whose body is being read, if any.
+	defining *macro
+
+	// expanding is the depth of macro expansions in progress.
+	expanding int
+
+	// expansions counts expansions to give their labels unique names.
+	expansions int
+
+	// diagnostics are the errors found in the source so far.
+	diagnostics Diagnostics
+
+	// dialect is the syntax accepted.
+	dialect Dialect
+
+	// trampolines is set to route far branches through trampolines.
+	trampolines bool
+
+	// mulDiv is set to assemble the multiply and divide extension.
+	mulDiv bool
+
+	// testAndSet is set to assemble the test-and-set extension.
+	testAndSet bool
+
+	// wideRegisters is set to assemble R8 to R15.
+	wideRegisters bool
+
+	// wideOperands has bit i set when operand i of the instruction
+	// being encoded names one of R8 to R15.
+	wideOperands int
+
+	// far holds the indexes of the statements that are branches too far
+	// for their offset, found by earlier assemblies.
+	far map[int]bool
+
+	// tooFar holds the indexes of the branches found too far for their
+	// offset in this assembly.
+	tooFar []int
+
+	// assertions are the ASSERT comments read so far.
+	assertions []assertion
+
+	// noIncludes is set to reject .INCLUDE.
+	noIncludes bool
+}
+
+// Option configures an assembly.
+type Option func(a *assembler)
+
+// WithIncludePath adds directories searched for included files that
+// are not found next to the file including them.
+func WithIncludePath(dirs ...string) Option {
+	return func(a *assembler) {
+		a.includePath = append(a.includePath, dirs...)
+	}
+}
+
+// WithoutIncludes rejects .INCLUDE, for sources from clients that must
+// not read the files of the host.
+func WithoutIncludes() Option {
+	return func(a *assembler) {
+		a.noIncludes = true
+	}
+}
+
+// Assemble assembles a program made of a single .ORIG block. Files it
+// includes are looked up in the current directory.
+//
+// It returns the object and its symbol table, or the diagnostics
+// describing every error found in the source. The error reports other
+// failures, such as a source that cannot be read.
+func Assemble(r io.Reader, opts ...Option) (*Object, *symbols.Table, Diagnostics, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return assemble([]source{{data: data}}, opts)
+}
+
+// AssembleFile assembles the program in a file like Assemble. Files it
+// includes are looked up next to the file including them.
+func AssembleFile(filename string, opts ...Option) (*Object, *symbols.Table, Diagnostics, error) {
+	return AssembleFiles([]string{filename}, opts...)
+}
+
+// AssembleFiles assembles the programs in several files into one
+// object, each file being a .ORIG block of its own. The files share
+// their labels, constants and macros, and their blocks must not
+// overlap.
+func AssembleFiles(filenames []string, opts ...Option) (*Object, *symbols.Table, Diagnostics, error) {
+	var sources []source
+
+	for _, filename := range filenames {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		sources = append(sources, source{name: filename, data: data})
+	}
+
+	return assemble(sources, opts)
+}
+
+// source is a source file read into memory.
+type source struct {
+	// name is the name of the file, empty for a source given as a
+	// reader.
+	name string
+
+	// data is the content of the file.
+	data []byte
+}
+
+// segment is a segment being assembled.
+type segment struct {
+	// origin is the address given by .ORIG.
+	origin uint16
+
+	// end is the address following the last statement.
+	end int
+
+	// pos is the position of the .ORIG directive.
+	pos position
+}
+
+// assemble assembles sources into one object. With trampolines, the
+// sources are assembled again as long as branches turn out to be too
+// far, each time making room for the trampolines of the branches
+// found.
+func assemble(sources []source, opts []Option) (*Object, *symbols.Table, Diagnostics, error) {
+	far := map[int]bool{}
+
+	for {
+		a := &assembler{
+			labels:    map[string]uint16{},
+			constants: map[string]int{},
+			externals: map[string]bool{},
+			macros:    map[string]*macro{},
+			dialect:   defaultDialect,
+			far:       far,
+		}
+
+		for _, opt := range opts {
+			opt(a)
+		}
+
+		obj, table, diagnostics, err := a.assemble(sources)
+		if err != nil || len(a.tooFar) == 0 {
+			return obj, table, diagnostics, err
+		}
+
+		for _, i := range a.tooFar {
+			far[i] = true
+		}
+	}
+}
+
+// assemble makes the two passes over the sources.
+func (a *assembler) assemble(sources []source) (*Object, *symbols.Table, Diagnostics, error) {
+	for _, source := range sources {
+		if err := a.firstPass(source); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	a.checkGlobals()
+
+	obj := a.secondPass()
+
+	if len(a.diagnostics) > 0 {
+		a.diagnostics.sort()
+		return nil, nil, a.diagnostics, nil
+	}
+
+	return obj, a.symbols(), nil, nil
+}
+
+// symbols returns the symbol table of the labels of the program.
+func (a *assembler) symbols() *symbols.Table {
+	table := symbols.New()
+	for label, address := range a.labels {
+		table.Add(label, address)
+	}
+
+	return table
+}
+
+// report records an error found in the source, so that assembly goes
+// on to find more of them. Other errors are returned.
+func (a *assembler) report(err error) error {
+	if d, ok := err.(*Diagnostic); ok {
+		a.diagnostics = append(a.diagnostics, d)
+		return nil
+	}
+
+	return err
+}
+
+// pos returns the position of a source line.
+func (a *assembler) pos(n int) position {
+	return position{
+		file:  a.source[n].File,
+		line:  a.source[n].Number,
+		text:  a.source[n].Text,
+		index: n,
+	}
+}
+
+// tokenPos returns the position of a token of a source line.
+func (a *assembler) tokenPos(n int, t token) position {
+	pos := a.pos(n)
+	pos.col = t.col
+	pos.length = t.size
+
+	return pos
+}
+
+// firstPass reads a source file, assigning addresses to statements and
+// labels.
+func (a *assembler) firstPass(src source) error {
+	a.including = nil
+	a.started, a.ended = false, false
+	a.scope = ""
+	reported := len(a.diagnostics)
+
+	if src.name != "" {
+		abs, err := filepath.Abs(src.name)
+		if err != nil {
+			return err
+		}
+
+		a.including = []string{abs}
+	}
+
+	if err := a.read(src.name, bytes.NewReader(src.data)); err != nil {
+		return err
+	}
+
+	end := position{file: src.name, index: len(a.source)}
+
+	if a.defining != nil {
+		a.report(coded(lineError(a.defining.pos, ".MACRO %s without .ENDM", a.defining.name), "macro"))
+		a.defining = nil
+	}
+
+	// statements before .ORIG have already been reported.
+	if !a.started && len(a.diagnostics) == reported {
+		a.report(hinted(coded(lineError(end, "missing .ORIG"), "missing-orig"), "programs start with .ORIG and the address they load at, as in .ORIG x3000"))
+	}
+
+	if !a.ended {
+		a.report(hinted(coded(lineError(end, "missing .END"), "missing-end"), "programs end with .END"))
+	}
+
+	return nil
+}
+
+// read reads the lines of a source file, named filename.
+func (a *assembler) read(filename string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+
+	for number := 1; scanner.Scan(); number++ {
+		n := len(a.source)
+		a.source = append(a.source, Line{File: filename, Number: number, Text: scanner.Text()})
+
+		tokens, err := lex(scanner.Text(), a.dialect)
+		if err != nil {
+			a.report(a.syntaxError(n, err))
+			continue
+		}
+
+		if !a.ended {
+			a.collectAssertion(n, tokens)
+		}
+
+		if err := a.report(a.line(n, tokens)); err != nil {
+			return err
+		}
+
+		a.source[n].Scope = a.scope
+	}
+
+	return scanner.Err()
+}
+
+// syntaxError locates an error found while splitting line n.
+func (a *assembler) syntaxError(n int, err error) *Diagnostic {
+	pos := a.pos(n)
+
+	if e, ok := err.(*syntaxError); ok {
+		pos.col = e.col
+		pos.length = 1
+	}
+
+	return lineError(pos, "%v", err)
+}
+
+// line processes the tokens of a source line, or of a line of a macro
+// expanded on line n.
+func (a *assembler) line(n int, tokens []token) error {
+	if a.defining != nil {
+		return a.macroLine(n, tokens)
+	}
+
+	if len(tokens) == 0 || a.ended {
+		return nil
+	}
+
+	if tokens[0].kind == tokWord && !a.isOp(tokens[0].text) {
+		if len(tokens) > 1 && tokens[1].kind == tokWord && !a.isOp(tokens[1].text) {
+			return a.unknownInstruction(a.tokenPos(n, tokens[0]), tokens[0].text)
+		}
+
+		label := strings.TrimSuffix(tokens[0].text, ":")
+
+		// labels of macro bodies were checked when they were defined.
+		if a.expanding == 0 && !validLabel(label) {
+			return hinted(coded(lineError(a.tokenPos(n, tokens[0]), "invalid label %q", label), "invalid-label"), "labels start with a letter or underscore, followed by letters, digits and underscores")
+		}
+
+		if _, ok := a.labels[label]; ok {
+			return coded(lineError(a.tokenPos(n, tokens[0]), "duplicate label %s", label), "duplicate-label")
+		}
+
+		if _, ok := a.constants[label]; ok {
+			return coded(lineError(a.tokenPos(n, tokens[0]), "duplicate label %s", label), "duplicate-label")
+		}
+
+		if a.externals[label] {
+			return coded(lineError(a.tokenPos(n, tokens[0]), "label %s is declared .EXTERNAL", label), "duplicate-label")
+		}
+
+		if len(tokens) > 1 && strings.EqualFold(tokens[1].text, ".EQU") {
+			if err := a.defineConstant(n, label, tokens[1:]); err != nil {
+				return err
+			}
+
+			a.define(a.tokenPos(n, tokens[0]), label, DefConstant, a.constants[label])
+
+			return nil
+		}
+
+		if !a.started {
+			return hinted(lineError(a.tokenPos(n, tokens[0]), "label %s before .ORIG", label), "only .EQU constants and macros may come before .ORIG")
+		}
+
+		a.labels[label] = uint16(a.pc)
+		a.define(a.tokenPos(n, tokens[0]), label, DefLabel, a.pc)
+		tokens = tokens[1:]
+
+		// the labels of macro bodies are local to the expansion.
+		if a.expanding == 0 {
+			a.scope = label
+		}
+
+		if len(tokens) == 0 {
+			return nil
+		}
+	}
+
+	if tokens[0].kind != tokWord {
+		return lineError(a.tokenPos(n, tokens[0]), "expected an instruction or directive")
+	}
+
+	operands, err := splitOperands(tokens[1:])
+	if err != nil {
+		return a.syntaxError(n, err)
+	}
+
+	s := &statement{
+		line:     n,
+		pos:      a.tokenPos(n, tokens[0]),
+		index:    len(a.statements),
+		segment:  len(a.segments) - 1,
+		address:  uint16(a.pc),
+		op:       tokens[0],
+		operands: operands,
+	}
+
+	if m, ok := a.macros[s.op.text]; ok {
+		return a.expand(m, s)
+	}
+
+	name := strings.ToUpper(s.op.text)
+
+	switch {
+	case name == ".MACRO":
+		return a.defineMacro(s)
+	case name == ".ENDM":
+		return coded(lineError(s.pos, ".ENDM without .MACRO"), "macro")
+	case name == ".INCLUDE":
+		return a.include(s)
+	case name == ".EQU":
+		return hinted(lineError(s.pos, ".EQU expects a name before it"), "constants are defined as NAME .EQU VALUE")
+	case name == ".EXTERNAL":
+		return a.declareExternal(s)
+	case name == ".GLOBAL":
+		return a.declareGlobal(s)
+	case name == ".ORIG":
+		if a.started {
+			return coded(lineError(s.pos, "duplicate .ORIG"), "duplicate-orig")
+		}
+
+		// an invalid .ORIG still starts the program, so that its
+		// statements are not all reported as coming before it.
+		a.started = true
+
+		if err := s.expect(1); err != nil {
+			return err
+		}
+
+		// dialects reading hex as signed make high origins negative.
+		origin, err := a.number(s, 0, math.MinInt16, math.MaxUint16)
+		if err != nil {
+			return err
+		}
+
+		a.pc = origin & math.MaxUint16
+		a.segments = append(a.segments, segment{origin: uint16(a.pc), end: a.pc, pos: s.pos})
+
+		return nil
+	case !a.started:
+		return hinted(coded(lineError(s.pos, "expected .ORIG before %s", s.op.text), "missing-orig"), "programs start with .ORIG and the address they load at, as in .ORIG x3000")
+	case name == ".END":
+		a.ended = true
+		return nil
+	}
+
+	size, err := a.size(s)
+	if err != nil {
+		// go on as if the statement took a word, to find more errors,
+		// but leave it out of the second pass.
+		a.pc++
+		return err
+	}
+
+	a.pc += size
+	if a.pc > math.MaxUint16+1 {
+		return coded(lineError(s.pos, "program does not fit in memory"), "out-of-memory")
+	}
+
+	a.segments[s.segment].end = a.pc
+
+	a.statements = append(a.statements, s)
+
+	return nil
+}
+
+// defineConstant defines a name for the value of the expression of an
+// .EQU directive, as in SIZE .EQU #10.
+func (a *assembler) defineConstant(n int, name string, tokens []token) error {
+	operands, err := splitOperands(tokens[1:])
+	if err != nil {
+		return a.syntaxError(n, err)
+	}
+
+	s := &statement{
+		line:     n,
+		pos:      a.tokenPos(n, tokens[0]),
+		op:       tokens[0],
+		operands: operands,
+	}
+
+	if err := s.expect(1); err != nil {
+		return err
+	}
+
+	v, err := a.number(s, 0, math.MinInt16, math.MaxUint16)
+	if err != nil {
+		return err
+	}
+
+	a.constants[name] = v
+
+	return nil
+}
+
+// secondPass encodes the statements, recording the errors found.
+func (a *assembler) secondPass() *Object {
+	obj := &Object{
+		Segments: make([]Segment, len(a.segments)),
+		Globals:  map[string]uint16{},
+	}
+
+	for i, segment := range a.segments {
+		obj.Segments[i].Origin = segment.origin
+	}
+
+	for _, g := range a.globals {
+		obj.Globals[g.name] = a.labels[g.name]
+	}
+
+	obj.Lines = a.source
+
+	for _, s := range a.statements {
+		words, err := a.encode(s)
+		if err != nil {
+			a.report(err)
+			continue
+		}
+
+		segment := &obj.Segments[s.segment]
+		segment.Words = append(segment.Words, words...)
+
+		// macro expansions add several statements to a line.
+		line := &obj.Lines[s.line]
+		if line.Words == nil {
+			line.Address = s.address
+		}
+
+		line.Words = append(line.Words, words...)
+	}
+
+	obj.Relocations = a.relocations
+	obj.Assertions = a.resolveAssertions()
+
+	if a.checkSegments() {
+		obj.Origin, obj.Words, _ = layout(obj.Segments)
+	}
+
+	return obj
+}
+
+// checkSegments reports the segments overlapping the segments before
+// them.
+func (a *assembler) checkSegments() bool {
+	ok := true
+
+	for i, segment := range a.segments {
+		for _, other := range a.segments[:i] {
+			if int(segment.origin) < other.end && int(other.origin) < segment.end {
+				a.report(coded(lineError(segment.pos, "block x%04X-x%04X overlaps the block x%04X-x%04X", segment.origin, segment.end-1, other.origin, other.end-1), "overlap"))
+				ok = false
+
+				break
+			}
+		}
+	}
+
+	return ok
+}
+
+// size returns the number of words a statement assembles to.
+func (a *assembler) size(s *statement) (int, error) {
+	if a.far[s.index] {
+		return trampolineSize(s), nil
+	}
+
+	switch strings.ToUpper(s.op.text) {
+	case ".FILL", ".WORDS":
+		if len(s.operands) == 0 {
+			return 0, lineError(s.pos, "%s expects at least one value", s.op.text)
+		}
+
+		return len(s.operands), nil
+	case ".ARRAY":
+		if len(s.operands) != 1 && len(s.operands) != 2 {
+			return 0, hinted(lineError(s.pos, "%s expects a count and a value", s.op.text), "blocks of initialized data are written .ARRAY COUNT, VALUE")
+		}
+
+		return a.number(s, 0, 1, math.MaxUint16)
+	case ".BLKW":
+		if err := s.expect(1); err != nil {
+			return 0, err
+		}
+
+		return a.number(s, 0, 1, math.MaxUint16)
+	case ".STRINGZ":
+		if err := s.expect(1); err != nil {
+			return 0, err
+		}
+
+		if s.operands[0].kind != tokString {
+			return 0, lineError(s.at(0), ".STRINGZ expects a quoted string")
+		}
+
+		return len(s.operands[0].text) + 1, nil
+	}
+
+	if a.usesWideRegisters(s) {
+		return 2, nil
+	}
+
+	return 1, nil
+}
+
+// encode returns the words of a statement.
+func (a *assembler) encode(s *statement) ([]uint16, error) {
+	switch strings.ToUpper(s.op.text) {
+	case ".FILL", ".WORDS":
+		words := make([]uint16, len(s.operands))
+		for i := range s.operands {
+			word, err := a.fill(s, i, s.address+uint16(i))
+			if err != nil {
+				return nil, err
+			}
+
+			words[i] = word
+		}
+
+		return words, nil
+	case ".ARRAY":
+		count, err := a.number(s, 0, 1, math.MaxUint16)
+		if err != nil || len(s.operands) == 1 {
+			return make([]uint16, count), err
+		}
+
+		words := make([]uint16, count)
+		for i := range words {
+			if words[i], err = a.fill(s, 1, s.address+uint16(i)); err != nil {
+				return nil, err
+			}
+		}
+
+		return words, nil
+	case ".BLKW":
+		count, err := a.number(s, 0, 1, math.MaxUint16)
+		return make([]uint16, count), err
+	case ".STRINGZ":
+		var words []uint16
+		for _, c := range []byte(s.operands[0].text) {
+			words = append(words, uint16(c))
+		}
+
+		return append(words, 0), nil
+	}
+
+	if a.far[s.index] {
+		return a.trampoline(s)
+	}
+
+	encoder, ok := a.lookup(s.op.text)
+	if !ok {
+		return nil, a.unknownInstruction(s.pos, s.op.text)
+	}
+
+	a.wideOperands = 0
+
+	word, err := encoder(a, s)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.wideOperands != 0 {
+		return []uint16{a.prefix(word), word}, nil
+	}
+
+	return []uint16{word}, nil
+}
+
+// fill returns the word at address given by operand i of a data
+// directive, which may be the address of an external symbol.
+func (a *assembler) fill(s *statement, i int, address uint16) (uint16, error) {
+	v, err := a.expression(s, i)
+	if err != nil {
+		return 0, err
+	}
+
+	if v.external != "" {
+		a.relocate(address, RelocWord, v)
+		return 0, nil
+	}
+
+	max := math.MaxUint16
+	if a.dialect.SignedFill {
+		max = math.MaxInt16
+	}
+
+	n, err := a.number(s, i, math.MinInt16, max)
+
+	return uint16(n), err
+}
+
+// unknownInstruction returns the error for a word that is neither an
+// instruction, a directive nor a macro, suggesting the closest one.
+func (a *assembler) unknownInstruction(pos position, word string) *Diagnostic {
+	d := coded(lineError(pos, "unknown instruction %s", word), "unknown-instruction")
+
+	if _, ok := mulDivInstructions[strings.ToUpper(word)]; ok && !a.mulDiv {
+		return hinted(d, "%s is part of the multiply and divide extension, which is not enabled", strings.ToUpper(word))
+	}
+
+	if _, ok := testAndSetInstructions[strings.ToUpper(word)]; ok && !a.testAndSet {
+		return hinted(d, "%s is part of the test-and-set extension, which is not enabled", strings.ToUpper(word))
+	}
+
+	var names []string
+	for name := range instructions {
+		names = append(names, name)
+	}
+
+	if a.mulDiv {
+		for name := range mulDivInstructions {
+			names = append(names, name)
+		}
+	}
+
+	if a.testAndSet {
+		for name := range testAndSetInstructions {
+			names = append(names, name)
+		}
+	}
+
+	for name := range directives {
+		names = append(names, name)
+	}
+
+	for name := range a.macros {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	if name, ok := closest(word, names); ok {
+		return hinted(d, "did you mean %s?", name)
+	}
+
+	return d
+}
+
+// at returns the position of operand i.
+func (s *statement) at(i int) position {
+	pos := s.pos
+	pos.col = s.operands[i].col
+	pos.length = s.operands[i].size
+
+	return pos
+}
+
+// expect checks the number of operands of a statement.
+func (s *statement) expect(n int) error {
+	if len(s.operands) != n {
+		return coded(lineError(s.pos, "%s expects %d operands, got %d", s.op.text, n, len(s.operands)), "operand-count")
+	}
+
+	return nil
+}
+
+// register parses operand i as a register R0-R7.
+func (a *assembler) register(s *statement, i int) (uint16, error) {
+	op := s.operands[i]
+
+	if r, ok := parseRegister(op.text); ok && op.kind == tokWord {
+		return r, nil
+	}
+
+	if r, ok := parseWideRegister(op.text); ok && op.kind == tokWord && a.wideRegisters {
+		a.wideOperands |= 1 << i
+		return r & 0x7, nil
+	}
+
+	d := coded(lineError(s.at(i), "%s expects a register, got %s", s.op.text, op.text), "invalid-register")
+
+	if len(op.text) == 2 && (op.text[0] == 'R' || op.text[0] == 'r') {
+		return 0, hinted(d, "registers are R0-R7")
+	}
+
+	return 0, d
+}
+
+// number evaluates operand i as a constant expression between min and
+// max.
+func (a *assembler) number(s *statement, i, min, max int) (int, error) {
+	n, err := a.constant(s, i)
+	if err != nil {
+		return 0, err
+	}
+
+	if n < min || n > max {
+		return 0, coded(lineError(s.at(i), "%s does not fit in %d..%d", s.operands[i].text, min, max), "out-of-range")
+	}
+
+	return n, nil
+}
+
+// constant evaluates operand i as a constant expression whose value
+// is known, one that does not refer to an external symbol.
+func (a *assembler) constant(s *statement, i int) (int, error) {
+	v, err := a.expression(s, i)
+	if err != nil {
+		return 0, err
+	}
+
+	if v.external != "" {
+		return 0, coded(lineError(s.at(i), "%s cannot refer to the external symbol %s", s.op.text, v.external), "external")
+	}
+
+	return v.n, nil
+}
+
+// expression evaluates operand i as a constant expression.
+func (a *assembler) expression(s *statement, i int) (value, error) {
+	op := s.operands[i]
+
+	if op.kind != tokWord {
+		return value{}, lineError(s.at(i), "%s expects a number, got %q", s.op.text, op.text)
+	}
+
+	if _, ok := parseRegister(op.text); ok {
+		return value{}, lineError(s.at(i), "%s expects a number, got %s", s.op.text, op.text)
+	}
+
+	v, err := a.evaluate(op.text)
+	if err != nil {
+		d := lineError(s.at(i), "%v", err)
+
+		var undefined *undefinedError
+		if errors.As(err, &undefined) {
+			coded(d, "undefined-symbol")
+
+			if name, ok := closest(undefined.name, a.names()); ok {
+				return value{}, hinted(d, "did you mean %s?", name)
+			}
+		}
+
+		return value{}, d
+	}
+
+	return v, nil
+}
+
+// names returns the labels, constants and externals defined so far,
+// sorted.
+func (a *assembler) names() []string {
+	var names []string
+	for name := range a.labels {
+		names = append(names, name)
+	}
+
+	for name := range a.constants {
+		names = append(names, name)
+	}
+
+	for name := range a.externals {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// immediate parses operand i as a signed number that fits in bits.
+func (a *assembler) immediate(s *statement, i, bits int) (uint16, error) {
+	n, err := a.constant(s, i)
+	if err != nil {
+		return 0, err
+	}
+
+	if n < -1<<(bits-1) || n > 1<<(bits-1)-1 {
+		d := coded(lineError(s.at(i), "%s does not fit in %d..%d", s.operands[i].text, -1<<(bits-1), 1<<(bits-1)-1), "out-of-range")
+
+		if bits == 5 {
+			hinted(d, "load larger values into a register with LD from a .FILL")
+		}
+
+		return 0, d
+	}
+
+	return uint16(n) & (1<<bits - 1), nil
+}
+
+// offset parses operand i as a PC-relative offset that fits in bits.
+// An expression referencing a label is an address, turned into its
+// distance from the incremented PC, and any other expression is taken
+// as the offset itself. The offset to an external symbol is left for
+// the linker.
+func (a *assembler) offset(s *statement, i, bits int) (uint16, error) {
+	v, err := a.expression(s, i)
+	if err != nil {
+		return 0, err
+	}
+
+	if !v.address {
+		return a.immediate(s, i, bits)
+	}
+
+	address := a.instructionAddress(s)
+
+	if v.external != "" {
+		a.relocate(address, pcRelocations[bits], v)
+		return 0, nil
+	}
+
+	offset := int(uint16(v.n)) - int(address) - 1
+	if offset < -1<<(bits-1) || offset > 1<<(bits-1)-1 {
+		if a.trampolines && isBranch(s.op.text) {
+			// assembled again with a trampoline.
+			a.tooFar = append(a.tooFar, s.index)
+		}
+
+		d := coded(lineError(s.at(i), "%s is too far away for %s (%d words, the limit is %d)", s.operands[i].text, s.op.text, offset, 1<<(bits-1)), "too-far")
+
+		switch strings.ToUpper(s.op.text) {
+		case "LD", "ST":
+			hinted(d, "use %sI through a nearby .FILL %s", strings.ToUpper(s.op.text), s.operands[i].text)
+		case "LEA", "LDI", "STI":
+			hinted(d, "load the address from a nearby .FILL %s", s.operands[i].text)
+		default:
+			if isBranch(s.op.text) {
+				hinted(d, "load the address into a register and jump through it with JMP, or enable trampolines")
+			} else {
+				hinted(d, "load the address into a register and jump through it with JSRR")
+			}
+		}
+
+		return 0, d
+	}
+
+	return uint16(offset) & (1<<bits - 1), nil
+}
+
+// splitOperands drops the commas between operands, checking that
+// there is exactly one between every pair. Tokens joined by expression
+// operators, as in LABEL + 2, form a single operand.
+func splitOperands(tokens []token) ([]token, error) {
+	var operands []token
+
+	for i, t := range tokens {
+		if t.kind == tokComma {
+			if i == 0 || i == len(tokens)-1 || tokens[i-1].kind == tokComma {
+				return nil, &syntaxError{col: t.col, msg: "unexpected comma"}
+			}
+
+			continue
+		}
+
+		if i > 0 && joins(tokens[i-1], t) {
+			op := &operands[len(operands)-1]
+			op.text += " " + t.text
+			op.size = t.col + t.size - op.col
+
+			continue
+		}
+
+		operands = append(operands, t)
+	}
+
+	return operands, nil
+}
+
+// joins reports whether a token continues the expression of the token
+// before it, because one of them has an operator at the edge between
+// them.
+func joins(prev, t token) bool {
+	if prev.kind != tokWord || t.kind != tokWord {
+		return false
+	}
+
+	return strings.ContainsRune("+-*/(", rune(prev.text[len(prev.text)-1])) || strings.ContainsRune("+-*/)", rune(t.text[0]))
+}
+
+// validLabel reports whether s is a valid label: a letter or
+// underscore followed by letters, digits and underscores, which is not
+// a register or number.
+func validLabel(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i, c := range s {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		digit := c >= '0' && c <= '9'
+
+		if !letter && (i == 0 || !digit) {
+			return false
+		}
+	}
+
+	if _, ok := defaultDialect.parseNumber(s); ok {
+		return false
+	}
+
+	_, ok := parseRegister(s)
+
+	return !ok
+}
+
+// parseRegister parses a register name R0-R7, in any case.
+func parseRegister(s string) (uint16, bool) {
+	if len(s) == 2 && (s[0] == 'R' || s[0] == 'r') && s[1] >= '0' && s[1] <= '7' {
+		return uint16(s[1] - '0'), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,237 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+// mustAssemble assembles a program, failing the test on any error.
+func mustAssemble(t *testing.T, source string, opts ...Option) *Object {
+	t.Helper()
+
+	obj, _, diagnostics, err := Assemble(strings.NewReader(source), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diagnostics) > 0 {
+		t.Fatalf("%v", diagnostics)
+	}
+
+	return obj
+}
+
+// program wraps lines in a .ORIG x3000 block.
+func program(lines ...string) string {
+	return ".ORIG x3000\n" + strings.Join(lines, "\n") + "\n.END\n"
+}
+
+// checkWords compares the words of an object with the expected ones.
+func checkWords(t *testing.T, obj *Object, words []uint16) {
+	t.Helper()
+
+	if len(obj.Words) != len(words) {
+		t.Fatalf("%d words %04X, expected %d %04X", len(obj.Words), obj.Words, len(words), words)
+	}
+
+	for i, word := range words {
+		if obj.Words[i] != word {
+			t.Errorf("word %d is x%04X, expected x%04X", i, obj.Words[i], word)
+		}
+	}
+}
+
+// TestEncoding checks the encoding of every instruction and directive.
+func TestEncoding(t *testing.T) {
+	tests := []struct {
+		line  string
+		words []uint16
+	}{
+		{"ADD R1, R2, R3", []uint16{0x1283}},
+		{"ADD R1, R2, #-16", []uint16{0x12B0}},
+		{"AND R0, R0, #0", []uint16{0x5020}},
+		{"AND R7, R6, R5", []uint16{0x5F85}},
+		{"NOT R4, R5", []uint16{0x997F}},
+		{"BRnzp #-1", []uint16{0x0FFF}},
+		{"BRz #2", []uint16{0x0402}},
+		{"BR #0", []uint16{0x0E00}},
+		{"JMP R3", []uint16{0xC0C0}},
+		{"RET", []uint16{0xC1C0}},
+		{"JSR #-1024", []uint16{0x4C00}},
+		{"JSRR R2", []uint16{0x4080}},
+		{"LD R0, #255", []uint16{0x20FF}},
+		{"LDI R1, #-256", []uint16{0xA300}},
+		{"LDR R2, R3, #31", []uint16{0x64DF}},
+		{"LEA R3, #1", []uint16{0xE601}},
+		{"ST R4, #0", []uint16{0x3800}},
+		{"STI R5, #-1", []uint16{0xBBFF}},
+		{"STR R6, R7, #-32", []uint16{0x7DE0}},
+		{"RTI", []uint16{0x8000}},
+		{"TRAP x23", []uint16{0xF023}},
+		{"GETC", []uint16{0xF020}},
+		{"OUT", []uint16{0xF021}},
+		{"PUTS", []uint16{0xF022}},
+		{"IN", []uint16{0xF023}},
+		{"PUTSP", []uint16{0xF024}},
+		{"HALT", []uint16{0xF025}},
+		{".FILL xBEEF", []uint16{0xBEEF}},
+		{".FILL #-1", []uint16{0xFFFF}},
+		{".BLKW 3", []uint16{0, 0, 0}},
+		{`.STRINGZ "hi"`, []uint16{'h', 'i', 0}},
+		{`.STRINGZ "a\n"`, []uint16{'a', '\n', 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			checkWords(t, mustAssemble(t, program(test.line)), test.words)
+		})
+	}
+}
+
+// TestLabels checks that PC-relative operands referring to labels are
+// encoded as the offset from the next instruction.
+func TestLabels(t *testing.T) {
+	obj := mustAssemble(t, program(
+		"LOOP  BRnzp DONE",
+		"      LEA R0, LOOP",
+		"      JSR LOOP",
+		"DONE  LD R1, DATA",
+		"DATA  .FILL LOOP",
+	))
+
+	checkWords(t, obj, []uint16{0x0E02, 0xE1FE, 0x4FFD, 0x2200, 0x3000})
+}
+
+// TestRangeErrors checks that immediates and offsets that do not fit in
+// their fields are rejected.
+func TestRangeErrors(t *testing.T) {
+	tests := []struct {
+		lines []string
+		code  string
+	}{
+		{[]string{"ADD R0, R0, #16"}, "out-of-range"},
+		{[]string{"ADD R0, R0, #-17"}, "out-of-range"},
+		{[]string{"LDR R0, R1, #32"}, "out-of-range"},
+		{[]string{"STR R0, R1, #-33"}, "out-of-range"},
+		{[]string{"BR #256"}, "out-of-range"},
+		{[]string{"LD R0, #-257"}, "out-of-range"},
+		{[]string{"JSR #1024"}, "out-of-range"},
+		{[]string{"LD R0, FAR", ".BLKW 256", "FAR .FILL 0"}, "too-far"},
+		{[]string{"ADD R8, R0, R0"}, "invalid-register"},
+		{[]string{"BR NOWHERE"}, "undefined-symbol"},
+		{[]string{"ADD R0, R0"}, "operand-count"},
+		{[]string{"FROB R0"}, "unknown-instruction"},
+	}
+
+	for _, test := range tests {
+		t.Run(strings.Join(test.lines, "; "), func(t *testing.T) {
+			_, _, diagnostics, err := Assemble(strings.NewReader(program(test.lines...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(diagnostics) != 1 || diagnostics[0].Code != test.code {
+				t.Errorf("diagnostics %v, expected one %s", diagnostics, test.code)
+			}
+		})
+	}
+}
+
+// TestMacros checks that macros expand their parameters and keep the
+// labels of each expansion apart.
+func TestMacros(t *testing.T) {
+	obj := mustAssemble(t, program(
+		".MACRO PUSH REG",
+		"      ADD R6, R6, #-1",
+		"      STR REG, R6, #0",
+		".ENDM",
+		".MACRO SKIP",
+		"      BRnzp OVER",
+		"OVER  ADD R0, R0, #0",
+		".ENDM",
+		"      PUSH R1",
+		"      PUSH R7",
+		"      SKIP",
+		"      SKIP",
+	))
+
+	checkWords(t, obj, []uint16{0x1DBF, 0x7380, 0x1DBF, 0x7F80, 0x0E00, 0x1020, 0x0E00, 0x1020})
+}
+
+// TestMacroErrors checks that macros used wrongly are rejected.
+func TestMacroErrors(t *testing.T) {
+	tests := map[string][]string{
+		"missing argument": {".MACRO PUSH REG", "STR REG, R6, #0", ".ENDM", "PUSH"},
+		"recursion":        {".MACRO AGAIN X", "AGAIN X", ".ENDM", "AGAIN R0"},
+		"unterminated":     {".MACRO PUSH REG", "STR REG, R6, #0"},
+		"duplicate param":  {".MACRO PUSH A, A", ".ENDM"},
+		"instruction name": {".MACRO ADD", ".ENDM"},
+	}
+
+	for name, lines := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, diagnostics, err := Assemble(strings.NewReader(program(lines...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(diagnostics) == 0 {
+				t.Error("assembled")
+			}
+		})
+	}
+}
+
+// TestExpressions checks constant expressions as immediates, words and
+// PC-relative operands.
+func TestExpressions(t *testing.T) {
+	tests := []struct {
+		expression string
+		value      uint16
+	}{
+		{"1+2*3", 7},
+		{"(1+2)*3", 9},
+		{"10/3", 3},
+		{"-2+x10", 14},
+		{"'A'+1", 'B'},
+		{"SIZE*2", 20},
+		{"DATA+1", 0x3002},
+		{"x3000-1", 0x2FFF},
+		{"#-1", 0xFFFF},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			obj := mustAssemble(t, program(
+				"SIZE  .EQU #10",
+				"      .FILL "+test.expression,
+				"DATA  .FILL 0",
+			))
+
+			if obj.Words[0] != test.value {
+				t.Errorf("x%04X, expected x%04X", obj.Words[0], test.value)
+			}
+		})
+	}
+
+	obj := mustAssemble(t, program(
+		"SIZE  .EQU #3",
+		"      ADD R0, R0, SIZE-4",
+		"      LD R0, DATA+1",
+		"DATA  .BLKW SIZE-1",
+		"      .FILL 0",
+	))
+
+	checkWords(t, obj, []uint16{0x103F, 0x2001, 0, 0, 0})
+
+	for _, expression := range []string{"1/0", "(1+2", "1+", "NOWHERE*2"} {
+		_, _, diagnostics, err := Assemble(strings.NewReader(program(".FILL " + expression)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(diagnostics) == 0 {
+			t.Errorf("%s assembled", expression)
+		}
+	}
+}
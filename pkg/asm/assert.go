@@ -0,0 +1,204 @@
+package asm
+
+import (
+	"fmt"
+	"lc3/pkg/registers"
+	"strings"
+)
+
+// Assertion is a check written in a comment of the source, as
+// "; ASSERT R0 == x0041" or "; ASSERT MEM[RESULT] != #0", that must
+// hold once the program halts.
+type Assertion struct {
+	// File is the name of the file of the comment, empty for a source
+	// given as a reader.
+	File string
+
+	// Line is the line number of the comment.
+	Line int
+
+	// Text is the assertion as written after ASSERT.
+	Text string
+
+	// Register is the register checked, R0 to R7 or the PC, unless
+	// Memory is set.
+	Register uint16
+
+	// Memory is set when the word at Address is checked.
+	Memory bool
+
+	// Address is the address of the word checked.
+	Address uint16
+
+	// Equal is set when the value checked must equal Value, and clear
+	// when it must differ from it.
+	Equal bool
+
+	// Value is the value compared with.
+	Value uint16
+}
+
+// Holds reports whether the assertion holds for the value checked.
+func (a Assertion) Holds(got uint16) bool {
+	return (got == a.Value) == a.Equal
+}
+
+// Target names the register or the word checked, as R0 or
+// MEM[x4000].
+func (a Assertion) Target() string {
+	switch {
+	case a.Memory:
+		return fmt.Sprintf("MEM[x%04X]", a.Address)
+	case a.Register == registers.RPC:
+		return "PC"
+	default:
+		return fmt.Sprintf("R%d", a.Register)
+	}
+}
+
+// String renders the assertion as FILE:LINE: ASSERT TEXT.
+func (a Assertion) String() string {
+	if a.File == "" {
+		return fmt.Sprintf("line %d: ASSERT %s", a.Line, a.Text)
+	}
+
+	return fmt.Sprintf("%s:%d: ASSERT %s", a.File, a.Line, a.Text)
+}
+
+// assertion is an assertion read from a comment, resolved once the
+// labels are known.
+type assertion struct {
+	// pos is the position of the comment.
+	pos position
+
+	// text is the assertion after ASSERT.
+	text string
+}
+
+// collectAssertion remembers the assertion in the comment of line n,
+// if it has one. The comment follows the last token of the line.
+func (a *assembler) collectAssertion(n int, tokens []token) {
+	line := a.source[n].Text
+
+	start := 0
+	if len(tokens) > 0 {
+		last := tokens[len(tokens)-1]
+		start = last.col - 1 + last.size
+	}
+
+	// the comment starts after the spaces that follow the last token.
+	col := start + len(line[start:]) - len(strings.TrimLeft(line[start:], " \t\r"))
+	comment := line[col:]
+
+	for _, prefix := range a.dialect.Comments {
+		text, ok := strings.CutPrefix(comment, prefix)
+		if !ok {
+			continue
+		}
+
+		keyword, rest, _ := strings.Cut(strings.TrimSpace(text), " ")
+		if !strings.EqualFold(keyword, "ASSERT") {
+			return
+		}
+
+		pos := a.pos(n)
+		pos.col = col + 1
+		pos.length = len(comment)
+
+		a.assertions = append(a.assertions, assertion{pos: pos, text: strings.TrimSpace(rest)})
+
+		return
+	}
+}
+
+// resolveAssertions resolves the assertions collected, reporting those
+// that cannot be.
+func (a *assembler) resolveAssertions() []Assertion {
+	var resolved []Assertion
+
+	for _, pending := range a.assertions {
+		assertion, err := a.resolveAssertion(pending.text)
+		if err != nil {
+			a.report(hinted(coded(lineError(pending.pos, "invalid assertion: %v", err), "assert"), "assertions are written as ; ASSERT R0 == x0041 or ; ASSERT MEM[LABEL] != #0"))
+			continue
+		}
+
+		assertion.File = pending.pos.file
+		assertion.Line = pending.pos.line
+
+		resolved = append(resolved, assertion)
+	}
+
+	return resolved
+}
+
+// resolveAssertion parses an assertion, evaluating its address and
+// value.
+func (a *assembler) resolveAssertion(text string) (Assertion, error) {
+	assertion := Assertion{Text: text}
+
+	op := "=="
+	lhs, rhs, ok := strings.Cut(text, op)
+	if !ok {
+		op = "!="
+		if lhs, rhs, ok = strings.Cut(text, op); !ok {
+			return assertion, fmt.Errorf("expected == or !=")
+		}
+	}
+
+	assertion.Equal = op == "=="
+	lhs, rhs = strings.TrimSpace(lhs), strings.TrimSpace(rhs)
+
+	upper := strings.ToUpper(lhs)
+
+	switch {
+	case strings.HasPrefix(upper, "MEM[") && strings.HasSuffix(upper, "]"):
+		address, err := a.assertionValue(lhs[len("MEM[") : len(lhs)-1])
+		if err != nil {
+			return assertion, err
+		}
+
+		assertion.Memory = true
+		assertion.Address = address
+	case upper == "PC":
+		assertion.Register = registers.RPC
+	default:
+		r, ok := parseRegister(lhs)
+		if !ok {
+			return assertion, fmt.Errorf("expected a register, PC or MEM[address], got %q", lhs)
+		}
+
+		assertion.Register = r
+	}
+
+	value, err := a.assertionValue(rhs)
+	if err != nil {
+		return assertion, err
+	}
+
+	assertion.Value = value
+
+	return assertion, nil
+}
+
+// assertionValue evaluates an expression of an assertion to a word.
+func (a *assembler) assertionValue(expression string) (uint16, error) {
+	if strings.TrimSpace(expression) == "" {
+		return 0, fmt.Errorf("missing value")
+	}
+
+	v, err := a.evaluate(strings.TrimSpace(expression))
+	if err != nil {
+		return 0, err
+	}
+
+	if v.external != "" {
+		return 0, fmt.Errorf("external symbol %s is only known once linked", v.external)
+	}
+
+	if v.n < -0x8000 || v.n > 0xFFFF {
+		return 0, fmt.Errorf("%s does not fit in a word", expression)
+	}
+
+	return uint16(v.n), nil
+}
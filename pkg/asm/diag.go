@@ -0,0 +1,276 @@
+package asm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Diagnostic is an error found in the source of a program.
+type Diagnostic struct {
+	// File is the name of the file, empty for a source given as a
+	// reader.
+	File string
+
+	// Line is the line number, starting at 1, or 0 if the error is not
+	// about a single line.
+	Line int
+
+	// Column is the column of the offending token, starting at 1, or 0
+	// if the error is about the whole line.
+	Column int
+
+	// Length is the length of the offending token.
+	Length int
+
+	// Source is the text of the line.
+	Source string
+
+	// Message describes the error.
+	Message string
+
+	// Hint suggests a fix, if there is one.
+	Hint string
+
+	// Severity is how serious the diagnostic is, error for everything
+	// that stops a program from assembling.
+	Severity string
+
+	// Code classifies the diagnostic, as undefined-symbol or too-far,
+	// for tools that react to some kinds of errors.
+	Code string
+
+	// index orders diagnostics by their place in the source.
+	index int
+}
+
+// Error renders the diagnostic as FILE:LINE:COLUMN: MESSAGE.
+func (d *Diagnostic) Error() string {
+	var b strings.Builder
+
+	b.WriteString(d.File)
+
+	if d.Line > 0 {
+		if d.File == "" {
+			fmt.Fprintf(&b, "line %d", d.Line)
+		} else {
+			fmt.Fprintf(&b, ":%d", d.Line)
+		}
+
+		if d.Column > 0 {
+			fmt.Fprintf(&b, ":%d", d.Column)
+		}
+	}
+
+	if b.Len() == 0 {
+		return d.Message
+	}
+
+	return b.String() + ": " + d.Message
+}
+
+// Format renders the diagnostic followed by the offending line with a
+// caret under the offending token, and the hint if there is one.
+func (d *Diagnostic) Format() string {
+	var b strings.Builder
+
+	b.WriteString(d.Error())
+	b.WriteString("\n")
+
+	if d.Line > 0 && strings.TrimSpace(d.Source) != "" {
+		fmt.Fprintf(&b, "    %s\n", d.Source)
+
+		if d.Column > 0 && d.Column <= len(d.Source)+1 {
+			// keep the tabs of the line so that the caret lines up.
+			indent := []byte(d.Source[:d.Column-1])
+			for i, c := range indent {
+				if c != '\t' {
+					indent[i] = ' '
+				}
+			}
+
+			fmt.Fprintf(&b, "    %s%s\n", indent, strings.Repeat("^", max(d.Length, 1)))
+		}
+	}
+
+	if d.Hint != "" {
+		fmt.Fprintf(&b, "    %s\n", d.Hint)
+	}
+
+	return b.String()
+}
+
+// Diagnostics are the errors found in the source of a program, in
+// source order.
+type Diagnostics []*Diagnostic
+
+// Error renders the diagnostics one per line.
+func (ds Diagnostics) Error() string {
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = d.Error()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// jsonPosition is a position of a diagnostic in JSON, with the line and
+// column starting at 1.
+type jsonPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// jsonDiagnostic is a diagnostic in JSON. Its range ends after the
+// offending token, and covers the whole line when the column is not
+// known.
+type jsonDiagnostic struct {
+	File  string `json:"file"`
+	Range struct {
+		Start jsonPosition `json:"start"`
+		End   jsonPosition `json:"end"`
+	} `json:"range"`
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// WriteJSON writes the diagnostics as a JSON array, for editors and
+// scripts:
+//
+//	[{"file": "prog.asm",
+//	  "range": {"start": {"line": 3, "column": 9}, "end": {"line": 3, "column": 12}},
+//	  "severity": "error", "code": "unknown-instruction",
+//	  "message": "unknown instruction ADX", "hint": "did you mean ADD?"}]
+func (ds Diagnostics) WriteJSON(w io.Writer) error {
+	out := make([]jsonDiagnostic, len(ds))
+
+	for i, d := range ds {
+		out[i] = jsonDiagnostic{
+			File:     d.File,
+			Severity: d.Severity,
+			Code:     d.Code,
+			Message:  d.Message,
+			Hint:     d.Hint,
+		}
+
+		start, end := jsonPosition{d.Line, d.Column}, jsonPosition{d.Line, d.Column + max(d.Length, 1)}
+		if d.Column == 0 {
+			start.Column, end.Column = 1, len(d.Source)+1
+		}
+
+		out[i].Range.Start, out[i].Range.End = start, end
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(out)
+}
+
+// sort orders the diagnostics by their place in the source.
+func (ds Diagnostics) sort() {
+	sort.SliceStable(ds, func(i, j int) bool {
+		return ds[i].index < ds[j].index
+	})
+}
+
+// lineError returns an error located at a position of the source.
+func lineError(pos position, format string, args ...any) *Diagnostic {
+	return &Diagnostic{
+		File:     pos.file,
+		Line:     pos.line,
+		Column:   pos.col,
+		Length:   pos.length,
+		Source:   pos.text,
+		Message:  fmt.Sprintf(format, args...),
+		Severity: "error",
+		Code:     "syntax",
+		index:    pos.index,
+	}
+}
+
+// coded classifies a diagnostic.
+func coded(d *Diagnostic, code string) *Diagnostic {
+	d.Code = code
+	return d
+}
+
+// hinted adds a hint to a diagnostic.
+func hinted(d *Diagnostic, format string, args ...any) *Diagnostic {
+	d.Hint = fmt.Sprintf(format, args...)
+	return d
+}
+
+// syntaxError is an error at a column of a line, found before the line
+// is split into statements.
+type syntaxError struct {
+	// col is the column of the error, starting at 1.
+	col int
+
+	// msg describes the error.
+	msg string
+}
+
+// Error returns the description of the error.
+func (e *syntaxError) Error() string {
+	return e.msg
+}
+
+// closest returns the candidate most similar to a misspelled word, if
+// one is close enough to be a likely typo.
+func closest(word string, candidates []string) (string, bool) {
+	best, bestDistance, bestPrefix := "", len(word)/2+1, 0
+
+	// among equally close candidates, prefer the one sharing the
+	// longest prefix, as LDI rather than ADD for LDD.
+	for _, c := range candidates {
+		d := editDistance(strings.ToUpper(word), strings.ToUpper(c))
+		prefix := commonPrefix(strings.ToUpper(word), strings.ToUpper(c))
+
+		if d < bestDistance || (d == bestDistance && best != "" && prefix > bestPrefix) {
+			best, bestDistance, bestPrefix = c, d, prefix
+		}
+	}
+
+	return best, best != ""
+}
+
+// commonPrefix returns the length of the common prefix of two words.
+func commonPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
+
+// editDistance returns the Levenshtein distance between two words.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
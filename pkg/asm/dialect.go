@@ -0,0 +1,145 @@
+package asm
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Dialect describes the syntax quirks of another assembler, so that
+// sources written for it assemble unchanged.
+type Dialect struct {
+	// Name names the dialect.
+	Name string
+
+	// Comments are the strings starting a comment.
+	Comments []string
+
+	// HexPrefixes are the prefixes of hex numbers.
+	HexPrefixes []string
+
+	// NegativeHex accepts a minus sign after the hex prefix, as in
+	// x-1.
+	NegativeHex bool
+
+	// SignedHex reads hex numbers as 16-bit two's complement, so that
+	// xFFFF is -1.
+	SignedHex bool
+
+	// SignedFill restricts .FILL values to -32768..32767, instead of
+	// also accepting 32768..65535.
+	SignedFill bool
+}
+
+// dialects are the dialects known by name.
+var dialects = map[string]Dialect{
+	"lc3": {
+		Name:        "lc3",
+		Comments:    []string{";"},
+		HexPrefixes: []string{"x", "X"},
+	},
+	"lc3as": {
+		Name:        "lc3as",
+		Comments:    []string{";"},
+		HexPrefixes: []string{"x", "X"},
+		NegativeHex: true,
+	},
+	"pennsim": {
+		Name:        "pennsim",
+		Comments:    []string{";", "//"},
+		HexPrefixes: []string{"x", "X", "0x", "0X"},
+		SignedHex:   true,
+	},
+	"lc3edit": {
+		Name:        "lc3edit",
+		Comments:    []string{";"},
+		HexPrefixes: []string{"x", "X"},
+		SignedHex:   true,
+		SignedFill:  true,
+	},
+}
+
+// defaultDialect is the syntax of this assembler.
+var defaultDialect = dialects["lc3"]
+
+// LookupDialect returns a dialect by name: lc3 for the syntax of this
+// assembler, or lc3as, pennsim or lc3edit for those assemblers.
+func LookupDialect(name string) (Dialect, bool) {
+	d, ok := dialects[strings.ToLower(name)]
+	return d, ok
+}
+
+// DialectNames returns the names of the known dialects, sorted.
+func DialectNames() []string {
+	names := make([]string, 0, len(dialects))
+	for name := range dialects {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// WithDialect accepts the syntax of another assembler.
+func WithDialect(d Dialect) Option {
+	return func(a *assembler) {
+		a.dialect = d
+	}
+}
+
+// comment reports whether a comment starts at the beginning of s.
+func (d Dialect) comment(s string) bool {
+	for _, c := range d.Comments {
+		if strings.HasPrefix(s, c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hex parses a hex number written with one of the prefixes of the
+// dialect, the longest matching prefix first.
+func (d Dialect) hex(s string) (int, bool) {
+	prefix := ""
+	for _, p := range d.HexPrefixes {
+		if strings.HasPrefix(s, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+
+	if prefix == "" {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(s[len(prefix):], 16, 32)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	if d.SignedHex && n > 0x7FFF && n <= 0xFFFF {
+		return int(n) - 0x10000, true
+	}
+
+	return int(n), true
+}
+
+// parseNumber parses a number written as #12, #-12 or 12 for decimal,
+// or with a hex prefix of the dialect.
+func (d Dialect) parseNumber(s string) (int, bool) {
+	if n, ok := d.hex(s); ok {
+		return n, true
+	}
+
+	switch {
+	case strings.HasPrefix(s, "#"):
+		n, err := strconv.ParseInt(s[1:], 10, 32)
+		return int(n), err == nil
+	case s != "" && s[0] >= '0' && s[0] <= '9':
+		n, err := strconv.ParseInt(s, 10, 32)
+		return int(n), err == nil
+	}
+
+	return 0, false
+}
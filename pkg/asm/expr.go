@@ -0,0 +1,287 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// exprOperators are the characters of expression operators, which
+// join the tokens around them into a single operand.
+const exprOperators = "+-*/()"
+
+// value is the value of a constant expression.
+type value struct {
+	// n is the value, or the constant added to the external symbol.
+	n int
+
+	// address is set when the expression references a label, which
+	// makes it an address.
+	address bool
+
+	// external is the external symbol the expression is relative to,
+	// if any, whose address is only known once linked.
+	external string
+}
+
+// exprParser evaluates a constant expression made of numbers, character
+// literals, labels and constants, combined with + - * / and
+// parentheses.
+type exprParser struct {
+	// a resolves labels and constants.
+	a *assembler
+
+	// s is the rest of the expression.
+	s string
+
+	// labels is set once a label has been referenced.
+	labels bool
+
+	// external is the external symbol referenced, if any.
+	external string
+}
+
+// evaluate evaluates a constant expression. An external symbol counts
+// as 0 in the value, and may only be offset by a constant.
+func (a *assembler) evaluate(expression string) (value, error) {
+	p := &exprParser{a: a, s: expression}
+
+	n, err := p.sum()
+	if err != nil {
+		return value{}, err
+	}
+
+	p.skipSpace()
+	if p.s != "" {
+		return value{}, fmt.Errorf("unexpected %q in %s", p.s, expression)
+	}
+
+	return value{n: n, address: p.labels, external: p.external}, nil
+}
+
+// offsetOnly fails if an external symbol was referenced since before,
+// in a part of the expression that would scale or negate it.
+func (p *exprParser) offsetOnly(before string) error {
+	if p.external != before {
+		return fmt.Errorf("external symbol %s can only be offset by a constant", p.external)
+	}
+
+	return nil
+}
+
+// undefinedError reports a name that is neither a label nor a
+// constant.
+type undefinedError struct {
+	// name is the undefined name.
+	name string
+}
+
+// Error describes the error.
+func (e *undefinedError) Error() string {
+	return fmt.Sprintf("undefined label %s", e.name)
+}
+
+// skipSpace skips leading whitespace.
+func (p *exprParser) skipSpace() {
+	p.s = strings.TrimLeft(p.s, " \t")
+}
+
+// accept consumes an operator if it comes next.
+func (p *exprParser) accept(op byte) bool {
+	p.skipSpace()
+
+	if p.s != "" && p.s[0] == op {
+		p.s = p.s[1:]
+		return true
+	}
+
+	return false
+}
+
+// sum parses terms separated by + and -.
+func (p *exprParser) sum() (int, error) {
+	v, err := p.product()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		switch {
+		case p.accept('+'):
+			w, err := p.product()
+			if err != nil {
+				return 0, err
+			}
+
+			v += w
+		case p.accept('-'):
+			before := p.external
+
+			w, err := p.product()
+			if err != nil {
+				return 0, err
+			}
+
+			if err := p.offsetOnly(before); err != nil {
+				return 0, err
+			}
+
+			v -= w
+		default:
+			return v, nil
+		}
+	}
+}
+
+// product parses factors separated by * and /.
+func (p *exprParser) product() (int, error) {
+	before := p.external
+
+	v, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		switch {
+		case p.accept('*'):
+			w, err := p.unary()
+			if err != nil {
+				return 0, err
+			}
+
+			if err := p.offsetOnly(before); err != nil {
+				return 0, err
+			}
+
+			v *= w
+		case p.accept('/'):
+			w, err := p.unary()
+			if err != nil {
+				return 0, err
+			}
+
+			if err := p.offsetOnly(before); err != nil {
+				return 0, err
+			}
+
+			if w == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+
+			v /= w
+		default:
+			return v, nil
+		}
+	}
+}
+
+// unary parses a negated or plain operand.
+func (p *exprParser) unary() (int, error) {
+	if p.accept('-') {
+		before := p.external
+
+		v, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+
+		return -v, p.offsetOnly(before)
+	}
+
+	return p.operand()
+}
+
+// operand parses a number, character literal, label, constant or
+// parenthesized expression.
+func (p *exprParser) operand() (int, error) {
+	p.skipSpace()
+
+	if p.accept('(') {
+		v, err := p.sum()
+		if err != nil {
+			return 0, err
+		}
+
+		if !p.accept(')') {
+			return 0, fmt.Errorf("missing )")
+		}
+
+		return v, nil
+	}
+
+	if strings.HasPrefix(p.s, "'") {
+		size, ok := charLiteral(p.s)
+		if !ok {
+			return 0, fmt.Errorf("invalid character literal %s", p.s)
+		}
+
+		c := charValue(p.s[:size])
+		p.s = p.s[size:]
+
+		return int(c), nil
+	}
+
+	// the sign of #-12, or of x-C in dialects allowing it, belongs to
+	// the number.
+	prefixes := []string{"#"}
+	if p.a.dialect.NegativeHex {
+		prefixes = append(prefixes, p.a.dialect.HexPrefixes...)
+	}
+
+	for _, prefix := range prefixes {
+		if rest, ok := strings.CutPrefix(p.s, prefix+"-"); ok {
+			p.s = rest
+
+			word := p.word()
+			if n, ok := p.a.dialect.parseNumber(prefix + word); ok {
+				return -n, nil
+			}
+
+			return 0, fmt.Errorf("invalid number %s-%s", prefix, word)
+		}
+	}
+
+	word := p.word()
+	if word == "" {
+		return 0, fmt.Errorf("missing operand")
+	}
+
+	if n, ok := p.a.dialect.parseNumber(word); ok {
+		return n, nil
+	}
+
+	if v, ok := p.a.constants[word]; ok {
+		return v, nil
+	}
+
+	if address, ok := p.a.labels[word]; ok {
+		p.labels = true
+		return int(address), nil
+	}
+
+	if p.a.externals[word] {
+		if p.external != "" {
+			return 0, fmt.Errorf("expression refers to both %s and %s, which are external", p.external, word)
+		}
+
+		p.labels = true
+		p.external = word
+
+		return 0, nil
+	}
+
+	return 0, &undefinedError{name: word}
+}
+
+// word consumes the characters up to the next operator or space.
+func (p *exprParser) word() string {
+	end := strings.IndexAny(p.s, exprOperators+" \t")
+	if end < 0 {
+		end = len(p.s)
+	}
+
+	word := p.s[:end]
+	p.s = p.s[end:]
+
+	return word
+}
@@ -0,0 +1,77 @@
+package asm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// include reads the file named by an .INCLUDE directive in place of
+// the directive. Relative names are looked up next to the including
+// file, then in the include path.
+func (a *assembler) include(s *statement) error {
+	if err := s.expect(1); err != nil {
+		return err
+	}
+
+	if s.operands[0].kind != tokString {
+		return lineError(s.at(0), ".INCLUDE expects a quoted file name")
+	}
+
+	if a.noIncludes {
+		return coded(lineError(s.at(0), ".INCLUDE is not allowed here"), "include")
+	}
+
+	filename, err := a.resolve(s.operands[0].text, a.source[s.line].File)
+	if err != nil {
+		return coded(lineError(s.at(0), "cannot include %s: %v", s.operands[0].text, err), "include")
+	}
+
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return coded(lineError(s.at(0), "cannot include %s: %v", filename, err), "include")
+	}
+
+	for i, other := range a.including {
+		if other == abs {
+			var cycle []string
+			for _, path := range append(a.including[i:], abs) {
+				cycle = append(cycle, filepath.Base(path))
+			}
+
+			return coded(lineError(s.at(0), "include cycle %s", strings.Join(cycle, " -> ")), "include")
+		}
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return coded(lineError(s.at(0), "cannot include %s: %v", filename, err), "include")
+	}
+
+	defer file.Close()
+
+	a.including = append(a.including, abs)
+	defer func() { a.including = a.including[:len(a.including)-1] }()
+
+	return a.read(filename, file)
+}
+
+// resolve finds an included file, named relative to the including
+// file or to a directory of the include path.
+func (a *assembler) resolve(name, from string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+
+	dirs := append([]string{filepath.Dir(from)}, a.includePath...)
+
+	for _, dir := range dirs {
+		filename := filepath.Join(dir, name)
+
+		if _, err := os.Stat(filename); err == nil {
+			return filename, nil
+		}
+	}
+
+	return "", os.ErrNotExist
+}
@@ -0,0 +1,154 @@
+package asm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files into a temporary directory, creating the
+// directories they are in, and returns the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for name, data := range files {
+		filename := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// TestInclude checks where included files are looked up.
+func TestInclude(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		path  []string
+		words []uint16
+	}{
+		{
+			name: "next to the including file",
+			files: map[string]string{
+				"main.asm": program(`.INCLUDE "lib.asm"`, "HALT"),
+				"lib.asm":  ".FILL #1\n",
+			},
+			words: []uint16{1, 0xF025},
+		},
+		{
+			name: "relative to the including file",
+			files: map[string]string{
+				"main.asm":     program(`.INCLUDE "sub/a.asm"`),
+				"sub/a.asm":    `.INCLUDE "b.asm"` + "\n.FILL #1\n",
+				"sub/b.asm":    ".FILL #2\n",
+				"b.asm":        ".FILL #3\n",
+				"unused/b.asm": ".FILL #4\n",
+			},
+			words: []uint16{2, 1},
+		},
+		{
+			name: "include path",
+			files: map[string]string{
+				"main.asm":    program(`.INCLUDE "lib.asm"`),
+				"lib/lib.asm": ".FILL #5\n",
+			},
+			path:  []string{"lib"},
+			words: []uint16{5},
+		},
+		{
+			name: "macros from an included file",
+			files: map[string]string{
+				"main.asm":   program(`.INCLUDE "macros.asm"`, "CLEAR R2"),
+				"macros.asm": ".MACRO CLEAR REG\nAND REG, REG, #0\n.ENDM\n",
+			},
+			words: []uint16{0x54A0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeFiles(t, test.files)
+
+			var path []string
+			for _, p := range test.path {
+				path = append(path, filepath.Join(dir, p))
+			}
+
+			obj, _, diagnostics, err := AssembleFile(filepath.Join(dir, "main.asm"), WithIncludePath(path...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(diagnostics) > 0 {
+				t.Fatalf("%v", diagnostics)
+			}
+
+			checkWords(t, obj, test.words)
+		})
+	}
+}
+
+// TestIncludeErrors checks that includes that cannot be resolved, or
+// are not allowed, are rejected.
+func TestIncludeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		opts    []Option
+		message string
+	}{
+		{
+			name:    "missing file",
+			files:   map[string]string{"main.asm": program(`.INCLUDE "missing.asm"`)},
+			message: "cannot include",
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"main.asm": program(`.INCLUDE "a.asm"`),
+				"a.asm":    `.INCLUDE "b.asm"` + "\n",
+				"b.asm":    `.INCLUDE "a.asm"` + "\n",
+			},
+			message: "include cycle a.asm -> b.asm -> a.asm",
+		},
+		{
+			name:    "unquoted name",
+			files:   map[string]string{"main.asm": program(".INCLUDE lib.asm")},
+			message: "quoted file name",
+		},
+		{
+			name: "includes disabled",
+			files: map[string]string{
+				"main.asm": program(`.INCLUDE "lib.asm"`),
+				"lib.asm":  ".FILL #1\n",
+			},
+			opts:    []Option{WithoutIncludes()},
+			message: "not allowed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeFiles(t, test.files)
+
+			_, _, diagnostics, err := AssembleFile(filepath.Join(dir, "main.asm"), test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(diagnostics) == 0 || !strings.Contains(diagnostics[0].Message, test.message) {
+				t.Errorf("diagnostics %v, expected %q", diagnostics, test.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package asm
+
+import (
+	"lc3/pkg/opcodes"
+	"lc3/pkg/traps"
+	"strings"
+)
+
+// encoder encodes an instruction statement into a word.
+type encoder func(a *assembler, s *statement) (uint16, error)
+
+// instructions maps mnemonics, in upper case, to their encoders.
+// Branches are handled by lookup since their mnemonic carries the
+// condition flags.
+var instructions = map[string]encoder{
+	"ADD":  encodeArithmetic(opcodes.OPADD),
+	"AND":  encodeArithmetic(opcodes.OPAND),
+	"NOT":  encodeNot,
+	"JMP":  encodeJump,
+	"JSR":  encodeJSR,
+	"JSRR": encodeJSRR,
+	"RET":  encodeRet,
+	"LD":   encodePCRelative(opcodes.OPLD),
+	"LDI":  encodePCRelative(opcodes.OPLDI),
+	"LEA":  encodePCRelative(opcodes.OPLEA),
+	"ST":   encodePCRelative(opcodes.OPST),
+	"STI":  encodePCRelative(opcodes.OPSTI),
+	"LDR":  encodeBaseOffset(opcodes.OPLDR),
+	"STR":  encodeBaseOffset(opcodes.OPSTR),
+	"RTI":  encodeRTI,
+	"TRAP": encodeTrap,
+
+	// the trap routines have aliases.
+	"GETC":  encodeTrapAlias(traps.GETC),
+	"OUT":   encodeTrapAlias(traps.OUT),
+	"PUTS":  encodeTrapAlias(traps.PUTS),
+	"IN":    encodeTrapAlias(traps.IN),
+	"PUTSP": encodeTrapAlias(traps.PUTSP),
+	"HALT":  encodeTrapAlias(traps.HALT),
+}
+
+// directives are the assembler directives.
+var directives = map[string]bool{
+	".ORIG":     true,
+	".END":      true,
+	".FILL":     true,
+	".BLKW":     true,
+	".WORDS":    true,
+	".ARRAY":    true,
+	".STRINGZ":  true,
+	".MACRO":    true,
+	".ENDM":     true,
+	".INCLUDE":  true,
+	".EQU":      true,
+	".EXTERNAL": true,
+	".GLOBAL":   true,
+}
+
+// isOp reports whether a word is a mnemonic, directive or macro,
+// rather than a label.
+func (a *assembler) isOp(word string) bool {
+	if directives[strings.ToUpper(word)] || a.macros[word] != nil {
+		return true
+	}
+
+	_, ok := a.lookup(word)
+
+	return ok
+}
+
+// lookup returns the encoder of a mnemonic, in any case.
+func lookup(mnemonic string) (encoder, bool) {
+	mnemonic = strings.ToUpper(mnemonic)
+
+	if e, ok := instructions[mnemonic]; ok {
+		return e, true
+	}
+
+	if flags, ok := branchFlags(mnemonic); ok {
+		return encodeBranch(flags), true
+	}
+
+	return nil, false
+}
+
+// isBranch reports whether a mnemonic is a branch.
+func isBranch(mnemonic string) bool {
+	_, ok := branchFlags(strings.ToUpper(mnemonic))
+	return ok
+}
+
+// branchFlags parses the condition flags of an upper case branch
+// mnemonic, BR followed by any of N, Z and P in that order. BR alone
+// branches always.
+func branchFlags(mnemonic string) (uint16, bool) {
+	rest, ok := strings.CutPrefix(mnemonic, "BR")
+	if !ok {
+		return 0, false
+	}
+
+	if rest == "" {
+		return 0x7, true
+	}
+
+	var flags uint16
+	for _, bit := range []struct {
+		flag  byte
+		value uint16
+	}{{'N', 0x4}, {'Z', 0x2}, {'P', 0x1}} {
+		if rest != "" && rest[0] == bit.flag {
+			flags |= bit.value
+			rest = rest[1:]
+		}
+	}
+
+	return flags, rest == ""
+}
+
+// encodeArithmetic encodes ADD and AND, whose second source is either
+// a register or a 5-bit immediate.
+func encodeArithmetic(op uint16) encoder {
+	return func(a *assembler, s *statement) (uint16, error) {
+		if err := s.expect(3); err != nil {
+			return 0, err
+		}
+
+		dr, err := a.register(s, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		sr1, err := a.register(s, 1)
+		if err != nil {
+			return 0, err
+		}
+
+		word := op<<12 | dr<<9 | sr1<<6
+
+		if sr2, err := a.register(s, 2); err == nil {
+			return word | sr2, nil
+		}
+
+		imm, err := a.immediate(s, 2, 5)
+
+		return word | 1<<5 | imm, err
+	}
+}
+
+// encodeNot encodes NOT.
+func encodeNot(a *assembler, s *statement) (uint16, error) {
+	if err := s.expect(2); err != nil {
+		return 0, err
+	}
+
+	dr, err := a.register(s, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	sr, err := a.register(s, 1)
+
+	return opcodes.OPNOT<<12 | dr<<9 | sr<<6 | 0x3F, err
+}
+
+// encodeBranch encodes a branch with the given condition flags.
+func encodeBranch(flags uint16) encoder {
+	return func(a *assembler, s *statement) (uint16, error) {
+		if err := s.expect(1); err != nil {
+			return 0, err
+		}
+
+		offset, err := a.offset(s, 0, 9)
+
+		return opcodes.OPBR<<12 | flags<<9 | offset, err
+	}
+}
+
+// encodeJump encodes JMP.
+func encodeJump(a *assembler, s *statement) (uint16, error) {
+	if err := s.expect(1); err != nil {
+		return 0, err
+	}
+
+	base, err := a.register(s, 0)
+
+	return opcodes.OPJMP<<12 | base<<6, err
+}
+
+// encodeJSR encodes JSR with an 11-bit offset.
+func encodeJSR(a *assembler, s *statement) (uint16, error) {
+	if err := s.expect(1); err != nil {
+		return 0, err
+	}
+
+	offset, err := a.offset(s, 0, 11)
+
+	return opcodes.OPJSR<<12 | 1<<11 | offset, err
+}
+
+// encodeJSRR encodes JSRR, the register form of JSR.
+func encodeJSRR(a *assembler, s *statement) (uint16, error) {
+	if err := s.expect(1); err != nil {
+		return 0, err
+	}
+
+	base, err := a.register(s, 0)
+
+	return opcodes.OPJSR<<12 | base<<6, err
+}
+
+// encodeRet encodes RET, which is JMP R7.
+func encodeRet(a *assembler, s *statement) (uint16, error) {
+	return opcodes.OPJMP<<12 | 7<<6, s.expect(0)
+}
+
+// encodePCRelative encodes the loads and stores addressing memory
+// relative to the PC.
+func encodePCRelative(op uint16) encoder {
+	return func(a *assembler, s *statement) (uint16, error) {
+		if err := s.expect(2); err != nil {
+			return 0, err
+		}
+
+		r, err := a.register(s, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		offset, err := a.offset(s, 1, 9)
+
+		return op<<12 | r<<9 | offset, err
+	}
+}
+
+// encodeBaseOffset encodes LDR and STR.
+func encodeBaseOffset(op uint16) encoder {
+	return func(a *assembler, s *statement) (uint16, error) {
+		if err := s.expect(3); err != nil {
+			return 0, err
+		}
+
+		r, err := a.register(s, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		base, err := a.register(s, 1)
+		if err != nil {
+			return 0, err
+		}
+
+		offset, err := a.immediate(s, 2, 6)
+
+		return op<<12 | r<<9 | base<<6 | offset, err
+	}
+}
+
+// encodeRTI encodes RTI.
+func encodeRTI(a *assembler, s *statement) (uint16, error) {
+	return opcodes.OPRTI << 12, s.expect(0)
+}
+
+// encodeTrapAlias encodes the alias of a trap routine, a TRAP with a
+// fixed vector.
+func encodeTrapAlias(vector uint16) encoder {
+	return func(a *assembler, s *statement) (uint16, error) {
+		return opcodes.OPTRAP<<12 | vector, s.expect(0)
+	}
+}
+
+// encodeTrap encodes TRAP.
+func encodeTrap(a *assembler, s *statement) (uint16, error) {
+	if err := s.expect(1); err != nil {
+		return 0, err
+	}
+
+	vector, err := a.number(s, 0, 0, 0xFF)
+
+	return opcodes.OPTRAP<<12 | uint16(vector), err
+}
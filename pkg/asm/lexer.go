@@ -0,0 +1,144 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind is the kind of a token.
+type tokenKind int
+
+const (
+	// tokWord is a mnemonic, directive, label, register or number.
+	tokWord tokenKind = iota
+
+	// tokString is a quoted string, its text excludes the quotes.
+	tokString
+
+	// tokComma separates operands.
+	tokComma
+)
+
+// token is a token of a source line.
+type token struct {
+	// kind is the kind of the token.
+	kind tokenKind
+
+	// text is the text of the token, with the escapes of a string
+	// replaced by the characters they stand for.
+	text string
+
+	// col is the column the token starts at, starting at 1.
+	col int
+
+	// size is the length of the token in the source.
+	size int
+}
+
+// escapes maps the characters following a backslash in strings and
+// character literals to the characters they stand for.
+var escapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'e':  0x1B,
+	'\\': '\\',
+	'\'': '\'',
+	'"':  '"',
+}
+
+// lex splits a source line into tokens, dropping the comment started
+// by one of the comment strings of the dialect.
+func lex(line string, d Dialect) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(line); {
+		c := line[i]
+
+		switch {
+		case c == '\'':
+			size, ok := charLiteral(line[i:])
+			if !ok {
+				return nil, &syntaxError{col: i + 1, msg: "invalid character literal"}
+			}
+
+			tokens = append(tokens, token{kind: tokWord, text: line[i : i+size], col: i + 1, size: size})
+			i += size
+		case d.comment(line[i:]):
+			return tokens, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ",", col: i + 1, size: 1})
+			i++
+		case c == '"':
+			text, size, err := quoted(line[i:])
+			if err != nil {
+				err.col += i
+				return nil, err
+			}
+
+			tokens = append(tokens, token{kind: tokString, text: text, col: i + 1, size: size})
+			i += size
+		default:
+			start := i
+			for i < len(line) && !strings.ContainsRune(" \t\r,\"", rune(line[i])) && !d.comment(line[i:]) {
+				i++
+			}
+
+			tokens = append(tokens, token{kind: tokWord, text: line[start:i], col: start + 1, size: i - start})
+		}
+	}
+
+	return tokens, nil
+}
+
+// quoted reads the quoted string s starts with, returning its text with
+// the escapes replaced and its length in the source.
+func quoted(s string) (string, int, *syntaxError) {
+	var b strings.Builder
+
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			return b.String(), i + 1, nil
+		case s[i] == '\\' && i+1 < len(s):
+			c, ok := escapes[s[i+1]]
+			if !ok {
+				return "", 0, &syntaxError{col: i + 1, msg: fmt.Sprintf("unknown escape \\%c", s[i+1])}
+			}
+
+			b.WriteByte(c)
+			i++
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return "", 0, &syntaxError{col: 1, msg: "unterminated string"}
+}
+
+// charLiteral returns the length of the character literal s starts
+// with, as in 'A' or '\n'.
+func charLiteral(s string) (int, bool) {
+	if len(s) >= 4 && s[1] == '\\' && s[3] == '\'' {
+		_, ok := escapes[s[2]]
+		return 4, ok
+	}
+
+	if len(s) >= 3 && s[1] != '\\' && s[2] == '\'' {
+		return 3, true
+	}
+
+	return 0, false
+}
+
+// charValue returns the character a literal of charLiteral stands for.
+func charValue(literal string) byte {
+	if literal[1] == '\\' {
+		return escapes[literal[2]]
+	}
+
+	return literal[1]
+}
@@ -0,0 +1,334 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RelocationKind is the kind of field a relocation patches.
+type RelocationKind int
+
+const (
+	// RelocWord sets a whole word to the address, as for .FILL.
+	RelocWord RelocationKind = iota
+
+	// RelocPC9 sets the 9-bit PC-relative offset of a branch, load or
+	// store.
+	RelocPC9
+
+	// RelocPC11 sets the 11-bit PC-relative offset of JSR.
+	RelocPC11
+)
+
+// relocationKinds names the kinds of relocations in relocation files.
+var relocationKinds = map[RelocationKind]string{
+	RelocWord: "word",
+	RelocPC9:  "pc9",
+	RelocPC11: "pc11",
+}
+
+// pcRelocations are the kinds of relocations of PC-relative offsets by
+// the width of their field.
+var pcRelocations = map[int]RelocationKind{
+	9:  RelocPC9,
+	11: RelocPC11,
+}
+
+// String returns the name of the kind.
+func (k RelocationKind) String() string {
+	return relocationKinds[k]
+}
+
+// bits returns the width of the PC-relative offset the kind patches,
+// or 0 for a whole word.
+func (k RelocationKind) bits() int {
+	for bits, kind := range pcRelocations {
+		if kind == k {
+			return bits
+		}
+	}
+
+	return 0
+}
+
+// Relocation is a word referring to a symbol declared .EXTERNAL, whose
+// address is only known once the objects are linked.
+type Relocation struct {
+	// Address is the address of the word.
+	Address uint16
+
+	// Kind is the kind of field to patch.
+	Kind RelocationKind
+
+	// Symbol is the external symbol.
+	Symbol string
+
+	// Addend is the constant added to the address of the symbol.
+	Addend int
+}
+
+// global is a label declared .GLOBAL.
+type global struct {
+	// name is the label.
+	name string
+
+	// pos is where it was declared.
+	pos position
+}
+
+// declareExternal declares the operands of an .EXTERNAL directive as
+// symbols defined by other objects.
+func (a *assembler) declareExternal(s *statement) error {
+	if len(s.operands) == 0 {
+		return lineError(s.pos, ".EXTERNAL expects the names of symbols")
+	}
+
+	for i, op := range s.operands {
+		if !validLabel(op.text) {
+			return lineError(s.at(i), "invalid label %q", op.text)
+		}
+
+		_, label := a.labels[op.text]
+		_, constant := a.constants[op.text]
+
+		if label || constant {
+			return coded(lineError(s.at(i), "%s is defined by this program", op.text), "external")
+		}
+
+		a.externals[op.text] = true
+	}
+
+	return nil
+}
+
+// declareGlobal declares the operands of a .GLOBAL directive as labels
+// other objects may refer to. They are checked once every label is
+// known.
+func (a *assembler) declareGlobal(s *statement) error {
+	if len(s.operands) == 0 {
+		return lineError(s.pos, ".GLOBAL expects the names of labels")
+	}
+
+	for i, op := range s.operands {
+		if !validLabel(op.text) {
+			return lineError(s.at(i), "invalid label %q", op.text)
+		}
+
+		a.globals = append(a.globals, global{name: op.text, pos: s.at(i)})
+	}
+
+	return nil
+}
+
+// checkGlobals reports the globals that are not labels of the program.
+func (a *assembler) checkGlobals() {
+	for _, g := range a.globals {
+		if _, ok := a.labels[g.name]; !ok {
+			a.report(coded(lineError(g.pos, ".GLOBAL %s is not a label of the program", g.name), "undefined-symbol"))
+		}
+	}
+}
+
+// relocate records that the word at address refers to an external
+// symbol.
+func (a *assembler) relocate(address uint16, kind RelocationKind, v value) {
+	a.relocations = append(a.relocations, Relocation{
+		Address: address,
+		Kind:    kind,
+		Symbol:  v.external,
+		Addend:  v.n,
+	})
+}
+
+// ReadObject reads an object in .obj format: the origin followed by
+// the words, all big-endian.
+func ReadObject(r io.Reader) (*Object, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 2 || len(data)%2 != 0 {
+		return nil, fmt.Errorf("invalid object of %d bytes", len(data))
+	}
+
+	obj := &Object{Origin: uint16(data[0])<<8 | uint16(data[1])}
+
+	for i := 2; i < len(data); i += 2 {
+		obj.Words = append(obj.Words, uint16(data[i])<<8|uint16(data[i+1]))
+	}
+
+	obj.Segments = []Segment{{Origin: obj.Origin, Words: obj.Words}}
+
+	return obj, nil
+}
+
+// WriteRelocations writes the globals and relocations of the object,
+// the sidecar the linker reads next to it.
+func (o *Object) WriteRelocations(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "// Relocations\n")
+	fmt.Fprintf(bw, "// global\tAddress\tLabel\n")
+	fmt.Fprintf(bw, "// Kind\tAddress\tSymbol\tAddend\n")
+
+	names := make([]string, 0, len(o.Globals))
+	for name := range o.Globals {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(bw, "global\t%04X\t%s\n", o.Globals[name], name)
+	}
+
+	for _, r := range o.Relocations {
+		fmt.Fprintf(bw, "%s\t%04X\t%s\t%d\n", r.Kind, r.Address, r.Symbol, r.Addend)
+	}
+
+	return bw.Flush()
+}
+
+// ReadRelocations reads the globals and relocations of the object,
+// one per line with tab-separated fields:
+//
+//	// Relocations
+//	global	3000	MAIN
+//	pc11	3004	PRINT	0
+func (o *Object) ReadRelocations(r io.Reader) error {
+	kinds := map[string]RelocationKind{}
+	for kind, name := range relocationKinds {
+		kinds[name] = kind
+	}
+
+	if o.Globals == nil {
+		o.Globals = map[string]uint16{}
+	}
+
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 {
+			return fmt.Errorf("line %d: expected a kind, address and symbol", n)
+		}
+
+		address, err := strconv.ParseUint(fields[1], 16, 16)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid address %q", n, fields[1])
+		}
+
+		if fields[0] == "global" && len(fields) == 3 {
+			o.Globals[fields[2]] = uint16(address)
+			continue
+		}
+
+		kind, ok := kinds[fields[0]]
+		if !ok || len(fields) != 4 {
+			return fmt.Errorf("line %d: invalid relocation %q", n, line)
+		}
+
+		addend, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return fmt.Errorf("line %d: invalid addend %q", n, fields[3])
+		}
+
+		o.Relocations = append(o.Relocations, Relocation{
+			Address: uint16(address),
+			Kind:    kind,
+			Symbol:  fields[2],
+			Addend:  addend,
+		})
+	}
+
+	return scanner.Err()
+}
+
+// Link combines objects into one program, patching the words referring
+// to external symbols with the addresses of the globals of the other
+// objects. Their segments keep their origin and must not overlap, the
+// gaps between them are filled with zeros.
+func Link(objects ...*Object) (*Object, error) {
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("no objects to link")
+	}
+
+	linked := &Object{Globals: map[string]uint16{}}
+
+	for _, obj := range objects {
+		linked.Segments = append(linked.Segments, obj.Segments...)
+		linked.Assertions = append(linked.Assertions, obj.Assertions...)
+
+		for name, address := range obj.Globals {
+			if _, ok := linked.Globals[name]; ok {
+				return nil, fmt.Errorf("global %s is defined by more than one object", name)
+			}
+
+			linked.Globals[name] = address
+		}
+	}
+
+	origin, words, err := layout(linked.Segments)
+	if err != nil {
+		return nil, err
+	}
+
+	linked.Origin, linked.Words = origin, words
+
+	for _, obj := range objects {
+		for _, r := range obj.Relocations {
+			if err := linked.patch(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// the segments show the patched words.
+	for i, segment := range linked.Segments {
+		start := int(segment.Origin - origin)
+		linked.Segments[i].Words = linked.Words[start : start+len(segment.Words)]
+	}
+
+	return linked, nil
+}
+
+// patch resolves a relocation against the globals of a linked object.
+func (o *Object) patch(r Relocation) error {
+	address, ok := o.Globals[r.Symbol]
+	if !ok {
+		return fmt.Errorf("x%04X: undefined external symbol %s", r.Address, r.Symbol)
+	}
+
+	i := int(r.Address) - int(o.Origin)
+	if i < 0 || i >= len(o.Words) {
+		return fmt.Errorf("x%04X: relocation outside of its object", r.Address)
+	}
+
+	target := uint16(int(address) + r.Addend)
+
+	bits := r.Kind.bits()
+	if bits == 0 {
+		o.Words[i] = target
+		return nil
+	}
+
+	offset := int(target) - int(r.Address) - 1
+	if offset < -1<<(bits-1) || offset > 1<<(bits-1)-1 {
+		return fmt.Errorf("x%04X: %s at x%04X is too far away for a %d-bit offset", r.Address, r.Symbol, target, bits)
+	}
+
+	mask := uint16(1<<bits - 1)
+	o.Words[i] = o.Words[i]&^mask | uint16(offset)&mask
+
+	return nil
+}
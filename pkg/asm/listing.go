@@ -0,0 +1,39 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteListing writes the classic assembler listing of the object:
+// every source line along with the address, hex and binary form of the
+// words assembled from it. Lines assembling to several words continue
+// on the following rows.
+func (o *Object) WriteListing(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "Address  Hex   Binary            Line  Source\n")
+
+	for _, line := range o.Lines {
+		text := strings.TrimRight(line.Text, " \t\r")
+
+		if len(line.Words) == 0 {
+			fmt.Fprintf(bw, "%33s%4d  %s\n", "", line.Number, text)
+			continue
+		}
+
+		for i, word := range line.Words {
+			fmt.Fprintf(bw, "x%04X    %04X  %016b", line.Address+uint16(i), word, word)
+
+			if i == 0 {
+				fmt.Fprintf(bw, "  %4d  %s", line.Number, text)
+			}
+
+			fmt.Fprintln(bw)
+		}
+	}
+
+	return bw.Flush()
+}
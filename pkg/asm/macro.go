@@ -0,0 +1,178 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxExpansionDepth bounds nested macro expansions, so that a macro
+// expanding itself fails instead of looping forever.
+const maxExpansionDepth = 64
+
+// macro is a parameterized sequence of lines defined with .MACRO and
+// .ENDM:
+//
+//	.MACRO PUSH REG
+//	        ADD R6, R6, #-1
+//	        STR REG, R6, #0
+//	.ENDM
+//
+// Parameters are referenced by name in the body. Labels defined in the
+// body are local to each expansion.
+type macro struct {
+	// name is the name of the macro.
+	name string
+
+	// pos is where the macro is defined.
+	pos position
+
+	// params are the names of the parameters.
+	params []string
+
+	// body holds the tokens of the lines of the body.
+	body [][]token
+
+	// locals are the labels defined in the body.
+	locals map[string]bool
+}
+
+// defineMacro starts the definition of a macro, whose body follows
+// until .ENDM.
+func (a *assembler) defineMacro(s *statement) error {
+	if len(s.operands) == 0 {
+		return lineError(s.pos, ".MACRO expects a name")
+	}
+
+	m := &macro{
+		name:   s.operands[0].text,
+		pos:    s.pos,
+		locals: map[string]bool{},
+	}
+
+	if !validLabel(m.name) || a.isOp(m.name) {
+		return lineError(s.at(0), "invalid macro name %q", m.name)
+	}
+
+	for i, param := range s.operands[1:] {
+		if !validLabel(param.text) {
+			return lineError(s.at(i+1), "invalid macro parameter %q", param.text)
+		}
+
+		for _, other := range m.params {
+			if other == param.text {
+				return lineError(s.at(i+1), "duplicate macro parameter %s", param.text)
+			}
+		}
+
+		m.params = append(m.params, param.text)
+	}
+
+	a.defining = m
+	a.define(s.at(0), m.name, DefMacro, len(m.params))
+
+	return nil
+}
+
+// macroLine adds a line to the body of the macro being defined, or
+// completes the definition on .ENDM.
+func (a *assembler) macroLine(n int, tokens []token) error {
+	m := a.defining
+
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	switch strings.ToUpper(tokens[0].text) {
+	case ".MACRO":
+		return lineError(a.tokenPos(n, tokens[0]), ".MACRO inside the definition of %s", m.name)
+	case ".ENDM":
+		if len(tokens) != 1 {
+			return lineError(a.tokenPos(n, tokens[1]), ".ENDM expects no operands")
+		}
+
+		a.defining = nil
+		a.macros[m.name] = m
+
+		return nil
+	}
+
+	first := tokens[0]
+
+	if first.kind == tokWord && !a.isOp(first.text) && !m.isParam(first.text) && (len(tokens) == 1 || a.isOp(tokens[1].text)) {
+		label := strings.TrimSuffix(first.text, ":")
+
+		if !validLabel(label) {
+			return lineError(a.tokenPos(n, first), "invalid label %q", label)
+		}
+
+		m.locals[label] = true
+	}
+
+	m.body = append(m.body, tokens)
+
+	return nil
+}
+
+// isParam reports whether a word names a parameter of the macro.
+func (m *macro) isParam(word string) bool {
+	for _, param := range m.params {
+		if param == word {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expand assembles the body of a macro invoked by a statement,
+// substituting the arguments for the parameters.
+func (a *assembler) expand(m *macro, s *statement) error {
+	if len(s.operands) != len(m.params) {
+		return coded(lineError(s.pos, "macro %s expects %d arguments, got %d", m.name, len(m.params), len(s.operands)), "operand-count")
+	}
+
+	if a.expanding >= maxExpansionDepth {
+		return coded(lineError(s.pos, "macro %s expands too deeply", m.name), "macro")
+	}
+
+	a.expansions++
+	suffix := fmt.Sprintf(".%d", a.expansions)
+
+	args := map[string]token{}
+	for i, param := range m.params {
+		args[param] = s.operands[i]
+	}
+
+	a.expanding++
+	defer func() { a.expanding-- }()
+
+	for _, body := range m.body {
+		tokens := make([]token, len(body))
+
+		// errors in the body are reported at the invocation.
+		for i, t := range body {
+			tokens[i] = t
+			tokens[i].col = s.op.col
+
+			if t.kind != tokWord {
+				continue
+			}
+
+			if arg, ok := args[t.text]; ok {
+				tokens[i] = arg
+			} else if label, colon := strings.CutSuffix(t.text, ":"); m.locals[label] {
+				tokens[i].text = label + suffix
+
+				if colon {
+					tokens[i].text += ":"
+				}
+			}
+		}
+
+		if err := a.line(s.line, tokens); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
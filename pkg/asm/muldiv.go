@@ -0,0 +1,70 @@
+package asm
+
+import (
+	"lc3/pkg/opcodes"
+	"strings"
+)
+
+// mulDivInstructions are the instructions of the multiply and divide
+// extension.
+var mulDivInstructions = map[string]encoder{
+	"MUL": encodeMulDiv(opcodes.FNMUL),
+	"DIV": encodeMulDiv(opcodes.FNDIV),
+	"MOD": encodeMulDiv(opcodes.FNMOD),
+}
+
+// WithMulDiv assembles the MUL, DIV and MOD instructions of the
+// multiply and divide extension, which take a destination and two
+// source registers as in MUL R0, R1, R2, on the reserved opcode 1101:
+//
+//	1101 DR SR1 0 FF SR2
+//
+// with FF 00 for MUL, 01 for DIV and 10 for MOD. Programs using them
+// run on CPUs with the extension only.
+func WithMulDiv() Option {
+	return func(a *assembler) {
+		a.mulDiv = true
+	}
+}
+
+// lookup returns the encoder of a mnemonic, in any case, including the
+// instructions of the extensions enabled.
+func (a *assembler) lookup(mnemonic string) (encoder, bool) {
+	if a.mulDiv {
+		if e, ok := mulDivInstructions[strings.ToUpper(mnemonic)]; ok {
+			return e, true
+		}
+	}
+
+	if a.testAndSet {
+		if e, ok := testAndSetInstructions[strings.ToUpper(mnemonic)]; ok {
+			return e, true
+		}
+	}
+
+	return lookup(mnemonic)
+}
+
+// encodeMulDiv encodes an instruction of the multiply and divide
+// extension.
+func encodeMulDiv(fn uint16) encoder {
+	return func(a *assembler, s *statement) (uint16, error) {
+		if err := s.expect(3); err != nil {
+			return 0, err
+		}
+
+		dr, err := a.register(s, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		sr1, err := a.register(s, 1)
+		if err != nil {
+			return 0, err
+		}
+
+		sr2, err := a.register(s, 2)
+
+		return opcodes.OPRES<<12 | dr<<9 | sr1<<6 | fn<<3 | sr2, err
+	}
+}
@@ -0,0 +1,39 @@
+package asm
+
+import "lc3/pkg/opcodes"
+
+// testAndSetInstructions are the instructions of the test-and-set
+// extension.
+var testAndSetInstructions = map[string]encoder{
+	"TAS": encodeTestAndSet,
+}
+
+// WithTestAndSet assembles the TAS instruction of the test-and-set
+// extension, which takes a destination and a base register as in
+// TAS R0, R1, on the reserved opcode 1101:
+//
+//	1101 DR BaseR 1 00000
+//
+// Programs using it run on CPUs with the extension only.
+func WithTestAndSet() Option {
+	return func(a *assembler) {
+		a.testAndSet = true
+	}
+}
+
+// encodeTestAndSet encodes an instruction of the test-and-set
+// extension.
+func encodeTestAndSet(a *assembler, s *statement) (uint16, error) {
+	if err := s.expect(2); err != nil {
+		return 0, err
+	}
+
+	dr, err := a.register(s, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	base, err := a.register(s, 1)
+
+	return opcodes.OPRES<<12 | dr<<9 | base<<6 | 1<<5, err
+}
@@ -0,0 +1,53 @@
+package asm
+
+import (
+	"lc3/pkg/opcodes"
+	"strings"
+)
+
+// WithTrampolines assembles branches whose target is out of reach of
+// their 9-bit offset into trampolines instead of failing. A trampoline
+// loads the target into R7 and jumps through it, skipped by a branch
+// on the opposite condition for a conditional branch:
+//
+//	BRp   #3      ; for BRnz FAR
+//	LD    R7, #1
+//	JMP   R7
+//	.FILL FAR
+//
+// Far branches overwrite R7, which subroutines must save first.
+func WithTrampolines() Option {
+	return func(a *assembler) {
+		a.trampolines = true
+	}
+}
+
+// trampolineSize returns the number of words of the trampoline of a
+// branch.
+func trampolineSize(s *statement) int {
+	if flags, _ := branchFlags(strings.ToUpper(s.op.text)); flags == 0x7 {
+		return 3
+	}
+
+	return 4
+}
+
+// trampoline returns the words of the trampoline of a far branch.
+func (a *assembler) trampoline(s *statement) ([]uint16, error) {
+	v, err := a.expression(s, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var words []uint16
+
+	if flags, _ := branchFlags(strings.ToUpper(s.op.text)); flags != 0x7 {
+		words = append(words, opcodes.OPBR<<12|(^flags&0x7)<<9|3)
+	}
+
+	return append(words,
+		opcodes.OPLD<<12|7<<9|1,
+		opcodes.OPJMP<<12|7<<6,
+		uint16(v.n),
+	), nil
+}
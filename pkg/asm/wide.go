@@ -0,0 +1,75 @@
+package asm
+
+import "lc3/pkg/opcodes"
+
+// WithWideRegisters assembles R8 to R15 for the experimental mode of 16
+// registers, putting the prefix before an instruction using them:
+//
+//	1101 000000 011 DST
+//
+// whose bits D, S and T move its first, second and third register
+// operands, or the base register of JMP and JSRR, to R8 to R15.
+// Programs using them run on CPUs with the mode only.
+func WithWideRegisters() Option {
+	return func(a *assembler) {
+		a.wideRegisters = true
+	}
+}
+
+// parseWideRegister parses R8 to R15.
+func parseWideRegister(s string) (uint16, bool) {
+	if len(s) == 2 && (s[0] == 'R' || s[0] == 'r') && (s[1] == '8' || s[1] == '9') {
+		return uint16(s[1] - '0'), true
+	}
+
+	if len(s) == 3 && (s[0] == 'R' || s[0] == 'r') && s[1] == '1' && s[2] >= '0' && s[2] <= '5' {
+		return uint16(10 + s[2] - '0'), true
+	}
+
+	return 0, false
+}
+
+// usesWideRegisters reports whether an instruction names any of R8 to
+// R15, taking a prefix.
+func (a *assembler) usesWideRegisters(s *statement) bool {
+	if !a.wideRegisters {
+		return false
+	}
+
+	for _, op := range s.operands {
+		if _, ok := parseWideRegister(op.text); ok && op.kind == tokWord {
+			return true
+		}
+	}
+
+	return false
+}
+
+// instructionAddress returns the address of the word of an
+// instruction, after its prefix if it takes one.
+func (a *assembler) instructionAddress(s *statement) uint16 {
+	if a.usesWideRegisters(s) {
+		return s.address + 1
+	}
+
+	return s.address
+}
+
+// prefix returns the prefix of an instruction whose operands in
+// a.wideOperands name R8 to R15.
+func (a *assembler) prefix(word uint16) uint16 {
+	var bits uint16
+
+	for i := 0; i < 3; i++ {
+		if a.wideOperands&(1<<i) != 0 {
+			bits |= 1 << (2 - i)
+		}
+	}
+
+	// the only register of JMP and JSRR is their base register.
+	if op := word >> 12; op == opcodes.OPJMP || op == opcodes.OPJSR {
+		bits >>= 1
+	}
+
+	return opcodes.OPRES<<12 | 0x18 | bits
+}
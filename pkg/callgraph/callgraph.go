@@ -0,0 +1,165 @@
+// Package callgraph follows the subroutine calls of a running program
+// to count how often each subroutine is called, by whom, and how many
+// instructions it runs itself and together with its callees.
+package callgraph
+
+import (
+	"lc3/pkg/callstack"
+	"lc3/pkg/cpu"
+	"lc3/pkg/registers"
+	"sort"
+)
+
+// Function is a subroutine, or the program itself, and its costs.
+type Function struct {
+	// Entry is the address of the first instruction.
+	Entry uint16
+
+	// Calls is the number of times it was called, or entered for the
+	// program itself.
+	Calls uint64
+
+	// Self is the number of instructions it ran itself.
+	Self uint64
+
+	// Total is the number of instructions run while it was active,
+	// including those of its callees. Recursive calls are counted
+	// once.
+	Total uint64
+
+	// step is the last instruction counted in Total, so that recursive
+	// calls are not counted twice.
+	step uint64
+}
+
+// Edge is a caller calling a callee.
+type Edge struct {
+	// Caller is the entry of the calling function.
+	Caller uint16
+
+	// Callee is the entry of the called function.
+	Callee uint16
+
+	// Calls is the number of calls.
+	Calls uint64
+}
+
+// Graph is the call graph of a run.
+type Graph struct {
+	// functions are the functions that ran by entry.
+	functions map[uint16]*Function
+
+	// calls counts the calls between functions by caller and callee.
+	calls map[[2]uint16]uint64
+
+	// total is the number of instructions run.
+	total uint64
+}
+
+// New creates an empty call graph.
+func New() *Graph {
+	return &Graph{functions: map[uint16]*Function{}, calls: map[[2]uint16]uint64{}}
+}
+
+// Record follows the calls made by a program run by a CPU. The first
+// instruction it runs is the entry of the program.
+func (g *Graph) Record(c cpu.CPU) {
+	stack := callstack.New()
+
+	var (
+		root    *Function
+		started bool
+	)
+
+	c.OnInstruction(func(pc, instr uint16) {
+		if !started {
+			root, started = g.function(pc), true
+			root.Calls++
+		}
+
+		g.total++
+
+		// the instruction belongs to the innermost function, and counts
+		// for every active one.
+		current := root
+		g.count(root)
+
+		for i := 0; i < stack.Depth(); i++ {
+			current = g.function(stack.Frame(i).Target)
+			g.count(current)
+		}
+
+		current.Self++
+
+		depth := stack.Depth()
+		stack.Observe(pc, instr, c.Register(registers.RPC))
+
+		if stack.Depth() > depth {
+			callee := g.function(stack.Frame(depth).Target)
+			callee.Calls++
+			g.calls[[2]uint16{current.Entry, callee.Entry}]++
+		}
+	})
+}
+
+// function returns the function at an entry, adding it if it is new.
+func (g *Graph) function(entry uint16) *Function {
+	f, ok := g.functions[entry]
+	if !ok {
+		f = &Function{Entry: entry}
+		g.functions[entry] = f
+	}
+
+	return f
+}
+
+// count counts the current instruction in the total of a function.
+func (g *Graph) count(f *Function) {
+	if f.step != g.total {
+		f.step = g.total
+		f.Total++
+	}
+}
+
+// Total returns the number of instructions run.
+func (g *Graph) Total() uint64 {
+	return g.total
+}
+
+// Functions returns the functions that ran, the most expensive
+// including their callees first.
+func (g *Graph) Functions() []Function {
+	functions := make([]Function, 0, len(g.functions))
+	for _, f := range g.functions {
+		functions = append(functions, *f)
+	}
+
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].Total != functions[j].Total {
+			return functions[i].Total > functions[j].Total
+		}
+
+		return functions[i].Entry < functions[j].Entry
+	})
+
+	return functions
+}
+
+// Edges returns the calls between functions, ordered by caller and
+// callee.
+func (g *Graph) Edges() []Edge {
+	edges := make([]Edge, 0, len(g.calls))
+	for key, calls := range g.calls {
+		edges = append(edges, Edge{Caller: key[0], Callee: key[1], Calls: calls})
+	}
+
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Caller != edges[j].Caller {
+			return edges[i].Caller < edges[j].Caller
+		}
+
+		return edges[i].Callee < edges[j].Callee
+	})
+
+	return edges
+}
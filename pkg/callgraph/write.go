@@ -0,0 +1,112 @@
+package callgraph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// percent returns n as a percentage of total.
+func percent(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return 100 * float64(n) / float64(total)
+}
+
+// WriteText writes the functions with their calls and costs, the most
+// expensive first, followed by the calls between them. name names the
+// entry of a function.
+//
+//	Function  Calls  Self  Self%  Total  Total%
+//	MAIN          1    14  31.8%     44  100.0%
+//	PRINT         3    15  34.1%     24   54.5%
+//
+//	Caller  Callee  Calls
+//	MAIN    PRINT       3
+func (g *Graph) WriteText(w io.Writer, name func(address uint16) string) error {
+	rows := [][]string{{"Function", "Calls", "Self", "Self%", "Total", "Total%"}}
+
+	for _, f := range g.Functions() {
+		rows = append(rows, []string{
+			name(f.Entry),
+			strconv.FormatUint(f.Calls, 10),
+			strconv.FormatUint(f.Self, 10),
+			fmt.Sprintf("%.1f%%", percent(f.Self, g.total)),
+			strconv.FormatUint(f.Total, 10),
+			fmt.Sprintf("%.1f%%", percent(f.Total, g.total)),
+		})
+	}
+
+	bw := bufio.NewWriter(w)
+	writeTable(bw, rows, 1)
+
+	if edges := g.Edges(); len(edges) > 0 {
+		rows = [][]string{{"Caller", "Callee", "Calls"}}
+
+		for _, e := range edges {
+			rows = append(rows, []string{name(e.Caller), name(e.Callee), strconv.FormatUint(e.Calls, 10)})
+		}
+
+		fmt.Fprintln(bw)
+		writeTable(bw, rows, 2)
+	}
+
+	return bw.Flush()
+}
+
+// writeTable writes rows in columns two spaces apart, the first left
+// columns aligned left and the others, which hold numbers, aligned
+// right.
+func writeTable(w io.Writer, rows [][]string, left int) {
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+
+	for _, row := range rows {
+		for i, cell := range row {
+			switch {
+			case i == len(row)-1 && i < left:
+				fmt.Fprint(w, cell)
+			case i < left:
+				fmt.Fprintf(w, "%-*s  ", widths[i], cell)
+			case i == len(row)-1:
+				fmt.Fprintf(w, "%*s", widths[i], cell)
+			default:
+				fmt.Fprintf(w, "%*s  ", widths[i], cell)
+			}
+		}
+
+		fmt.Fprintln(w)
+	}
+}
+
+// WriteDOT writes the call graph in Graphviz DOT, one box per function
+// with its costs and one edge per caller and callee labelled with the
+// number of calls.
+func (g *Graph) WriteDOT(w io.Writer, name func(address uint16) string) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "digraph calls {\n")
+	fmt.Fprintf(bw, "\tnode [shape=box, fontname=monospace];\n")
+
+	for _, f := range g.Functions() {
+		label := fmt.Sprintf("%s\ncalls %d\nself %d (%.1f%%)\ntotal %d (%.1f%%)",
+			name(f.Entry), f.Calls, f.Self, percent(f.Self, g.total), f.Total, percent(f.Total, g.total))
+
+		fmt.Fprintf(bw, "\tf%04X [label=%s];\n", f.Entry, strconv.Quote(label))
+	}
+
+	for _, e := range g.Edges() {
+		fmt.Fprintf(bw, "\tf%04X -> f%04X [label=\"%d\"];\n", e.Caller, e.Callee, e.Calls)
+	}
+
+	fmt.Fprintf(bw, "}\n")
+
+	return bw.Flush()
+}
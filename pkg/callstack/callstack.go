@@ -0,0 +1,104 @@
+// Package callstack implements a shadow call stack, which follows
+// subroutine calls and returns as instructions execute so that the
+// chain of active subroutines can be shown at any point.
+package callstack
+
+import (
+	"lc3/pkg/disasm"
+	"lc3/pkg/opcodes"
+)
+
+// Frame is a single active subroutine call.
+type Frame struct {
+	// Call is the address of the calling instruction.
+	Call uint16 `json:"call"`
+
+	// Target is the entry point of the called subroutine.
+	Target uint16 `json:"target"`
+
+	// Return is the address execution resumes at on return.
+	Return uint16 `json:"return"`
+
+	// Trap is set when the call was made by a TRAP instruction.
+	Trap bool `json:"trap,omitempty"`
+}
+
+// Stack is a shadow call stack.
+type Stack struct {
+	// frames are the active calls, innermost last.
+	frames []Frame
+}
+
+// New creates an empty call stack.
+func New() *Stack {
+	return &Stack{}
+}
+
+// Observe updates the stack after an instruction has executed. pc is
+// the address of the instruction, instr the instruction itself and
+// next the program counter after it executed.
+func (s *Stack) Observe(pc, instr, next uint16) {
+	i := disasm.Decode(instr)
+
+	switch i.Opcode {
+	case opcodes.OPJSR:
+		s.push(Frame{Call: pc, Target: next, Return: pc + 1})
+	case opcodes.OPTRAP:
+		// service routines implemented by the VM complete in a
+		// single step and never show up on the stack.
+		if next != pc+1 {
+			s.push(Frame{Call: pc, Target: next, Return: pc + 1, Trap: true})
+		}
+	case opcodes.OPJMP:
+		if i.Name == "RET" {
+			s.pop(next)
+		}
+	case opcodes.OPRTI:
+		s.pop(next)
+	}
+}
+
+// push pushes a frame.
+func (s *Stack) push(f Frame) {
+	s.frames = append(s.frames, f)
+}
+
+// pop unwinds the stack to the frame returning to next. If no frame
+// returns there, only the innermost frame is popped.
+func (s *Stack) pop(next uint16) {
+	for i := len(s.frames) - 1; i >= 0; i-- {
+		if s.frames[i].Return == next {
+			s.frames = s.frames[:i]
+			return
+		}
+	}
+
+	if len(s.frames) > 0 {
+		s.frames = s.frames[:len(s.frames)-1]
+	}
+}
+
+// Frames returns a copy of the active frames, innermost last.
+func (s *Stack) Frames() []Frame {
+	return append([]Frame(nil), s.frames...)
+}
+
+// Frame returns the active frame at a depth, the outermost at 0.
+func (s *Stack) Frame(depth int) Frame {
+	return s.frames[depth]
+}
+
+// Depth returns the number of active frames.
+func (s *Stack) Depth() int {
+	return len(s.frames)
+}
+
+// Restore replaces the active frames, innermost last.
+func (s *Stack) Restore(frames []Frame) {
+	s.frames = append(s.frames[:0], frames...)
+}
+
+// Reset empties the stack.
+func (s *Stack) Reset() {
+	s.frames = s.frames[:0]
+}
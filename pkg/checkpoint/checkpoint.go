@@ -0,0 +1,191 @@
+// Package checkpoint snapshots a running program every so many
+// instructions and keeps the most recent snapshots, so that it can be
+// rewound to just before something went wrong or a long simulation can
+// be resumed after a crash.
+package checkpoint
+
+import (
+	"fmt"
+	"lc3/pkg/callstack"
+	"lc3/pkg/cpu"
+	"os"
+	"path/filepath"
+)
+
+// Checkpoint is the state of a program after a number of instructions.
+type Checkpoint struct {
+	// Instructions counts the instructions run before it was taken.
+	Instructions uint64
+
+	// State is the registers and memory of the machine, sharing the
+	// pages of memory unchanged with the checkpoints around it.
+	State *cpu.Clone
+
+	// Frames are the active subroutine calls, innermost last, if a
+	// call stack is followed.
+	Frames []callstack.Frame
+
+	// File is the snapshot file it was written to, if any.
+	File string
+}
+
+// Checkpointer takes checkpoints of a CPU.
+type Checkpointer struct {
+	// every is the number of instructions between checkpoints.
+	every uint64
+
+	// keep is the number of checkpoints kept.
+	keep int
+
+	// stack is the call stack saved with every checkpoint, if any.
+	stack *callstack.Stack
+
+	// dir is the directory checkpoints are written to, if any.
+	dir string
+
+	// cpu is the CPU being checkpointed.
+	cpu cpu.CPU
+
+	// instructions counts the instructions run.
+	instructions uint64
+
+	// checkpoints are the checkpoints kept, oldest first.
+	checkpoints []*Checkpoint
+
+	// err is the first error writing a checkpoint.
+	err error
+}
+
+// Option configures a checkpointer.
+type Option func(k *Checkpointer)
+
+// WithStack saves the frames of a call stack with every checkpoint and
+// restores them on rewinding.
+func WithStack(stack *callstack.Stack) Option {
+	return func(k *Checkpointer) {
+		k.stack = stack
+	}
+}
+
+// WithDirectory also writes every checkpoint to a snapshot file in dir,
+// named after the instructions run, and removes the files of those no
+// longer kept.
+func WithDirectory(dir string) Option {
+	return func(k *Checkpointer) {
+		k.dir = dir
+	}
+}
+
+// New creates a checkpointer taking a checkpoint every so many
+// instructions and keeping the last keep of them.
+func New(every uint64, keep int, opts ...Option) *Checkpointer {
+	k := &Checkpointer{every: every, keep: max(keep, 1)}
+
+	for _, opt := range opts {
+		opt(k)
+	}
+
+	return k
+}
+
+// Record takes checkpoints of a CPU as it runs. Hooks registered on the
+// CPU earlier, such as the one following the call stack, see every
+// instruction before its checkpoint is taken.
+func (k *Checkpointer) Record(c cpu.CPU) {
+	k.cpu = c
+
+	c.OnInstruction(func(pc, instr uint16) {
+		k.instructions++
+
+		if k.instructions%k.every == 0 {
+			k.take()
+		}
+	})
+}
+
+// take takes a checkpoint, dropping the oldest if there are too many.
+func (k *Checkpointer) take() {
+	cp := &Checkpoint{Instructions: k.instructions, State: k.cpu.Clone()}
+
+	if k.stack != nil {
+		cp.Frames = k.stack.Frames()
+	}
+
+	if k.dir != "" {
+		cp.File = filepath.Join(k.dir, fmt.Sprintf("%012d.snap", k.instructions))
+		k.write(cp)
+	}
+
+	k.checkpoints = append(k.checkpoints, cp)
+
+	if len(k.checkpoints) > k.keep {
+		if old := k.checkpoints[0]; old.File != "" {
+			k.fail(os.Remove(old.File))
+		}
+
+		k.checkpoints = k.checkpoints[1:]
+	}
+}
+
+// write writes a checkpoint to its file.
+func (k *Checkpointer) write(cp *Checkpoint) {
+	file, err := os.Create(cp.File)
+	if err != nil {
+		k.fail(err)
+		return
+	}
+
+	_, err = cp.State.Snapshot().WriteTo(file)
+	k.fail(err)
+	k.fail(file.Close())
+}
+
+// fail records the first error writing checkpoints.
+func (k *Checkpointer) fail(err error) {
+	if k.err == nil {
+		k.err = err
+	}
+}
+
+// Err returns the first error writing a checkpoint file, if any.
+func (k *Checkpointer) Err() error {
+	return k.err
+}
+
+// Instructions counts the instructions run.
+func (k *Checkpointer) Instructions() uint64 {
+	return k.instructions
+}
+
+// Checkpoints returns the checkpoints kept, oldest first.
+func (k *Checkpointer) Checkpoints() []*Checkpoint {
+	return append([]*Checkpoint(nil), k.checkpoints...)
+}
+
+// Rewind puts the CPU back into the state of the nth most recent
+// checkpoint, 1 being the latest, and forgets the checkpoints after it.
+func (k *Checkpointer) Rewind(n int) (*Checkpoint, error) {
+	if n < 1 || n > len(k.checkpoints) {
+		return nil, fmt.Errorf("there are %d checkpoints, cannot rewind to checkpoint %d", len(k.checkpoints), n)
+	}
+
+	i := len(k.checkpoints) - n
+	cp := k.checkpoints[i]
+
+	for _, later := range k.checkpoints[i+1:] {
+		if later.File != "" {
+			k.fail(os.Remove(later.File))
+		}
+	}
+
+	k.checkpoints = k.checkpoints[:i+1]
+	k.instructions = cp.Instructions
+
+	k.cpu.RestoreClone(cp.State)
+
+	if k.stack != nil {
+		k.stack.Restore(cp.Frames)
+	}
+
+	return cp, nil
+}
@@ -0,0 +1,277 @@
+// Package console contains helpers for sharing the host console
+// between several LC3 virtual machines running in one process.
+package console
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+// SelectPrefix starts an input line that selects which VM receives
+// subsequent input, e.g. "@vm2". Anything following the name on the
+// same line is delivered to the newly selected VM.
+const SelectPrefix = "@"
+
+// Mux multiplexes a single console between several VMs. Output from
+// each VM is tagged with the name of its port, or split to a writer
+// of its own, and input is routed to the currently selected port.
+type Mux struct {
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// out is the shared console output.
+	out io.Writer
+
+	// ports maps port names to ports.
+	ports map[string]*Port
+
+	// selected is the port that receives input.
+	selected *Port
+
+	// in is the shared console input.
+	in io.Reader
+
+	// start starts pumping input once the first port is attached.
+	start sync.Once
+
+	// ended is set once the shared input ended, so that ports
+	// attached later read its end straight away.
+	ended bool
+}
+
+// NewMux creates a new console multiplexer reading input from in and
+// writing tagged output to out. Once the first port is attached,
+// input is pumped in the background until in returns an error.
+func NewMux(in io.Reader, out io.Writer) *Mux {
+	return &Mux{
+		out:   out,
+		ports: map[string]*Port{},
+		in:    in,
+	}
+}
+
+// Attach creates a port whose output is written to the shared console,
+// each line prefixed with "[name] ". The first attached port is
+// selected for input.
+func (m *Mux) Attach(name string) *Port {
+	return m.attach(name, nil)
+}
+
+// AttachWriter creates a port whose output is split off to w rather
+// than being tagged onto the shared console.
+func (m *Mux) AttachWriter(name string, w io.Writer) *Port {
+	return m.attach(name, w)
+}
+
+// attach registers a new port.
+func (m *Mux) attach(name string, w io.Writer) *Port {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := &Port{
+		name:   name,
+		mux:    m,
+		out:    w,
+		closed: m.ended,
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	m.ports[name] = p
+
+	if m.selected == nil {
+		m.selected = p
+	}
+
+	if m.in != nil {
+		m.start.Do(func() {
+			go m.pump(m.in)
+		})
+	}
+
+	return p
+}
+
+// Select routes input to the port with the given name, it reports
+// whether such a port exists.
+func (m *Mux) Select(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.ports[name]
+	if ok {
+		m.selected = p
+	}
+
+	return ok
+}
+
+// pump reads input lines and delivers them to the selected port.
+func (m *Mux) pump(in io.Reader) {
+	reader := bufio.NewReader(in)
+
+	for {
+		line, err := reader.ReadString('\n')
+
+		if strings.HasPrefix(line, SelectPrefix) {
+			name, rest, _ := strings.Cut(strings.TrimPrefix(line, SelectPrefix), " ")
+			name = strings.TrimSpace(name)
+
+			if m.Select(name) {
+				line = rest
+			}
+		}
+
+		if line != "" {
+			m.mu.Lock()
+			selected := m.selected
+			m.mu.Unlock()
+
+			if selected != nil {
+				selected.deliver([]byte(line))
+			}
+		}
+
+		if err != nil {
+			m.mu.Lock()
+			m.ended = true
+			for _, p := range m.ports {
+				p.close()
+			}
+			m.mu.Unlock()
+
+			return
+		}
+	}
+}
+
+// write writes a complete line of tagged output to the console.
+func (m *Mux) write(name string, line []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := io.WriteString(m.out, "["+name+"] "+string(line))
+
+	return err
+}
+
+// Port is the console of a single VM attached to a Mux. It implements
+// io.Reader and io.Writer so it can be handed directly to the CPU.
+type Port struct {
+	// name is the name the port was attached under.
+	name string
+
+	// mux is the multiplexer the port belongs to.
+	mux *Mux
+
+	// out is the writer output is split to, when nil output is
+	// tagged onto the shared console.
+	out io.Writer
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// cond signals the arrival of input.
+	cond *sync.Cond
+
+	// pending is input waiting to be read.
+	pending bytes.Buffer
+
+	// closed is set once no more input will arrive.
+	closed bool
+
+	// line holds tagged output that has not been terminated by
+	// a newline yet.
+	line []byte
+}
+
+// Name returns the name of the port.
+func (p *Port) Name() string {
+	return p.name
+}
+
+// Read reads input routed to this port, blocking until some is
+// available. Any partial line of output is flushed first so that
+// prompts are visible.
+func (p *Port) Read(b []byte) (int, error) {
+	if err := p.Flush(); err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.pending.Len() == 0 && !p.closed {
+		p.cond.Wait()
+	}
+
+	if p.pending.Len() == 0 {
+		return 0, io.EOF
+	}
+
+	return p.pending.Read(b)
+}
+
+// Write writes output of the VM attached to this port.
+func (p *Port) Write(b []byte) (int, error) {
+	if p.out != nil {
+		return p.out.Write(b)
+	}
+
+	p.mu.Lock()
+	p.line = append(p.line, b...)
+
+	var lines [][]byte
+	for {
+		i := bytes.IndexByte(p.line, '\n')
+		if i < 0 {
+			break
+		}
+
+		lines = append(lines, p.line[:i+1])
+		p.line = p.line[i+1:]
+	}
+	p.mu.Unlock()
+
+	for _, line := range lines {
+		if err := p.mux.write(p.name, line); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// Flush writes any partial line of tagged output to the console.
+func (p *Port) Flush() error {
+	p.mu.Lock()
+	line := p.line
+	p.line = nil
+	p.mu.Unlock()
+
+	if len(line) == 0 {
+		return nil
+	}
+
+	return p.mux.write(p.name, line)
+}
+
+// deliver queues input for the port.
+func (p *Port) deliver(b []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending.Write(b)
+	p.cond.Broadcast()
+}
+
+// close marks the end of input for the port.
+func (p *Port) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.cond.Broadcast()
+}
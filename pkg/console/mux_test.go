@@ -0,0 +1,116 @@
+package console
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readAll reads a port to the end of its input, failing the test if
+// that takes too long.
+func readAll(t *testing.T, p *Port) string {
+	t.Helper()
+
+	done := make(chan string, 1)
+
+	go func() {
+		data, _ := io.ReadAll(p)
+		done <- string(data)
+	}()
+
+	select {
+	case data := <-done:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reading %s did not end", p.Name())
+		return ""
+	}
+}
+
+// TestOutput checks that output is tagged a line at a time, partial
+// lines being written on Flush, and that split ports write to their
+// own writer.
+func TestOutput(t *testing.T) {
+	var out, split strings.Builder
+
+	m := NewMux(nil, &out)
+	vm1 := m.Attach("vm1")
+	vm2 := m.Attach("vm2")
+	vm3 := m.AttachWriter("vm3", &split)
+
+	io.WriteString(vm1, "hel")
+	io.WriteString(vm2, "one\ntwo\n")
+	io.WriteString(vm3, "apart\n")
+	io.WriteString(vm1, "lo\nprompt: ")
+
+	if err := vm1.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "[vm2] one\n[vm2] two\n[vm1] hello\n[vm1] prompt: "
+
+	if out.String() != expected {
+		t.Errorf("output %q, expected %q", out.String(), expected)
+	}
+
+	if split.String() != "apart\n" {
+		t.Errorf("split output %q", split.String())
+	}
+}
+
+// TestInput checks that input lines go to the selected port, the first
+// attached until a line selects another.
+func TestInput(t *testing.T) {
+	in, keys := io.Pipe()
+
+	m := NewMux(in, io.Discard)
+	vm1 := m.Attach("vm1")
+	vm2 := m.Attach("vm2")
+
+	go func() {
+		io.WriteString(keys, "a\n@vm2 b\nc\n@nowhere\n@vm1\nd\n")
+		keys.Close()
+	}()
+
+	if got := readAll(t, vm2); got != "b\nc\n@nowhere\n" {
+		t.Errorf("vm2 read %q", got)
+	}
+
+	if got := readAll(t, vm1); got != "a\nd\n" {
+		t.Errorf("vm1 read %q", got)
+	}
+}
+
+// TestReadFlushes checks that reading a port writes its partial line,
+// so that prompts show before the program waits for input.
+func TestReadFlushes(t *testing.T) {
+	var out strings.Builder
+
+	m := NewMux(strings.NewReader("x\n"), &out)
+	vm1 := m.Attach("vm1")
+
+	io.WriteString(vm1, "> ")
+
+	if got := readAll(t, vm1); got != "x\n" {
+		t.Errorf("read %q", got)
+	}
+
+	if out.String() != "[vm1] > " {
+		t.Errorf("output %q", out.String())
+	}
+}
+
+// TestAttachAfterEOF checks that a port attached once the shared input
+// ended reads its end rather than blocking.
+func TestAttachAfterEOF(t *testing.T) {
+	m := NewMux(strings.NewReader("x\n"), io.Discard)
+
+	if got := readAll(t, m.Attach("vm1")); got != "x\n" {
+		t.Fatalf("vm1 read %q", got)
+	}
+
+	if got := readAll(t, m.Attach("late")); got != "" {
+		t.Errorf("late port read %q", got)
+	}
+}
@@ -0,0 +1,859 @@
+// Package control serves a line-delimited JSON-RPC 2.0 protocol
+// driving the VM, for editors and scripts that speak neither DAP nor
+// gRPC. Every line read is a request or notification, every line
+// written a response or notification:
+//
+//	-> {"jsonrpc": "2.0", "id": 1, "method": "load", "params": {"file": "hello.asm"}}
+//	<- {"jsonrpc": "2.0", "id": 1, "result": {"origin": 12288}}
+//	-> {"jsonrpc": "2.0", "id": 2, "method": "setBreakpoint", "params": {"address": "LOOP"}}
+//	<- {"jsonrpc": "2.0", "id": 2, "result": {"id": 1, "address": 12292, "location": "LOOP"}}
+//	-> {"jsonrpc": "2.0", "id": 3, "method": "run"}
+//	<- {"jsonrpc": "2.0", "method": "output", "params": {"text": "Hello"}}
+//	<- {"jsonrpc": "2.0", "method": "stopped", "params": {"reason": "breakpoint", "pc": 12292, "location": "LOOP", "breakpoint": 1}}
+//	<- {"jsonrpc": "2.0", "id": 3, "result": {"reason": "breakpoint", "pc": 12292, "location": "LOOP", "breakpoint": 1}}
+//
+// Requests are handled in order, but for interrupt and input, which
+// are handled as soon as they are read so that they reach a program
+// that is running or waiting for keys.
+//
+// ServeFramed serves the same protocol multiplexed with the input and
+// output of the program over a single stream, for hosts without a
+// terminal such as containers and pipelines.
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"lc3/pkg/debugger"
+	"lc3/pkg/devices"
+	"lc3/pkg/disasm"
+	"lc3/pkg/frame"
+	"lc3/pkg/registers"
+	"lc3/pkg/symbols"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The error codes of JSON-RPC 2.0.
+const (
+	parseError     = -32700
+	invalidRequest = -32600
+	methodNotFound = -32601
+	invalidParams  = -32602
+
+	// requestFailed is the error code of a request that failed.
+	requestFailed = -32000
+)
+
+// maxLine caps the bytes of a request.
+const maxLine = 16 << 20
+
+// stopReasons name the reasons a program stops.
+var stopReasons = map[debugger.StopReason]string{
+	debugger.StopStep:          "step",
+	debugger.StopBreakpoint:    "breakpoint",
+	debugger.StopHalt:          "halt",
+	debugger.StopError:         "error",
+	debugger.StopWatchpoint:    "watchpoint",
+	debugger.StopRegisterWatch: "watchpoint",
+	debugger.StopInterrupt:     "interrupt",
+	debugger.StopTrap:          "trap",
+}
+
+// errNotLoaded is answered to requests made before a program is
+// loaded.
+var errNotLoaded = errors.New("no program is loaded")
+
+// request is a request or notification sent by the client.
+type request struct {
+	// JSONRPC is the version of the protocol, 2.0.
+	JSONRPC string `json:"jsonrpc"`
+
+	// ID identifies a request, it is missing for a notification.
+	ID json.RawMessage `json:"id,omitempty"`
+
+	// Method names the request or notification.
+	Method string `json:"method"`
+
+	// Params are the method specific parameters.
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// response answers a request.
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *responseError  `json:"error,omitempty"`
+}
+
+// responseError is the error of a failed request.
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error returns the message of the error.
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// notification is a message sent by the server on its own accord.
+type notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+// server is a single connection to a client.
+type server struct {
+	// out writes responses and notifications.
+	out io.Writer
+
+	// framed is set if out is written in frames.
+	framed bool
+
+	// runFirst is set to run the program before the first request.
+	runFirst bool
+
+	// writeMu serializes writes to out.
+	writeMu sync.Mutex
+
+	// dbg controls the loaded program, nil until one is loaded.
+	dbg *debugger.Debugger
+
+	// dbgMu guards dbg, which is replaced while interrupt reads it.
+	dbgMu sync.Mutex
+
+	// keyboard is the console input, fed by input requests.
+	keyboard *keyboard
+}
+
+// Option configures a server.
+type Option func(s *server)
+
+// WithImage loads a memory image with the PC at origin before the
+// first request.
+func WithImage(image [cpu.MemoryMax]uint16, origin uint16) Option {
+	return func(s *server) {
+		s.load(image, origin, nil)
+	}
+}
+
+// WithRun runs the program before the first request, as a run
+// notification would.
+func WithRun() Option {
+	return func(s *server) {
+		s.runFirst = true
+	}
+}
+
+// Serve serves the protocol on a connection until the client sends
+// exit or closes it.
+func Serve(conn io.ReadWriter, opts ...Option) error {
+	in := bufio.NewScanner(conn)
+	in.Buffer(nil, maxLine)
+
+	s := newServer(conn, false, opts)
+
+	return s.serve(func() ([]byte, error) {
+		for in.Scan() {
+			if line := bytes.TrimSpace(in.Bytes()); len(line) > 0 {
+				return line, nil
+			}
+		}
+
+		if err := in.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, io.EOF
+	})
+}
+
+// ServeFramed serves the protocol on a connection multiplexing
+// channels with lc3/pkg/frame, until the client sends exit or closes
+// it. Requests and responses are sent on the control channel, keys on
+// the input channel, and the server sends the console output of the
+// program on the output channel and notifications on the event
+// channel.
+func ServeFramed(conn io.ReadWriter, opts ...Option) error {
+	s := newServer(conn, true, opts)
+
+	return s.serve(func() ([]byte, error) {
+		for {
+			channel, payload, err := frame.Read(conn)
+			if err != nil {
+				return nil, err
+			}
+
+			switch channel {
+			case frame.Control:
+				return payload, nil
+			case frame.Input:
+				s.keyboard.typeKeys(payload)
+			default:
+				return nil, fmt.Errorf("unexpected frame on channel %q", channel)
+			}
+		}
+	})
+}
+
+// newServer creates a server writing to out, in frames if framed.
+func newServer(out io.Writer, framed bool, opts []Option) *server {
+	s := &server{out: out, framed: framed, keyboard: newKeyboard()}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// serve handles the requests returned by next until it fails or the
+// client sends exit. Requests are handled in order by another
+// goroutine, so that interrupt and input reach a running program.
+func (s *server) serve(next func() ([]byte, error)) error {
+	requests := make(chan *request, 64)
+	done := make(chan struct{})
+
+	if s.runFirst {
+		requests <- &request{JSONRPC: "2.0", Method: "run"}
+	}
+
+	go func() {
+		defer close(done)
+
+		for req := range requests {
+			s.respond(req, s.handle(req))
+		}
+	}()
+
+	// once the input ends, the requests queued are finished with the
+	// keys typed, while exit interrupts them.
+	defer func() {
+		close(requests)
+		s.keyboard.close()
+		<-done
+	}()
+
+	for {
+		b, err := next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			s.interrupt()
+			return err
+		}
+
+		req := &request{}
+		if err := json.Unmarshal(b, req); err != nil {
+			s.write(response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &responseError{parseError, err.Error()}})
+			continue
+		}
+
+		switch req.Method {
+		case "exit":
+			s.interrupt()
+			return nil
+		case "interrupt", "input":
+			s.respond(req, s.handle(req))
+		default:
+			requests <- req
+		}
+	}
+}
+
+// result is the outcome of a request.
+type result struct {
+	// value is the result of a request that succeeded.
+	value any
+
+	// err is the error of a request that failed.
+	err error
+}
+
+// handle handles a request.
+func (s *server) handle(req *request) result {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return result{err: &responseError{invalidRequest, "not a JSON-RPC 2.0 request"}}
+	}
+
+	method, ok := methods[req.Method]
+	if !ok {
+		return result{err: &responseError{methodNotFound, fmt.Sprintf("unknown method %s", req.Method)}}
+	}
+
+	params := req.Params
+	if len(params) == 0 || string(params) == "null" {
+		params = json.RawMessage("{}")
+	}
+
+	value, err := method(s, params)
+
+	return result{value, err}
+}
+
+// respond answers a request with a result, but for notifications.
+func (s *server) respond(req *request, res result) {
+	if req.ID == nil {
+		return
+	}
+
+	resp := response{JSONRPC: "2.0", ID: req.ID, Result: res.value}
+
+	if res.err != nil {
+		var rerr *responseError
+		if !errors.As(res.err, &rerr) {
+			rerr = &responseError{requestFailed, res.err.Error()}
+		}
+
+		resp.Result, resp.Error = nil, rerr
+	} else if res.value == nil {
+		resp.Result = struct{}{}
+	}
+
+	s.write(resp)
+}
+
+// notify sends a notification, on the event channel if framed.
+func (s *server) notify(method string, params any) {
+	s.send(frame.Event, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// write writes a response.
+func (s *server) write(msg any) {
+	s.send(frame.Control, msg)
+}
+
+// send writes a message on a channel if framed, or on a line of its
+// own.
+func (s *server) send(channel byte, msg any) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.framed {
+		frame.Write(s.out, channel, b)
+	} else {
+		s.out.Write(append(b, '\n'))
+	}
+}
+
+// Write sends the console output of the program on the output channel
+// if framed, or as an output notification.
+func (s *server) Write(p []byte) (int, error) {
+	if !s.framed {
+		s.notify("output", map[string]string{"text": string(p)})
+		return len(p), nil
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := frame.Write(s.out, frame.Output, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// load loads an image on a fresh machine with the PC at origin.
+func (s *server) load(image [cpu.MemoryMax]uint16, origin uint16, table *symbols.Table) {
+	newCPU := func() cpu.CPU {
+		return cpu.NewCPU(
+			cpu.WithInput(s.keyboard),
+			cpu.WithOutput(s),
+			cpu.WithDevice(devices.NewTerminal(s)),
+			cpu.WithDevice(devices.NewRNG(time.Now().UnixNano())),
+			cpu.WithDevice(devices.NewRTC(time.Now)),
+		)
+	}
+
+	dbg := debugger.New(newCPU, image, nil, io.Discard)
+	dbg.CPU().SetRegister(registers.RPC, origin)
+	dbg.SetSymbols(table)
+
+	s.dbgMu.Lock()
+	s.dbg = dbg
+	s.dbgMu.Unlock()
+}
+
+// debugger returns the debugger of the loaded program.
+func (s *server) debugger() (*debugger.Debugger, error) {
+	s.dbgMu.Lock()
+	defer s.dbgMu.Unlock()
+
+	if s.dbg == nil {
+		return nil, errNotLoaded
+	}
+
+	return s.dbg, nil
+}
+
+// interrupt stops the program if it is running.
+func (s *server) interrupt() {
+	if dbg, err := s.debugger(); err == nil {
+		dbg.Interrupt()
+	}
+}
+
+// methods maps the names of the methods to their implementation, which
+// decodes its parameters and returns its result.
+var methods = map[string]func(s *server, params json.RawMessage) (any, error){
+	"load":            (*server).handleLoad,
+	"run":             (*server).handleRun,
+	"step":            (*server).handleStep,
+	"interrupt":       (*server).handleInterrupt,
+	"input":           (*server).handleInput,
+	"setBreakpoint":   (*server).handleSetBreakpoint,
+	"clearBreakpoint": (*server).handleClearBreakpoint,
+	"breakpoints":     (*server).handleBreakpoints,
+	"state":           (*server).handleState,
+	"readMemory":      (*server).handleReadMemory,
+	"writeMemory":     (*server).handleWriteMemory,
+	"setRegister":     (*server).handleSetRegister,
+	"evaluate":        (*server).handleEvaluate,
+}
+
+// decodeParams decodes the parameters of a request.
+func decodeParams(params json.RawMessage, v any) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &responseError{invalidParams, err.Error()}
+	}
+
+	return nil
+}
+
+// invalid returns an invalid parameters error.
+func invalid(format string, args ...any) error {
+	return &responseError{invalidParams, fmt.Sprintf(format, args...)}
+}
+
+// handleLoad loads a program given as source, an object or a file,
+// assembling files named .asm.
+func (s *server) handleLoad(params json.RawMessage) (any, error) {
+	var p struct {
+		Source *string `json:"source"`
+		Object []byte  `json:"object"`
+		File   string  `json:"file"`
+	}
+
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	var (
+		source io.Reader
+		object io.Reader
+		opts   []asm.Option
+	)
+
+	switch {
+	case p.Source != nil:
+		source = strings.NewReader(*p.Source)
+	case p.Object != nil:
+		object = bytes.NewReader(p.Object)
+	case p.File != "":
+		file, err := os.Open(p.File)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		if strings.EqualFold(filepath.Ext(p.File), ".asm") {
+			source = file
+			opts = append(opts, asm.WithIncludePath(filepath.Dir(p.File)))
+		} else {
+			object = file
+		}
+	default:
+		return nil, invalid("no source, object or file to load")
+	}
+
+	var (
+		obj   *asm.Object
+		table *symbols.Table
+		err   error
+	)
+
+	if source != nil {
+		var diagnostics asm.Diagnostics
+
+		obj, table, diagnostics, err = asm.Assemble(source, opts...)
+		if len(diagnostics) > 0 {
+			lines := make([]string, len(diagnostics))
+			for i, d := range diagnostics {
+				lines[i] = d.Error()
+			}
+
+			return map[string]any{"diagnostics": lines}, nil
+		}
+	} else {
+		obj, err = asm.ReadObject(object)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var image [cpu.MemoryMax]uint16
+	copy(image[obj.Origin:], obj.Words)
+
+	s.load(image, obj.Origin, table)
+
+	return map[string]any{"origin": obj.Origin}, nil
+}
+
+// handleRun runs the program until it stops.
+func (s *server) handleRun(params json.RawMessage) (any, error) {
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.stopped(dbg, dbg.Continue()), nil
+}
+
+// handleStep runs a number of instructions, one by default.
+func (s *server) handleStep(params json.RawMessage) (any, error) {
+	var p struct {
+		Count int `json:"count"`
+	}
+
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.stopped(dbg, dbg.Step(max(p.Count, 1))), nil
+}
+
+// stopped sends a stopped notification and returns its parameters,
+// where and why the program stopped.
+func (s *server) stopped(dbg *debugger.Debugger, stop debugger.Stop) map[string]any {
+	res := map[string]any{
+		"reason":   stopReasons[stop.Reason],
+		"pc":       stop.PC,
+		"location": dbg.Symbols().Format(stop.PC),
+	}
+
+	if stop.Breakpoint != nil {
+		res["breakpoint"] = stop.Breakpoint.ID
+	}
+
+	if stop.Err != nil {
+		res["error"] = stop.Err.Error()
+	}
+
+	s.notify("stopped", res)
+
+	return res
+}
+
+// handleInterrupt stops a running program.
+func (s *server) handleInterrupt(params json.RawMessage) (any, error) {
+	if _, err := s.debugger(); err != nil {
+		return nil, err
+	}
+
+	s.interrupt()
+
+	return nil, nil
+}
+
+// handleInput types text into the console.
+func (s *server) handleInput(params json.RawMessage) (any, error) {
+	var p struct {
+		Text string `json:"text"`
+	}
+
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	s.keyboard.typeKeys([]byte(p.Text))
+
+	return nil, nil
+}
+
+// address is an address given as a number or as an expression, such
+// as "x3000" or "LOOP+1".
+type address json.RawMessage
+
+// UnmarshalJSON keeps the address as given.
+func (a *address) UnmarshalJSON(b []byte) error {
+	*a = append((*a)[:0], b...)
+	return nil
+}
+
+// resolve returns the address, evaluating expressions against the
+// program.
+func (a address) resolve(dbg *debugger.Debugger) (uint16, error) {
+	if len(a) == 0 {
+		return 0, invalid("missing address")
+	}
+
+	var n int
+
+	var expression string
+	if err := json.Unmarshal(a, &expression); err == nil {
+		if n, err = dbg.Evaluate(expression); err != nil {
+			return 0, invalid("invalid address %q: %v", expression, err)
+		}
+	} else if err := json.Unmarshal(a, &n); err != nil {
+		return 0, invalid("invalid address %s", a)
+	}
+
+	if n < math.MinInt16 || n > math.MaxUint16 {
+		return 0, invalid("address %d out of range", n)
+	}
+
+	return uint16(n), nil
+}
+
+// breakpointResult describes a breakpoint.
+func breakpointResult(dbg *debugger.Debugger, bp *debugger.Breakpoint) map[string]any {
+	return map[string]any{
+		"id":       bp.ID,
+		"address":  bp.Address,
+		"location": dbg.Symbols().Format(bp.Address),
+	}
+}
+
+// handleSetBreakpoint sets a breakpoint at an address.
+func (s *server) handleSetBreakpoint(params json.RawMessage) (any, error) {
+	var p struct {
+		Address address `json:"address"`
+	}
+
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := p.Address.resolve(dbg)
+	if err != nil {
+		return nil, err
+	}
+
+	return breakpointResult(dbg, dbg.AddBreakpoint(addr)), nil
+}
+
+// handleClearBreakpoint deletes a breakpoint.
+func (s *server) handleClearBreakpoint(params json.RawMessage) (any, error) {
+	var p struct {
+		ID int `json:"id"`
+	}
+
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	if !dbg.DeleteBreakpoint(p.ID) {
+		return nil, invalid("no breakpoint %d", p.ID)
+	}
+
+	return nil, nil
+}
+
+// handleBreakpoints lists the breakpoints.
+func (s *server) handleBreakpoints(params json.RawMessage) (any, error) {
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	list := []map[string]any{}
+	for _, bp := range dbg.Breakpoints() {
+		list = append(list, breakpointResult(dbg, bp))
+	}
+
+	return list, nil
+}
+
+// handleState returns the registers and whether the program can be
+// resumed.
+func (s *server) handleState(params json.RawMessage) (any, error) {
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	c := dbg.CPU()
+
+	regs := map[string]uint16{}
+	for r := uint16(registers.RR0); r <= registers.RR7; r++ {
+		regs[fmt.Sprintf("R%d", r)] = c.Register(r)
+	}
+
+	pc := c.Register(registers.RPC)
+
+	return map[string]any{
+		"running":   dbg.Running(),
+		"pc":        pc,
+		"location":  dbg.Symbols().Format(pc),
+		"cc":        debugger.ConditionCodes(c.Register(registers.RCOND)),
+		"registers": regs,
+	}, nil
+}
+
+// handleReadMemory reads a range of memory, one word by default, with
+// the instructions the words decode to.
+func (s *server) handleReadMemory(params json.RawMessage) (any, error) {
+	var p struct {
+		Address address `json:"address"`
+		Count   int     `json:"count"`
+	}
+
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := p.Address.resolve(dbg)
+	if err != nil {
+		return nil, err
+	}
+
+	count := min(max(p.Count, 1), math.MaxUint16+1-int(start))
+
+	words := make([]map[string]any, count)
+	for i := range words {
+		addr := start + uint16(i)
+		word := dbg.CPU().PeekMemory(addr)
+
+		words[i] = map[string]any{
+			"address":     addr,
+			"word":        word,
+			"instruction": disasm.Format(addr, word),
+		}
+
+		if label, ok := dbg.Symbols().Name(addr); ok {
+			words[i]["label"] = label
+		}
+	}
+
+	return words, nil
+}
+
+// handleWriteMemory writes a run of words to memory.
+func (s *server) handleWriteMemory(params json.RawMessage) (any, error) {
+	var p struct {
+		Address address  `json:"address"`
+		Words   []uint16 `json:"words"`
+	}
+
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := p.Address.resolve(dbg)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(start)+len(p.Words) > math.MaxUint16+1 {
+		return nil, invalid("the words run past the end of memory")
+	}
+
+	for i, word := range p.Words {
+		dbg.CPU().PokeMemory(start+uint16(i), word)
+	}
+
+	return nil, nil
+}
+
+// handleSetRegister sets R0 to R7 or the PC.
+func (s *server) handleSetRegister(params json.RawMessage) (any, error) {
+	var p struct {
+		Name  string `json:"name"`
+		Value uint16 `json:"value"`
+	}
+
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	name := strings.ToUpper(p.Name)
+
+	var r uint16
+
+	switch {
+	case name == "PC":
+		r = registers.RPC
+	case len(name) == 2 && name[0] == 'R' && name[1] >= '0' && name[1] <= '7':
+		r = uint16(name[1] - '0')
+	default:
+		return nil, invalid("unknown register %q, expected R0 to R7 or PC", p.Name)
+	}
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	dbg.CPU().SetRegister(r, p.Value)
+
+	return nil, nil
+}
+
+// handleEvaluate evaluates an expression of the debugger, such as
+// "R0 + 1" or "LOOP".
+func (s *server) handleEvaluate(params json.RawMessage) (any, error) {
+	var p struct {
+		Expression string `json:"expression"`
+	}
+
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := dbg.Evaluate(p.Expression)
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+
+	return map[string]int{"value": v}, nil
+}
@@ -0,0 +1,81 @@
+package control
+
+import (
+	"io"
+	"sync"
+)
+
+// keyboard is the console input, fed by input requests.
+type keyboard struct {
+	// mu guards keys and closed.
+	mu sync.Mutex
+
+	// keys are the keys typed and not yet read.
+	keys []byte
+
+	// closed is set once no more keys will be typed.
+	closed bool
+
+	// typed is signalled when keys are typed or the keyboard closed.
+	typed chan struct{}
+}
+
+// newKeyboard creates a keyboard with no keys typed.
+func newKeyboard() *keyboard {
+	return &keyboard{typed: make(chan struct{}, 1)}
+}
+
+// Read waits for a key and reads it. Keys are read one at a time so
+// that none are lost to readers that buffer.
+func (k *keyboard) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for {
+		k.mu.Lock()
+
+		if len(k.keys) > 0 {
+			p[0] = k.keys[0]
+			k.keys = k.keys[1:]
+			k.mu.Unlock()
+
+			return 1, nil
+		}
+
+		closed := k.closed
+		k.mu.Unlock()
+
+		if closed {
+			return 0, io.EOF
+		}
+
+		<-k.typed
+	}
+}
+
+// typeKeys queues keys to be read.
+func (k *keyboard) typeKeys(keys []byte) {
+	k.mu.Lock()
+	k.keys = append(k.keys, keys...)
+	k.mu.Unlock()
+
+	k.signal()
+}
+
+// close ends the input once the keys typed are read.
+func (k *keyboard) close() {
+	k.mu.Lock()
+	k.closed = true
+	k.mu.Unlock()
+
+	k.signal()
+}
+
+// signal wakes a reader waiting for keys.
+func (k *keyboard) signal() {
+	select {
+	case k.typed <- struct{}{}:
+	default:
+	}
+}
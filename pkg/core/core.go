@@ -0,0 +1,192 @@
+// Package core writes and reads core files: the state of a machine at
+// the moment a program failed, with the faulting instruction, the error
+// and the subroutine calls that led there, for post-mortem debugging
+// without reproducing the failure.
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"lc3/pkg/callstack"
+	"lc3/pkg/cpu"
+	"lc3/pkg/disasm"
+	"lc3/pkg/registers"
+	"lc3/pkg/symbols"
+	"os"
+	"strings"
+)
+
+// maxFrames is the number of innermost calls kept in a core, so that
+// runaway recursion does not bloat it.
+const maxFrames = 64
+
+// Core is the state of a machine when its program failed.
+type Core struct {
+	// Error is the error the program failed with.
+	Error string `json:"error"`
+
+	// PC is the address of the faulting instruction.
+	PC uint16 `json:"pc"`
+
+	// Instruction is the faulting instruction.
+	Instruction uint16 `json:"instruction"`
+
+	// Instructions counts the instructions run before the fault.
+	Instructions uint64 `json:"instructions"`
+
+	// Frames are the innermost active subroutine calls, innermost last.
+	Frames []callstack.Frame `json:"frames"`
+
+	// State is the registers and memory of the machine.
+	State *cpu.Snapshot `json:"state"`
+}
+
+// Recorder follows a CPU so that a core can be taken when it fails.
+type Recorder struct {
+	// stack follows the subroutine calls.
+	stack *callstack.Stack
+
+	// next is the address of the instruction about to run.
+	next uint16
+
+	// instructions counts the instructions run.
+	instructions uint64
+}
+
+// NewRecorder creates a recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{stack: callstack.New()}
+}
+
+// Record follows the instructions run by a CPU.
+func (r *Recorder) Record(c cpu.CPU) {
+	r.next = c.Register(registers.RPC)
+
+	c.OnInstruction(func(pc, instr uint16) {
+		r.next = c.Register(registers.RPC)
+		r.instructions++
+		r.stack.Observe(pc, instr, r.next)
+	})
+}
+
+// Dump takes a core of a CPU that failed with err.
+func (r *Recorder) Dump(c cpu.CPU, err error) *Core {
+	frames := r.stack.Frames()
+	if len(frames) > maxFrames {
+		frames = frames[len(frames)-maxFrames:]
+	}
+
+	state := c.Snapshot()
+
+	// the failed instruction has already advanced the PC, leave it at
+	// the fault.
+	state.Registers[registers.RPC] = r.next
+
+	return &Core{
+		Error:        err.Error(),
+		PC:           r.next,
+		Instruction:  c.PeekMemory(r.next),
+		Instructions: r.instructions,
+		Frames:       frames,
+		State:        state,
+	}
+}
+
+// Write writes the core as JSON.
+func (c *Core) Write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(c)
+}
+
+// Load reads a core file.
+func Load(filename string) (*Core, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	c := &Core{}
+	if err := json.NewDecoder(file).Decode(c); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if c.State == nil {
+		return nil, fmt.Errorf("%s: core has no state", filename)
+	}
+
+	return c, nil
+}
+
+// WriteSummary writes what went wrong: the error, the faulting
+// instruction, the registers and the backtrace, naming addresses after
+// the labels of table.
+func (c *Core) WriteSummary(w io.Writer, table *symbols.Table) error {
+	regs := c.State.Registers
+
+	fmt.Fprintf(w, "%s after %d instructions\n", c.Error, c.Instructions)
+	fmt.Fprintf(w, "at x%04X in %s: x%04X %s\n\n", c.PC, table.Format(c.PC), c.Instruction, disasm.Format(c.PC, c.Instruction))
+
+	for r := uint16(registers.RR0); r <= registers.RR7; r += 4 {
+		fmt.Fprintf(w, "R%d x%04X  R%d x%04X  R%d x%04X  R%d x%04X\n", r, regs[r], r+1, regs[r+1], r+2, regs[r+2], r+3, regs[r+3])
+	}
+
+	fmt.Fprintf(w, "PC x%04X  COND %s\n\nbacktrace:\n", c.PC, flags(regs[registers.RCOND]))
+
+	pc := c.PC
+
+	for i := len(c.Frames) - 1; i >= 0; i-- {
+		fmt.Fprintf(w, "#%-3d x%04X in %s\n", len(c.Frames)-1-i, pc, table.Format(pc))
+
+		pc = c.Frames[i].Call
+	}
+
+	_, err := fmt.Fprintf(w, "#%-3d x%04X in %s\n", len(c.Frames), pc, table.Format(pc))
+
+	return err
+}
+
+// WriteMemory writes n words of memory from an address on, each with
+// its label, its disassembly and its character if it is printable.
+func (c *Core) WriteMemory(w io.Writer, address uint16, n int, table *symbols.Table) error {
+	for i := 0; i < n; i++ {
+		a := address + uint16(i)
+		word := c.State.Memory[a]
+
+		marker := "  "
+		if a == c.PC {
+			marker = "=>"
+		}
+
+		char := ""
+		if word >= 0x20 && word < 0x7F {
+			char = fmt.Sprintf("'%c'", word)
+		}
+
+		line := fmt.Sprintf("%s x%04X %-12s x%04X  %-24s %s", marker, a, table.Format(a), word, disasm.Format(a, word), char)
+
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flags names the condition codes set, as N, Z and P.
+func flags(cond uint16) string {
+	s := ""
+
+	for i, name := range "PZN" {
+		if cond&(1<<i) != 0 {
+			s = string(name) + s
+		}
+	}
+
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
@@ -0,0 +1,219 @@
+package cpu
+
+import (
+	"io"
+	"lc3/pkg/cflags"
+	"lc3/pkg/registers"
+	"testing"
+	"testing/quick"
+)
+
+// operands are the registers and immediate of a random instruction.
+type operands struct {
+	// DR, SR1 and SR2 are register numbers, taken modulo 8.
+	DR, SR1, SR2 uint8
+
+	// Imm5 is the immediate field, taken modulo 32.
+	Imm5 uint8
+
+	// A and B are the values of SR1 and SR2.
+	A, B uint16
+}
+
+// modelSext sign-extends the low bits of x independently of the CPU.
+func modelSext(x uint16, bits uint) uint16 {
+	v := int32(x & (1<<bits - 1))
+	if v >= 1<<(bits-1) {
+		v -= 1 << bits
+	}
+
+	return uint16(v)
+}
+
+// modelFlags returns the condition codes a result sets.
+func modelFlags(v uint16) uint16 {
+	switch {
+	case v == 0:
+		return cflags.FLZRO
+	case int16(v) < 0:
+		return cflags.FLNEG
+	default:
+		return cflags.FLPOS
+	}
+}
+
+// execute runs a single instruction word with the operands in their
+// registers and returns the value of DR and the condition codes.
+func execute(t *testing.T, word uint16, o operands) (uint16, uint16) {
+	t.Helper()
+
+	c := newFuzzCPU(nil)
+
+	c.memory[0x3000] = word
+	c.SetRegister(uint16(o.SR2%8), o.B)
+	c.SetRegister(uint16(o.SR1%8), o.A)
+
+	if err := c.Execute(); err != nil {
+		t.Fatalf("%04X: %v", word, err)
+	}
+
+	return c.Register(uint16(o.DR % 8)), c.Register(registers.RCOND)
+}
+
+// value returns the value a register holds before the instruction,
+// SR1 taking precedence as it is set last.
+func (o operands) value(r uint8) uint16 {
+	if r%8 == o.SR1%8 {
+		return o.A
+	}
+
+	if r%8 == o.SR2%8 {
+		return o.B
+	}
+
+	return 0
+}
+
+// encode builds an operate instruction with a register or immediate
+// second operand.
+func (o operands) encode(op uint16, immediate bool) uint16 {
+	word := op<<12 | uint16(o.DR%8)<<9 | uint16(o.SR1%8)<<6
+	if immediate {
+		return word | 1<<5 | uint16(o.Imm5%32)
+	}
+
+	return word | uint16(o.SR2%8)
+}
+
+// checkProperty runs a property with testing/quick, failing the test
+// with the counterexample found, if any.
+func checkProperty(t *testing.T, property any) {
+	t.Helper()
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 20000}); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestAddProperty checks ADD against the model in both modes.
+func TestAddProperty(t *testing.T) {
+	checkProperty(t, func(o operands, immediate bool) bool {
+		want := o.value(o.SR1) + o.value(o.SR2)
+		if immediate {
+			want = o.value(o.SR1) + modelSext(uint16(o.Imm5), 5)
+		}
+
+		got, cond := execute(t, o.encode(0b0001, immediate), o)
+
+		return got == want && cond == modelFlags(want)
+	})
+}
+
+// TestAndProperty checks AND against the model in both modes.
+func TestAndProperty(t *testing.T) {
+	checkProperty(t, func(o operands, immediate bool) bool {
+		want := o.value(o.SR1) & o.value(o.SR2)
+		if immediate {
+			want = o.value(o.SR1) & modelSext(uint16(o.Imm5), 5)
+		}
+
+		got, cond := execute(t, o.encode(0b0101, immediate), o)
+
+		return got == want && cond == modelFlags(want)
+	})
+}
+
+// TestNotProperty checks NOT against the model.
+func TestNotProperty(t *testing.T) {
+	checkProperty(t, func(o operands) bool {
+		want := ^o.value(o.SR1)
+
+		got, cond := execute(t, 0b1001<<12|uint16(o.DR%8)<<9|uint16(o.SR1%8)<<6|0x3F, o)
+
+		return got == want && cond == modelFlags(want)
+	})
+}
+
+// TestImmediateEdges checks every immediate against the values at the
+// edges of the signed and unsigned ranges, where sign extension and
+// overflow go wrong.
+func TestImmediateEdges(t *testing.T) {
+	edges := []uint16{0x0000, 0x0001, 0x000F, 0x0010, 0x7FFF, 0x8000, 0x8001, 0xFFF0, 0xFFFF}
+
+	for imm := uint8(0); imm < 32; imm++ {
+		for _, a := range edges {
+			o := operands{DR: 0, SR1: 1, SR2: 2, Imm5: imm, A: a}
+			ext := modelSext(uint16(imm), 5)
+
+			if got, cond := execute(t, o.encode(0b0001, true), o); got != a+ext || cond != modelFlags(a+ext) {
+				t.Errorf("ADD %04X + #%d = %04X with CC %03b, expected %04X with CC %03b", a, int16(ext), got, cond, a+ext, modelFlags(a+ext))
+			}
+
+			if got, cond := execute(t, o.encode(0b0101, true), o); got != a&ext || cond != modelFlags(a&ext) {
+				t.Errorf("AND %04X & #%d = %04X with CC %03b, expected %04X with CC %03b", a, int16(ext), got, cond, a&ext, modelFlags(a&ext))
+			}
+		}
+	}
+}
+
+// TestFlagsEveryValue checks the condition codes set by loading every
+// 16-bit value with ADD R0, R1, #0.
+func TestFlagsEveryValue(t *testing.T) {
+	for v := 0; v <= 0xFFFF; v++ {
+		o := operands{DR: 0, SR1: 1, SR2: 2, A: uint16(v)}
+
+		if got, cond := execute(t, o.encode(0b0001, true), o); got != uint16(v) || cond != modelFlags(uint16(v)) {
+			t.Fatalf("ADD of %04X gave %04X with CC %03b, expected CC %03b", v, got, cond, modelFlags(uint16(v)))
+		}
+	}
+}
+
+// TestMulDiv checks the multiply and divide extension against Go's
+// arithmetic on signed words.
+func TestMulDiv(t *testing.T) {
+	for _, tc := range []struct {
+		word     uint16
+		a, b     uint16
+		expected uint16
+	}{
+		{0xD042, 7, 6, 42},               // MUL R0, R1, R2
+		{0xD042, 0xFFFD, 5, 0xFFF1},      // -3 * 5
+		{0xD042, 300, 300, 0x5F90},       // 90000 wraps
+		{0xD04A, 0xFFF9, 2, 0xFFFD},      // DIV: -7 / 2 is -3
+		{0xD04A, 0x8000, 0xFFFF, 0x8000}, // -32768 / -1 overflows
+		{0xD052, 0xFFF9, 2, 0xFFFF},      // MOD: -7 % 2 is -1
+		{0xD052, 7, 0xFFFE, 1},           // 7 % -2 is 1
+	} {
+		c := NewCPU(WithMulDiv(), WithOutput(io.Discard))
+
+		c.memory[0x3000] = tc.word
+		c.SetRegister(registers.RR1, tc.a)
+		c.SetRegister(registers.RR2, tc.b)
+
+		if err := c.Execute(); err != nil {
+			t.Fatalf("%04X: %v", tc.word, err)
+		}
+
+		if got := c.Register(registers.RR0); got != tc.expected {
+			t.Errorf("%04X with x%04X and x%04X = x%04X, expected x%04X", tc.word, tc.a, tc.b, got, tc.expected)
+		}
+
+		if got := c.Register(registers.RCOND); got != modelFlags(tc.expected) {
+			t.Errorf("%04X: condition codes %03b, expected %03b", tc.word, got, modelFlags(tc.expected))
+		}
+	}
+
+	c := NewCPU(WithMulDiv())
+	c.memory[0x3000] = 0xD04A
+
+	if err := c.Execute(); err != ErrDivideByZero {
+		t.Errorf("dividing by zero: %v, expected %v", err, ErrDivideByZero)
+	}
+
+	c = NewCPU()
+	c.memory[0x3000] = 0xD042
+
+	if err := c.Execute(); err == nil {
+		t.Error("MUL ran without the extension")
+	}
+}
@@ -0,0 +1,251 @@
+package cpu_test
+
+import (
+	"io"
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"lc3/pkg/workload"
+	"strings"
+	"testing"
+)
+
+// image assembles a benchmarked program into a memory image.
+func image(b *testing.B, name string) [cpu.MemoryMax]uint16 {
+	return assemble(b, workload.Sources[name])
+}
+
+// assemble assembles a program into a memory image.
+func assemble(tb testing.TB, source string) [cpu.MemoryMax]uint16 {
+	tb.Helper()
+
+	obj, _, diagnostics, err := asm.Assemble(strings.NewReader(source))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if len(diagnostics) > 0 {
+		tb.Fatal(diagnostics)
+	}
+
+	var memory [cpu.MemoryMax]uint16
+	copy(memory[obj.Origin:], obj.Words)
+
+	return memory
+}
+
+// newCPU creates a CPU with its console stubbed.
+func newCPU() cpu.CPU {
+	return cpu.NewCPU(cpu.WithInput(strings.NewReader("")), cpu.WithOutput(io.Discard))
+}
+
+// benchmarkInstructions executes b.N instructions of a program that
+// never halts, reporting the time per instruction.
+func benchmarkInstructions(b *testing.B, name string) {
+	c := newCPU()
+	c.Load(image(b, name))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := c.Execute(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkProgram runs a program to HALT b.N times, reporting the
+// instructions it runs per second.
+func benchmarkProgram(b *testing.B, name string) {
+	memory := image(b, name)
+
+	var instructions uint64
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c := newCPU()
+		c.Load(memory)
+
+		for !c.Halted() {
+			if err := c.Execute(); err != nil {
+				b.Fatal(err)
+			}
+
+			instructions++
+		}
+	}
+
+	b.ReportMetric(float64(instructions)/b.Elapsed().Seconds(), "instr/s")
+}
+
+// BenchmarkDispatch measures decoding and dispatching register
+// operations.
+func BenchmarkDispatch(b *testing.B) {
+	benchmarkInstructions(b, "dispatch")
+}
+
+// BenchmarkMemory measures loads and stores.
+func BenchmarkMemory(b *testing.B) {
+	benchmarkInstructions(b, "memory")
+}
+
+// BenchmarkTrap measures the OUT trap.
+func BenchmarkTrap(b *testing.B) {
+	benchmarkInstructions(b, "trap")
+}
+
+// BenchmarkSieve measures a sieve of Eratosthenes run to HALT.
+func BenchmarkSieve(b *testing.B) {
+	benchmarkProgram(b, "sieve")
+}
+
+// BenchmarkStringCopy measures copying a string run to HALT.
+func BenchmarkStringCopy(b *testing.B) {
+	benchmarkProgram(b, "strcpy")
+}
+
+// BenchmarkRun measures the sieve under Run, the loop the command
+// line uses.
+func BenchmarkRun(b *testing.B) {
+	benchmarkRun(b, "sieve")
+}
+
+// BenchmarkRunFast measures the sieve under Run in the fast mode.
+func BenchmarkRunFast(b *testing.B) {
+	benchmarkRun(b, "sieve", cpu.WithFastMode())
+}
+
+// BenchmarkRunJIT measures the sieve under Run a block at a time.
+func BenchmarkRunJIT(b *testing.B) {
+	benchmarkRun(b, "sieve", cpu.WithBlockJIT())
+}
+
+// BenchmarkRunFused measures the sieve under Run a block at a time,
+// with pairs of instructions fused.
+func BenchmarkRunFused(b *testing.B) {
+	benchmarkRun(b, "sieve", cpu.WithBlockJIT(), cpu.WithFusion())
+}
+
+// BenchmarkRunStringCopy measures copying a string under Run.
+func BenchmarkRunStringCopy(b *testing.B) {
+	benchmarkRun(b, "strcpy")
+}
+
+// BenchmarkRunStringCopyJIT measures copying a string under Run a
+// block at a time.
+func BenchmarkRunStringCopyJIT(b *testing.B) {
+	benchmarkRun(b, "strcpy", cpu.WithBlockJIT())
+}
+
+// BenchmarkRunStringCopyFused measures copying a string under Run a
+// block at a time, with pairs of instructions fused.
+func BenchmarkRunStringCopyFused(b *testing.B) {
+	benchmarkRun(b, "strcpy", cpu.WithBlockJIT(), cpu.WithFusion())
+}
+
+// BenchmarkRunReused measures the sieve under Run on one CPU restored
+// between runs, whose runs should not allocate.
+func BenchmarkRunReused(b *testing.B) {
+	memory := image(b, "sieve")
+	c := newCPU()
+	reset := c.Snapshot()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c.Restore(reset)
+
+		if err := c.Run(memory); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkRun runs a program under Run b.N times.
+func benchmarkRun(b *testing.B, name string, opts ...cpu.Option) {
+	memory := image(b, name)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c := cpu.NewCPU(append(opts, cpu.WithInput(strings.NewReader("")), cpu.WithOutput(io.Discard))...)
+
+		if err := c.Run(memory); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestZeroAllocations checks that executing instructions and running a
+// program to HALT on a CPU already created allocate nothing.
+func TestZeroAllocations(t *testing.T) {
+	for _, name := range []string{"dispatch", "memory", "trap"} {
+		c := newCPU()
+		c.Load(assemble(t, workload.Sources[name]))
+
+		allocs := testing.AllocsPerRun(1000, func() {
+			if err := c.Execute(); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("%s: %v allocations per instruction, want 0", name, allocs)
+		}
+	}
+
+	memory := assemble(t, workload.Sources["sieve"])
+	c := newCPU()
+	reset := c.Snapshot()
+
+	allocs := testing.AllocsPerRun(10, func() {
+		c.Restore(reset)
+
+		if err := c.Run(memory); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("run: %v allocations per run, want 0", allocs)
+	}
+}
+
+// BenchmarkSnapshot measures taking a snapshot of the sieve as it runs.
+func BenchmarkSnapshot(b *testing.B) {
+	benchmarkCheckpoint(b, func(c cpu.CPU) { c.Snapshot() })
+}
+
+// BenchmarkClone measures taking a clone of the sieve as it runs, which
+// copies the pages written to since the last.
+func BenchmarkClone(b *testing.B) {
+	benchmarkCheckpoint(b, func(c cpu.CPU) { c.Clone() })
+}
+
+// benchmarkCheckpoint runs a hundred instructions of the sieve between
+// checkpoints taken b.N times.
+func benchmarkCheckpoint(b *testing.B, take func(c cpu.CPU)) {
+	memory := image(b, "sieve")
+	c := newCPU()
+	reset := c.Snapshot()
+	c.Load(memory)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if c.Halted() {
+			c.Restore(reset)
+			c.Load(memory)
+		}
+
+		for j := 0; j < 100 && !c.Halted(); j++ {
+			if err := c.Execute(); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		take(c)
+	}
+}
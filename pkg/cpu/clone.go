@@ -0,0 +1,112 @@
+package cpu
+
+import "lc3/pkg/registers"
+
+// pageCount is the number of pages of memory, of PageSize words each.
+const pageCount = MemoryMax / PageSize
+
+// page is a page of memory.
+type page [PageSize]uint16
+
+// pages tracks memory a page at a time, so that clones share the pages
+// no one has written to since the last clone rather than copying all
+// of memory.
+type pages struct {
+	// last are the pages of the last clone taken or restored, which are
+	// never written to again, or nil before the first.
+	last *[pageCount]*page
+
+	// dirty marks the pages written to since.
+	dirty [pageCount]bool
+}
+
+// Clone is the state of a machine like a Snapshot, its memory held in
+// pages shared copy-on-write with the clones taken before and after
+// it, so that taking and restoring one costs the pages written to in
+// between rather than the whole of memory. The state of devices is not
+// part of it.
+type Clone struct {
+	// Registers are the values of the registers, indexed like
+	// registers.RR0 to registers.RCOND.
+	Registers [registers.RCOUNT]uint16
+
+	// Halted reports whether the program had halted.
+	Halted bool
+
+	// pages are the pages of memory.
+	pages *[pageCount]*page
+}
+
+// PeekMemory reads a word of the memory of the clone.
+func (cl *Clone) PeekMemory(address uint16) uint16 {
+	return cl.pages[address/PageSize][address%PageSize]
+}
+
+// Snapshot copies the clone into a snapshot, to be written to a file.
+func (cl *Clone) Snapshot() *Snapshot {
+	s := &Snapshot{Registers: cl.Registers, Halted: cl.Halted}
+
+	for i, p := range cl.pages {
+		copy(s.Memory[i*PageSize:], p[:])
+	}
+
+	return s
+}
+
+// touch marks the page of an address as written to.
+func (p *pages) touch(address uint16) {
+	p.dirty[address/PageSize] = true
+}
+
+// touchAll marks every page as written to, once all of memory is.
+func (p *pages) touchAll() {
+	for i := range p.dirty {
+		p.dirty[i] = true
+	}
+}
+
+// Clone captures the state of the CPU, copying the pages of memory
+// written to since the last clone and sharing the rest with it.
+func (c *cpu) Clone() *Clone {
+	p := c.pages
+	taken := new([pageCount]*page)
+
+	for i := range taken {
+		if p.last == nil || p.dirty[i] {
+			taken[i] = new(page)
+			copy(taken[i][:], c.memory[i*PageSize:])
+		} else {
+			taken[i] = p.last[i]
+		}
+
+		p.dirty[i] = false
+	}
+
+	p.last = taken
+
+	return &Clone{Registers: c.registers, Halted: c.halted, pages: taken}
+}
+
+// RestoreClone puts the CPU back into the state of a clone, copying
+// only the pages of memory that differ from the last clone or were
+// written to since.
+func (c *cpu) RestoreClone(cl *Clone) {
+	p := c.pages
+
+	for i, restored := range cl.pages {
+		if p.last == nil || p.dirty[i] || p.last[i] != restored {
+			copy(c.memory[i*PageSize:], restored[:])
+		}
+
+		p.dirty[i] = false
+	}
+
+	p.last = cl.pages
+
+	if c.jit != nil {
+		c.jit.flush()
+	}
+
+	c.registers = cl.Registers
+	c.halted = cl.Halted
+}
@@ -0,0 +1,63 @@
+package cpu
+
+import (
+	"io"
+	"lc3/pkg/registers"
+	"strings"
+	"testing"
+)
+
+// TestClone checks that clones share the pages no store touched and
+// restore the memory and registers they were taken with.
+func TestClone(t *testing.T) {
+	var image [MemoryMax]uint16
+	image[0x3000] = 0x1261 // ADD R1, R1, #1
+	image[0x3001] = 0x3201 // ST R1, #1
+	image[0x3002] = 0x0FFD // BRnzp #-3
+
+	c := NewCPU(WithInput(strings.NewReader("")), WithOutput(io.Discard))
+	c.Load(image)
+
+	first := c.Clone()
+
+	for i := 0; i < 6; i++ {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("at x%04X: %v", c.pc, err)
+		}
+	}
+
+	c.PokeMemory(0x4000, 7)
+
+	second := c.Clone()
+
+	for i, p := range second.pages {
+		shared := p == first.pages[i]
+		if touched := i == 0x3000/PageSize || i == 0x4000/PageSize; shared == touched {
+			t.Errorf("page %d shared %t", i, shared)
+		}
+	}
+
+	if got := second.PeekMemory(0x3003); got != 2 {
+		t.Errorf("second clone has %d at x3003, expected 2", got)
+	}
+
+	c.RestoreClone(first)
+
+	if got := c.Register(registers.RR1); got != 0 {
+		t.Errorf("R1 = %d after restoring, expected 0", got)
+	}
+
+	if got, pc := c.PeekMemory(0x3003), c.Register(registers.RPC); got != 0 || pc != 0x3000 {
+		t.Errorf("x3003 = %d and PC = x%04X after restoring, expected 0 and x3000", got, pc)
+	}
+
+	c.RestoreClone(second)
+
+	if got := c.PeekMemory(0x4000); got != 7 {
+		t.Errorf("x4000 = %d after restoring the second clone, expected 7", got)
+	}
+
+	if s := second.Snapshot(); s.Memory[0x3003] != 2 || s.Registers != second.Registers {
+		t.Error("snapshot of the clone differs from it")
+	}
+}
@@ -0,0 +1,338 @@
+package cpu
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"lc3/pkg/cflags"
+	"lc3/pkg/registers"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// conformanceCase is a single instruction run with the state around
+// it, read from testdata/conformance.txt.
+type conformanceCase struct {
+	// name names the case.
+	name string
+
+	// line is the line the case starts on.
+	line int
+
+	// pc is the address of the instruction.
+	pc uint16
+
+	// instr is the instruction word.
+	instr uint16
+
+	// registers are R0 to R7, then the PC and CC, before and after
+	// the instruction.
+	before, after [registers.RCOUNT]uint16
+
+	// memory are the words set before the instruction.
+	memory map[uint16]uint16
+
+	// writes are the words expected to be written.
+	writes map[uint16]uint16
+
+	// input is the console input.
+	input string
+
+	// output is the expected console output.
+	output string
+
+	// halted and failed expect the instruction to halt or to fail.
+	halted, failed bool
+
+	// expected marks the registers the case expects a value for.
+	expected [registers.RCOUNT]bool
+}
+
+// conditionCodes maps the letters of condition codes to the cflags.
+var conditionCodes = map[string]uint16{
+	"n": cflags.FLNEG,
+	"z": cflags.FLZRO,
+	"p": cflags.FLPOS,
+}
+
+// parseRegister parses a register named R0 to R7, PC or CC.
+func parseRegister(s string) (uint16, error) {
+	switch s {
+	case "PC":
+		return registers.RPC, nil
+	case "CC":
+		return registers.RCOND, nil
+	}
+
+	if len(s) == 2 && s[0] == 'R' && s[1] >= '0' && s[1] <= '7' {
+		return uint16(s[1] - '0'), nil
+	}
+
+	return 0, fmt.Errorf("unknown register %s", s)
+}
+
+// parseWord parses a word in hex as x1234, or condition codes as n, z
+// or p when r is CC.
+func parseWord(s string, r uint16) (uint16, error) {
+	if r == registers.RCOND {
+		if cond, ok := conditionCodes[s]; ok {
+			return cond, nil
+		}
+
+		return 0, fmt.Errorf("invalid condition codes %s", s)
+	}
+
+	if !strings.HasPrefix(s, "x") {
+		return 0, fmt.Errorf("invalid word %s", s)
+	}
+
+	n, err := strconv.ParseUint(s[1:], 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid word %s", s)
+	}
+
+	return uint16(n), nil
+}
+
+// parseMemory parses a word of memory and its value, as x3005 x1234.
+func parseMemory(fields []string) (uint16, uint16, error) {
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("expected an address and a value")
+	}
+
+	address, err := parseWord(fields[0], 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	value, err := parseWord(fields[1], 0)
+
+	return address, value, err
+}
+
+// parseAssignment parses a register and its value, as R1 x0001 or
+// CC p.
+func parseAssignment(fields []string) (uint16, uint16, error) {
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("expected a register and a value")
+	}
+
+	r, err := parseRegister(fields[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	value, err := parseWord(fields[1], r)
+
+	return r, value, err
+}
+
+// parseConformance parses the cases of a conformance suite.
+func parseConformance(filename string) ([]*conformanceCase, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var (
+		cases []*conformanceCase
+		c     *conformanceCase
+	)
+
+	scanner := bufio.NewScanner(file)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		directive, arg, _ := strings.Cut(text, " ")
+
+		if directive == "case" {
+			c = &conformanceCase{
+				name:   arg,
+				line:   line,
+				pc:     0x3000,
+				memory: map[uint16]uint16{},
+				writes: map[uint16]uint16{},
+			}
+			c.before[registers.RCOND] = cflags.FLZRO
+			cases = append(cases, c)
+
+			continue
+		}
+
+		if c == nil {
+			return nil, fmt.Errorf("line %d: %s outside a case", line, directive)
+		}
+
+		if err := c.parseLine(directive, arg); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+
+	return cases, scanner.Err()
+}
+
+// parseLine parses a line of a case.
+func (c *conformanceCase) parseLine(directive, arg string) error {
+	var err error
+
+	switch directive {
+	case "pc":
+		c.pc, err = parseWord(arg, 0)
+	case "instr":
+		c.instr, err = parseWord(arg, 0)
+	case "input":
+		c.input, err = strconv.Unquote(arg)
+	case "set":
+		r, value, err := parseAssignment(strings.Fields(arg))
+		if err != nil {
+			return err
+		}
+
+		if r == registers.RPC {
+			return fmt.Errorf("set the PC with pc")
+		}
+
+		c.before[r] = value
+	case "mem":
+		address, value, err := parseMemory(strings.Fields(arg))
+		if err != nil {
+			return err
+		}
+
+		c.memory[address] = value
+	case "expect":
+		fields := strings.Fields(arg)
+
+		switch {
+		case arg == "halted":
+			c.halted = true
+		case arg == "error":
+			c.failed = true
+		case fields[0] == "output":
+			c.output, err = strconv.Unquote(strings.TrimSpace(strings.TrimPrefix(arg, "output")))
+		case fields[0] == "mem":
+			address, value, err := parseMemory(fields[1:])
+			if err != nil {
+				return err
+			}
+
+			c.writes[address] = value
+		default:
+			r, value, err := parseAssignment(fields)
+			if err != nil {
+				return err
+			}
+
+			c.after[r] = value
+			c.expected[r] = true
+		}
+	default:
+		return fmt.Errorf("unknown directive %q", directive)
+	}
+
+	return err
+}
+
+// run runs the case and reports where the state it leaves differs from
+// the expected one.
+func (c *conformanceCase) run(t *testing.T) {
+	var out bytes.Buffer
+
+	cpu := NewCPU(WithInput(strings.NewReader(c.input)), WithOutput(&out))
+
+	for address, value := range c.memory {
+		cpu.memory[address] = value
+	}
+
+	cpu.memory[c.pc] = c.instr
+	cpu.registers = c.before
+	cpu.registers[registers.RPC] = c.pc
+
+	writes := map[uint16]uint16{}
+	cpu.OnMemoryAccess(func(access MemoryAccess) {
+		if access.Write {
+			writes[access.Address] = access.Value
+		}
+	})
+
+	err := cpu.Execute()
+
+	if c.failed {
+		if err == nil {
+			t.Errorf("line %d: expected an error", c.line)
+		}
+
+		return
+	}
+
+	if err != nil {
+		t.Fatalf("line %d: %v", c.line, err)
+	}
+
+	want := c.before
+	want[registers.RPC] = c.pc + 1
+
+	for r, expected := range c.expected {
+		if expected {
+			want[r] = c.after[r]
+		}
+	}
+
+	for r, value := range cpu.registers {
+		if value != want[r] {
+			t.Errorf("line %d: %s is %04X, expected %04X", c.line, registerName(uint16(r)), value, want[r])
+		}
+	}
+
+	for address, value := range c.writes {
+		if got, ok := writes[address]; !ok || got != value {
+			t.Errorf("line %d: expected %04X written to x%04X", c.line, value, address)
+		}
+	}
+
+	for address, value := range writes {
+		if _, ok := c.writes[address]; !ok {
+			t.Errorf("line %d: unexpected write of %04X to x%04X", c.line, value, address)
+		}
+	}
+
+	if got := out.String(); got != c.output {
+		t.Errorf("line %d: output %q, expected %q", c.line, got, c.output)
+	}
+
+	if cpu.Halted() != c.halted {
+		t.Errorf("line %d: halted is %t, expected %t", c.line, cpu.Halted(), c.halted)
+	}
+}
+
+// registerName names a register as in the suite.
+func registerName(r uint16) string {
+	switch r {
+	case registers.RPC:
+		return "PC"
+	case registers.RCOND:
+		return "CC"
+	}
+
+	return fmt.Sprintf("R%d", r)
+}
+
+// TestConformance runs the instruction conformance suite.
+func TestConformance(t *testing.T) {
+	cases, err := parseConformance("testdata/conformance.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, c.run)
+	}
+}
@@ -0,0 +1,36 @@
+package cpu
+
+// flushAge is the number of instructions output is held back for
+// before it is written, should no input, HALT or full buffer write it
+// first.
+const flushAge = 100_000
+
+// flushOutput writes the console output held back.
+func (c *cpu) flushOutput() error {
+	c.outputAge = 0
+
+	return c.out.Flush()
+}
+
+// ageOutput counts the instructions run while output is held back,
+// writing it once it gets too old. An error writing it stays with the
+// writer until the next flush reports it.
+func (c *cpu) ageOutput() {
+	if c.out.Buffered() == 0 {
+		return
+	}
+
+	if c.outputAge++; c.outputAge >= flushAge {
+		c.flushOutput()
+	}
+}
+
+// finish writes the output held back once a run ends, returning the
+// error the run ended with first.
+func (c *cpu) finish(err error) error {
+	if flushErr := c.flushOutput(); err == nil {
+		err = flushErr
+	}
+
+	return err
+}
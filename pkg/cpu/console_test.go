@@ -0,0 +1,138 @@
+package cpu
+
+import (
+	"lc3/pkg/devices"
+	"lc3/pkg/registers"
+	"lc3/pkg/term"
+	"strings"
+	"testing"
+)
+
+// TestConsoleReadAhead checks that characters read ahead of GETC, IN
+// and the keyboard are kept for the next read.
+func TestConsoleReadAhead(t *testing.T) {
+	var out strings.Builder
+
+	c := NewCPU(WithInput(strings.NewReader("abc")), WithOutput(&out))
+
+	c.memory[0x3000] = 0xF020 // GETC
+	c.memory[0x3001] = 0x1220 // ADD R1, R0, #0
+	c.memory[0x3002] = 0xF023 // IN
+	c.memory[0x3003] = 0xA401 // LDI R2, KBSR
+	c.memory[0x3004] = 0xF025 // HALT
+	c.memory[0x3005] = registers.MRKBSR
+
+	for !c.Halted() {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("at x%04X: %v", c.pc, err)
+		}
+	}
+
+	if r1, r0 := c.Register(registers.RR1), c.Register(registers.RR0); r1 != 'a' || r0 != 'b' {
+		t.Errorf("GETC read %q and IN %q, expected 'a' and 'b'", r1, r0)
+	}
+
+	if got := c.PeekMemory(registers.MRKBDR); got != 'c' {
+		t.Errorf("keyboard read %q, expected 'c'", got)
+	}
+
+	if out.String() != "Enter a character: b" {
+		t.Errorf("output %q", out.String())
+	}
+}
+
+// countingWriter counts the writes made to it.
+type countingWriter struct {
+	strings.Builder
+
+	// writes counts the writes.
+	writes int
+}
+
+// Write counts a write.
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Builder.Write(p)
+}
+
+// TestBatchedOutput checks that Run writes the output of many OUT traps
+// at once, and writes what it held back before reading input.
+func TestBatchedOutput(t *testing.T) {
+	var image [MemoryMax]uint16
+	image[0x3000] = 0x2206 // LD R1, COUNT
+	image[0x3001] = 0x2006 // LD R0, CHAR
+	image[0x3002] = 0xF021 // OUT
+	image[0x3003] = 0x127F // ADD R1, R1, #-1
+	image[0x3004] = 0x03FD // BRp #-3
+	image[0x3005] = 0xF020 // GETC
+	image[0x3006] = 0xF025 // HALT
+	image[0x3007] = 100
+	image[0x3008] = 'x'
+
+	out := &countingWriter{}
+	in := &outputAtRead{out: out}
+
+	c := NewCPU(WithInput(in), WithOutput(out))
+
+	if err := c.Run(image); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.writes != 1 || out.Len() != 100 {
+		t.Errorf("%d bytes in %d writes, expected 100 in one", out.Len(), out.writes)
+	}
+
+	if in.written != 100 {
+		t.Errorf("GETC read with %d bytes written, expected all 100", in.written)
+	}
+}
+
+// outputAtRead records how much output was written when it is read.
+type outputAtRead struct {
+	// out is the output.
+	out *countingWriter
+
+	// written is the length of the output at the first read.
+	written int
+}
+
+// Read records the output written and reads an a.
+func (r *outputAtRead) Read(p []byte) (int, error) {
+	r.written = r.out.Len()
+	p[0] = 'a'
+
+	return 1, nil
+}
+
+// TestDeviceOutputOrder checks that output held back is written before
+// a device writing to the same console, here the terminal clearing the
+// screen between two characters.
+func TestDeviceOutputOrder(t *testing.T) {
+	var image [MemoryMax]uint16
+	image[0x3000] = 0x2007 // LD R0, FIRST
+	image[0x3001] = 0xF021 // OUT
+	image[0x3002] = 0x2206 // LD R1, CLEAR
+	image[0x3003] = 0xB206 // STI R1, TERMCMD
+	image[0x3004] = 0x2006 // LD R0, SECOND
+	image[0x3005] = 0xF021 // OUT
+	image[0x3006] = 0xF025 // HALT
+	image[0x3008] = 'b'
+	image[0x3009] = devices.TermClear
+	image[0x300A] = registers.MRTCCMD
+	image[0x300B] = 'a'
+
+	var out strings.Builder
+
+	c := NewCPU(WithOutput(&out), WithDevice(devices.NewTerminal(&out)))
+
+	if err := c.Run(image); err != nil {
+		t.Fatal(err)
+	}
+
+	var clear strings.Builder
+	term.Clear(&clear)
+
+	if expected := "b" + clear.String() + "a"; out.String() != expected {
+		t.Errorf("output %q, expected %q", out.String(), expected)
+	}
+}
@@ -7,6 +7,7 @@ package cpu
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"lc3/pkg/cflags"
 	"lc3/pkg/opcodes"
 	"lc3/pkg/registers"
@@ -15,6 +16,10 @@ import (
 	"os"
 )
 
+// MemoryMax is the number of words of memory the 16-bit addresses of
+// the LC-3 reach, and the size of the images it runs.
+const MemoryMax = math.MaxUint16 + 1
+
 // opTable specifies a table of operations and corresponding functions.
 var opTable = map[uint16]func(cpu *cpu) error{
 	opcodes.OPADD:  handleAdd,
@@ -43,6 +48,8 @@ var trapTable = map[uint16]func(cpu *cpu) error{
 	traps.IN:    handleIn,
 	traps.PUTSP: handlePutsP,
 	traps.HALT:  handleHalt,
+
+	traps.HOSTCALL: handleHostCall,
 }
 
 // CPU defines an interface that we expect for a
@@ -50,14 +57,74 @@ var trapTable = map[uint16]func(cpu *cpu) error{
 // we should be able to run the program!.
 type CPU interface {
 	// Run runs the CPU given an initial memory state.
-	Run(memory [math.MaxUint16 + 1]uint16) error
+	Run(memory [MemoryMax]uint16) error
+
+	// Load loads an initial memory state without running it.
+	Load(memory [MemoryMax]uint16)
+
+	// Execute executes a single instruction.
+	Execute() error
+
+	// Halted reports whether the program has halted.
+	Halted() bool
+
+	// Register returns the value of a register.
+	Register(r uint16) uint16
+
+	// SetRegister sets the value of a register.
+	SetRegister(r uint16, val uint16)
+
+	// ReadMemory reads a word of memory, including from devices.
+	ReadMemory(address uint16) (uint16, error)
+
+	// PeekMemory reads a word of memory without touching devices.
+	PeekMemory(address uint16) uint16
+
+	// WriteMemory writes a word of memory, including to devices.
+	WriteMemory(address uint16, val uint16) error
+
+	// PokeMemory writes a word of memory without touching devices.
+	PokeMemory(address uint16, val uint16)
+
+	// SetHalted sets whether the program has halted, clearing it
+	// allows a halted program to be resumed.
+	SetHalted(halted bool)
+
+	// OnMemoryAccess registers a hook that is called whenever an
+	// instruction reads or writes memory.
+	OnMemoryAccess(hook MemoryHook)
+
+	// OnInstruction registers a hook that is called after every
+	// executed instruction.
+	OnInstruction(hook InstructionHook)
+
+	// Devices returns the attached devices by the addresses of their
+	// memory-mapped registers.
+	Devices() map[uint16]Device
+
+	// Snapshot captures the registers, memory and halted state.
+	Snapshot() *Snapshot
+
+	// Restore puts the CPU back into the state of a snapshot.
+	Restore(s *Snapshot)
+
+	// Clone captures the registers, memory and halted state, sharing
+	// the pages of memory unchanged since the last clone.
+	Clone() *Clone
+
+	// RestoreClone puts the CPU back into the state of a clone.
+	RestoreClone(cl *Clone)
 }
 
 // cpu defines our default CPU implementation.
 type cpu struct {
 	// memory is the current place in memory
-	// that we are at.
-	memory [math.MaxUint16 + 1]uint16
+	// that we are at, shared by the cores of a machine.
+	memory *[MemoryMax]uint16
+
+	// pages tracks the pages of memory written to since the last
+	// clone, shared like memory.
+	pages *pages
 
 	// registers denotes the current workbench state
 	// of the CPU.
@@ -71,16 +138,145 @@ type cpu struct {
 	// executing on the CPU.
 	instr uint16
 
-	// cancel cancels the execution of the CPU.
-	cancel func()
+	// running is set while the CPU runs, HALT clearing it to stop
+	// the loop.
+	running bool
+
+	// halted is set once the program has halted.
+	halted bool
+
+	// pc is the address of the instruction being executed.
+	pc uint16
+
+	// memoryHooks are called on every memory access made by an
+	// instruction.
+	memoryHooks []MemoryHook
+
+	// instructionHooks are called after every executed instruction.
+	instructionHooks []InstructionHook
+
+	// devices maps memory-mapped register addresses to the
+	// device that owns them.
+	devices map[uint16]Device
+
+	// mapped marks the addresses of devices, so that reads and
+	// writes of the rest of memory index it without a lookup.
+	mapped deviceMap
+
+	// tickers are the devices that are ticked after every
+	// instruction.
+	tickers []Ticker
+
+	// hostCalls maps host-call numbers to the functions
+	// registered by the embedder.
+	hostCalls map[uint16]HostFunc
+
+	// customOps maps opcodes to the handlers registered by the
+	// embedder, which take precedence over the built-in ones.
+	customOps map[uint16]OpcodeFunc
+
+	// traps maps trap vectors to the handlers registered by the
+	// embedder, which take precedence over the built-in ones.
+	traps map[uint16]TrapFunc
+
+	// input is the stream console input is read from.
+	input io.Reader
+
+	// output is the stream console output is written to.
+	output io.Writer
+
+	// in buffers the input across traps and keyboard reads, so that
+	// the characters read ahead are not lost.
+	in *bufio.Reader
+
+	// out holds back console output across instructions, written
+	// on input, HALT, a full buffer or once it gets old.
+	out *bufio.Writer
+
+	// outputAge counts the instructions run since output was held
+	// back.
+	outputAge int
+
+	// utf8Output interprets characters above 0x7F written by
+	// OUT and PUTS as Unicode code points.
+	utf8Output bool
+
+	// isa is the instruction set the CPU runs.
+	isa ISA
+
+	// ops are the handlers of the opcodes of the instruction set.
+	ops map[uint16]func(cpu *cpu) error
+
+	// trapHandlers are the built-in handlers of the traps.
+	trapHandlers map[uint16]func(cpu *cpu) error
+
+	// wordSize is how far the PC moves per instruction, 1 on the
+	// word-addressed LC-3 and 2 on the byte-addressed LC-3b.
+	wordSize uint16
+
+	// mulDiv is set to run the multiply and divide extension.
+	mulDiv bool
+
+	// testAndSet is set to run the test-and-set extension.
+	testAndSet bool
+
+	// wideRegisters is set to run the mode of 16 registers.
+	wideRegisters bool
+
+	// high are R8 to R15 in the mode of 16 registers.
+	high [8]uint16
+
+	// code is the code space instructions are fetched from in the
+	// Harvard mode, memory being the data space.
+	code *[MemoryMax]uint16
+
+	// strict is set when the fixed bits of instructions are
+	// checked.
+	strict bool
+
+	// fast is set when Run may run the loop stripped of
+	// instrumentation.
+	fast bool
+
+	// fusion is set when the blocks of jit fuse pairs of
+	// instructions.
+	fusion bool
+
+	// jit holds the blocks translated when running a block at a
+	// time.
+	jit *jit
+
+	// prefix holds the bits D, S and T of the prefix before the
+	// instruction, with bit 3 set while one is pending.
+	prefix uint16
+
+	// memorySize is the number of words of memory installed, past
+	// which instructions may not reach but for the devices.
+	memorySize int
+
+	// mmu translates the addresses of instructions when set.
+	mmu *mmu
 }
 
 // NewCPU defines a new CPU.
-func NewCPU() *cpu {
+func NewCPU(opts ...Option) *cpu {
 	var regs [registers.RCOUNT]uint16
 
 	cpu := cpu{
+		memory:    new([MemoryMax]uint16),
+		pages:     &pages{},
 		registers: regs,
+		devices:   map[uint16]Device{},
+		hostCalls: map[uint16]HostFunc{},
+		traps:     map[uint16]TrapFunc{},
+		customOps: map[uint16]OpcodeFunc{},
+		input:     os.Stdin,
+		output:    os.Stdout,
+
+		ops:          opTable,
+		trapHandlers: trapTable,
+		wordSize:     1,
+		memorySize:   MemoryMax,
 	}
 
 	cpu.registers[registers.RCOND] = cflags.FLZRO
@@ -90,53 +286,193 @@ func NewCPU() *cpu {
 	// position for whatever reason.
 	cpu.registers[registers.RPC] = 0x3000
 
+	for _, opt := range opts {
+		opt(&cpu)
+	}
+
+	cpu.in = bufio.NewReader(cpu.input)
+	cpu.out = bufio.NewWriter(cpu.output)
+
+	cpu.installExtensions()
+	cpu.mapDevices()
+
 	return &cpu
 }
 
 // Run runs the CPU over the memory.
-func (c *cpu) Run(memory [math.MaxUint16 + 1]uint16) error {
-	c.memory = memory
+func (c *cpu) Run(memory [MemoryMax]uint16) error {
+	c.Load(memory)
+
+	if c.jitEnabled() {
+		return c.finish(c.runBlocks())
+	}
+
+	if c.fastEnabled() {
+		return c.finish(c.runFast())
+	}
+
+	return c.finish(c.loop())
+}
+
+// Load loads the memory without running it.
+func (c *cpu) Load(memory [MemoryMax]uint16) {
+	*c.memory = memory
+	c.pages.touchAll()
 
-	err := c.Loop(func(op uint16) error {
-		fn, ok := opTable[op]
+	if c.jit != nil {
+		c.jit.flush()
+	}
+
+	if c.code != nil {
+		*c.code = memory
+	}
+}
+
+// dispatch executes the current instruction.
+func (c *cpu) dispatch(op uint16) error {
+	fn, ok := c.ops[op]
+
+	if !ok {
+		return fmt.Errorf("unrecognized operation %d", op)
+	}
+
+	if c.prefix != 0 && !c.isPrefix() {
+		return c.dispatchWide(fn)
+	}
+
+	return fn(c)
+}
 
-		if !ok {
-			return fmt.Errorf("unrecognized operation %d", op)
+// Execute executes a single instruction.
+func (c *cpu) Execute() error {
+	err := c.Step()
+	if err == nil {
+		err = c.dispatch(c.op)
+	}
+
+	if err != nil {
+		if err := c.fault(err); err != nil {
+			return err
 		}
+	}
 
-		err := fn(c)
+	c.tick()
 
-		return err
-	})
+	if c.out.Buffered() > 0 {
+		return c.flushOutput()
+	}
+
+	return nil
+}
+
+// Halted reports whether the program has halted.
+func (c *cpu) Halted() bool {
+	return c.halted
+}
+
+// Register returns the value of a register.
+func (c *cpu) Register(r uint16) uint16 {
+	return c.registers[r]
+}
+
+// SetRegister sets the value of a register.
+func (c *cpu) SetRegister(r uint16, val uint16) {
+	c.registers[r] = val
+}
+
+// ReadMemory reads a word of memory, including from devices.
+func (c *cpu) ReadMemory(address uint16) (uint16, error) {
+	return c.readWord(address)
+}
+
+// PeekMemory reads a word of memory without touching devices.
+func (c *cpu) PeekMemory(address uint16) uint16 {
+	return c.memory[address]
+}
+
+// WriteMemory writes a word of memory, including to devices.
+func (c *cpu) WriteMemory(address uint16, val uint16) error {
+	return c.writeWord(address, val)
+}
+
+// PokeMemory writes a word of memory without touching devices.
+func (c *cpu) PokeMemory(address uint16, val uint16) {
+	c.memory[address] = val
+	c.written(address)
+}
+
+// Devices returns the attached devices by the addresses of their
+// memory-mapped registers.
+func (c *cpu) Devices() map[uint16]Device {
+	devices := make(map[uint16]Device, len(c.devices))
+	for address, device := range c.devices {
+		devices[address] = device
+	}
 
-	return err
+	return devices
+}
+
+// SetHalted sets whether the program has halted.
+func (c *cpu) SetHalted(halted bool) {
+	c.halted = halted
+}
+
+// tick ticks the devices and hooks that observe executed
+// instructions.
+func (c *cpu) tick() {
+	for _, ticker := range c.tickers {
+		ticker.Tick()
+	}
+
+	if len(c.instructionHooks) > 0 {
+		c.notifyInstructionHooks()
+	}
+
+	c.ageOutput()
 }
 
 // Loop takes in a continuation for the function
 // that could potentially return an error, and executes
-// it, breaking on either the nil or a call to the cancel
-// function.
+// it, breaking on either the nil or the program halting.
 func (c *cpu) Loop(loopCont func(op uint16) error) error {
-	running := true
+	c.running = true
+
+	for c.running {
+		err := c.Step()
+		if err == nil {
+			err = loopCont(c.op)
+		}
 
-	cancel := func() {
-		running = false
+		if err != nil {
+			if err := c.fault(err); err != nil {
+				return c.finish(err)
+			}
+		}
+
+		c.tick()
 	}
 
-	c.cancel = cancel
+	return c.finish(nil)
+}
 
-	exec := 0
+// loop is Loop dispatching the instructions, without the closure of a
+// continuation, so that a run allocates nothing.
+func (c *cpu) loop() error {
+	c.running = true
 
-	for running {
-		if err := c.Step(); err != nil {
-			return err
+	for c.running {
+		err := c.Step()
+		if err == nil {
+			err = c.dispatch(c.op)
 		}
 
-		if err := loopCont(c.op); err != nil {
-			return err
+		if err != nil {
+			if err := c.fault(err); err != nil {
+				return err
+			}
 		}
 
-		exec++
+		c.tick()
 	}
 
 	return nil
@@ -144,8 +480,19 @@ func (c *cpu) Loop(loopCont func(op uint16) error) error {
 
 // Step steps the CPU along.
 func (c *cpu) Step() error {
+	c.pc = c.registers[registers.RPC]
+
+	if c.pc%c.wordSize != 0 {
+		return fmt.Errorf("unaligned instruction fetch at x%04X", c.pc)
+	}
+
+	address, err := c.translate(c.pc, false)
+	if err != nil {
+		return err
+	}
+
 	// read the memory location of the program counter.
-	instr, err := c.memoryRead(c.registers[registers.RPC])
+	instr, err := c.fetch(address)
 	if err != nil {
 		return err
 	}
@@ -162,15 +509,54 @@ func (c *cpu) Step() error {
 
 // incrProgramCounter increments the program counter.
 func (c *cpu) incrProgramCounter() {
-	c.registers[registers.RPC] += 1
+	c.registers[registers.RPC] += c.wordSize
 }
 
-// memoryRead reads a value from the current memory address.
+// memoryRead reads a value from the current memory address
+// on behalf of the executing instruction.
 func (c *cpu) memoryRead(address uint16) (uint16, error) {
+	physical, err := c.translate(address, false)
+	if err != nil {
+		return 0, err
+	}
+
+	val, err := c.readWord(physical)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(c.memoryHooks) > 0 {
+		c.notifyMemoryHooks(MemoryAccess{
+			PC:      c.pc,
+			Address: address,
+			Value:   val,
+		})
+	}
+
+	return val, nil
+}
+
+// readWord reads a value from memory or the device mapped
+// at the address.
+func (c *cpu) readWord(address uint16) (uint16, error) {
+	if !c.mapped.has(address) {
+		return c.memory[address], nil
+	}
+
+	if device, ok := c.devices[address]; ok {
+		return device.Read(address)
+	}
+
+	if err := c.installed(address); err != nil {
+		return 0, err
+	}
+
 	if address == registers.MRKBSR {
-		reader := bufio.NewReader(os.Stdin)
+		if err := c.flushOutput(); err != nil {
+			return 0, err
+		}
 
-		key, err := reader.ReadByte()
+		key, err := c.in.ReadByte()
 		if err != nil {
 			return 0, err
 		}
@@ -182,18 +568,80 @@ func (c *cpu) memoryRead(address uint16) (uint16, error) {
 			c.memory[registers.MRKBSR] = 0
 		}
 
+		c.pages.touch(registers.MRKBSR)
+
 	}
 
 	return c.memory[address], nil
 }
 
-// unable to write to a memory address.
+// memoryWrite writes a value to a memory address on behalf
+// of the executing instruction.
 func (c *cpu) memoryWrite(address uint16, val uint16) error {
+	physical, err := c.translate(address, true)
+	if err != nil {
+		return err
+	}
+
+	old := c.memory[physical]
+
+	if err := c.writeWord(physical, val); err != nil {
+		return err
+	}
+
+	if len(c.memoryHooks) > 0 {
+		c.notifyMemoryHooks(MemoryAccess{
+			PC:      c.pc,
+			Address: address,
+			Value:   val,
+			Old:     old,
+			Write:   true,
+		})
+	}
+
+	return nil
+}
+
+// writeWord writes a value to memory or the device mapped
+// at the address.
+func (c *cpu) writeWord(address uint16, val uint16) error {
+	if !c.mapped.has(address) {
+		c.memory[address] = val
+		c.written(address)
+
+		return nil
+	}
+
+	if device, ok := c.devices[address]; ok {
+		// devices such as the terminal write to the console
+		// themselves, after the output held back.
+		if err := c.flushOutput(); err != nil {
+			return err
+		}
+
+		return device.Write(address, val)
+	}
+
+	if err := c.installed(address); err != nil {
+		return err
+	}
+
 	c.memory[address] = val
+	c.written(address)
 
 	return nil
 }
 
+// written notes a store to an address, marking its page for the next
+// clone and dropping the blocks when it is part of one.
+func (c *cpu) written(address uint16) {
+	c.pages.touch(address)
+
+	if c.jit != nil && c.jit.translated[address] {
+		c.jit.flush()
+	}
+}
+
 // updateFlags updates the flags of a given register.
 func (c *cpu) updateFlags(r uint16) {
 	if c.registers[r] == 0 {
@@ -276,18 +724,20 @@ func handleJmp(cpu *cpu) error {
 
 // handleJsr handles the jump to subroutine opcode.
 func handleJumpSubroutine(cpu *cpu) error {
-	cpu.registers[registers.RR7] = cpu.registers[registers.RPC]
+	target := cpu.registers[registers.RPC] + signExtend(cpu.instr&0x7FF, 11)
 
 	bit11 := (cpu.instr >> 11) & 0x1
 
 	if bit11 == 0 {
+		// the base register is read before R7 is written, so that
+		// JSRR R7 jumps to the old R7.
 		baseR := (cpu.instr >> 6) & 0x7
-		cpu.registers[registers.RPC] = cpu.registers[baseR]
-	} else {
-		pcOffset := signExtend(cpu.instr&0x7FF, 11)
-		cpu.registers[registers.RPC] += pcOffset
+		target = cpu.registers[baseR]
 	}
 
+	cpu.registers[registers.RR7] = cpu.registers[registers.RPC]
+	cpu.registers[registers.RPC] = target
+
 	return nil
 }
 
@@ -400,7 +850,15 @@ func handleTrap(cpu *cpu) error {
 
 	trap := cpu.instr & 0xFF
 
-	handler, ok := trapTable[trap]
+	if fn, ok := cpu.traps[trap]; ok {
+		if err := cpu.flushOutput(); err != nil {
+			return err
+		}
+
+		return fn(cpu)
+	}
+
+	handler, ok := cpu.trapHandlers[trap]
 	if !ok {
 		return fmt.Errorf("unrecognized trap %x", trap)
 	}
@@ -410,9 +868,11 @@ func handleTrap(cpu *cpu) error {
 
 // handleGetC handles the GetC trap.
 func handleGetC(cpu *cpu) error {
-	reader := bufio.NewReader(os.Stdin)
+	if err := cpu.flushOutput(); err != nil {
+		return err
+	}
 
-	byt, err := reader.ReadByte()
+	byt, err := cpu.in.ReadByte()
 	if err != nil {
 		return err
 	}
@@ -425,7 +885,7 @@ func handleGetC(cpu *cpu) error {
 
 // handlePut handles the Puts trap.
 func handlePuts(cpu *cpu) error {
-	writer := bufio.NewWriter(os.Stdout)
+	writer := cpu.out
 
 	for addr := cpu.registers[registers.RR0]; ; addr++ {
 		char, err := cpu.memoryRead(addr)
@@ -437,42 +897,46 @@ func handlePuts(cpu *cpu) error {
 			break
 		}
 
-		err = writer.WriteByte(byte(char))
+		err = cpu.writeChar(writer, char)
 		if err != nil {
 			return err
 		}
 	}
 
-	return writer.Flush()
+	return nil
 }
 
 // handleOut handles the Out trap.
 func handleOut(cpu *cpu) error {
-	writer := bufio.NewWriter(os.Stdout)
-
-	elem := byte(cpu.registers[registers.RR0])
+	return cpu.writeChar(cpu.out, cpu.registers[registers.RR0])
+}
 
-	if err := writer.WriteByte(elem); err != nil {
+// writeChar writes a single character to the console, either as a
+// raw byte or, in UTF-8 mode, as a Unicode code point.
+func (c *cpu) writeChar(writer *bufio.Writer, char uint16) error {
+	if c.utf8Output && char > 0x7F {
+		_, err := writer.WriteRune(rune(char))
 		return err
 	}
 
-	return writer.Flush()
+	return writer.WriteByte(byte(char))
 }
 
 // handleIn handles the In trap.
 func handleIn(cpu *cpu) error {
-	fmt.Print("Enter a character: ")
-
-	reader := bufio.NewReader(os.Stdin)
+	// the prompt is shown before waiting for the key.
+	fmt.Fprint(cpu.out, "Enter a character: ")
 
-	writer := bufio.NewWriter(os.Stdin)
+	if err := cpu.flushOutput(); err != nil {
+		return err
+	}
 
-	byt, err := reader.ReadByte()
+	byt, err := cpu.in.ReadByte()
 	if err != nil {
 		return err
 	}
 
-	err = writer.WriteByte(byt)
+	err = cpu.out.WriteByte(byt)
 	if err != nil {
 		return err
 	}
@@ -481,12 +945,12 @@ func handleIn(cpu *cpu) error {
 
 	cpu.updateFlags(registers.RR0)
 
-	return writer.Flush()
+	return nil
 }
 
 // handlePutsP handles the PutsP trap.
 func handlePutsP(cpu *cpu) error {
-	writer := bufio.NewWriter(os.Stdout)
+	writer := cpu.out
 
 	for addr := cpu.registers[registers.RR0]; ; addr++ {
 		char, err := cpu.memoryRead(addr)
@@ -512,14 +976,16 @@ func handlePutsP(cpu *cpu) error {
 		}
 	}
 
-	return writer.Flush()
+	return nil
 }
 
 // handleHalt handles the Halt trap.
 func handleHalt(cpu *cpu) error {
-	cpu.cancel()
+	cpu.halted = true
 
-	return nil
+	cpu.running = false
+
+	return cpu.flushOutput()
 }
 
 // signExtend extends the sign of an unsigned int16
@@ -0,0 +1,152 @@
+package cpu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"lc3/pkg/cflags"
+	"lc3/pkg/registers"
+	"testing"
+)
+
+// fuzzSteps bounds the instructions a fuzzed program may run.
+const fuzzSteps = 1000
+
+// newFuzzCPU creates a CPU with its console stubbed, reading input
+// and discarding output.
+func newFuzzCPU(input []byte) *cpu {
+	return NewCPU(
+		WithInput(bytes.NewReader(input)),
+		WithOutput(io.Discard),
+	)
+}
+
+// checkState fails the test if the CPU is left in a state no
+// instruction can produce.
+func checkState(t *testing.T, c *cpu) {
+	t.Helper()
+
+	switch cond := c.Register(registers.RCOND); cond {
+	case cflags.FLPOS, cflags.FLZRO, cflags.FLNEG:
+	default:
+		t.Fatalf("condition codes %03b, expected exactly one of n, z and p", cond)
+	}
+}
+
+// encode encodes words big-endian.
+func encode(words ...uint16) []byte {
+	var data []byte
+	for _, word := range words {
+		data = binary.BigEndian.AppendUint16(data, word)
+	}
+
+	return data
+}
+
+// FuzzInstruction executes a single instruction word with random
+// registers around it, which must not panic and must leave valid
+// condition codes.
+func FuzzInstruction(f *testing.F) {
+	f.Add(uint16(0x1261), uint16(0x7FFF), uint16(1), uint16(0x3000))
+	f.Add(uint16(0x5020), uint16(0xFFFF), uint16(0), uint16(0x3000))
+	f.Add(uint16(0x903F), uint16(0x8000), uint16(0), uint16(0xFFFF))
+	f.Add(uint16(0xF025), uint16(0), uint16(0), uint16(0x3000))
+	f.Add(uint16(0xC1C0), uint16(0), uint16(0), uint16(0x0000))
+	f.Add(uint16(0x8000), uint16(0), uint16(0), uint16(0x3000))
+
+	f.Fuzz(func(t *testing.T, word, r0, r1, pc uint16) {
+		c := newFuzzCPU([]byte("a"))
+
+		c.memory[pc] = word
+		c.memory[pc+1] = 0xF025
+
+		c.SetRegister(registers.RR0, r0)
+		c.SetRegister(registers.RR1, r1)
+		c.SetRegister(registers.RPC, pc)
+
+		if err := c.Execute(); err != nil {
+			return
+		}
+
+		checkState(t, c)
+	})
+}
+
+// FuzzProgram runs random big-endian words loaded at x3000 for a
+// bounded number of instructions, which must not panic and must leave
+// valid condition codes after every instruction.
+func FuzzProgram(f *testing.F) {
+	// LEA R0, MSG; PUTS; GETC; OUT; HALT; MSG "hi"
+	f.Add(encode(0xE004, 0xF022, 0xF020, 0xF021, 0xF025, 'h', 'i', 0), []byte("x"))
+
+	// a loop counting R1 down from 3
+	f.Add(encode(0x5260, 0x1263, 0x127F, 0x03FE, 0xF025), []byte{})
+
+	// JSR into a subroutine storing through a pointer
+	f.Add(encode(0x4802, 0xF025, 0x0000, 0x2202, 0xB201, 0xC1C0, 0x4000), []byte{})
+
+	f.Fuzz(func(t *testing.T, program, input []byte) {
+		c := newFuzzCPU(input)
+
+		for i := 0; i+1 < len(program) && 0x3000+i/2 <= 0xFFFF; i += 2 {
+			c.memory[0x3000+i/2] = binary.BigEndian.Uint16(program[i:])
+		}
+
+		for i := 0; i < fuzzSteps && !c.Halted(); i++ {
+			if err := c.Execute(); err != nil {
+				return
+			}
+
+			checkState(t, c)
+		}
+	})
+}
+
+// FuzzImage runs a random .obj image, the origin followed by the
+// words, as a malformed object would be loaded.
+func FuzzImage(f *testing.F) {
+	f.Add([]byte{0x30, 0x00, 0xF0, 0x25})
+	f.Add([]byte{0xFF, 0xFF, 0x0F, 0xFF, 0xF0, 0x25})
+	f.Add([]byte{0x30})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) < 2 {
+			return
+		}
+
+		c := newFuzzCPU(nil)
+
+		origin := binary.BigEndian.Uint16(data)
+		for i := 2; i+1 < len(data) && int(origin)+(i-2)/2 <= 0xFFFF; i += 2 {
+			c.memory[int(origin)+(i-2)/2] = binary.BigEndian.Uint16(data[i:])
+		}
+
+		c.SetRegister(registers.RPC, origin)
+
+		for i := 0; i < fuzzSteps && !c.Halted(); i++ {
+			if err := c.Execute(); err != nil {
+				return
+			}
+
+			checkState(t, c)
+		}
+	})
+}
+
+// TestJSRRThroughR7 checks that JSRR R7 jumps to the address R7 held
+// before the instruction, and links R7 to the instruction after it.
+func TestJSRRThroughR7(t *testing.T) {
+	c := NewCPU(WithOutput(io.Discard))
+
+	c.memory[0x3000] = 0x41C0 // JSRR R7
+	c.SetRegister(registers.RR7, 0x4000)
+	c.SetRegister(registers.RPC, 0x3000)
+
+	if err := c.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	if pc, r7 := c.Register(registers.RPC), c.Register(registers.RR7); pc != 0x4000 || r7 != 0x3001 {
+		t.Errorf("PC x%04X and R7 x%04X, expected x4000 and x3001", pc, r7)
+	}
+}
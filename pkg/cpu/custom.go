@@ -0,0 +1,120 @@
+package cpu
+
+import (
+	"lc3/pkg/registers"
+	"maps"
+)
+
+// OpcodeFunc handles the instructions of an opcode in place of its
+// built-in handler, called with the PC past the instruction.
+type OpcodeFunc func(in *Instruction) error
+
+// Instruction is an instruction handled by an OpcodeFunc, with helpers
+// decoding its fields and running it on the CPU.
+type Instruction struct {
+	// Word is the instruction.
+	Word uint16
+
+	// Address is the address of the instruction.
+	Address uint16
+
+	// cpu is the CPU running the instruction.
+	cpu *cpu
+}
+
+// WithOpcode registers a Go function handling an opcode, such as the
+// reserved opcode 1101, replacing its built-in handler or that of an
+// extension, so that new instructions can be tried without changing
+// the CPU.
+func WithOpcode(op uint16, fn OpcodeFunc) Option {
+	return func(c *cpu) {
+		c.customOps[op&0xF] = fn
+	}
+}
+
+// installCustomOpcodes adds the handlers registered with WithOpcode to
+// the opcodes of the instruction set.
+func (c *cpu) installCustomOpcodes() {
+	if len(c.customOps) == 0 {
+		return
+	}
+
+	c.ops = maps.Clone(c.ops)
+
+	for op, fn := range c.customOps {
+		c.ops[op] = func(c *cpu) error {
+			return fn(&Instruction{Word: c.instr, Address: c.pc, cpu: c})
+		}
+	}
+}
+
+// CPU returns the CPU running the instruction.
+func (in *Instruction) CPU() CPU {
+	return in.cpu
+}
+
+// Opcode returns bits 15 to 12.
+func (in *Instruction) Opcode() uint16 {
+	return in.Word >> 12
+}
+
+// DR returns the destination or source register in bits 11 to 9.
+func (in *Instruction) DR() uint16 {
+	return in.Bits(11, 9)
+}
+
+// SR1 returns the first source or base register in bits 8 to 6.
+func (in *Instruction) SR1() uint16 {
+	return in.Bits(8, 6)
+}
+
+// SR2 returns the second source register in bits 2 to 0.
+func (in *Instruction) SR2() uint16 {
+	return in.Bits(2, 0)
+}
+
+// Immediate reports whether bit 5 is set, as it is in the immediate
+// forms of ADD and AND.
+func (in *Instruction) Immediate() bool {
+	return in.Bits(5, 5) == 1
+}
+
+// Bits returns bits hi to lo.
+func (in *Instruction) Bits(hi, lo uint) uint16 {
+	return in.Word >> lo & (1<<(hi-lo+1) - 1)
+}
+
+// SignedBits returns bits hi to lo sign-extended, as offsets and
+// immediates are.
+func (in *Instruction) SignedBits(hi, lo uint) uint16 {
+	return signExtend(in.Bits(hi, lo), uint16(hi-lo+1))
+}
+
+// Register returns the value of a register.
+func (in *Instruction) Register(r uint16) uint16 {
+	return in.cpu.registers[r]
+}
+
+// SetRegister sets a general-purpose register and the condition codes,
+// as instructions computing a value do.
+func (in *Instruction) SetRegister(r, val uint16) {
+	in.cpu.registers[r] = val
+	in.cpu.updateFlags(r)
+}
+
+// Jump sets the PC.
+func (in *Instruction) Jump(address uint16) {
+	in.cpu.registers[registers.RPC] = address
+}
+
+// Load reads a word of memory as an instruction does, through devices
+// and memory hooks.
+func (in *Instruction) Load(address uint16) (uint16, error) {
+	return in.cpu.memoryRead(address)
+}
+
+// Store writes a word of memory as an instruction does, through devices
+// and memory hooks.
+func (in *Instruction) Store(address, val uint16) error {
+	return in.cpu.memoryWrite(address, val)
+}
@@ -0,0 +1,63 @@
+package cpu
+
+import (
+	"io"
+	"lc3/pkg/cflags"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+	"strings"
+	"testing"
+)
+
+// TestWithOpcode runs an instruction of an embedder on the reserved
+// opcode, a population count, along with an override of NOT.
+func TestWithOpcode(t *testing.T) {
+	popcount := func(in *Instruction) error {
+		if in.Address != 0x3000 || in.Bits(5, 0) != 0 {
+			t.Errorf("instruction x%04X at x%04X", in.Word, in.Address)
+		}
+
+		var n uint16
+		for v := in.Register(in.SR1()); v != 0; v >>= 1 {
+			n += v & 1
+		}
+
+		in.SetRegister(in.DR(), n)
+
+		return nil
+	}
+
+	negate := func(in *Instruction) error {
+		in.SetRegister(in.DR(), -in.Register(in.SR1()))
+		return nil
+	}
+
+	c := NewCPU(
+		WithOpcode(opcodes.OPRES, popcount),
+		WithOpcode(opcodes.OPNOT, negate),
+		WithInput(strings.NewReader("")),
+		WithOutput(io.Discard),
+	)
+
+	c.memory[0x3000] = 0xD040 // POPCNT R0, R1
+	c.memory[0x3001] = 0x9400 // NOT R2, R0, negating
+	c.SetRegister(registers.RR1, 0xF0F1)
+
+	for i := 0; i < 2; i++ {
+		if err := c.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := c.Register(registers.RR0); got != 9 {
+		t.Errorf("R0 = %d, expected 9", got)
+	}
+
+	if got := c.Register(registers.RR2); got != 0xFFF7 {
+		t.Errorf("R2 = x%04X, expected -9", got)
+	}
+
+	if got := c.Register(registers.RCOND); got != cflags.FLNEG {
+		t.Errorf("condition codes %03b, expected N", got)
+	}
+}
@@ -0,0 +1,22 @@
+package cpu
+
+// Device defines a memory-mapped peripheral. Reads and writes
+// to any of the addresses a device owns are routed to the
+// device instead of main memory.
+type Device interface {
+	// Addresses returns the memory-mapped register addresses
+	// owned by the device.
+	Addresses() []uint16
+
+	// Read reads the register at the given address.
+	Read(address uint16) (uint16, error)
+
+	// Write writes a value to the register at the given address.
+	Write(address uint16, val uint16) error
+}
+
+// Ticker is implemented by devices that need to observe the passage
+// of time on the CPU. Tick is called once after every instruction.
+type Ticker interface {
+	Tick()
+}
@@ -0,0 +1,32 @@
+package cpu
+
+import "lc3/pkg/registers"
+
+// deviceMap has a bit set for every address whose reads and writes
+// take the slow path: device registers, the keyboard and memory that is
+// not installed.
+type deviceMap [MemoryMax / 64]uint64
+
+// set marks an address as taking the slow path.
+func (m *deviceMap) set(address uint16) {
+	m[address/64] |= 1 << (address % 64)
+}
+
+// has reports whether an address takes the slow path.
+func (m *deviceMap) has(address uint16) bool {
+	return m[address/64]&(1<<(address%64)) != 0
+}
+
+// mapDevices marks the addresses taking the slow path, once the
+// options have attached the devices and sized the memory.
+func (c *cpu) mapDevices() {
+	for address := range c.devices {
+		c.mapped.set(address)
+	}
+
+	c.mapped.set(registers.MRKBSR)
+
+	for address := c.memorySize; address < int(registers.MRKBSR); address++ {
+		c.mapped.set(uint16(address))
+	}
+}
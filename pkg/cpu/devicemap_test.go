@@ -0,0 +1,25 @@
+package cpu
+
+import (
+	"lc3/pkg/registers"
+	"testing"
+)
+
+// TestDeviceMap checks that device registers, the keyboard and memory
+// that is not installed take the slow path, and the rest of memory
+// does not.
+func TestDeviceMap(t *testing.T) {
+	c := NewCPU(WithMMU(), WithMemorySize(0x1000))
+
+	for _, address := range []uint16{registers.MRMMUCR, registers.MRKBSR, 0x1000, 0xFDFF} {
+		if !c.mapped.has(address) {
+			t.Errorf("x%04X not mapped", address)
+		}
+	}
+
+	for _, address := range []uint16{0x0000, 0x0FFF, registers.MRKBDR, 0xFFFF} {
+		if c.mapped.has(address) {
+			t.Errorf("x%04X mapped", address)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package cpu
+
+import (
+	"lc3/pkg/opcodes"
+	"maps"
+)
+
+// installExtensions adds the handlers of the extensions enabled to the
+// opcodes of the instruction set.
+func (c *cpu) installExtensions() {
+	if (c.mulDiv || c.testAndSet || c.wideRegisters) && c.isa == LC3 {
+		c.ops = maps.Clone(c.ops)
+		c.ops[opcodes.OPRES] = handleReserved
+	}
+
+	c.installMMU()
+	c.installStrict()
+	c.installCustomOpcodes()
+}
+
+// handleReserved handles the reserved opcode, shared by the prefix of
+// the mode of 16 registers and the test-and-set and multiply and divide
+// extensions.
+func handleReserved(cpu *cpu) error {
+	switch {
+	case cpu.isPrefix():
+		cpu.prefix = 0x8 | cpu.instr&0x7
+		return nil
+	case cpu.testAndSet && (cpu.instr>>5)&0x1 == 1:
+		return handleTestAndSet(cpu)
+	case cpu.mulDiv:
+		return handleMulDiv(cpu)
+	default:
+		return unhandledOpcode(cpu)
+	}
+}
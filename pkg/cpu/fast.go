@@ -0,0 +1,62 @@
+package cpu
+
+import "lc3/pkg/registers"
+
+// WithFastMode runs programs under Run in a loop stripped of the checks
+// the interpreter makes every instruction for hooks, ticking devices,
+// the MMU, misaligned fetches and opcodes without a handler, for the
+// most instructions a second. Run keeps to the full loop while a hook
+// or a ticking device is attached, and the block JIT takes precedence.
+func WithFastMode() Option {
+	return func(c *cpu) {
+		c.fast = true
+	}
+}
+
+// fastEnabled reports whether Run runs the stripped loop.
+func (c *cpu) fastEnabled() bool {
+	return c.fast && c.wordSize == 1 && c.mmu == nil && c.code == nil && !c.wideRegisters &&
+		len(c.tickers) == 0 && len(c.instructionHooks) == 0 && len(c.memoryHooks) == 0
+}
+
+// runFast runs the program until it halts, fetching from memory
+// directly and dispatching through an array of the handlers.
+func (c *cpu) runFast() error {
+	var ops [16]func(c *cpu) error
+
+	for op := range ops {
+		fn, ok := c.ops[uint16(op)]
+		if !ok {
+			fn = unhandledOpcode
+		}
+
+		ops[op] = fn
+	}
+
+	c.running = true
+
+	for c.running {
+		pc := c.registers[registers.RPC]
+
+		instr := c.memory[pc]
+		if c.mapped.has(pc) {
+			var err error
+			if instr, err = c.readWord(pc); err != nil {
+				return err
+			}
+		}
+
+		c.pc = pc
+		c.instr = instr
+		c.op = instr >> 12
+		c.registers[registers.RPC] = pc + 1
+
+		if err := ops[c.op](c); err != nil {
+			return err
+		}
+
+		c.ageOutput()
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package cpu
+
+import (
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+)
+
+// WithFusion fuses frequent pairs of instructions in the blocks of
+// WithBlockJIT into one step each: an operation setting the condition
+// codes and the branch testing them, as at the end of most loops,
+// NOT and ADD #1 negating a register, and LEA followed by a trap, as
+// in printing a string. Fused steps run only while no device or hook
+// watches every instruction, which then see the pairs one at a time.
+func WithFusion() Option {
+	return func(c *cpu) {
+		c.fusion = true
+	}
+}
+
+// fuse returns the steps of a block with the pairs fused that can be,
+// or nil when there are none.
+func (c *cpu) fuse(steps []step) []step {
+	var fused []step
+
+	for i := 0; i < len(steps); i++ {
+		if i+1 < len(steps) {
+			if run, ok := c.fusePair(steps[i], steps[i+1]); ok {
+				if fused == nil {
+					fused = append(make([]step, 0, len(steps)), steps[:i]...)
+				}
+
+				fused = append(fused, step{address: steps[i].address, instr: steps[i].instr, run: run})
+				i++
+
+				continue
+			}
+		}
+
+		if fused != nil {
+			fused = append(fused, steps[i])
+		}
+	}
+
+	return fused
+}
+
+// fusePair returns a step running two instructions, if they make one
+// of the pairs fused. The first of a pair never stores, so the second
+// cannot have changed in between, and opcodes the embedder handles are
+// never fused.
+func (c *cpu) fusePair(first, second step) (func(c *cpu) error, bool) {
+	op := first.instr >> 12
+	next := second.instr >> 12
+
+	_, custom := c.customOps[op]
+	_, customNext := c.customOps[next]
+
+	switch {
+	case custom || customNext:
+		return nil, false
+	case next == opcodes.OPBR && setsFlags(op):
+		return fuseBranch(first, second), true
+	case op == opcodes.OPNOT && next == opcodes.OPADD && negates(first.instr, second.instr):
+		r := (first.instr >> 9) & 0x7
+
+		return func(c *cpu) error {
+			c.pc = second.address
+			c.instr = second.instr
+			c.op = next
+			c.registers[registers.RPC] = second.address + 1
+
+			c.registers[r] = -c.registers[r]
+			c.updateFlags(r)
+
+			return nil
+		}, true
+	case op == opcodes.OPLEA && next == opcodes.OPTRAP:
+		return func(c *cpu) error {
+			if err := first.run(c); err != nil {
+				return err
+			}
+
+			c.pc = second.address
+			c.instr = second.instr
+			c.op = next
+			c.registers[registers.RPC] = second.address + 1
+
+			return second.run(c)
+		}, true
+	}
+
+	return nil, false
+}
+
+// fuseBranch fuses an operation setting the condition codes with the
+// branch after it, running additions and loads inline as loop counters
+// and scans do.
+func fuseBranch(first, second step) func(c *cpu) error {
+	br := branch{
+		address: second.address,
+		instr:   second.instr,
+		target:  second.address + 1 + signExtend(second.instr&0x1FF, 9),
+	}
+
+	op := first.instr >> 12
+	dr := (first.instr >> 9) & 0x7
+	sr1 := (first.instr >> 6) & 0x7
+
+	switch {
+	case op == opcodes.OPADD && (first.instr>>5)&0x1 == 1:
+		imm5 := signExtend(first.instr&0x1F, 5)
+
+		return func(c *cpu) error {
+			c.registers[dr] = c.registers[sr1] + imm5
+			c.updateFlags(dr)
+			br.take(c)
+
+			return nil
+		}
+	case op == opcodes.OPADD:
+		sr2 := first.instr & 0x7
+
+		return func(c *cpu) error {
+			c.registers[dr] = c.registers[sr1] + c.registers[sr2]
+			c.updateFlags(dr)
+			br.take(c)
+
+			return nil
+		}
+	case op == opcodes.OPLDR:
+		offset6 := signExtend(first.instr&0x3F, 6)
+
+		return func(c *cpu) error {
+			if err := c.loadRegister(dr, c.registers[sr1]+offset6); err != nil {
+				return err
+			}
+
+			br.take(c)
+
+			return nil
+		}
+	}
+
+	return func(c *cpu) error {
+		if err := first.run(c); err != nil {
+			return err
+		}
+
+		br.take(c)
+
+		return nil
+	}
+}
+
+// branch is the branch ending a fused pair.
+type branch struct {
+	// address is the address of the branch.
+	address uint16
+
+	// instr is the branch.
+	instr uint16
+
+	// target is the address it branches to.
+	target uint16
+}
+
+// take runs the branch, as the instruction executing.
+func (br *branch) take(c *cpu) {
+	c.pc = br.address
+	c.instr = br.instr
+	c.op = opcodes.OPBR
+	c.registers[registers.RPC] = br.address + 1
+
+	if (br.instr>>9)&0x7&c.registers[registers.RCOND] != 0 {
+		c.registers[registers.RPC] = br.target
+	}
+}
+
+// setsFlags reports whether the translated instructions of an opcode
+// set the condition codes without storing or jumping.
+func setsFlags(op uint16) bool {
+	switch op {
+	case opcodes.OPADD, opcodes.OPAND, opcodes.OPNOT, opcodes.OPLEA, opcodes.OPLD, opcodes.OPLDR:
+		return true
+	}
+
+	return false
+}
+
+// negates reports whether NOT and ADD negate a register in place.
+func negates(not, add uint16) bool {
+	r := (not >> 9) & 0x7
+
+	return (not>>6)&0x7 == r && add&0x0FFF == r<<9|r<<6|1<<5|1
+}
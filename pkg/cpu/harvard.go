@@ -0,0 +1,27 @@
+package cpu
+
+// WithHarvard splits memory into a code space fetched from and a data
+// space loaded from and stored to, of 64K words each, for contrasting
+// the Harvard and von Neumann architectures. Loading an image puts it
+// in both, so that instructions read their .FILL data, but stores no
+// longer change the code, and self-modifying programs run their
+// original instructions.
+func WithHarvard() Option {
+	return func(c *cpu) {
+		c.code = new([MemoryMax]uint16)
+	}
+}
+
+// fetch reads the instruction at an address, from the code space in
+// the Harvard mode.
+func (c *cpu) fetch(address uint16) (uint16, error) {
+	if c.code == nil {
+		return c.readWord(address)
+	}
+
+	if err := c.installed(address); err != nil {
+		return 0, err
+	}
+
+	return c.code[address], nil
+}
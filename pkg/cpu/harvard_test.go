@@ -0,0 +1,45 @@
+package cpu
+
+import (
+	"io"
+	"lc3/pkg/registers"
+	"strings"
+	"testing"
+)
+
+// TestHarvard checks that a store over the next instruction changes
+// the data space only in the Harvard mode.
+func TestHarvard(t *testing.T) {
+	var image [MemoryMax]uint16
+	image[0x3000] = 0x2202 // LD R1, ADD
+	image[0x3001] = 0x3200 // ST R1, #0
+	image[0x3002] = 0xF025 // HALT
+	image[0x3003] = 0x14A1 // ADD R2, R2, #1
+
+	for _, tc := range []struct {
+		harvard  bool
+		expected uint16
+	}{{false, 1}, {true, 0}} {
+		opts := []Option{WithInput(strings.NewReader("")), WithOutput(io.Discard)}
+		if tc.harvard {
+			opts = append(opts, WithHarvard())
+		}
+
+		c := NewCPU(opts...)
+		c.Load(image)
+
+		for i := 0; i < 3; i++ {
+			if err := c.Execute(); err != nil {
+				t.Fatalf("at x%04X: %v", c.pc, err)
+			}
+		}
+
+		if got := c.Register(registers.RR2); got != tc.expected {
+			t.Errorf("harvard %t: R2 = %d, expected %d", tc.harvard, got, tc.expected)
+		}
+
+		if got := c.PeekMemory(0x3002); got != 0x14A1 {
+			t.Errorf("harvard %t: data x3002 = x%04X, expected the store", tc.harvard, got)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package cpu
+
+// MemoryAccess describes a single memory access made by an
+// instruction.
+type MemoryAccess struct {
+	// PC is the address of the instruction that made the access.
+	PC uint16
+
+	// Address is the memory address that was accessed.
+	Address uint16
+
+	// Value is the value that was read or written.
+	Value uint16
+
+	// Old is the value memory held before a write.
+	Old uint16
+
+	// Write is set for writes and clear for reads.
+	Write bool
+}
+
+// InstructionHook is called after every executed instruction with the
+// address and the word of the instruction, the registers reflect its
+// result.
+type InstructionHook func(pc, instr uint16)
+
+// MemoryHook is called after every memory access made by an
+// instruction. Instruction fetches and accesses made through
+// ReadMemory and WriteMemory are not reported.
+type MemoryHook func(access MemoryAccess)
+
+// OnMemoryAccess registers a hook that is called on every memory
+// access made by an instruction.
+func (c *cpu) OnMemoryAccess(hook MemoryHook) {
+	c.memoryHooks = append(c.memoryHooks, hook)
+}
+
+// notifyMemoryHooks calls the memory hooks.
+func (c *cpu) notifyMemoryHooks(access MemoryAccess) {
+	for _, hook := range c.memoryHooks {
+		hook(access)
+	}
+}
+
+// OnInstruction registers a hook that is called after every executed
+// instruction.
+func (c *cpu) OnInstruction(hook InstructionHook) {
+	c.instructionHooks = append(c.instructionHooks, hook)
+}
+
+// notifyInstructionHooks calls the instruction hooks.
+func (c *cpu) notifyInstructionHooks() {
+	for _, hook := range c.instructionHooks {
+		hook(c.pc, c.instr)
+	}
+}
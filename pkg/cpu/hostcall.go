@@ -0,0 +1,101 @@
+package cpu
+
+import (
+	"fmt"
+	"lc3/pkg/registers"
+	"strings"
+)
+
+// HostFunc is a Go function that can be invoked by an LC3 program
+// through the HOSTCALL trap. The returned value is written back
+// to R0.
+type HostFunc func(call *HostCall) (uint16, error)
+
+// HostCall describes a single invocation of the HOSTCALL trap and
+// gives the host function access to the memory of the CPU.
+type HostCall struct {
+	// Number is the call number, taken from R0.
+	Number uint16
+
+	// Args are the call arguments, taken from R1-R5.
+	Args [5]uint16
+
+	// cpu is the CPU that issued the call.
+	cpu *cpu
+}
+
+// Load reads a word from memory.
+func (h *HostCall) Load(address uint16) (uint16, error) {
+	return h.cpu.memoryRead(address)
+}
+
+// Store writes a word to memory.
+func (h *HostCall) Store(address uint16, val uint16) error {
+	return h.cpu.memoryWrite(address, val)
+}
+
+// LoadString reads a null terminated string starting at the given
+// address, one character per word.
+func (h *HostCall) LoadString(address uint16) (string, error) {
+	var sb strings.Builder
+
+	for addr := address; ; addr++ {
+		char, err := h.cpu.memoryRead(addr)
+		if err != nil {
+			return "", err
+		}
+
+		if char == 0 {
+			break
+		}
+
+		sb.WriteByte(byte(char))
+	}
+
+	return sb.String(), nil
+}
+
+// StoreString writes s as a null terminated string starting at
+// the given address, one character per word.
+func (h *HostCall) StoreString(address uint16, s string) error {
+	for i := 0; i < len(s); i++ {
+		if err := h.cpu.memoryWrite(address+uint16(i), uint16(s[i])); err != nil {
+			return err
+		}
+	}
+
+	return h.cpu.memoryWrite(address+uint16(len(s)), 0)
+}
+
+// handleHostCall handles the HostCall trap.
+func handleHostCall(cpu *cpu) error {
+	number := cpu.registers[registers.RR0]
+
+	fn, ok := cpu.hostCalls[number]
+	if !ok {
+		return fmt.Errorf("unregistered host call %d", number)
+	}
+
+	if err := cpu.flushOutput(); err != nil {
+		return err
+	}
+
+	call := &HostCall{
+		Number: number,
+		cpu:    cpu,
+	}
+
+	for i := range call.Args {
+		call.Args[i] = cpu.registers[registers.RR1+i]
+	}
+
+	result, err := fn(call)
+	if err != nil {
+		return fmt.Errorf("host call %d: %w", number, err)
+	}
+
+	cpu.registers[registers.RR0] = result
+	cpu.updateFlags(registers.RR0)
+
+	return nil
+}
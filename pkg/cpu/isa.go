@@ -0,0 +1,80 @@
+package cpu
+
+import (
+	"sort"
+	"strings"
+)
+
+// ISA is an instruction set the CPU runs.
+type ISA int
+
+const (
+	// LC3 is the LC-3 of Patt and Patel, run unless configured
+	// otherwise.
+	LC3 ISA = iota
+
+	// LC3b is the byte-addressed LC-3b of Patt and Patel, with byte
+	// and word loads and stores, XOR and shifts.
+	LC3b
+
+	// LC2 is the LC-2 of the first edition of Patt and Patel, with
+	// page-based addressing, for historical course materials.
+	LC2
+)
+
+// isaNames are the names of the instruction sets.
+var isaNames = map[ISA]string{
+	LC3:  "lc3",
+	LC3b: "lc3b",
+	LC2:  "lc2",
+}
+
+// String names the instruction set.
+func (i ISA) String() string {
+	return isaNames[i]
+}
+
+// LookupISA returns an instruction set by name.
+func LookupISA(name string) (ISA, bool) {
+	for isa, n := range isaNames {
+		if strings.EqualFold(n, name) {
+			return isa, true
+		}
+	}
+
+	return LC3, false
+}
+
+// ISANames returns the names of the instruction sets, sorted.
+func ISANames() []string {
+	names := make([]string, 0, len(isaNames))
+	for _, name := range isaNames {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// WithISA runs another instruction set than the LC-3.
+func WithISA(isa ISA) Option {
+	return func(c *cpu) {
+		c.isa = isa
+
+		switch isa {
+		case LC3b:
+			c.ops = lc3bOpTable
+			c.trapHandlers = lc3bTrapTable
+			c.wordSize = 2
+		case LC2:
+			c.ops = lc2OpTable
+			c.trapHandlers = lc2TrapTable
+			c.wordSize = 1
+		default:
+			c.ops = opTable
+			c.trapHandlers = trapTable
+			c.wordSize = 1
+		}
+	}
+}
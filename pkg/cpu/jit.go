@@ -0,0 +1,282 @@
+package cpu
+
+import (
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+)
+
+// maxBlock is the most instructions translated into a block.
+const maxBlock = 64
+
+// WithBlockJIT runs programs under Run a basic block at a time rather
+// than an instruction at a time. A block runs from an address to the
+// first instruction that may jump, its instructions decoded once into
+// Go closures specialized on their fields, and is translated again
+// once the program stores into it. Hooks, devices and errors see the
+// same instructions as the interpreter. It only applies to the LC-3
+// without the MMU, the mode of 16 registers or the Harvard mode, and
+// Execute still interprets.
+func WithBlockJIT() Option {
+	return func(c *cpu) {
+		c.jit = &jit{}
+	}
+}
+
+// jit holds the blocks translated.
+type jit struct {
+	// blocks are the blocks by the address they start at, indexed
+	// rather than hashed as looking them up dominates running them.
+	blocks [MemoryMax]*block
+
+	// translated marks the addresses of the instructions of blocks.
+	translated [MemoryMax]bool
+
+	// flushed is set when the blocks were dropped, stopping the one
+	// running.
+	flushed bool
+
+	// filled is set once a block is translated after the blocks were
+	// last dropped, so that dropping none costs nothing.
+	filled bool
+}
+
+// block is a translated basic block.
+type block struct {
+	// steps are its instructions.
+	steps []step
+
+	// fused are its instructions with pairs fused, if any were.
+	fused []step
+}
+
+// step is a translated instruction.
+type step struct {
+	// address is the address of the instruction.
+	address uint16
+
+	// instr is the instruction.
+	instr uint16
+
+	// run runs the instruction, with the PC past it.
+	run func(c *cpu) error
+}
+
+// flush drops the blocks.
+func (j *jit) flush() {
+	if j.filled {
+		clear(j.blocks[:])
+		clear(j.translated[:])
+		j.filled = false
+	}
+
+	j.flushed = true
+}
+
+// jitEnabled reports whether Run runs blocks.
+func (c *cpu) jitEnabled() bool {
+	return c.jit != nil && c.isa == LC3 && c.mmu == nil && !c.wideRegisters && c.code == nil
+}
+
+// runBlocks runs the program a block at a time until it halts or is
+// cancelled, interpreting instructions no block can start at.
+func (c *cpu) runBlocks() error {
+	c.running = true
+
+	for c.running {
+		b := c.block(c.registers[registers.RPC])
+		if b == nil {
+			err := c.Step()
+			if err == nil {
+				err = c.dispatch(c.op)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			c.tick()
+
+			continue
+		}
+
+		c.jit.flushed = false
+
+		steps := b.steps
+		if b.fused != nil && len(c.tickers) == 0 && len(c.instructionHooks) == 0 {
+			steps = b.fused
+		}
+
+		for _, s := range steps {
+			c.pc = s.address
+			c.instr = s.instr
+			c.op = s.instr >> 12
+			c.registers[registers.RPC] = s.address + 1
+
+			if err := s.run(c); err != nil {
+				return err
+			}
+
+			c.tick()
+
+			if !c.running || c.jit.flushed {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// block returns the block starting at an address, translating it if
+// need be, or nil outside of memory.
+func (c *cpu) block(start uint16) *block {
+	if b := c.jit.blocks[start]; b != nil {
+		return b
+	}
+
+	b := &block{}
+
+	for address := start; len(b.steps) < maxBlock; address++ {
+		if int(address) >= c.memorySize || address >= registers.MRKBSR {
+			break
+		}
+
+		instr := c.memory[address]
+
+		run, jumps := c.compile(address, instr)
+		b.steps = append(b.steps, step{address: address, instr: instr, run: run})
+
+		if jumps {
+			break
+		}
+	}
+
+	if len(b.steps) == 0 {
+		return nil
+	}
+
+	for _, s := range b.steps {
+		c.jit.translated[s.address] = true
+	}
+
+	if c.fusion {
+		b.fused = c.fuse(b.steps)
+	}
+
+	c.jit.blocks[start] = b
+	c.jit.filled = true
+
+	return b
+}
+
+// compile translates an instruction into a closure, reporting whether
+// it may jump and so ends its block. Instructions without a closure of
+// their own, or whose opcode the embedder handles, run their handler.
+func (c *cpu) compile(address, instr uint16) (run func(c *cpu) error, jumps bool) {
+	op := instr >> 12
+	dr := (instr >> 9) & 0x7
+	sr1 := (instr >> 6) & 0x7
+	sr2 := instr & 0x7
+	imm5 := signExtend(instr&0x1F, 5)
+	pcRelative := address + 1 + signExtend(instr&0x1FF, 9)
+	offset6 := signExtend(instr&0x3F, 6)
+	immediate := (instr>>5)&0x1 == 1
+
+	if _, ok := c.customOps[op]; ok {
+		return dispatchStep, true
+	}
+
+	if c.strict && illegalBits(instr) != "" {
+		return dispatchStep, true
+	}
+
+	switch op {
+	case opcodes.OPADD:
+		if immediate {
+			return func(c *cpu) error {
+				c.registers[dr] = c.registers[sr1] + imm5
+				c.updateFlags(dr)
+				return nil
+			}, false
+		}
+
+		return func(c *cpu) error {
+			c.registers[dr] = c.registers[sr1] + c.registers[sr2]
+			c.updateFlags(dr)
+			return nil
+		}, false
+	case opcodes.OPAND:
+		if immediate {
+			return func(c *cpu) error {
+				c.registers[dr] = c.registers[sr1] & imm5
+				c.updateFlags(dr)
+				return nil
+			}, false
+		}
+
+		return func(c *cpu) error {
+			c.registers[dr] = c.registers[sr1] & c.registers[sr2]
+			c.updateFlags(dr)
+			return nil
+		}, false
+	case opcodes.OPNOT:
+		return func(c *cpu) error {
+			c.registers[dr] = ^c.registers[sr1]
+			c.updateFlags(dr)
+			return nil
+		}, false
+	case opcodes.OPLEA:
+		return func(c *cpu) error {
+			c.registers[dr] = pcRelative
+			c.updateFlags(dr)
+			return nil
+		}, false
+	case opcodes.OPLD:
+		return func(c *cpu) error {
+			return c.loadRegister(dr, pcRelative)
+		}, false
+	case opcodes.OPLDR:
+		return func(c *cpu) error {
+			return c.loadRegister(dr, c.registers[sr1]+offset6)
+		}, false
+	case opcodes.OPST:
+		return func(c *cpu) error {
+			return c.memoryWrite(pcRelative, c.registers[dr])
+		}, false
+	case opcodes.OPSTR:
+		return func(c *cpu) error {
+			return c.memoryWrite(c.registers[sr1]+offset6, c.registers[dr])
+		}, false
+	case opcodes.OPBR:
+		return func(c *cpu) error {
+			if dr&c.registers[registers.RCOND] != 0 {
+				c.registers[registers.RPC] = pcRelative
+			}
+
+			return nil
+		}, true
+	case opcodes.OPLDI, opcodes.OPSTI:
+		return dispatchStep, false
+	}
+
+	return dispatchStep, true
+}
+
+// dispatchStep runs an instruction through the handler of its opcode.
+func dispatchStep(c *cpu) error {
+	return c.dispatch(c.op)
+}
+
+// loadRegister loads a register from memory and sets the condition
+// codes.
+func (c *cpu) loadRegister(r, address uint16) error {
+	val, err := c.memoryRead(address)
+	if err != nil {
+		return err
+	}
+
+	c.registers[r] = val
+	c.updateFlags(r)
+
+	return nil
+}
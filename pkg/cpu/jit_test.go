@@ -0,0 +1,94 @@
+package cpu_test
+
+import (
+	"lc3/pkg/cpu"
+	"lc3/pkg/workload"
+	"strings"
+	"testing"
+)
+
+// TestBlockJIT runs the benchmarked programs that halt, and one storing
+// into its own block, under Run with and without the JIT, fusion and
+// the fast mode, expecting the same registers, memory and output.
+func TestBlockJIT(t *testing.T) {
+	programs := map[string]string{
+		"sieve":  workload.Sources["sieve"],
+		"strcpy": workload.Sources["strcpy"],
+
+		// patch overwrites the ADD in its own loop with one adding 2,
+		// after the block of the loop has been translated.
+		"patch": `
+		.ORIG x3000
+		AND R1, R1, #0
+		LD R3, TIMES
+LOOP	ADD R1, R1, #1
+		LD R2, TWO
+		ST R2, LOOP
+		ADD R3, R3, #-1
+		BRp LOOP
+		HALT
+TIMES	.FILL #5
+TWO		ADD R1, R1, #2
+		.END`,
+
+		// fused runs every pair fused: negating, printing a string
+		// and counting down.
+		"fused": `
+		.ORIG x3000
+		LD R1, TIMES
+		NOT R1, R1
+		ADD R1, R1, #1
+LOOP	LEA R0, HELLO
+		PUTS
+		ADD R1, R1, #1
+		BRn LOOP
+		HALT
+TIMES	.FILL #3
+HELLO	.STRINGZ "hi "
+		.END`,
+	}
+
+	for name, source := range programs {
+		memory := assemble(t, source)
+
+		var snapshots []*cpu.Snapshot
+		var outputs []string
+
+		for _, opts := range [][]cpu.Option{nil, {cpu.WithBlockJIT()}, {cpu.WithBlockJIT(), cpu.WithFusion()}, {cpu.WithFastMode()}} {
+			var out strings.Builder
+
+			c := cpu.NewCPU(append(opts, cpu.WithInput(strings.NewReader("")), cpu.WithOutput(&out))...)
+
+			if err := c.Run(memory); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			snapshots = append(snapshots, c.Snapshot())
+			outputs = append(outputs, out.String())
+		}
+
+		for i, mode := range []string{"the JIT", "fusion", "fast mode"} {
+			s := snapshots[i+1]
+
+			if snapshots[0].Registers != s.Registers {
+				t.Errorf("%s: registers %v under %s, expected %v", name, s.Registers, mode, snapshots[0].Registers)
+			}
+
+			if snapshots[0].Memory != s.Memory {
+				t.Errorf("%s: memory differs under %s", name, mode)
+			}
+
+			if outputs[0] != outputs[i+1] {
+				t.Errorf("%s: output %q under %s, expected %q", name, outputs[i+1], mode, outputs[0])
+			}
+		}
+
+		if name == "patch" && snapshots[1].Registers[1] != 9 {
+			t.Errorf("patch: R1 = %d, expected 9 from the patched ADD", snapshots[1].Registers[1])
+		}
+
+		if name == "fused" && outputs[0] != "hi hi hi " {
+			t.Errorf("fused: output %q, expected three greetings", outputs[0])
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package cpu
+
+import (
+	"encoding/json"
+	"fmt"
+	"lc3/pkg/cflags"
+	"lc3/pkg/registers"
+	"math"
+	"slices"
+	"strings"
+)
+
+// minRepeat is the shortest run of a word encoded as a repeat, and the
+// shortest run of zeros that ends a block of words.
+const minRepeat = 4
+
+// registerNames name the registers in JSON, the condition codes aside.
+var registerNames = [...]string{
+	registers.RR0: "R0",
+	registers.RR1: "R1",
+	registers.RR2: "R2",
+	registers.RR3: "R3",
+	registers.RR4: "R4",
+	registers.RR5: "R5",
+	registers.RR6: "R6",
+	registers.RR7: "R7",
+	registers.RPC: "PC",
+}
+
+// flagNames name the condition codes in JSON.
+var flagNames = []struct {
+	flag uint16
+	name byte
+}{
+	{cflags.FLNEG, 'n'},
+	{cflags.FLZRO, 'z'},
+	{cflags.FLPOS, 'p'},
+}
+
+// jsonSnapshot is a snapshot as encoded in JSON.
+type jsonSnapshot struct {
+	// Registers map R0 to R7 and PC to their values.
+	Registers map[string]uint16 `json:"registers"`
+
+	// Flags are the condition codes set, as "n", "z" and "p".
+	Flags string `json:"flags"`
+
+	// Halted reports whether the program has halted.
+	Halted bool `json:"halted"`
+
+	// Memory are the runs of words that are not zero, in order.
+	Memory []memoryRun `json:"memory"`
+}
+
+// memoryRun is a run of words of memory, either given one by one in
+// Words or as Value repeated Repeat times.
+type memoryRun struct {
+	// Address is the address of the first word.
+	Address uint16 `json:"address"`
+
+	// Words are the words of the run.
+	Words []uint16 `json:"words,omitempty"`
+
+	// Repeat counts the words holding Value.
+	Repeat int `json:"repeat,omitempty"`
+
+	// Value is the value repeated.
+	Value uint16 `json:"value,omitempty"`
+}
+
+// MarshalJSON encodes the snapshot as an object of its registers by
+// name, its condition codes as a string such as "z", whether it halted
+// and its memory run-length encoded. Words that are zero are left out,
+// and a word repeated at least four times is given once with its count:
+//
+//	{"registers": {"R0": 72, ..., "PC": 12290}, "flags": "p", "halted": false,
+//	 "memory": [{"address": 12288, "words": [57346, 61474]},
+//	            {"address": 16384, "repeat": 256, "value": 32}]}
+func (s *Snapshot) MarshalJSON() ([]byte, error) {
+	js := jsonSnapshot{Registers: map[string]uint16{}, Halted: s.Halted, Memory: []memoryRun{}}
+
+	for r, name := range registerNames {
+		js.Registers[name] = s.Registers[r]
+	}
+
+	for _, f := range flagNames {
+		if s.Registers[registers.RCOND]&f.flag != 0 {
+			js.Flags += string(f.name)
+		}
+	}
+
+	for i := 0; i <= math.MaxUint16; {
+		if s.Memory[i] == 0 {
+			i++
+			continue
+		}
+
+		if n := s.repeats(i); n >= minRepeat {
+			js.Memory = append(js.Memory, memoryRun{Address: uint16(i), Repeat: n, Value: s.Memory[i]})
+			i += n
+
+			continue
+		}
+
+		start := i
+		for i <= math.MaxUint16 && s.repeats(i) < minRepeat {
+			i++
+		}
+
+		js.Memory = append(js.Memory, memoryRun{Address: uint16(start), Words: s.Memory[start:i]})
+	}
+
+	return json.Marshal(js)
+}
+
+// repeats counts the words from address i on that hold the same value.
+func (s *Snapshot) repeats(i int) int {
+	n := 1
+	for i+n <= math.MaxUint16 && s.Memory[i+n] == s.Memory[i] {
+		n++
+	}
+
+	return n
+}
+
+// UnmarshalJSON decodes a snapshot encoded by MarshalJSON. Registers
+// and memory it leaves out are zero.
+func (s *Snapshot) UnmarshalJSON(data []byte) error {
+	var js jsonSnapshot
+	if err := json.Unmarshal(data, &js); err != nil {
+		return err
+	}
+
+	*s = Snapshot{Halted: js.Halted}
+
+	for name, value := range js.Registers {
+		r := slices.Index(registerNames[:], strings.ToUpper(name))
+		if r < 0 {
+			return fmt.Errorf("unknown register %q", name)
+		}
+
+		s.Registers[r] = value
+	}
+
+	for _, c := range js.Flags {
+		known := false
+
+		for _, f := range flagNames {
+			if byte(c) == f.name {
+				s.Registers[registers.RCOND] |= f.flag
+				known = true
+			}
+		}
+
+		if !known {
+			return fmt.Errorf("unknown condition code %q", c)
+		}
+	}
+
+	for _, run := range js.Memory {
+		n := len(run.Words)
+
+		if run.Repeat < 0 {
+			return fmt.Errorf("run at x%04X has a negative repeat", run.Address)
+		}
+
+		if run.Repeat > 0 {
+			if n > 0 {
+				return fmt.Errorf("run at x%04X has both words and a repeat", run.Address)
+			}
+
+			n = run.Repeat
+		}
+
+		// checked before filling memory, so that a huge repeat is not
+		// allocated or looped over.
+		if n > MemoryMax-int(run.Address) {
+			return fmt.Errorf("run at x%04X runs past the end of memory", run.Address)
+		}
+
+		if run.Repeat > 0 {
+			for i := 0; i < n; i++ {
+				s.Memory[int(run.Address)+i] = run.Value
+			}
+		} else {
+			copy(s.Memory[run.Address:], run.Words)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package cpu
+
+import (
+	"encoding/json"
+	"lc3/pkg/cflags"
+	"lc3/pkg/registers"
+	"strings"
+	"testing"
+)
+
+// TestSnapshotJSON checks that a snapshot survives a round trip through
+// JSON, with its repeated words run-length encoded.
+func TestSnapshotJSON(t *testing.T) {
+	var s Snapshot
+	s.Registers[registers.RR0] = 72
+	s.Registers[registers.RPC] = 0x3002
+	s.Registers[registers.RCOND] = cflags.FLPOS
+	s.Memory[0x3000] = 0xE002
+	s.Memory[0x3001] = 0xF022
+
+	for i := 0x4000; i < 0x4100; i++ {
+		s.Memory[i] = ' '
+	}
+
+	s.Memory[0xFFFF] = 1
+
+	data, err := json.Marshal(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), `"repeat":256`) {
+		t.Errorf("%s does not repeat the spaces", data)
+	}
+
+	var decoded Snapshot
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded != s {
+		t.Error("decoded snapshot differs")
+	}
+}
+
+// TestSnapshotJSONErrors checks that malformed snapshots are rejected,
+// and that runs past the end of memory are rejected without being
+// allocated.
+func TestSnapshotJSONErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown register":    `{"registers": {"R9": 1}}`,
+		"unknown flag":        `{"flags": "q"}`,
+		"words and repeat":    `{"memory": [{"address": 0, "words": [1], "repeat": 4, "value": 1}]}`,
+		"negative repeat":     `{"memory": [{"address": 0, "repeat": -1, "value": 1}]}`,
+		"words past the end":  `{"memory": [{"address": 65535, "words": [1, 2]}]}`,
+		"repeat past the end": `{"memory": [{"address": 65535, "repeat": 2, "value": 1}]}`,
+		"huge repeat":         `{"memory": [{"address": 0, "repeat": 9223372036854775807, "value": 1}]}`,
+		"not an object":       `[]`,
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			var s Snapshot
+			if err := json.Unmarshal([]byte(data), &s); err == nil {
+				t.Error("decoded")
+			}
+		})
+	}
+
+	var s Snapshot
+	if err := json.Unmarshal([]byte(`{"memory": [{"address": 65532, "repeat": 4, "value": 7}]}`), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Memory[0xFFFC] != 7 || s.Memory[0xFFFF] != 7 {
+		t.Error("run at the end of memory not filled")
+	}
+}
@@ -0,0 +1,177 @@
+package cpu
+
+import (
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+	"lc3/pkg/traps"
+)
+
+// lc2OpTable are the handlers of the opcodes of the LC-2. LD, ST, LDI,
+// STI, BR, JSR and LEA address the page of 512 words of the PC, LDR,
+// STR and JSRR add an unsigned index to their base register and RET
+// takes the reserved opcode.
+var lc2OpTable = map[uint16]func(cpu *cpu) error{
+	opcodes.OPBR:   handleBrLC2,
+	opcodes.OPADD:  handleAdd,
+	opcodes.OPLD:   handleLoadLC2,
+	opcodes.OPST:   handleStoreLC2,
+	opcodes.OPJSR:  handleJumpSubroutineLC2,
+	opcodes.OPAND:  handleAnd,
+	opcodes.OPLDR:  handleLoadRLC2,
+	opcodes.OPSTR:  handleStrLC2,
+	opcodes.OPRTI:  unhandledOpcode,
+	opcodes.OPNOT:  handleNot,
+	opcodes.OPLDI:  handleLoadIndirectLC2,
+	opcodes.OPSTI:  handleStoreIndirectLC2,
+	opcodes.OPJSRR: handleJumpSubroutineRLC2,
+	opcodes.OPRET:  handleRet,
+	opcodes.OPLEA:  handleLoadEffectiveAddressLC2,
+	opcodes.OPTRAP: handleTrapLC2,
+}
+
+// lc2TrapTable are the built-in traps of the LC-2, which had no PUTSP.
+var lc2TrapTable = map[uint16]func(cpu *cpu) error{
+	traps.GETC: handleGetC,
+	traps.OUT:  handleOut,
+	traps.PUTS: handlePuts,
+	traps.IN:   handleIn,
+	traps.HALT: handleHalt,
+
+	traps.HOSTCALL: handleHostCall,
+}
+
+// pageAddress returns the address on the page of the PC at the 9-bit
+// page offset of the instruction.
+func (c *cpu) pageAddress() uint16 {
+	return c.registers[registers.RPC]&0xFE00 | c.instr&0x1FF
+}
+
+// indexAddress returns the address of the base register of the
+// instruction plus its unsigned 6-bit index.
+func (c *cpu) indexAddress() uint16 {
+	return c.registers[(c.instr>>6)&0x7] + c.instr&0x3F
+}
+
+// handleBrLC2 handles the conditional branch opcode, within the page.
+func handleBrLC2(cpu *cpu) error {
+	if (cpu.instr>>9)&0x7&cpu.registers[registers.RCOND] != 0 {
+		cpu.registers[registers.RPC] = cpu.pageAddress()
+	}
+
+	return nil
+}
+
+// handleLoadLC2 handles the load opcode, within the page.
+func handleLoadLC2(cpu *cpu) error {
+	return cpu.loadLC2(cpu.pageAddress())
+}
+
+// handleLoadRLC2 handles the load register opcode.
+func handleLoadRLC2(cpu *cpu) error {
+	return cpu.loadLC2(cpu.indexAddress())
+}
+
+// handleLoadIndirectLC2 handles the load indirect opcode, its pointer
+// within the page.
+func handleLoadIndirectLC2(cpu *cpu) error {
+	address, err := cpu.memoryRead(cpu.pageAddress())
+	if err != nil {
+		return err
+	}
+
+	return cpu.loadLC2(address)
+}
+
+// loadLC2 loads the destination register of the instruction from
+// memory.
+func (c *cpu) loadLC2(address uint16) error {
+	dr := (c.instr >> 9) & 0x7
+
+	val, err := c.memoryRead(address)
+	if err != nil {
+		return err
+	}
+
+	c.registers[dr] = val
+	c.updateFlags(dr)
+
+	return nil
+}
+
+// handleStoreLC2 handles the store opcode, within the page.
+func handleStoreLC2(cpu *cpu) error {
+	return cpu.memoryWrite(cpu.pageAddress(), cpu.registers[(cpu.instr>>9)&0x7])
+}
+
+// handleStrLC2 handles the store register opcode.
+func handleStrLC2(cpu *cpu) error {
+	return cpu.memoryWrite(cpu.indexAddress(), cpu.registers[(cpu.instr>>9)&0x7])
+}
+
+// handleStoreIndirectLC2 handles the store indirect opcode, its pointer
+// within the page.
+func handleStoreIndirectLC2(cpu *cpu) error {
+	address, err := cpu.memoryRead(cpu.pageAddress())
+	if err != nil {
+		return err
+	}
+
+	return cpu.memoryWrite(address, cpu.registers[(cpu.instr>>9)&0x7])
+}
+
+// handleJumpSubroutineLC2 handles JSR and JMP, within the page, JSR
+// having bit 11 set to link R7.
+func handleJumpSubroutineLC2(cpu *cpu) error {
+	return cpu.jumpLC2(cpu.pageAddress())
+}
+
+// handleJumpSubroutineRLC2 handles JSRR and JMPR, JSRR having bit 11
+// set to link R7.
+func handleJumpSubroutineRLC2(cpu *cpu) error {
+	return cpu.jumpLC2(cpu.indexAddress())
+}
+
+// jumpLC2 jumps to a target, linking R7 if bit 11 of the instruction
+// is set.
+func (c *cpu) jumpLC2(target uint16) error {
+	if (c.instr>>11)&0x1 == 1 {
+		c.registers[registers.RR7] = c.registers[registers.RPC]
+	}
+
+	c.registers[registers.RPC] = target
+
+	return nil
+}
+
+// handleRet handles the return opcode, jumping to R7.
+func handleRet(cpu *cpu) error {
+	cpu.registers[registers.RPC] = cpu.registers[registers.RR7]
+
+	return nil
+}
+
+// handleLoadEffectiveAddressLC2 handles loading the effective address,
+// within the page.
+func handleLoadEffectiveAddressLC2(cpu *cpu) error {
+	dr := (cpu.instr >> 9) & 0x7
+	cpu.registers[dr] = cpu.pageAddress()
+	cpu.updateFlags(dr)
+
+	return nil
+}
+
+// handleTrapLC2 handles the trap opcode as the LC-2 did, jumping to the
+// routine in the trap vector table when the program brings one, which
+// returns with RET, and running the built-in one otherwise.
+func handleTrapLC2(cpu *cpu) error {
+	if _, ok := cpu.traps[cpu.instr&0xFF]; !ok {
+		if routine := cpu.memory[cpu.instr&0xFF]; routine != 0 {
+			cpu.registers[registers.RR7] = cpu.registers[registers.RPC]
+			cpu.registers[registers.RPC] = routine
+
+			return nil
+		}
+	}
+
+	return handleTrap(cpu)
+}
@@ -0,0 +1,56 @@
+package cpu
+
+import (
+	"bytes"
+	"lc3/pkg/registers"
+	"strings"
+	"testing"
+)
+
+// TestLC2 runs a program using the page-based addressing of the LC-2,
+// its unsigned indexes, RET and a trap routine brought by the program.
+func TestLC2(t *testing.T) {
+	var out bytes.Buffer
+
+	c := NewCPU(WithISA(LC2), WithInput(strings.NewReader("")), WithOutput(&out))
+
+	program := map[uint16]uint16{
+		0x0021: 0x3018, // trap vector of OUT
+		0x3000: 0x2210, // LD R1, x3010
+		0x3001: 0x6441, // LDR R2, R1, #1
+		0x3002: 0x4811, // JSR x3011
+		0x3003: 0xF021, // TRAP x21
+		0x3004: 0xF025, // HALT
+		0x3010: 0x3020,
+		0x3011: 0x14A1, // ADD R2, R2, #1
+		0x3012: 0xD000, // RET
+		0x3018: 0x16E7, // ADD R3, R3, #7
+		0x3019: 0xD000, // RET
+		0x3021: 5,
+	}
+
+	for address, word := range program {
+		c.memory[address] = word
+	}
+
+	for !c.Halted() {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("at x%04X: %v", c.pc, err)
+		}
+	}
+
+	if out.Len() != 0 {
+		t.Errorf("output %q, expected the routine of the program to run", out.String())
+	}
+
+	for r, expected := range map[uint16]uint16{
+		registers.RR1: 0x3020,
+		registers.RR2: 6,
+		registers.RR3: 7,
+		registers.RPC: 0x3005,
+	} {
+		if got := c.Register(r); got != expected {
+			t.Errorf("R%d = x%04X, expected x%04X", r, got, expected)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package cpu
+
+import (
+	"fmt"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+	"lc3/pkg/traps"
+)
+
+// lc3bOpTable are the handlers of the opcodes of the LC-3b. Memory is
+// addressed by byte, words being held at even addresses with their low
+// byte first, so that memory[a] holds the word at byte address a and
+// the odd entries are unused.
+var lc3bOpTable = map[uint16]func(cpu *cpu) error{
+	opcodes.OPBR:   handleBrLC3b,
+	opcodes.OPADD:  handleAdd,
+	opcodes.OPLDB:  handleLoadByte,
+	opcodes.OPSTB:  handleStoreByte,
+	opcodes.OPJSR:  handleJumpSubroutineLC3b,
+	opcodes.OPAND:  handleAnd,
+	opcodes.OPLDW:  handleLoadWord,
+	opcodes.OPSTW:  handleStoreWord,
+	opcodes.OPRTI:  unhandledOpcode,
+	opcodes.OPXOR:  handleXor,
+	opcodes.OPLDI:  unhandledOpcode,
+	opcodes.OPSTI:  unhandledOpcode,
+	opcodes.OPJMP:  handleJmp,
+	opcodes.OPSHF:  handleShift,
+	opcodes.OPLEA:  handleLoadEffectiveAddressLC3b,
+	opcodes.OPTRAP: handleTrap,
+}
+
+// lc3bTrapTable are the built-in traps of the LC-3b, whose strings are
+// made of bytes.
+var lc3bTrapTable = map[uint16]func(cpu *cpu) error{
+	traps.GETC:  handleGetC,
+	traps.OUT:   handleOut,
+	traps.PUTS:  handlePutsLC3b,
+	traps.IN:    handleIn,
+	traps.PUTSP: handlePutsLC3b,
+	traps.HALT:  handleHalt,
+
+	traps.HOSTCALL: handleHostCall,
+}
+
+// memoryReadByte reads a byte of memory on behalf of the executing
+// instruction.
+func (c *cpu) memoryReadByte(address uint16) (uint16, error) {
+	word, err := c.memoryRead(address &^ 1)
+	if err != nil {
+		return 0, err
+	}
+
+	if address&1 != 0 {
+		word >>= 8
+	}
+
+	return word & 0xFF, nil
+}
+
+// memoryWriteByte writes a byte of memory on behalf of the executing
+// instruction. Devices are written the byte alone, in its place in the
+// word.
+func (c *cpu) memoryWriteByte(address uint16, val uint16) error {
+	word := address &^ 1
+	shift := (address & 1) * 8
+
+	merged := (val & 0xFF) << shift
+
+	if _, ok := c.devices[word]; !ok {
+		merged |= c.memory[word] &^ (0xFF << shift)
+	}
+
+	return c.memoryWrite(word, merged)
+}
+
+// alignedWord checks that a word is accessed at an even address.
+func alignedWord(address uint16) error {
+	if address&1 != 0 {
+		return fmt.Errorf("unaligned word access at x%04X", address)
+	}
+
+	return nil
+}
+
+// handleBrLC3b handles the conditional branch opcode, its offset
+// counting words.
+func handleBrLC3b(cpu *cpu) error {
+	condFlag := (cpu.instr >> 9) & 0x7
+	pcOffset := signExtend(cpu.instr&0x1FF, 9) << 1
+
+	if (condFlag & cpu.registers[registers.RCOND]) != 0 {
+		cpu.registers[registers.RPC] += pcOffset
+	}
+
+	return nil
+}
+
+// handleJumpSubroutineLC3b handles the jump to subroutine opcode, its
+// offset counting words.
+func handleJumpSubroutineLC3b(cpu *cpu) error {
+	target := cpu.registers[registers.RPC] + signExtend(cpu.instr&0x7FF, 11)<<1
+
+	if (cpu.instr>>11)&0x1 == 0 {
+		target = cpu.registers[(cpu.instr>>6)&0x7]
+	}
+
+	cpu.registers[registers.RR7] = cpu.registers[registers.RPC]
+	cpu.registers[registers.RPC] = target
+
+	return nil
+}
+
+// handleLoadByte handles the load byte opcode, sign extending the
+// byte.
+func handleLoadByte(cpu *cpu) error {
+	dr := (cpu.instr >> 9) & 0x7
+	br := (cpu.instr >> 6) & 0x7
+	offset := signExtend(cpu.instr&0x3F, 6)
+
+	val, err := cpu.memoryReadByte(cpu.registers[br] + offset)
+	if err != nil {
+		return err
+	}
+
+	cpu.registers[dr] = signExtend(val, 8)
+	cpu.updateFlags(dr)
+
+	return nil
+}
+
+// handleStoreByte handles the store byte opcode, storing the low byte
+// of the register.
+func handleStoreByte(cpu *cpu) error {
+	sr := (cpu.instr >> 9) & 0x7
+	br := (cpu.instr >> 6) & 0x7
+	offset := signExtend(cpu.instr&0x3F, 6)
+
+	return cpu.memoryWriteByte(cpu.registers[br]+offset, cpu.registers[sr])
+}
+
+// handleLoadWord handles the load word opcode, its offset counting
+// words.
+func handleLoadWord(cpu *cpu) error {
+	dr := (cpu.instr >> 9) & 0x7
+	br := (cpu.instr >> 6) & 0x7
+	address := cpu.registers[br] + signExtend(cpu.instr&0x3F, 6)<<1
+
+	if err := alignedWord(address); err != nil {
+		return err
+	}
+
+	val, err := cpu.memoryRead(address)
+	if err != nil {
+		return err
+	}
+
+	cpu.registers[dr] = val
+	cpu.updateFlags(dr)
+
+	return nil
+}
+
+// handleStoreWord handles the store word opcode, its offset counting
+// words.
+func handleStoreWord(cpu *cpu) error {
+	sr := (cpu.instr >> 9) & 0x7
+	br := (cpu.instr >> 6) & 0x7
+	address := cpu.registers[br] + signExtend(cpu.instr&0x3F, 6)<<1
+
+	if err := alignedWord(address); err != nil {
+		return err
+	}
+
+	return cpu.memoryWrite(address, cpu.registers[sr])
+}
+
+// handleXor handles the exclusive or opcode, which is NOT with an
+// immediate of -1.
+func handleXor(cpu *cpu) error {
+	dr := (cpu.instr >> 9) & 0x7
+	sr1 := (cpu.instr >> 6) & 0x7
+
+	operand := cpu.registers[cpu.instr&0x7]
+	if (cpu.instr>>5)&0x1 == 1 {
+		operand = signExtend(cpu.instr&0x1F, 5)
+	}
+
+	cpu.registers[dr] = cpu.registers[sr1] ^ operand
+	cpu.updateFlags(dr)
+
+	return nil
+}
+
+// handleShift handles the shift opcode: left, right logical or right
+// arithmetic by an amount of 0 to 15.
+func handleShift(cpu *cpu) error {
+	dr := (cpu.instr >> 9) & 0x7
+	sr := cpu.registers[(cpu.instr>>6)&0x7]
+	amount := cpu.instr & 0xF
+
+	switch (cpu.instr >> 4) & 0x3 {
+	case 0:
+		cpu.registers[dr] = sr << amount
+	case 1:
+		cpu.registers[dr] = sr >> amount
+	case 3:
+		cpu.registers[dr] = uint16(int16(sr) >> amount)
+	default:
+		return fmt.Errorf("invalid shift %04X", cpu.instr)
+	}
+
+	cpu.updateFlags(dr)
+
+	return nil
+}
+
+// handleLoadEffectiveAddressLC3b handles loading the effective address,
+// its offset counting words. Unlike on the LC-3 it leaves the condition
+// codes alone.
+func handleLoadEffectiveAddressLC3b(cpu *cpu) error {
+	dr := (cpu.instr >> 9) & 0x7
+	cpu.registers[dr] = cpu.registers[registers.RPC] + signExtend(cpu.instr&0x1FF, 9)<<1
+
+	return nil
+}
+
+// handlePutsLC3b handles the PUTS trap, writing the bytes from R0 up to
+// a zero byte.
+func handlePutsLC3b(cpu *cpu) error {
+	writer := cpu.out
+
+	for addr := cpu.registers[registers.RR0]; ; addr++ {
+		char, err := cpu.memoryReadByte(addr)
+		if err != nil {
+			return err
+		}
+
+		if char == 0 {
+			break
+		}
+
+		if err := writer.WriteByte(byte(char)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package cpu
+
+import (
+	"bytes"
+	"lc3/pkg/registers"
+	"strings"
+	"testing"
+)
+
+// TestLC3b runs a program using the byte loads and stores, shifts and
+// XOR of the LC-3b.
+func TestLC3b(t *testing.T) {
+	var out bytes.Buffer
+
+	c := NewCPU(WithISA(LC3b), WithInput(strings.NewReader("")), WithOutput(&out))
+
+	program := map[uint16]uint16{
+		0x3000: 0xE007, // LEA R0, MSG
+		0x3002: 0x2201, // LDB R1, R0, #1
+		0x3004: 0xD444, // LSHF R2, R1, #4
+		0x3006: 0x96BF, // NOT R3, R2
+		0x3008: 0xD8F4, // RSHFA R4, R3, #4
+		0x300A: 0x3200, // STB R1, R0, #0
+		0x300C: 0xF022, // PUTS
+		0x300E: 0xF025, // HALT
+		0x3010: 0x6948, // MSG "Hi"
+	}
+
+	for address, word := range program {
+		c.memory[address] = word
+	}
+
+	for !c.Halted() {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("at x%04X: %v", c.pc, err)
+		}
+	}
+
+	if out.String() != "ii" {
+		t.Errorf("output %q, expected \"ii\"", out.String())
+	}
+
+	for r, expected := range map[uint16]uint16{
+		registers.RR1: 'i',
+		registers.RR2: 0x0690,
+		registers.RR3: 0xF96F,
+		registers.RR4: 0xFF96,
+		registers.RPC: 0x3010,
+	} {
+		if got := c.Register(r); got != expected {
+			t.Errorf("R%d = x%04X, expected x%04X", r, got, expected)
+		}
+	}
+}
+
+// TestLC3bUnaligned checks that words are not fetched, loaded or
+// stored at odd addresses.
+func TestLC3bUnaligned(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		pc   uint16
+		word uint16
+	}{
+		{"fetch", 0x3001, 0x1020},
+		{"LDW", 0x3000, 0x6041}, // LDW R0, R1, #1 with R1 odd
+		{"STW", 0x3000, 0x7041}, // STW R0, R1, #1 with R1 odd
+	} {
+		c := NewCPU(WithISA(LC3b))
+
+		c.memory[tc.pc&^1] = tc.word
+		c.SetRegister(registers.RPC, tc.pc)
+		c.SetRegister(registers.RR1, 0x4001)
+
+		if err := c.Execute(); err == nil || !strings.Contains(err.Error(), "unaligned") {
+			t.Errorf("%s: error %v, expected an unaligned access", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package cpu
+
+import (
+	"fmt"
+	"lc3/pkg/registers"
+)
+
+// WithMemorySize installs memory below an address only, rather than the
+// 64K the addresses reach, for teaching machines such as 4K ones.
+// Fetching, loading or storing at or past the end of memory stops the
+// program, while the device registers from xFE00 stay reachable. Sizes
+// outside 1 to MemoryMax are ignored.
+func WithMemorySize(words int) Option {
+	return func(c *cpu) {
+		if words > 0 && words <= MemoryMax {
+			c.memorySize = words
+		}
+	}
+}
+
+// WithPC starts the program at an address rather than x3000, such as
+// the origin of a program for a machine whose memory ends below x3000.
+func WithPC(pc uint16) Option {
+	return func(c *cpu) {
+		c.registers[registers.RPC] = pc
+	}
+}
+
+// installed checks that an address is within the memory installed or
+// among the device registers.
+func (c *cpu) installed(address uint16) error {
+	if int(address) >= c.memorySize && address < registers.MRKBSR {
+		return fmt.Errorf("access of x%04X past the end of the %d words of memory", address, c.memorySize)
+	}
+
+	return nil
+}
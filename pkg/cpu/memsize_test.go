@@ -0,0 +1,37 @@
+package cpu
+
+import (
+	"io"
+	"lc3/pkg/registers"
+	"strings"
+	"testing"
+)
+
+// TestMemorySize checks that a reduced memory stops accesses past its
+// end but leaves the device registers reachable.
+func TestMemorySize(t *testing.T) {
+	c := NewCPU(WithMemorySize(0x1000), WithInput(strings.NewReader("a")), WithOutput(io.Discard))
+
+	c.SetRegister(registers.RPC, 0x0200)
+	c.SetRegister(registers.RR1, 0x0FFF)
+	c.SetRegister(registers.RR2, 0xFE00)
+	c.memory[0x0200] = 0x6040 // LDR R0, R1, #0
+	c.memory[0x0201] = 0x6080 // LDR R0, R2, #0
+	c.memory[0x0202] = 0x7041 // STR R0, R1, #1
+
+	for i := 0; i < 2; i++ {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("at x%04X: %v", c.pc, err)
+		}
+	}
+
+	if err := c.Execute(); err == nil || !strings.Contains(err.Error(), "past the end") {
+		t.Errorf("error %v, expected an access past the end of memory", err)
+	}
+
+	c.SetRegister(registers.RPC, 0x3000)
+
+	if err := c.Execute(); err == nil {
+		t.Error("fetched past the end of memory")
+	}
+}
@@ -0,0 +1,184 @@
+package cpu
+
+import (
+	"errors"
+	"fmt"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+	"maps"
+)
+
+const (
+	// PageSize is the number of words of a page of the MMU.
+	PageSize = 0x200
+
+	// PageFaultVector is the entry of the exception vector table
+	// holding the address of the page fault handler.
+	PageFaultVector = 0x0103
+
+	// PTEValid marks a page table entry as mapped.
+	PTEValid = 1 << 15
+
+	// PTEWritable marks a page table entry as writable.
+	PTEWritable = 1 << 14
+
+	// PTEFrame masks the physical page of a page table entry.
+	PTEFrame = 0x7F
+
+	// mmuEnabled is the bit of the control register turning address
+	// translation on.
+	mmuEnabled = 1 << 15
+)
+
+// PageFault is the error of an access to a page not mapped, or a store
+// to a page not writable.
+type PageFault struct {
+	// Address is the virtual address accessed.
+	Address uint16
+
+	// Write is set for stores.
+	Write bool
+}
+
+// Error describes the page fault.
+func (f *PageFault) Error() string {
+	access := "read"
+	if f.Write {
+		access = "write"
+	}
+
+	return fmt.Sprintf("page fault on %s of x%04X", access, f.Address)
+}
+
+// mmu is the memory management unit, seen by programs through its
+// memory-mapped registers.
+type mmu struct {
+	// control is the control register.
+	control uint16
+
+	// ptbr is the physical address of the page table.
+	ptbr uint16
+
+	// faultAddress is the virtual address of the last page fault.
+	faultAddress uint16
+
+	// faultPC is the address of the instruction of the last page
+	// fault.
+	faultPC uint16
+}
+
+// Addresses returns the registers of the MMU.
+func (m *mmu) Addresses() []uint16 {
+	return []uint16{registers.MRMMUCR, registers.MRPTBR, registers.MRMMUFA, registers.MRMMUPC}
+}
+
+// Read reads a register of the MMU.
+func (m *mmu) Read(address uint16) (uint16, error) {
+	switch address {
+	case registers.MRMMUCR:
+		return m.control, nil
+	case registers.MRPTBR:
+		return m.ptbr, nil
+	case registers.MRMMUFA:
+		return m.faultAddress, nil
+	default:
+		return m.faultPC, nil
+	}
+}
+
+// Write writes a register of the MMU, the fault address being read
+// only.
+func (m *mmu) Write(address uint16, val uint16) error {
+	switch address {
+	case registers.MRMMUCR:
+		m.control = val
+	case registers.MRPTBR:
+		m.ptbr = val
+	case registers.MRMMUPC:
+		m.faultPC = val
+	}
+
+	return nil
+}
+
+// WithMMU adds a simple MMU to the LC-3 for virtual memory coursework.
+// Programs set the physical address of a page table of 128 entries,
+// one per page of 512 words, in PTBR at xFE2A and turn translation on
+// with bit 15 of the control register at xFE28. An entry maps its page
+// to the physical page in its low 7 bits when bit 15, valid, is set,
+// and allows stores when bit 14, writable, is also set. The device
+// registers from xFE00 are never translated.
+//
+// Fetches, loads and stores to pages not mapped, and stores to pages
+// not writable, fault: the MMU records the virtual address at xFE2C
+// and the address of the instruction at xFE2E, turns translation off
+// and jumps to the handler in the exception vector table at x0103. RTI
+// turns translation back on and resumes the instruction, at the
+// address of xFE2E. Faults stop the program when there is no handler.
+// It has no effect on the LC-3b.
+func WithMMU() Option {
+	return func(c *cpu) {
+		c.mmu = &mmu{}
+	}
+}
+
+// installMMU maps the registers of the MMU and takes RTI to return from
+// page faults.
+func (c *cpu) installMMU() {
+	if c.mmu == nil || c.isa != LC3 {
+		c.mmu = nil
+		return
+	}
+
+	for _, address := range c.mmu.Addresses() {
+		c.devices[address] = c.mmu
+	}
+
+	c.ops = maps.Clone(c.ops)
+	c.ops[opcodes.OPRTI] = handleReturnFromFault
+}
+
+// translate maps a virtual address to a physical one.
+func (c *cpu) translate(address uint16, write bool) (uint16, error) {
+	if c.mmu == nil || c.mmu.control&mmuEnabled == 0 || address >= registers.MRKBSR {
+		return address, nil
+	}
+
+	entry := c.memory[c.mmu.ptbr+address/PageSize]
+
+	if entry&PTEValid == 0 || write && entry&PTEWritable == 0 {
+		return 0, &PageFault{Address: address, Write: write}
+	}
+
+	return (entry&PTEFrame)*PageSize + address%PageSize, nil
+}
+
+// fault takes the page fault handler for the error of an instruction,
+// returning the errors of other kinds or without a handler.
+func (c *cpu) fault(err error) error {
+	var pageFault *PageFault
+	if c.mmu == nil || !errors.As(err, &pageFault) {
+		return err
+	}
+
+	handler := c.memory[PageFaultVector]
+	if handler == 0 {
+		return err
+	}
+
+	c.mmu.faultAddress = pageFault.Address
+	c.mmu.faultPC = c.pc
+	c.mmu.control &^= mmuEnabled
+	c.registers[registers.RPC] = handler
+
+	return nil
+}
+
+// handleReturnFromFault handles RTI with the MMU, resuming the
+// instruction of the last page fault with translation on.
+func handleReturnFromFault(cpu *cpu) error {
+	cpu.mmu.control |= mmuEnabled
+	cpu.registers[registers.RPC] = cpu.mmu.faultPC
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package cpu
+
+import (
+	"errors"
+	"io"
+	"lc3/pkg/registers"
+	"strings"
+	"testing"
+)
+
+// TestMMU runs a store to a page not mapped, whose fault handler maps
+// it and resumes the store.
+func TestMMU(t *testing.T) {
+	c := NewCPU(WithMMU(), WithInput(strings.NewReader("")), WithOutput(io.Discard))
+
+	program := map[uint16]uint16{
+		0x0103: 0x0200, // page fault vector
+		0x0200: 0x2802, // LD R4, ENTRY
+		0x0201: 0xB802, // STI R4, SLOT
+		0x0202: 0x8000, // RTI
+		0x0203: PTEValid | PTEWritable | 0x21,
+		0x0204: 0x1000 + 0x4000/PageSize,
+		0x3000: 0x2203, // LD R1, PTR
+		0x3001: 0x7445, // STR R2, R1, #5
+		0x3002: 0x6A45, // LDR R5, R1, #5
+		0x3003: 0xF025, // HALT
+		0x3004: 0x4000, // PTR
+	}
+
+	for address, word := range program {
+		c.memory[address] = word
+	}
+
+	c.memory[0x1000+0x3000/PageSize] = PTEValid | 0x3000/PageSize
+
+	c.SetRegister(registers.RR2, 0x1234)
+	c.WriteMemory(registers.MRPTBR, 0x1000)
+	c.WriteMemory(registers.MRMMUCR, 0x8000)
+
+	for !c.Halted() {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("at x%04X: %v", c.pc, err)
+		}
+	}
+
+	if got := c.memory[0x21*PageSize+5]; got != 0x1234 {
+		t.Errorf("physical x4205 = x%04X, expected x1234", got)
+	}
+
+	if got := c.Register(registers.RR5); got != 0x1234 {
+		t.Errorf("R5 = x%04X, expected x1234", got)
+	}
+
+	if got, _ := c.ReadMemory(registers.MRMMUFA); got != 0x4005 {
+		t.Errorf("fault address x%04X, expected x4005", got)
+	}
+}
+
+// TestMMUWithoutHandler checks that faults stop programs without a
+// handler, and that read-only pages refuse stores.
+func TestMMUWithoutHandler(t *testing.T) {
+	c := NewCPU(WithMMU())
+
+	c.memory[0x1000+0x3000/PageSize] = PTEValid | 0x3000/PageSize
+	c.memory[0x3000] = 0x3000 // ST R0, #0
+
+	c.WriteMemory(registers.MRPTBR, 0x1000)
+	c.WriteMemory(registers.MRMMUCR, 0x8000)
+
+	var fault *PageFault
+	if err := c.Execute(); !errors.As(err, &fault) || !fault.Write || fault.Address != 0x3001 {
+		t.Errorf("error %v, expected a page fault on write of x3001", err)
+	}
+}
@@ -0,0 +1,64 @@
+package cpu
+
+import (
+	"errors"
+	"fmt"
+	"lc3/pkg/opcodes"
+)
+
+// ErrDivideByZero stops programs dividing by zero with the multiply and
+// divide extension.
+var ErrDivideByZero = errors.New("division by zero")
+
+// WithMulDiv runs the multiply and divide extension of the LC-3 on the
+// reserved opcode 1101, for courses allowing it:
+//
+//	1101 DR SR1 0 FF SR2
+//
+// with FF 00 for MUL, 01 for DIV and 10 for MOD, on signed words, the
+// condition codes set by the result. MUL keeps the low 16 bits of the
+// product, DIV truncates toward zero and MOD takes the sign of the
+// dividend. It has no effect on the LC-3b, whose shifts take the
+// opcode.
+func WithMulDiv() Option {
+	return func(c *cpu) {
+		c.mulDiv = true
+	}
+}
+
+// handleMulDiv handles the multiply and divide extension.
+func handleMulDiv(cpu *cpu) error {
+	if (cpu.instr>>5)&0x1 != 0 {
+		return fmt.Errorf("invalid multiply or divide %04X", cpu.instr)
+	}
+
+	dr := (cpu.instr >> 9) & 0x7
+	a := int16(cpu.registers[(cpu.instr>>6)&0x7])
+	b := int16(cpu.registers[cpu.instr&0x7])
+
+	var result int16
+
+	switch (cpu.instr >> 3) & 0x3 {
+	case opcodes.FNMUL:
+		result = a * b
+	case opcodes.FNDIV:
+		if b == 0 {
+			return ErrDivideByZero
+		}
+
+		result = a / b
+	case opcodes.FNMOD:
+		if b == 0 {
+			return ErrDivideByZero
+		}
+
+		result = a % b
+	default:
+		return fmt.Errorf("invalid multiply or divide %04X", cpu.instr)
+	}
+
+	cpu.registers[dr] = uint16(result)
+	cpu.updateFlags(dr)
+
+	return nil
+}
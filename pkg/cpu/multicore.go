@@ -0,0 +1,86 @@
+package cpu
+
+import (
+	"fmt"
+	"lc3/pkg/registers"
+)
+
+// WithSharedMemory makes the memory of the CPU that of another, for
+// machines of several cores each with its own registers, devices and
+// console. Loading either loads both.
+func WithSharedMemory(other CPU) Option {
+	return func(c *cpu) {
+		if o, ok := other.(*cpu); ok {
+			c.memory = o.memory
+			c.pages = o.pages
+		}
+	}
+}
+
+// WithTestAndSet runs the atomic test-and-set extension of the LC-3 on
+// the reserved opcode 1101, for teaching synchronization on several
+// cores:
+//
+//	1101 DR BaseR 1 00000
+//
+// loads DR from the address in BaseR, setting the condition codes, and
+// stores 1 there, in one instruction no other core can come between.
+// It shares the opcode with the multiply and divide extension, whose
+// instructions have bit 5 clear.
+func WithTestAndSet() Option {
+	return func(c *cpu) {
+		c.testAndSet = true
+	}
+}
+
+// RunCores runs CPUs sharing memory in turn, an instruction each, until
+// they have all halted. Cores start with their number in R0, counting
+// from 0, to tell themselves apart. A core waiting for input holds up
+// the others.
+func RunCores(cores ...CPU) error {
+	for i, core := range cores {
+		core.SetRegister(registers.RR0, uint16(i))
+	}
+
+	for running := true; running; {
+		running = false
+
+		for i, core := range cores {
+			if core.Halted() {
+				continue
+			}
+
+			if err := core.Execute(); err != nil {
+				return fmt.Errorf("core %d: %w", i, err)
+			}
+
+			running = true
+		}
+	}
+
+	return nil
+}
+
+// handleTestAndSet handles the test-and-set extension.
+func handleTestAndSet(cpu *cpu) error {
+	if cpu.instr&0x1F != 0 {
+		return fmt.Errorf("invalid test-and-set %04X", cpu.instr)
+	}
+
+	dr := (cpu.instr >> 9) & 0x7
+	address := cpu.registers[(cpu.instr>>6)&0x7]
+
+	val, err := cpu.memoryRead(address)
+	if err != nil {
+		return err
+	}
+
+	if err := cpu.memoryWrite(address, 1); err != nil {
+		return err
+	}
+
+	cpu.registers[dr] = val
+	cpu.updateFlags(dr)
+
+	return nil
+}
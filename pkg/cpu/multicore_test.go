@@ -0,0 +1,43 @@
+package cpu
+
+import (
+	"io"
+	"lc3/pkg/registers"
+	"strings"
+	"testing"
+)
+
+// TestRunCores runs two cores sharing memory, both taking a lock with
+// test-and-set, of which only the first finds it free.
+func TestRunCores(t *testing.T) {
+	first := NewCPU(WithTestAndSet(), WithInput(strings.NewReader("")), WithOutput(io.Discard))
+	second := NewCPU(WithTestAndSet(), WithSharedMemory(first), WithInput(strings.NewReader("")), WithOutput(io.Discard))
+
+	var image [MemoryMax]uint16
+	image[0x3000] = 0xE403 // LEA R2, LOCK
+	image[0x3001] = 0xD2A0 // TAS R1, R2
+	image[0x3002] = 0xF025 // HALT
+
+	first.Load(image)
+
+	if err := RunCores(first, second); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range []struct {
+		core     CPU
+		expected uint16
+	}{{first, 0}, {second, 1}} {
+		if got := tc.core.Register(registers.RR1); got != tc.expected {
+			t.Errorf("core %d: R1 = %d, expected %d", i, got, tc.expected)
+		}
+
+		if got := tc.core.Register(registers.RR0); got != uint16(i) {
+			t.Errorf("core %d: R0 = %d, expected its number", i, got)
+		}
+	}
+
+	if got := second.PeekMemory(0x3004); got != 1 {
+		t.Errorf("lock = %d, expected 1", got)
+	}
+}
@@ -0,0 +1,66 @@
+package cpu
+
+import "io"
+
+// Option configures a CPU when it is created.
+type Option func(c *cpu)
+
+// WithDevice maps a device into the memory of the CPU. A device
+// mapped later takes precedence over an earlier device that owns
+// the same address.
+func WithDevice(device Device) Option {
+	return func(c *cpu) {
+		for _, address := range device.Addresses() {
+			c.devices[address] = device
+		}
+
+		if ticker, ok := device.(Ticker); ok {
+			c.tickers = append(c.tickers, ticker)
+		}
+	}
+}
+
+// WithHostCall registers a host function that LC3 programs can
+// invoke through the HOSTCALL trap using the given call number.
+func WithHostCall(number uint16, fn HostFunc) Option {
+	return func(c *cpu) {
+		c.hostCalls[number] = fn
+	}
+}
+
+// TrapFunc handles a trap in place of the built-in handler, called
+// with R7 holding the return address and the PC past the TRAP.
+type TrapFunc func(c CPU) error
+
+// WithTrap registers a Go function handling a trap vector, replacing
+// its built-in handler if any.
+func WithTrap(vector uint16, fn TrapFunc) Option {
+	return func(c *cpu) {
+		c.traps[vector] = fn
+	}
+}
+
+// WithInput sets the stream console input is read from, by default
+// this is os.Stdin.
+func WithInput(r io.Reader) Option {
+	return func(c *cpu) {
+		c.input = r
+	}
+}
+
+// WithOutput sets the stream console output is written to, by
+// default this is os.Stdout.
+func WithOutput(w io.Writer) Option {
+	return func(c *cpu) {
+		c.output = w
+	}
+}
+
+// WithUTF8Output makes OUT and PUTS interpret characters above 0x7F
+// as Unicode code points and write them encoded as UTF-8, rather than
+// writing the low byte of each character.
+func WithUTF8Output() Option {
+	return func(c *cpu) {
+		c.utf8Output = true
+	}
+}
@@ -0,0 +1,105 @@
+package cpu
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"lc3/pkg/registers"
+)
+
+// snapshotMagic starts every snapshot file, followed by the version of
+// its format.
+const snapshotMagic = "LC3S"
+
+// snapshotVersion is the version of the snapshot file format.
+const snapshotVersion = 1
+
+// Snapshot is the state of a machine: its registers, its memory and
+// whether it has halted. The state of devices is not part of it.
+type Snapshot struct {
+	// Registers are the values of the registers, indexed like
+	// registers.RR0 to registers.RCOND.
+	Registers [registers.RCOUNT]uint16
+
+	// Memory is the contents of memory.
+	Memory [MemoryMax]uint16
+
+	// Halted reports whether the program has halted.
+	Halted bool
+}
+
+// Snapshot captures the state of the CPU.
+func (c *cpu) Snapshot() *Snapshot {
+	return &Snapshot{Registers: c.registers, Memory: *c.memory, Halted: c.halted}
+}
+
+// Restore puts the CPU back into the state of a snapshot, from which
+// execution continues exactly where it was taken.
+func (c *cpu) Restore(s *Snapshot) {
+	c.registers = s.Registers
+	*c.memory = s.Memory
+	c.pages.touchAll()
+
+	if c.jit != nil {
+		c.jit.flush()
+	}
+
+	c.halted = s.Halted
+}
+
+// WriteTo writes the snapshot in its binary file format: "LC3S", the
+// version of the format, a word set if the program halted, the
+// registers and the memory, every word big-endian.
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+
+	var halted uint16
+	if s.Halted {
+		halted = 1
+	}
+
+	bw.WriteString(snapshotMagic)
+
+	for _, v := range []any{uint16(snapshotVersion), halted, s.Registers, s.Memory} {
+		if err := binary.Write(bw, binary.BigEndian, v); err != nil {
+			return 0, err
+		}
+	}
+
+	n := int64(len(snapshotMagic) + 2*(2+len(s.Registers)+len(s.Memory)))
+
+	return n, bw.Flush()
+}
+
+// ReadSnapshot reads a snapshot written by WriteTo.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != snapshotMagic {
+		return nil, fmt.Errorf("not a snapshot")
+	}
+
+	var header struct {
+		Version, Halted uint16
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("truncated snapshot: %w", err)
+	}
+
+	if header.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+
+	s := &Snapshot{Halted: header.Halted != 0}
+
+	if err := binary.Read(r, binary.BigEndian, &s.Registers); err != nil {
+		return nil, fmt.Errorf("truncated snapshot: %w", err)
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &s.Memory); err != nil {
+		return nil, fmt.Errorf("truncated snapshot: %w", err)
+	}
+
+	return s, nil
+}
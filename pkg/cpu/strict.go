@@ -0,0 +1,91 @@
+package cpu
+
+import (
+	"fmt"
+	"lc3/pkg/opcodes"
+	"maps"
+)
+
+// WithStrictEncoding stops the LC-3 on instructions whose bits the
+// specification fixes but the processor ignores are not as specified,
+// such as bits 5 to 0 of NOT, which must be 1s, or the unused bits of
+// JMP, which must be 0s, so that corrupted images and encoder bugs show
+// up where they happen. Opcodes the embedder handles are not checked.
+func WithStrictEncoding() Option {
+	return func(c *cpu) {
+		c.strict = true
+	}
+}
+
+// IllegalInstruction is the error of an instruction whose fixed bits
+// are not as specified.
+type IllegalInstruction struct {
+	// Address is the address of the instruction.
+	Address uint16
+
+	// Instruction is the instruction.
+	Instruction uint16
+
+	// Reason says which bits are wrong.
+	Reason string
+}
+
+// Error describes the illegal instruction.
+func (e *IllegalInstruction) Error() string {
+	return fmt.Sprintf("illegal instruction x%04X at x%04X: %s", e.Instruction, e.Address, e.Reason)
+}
+
+// installStrict checks the fixed bits of every instruction before its
+// handler runs.
+func (c *cpu) installStrict() {
+	if !c.strict || c.isa != LC3 {
+		return
+	}
+
+	ops := maps.Clone(c.ops)
+
+	for op, fn := range c.ops {
+		ops[op] = func(c *cpu) error {
+			if reason := illegalBits(c.instr); reason != "" {
+				return &IllegalInstruction{Address: c.pc, Instruction: c.instr, Reason: reason}
+			}
+
+			return fn(c)
+		}
+	}
+
+	c.ops = ops
+}
+
+// illegalBits returns which fixed bits of an LC-3 instruction are not
+// as specified, or "" when they all are.
+func illegalBits(instr uint16) string {
+	switch instr >> 12 {
+	case opcodes.OPADD, opcodes.OPAND:
+		if instr&0x20 == 0 && instr&0x18 != 0 {
+			return "bits 4 and 3 of a register operand must be 0"
+		}
+	case opcodes.OPNOT:
+		if instr&0x3F != 0x3F {
+			return "bits 5 to 0 of NOT must be 1"
+		}
+	case opcodes.OPJMP:
+		if instr&0x0E3F != 0 {
+			return "bits 11 to 9 and 5 to 0 of JMP must be 0"
+		}
+	case opcodes.OPJSR:
+		if instr&0x0800 == 0 && instr&0x063F != 0 {
+			return "bits 10, 9 and 5 to 0 of JSRR must be 0"
+		}
+	case opcodes.OPTRAP:
+		if instr&0x0F00 != 0 {
+			return "bits 11 to 8 of TRAP must be 0"
+		}
+	case opcodes.OPRTI:
+		if instr&0x0FFF != 0 {
+			return "bits 11 to 0 of RTI must be 0"
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,62 @@
+package cpu
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestStrictEncoding checks that instructions with their fixed bits
+// wrong stop the program only in the strict mode, with or without the
+// JIT, and that well-formed ones run.
+func TestStrictEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		instr   uint16
+		illegal bool
+	}{
+		{0x127F, false}, // ADD R1, R1, #-1
+		{0x1242, false}, // ADD R1, R1, R2
+		{0x124A, true},  // ADD R1, R1, R2 with bit 3 set
+		{0x927F, false}, // NOT R1, R1
+		{0x9240, true},  // NOT R1, R1 with bits 5 to 0 clear
+		{0xC080, false}, // JMP R2
+		{0xC081, true},  // JMP R2 with bit 0 set
+		{0xC880, true},  // JMP R2 with bit 11 set
+		{0x4080, false}, // JSRR R2
+		{0x4280, true},  // JSRR R2 with bit 9 set
+		{0xF025, false}, // HALT
+		{0xF125, true},  // HALT with bit 8 set
+	} {
+		for _, jit := range []bool{false, true} {
+			opts := []Option{WithStrictEncoding(), WithInput(strings.NewReader("")), WithOutput(io.Discard)}
+			if jit {
+				opts = append(opts, WithBlockJIT())
+			}
+
+			var image [MemoryMax]uint16
+			image[0x3000] = tc.instr
+			image[0x3001] = 0xF025 // HALT
+			image[0x4000] = 0xF025 // HALT
+
+			c := NewCPU(opts...)
+			c.SetRegister(2, 0x4000)
+
+			err := c.Run(image)
+
+			var illegal *IllegalInstruction
+			if got := errors.As(err, &illegal); got != tc.illegal {
+				t.Errorf("x%04X, jit %t: error %v, expected illegal %t", tc.instr, jit, err, tc.illegal)
+			} else if got && (illegal.Address != 0x3000 || illegal.Instruction != tc.instr) {
+				t.Errorf("x%04X, jit %t: %v", tc.instr, jit, err)
+			}
+		}
+
+		c := NewCPU(WithInput(strings.NewReader("")), WithOutput(io.Discard))
+		c.memory[0x3000] = tc.instr
+
+		if err := c.Execute(); err != nil && tc.illegal {
+			t.Errorf("x%04X stopped without the strict mode: %v", tc.instr, err)
+		}
+	}
+}
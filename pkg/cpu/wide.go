@@ -0,0 +1,112 @@
+package cpu
+
+import (
+	"fmt"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+)
+
+// WithWideRegisters runs the experimental mode of 16 registers, for
+// exploring how register pressure shapes code. R8 to R15 are reached
+// through a prefix on the reserved opcode, in the encoding the multiply
+// and divide extension leaves unused:
+//
+//	1101 000000 011 DST
+//
+// whose bits D, S and T move the DR or SR, SR1 or BaseR and SR2
+// fields of the next instruction to R8 to R15. An instruction may not
+// use both a register and the one 8 above it, nor JSR or JSRR R15,
+// which link R7. It has no effect on the LC-3b and LC-2.
+func WithWideRegisters() Option {
+	return func(c *cpu) {
+		c.wideRegisters = true
+	}
+}
+
+// isPrefix reports whether the instruction is the prefix of the mode of
+// 16 registers.
+func (c *cpu) isPrefix() bool {
+	return c.wideRegisters && c.op == opcodes.OPRES && c.instr&0x0FF8 == 0x0018
+}
+
+// registerFields returns the fields of the instruction naming
+// registers, D, S and T from bit 2, along with whether it links R7.
+func (c *cpu) registerFields() (fields uint16, link bool) {
+	const d, s, t = 1 << 2, 1 << 1, 1 << 0
+
+	registerMode := (c.instr>>5)&0x1 == 0
+
+	switch c.op {
+	case opcodes.OPADD, opcodes.OPAND, opcodes.OPRES:
+		fields = d | s
+		if registerMode {
+			fields |= t
+		}
+	case opcodes.OPNOT, opcodes.OPLDR, opcodes.OPSTR:
+		fields = d | s
+	case opcodes.OPLD, opcodes.OPLDI, opcodes.OPLEA, opcodes.OPST, opcodes.OPSTI:
+		fields = d
+	case opcodes.OPJMP:
+		fields = s
+	case opcodes.OPJSR:
+		if (c.instr>>11)&0x1 == 0 {
+			fields = s
+		}
+
+		link = true
+	}
+
+	return fields, link
+}
+
+// dispatchWide runs an instruction following a prefix, swapping the
+// registers it moves to R8 to R15 in for it.
+func (c *cpu) dispatchWide(fn func(cpu *cpu) error) error {
+	banks := c.prefix
+	c.prefix = 0
+
+	fields, link := c.registerFields()
+
+	var high, low uint16
+
+	for i, shift := range []uint16{9, 6, 0} {
+		bit := uint16(1) << (2 - i)
+		if fields&bit == 0 {
+			continue
+		}
+
+		r := (c.instr >> shift) & 0x7
+		if banks&bit != 0 {
+			high |= 1 << r
+		} else {
+			low |= 1 << r
+		}
+	}
+
+	if link {
+		low |= 1 << registers.RR7
+	}
+
+	if conflict := high & low; conflict != 0 {
+		for r := uint16(0); r < 8; r++ {
+			if conflict&(1<<r) != 0 {
+				return fmt.Errorf("R%d and R%d cannot be used in one instruction", r, r+8)
+			}
+		}
+	}
+
+	c.swapHigh(high)
+	err := fn(c)
+	c.swapHigh(high)
+
+	return err
+}
+
+// swapHigh swaps the registers of a set with those 8 above them.
+func (c *cpu) swapHigh(set uint16) {
+	for r := 0; r < 8; r++ {
+		if set&(1<<r) != 0 {
+			c.registers[r], c.high[r] = c.high[r], c.registers[r]
+		}
+	}
+}
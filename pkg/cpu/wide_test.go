@@ -0,0 +1,53 @@
+package cpu
+
+import (
+	"io"
+	"lc3/pkg/registers"
+	"strings"
+	"testing"
+)
+
+// TestWideRegisters runs instructions prefixed to reach R8 to R15.
+func TestWideRegisters(t *testing.T) {
+	c := NewCPU(WithWideRegisters(), WithInput(strings.NewReader("")), WithOutput(io.Discard))
+
+	program := map[uint16]uint16{
+		0x3000: 0xD01C, // prefix D
+		0x3001: 0x1225, // ADD R9, R0, #5
+		0x3002: 0xD01F, // prefix D, S, T
+		0x3003: 0x1441, // ADD R10, R9, R9
+		0x3004: 0xD01A, // prefix S
+		0x3005: 0x1680, // ADD R3, R10, R0
+		0x3006: 0xD01C, // prefix D
+		0x3007: 0x1240, // ADD R9, R1, R0
+		0x3008: 0xF025, // HALT
+	}
+
+	for address, word := range program {
+		c.memory[address] = word
+	}
+
+	for i := 0; i < 6; i++ {
+		if err := c.Execute(); err != nil {
+			t.Fatalf("at x%04X: %v", c.pc, err)
+		}
+	}
+
+	if c.high[1] != 5 || c.high[2] != 10 {
+		t.Errorf("R9 = %d, R10 = %d, expected 5 and 10", c.high[1], c.high[2])
+	}
+
+	if got := c.Register(registers.RR3); got != 10 {
+		t.Errorf("R3 = %d, expected 10", got)
+	}
+
+	if got := c.Register(registers.RR1); got != 0 {
+		t.Errorf("R1 = %d, expected to be left alone", got)
+	}
+
+	c.Execute()
+
+	if err := c.Execute(); err == nil || !strings.Contains(err.Error(), "R1 and R9") {
+		t.Errorf("error %v, expected R1 and R9 to conflict", err)
+	}
+}
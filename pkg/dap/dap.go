@@ -0,0 +1,817 @@
+// Package dap implements the Debug Adapter Protocol on top of the
+// debugger, so that editors such as VS Code can debug LC3 programs
+// running on the virtual machine.
+//
+// Programs assembled with debug info are debugged in their source,
+// others in the disassembly view of the editor. Memory references are LC3 word addresses such as
+// 0x3000 and every word is transferred as two big-endian bytes.
+package dap
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"lc3/pkg/debugger"
+	"lc3/pkg/disasm"
+	"lc3/pkg/registers"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ExecPrefix marks an expression typed into the debug console as
+// a debugger command, "-exec break x3000" for example.
+const ExecPrefix = "-exec "
+
+const (
+	// registersReference is the variables reference of the registers.
+	registersReference = 1
+
+	// memoryReference is the variables reference of the memory, which
+	// is split into blocks.
+	memoryReference = 2
+
+	// blockReference is the variables reference of the first block
+	// of memory, the following blocks have consecutive references.
+	blockReference = 0x100
+
+	// blockSize is the number of words in a block of memory.
+	blockSize = 0x100
+
+	// maxInstructions is the most instructions disassembled at once,
+	// every word of memory.
+	maxInstructions = 0x10000
+)
+
+// Launcher loads a program for a launch or attach request. Program
+// output is written to stdout and debugger command output to console.
+type Launcher func(args LaunchArguments, stdout, console io.Writer) (*debugger.Debugger, error)
+
+// Serve speaks the Debug Adapter Protocol over conn until the client
+// disconnects, using launch to load the program.
+func Serve(conn io.ReadWriter, launch Launcher) error {
+	s := &session{
+		in:          bufio.NewReader(conn),
+		out:         conn,
+		launch:      launch,
+		breakpoints: map[string][]int{},
+	}
+
+	return s.serve()
+}
+
+// session is a single connection to a client.
+type session struct {
+	// in reads requests from the client.
+	in *bufio.Reader
+
+	// out writes responses and events to the client.
+	out io.Writer
+
+	// mu serializes writes to out and guards seq.
+	mu sync.Mutex
+
+	// seq is the sequence number of the last message sent.
+	seq int
+
+	// launch loads the program.
+	launch Launcher
+
+	// dbg is the debugger controlling the program, nil until it is
+	// launched.
+	dbg *debugger.Debugger
+
+	// stopOnEntry is set if the program is stopped before its first
+	// instruction once configuration is done.
+	stopOnEntry bool
+
+	// resume is the run requested by the current request, which is
+	// started once the request has been answered.
+	resume func() debugger.Stop
+
+	// busy is closed when the program stops running, it is nil while
+	// the program is stopped.
+	busy chan struct{}
+
+	// breakpoints maps the kinds of breakpoints set by the client to
+	// the IDs of the debugger breakpoints, which are replaced as
+	// a whole on every request.
+	breakpoints map[string][]int
+}
+
+// serve handles requests until the client disconnects.
+func (s *session) serve() error {
+	for {
+		msg, err := readMessage(s.in)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		// only a few requests may be handled while the program runs.
+		switch msg.Command {
+		case "pause", "threads", "disconnect", "terminate":
+		default:
+			s.wait()
+		}
+
+		body, err := s.handle(msg)
+		if err := s.respond(msg, body, err); err != nil {
+			return err
+		}
+
+		switch msg.Command {
+		case "launch", "attach":
+			if err == nil {
+				s.event("initialized", nil)
+			}
+		case "configurationDone":
+			s.start()
+		case "disconnect":
+			return nil
+		}
+
+		if s.resume != nil {
+			s.run(s.resume)
+			s.resume = nil
+		}
+	}
+}
+
+// handle executes a request and returns the body of the response.
+func (s *session) handle(req *request) (any, error) {
+	if s.dbg == nil {
+		switch req.Command {
+		case "initialize", "launch", "attach", "disconnect":
+		default:
+			return nil, fmt.Errorf("no program has been launched")
+		}
+	}
+
+	switch req.Command {
+	case "initialize":
+		return capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsFunctionBreakpoints:      true,
+			SupportsConditionalBreakpoints:   true,
+			SupportsInstructionBreakpoints:   true,
+			SupportsDisassembleRequest:       true,
+			SupportsReadMemoryRequest:        true,
+			SupportsSetVariable:              true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		}, nil
+	case "launch", "attach":
+		return nil, s.handleLaunch(req)
+	case "configurationDone":
+		return nil, nil
+	case "setBreakpoints":
+		return s.handleSetBreakpoints(req)
+	case "setFunctionBreakpoints":
+		return s.handleSetFunctionBreakpoints(req)
+	case "setInstructionBreakpoints":
+		return s.handleSetInstructionBreakpoints(req)
+	case "setExceptionBreakpoints":
+		return map[string]any{}, nil
+	case "threads":
+		return map[string]any{
+			"threads": []map[string]any{{"id": 1, "name": "LC3"}},
+		}, nil
+	case "stackTrace":
+		return s.handleStackTrace()
+	case "scopes":
+		return map[string]any{
+			"scopes": []scope{
+				{Name: "Registers", VariablesReference: registersReference},
+				{Name: "Memory", VariablesReference: memoryReference, Expensive: true},
+			},
+		}, nil
+	case "variables":
+		return s.handleVariables(req)
+	case "setVariable":
+		return s.handleSetVariable(req)
+	case "evaluate":
+		return s.handleEvaluate(req)
+	case "disassemble":
+		return s.handleDisassemble(req)
+	case "readMemory":
+		return s.handleReadMemory(req)
+	case "continue":
+		s.resume = s.dbg.Continue
+		return map[string]any{"allThreadsContinued": true}, nil
+	case "next":
+		s.resume = s.dbg.Next
+		return nil, nil
+	case "stepIn":
+		s.resume = func() debugger.Stop { return s.dbg.Step(1) }
+		return nil, nil
+	case "stepOut":
+		s.resume = s.dbg.Finish
+		return nil, nil
+	case "pause":
+		s.dbg.Interrupt()
+		return nil, nil
+	case "disconnect", "terminate":
+		if s.dbg != nil {
+			s.dbg.Interrupt()
+			s.wait()
+		}
+
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("unsupported request %q", req.Command)
+}
+
+// handleLaunch loads the program.
+func (s *session) handleLaunch(req *request) error {
+	var args LaunchArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return err
+	}
+
+	if args.Program == "" {
+		return fmt.Errorf("no program given")
+	}
+
+	dbg, err := s.launch(args, s.output("stdout"), s.output("console"))
+	if err != nil {
+		return err
+	}
+
+	s.dbg = dbg
+	s.stopOnEntry = args.StopOnEntry && req.Command == "launch"
+
+	return nil
+}
+
+// start starts the program once configuration is done.
+func (s *session) start() {
+	if s.stopOnEntry {
+		s.event("stopped", map[string]any{
+			"reason":            "entry",
+			"threadId":          1,
+			"allThreadsStopped": true,
+		})
+
+		return
+	}
+
+	s.run(s.dbg.Continue)
+}
+
+// run executes f in the background and reports the stop once it
+// returns.
+func (s *session) run(f func() debugger.Stop) {
+	busy := make(chan struct{})
+	s.busy = busy
+
+	go func() {
+		defer close(busy)
+
+		s.stopped(f())
+	}()
+}
+
+// wait waits for the program to stop running.
+func (s *session) wait() {
+	if s.busy != nil {
+		<-s.busy
+		s.busy = nil
+	}
+}
+
+// stopped reports a stop to the client.
+func (s *session) stopped(stop debugger.Stop) {
+	body := map[string]any{
+		"threadId":          1,
+		"allThreadsStopped": true,
+	}
+
+	switch stop.Reason {
+	case debugger.StopHalt:
+		s.event("exited", map[string]any{"exitCode": 0})
+		s.event("terminated", nil)
+
+		return
+	case debugger.StopBreakpoint:
+		body["reason"] = "breakpoint"
+		body["hitBreakpointIds"] = []int{stop.Breakpoint.ID}
+
+		if stop.Err != nil {
+			body["text"] = stop.Err.Error()
+		}
+	case debugger.StopTrap:
+		body["reason"] = "breakpoint"
+		body["hitBreakpointIds"] = []int{stop.TrapBreakpoint.ID}
+	case debugger.StopWatchpoint, debugger.StopRegisterWatch:
+		body["reason"] = "data breakpoint"
+	case debugger.StopInterrupt:
+		body["reason"] = "pause"
+	case debugger.StopError:
+		body["reason"] = "exception"
+		body["description"] = "Program failed"
+		body["text"] = stop.Err.Error()
+	default:
+		body["reason"] = "step"
+	}
+
+	s.event("stopped", body)
+}
+
+// replaceBreakpoints deletes the breakpoints of a kind set by the
+// previous request, the new ones are recorded by the caller.
+func (s *session) replaceBreakpoints(kind string) {
+	for _, id := range s.breakpoints[kind] {
+		s.dbg.DeleteBreakpoint(id)
+	}
+
+	s.breakpoints[kind] = nil
+}
+
+// addBreakpoint adds a breakpoint of a kind with an optional condition.
+func (s *session) addBreakpoint(kind string, address uint16, condition string) breakpoint {
+	bp := s.dbg.AddBreakpoint(address)
+
+	s.breakpoints[kind] = append(s.breakpoints[kind], bp.ID)
+
+	result := breakpoint{
+		ID:                   bp.ID,
+		Verified:             true,
+		InstructionReference: reference(address),
+	}
+
+	if err := s.dbg.SetCondition(bp.ID, condition); err != nil {
+		result.Verified = false
+		result.Message = err.Error()
+	}
+
+	return result
+}
+
+// handleSetBreakpoints sets breakpoints on lines of a source file,
+// which requires the debug info of the program.
+func (s *session) handleSetBreakpoints(req *request) (any, error) {
+	var args struct {
+		Source      source `json:"source"`
+		Breakpoints []struct {
+			Line      int    `json:"line"`
+			Condition string `json:"condition"`
+		} `json:"breakpoints"`
+	}
+
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	kind := "source " + args.Source.Path
+	s.replaceBreakpoints(kind)
+
+	info := s.dbg.DebugInfo()
+
+	result := []breakpoint{}
+	for _, b := range args.Breakpoints {
+		address, ok := info.Address(args.Source.Path, b.Line)
+		if !ok {
+			result = append(result, breakpoint{Message: "no code at this line, or no debug info for the program"})
+			continue
+		}
+
+		bp := s.addBreakpoint(kind, address, b.Condition)
+		bp.Source, bp.Line = s.location(address)
+
+		result = append(result, bp)
+	}
+
+	return map[string]any{"breakpoints": result}, nil
+}
+
+// location returns the source and line an address was assembled from,
+// if known.
+func (s *session) location(address uint16) (*source, int) {
+	location, ok := s.dbg.DebugInfo().Location(address)
+	if !ok {
+		return nil, 0
+	}
+
+	return &source{Name: filepath.Base(location.File), Path: location.File}, location.Line
+}
+
+// handleSetFunctionBreakpoints sets breakpoints on labels or addresses.
+func (s *session) handleSetFunctionBreakpoints(req *request) (any, error) {
+	var args struct {
+		Breakpoints []struct {
+			Name      string `json:"name"`
+			Condition string `json:"condition"`
+		} `json:"breakpoints"`
+	}
+
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	s.replaceBreakpoints("function")
+
+	result := []breakpoint{}
+	for _, b := range args.Breakpoints {
+		address, err := s.resolve(b.Name)
+		if err != nil {
+			result = append(result, breakpoint{Message: err.Error()})
+			continue
+		}
+
+		result = append(result, s.addBreakpoint("function", address, b.Condition))
+	}
+
+	return map[string]any{"breakpoints": result}, nil
+}
+
+// handleSetInstructionBreakpoints sets breakpoints on addresses chosen
+// in the disassembly view.
+func (s *session) handleSetInstructionBreakpoints(req *request) (any, error) {
+	var args struct {
+		Breakpoints []struct {
+			InstructionReference string `json:"instructionReference"`
+			Offset               int    `json:"offset"`
+			Condition            string `json:"condition"`
+		} `json:"breakpoints"`
+	}
+
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	s.replaceBreakpoints("instruction")
+
+	result := []breakpoint{}
+	for _, b := range args.Breakpoints {
+		address, err := parseReference(b.InstructionReference)
+		if err != nil {
+			result = append(result, breakpoint{Message: err.Error()})
+			continue
+		}
+
+		result = append(result, s.addBreakpoint("instruction", address+uint16(b.Offset), b.Condition))
+	}
+
+	return map[string]any{"breakpoints": result}, nil
+}
+
+// handleStackTrace returns the frames of the shadow call stack,
+// innermost first.
+func (s *session) handleStackTrace() (any, error) {
+	frames := s.dbg.Stack()
+	table := s.dbg.Symbols()
+
+	pc := s.dbg.CPU().Register(registers.RPC)
+
+	result := []stackFrame{}
+	for i := len(frames); i >= 0; i-- {
+		frame := stackFrame{
+			ID:                          len(result) + 1,
+			Name:                        table.Format(pc),
+			InstructionPointerReference: reference(pc),
+		}
+
+		frame.Source, frame.Line = s.location(pc)
+		if frame.Source != nil {
+			frame.Column = 1
+		}
+
+		result = append(result, frame)
+
+		if i > 0 {
+			pc = frames[i-1].Call
+		}
+	}
+
+	return map[string]any{
+		"stackFrames": result,
+		"totalFrames": len(result),
+	}, nil
+}
+
+// handleVariables lists the registers, the blocks of memory or the
+// words of a block.
+func (s *session) handleVariables(req *request) (any, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	cpu := s.dbg.CPU()
+	result := []variable{}
+
+	switch ref := args.VariablesReference; {
+	case ref == registersReference:
+		for r := uint16(registers.RR0); r <= registers.RR7; r++ {
+			result = append(result, variable{Name: fmt.Sprintf("R%d", r), Value: formatWord(cpu.Register(r))})
+		}
+
+		pc := cpu.Register(registers.RPC)
+		result = append(result,
+			variable{Name: "PC", Value: formatWord(pc), MemoryReference: reference(pc)},
+			variable{Name: "CC", Value: debugger.ConditionCodes(cpu.Register(registers.RCOND))},
+		)
+	case ref == memoryReference:
+		for block := 0; block < 0x10000/blockSize; block++ {
+			start := block * blockSize
+
+			result = append(result, variable{
+				Name:               fmt.Sprintf("x%04X-x%04X", start, start+blockSize-1),
+				VariablesReference: blockReference + block,
+				MemoryReference:    reference(uint16(start)),
+			})
+		}
+	case ref >= blockReference && ref < blockReference+0x10000/blockSize:
+		start := (ref - blockReference) * blockSize
+
+		for address := start; address < start+blockSize; address++ {
+			word := cpu.PeekMemory(uint16(address))
+
+			result = append(result, variable{
+				Name:            fmt.Sprintf("x%04X", address),
+				Value:           fmt.Sprintf("%s  %s", formatWord(word), disasm.Format(uint16(address), word)),
+				MemoryReference: reference(uint16(address)),
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unknown variables reference %d", ref)
+	}
+
+	return map[string]any{"variables": result}, nil
+}
+
+// handleSetVariable sets a register or memory word to the value of an
+// expression.
+func (s *session) handleSetVariable(req *request) (any, error) {
+	var args struct {
+		VariablesReference int    `json:"variablesReference"`
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+	}
+
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	value, err := s.dbg.Evaluate(args.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	val := uint16(value)
+	cpu := s.dbg.CPU()
+
+	switch ref := args.VariablesReference; {
+	case ref == registersReference:
+		r, ok := registers.Lookup(args.Name)
+		if !ok || r == registers.RCOND {
+			return nil, fmt.Errorf("register %s cannot be set", args.Name)
+		}
+
+		cpu.SetRegister(r, val)
+	case ref >= blockReference:
+		address, err := strconv.ParseUint(strings.TrimPrefix(args.Name, "x"), 16, 16)
+		if err != nil {
+			return nil, err
+		}
+
+		cpu.PokeMemory(uint16(address), val)
+	default:
+		return nil, fmt.Errorf("%s cannot be set", args.Name)
+	}
+
+	return map[string]any{"value": formatWord(val)}, nil
+}
+
+// handleEvaluate evaluates an expression, or runs a debugger command
+// typed into the debug console with ExecPrefix.
+func (s *session) handleEvaluate(req *request) (any, error) {
+	var args struct {
+		Expression string `json:"expression"`
+		Context    string `json:"context"`
+	}
+
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	if command, ok := strings.CutPrefix(args.Expression, ExecPrefix); ok && args.Context == "repl" {
+		if err := s.dbg.Exec(command); err != nil {
+			return nil, err
+		}
+
+		return map[string]any{"result": "", "variablesReference": 0}, nil
+	}
+
+	if address, ok := s.dbg.Symbols().Address(args.Expression); ok {
+		return map[string]any{
+			"result":             fmt.Sprintf("x%04X", address),
+			"variablesReference": 0,
+			"memoryReference":    reference(address),
+		}, nil
+	}
+
+	value, err := s.dbg.Evaluate(args.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"result":             fmt.Sprintf("x%04X (%d)", uint16(value), value),
+		"variablesReference": 0,
+	}, nil
+}
+
+// handleDisassemble disassembles the words around a memory reference.
+// Offsets are given in bytes, two per word, and counts beyond the size
+// of memory are cut to it.
+func (s *session) handleDisassemble(req *request) (any, error) {
+	var args struct {
+		MemoryReference   string `json:"memoryReference"`
+		Offset            int    `json:"offset"`
+		InstructionOffset int    `json:"instructionOffset"`
+		InstructionCount  int    `json:"instructionCount"`
+	}
+
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	if args.InstructionCount < 0 {
+		return nil, fmt.Errorf("invalid instruction count %d", args.InstructionCount)
+	}
+
+	base, err := parseReference(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+
+	start := int(base) + args.Offset/2 + args.InstructionOffset
+	count := min(args.InstructionCount, maxInstructions)
+	table := s.dbg.Symbols()
+
+	result := make([]disassembledInstruction, 0, count)
+	for i := 0; i < count; i++ {
+		address := uint16(start + i)
+		word := s.dbg.CPU().PeekMemory(address)
+
+		label, _ := table.Name(address)
+
+		instruction := disassembledInstruction{
+			Address:          reference(address),
+			InstructionBytes: fmt.Sprintf("%04X", word),
+			Instruction:      disasm.Format(address, word),
+			Symbol:           label,
+		}
+
+		instruction.Location, instruction.Line = s.location(address)
+
+		result = append(result, instruction)
+	}
+
+	return map[string]any{"instructions": result}, nil
+}
+
+// handleReadMemory reads memory as big-endian bytes.
+func (s *session) handleReadMemory(req *request) (any, error) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Count           int    `json:"count"`
+	}
+
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return nil, err
+	}
+
+	base, err := parseReference(args.MemoryReference)
+	if err != nil {
+		return nil, err
+	}
+
+	start := int(base)*2 + args.Offset
+	count := min(args.Count, 0x20000-start)
+
+	buf := make([]byte, max(count, 0))
+	for i := range buf {
+		word := s.dbg.CPU().PeekMemory(uint16((start + i) / 2))
+
+		if (start+i)%2 == 0 {
+			buf[i] = byte(word >> 8)
+		} else {
+			buf[i] = byte(word)
+		}
+	}
+
+	return map[string]any{
+		"address": reference(uint16(start / 2)),
+		"data":    base64.StdEncoding.EncodeToString(buf),
+	}, nil
+}
+
+// resolve resolves a label or an expression to an address.
+func (s *session) resolve(name string) (uint16, error) {
+	if address, ok := s.dbg.Symbols().Address(name); ok {
+		return address, nil
+	}
+
+	value, err := s.dbg.Evaluate(name)
+	if err != nil {
+		return 0, fmt.Errorf("unknown label or address %q", name)
+	}
+
+	return uint16(value), nil
+}
+
+// respond sends the response to a request.
+func (s *session) respond(req *request, body any, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+
+	resp := response{
+		Seq:        s.seq,
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    err == nil,
+		Command:    req.Command,
+		Body:       body,
+	}
+
+	if err != nil {
+		resp.Message = err.Error()
+	}
+
+	return writeMessage(s.out, resp)
+}
+
+// event sends an event.
+func (s *session) event(name string, body any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+
+	return writeMessage(s.out, event{
+		Seq:   s.seq,
+		Type:  "event",
+		Event: name,
+		Body:  body,
+	})
+}
+
+// output returns a writer sending output events of a category.
+func (s *session) output(category string) io.Writer {
+	return outputWriter{session: s, category: category}
+}
+
+// outputWriter sends everything written as output events.
+type outputWriter struct {
+	session  *session
+	category string
+}
+
+// Write sends b as an output event.
+func (w outputWriter) Write(b []byte) (int, error) {
+	err := w.session.event("output", map[string]any{
+		"category": w.category,
+		"output":   string(b),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// reference formats an address as a memory reference.
+func reference(address uint16) string {
+	return fmt.Sprintf("0x%04X", address)
+}
+
+// parseReference parses a memory reference.
+func parseReference(ref string) (uint16, error) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(ref), "0x"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory reference %q", ref)
+	}
+
+	return uint16(n), nil
+}
+
+// formatWord formats the value of a register or memory word.
+func formatWord(word uint16) string {
+	return fmt.Sprintf("x%04X (%d)", word, int16(word))
+}
@@ -0,0 +1,364 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"lc3/pkg/debugger"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// program counts R1 up to 3 and halts.
+const program = `
+		.ORIG x3000
+MAIN	AND R1, R1, #0
+LOOP	ADD R1, R1, #1
+		ADD R2, R1, #-3
+		BRn LOOP
+		HALT
+		.END`
+
+// message is a response or event received by the client.
+type message struct {
+	Type       string          `json:"type"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Command    string          `json:"command"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// client is the editor side of a session.
+type client struct {
+	t *testing.T
+
+	// conn is the connection to the adapter.
+	conn net.Conn
+
+	// r buffers reads from conn.
+	r *bufio.Reader
+
+	// seq is the sequence number of the last request.
+	seq int
+
+	// events are the events received so far, in order.
+	events []message
+}
+
+// launch loads program into a debugger.
+func launch() Launcher {
+	return func(args LaunchArguments, stdout, console io.Writer) (*debugger.Debugger, error) {
+		obj, table, _, err := asm.Assemble(strings.NewReader(program))
+		if err != nil {
+			return nil, err
+		}
+
+		var image [cpu.MemoryMax]uint16
+		copy(image[obj.Origin:], obj.Words)
+
+		newCPU := func() cpu.CPU {
+			return cpu.NewCPU(cpu.WithOutput(stdout))
+		}
+
+		d := debugger.New(newCPU, image, nil, console)
+		d.SetSymbols(table)
+
+		return d, nil
+	}
+}
+
+// connect serves a session and returns a client connected to it.
+func connect(t *testing.T) *client {
+	t.Helper()
+
+	adapter, conn := net.Pipe()
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	go func() {
+		defer adapter.Close()
+		Serve(adapter, launch())
+	}()
+
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// receive reads the next message.
+func (c *client) receive() message {
+	c.t.Helper()
+
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.t.Fatal(err)
+	}
+
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return msg
+}
+
+// send writes raw data to the adapter.
+func (c *client) send(data string) {
+	c.t.Helper()
+
+	if _, err := io.WriteString(c.conn, data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// request sends a request and returns its response, keeping the events
+// received before it.
+func (c *client) request(command string, args any) message {
+	c.t.Helper()
+
+	c.seq++
+
+	body, err := json.Marshal(map[string]any{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	c.send(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body))
+
+	for {
+		msg := c.receive()
+		if msg.Type == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+
+		if msg.RequestSeq != c.seq || msg.Command != command {
+			c.t.Fatalf("response to %s %d, expected %s %d", msg.Command, msg.RequestSeq, command, c.seq)
+		}
+
+		return msg
+	}
+}
+
+// succeed sends a request that must succeed, decoding its body into
+// body if not nil.
+func (c *client) succeed(command string, args any, body any) {
+	c.t.Helper()
+
+	resp := c.request(command, args)
+	if !resp.Success {
+		c.t.Fatalf("%s failed: %s", command, resp.Message)
+	}
+
+	if body != nil {
+		if err := json.Unmarshal(resp.Body, body); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+// event waits for an event, returning it.
+func (c *client) event(name string) message {
+	c.t.Helper()
+
+	for i, msg := range c.events {
+		if msg.Event == name {
+			c.events = c.events[i+1:]
+			return msg
+		}
+	}
+
+	c.events = nil
+
+	for {
+		msg := c.receive()
+		if msg.Type == "event" && msg.Event == name {
+			return msg
+		}
+	}
+}
+
+// start launches the program stopped on entry.
+func (c *client) start() {
+	c.t.Helper()
+
+	c.succeed("initialize", map[string]any{}, nil)
+	c.succeed("launch", LaunchArguments{Program: "prog.obj", StopOnEntry: true}, nil)
+	c.event("initialized")
+	c.succeed("configurationDone", nil, nil)
+	c.event("stopped")
+}
+
+// TestBreakpointSession checks a session stopping at a breakpoint on a
+// label, reading the registers and running to the end.
+func TestBreakpointSession(t *testing.T) {
+	c := connect(t)
+	c.start()
+
+	var breakpoints struct {
+		Breakpoints []breakpoint `json:"breakpoints"`
+	}
+
+	c.succeed("setFunctionBreakpoints", map[string]any{
+		"breakpoints": []map[string]any{{"name": "LOOP", "condition": "R1 == 2"}, {"name": "NOWHERE"}},
+	}, &breakpoints)
+
+	if b := breakpoints.Breakpoints; len(b) != 2 || !b[0].Verified || b[0].InstructionReference != "0x3001" || b[1].Verified {
+		t.Fatalf("breakpoints %+v", b)
+	}
+
+	c.succeed("continue", map[string]any{"threadId": 1}, nil)
+
+	var stopped struct {
+		Reason string `json:"reason"`
+	}
+
+	if err := json.Unmarshal(c.event("stopped").Body, &stopped); err != nil || stopped.Reason != "breakpoint" {
+		t.Fatalf("stopped for %q: %v", stopped.Reason, err)
+	}
+
+	var variables struct {
+		Variables []variable `json:"variables"`
+	}
+
+	c.succeed("variables", map[string]any{"variablesReference": registersReference}, &variables)
+
+	if v := variables.Variables; len(v) < 2 || v[1].Name != "R1" || v[1].Value != "x0002 (2)" {
+		t.Errorf("variables %+v", v)
+	}
+
+	var evaluated struct {
+		Result string `json:"result"`
+	}
+
+	c.succeed("evaluate", map[string]any{"expression": "R1 + 1"}, &evaluated)
+
+	if evaluated.Result != "x0003 (3)" {
+		t.Errorf("evaluated %q", evaluated.Result)
+	}
+
+	c.succeed("setFunctionBreakpoints", map[string]any{"breakpoints": []any{}}, nil)
+	c.succeed("continue", map[string]any{"threadId": 1}, nil)
+	c.event("terminated")
+}
+
+// TestDisassemble checks disassembly, and that negative counts are
+// refused and large ones cut to the size of memory.
+func TestDisassemble(t *testing.T) {
+	c := connect(t)
+	c.start()
+
+	var disassembly struct {
+		Instructions []disassembledInstruction `json:"instructions"`
+	}
+
+	c.succeed("disassemble", map[string]any{"memoryReference": "0x3000", "instructionOffset": 1, "instructionCount": 2}, &disassembly)
+
+	if in := disassembly.Instructions; len(in) != 2 || in[0].Address != "0x3001" || in[0].Symbol != "LOOP" || in[1].Instruction != "ADD R2, R1, #-3" {
+		t.Errorf("instructions %+v", in)
+	}
+
+	if resp := c.request("disassemble", map[string]any{"memoryReference": "0x3000", "instructionCount": -1}); resp.Success {
+		t.Error("disassembled a negative count")
+	}
+
+	c.succeed("disassemble", map[string]any{"memoryReference": "0x3000", "instructionCount": 1 << 40}, &disassembly)
+
+	if n := len(disassembly.Instructions); n != maxInstructions {
+		t.Errorf("%d instructions, expected %d", n, maxInstructions)
+	}
+}
+
+// TestReadMemory checks that memory is read as big-endian bytes.
+func TestReadMemory(t *testing.T) {
+	c := connect(t)
+	c.start()
+
+	var memory struct {
+		Address string `json:"address"`
+		Data    []byte `json:"data"`
+	}
+
+	c.succeed("readMemory", map[string]any{"memoryReference": "0x3000", "offset": 2, "count": 3}, &memory)
+
+	if memory.Address != "0x3001" || string(memory.Data) != "\x12\x61\x14" {
+		t.Errorf("read % X at %s", memory.Data, memory.Address)
+	}
+
+	c.succeed("readMemory", map[string]any{"memoryReference": "0xFFFF", "count": 100}, &memory)
+
+	if len(memory.Data) != 2 {
+		t.Errorf("read %d bytes past the end of memory, expected 2", len(memory.Data))
+	}
+}
+
+// TestRequestErrors checks that malformed and unknown requests fail
+// without ending the session.
+func TestRequestErrors(t *testing.T) {
+	c := connect(t)
+
+	if resp := c.request("stackTrace", nil); resp.Success || resp.Message != "no program has been launched" {
+		t.Errorf("stack trace before launching: %+v", resp)
+	}
+
+	if resp := c.request("launch", map[string]any{}); resp.Success {
+		t.Error("launched without a program")
+	}
+
+	c.start()
+
+	tests := []struct {
+		command string
+		args    any
+	}{
+		{"variables", "not an object"},
+		{"variables", map[string]any{"variablesReference": 99}},
+		{"setVariable", map[string]any{"variablesReference": registersReference, "name": "CC", "value": "1"}},
+		{"evaluate", map[string]any{"expression": "(R1"}},
+		{"disassemble", map[string]any{"memoryReference": "nowhere", "instructionCount": 1}},
+		{"readMemory", map[string]any{"memoryReference": "0x10000"}},
+		{"flyToTheMoon", nil},
+	}
+
+	for _, test := range tests {
+		if resp := c.request(test.command, test.args); resp.Success {
+			t.Errorf("%s %v succeeded", test.command, test.args)
+		}
+	}
+
+	c.succeed("threads", nil, nil)
+}
+
+// TestContentLength checks that a negative Content-Length ends the
+// session instead of the adapter.
+func TestContentLength(t *testing.T) {
+	c := connect(t)
+
+	c.send("Content-Length: -1\r\n\r\n")
+
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("read after a negative length: %v", err)
+	}
+}
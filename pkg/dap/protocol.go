@@ -0,0 +1,170 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// request is a request sent by the client.
+type request struct {
+	// Seq is the sequence number of the request.
+	Seq int `json:"seq"`
+
+	// Command names the request.
+	Command string `json:"command"`
+
+	// Arguments are the command specific arguments.
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// response answers a request.
+type response struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+// event is a message sent by the adapter on its own accord.
+type event struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+// readMessage reads a single message framed by a Content-Length
+// header.
+func readMessage(r *bufio.Reader) (*request, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	req := &request{}
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// writeMessage writes a single message framed by a Content-Length
+// header.
+func writeMessage(w io.Writer, msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+
+	_, err = w.Write(body)
+
+	return err
+}
+
+// capabilities are the features of the adapter announced in the
+// initialize response.
+type capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsFunctionBreakpoints      bool `json:"supportsFunctionBreakpoints"`
+	SupportsConditionalBreakpoints   bool `json:"supportsConditionalBreakpoints"`
+	SupportsInstructionBreakpoints   bool `json:"supportsInstructionBreakpoints"`
+	SupportsDisassembleRequest       bool `json:"supportsDisassembleRequest"`
+	SupportsReadMemoryRequest        bool `json:"supportsReadMemoryRequest"`
+	SupportsSetVariable              bool `json:"supportsSetVariable"`
+	SupportsEvaluateForHovers        bool `json:"supportsEvaluateForHovers"`
+	SupportsTerminateRequest         bool `json:"supportsTerminateRequest"`
+}
+
+// LaunchArguments are the arguments of the launch and attach requests,
+// given in the launch configuration of the editor.
+type LaunchArguments struct {
+	// Program is the object file to debug.
+	Program string `json:"program"`
+
+	// Symbols is the symbol table of the program, by default the
+	// program name with a .sym extension if it exists.
+	Symbols string `json:"symbols"`
+
+	// DebugInfo maps the program to its assembly source, by default
+	// the program name with a .debug extension if it exists.
+	DebugInfo string `json:"debugInfo"`
+
+	// Keys is a keystroke script replayed as console input.
+	Keys string `json:"keys"`
+
+	// StopOnEntry stops the program before its first instruction.
+	StopOnEntry bool `json:"stopOnEntry"`
+}
+
+// breakpoint is the state of a breakpoint reported to the client.
+type breakpoint struct {
+	ID                   int     `json:"id,omitempty"`
+	Verified             bool    `json:"verified"`
+	Message              string  `json:"message,omitempty"`
+	InstructionReference string  `json:"instructionReference,omitempty"`
+	Source               *source `json:"source,omitempty"`
+	Line                 int     `json:"line,omitempty"`
+}
+
+// source is a source file of the program.
+type source struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// stackFrame is a single frame of a stack trace.
+type stackFrame struct {
+	ID                          int     `json:"id"`
+	Name                        string  `json:"name"`
+	Source                      *source `json:"source,omitempty"`
+	Line                        int     `json:"line"`
+	Column                      int     `json:"column"`
+	InstructionPointerReference string  `json:"instructionPointerReference"`
+}
+
+// scope is a group of variables.
+type scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+// variable is a single register, memory word or group of words.
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+// disassembledInstruction is a single line of disassembly.
+type disassembledInstruction struct {
+	Address          string  `json:"address"`
+	InstructionBytes string  `json:"instructionBytes"`
+	Instruction      string  `json:"instruction"`
+	Symbol           string  `json:"symbol,omitempty"`
+	Location         *source `json:"location,omitempty"`
+	Line             int     `json:"line,omitempty"`
+}
@@ -0,0 +1,19 @@
+package debugger
+
+import (
+	"lc3/pkg/disasm"
+	"lc3/pkg/opcodes"
+)
+
+// isCall reports whether an instruction calls a subroutine with JSR
+// or JSRR. Traps are not calls since their service routines are
+// implemented by the VM and complete in a single step.
+func isCall(instr uint16) bool {
+	return disasm.Decode(instr).Opcode == opcodes.OPJSR
+}
+
+// isReturn reports whether an instruction returns from a subroutine,
+// that is RET (JMP R7).
+func isReturn(instr uint16) bool {
+	return disasm.Decode(instr).Name == "RET"
+}
@@ -0,0 +1,577 @@
+package debugger
+
+import (
+	"fmt"
+	"lc3/pkg/registers"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// command is a debugger command.
+type command struct {
+	// names are the name of the command followed by its aliases.
+	names []string
+
+	// usage describes the arguments of the command.
+	usage string
+
+	// help is a one line description of the command.
+	help string
+
+	// run runs the command.
+	run func(d *Debugger, args []string) error
+}
+
+// commandTable is the table of debugger commands, it is populated in
+// init since the help command refers back to it.
+var commandTable []command
+
+func init() {
+	commandTable = []command{
+		{[]string{"break", "b"}, "ADDR[..END] [if EXPR]", "set a breakpoint at ADDR or a range, optionally conditional", handleBreak},
+		{[]string{"tbreak", "tb"}, "ADDR[..END] [if EXPR]", "like break, but delete the breakpoint once it stops", handleTbreak},
+		{[]string{"break trap"}, "[VECTOR]", "stop before trap VECTOR, or any trap, is invoked", handleBreak},
+		{[]string{"condition", "cond"}, "ID [EXPR]", "set or clear the condition of a breakpoint", handleCondition},
+		{[]string{"ignore"}, "ID N", "ignore the next N hits of a breakpoint", handleIgnore},
+		{[]string{"watch"}, "ADDR[..END] | REG [OP VAL]", "stop when memory is written or a register changes", handleWatch(WatchWrite)},
+		{[]string{"rwatch"}, "ADDR[..END]", "stop when memory is read", handleWatch(WatchRead)},
+		{[]string{"awatch"}, "ADDR[..END]", "stop when memory is read or written", handleWatch(WatchAccess)},
+		{[]string{"delete", "d"}, "[ID]", "delete a breakpoint or watchpoint, or all of them", handleDelete},
+		{[]string{"run", "r"}, "", "restart the program from the beginning", handleRun},
+		{[]string{"continue", "c"}, "", "continue until a breakpoint or halt", handleContinue},
+		{[]string{"step", "s", "stepi", "si"}, "[back] [N]", "execute N instructions, default 1, or undo them with back", handleStep},
+		{[]string{"next", "n", "nexti", "ni"}, "[N]", "like step, but run subroutine calls to completion", handleNext},
+		{[]string{"until", "u"}, "ADDR", "run until the PC reaches ADDR", handleUntil},
+		{[]string{"finish", "fin"}, "", "run until the current subroutine returns", handleFinish},
+		{[]string{"reverse-step", "rs", "back"}, "[N]", "undo the last N instructions, default 1", handleReverseStep},
+		{[]string{"rewind"}, "[N]", "go back to the Nth most recent checkpoint, default 1", handleRewind},
+		{[]string{"set"}, "REG = EXPR", "set a register to the value of EXPR", handleSet},
+		{[]string{"deposit", "dep"}, "ADDR = EXPR", "set a word of memory to the value of EXPR", handleDeposit},
+		{[]string{"backtrace", "bt", "where"}, "", "show the chain of active subroutine calls", handleBacktrace},
+		{[]string{"examine", "x"}, "[/NFW] [ADDR[, N]]", "show N words in format F (x, d, u, c, s, i), W per line", func(d *Debugger, args []string) error {
+			return handleExamine(d, args, "")
+		}},
+		{[]string{"display"}, "[EXPR]", "print EXPR whenever execution stops, or show all displays", handleDisplay},
+		{[]string{"undisplay"}, "[ID]", "delete a display, or all of them", handleUndisplay},
+		{[]string{"list", "l"}, "[FILE:LINE]", "show the source or instructions around the PC, or the source around FILE:LINE", handleList},
+		{[]string{"registers", "regs"}, "", "show the registers", handleRegisters},
+		{[]string{"info", "i"}, "registers|breakpoints|devices|stack|display|checkpoints", "show detailed state", handleInfo},
+		{[]string{"source"}, "FILE", "execute the debugger commands in FILE", handleSource},
+		{[]string{"help", "h", "?"}, "", "show this help", handleHelp},
+		{[]string{"quit", "q"}, "", "leave the debugger", handleQuit},
+	}
+}
+
+// lookupCommand finds a command by name or alias.
+func lookupCommand(name string) (command, bool) {
+	for _, cmd := range commandTable {
+		for _, n := range cmd.names {
+			if n == name {
+				return cmd, true
+			}
+		}
+	}
+
+	return command{}, false
+}
+
+// handleBreak handles the break command.
+func handleBreak(d *Debugger, args []string) error {
+	if len(args) > 0 && args[0] == "trap" {
+		return handleBreakTrap(d, args[1:])
+	}
+
+	return addBreakpoint(d, args, false)
+}
+
+// handleTbreak handles the tbreak command.
+func handleTbreak(d *Debugger, args []string) error {
+	return addBreakpoint(d, args, true)
+}
+
+// addBreakpoint adds a possibly temporary breakpoint from the
+// arguments ADDR[..END] [if EXPR].
+func addBreakpoint(d *Debugger, args []string, temporary bool) error {
+	if len(args) != 1 && (len(args) < 3 || args[1] != "if") {
+		return fmt.Errorf("usage: break ADDR[..END] [if EXPR]")
+	}
+
+	start, end, err := d.parseRange(args[0])
+	if err != nil {
+		return err
+	}
+
+	var condition string
+	if len(args) > 1 {
+		condition = strings.Join(args[2:], " ")
+
+		// check the condition before creating the breakpoint.
+		if _, err := parseExpr(condition); err != nil {
+			return err
+		}
+	}
+
+	bp := d.AddRangeBreakpoint(start, end)
+	bp.Temporary = temporary
+
+	if err := d.SetCondition(bp.ID, condition); err != nil {
+		return err
+	}
+
+	if start == end {
+		fmt.Fprintf(d.out, "%s %d at %s\n", bp.kind(), bp.ID, d.formatAddress(start))
+	} else {
+		fmt.Fprintf(d.out, "%s %d at %s..%s\n", bp.kind(), bp.ID, d.formatAddress(start), d.formatAddress(end))
+	}
+
+	return nil
+}
+
+// handleBreakTrap handles the break trap command.
+func handleBreakTrap(d *Debugger, args []string) error {
+	switch len(args) {
+	case 0:
+		tb := d.AddTrapBreakpoint(0, true)
+		fmt.Fprintf(d.out, "Breakpoint %d on every trap\n", tb.ID)
+
+		return nil
+	case 1:
+		vector, err := parseTrapVector(args[0])
+		if err != nil {
+			return err
+		}
+
+		tb := d.AddTrapBreakpoint(vector, false)
+		fmt.Fprintf(d.out, "Breakpoint %d on trap x%02X\n", tb.ID, tb.Vector)
+
+		return nil
+	}
+
+	return fmt.Errorf("usage: break trap [VECTOR]")
+}
+
+// handleIgnore handles the ignore command.
+func handleIgnore(d *Debugger, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: ignore ID N")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid breakpoint %q", args[0])
+	}
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count < 0 {
+		return fmt.Errorf("invalid count %q", args[1])
+	}
+
+	if err := d.SetIgnore(id, count); err != nil {
+		return err
+	}
+
+	switch count {
+	case 0:
+		fmt.Fprintf(d.out, "Will stop next time breakpoint %d is reached.\n", id)
+	case 1:
+		fmt.Fprintf(d.out, "Will ignore next crossing of breakpoint %d.\n", id)
+	default:
+		fmt.Fprintf(d.out, "Will ignore next %d crossings of breakpoint %d.\n", count, id)
+	}
+
+	return nil
+}
+
+// handleCondition handles the condition command.
+func handleCondition(d *Debugger, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: condition ID [EXPR]")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid breakpoint %q", args[0])
+	}
+
+	return d.SetCondition(id, strings.Join(args[1:], " "))
+}
+
+// handleWatch returns a handler for the watch commands.
+func handleWatch(kind WatchKind) func(d *Debugger, args []string) error {
+	return func(d *Debugger, args []string) error {
+		if kind == WatchWrite && len(args) > 0 {
+			if r, ok := parseRegister(args[0]); ok {
+				return watchRegister(d, r, args[1:])
+			}
+		}
+
+		if len(args) != 1 {
+			return fmt.Errorf("usage: watch ADDR[..END]")
+		}
+
+		start, end, err := d.parseRange(args[0])
+		if err != nil {
+			return err
+		}
+
+		wp := d.AddWatchpoint(start, end, kind)
+
+		if start == end {
+			fmt.Fprintf(d.out, "Watchpoint %d at %s\n", wp.ID, d.formatAddress(start))
+		} else {
+			fmt.Fprintf(d.out, "Watchpoint %d at %s..%s\n", wp.ID, d.formatAddress(start), d.formatAddress(end))
+		}
+
+		return nil
+	}
+}
+
+// comparisons are the operators accepted by register watchpoints.
+var comparisons = map[string]bool{
+	"==": true,
+	"!=": true,
+	"<":  true,
+	"<=": true,
+	">":  true,
+	">=": true,
+}
+
+// watchRegister adds a register watchpoint, args are an optional
+// comparison operator and value.
+func watchRegister(d *Debugger, r uint16, args []string) error {
+	var (
+		op  string
+		val uint16
+	)
+
+	switch len(args) {
+	case 0:
+	case 2:
+		if !comparisons[args[0]] {
+			return fmt.Errorf("invalid comparison %q", args[0])
+		}
+
+		n, err := parseNumber(args[1])
+		if err != nil {
+			return err
+		}
+
+		op, val = args[0], n
+	default:
+		return fmt.Errorf("usage: watch REG [OP VAL]")
+	}
+
+	rw := d.AddRegisterWatch(r, op, val)
+
+	if op == "" {
+		fmt.Fprintf(d.out, "Watchpoint %d: %s\n", rw.ID, registers.Name(r))
+	} else {
+		fmt.Fprintf(d.out, "Watchpoint %d: %s %s x%04X\n", rw.ID, registers.Name(r), op, val)
+	}
+
+	return nil
+}
+
+// handleDelete handles the delete command.
+func handleDelete(d *Debugger, args []string) error {
+	if len(args) == 0 {
+		for _, bp := range d.Breakpoints() {
+			d.DeleteBreakpoint(bp.ID)
+		}
+
+		for _, wp := range d.Watchpoints() {
+			d.DeleteBreakpoint(wp.ID)
+		}
+
+		for _, rw := range d.RegisterWatches() {
+			d.DeleteBreakpoint(rw.ID)
+		}
+
+		for _, tb := range d.TrapBreakpoints() {
+			d.DeleteBreakpoint(tb.ID)
+		}
+
+		return nil
+	}
+
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid breakpoint %q", arg)
+		}
+
+		if !d.DeleteBreakpoint(id) {
+			return fmt.Errorf("no breakpoint or watchpoint %d", id)
+		}
+	}
+
+	return nil
+}
+
+// handleRun handles the run command.
+func handleRun(d *Debugger, args []string) error {
+	d.restart()
+	d.report(d.Continue())
+
+	return nil
+}
+
+// handleContinue handles the continue command.
+func handleContinue(d *Debugger, args []string) error {
+	if !d.running {
+		return fmt.Errorf("the program is not being run")
+	}
+
+	d.report(d.Continue())
+
+	return nil
+}
+
+// handleStep handles the step command, and step back as the
+// reverse-step command.
+func handleStep(d *Debugger, args []string) error {
+	if len(args) > 0 && args[0] == "back" {
+		return handleReverseStep(d, args[1:])
+	}
+
+	if !d.running {
+		return fmt.Errorf("the program is not being run")
+	}
+
+	n, err := parseCount(args)
+	if err != nil {
+		return err
+	}
+
+	d.report(d.Step(n))
+
+	return nil
+}
+
+// handleNext handles the next command.
+func handleNext(d *Debugger, args []string) error {
+	if !d.running {
+		return fmt.Errorf("the program is not being run")
+	}
+
+	n, err := parseCount(args)
+	if err != nil {
+		return err
+	}
+
+	stop := d.Next()
+	for i := 1; i < n && stop.Reason == StopStep; i++ {
+		stop = d.Next()
+	}
+
+	d.report(stop)
+
+	return nil
+}
+
+// handleUntil handles the until command.
+func handleUntil(d *Debugger, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: until ADDR")
+	}
+
+	if !d.running {
+		return fmt.Errorf("the program is not being run")
+	}
+
+	address, err := d.parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+
+	d.report(d.Until(address))
+
+	return nil
+}
+
+// handleFinish handles the finish command.
+func handleFinish(d *Debugger, args []string) error {
+	if !d.running {
+		return fmt.Errorf("the program is not being run")
+	}
+
+	stop := d.Finish()
+	if stop.Reason == StopStep {
+		fmt.Fprintf(d.out, "Returned to %s\n", d.formatAddress(stop.PC))
+	}
+
+	d.report(stop)
+
+	return nil
+}
+
+// handleReverseStep handles the reverse-step command.
+func handleReverseStep(d *Debugger, args []string) error {
+	n, err := parseCount(args)
+	if err != nil {
+		return err
+	}
+
+	if d.StepBack(n) == 0 {
+		return fmt.Errorf("no more history to step back through")
+	}
+
+	d.report(d.stop(StopStep))
+
+	return nil
+}
+
+// handleRewind handles the rewind command.
+func handleRewind(d *Debugger, args []string) error {
+	n, err := parseCount(args)
+	if err != nil {
+		return err
+	}
+
+	cp, err := d.Rewind(n)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(d.out, "Rewound to the checkpoint after %d instructions\n", cp.Instructions)
+
+	d.report(d.stop(StopStep))
+
+	return nil
+}
+
+// parseAssignment splits the arguments of an assignment such as
+// R3 = x1F into its target and expression.
+func parseAssignment(args []string) (string, string, bool) {
+	target, expression, ok := strings.Cut(strings.Join(args, " "), "=")
+	target, expression = strings.TrimSpace(target), strings.TrimSpace(expression)
+
+	return target, expression, ok && target != "" && expression != ""
+}
+
+// handleSet handles the set command.
+func handleSet(d *Debugger, args []string) error {
+	target, expression, ok := parseAssignment(args)
+	if !ok {
+		return fmt.Errorf("usage: set REG = EXPR")
+	}
+
+	r, ok := parseRegister(target)
+	if !ok {
+		return fmt.Errorf("invalid register %q", target)
+	}
+
+	val, err := d.Evaluate(expression)
+	if err != nil {
+		return err
+	}
+
+	d.cpu.SetRegister(r, uint16(val))
+
+	fmt.Fprintf(d.out, "%s = x%04X\n", registers.Name(r), uint16(val))
+
+	return nil
+}
+
+// handleDeposit handles the deposit command.
+func handleDeposit(d *Debugger, args []string) error {
+	target, expression, ok := parseAssignment(args)
+	if !ok {
+		return fmt.Errorf("usage: deposit ADDR = EXPR")
+	}
+
+	address, err := d.parseAddress(target)
+	if err != nil {
+		return err
+	}
+
+	val, err := d.Evaluate(expression)
+	if err != nil {
+		return err
+	}
+
+	d.cpu.PokeMemory(address, uint16(val))
+
+	fmt.Fprintf(d.out, "%s = x%04X\n", d.formatAddress(address), uint16(val))
+
+	return nil
+}
+
+// handleBacktrace handles the backtrace command.
+func handleBacktrace(d *Debugger, args []string) error {
+	frames := d.Stack()
+
+	pc := d.cpu.Register(registers.RPC)
+
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+		fmt.Fprintf(d.out, "#%-3d x%04X in %s\n", len(frames)-1-i, pc, d.symbols.Format(pc))
+
+		pc = f.Call
+	}
+
+	fmt.Fprintf(d.out, "#%-3d x%04X in %s\n", len(frames), pc, d.symbols.Format(pc))
+
+	return nil
+}
+
+// parseCount parses the optional repeat count of the stepping
+// commands.
+func parseCount(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+
+	count, err := strconv.Atoi(args[0])
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("invalid step count %q", args[0])
+	}
+
+	return count, nil
+}
+
+// handleRegisters handles the registers command.
+func handleRegisters(d *Debugger, args []string) error {
+	d.printRegisters()
+
+	return nil
+}
+
+// handleSource handles the source command.
+func handleSource(d *Debugger, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: source FILE")
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if err := d.Source(file); err != nil {
+		if err == ErrQuit {
+			return err
+		}
+
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	return nil
+}
+
+// handleHelp handles the help command.
+func handleHelp(d *Debugger, args []string) error {
+	for _, cmd := range commandTable {
+		fmt.Fprintf(d.out, "  %-32s %s\n", cmd.names[0]+" "+cmd.usage, cmd.help)
+	}
+
+	return nil
+}
+
+// handleQuit handles the quit command.
+func handleQuit(d *Debugger, args []string) error {
+	return ErrQuit
+}
@@ -0,0 +1,54 @@
+package debugger
+
+import (
+	"sort"
+	"strings"
+)
+
+// registerNames are the registers completed in arguments.
+var registerNames = []string{"R0", "R1", "R2", "R3", "R4", "R5", "R6", "R7", "PC"}
+
+// Complete returns the candidates completing the last word of a
+// command line being typed: the names of the commands for the first
+// word, the topics of info, or else the registers and the labels of
+// the program. Candidates keep the case of what was typed of them.
+func (d *Debugger) Complete(line string) []string {
+	fields := strings.Fields(line)
+
+	word := ""
+	if len(fields) > 0 && !strings.HasSuffix(line, " ") {
+		word = fields[len(fields)-1]
+		fields = fields[:len(fields)-1]
+	}
+
+	var names []string
+
+	switch {
+	case len(fields) == 0:
+		for _, cmd := range commandTable {
+			if !strings.Contains(cmd.names[0], " ") {
+				names = append(names, cmd.names[0])
+			}
+		}
+	case len(fields) == 1 && (fields[0] == "info" || fields[0] == "i"):
+		for topic := range infoTopics {
+			if len(topic) > 3 {
+				names = append(names, topic)
+			}
+		}
+	default:
+		names = append(append(names, registerNames...), d.symbols.Labels()...)
+	}
+
+	var candidates []string
+
+	for _, name := range names {
+		if len(name) >= len(word) && strings.EqualFold(name[:len(word)], word) {
+			candidates = append(candidates, word+name[len(word):])
+		}
+	}
+
+	sort.Strings(candidates)
+
+	return candidates
+}
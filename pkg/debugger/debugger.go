@@ -0,0 +1,1114 @@
+// Package debugger implements an interactive debugger for the
+// LC3 virtual machine, supporting breakpoints and stepping
+// through a program one instruction at a time.
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"lc3/pkg/callstack"
+	"lc3/pkg/checkpoint"
+	"lc3/pkg/cpu"
+	"lc3/pkg/debuginfo"
+	"lc3/pkg/disasm"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+	"lc3/pkg/symbols"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Prompt is printed whenever the debugger waits for a command.
+const Prompt = "(lc3) "
+
+// ErrQuit is returned by the quit command to end the session.
+var ErrQuit = errors.New("quit")
+
+// StopReason describes why execution stopped.
+type StopReason int
+
+const (
+	// StopStep indicates that a requested number of steps completed.
+	StopStep StopReason = iota
+
+	// StopBreakpoint indicates that a breakpoint was reached.
+	StopBreakpoint
+
+	// StopHalt indicates that the program halted.
+	StopHalt
+
+	// StopError indicates that the program failed.
+	StopError
+
+	// StopWatchpoint indicates that a watched memory location was
+	// accessed.
+	StopWatchpoint
+
+	// StopRegisterWatch indicates that a watched register changed.
+	StopRegisterWatch
+
+	// StopInterrupt indicates that execution was interrupted.
+	StopInterrupt
+
+	// StopTrap indicates that a trap breakpoint was reached.
+	StopTrap
+)
+
+// Stop describes where and why execution stopped.
+type Stop struct {
+	// Reason is why execution stopped.
+	Reason StopReason
+
+	// PC is the program counter at the stop.
+	PC uint16
+
+	// Breakpoint is the breakpoint that was reached, if any.
+	Breakpoint *Breakpoint
+
+	// Err is the error the program failed with, if any.
+	Err error
+
+	// Watchpoint is the watchpoint that was triggered, if any.
+	Watchpoint *Watchpoint
+
+	// Access is the memory access that triggered the watchpoint.
+	Access cpu.MemoryAccess
+
+	// RegisterWatch is the register watchpoint that was triggered,
+	// if any.
+	RegisterWatch *RegisterWatch
+
+	// Old is the value of the watched register before the change.
+	Old uint16
+
+	// New is the value of the watched register after the change.
+	New uint16
+
+	// Source is the address of the instruction that changed the
+	// watched register.
+	Source uint16
+
+	// TrapBreakpoint is the trap breakpoint that was reached, if any.
+	TrapBreakpoint *TrapBreakpoint
+}
+
+// Breakpoint stops execution before the instruction at an address,
+// or at any address of a range, is executed.
+type Breakpoint struct {
+	// ID identifies the breakpoint in commands.
+	ID int
+
+	// Address is the address of the instruction to stop at, or the
+	// first address of the range.
+	Address uint16
+
+	// End is the last address of the range, which equals Address for
+	// a breakpoint on a single instruction.
+	End uint16
+
+	// Temporary is set if the breakpoint is deleted once it stops
+	// execution.
+	Temporary bool
+
+	// Hits counts how often the breakpoint was reached with its
+	// condition satisfied.
+	Hits int
+
+	// Ignore is the number of upcoming hits that do not stop
+	// execution.
+	Ignore int
+
+	// Condition is the condition under which the breakpoint stops,
+	// empty to always stop.
+	Condition string
+
+	// cond is the parsed condition.
+	cond expr
+}
+
+// kind describes the breakpoint in messages.
+func (b *Breakpoint) kind() string {
+	if b.Temporary {
+		return "Temporary breakpoint"
+	}
+
+	return "Breakpoint"
+}
+
+// WatchKind selects the memory accesses that trigger a watchpoint.
+type WatchKind int
+
+const (
+	// WatchWrite triggers on writes.
+	WatchWrite WatchKind = iota
+
+	// WatchRead triggers on reads.
+	WatchRead
+
+	// WatchAccess triggers on reads and writes.
+	WatchAccess
+)
+
+// Watchpoint stops execution after an instruction accesses a range
+// of memory.
+type Watchpoint struct {
+	// ID identifies the watchpoint in commands.
+	ID int
+
+	// Start is the first watched address.
+	Start uint16
+
+	// End is the last watched address.
+	End uint16
+
+	// Kind selects the accesses that trigger the watchpoint.
+	Kind WatchKind
+
+	// Hits counts how often the watchpoint stopped execution.
+	Hits int
+}
+
+// matches reports whether an access triggers the watchpoint.
+func (w *Watchpoint) matches(access cpu.MemoryAccess) bool {
+	if access.Address < w.Start || access.Address > w.End {
+		return false
+	}
+
+	switch w.Kind {
+	case WatchWrite:
+		return access.Write
+	case WatchRead:
+		return !access.Write
+	}
+
+	return true
+}
+
+// RegisterWatch stops execution after an instruction changes a
+// register, optionally only when the new value satisfies a comparison.
+type RegisterWatch struct {
+	// ID identifies the watchpoint in commands.
+	ID int
+
+	// Register is the watched register.
+	Register uint16
+
+	// Op is the comparison operator, empty to stop on any change.
+	Op string
+
+	// Value is the value the register is compared against.
+	Value uint16
+
+	// Hits counts how often the watchpoint stopped execution.
+	Hits int
+}
+
+// matches reports whether a change of the register from old to val
+// triggers the watchpoint.
+func (w *RegisterWatch) matches(old, val uint16) bool {
+	if old == val {
+		return false
+	}
+
+	switch w.Op {
+	case "==":
+		return val == w.Value
+	case "!=":
+		return val != w.Value
+	case "<":
+		return val < w.Value
+	case "<=":
+		return val <= w.Value
+	case ">":
+		return val > w.Value
+	case ">=":
+		return val >= w.Value
+	}
+
+	return true
+}
+
+// TrapBreakpoint stops execution before a TRAP instruction invoking
+// a trap vector is executed.
+type TrapBreakpoint struct {
+	// ID identifies the breakpoint in commands.
+	ID int
+
+	// Vector is the trap vector to stop at, ignored if Any is set.
+	Vector uint16
+
+	// Any is set to stop at every trap.
+	Any bool
+
+	// Hits counts how often the breakpoint stopped execution.
+	Hits int
+}
+
+// matches reports whether an instruction triggers the breakpoint.
+func (t *TrapBreakpoint) matches(instr uint16) bool {
+	i := disasm.Decode(instr)
+
+	return i.Opcode == opcodes.OPTRAP && (t.Any || uint16(i.Imm) == t.Vector)
+}
+
+// Debugger is an interactive debugging session for a single program.
+type Debugger struct {
+	// newCPU creates a fresh CPU whenever the program is (re)started.
+	newCPU func() cpu.CPU
+
+	// image is the initial memory state of the program.
+	image [cpu.MemoryMax]uint16
+
+	// cpu is the CPU running the program.
+	cpu cpu.CPU
+
+	// in is where commands are read from.
+	in *bufio.Reader
+
+	// out is where command output is written to.
+	out io.Writer
+
+	// breakpoints maps breakpoint IDs to breakpoints.
+	breakpoints map[int]*Breakpoint
+
+	// watchpoints maps watchpoint IDs to watchpoints.
+	watchpoints map[int]*Watchpoint
+
+	// registerWatches maps watchpoint IDs to register watchpoints.
+	registerWatches map[int]*RegisterWatch
+
+	// trapBreakpoints maps breakpoint IDs to trap breakpoints.
+	trapBreakpoints map[int]*TrapBreakpoint
+
+	// nextID is the ID of the next breakpoint or watchpoint.
+	nextID int
+
+	// watchStop is set when an instruction triggers a watchpoint.
+	watchStop *Stop
+
+	// running is set while there is a program that can be resumed.
+	running bool
+
+	// stack is the shadow call stack of the program.
+	stack *callstack.Stack
+
+	// symbols is the symbol table of the program, if loaded.
+	symbols *symbols.Table
+
+	// readLine reads the command lines in place of in, if set.
+	readLine func(prompt string) (string, error)
+
+	// debugInfo maps addresses to source lines, if loaded.
+	debugInfo *debuginfo.Info
+
+	// sources caches the lines of source files shown.
+	sources map[string][]string
+
+	// history records recently executed instructions for stepping
+	// back.
+	history history
+
+	// current is the delta of the executing instruction.
+	current *delta
+
+	// nextExamine is the address examine continues from when no
+	// address is given.
+	nextExamine uint16
+
+	// displays are the expressions printed whenever execution stops.
+	displays []*Display
+
+	// nextDisplay is the ID of the last display added.
+	nextDisplay int
+
+	// fresh is set until the program resumes after it was loaded, so
+	// that a breakpoint on its first instruction stops it before it
+	// runs rather than being resumed from.
+	fresh bool
+
+	// interrupted is set by Interrupt to stop a running program.
+	interrupted atomic.Bool
+
+	// interrupter arranges for Interrupt to be called while the
+	// program is resumed, if set.
+	interrupter func(interrupt func()) func()
+
+	// transcript records the session, if it is being recorded.
+	transcript *transcript
+
+	// checkpointEvery is the number of instructions between
+	// checkpoints, 0 if none are taken.
+	checkpointEvery uint64
+
+	// checkpointKeep is the number of checkpoints kept.
+	checkpointKeep int
+
+	// checkpoints takes checkpoints of the program, if enabled.
+	checkpoints *checkpoint.Checkpointer
+}
+
+// New creates a debugger for the program image. newCPU is called to
+// create the CPU each time the program is started, commands are read
+// from in and output is written to out. If the program reads the
+// console as well, in should be shared with the CPU so that neither
+// loses buffered input.
+func New(newCPU func() cpu.CPU, image [cpu.MemoryMax]uint16, in *bufio.Reader, out io.Writer) *Debugger {
+	d := &Debugger{
+		newCPU:      newCPU,
+		image:       image,
+		in:          in,
+		out:         out,
+		breakpoints: map[int]*Breakpoint{},
+		watchpoints: map[int]*Watchpoint{},
+		nextID:      1,
+
+		registerWatches: map[int]*RegisterWatch{},
+		trapBreakpoints: map[int]*TrapBreakpoint{},
+		sources:         map[string][]string{},
+	}
+
+	d.restart()
+
+	return d
+}
+
+// restart reloads the program on a fresh CPU.
+func (d *Debugger) restart() {
+	d.cpu = d.newCPU()
+	d.cpu.Load(d.image)
+	d.cpu.OnMemoryAccess(d.onMemoryAccess)
+	d.cpu.OnInstruction(d.onInstruction)
+	d.stack = callstack.New()
+	d.history = history{}
+	d.running = true
+	d.fresh = true
+	d.attachCheckpoints()
+}
+
+// SetCheckpoints takes a checkpoint of the program every so many
+// instructions, keeping the last keep of them to rewind to.
+func (d *Debugger) SetCheckpoints(every uint64, keep int) {
+	d.checkpointEvery = every
+	d.checkpointKeep = keep
+	d.attachCheckpoints()
+}
+
+// attachCheckpoints starts taking checkpoints of the CPU, if enabled.
+func (d *Debugger) attachCheckpoints() {
+	d.checkpoints = nil
+
+	if d.checkpointEvery > 0 {
+		d.checkpoints = checkpoint.New(d.checkpointEvery, d.checkpointKeep, checkpoint.WithStack(d.stack))
+		d.checkpoints.Record(d.cpu)
+	}
+}
+
+// Rewind puts the program back into the state of the nth most recent
+// checkpoint, 1 being the latest.
+func (d *Debugger) Rewind(n int) (*checkpoint.Checkpoint, error) {
+	if d.checkpoints == nil {
+		return nil, fmt.Errorf("no checkpoints are taken, start the debugger with -checkpoint")
+	}
+
+	cp, err := d.checkpoints.Rewind(n)
+	if err != nil {
+		return nil, err
+	}
+
+	d.history = history{}
+	d.running = true
+
+	return cp, nil
+}
+
+// onInstruction follows calls and returns on the shadow call stack.
+func (d *Debugger) onInstruction(pc, instr uint16) {
+	d.stack.Observe(pc, instr, d.cpu.Register(registers.RPC))
+}
+
+// SetSymbols sets the symbol table used to render addresses.
+func (d *Debugger) SetSymbols(table *symbols.Table) {
+	d.symbols = table
+}
+
+// Symbols returns the symbol table used to render addresses, which
+// is nil if none was set.
+func (d *Debugger) Symbols() *symbols.Table {
+	return d.symbols
+}
+
+// SetDebugInfo sets the debug info used to show the source of the
+// program.
+func (d *Debugger) SetDebugInfo(info *debuginfo.Info) {
+	d.debugInfo = info
+}
+
+// DebugInfo returns the debug info of the program, which is nil if
+// none was set.
+func (d *Debugger) DebugInfo() *debuginfo.Info {
+	return d.debugInfo
+}
+
+// Evaluate evaluates an expression in the expression language of
+// conditional breakpoints against the current state of the program.
+func (d *Debugger) Evaluate(expression string) (int, error) {
+	e, err := parseExpr(expression)
+	if err != nil {
+		return 0, err
+	}
+
+	return e.eval(d)
+}
+
+// Stack returns the active frames of the shadow call stack,
+// innermost last.
+func (d *Debugger) Stack() []callstack.Frame {
+	return d.stack.Frames()
+}
+
+// onMemoryAccess checks memory accesses against the watchpoints.
+func (d *Debugger) onMemoryAccess(access cpu.MemoryAccess) {
+	if access.Write && d.current != nil {
+		d.current.writes = append(d.current.writes, memoryWrite{address: access.Address, old: access.Old})
+	}
+
+	if d.watchStop != nil {
+		return
+	}
+
+	for _, wp := range d.Watchpoints() {
+		if wp.matches(access) {
+			d.watchStop = &Stop{
+				Reason:     StopWatchpoint,
+				Watchpoint: wp,
+				Access:     access,
+			}
+
+			return
+		}
+	}
+}
+
+// Running reports whether there is a program that can be resumed,
+// that is one that has not halted or failed.
+func (d *Debugger) Running() bool {
+	return d.running
+}
+
+// CPU returns the CPU running the program.
+func (d *Debugger) CPU() cpu.CPU {
+	return d.cpu
+}
+
+// SetLineReader reads the command lines with read, which prints the
+// prompt, in place of the input given to New, to edit them as typed.
+func (d *Debugger) SetLineReader(read func(prompt string) (string, error)) {
+	d.readLine = read
+}
+
+// Run reads and executes commands until the input ends or the
+// session is quit.
+func (d *Debugger) Run() error {
+	defer d.transcript.flush()
+
+	for {
+		line, err := d.nextLine()
+		if err != nil && line == "" {
+			if err == io.EOF {
+				fmt.Fprintln(d.console())
+				return nil
+			}
+
+			return err
+		}
+
+		if err := d.Exec(line); err != nil {
+			if err == ErrQuit {
+				return nil
+			}
+
+			fmt.Fprintf(d.out, "error: %v\n", err)
+		}
+	}
+}
+
+// nextLine prompts for a command line and reads it.
+func (d *Debugger) nextLine() (string, error) {
+	if d.readLine != nil {
+		return d.readLine(Prompt)
+	}
+
+	fmt.Fprint(d.console(), Prompt)
+
+	return d.in.ReadString('\n')
+}
+
+// Source executes the commands read from r, one per line, skipping
+// blank lines and lines starting with #. It stops at the first command
+// that fails, or returns ErrQuit if the script quits the session.
+func (d *Debugger) Source(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if err := d.Exec(text); err != nil {
+			if err == ErrQuit {
+				return err
+			}
+
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Exec executes a single command line.
+func (d *Debugger) Exec(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// commands read by source are recorded on their own.
+	if fields[0] == "source" {
+		d.transcript.note(line)
+
+		cmd, _ := lookupCommand(fields[0])
+
+		return cmd.run(d, fields[1:])
+	}
+
+	d.transcript.flush()
+
+	err := d.exec(fields)
+
+	d.transcript.command(line, err)
+
+	return err
+}
+
+// exec executes a single command.
+func (d *Debugger) exec(fields []string) error {
+	// the examine command takes its format glued to its name,
+	// x/16x for example.
+	name, spec, _ := strings.Cut(fields[0], "/")
+	if name == "x" || name == "examine" {
+		return handleExamine(d, fields[1:], spec)
+	}
+
+	cmd, ok := lookupCommand(fields[0])
+	if !ok {
+		return fmt.Errorf("unknown command %q, try \"help\"", fields[0])
+	}
+
+	return cmd.run(d, fields[1:])
+}
+
+// AddBreakpoint adds a breakpoint at an address.
+func (d *Debugger) AddBreakpoint(address uint16) *Breakpoint {
+	return d.AddRangeBreakpoint(address, address)
+}
+
+// AddRangeBreakpoint adds a breakpoint stopping before any instruction
+// at the addresses start to end inclusive is executed.
+func (d *Debugger) AddRangeBreakpoint(start, end uint16) *Breakpoint {
+	bp := &Breakpoint{
+		ID:      d.nextID,
+		Address: start,
+		End:     end,
+	}
+
+	d.breakpoints[bp.ID] = bp
+	d.nextID++
+
+	return bp
+}
+
+// AddWatchpoint adds a watchpoint on the addresses start to end
+// inclusive.
+func (d *Debugger) AddWatchpoint(start, end uint16, kind WatchKind) *Watchpoint {
+	wp := &Watchpoint{
+		ID:    d.nextID,
+		Start: start,
+		End:   end,
+		Kind:  kind,
+	}
+
+	d.watchpoints[wp.ID] = wp
+	d.nextID++
+
+	return wp
+}
+
+// AddRegisterWatch adds a watchpoint on a register. If op is empty
+// any change stops execution, otherwise only changes for which the new
+// value compares to val with op.
+func (d *Debugger) AddRegisterWatch(r uint16, op string, val uint16) *RegisterWatch {
+	rw := &RegisterWatch{
+		ID:       d.nextID,
+		Register: r,
+		Op:       op,
+		Value:    val,
+	}
+
+	d.registerWatches[rw.ID] = rw
+	d.nextID++
+
+	return rw
+}
+
+// AddTrapBreakpoint adds a breakpoint on TRAP instructions invoking
+// a trap vector, or on every TRAP instruction if any is set.
+func (d *Debugger) AddTrapBreakpoint(vector uint16, any bool) *TrapBreakpoint {
+	tb := &TrapBreakpoint{
+		ID:     d.nextID,
+		Vector: vector,
+		Any:    any,
+	}
+
+	d.trapBreakpoints[tb.ID] = tb
+	d.nextID++
+
+	return tb
+}
+
+// SetCondition sets the condition of a breakpoint, an empty condition
+// makes it unconditional.
+func (d *Debugger) SetCondition(id int, condition string) error {
+	bp, ok := d.breakpoints[id]
+	if !ok {
+		return fmt.Errorf("no breakpoint %d", id)
+	}
+
+	if strings.TrimSpace(condition) == "" {
+		bp.Condition, bp.cond = "", nil
+		return nil
+	}
+
+	cond, err := parseExpr(condition)
+	if err != nil {
+		return err
+	}
+
+	bp.Condition, bp.cond = strings.TrimSpace(condition), cond
+
+	return nil
+}
+
+// SetIgnore makes a breakpoint ignore its next count hits.
+func (d *Debugger) SetIgnore(id int, count int) error {
+	bp, ok := d.breakpoints[id]
+	if !ok {
+		return fmt.Errorf("no breakpoint %d", id)
+	}
+
+	bp.Ignore = count
+
+	return nil
+}
+
+// DeleteBreakpoint deletes a breakpoint or watchpoint, it reports
+// whether it existed.
+func (d *Debugger) DeleteBreakpoint(id int) bool {
+	_, isBreak := d.breakpoints[id]
+	_, isWatch := d.watchpoints[id]
+	_, isRegisterWatch := d.registerWatches[id]
+	_, isTrap := d.trapBreakpoints[id]
+
+	delete(d.breakpoints, id)
+	delete(d.watchpoints, id)
+	delete(d.registerWatches, id)
+	delete(d.trapBreakpoints, id)
+
+	return isBreak || isWatch || isRegisterWatch || isTrap
+}
+
+// TrapBreakpoints returns the trap breakpoints ordered by ID.
+func (d *Debugger) TrapBreakpoints() []*TrapBreakpoint {
+	tbs := make([]*TrapBreakpoint, 0, len(d.trapBreakpoints))
+	for _, tb := range d.trapBreakpoints {
+		tbs = append(tbs, tb)
+	}
+
+	sort.Slice(tbs, func(i, j int) bool {
+		return tbs[i].ID < tbs[j].ID
+	})
+
+	return tbs
+}
+
+// RegisterWatches returns the register watchpoints ordered by ID.
+func (d *Debugger) RegisterWatches() []*RegisterWatch {
+	rws := make([]*RegisterWatch, 0, len(d.registerWatches))
+	for _, rw := range d.registerWatches {
+		rws = append(rws, rw)
+	}
+
+	sort.Slice(rws, func(i, j int) bool {
+		return rws[i].ID < rws[j].ID
+	})
+
+	return rws
+}
+
+// Watchpoints returns the watchpoints ordered by ID.
+func (d *Debugger) Watchpoints() []*Watchpoint {
+	wps := make([]*Watchpoint, 0, len(d.watchpoints))
+	for _, wp := range d.watchpoints {
+		wps = append(wps, wp)
+	}
+
+	sort.Slice(wps, func(i, j int) bool {
+		return wps[i].ID < wps[j].ID
+	})
+
+	return wps
+}
+
+// Breakpoints returns the breakpoints ordered by ID.
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	bps := make([]*Breakpoint, 0, len(d.breakpoints))
+	for _, bp := range d.breakpoints {
+		bps = append(bps, bp)
+	}
+
+	sort.Slice(bps, func(i, j int) bool {
+		return bps[i].ID < bps[j].ID
+	})
+
+	return bps
+}
+
+// breakpointAt returns a breakpoint at the address, if any.
+func (d *Debugger) breakpointAt(address uint16) *Breakpoint {
+	for _, bp := range d.Breakpoints() {
+		if address >= bp.Address && address <= bp.End {
+			return bp
+		}
+	}
+
+	return nil
+}
+
+// trapBreakpointAt returns a trap breakpoint triggered by the
+// instruction at the address, if any.
+func (d *Debugger) trapBreakpointAt(address uint16) *TrapBreakpoint {
+	instr := d.cpu.PeekMemory(address)
+
+	for _, tb := range d.TrapBreakpoints() {
+		if tb.matches(instr) {
+			return tb
+		}
+	}
+
+	return nil
+}
+
+// Step executes up to n instructions, stopping early if the program
+// halts or fails.
+func (d *Debugger) Step(n int) Stop {
+	for i := 0; i < n; i++ {
+		if stop, ok := d.execute(); !ok {
+			return stop
+		}
+	}
+
+	return d.stop(StopStep)
+}
+
+// Continue executes instructions until a breakpoint is reached or
+// the program halts or fails. The instruction at the current PC is
+// always executed, so continuing from a breakpoint makes progress.
+func (d *Debugger) Continue() Stop {
+	return d.resume(nil)
+}
+
+// Next executes a single instruction, treating a subroutine call as
+// one step by running until the subroutine returns to the instruction
+// following the call. Breakpoints inside the subroutine still stop
+// execution.
+func (d *Debugger) Next() Stop {
+	pc := d.cpu.Register(registers.RPC)
+
+	if !isCall(d.cpu.PeekMemory(pc)) {
+		return d.Step(1)
+	}
+
+	return d.runUntilReturn(pc + 1)
+}
+
+// Finish runs until the current subroutine returns, that is until a
+// RET unwinds the frame that was active when finish was issued.
+func (d *Debugger) Finish() Stop {
+	depth := d.stack.Depth()
+
+	return d.resume(func(instr uint16) bool {
+		return isReturn(instr) && (depth == 0 || d.stack.Depth() < depth)
+	})
+}
+
+// Until runs until the PC reaches an address, without leaving
+// a breakpoint behind. Breakpoints reached on the way still stop
+// execution.
+func (d *Debugger) Until(address uint16) Stop {
+	return d.resume(func(instr uint16) bool {
+		return d.cpu.Register(registers.RPC) == address
+	})
+}
+
+// runUntilReturn runs until the PC reaches the return address at the
+// same call depth, so that recursive calls returning to the same
+// address do not end the run early.
+func (d *Debugger) runUntilReturn(ret uint16) Stop {
+	depth := d.stack.Depth()
+
+	return d.resume(func(instr uint16) bool {
+		return d.stack.Depth() <= depth && d.cpu.Register(registers.RPC) == ret
+	})
+}
+
+// Interrupt stops a program that is being resumed, it may be called
+// from another goroutine.
+func (d *Debugger) Interrupt() {
+	d.interrupted.Store(true)
+}
+
+// SetInterrupter sets a function called whenever the program is
+// resumed that arranges for interrupt to be called, on Ctrl+C for
+// example, and returns a function undoing it once the program stops.
+func (d *Debugger) SetInterrupter(arm func(interrupt func()) func()) {
+	d.interrupter = arm
+}
+
+// resume executes instructions until a breakpoint is reached, the
+// program halts or fails, done reports true or the debugger is
+// interrupted. done is called after every instruction with the
+// instruction that was executed.
+func (d *Debugger) resume(done func(instr uint16) bool) Stop {
+	d.interrupted.Store(false)
+
+	if d.interrupter != nil {
+		defer d.interrupter(d.Interrupt)()
+	}
+
+	if d.fresh {
+		d.fresh = false
+
+		if stop, ok := d.breakAt(d.cpu.Register(registers.RPC)); ok {
+			return stop
+		}
+	}
+
+	for {
+		if d.interrupted.Swap(false) {
+			return d.stop(StopInterrupt)
+		}
+
+		instr := d.cpu.PeekMemory(d.cpu.Register(registers.RPC))
+
+		if stop, ok := d.execute(); !ok {
+			return stop
+		}
+
+		if stop, ok := d.breakAt(d.cpu.Register(registers.RPC)); ok {
+			return stop
+		}
+
+		if done != nil && done(instr) {
+			return d.stop(StopStep)
+		}
+	}
+}
+
+// breakAt returns the stop for a breakpoint or trap breakpoint at pc,
+// if one stops the program there.
+func (d *Debugger) breakAt(pc uint16) (Stop, bool) {
+	if bp := d.breakpointAt(pc); bp != nil {
+		hit, err := d.shouldStop(bp)
+		if hit || err != nil {
+			stop := d.stop(StopBreakpoint)
+			stop.Breakpoint = bp
+			stop.Err = err
+
+			if bp.Temporary && hit {
+				d.DeleteBreakpoint(bp.ID)
+			}
+
+			return stop, true
+		}
+	}
+
+	if tb := d.trapBreakpointAt(pc); tb != nil {
+		tb.Hits++
+
+		stop := d.stop(StopTrap)
+		stop.TrapBreakpoint = tb
+
+		return stop, true
+	}
+
+	return Stop{}, false
+}
+
+// shouldStop evaluates the condition of a breakpoint that was reached
+// and counts the hit against its ignore count.
+func (d *Debugger) shouldStop(bp *Breakpoint) (bool, error) {
+	if bp.cond != nil {
+		v, err := bp.cond.eval(d)
+		if err != nil {
+			return false, fmt.Errorf("evaluating condition of breakpoint %d: %w", bp.ID, err)
+		}
+
+		if v == 0 {
+			return false, nil
+		}
+	}
+
+	bp.Hits++
+
+	if bp.Ignore > 0 {
+		bp.Ignore--
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// execute executes a single instruction, it returns false along with
+// the stop if execution must stop.
+func (d *Debugger) execute() (Stop, bool) {
+	if !d.running {
+		return d.stop(StopHalt), false
+	}
+
+	d.watchStop = nil
+
+	pc := d.cpu.Register(registers.RPC)
+
+	var before [registers.RCOUNT]uint16
+	for r := range before {
+		before[r] = d.cpu.Register(uint16(r))
+	}
+
+	d.current = &delta{registers: before}
+
+	if changesStack(d.cpu.PeekMemory(pc)) {
+		d.current.frames = d.stack.Frames()
+		d.current.framesSaved = true
+	}
+
+	err := d.cpu.Execute()
+
+	d.history.push(d.current)
+	d.current = nil
+
+	if err != nil {
+		d.running = false
+
+		stop := d.stop(StopError)
+		stop.Err = err
+
+		return stop, false
+	}
+
+	if d.cpu.Halted() {
+		d.running = false
+
+		return d.stop(StopHalt), false
+	}
+
+	if d.watchStop != nil {
+		stop := *d.watchStop
+		stop.PC = d.cpu.Register(registers.RPC)
+		stop.Watchpoint.Hits++
+
+		return stop, false
+	}
+
+	for _, rw := range d.RegisterWatches() {
+		old, val := before[rw.Register], d.cpu.Register(rw.Register)
+
+		if rw.matches(old, val) {
+			rw.Hits++
+
+			stop := d.stop(StopRegisterWatch)
+			stop.RegisterWatch = rw
+			stop.Old = old
+			stop.New = val
+			stop.Source = pc
+
+			return stop, false
+		}
+	}
+
+	return Stop{}, true
+}
+
+// stop describes a stop at the current PC.
+func (d *Debugger) stop(reason StopReason) Stop {
+	return Stop{
+		Reason: reason,
+		PC:     d.cpu.Register(registers.RPC),
+	}
+}
+
+// report prints a description of a stop followed by the registers
+// and displays.
+func (d *Debugger) report(stop Stop) {
+	switch stop.Reason {
+	case StopBreakpoint:
+		fmt.Fprintf(d.out, "%s %d at %s\n", stop.Breakpoint.kind(), stop.Breakpoint.ID, d.formatAddress(stop.PC))
+
+		if stop.Err != nil {
+			fmt.Fprintf(d.out, "error: %v\n", stop.Err)
+		}
+	case StopHalt:
+		fmt.Fprintln(d.out, "Program halted.")
+	case StopError:
+		fmt.Fprintf(d.out, "Program failed at %s: %v\n", d.formatAddress(stop.PC), stop.Err)
+	case StopWatchpoint:
+		access := stop.Access
+
+		if access.Write {
+			fmt.Fprintf(d.out, "Watchpoint %d: %s written by instruction at %s\n", stop.Watchpoint.ID, d.formatAddress(access.Address), d.formatAddress(access.PC))
+			fmt.Fprintf(d.out, "Old value = x%04X\nNew value = x%04X\n", access.Old, access.Value)
+		} else {
+			fmt.Fprintf(d.out, "Watchpoint %d: %s read by instruction at %s\n", stop.Watchpoint.ID, d.formatAddress(access.Address), d.formatAddress(access.PC))
+			fmt.Fprintf(d.out, "Value = x%04X\n", access.Value)
+		}
+	case StopRegisterWatch:
+		fmt.Fprintf(d.out, "Watchpoint %d: %s changed by instruction at %s\n", stop.RegisterWatch.ID, registers.Name(stop.RegisterWatch.Register), d.formatAddress(stop.Source))
+		fmt.Fprintf(d.out, "Old value = x%04X\nNew value = x%04X\n", stop.Old, stop.New)
+	case StopInterrupt:
+		fmt.Fprintf(d.out, "Program interrupted at %s\n", d.formatAddress(stop.PC))
+	case StopTrap:
+		fmt.Fprintf(d.out, "Breakpoint %d, %s at %s\n", stop.TrapBreakpoint.ID, d.disassemble(stop.PC, d.cpu.PeekMemory(stop.PC)), d.formatAddress(stop.PC))
+	}
+
+	d.printListing()
+	d.printRegisters()
+	d.printDisplays()
+}
+
+// printRegisters prints the general purpose registers, the PC and
+// the condition codes.
+func (d *Debugger) printRegisters() {
+	for r := uint16(registers.RR0); r <= registers.RR7; r++ {
+		sep := "  "
+		if r%4 == 3 {
+			sep = "\n"
+		}
+
+		fmt.Fprintf(d.out, "R%d x%04X%s", r, d.cpu.Register(r), sep)
+	}
+
+	fmt.Fprintf(d.out, "PC x%04X  CC %s\n", d.cpu.Register(registers.RPC), ConditionCodes(d.cpu.Register(registers.RCOND)))
+}
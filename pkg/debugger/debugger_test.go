@@ -0,0 +1,362 @@
+package debugger
+
+import (
+	"io"
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"lc3/pkg/registers"
+	"strings"
+	"testing"
+)
+
+// source counts R1 up to 3, calls a subroutine saving it and prints a
+// message.
+const source = `
+		.ORIG x3000
+MAIN	AND R1, R1, #0
+LOOP	ADD R1, R1, #1
+		ADD R2, R1, #-3
+		BRn LOOP
+		JSR SUB
+		LEA R0, MSG
+		PUTS
+		HALT
+SUB		ST R1, SAVED
+		RET
+SAVED	.FILL 0
+MSG		.STRINGZ "hi"
+		.END`
+
+// Addresses of the labels of source.
+const (
+	addrMain  = 0x3000
+	addrLoop  = 0x3001
+	addrHalt  = 0x3007
+	addrSub   = 0x3008
+	addrSaved = 0x300A
+)
+
+// newDebugger creates a debugger for source, with its symbols loaded,
+// writing its output to out.
+func newDebugger(t *testing.T, out io.Writer) *Debugger {
+	t.Helper()
+
+	obj, table, diagnostics, err := asm.Assemble(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(diagnostics) > 0 {
+		t.Fatalf("%v", diagnostics)
+	}
+
+	var image [cpu.MemoryMax]uint16
+	copy(image[obj.Origin:], obj.Words)
+
+	newCPU := func() cpu.CPU {
+		return cpu.NewCPU(cpu.WithOutput(io.Discard))
+	}
+
+	d := New(newCPU, image, nil, out)
+	d.SetSymbols(table)
+
+	return d
+}
+
+// TestBreakpointAtEntry checks that a breakpoint on the first
+// instruction stops the program before it runs, and that continuing
+// from it does not stop there again.
+func TestBreakpointAtEntry(t *testing.T) {
+	d := newDebugger(t, io.Discard)
+	bp := d.AddBreakpoint(addrMain)
+
+	if stop := d.Continue(); stop.Reason != StopBreakpoint || stop.PC != addrMain {
+		t.Fatalf("stopped for %d at x%04X, expected the breakpoint at x%04X", stop.Reason, stop.PC, addrMain)
+	}
+
+	if stop := d.Continue(); stop.Reason != StopHalt {
+		t.Errorf("stopped for %d at x%04X, expected a halt", stop.Reason, stop.PC)
+	}
+
+	if bp.Hits != 1 {
+		t.Errorf("%d hits, expected 1", bp.Hits)
+	}
+}
+
+// TestBreakpoints checks the stops and hits of breakpoints with
+// conditions and ignore counts.
+func TestBreakpoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition string
+		ignore    int
+		stops     []uint16
+	}{
+		{name: "every pass", stops: []uint16{0, 1, 2}},
+		{name: "condition", condition: "R1 >= 1", stops: []uint16{1, 2}},
+		{name: "ignore", ignore: 2, stops: []uint16{2}},
+		{name: "condition and ignore", condition: "R1 != 0", ignore: 1, stops: []uint16{2}},
+		{name: "never", condition: "R1 > 5", stops: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newDebugger(t, io.Discard)
+			bp := d.AddBreakpoint(addrLoop)
+
+			if test.condition != "" {
+				if err := d.SetCondition(bp.ID, test.condition); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := d.SetIgnore(bp.ID, test.ignore); err != nil {
+				t.Fatal(err)
+			}
+
+			var stops []uint16
+
+			for stop := d.Continue(); stop.Reason == StopBreakpoint; stop = d.Continue() {
+				stops = append(stops, d.CPU().Register(registers.RR1))
+			}
+
+			if len(stops) != len(test.stops) {
+				t.Fatalf("stopped with R1 %v, expected %v", stops, test.stops)
+			}
+
+			for i := range stops {
+				if stops[i] != test.stops[i] {
+					t.Errorf("stopped with R1 %v, expected %v", stops, test.stops)
+				}
+			}
+		})
+	}
+}
+
+// TestTemporaryBreakpoint checks that a temporary breakpoint is
+// deleted once it stops the program.
+func TestTemporaryBreakpoint(t *testing.T) {
+	d := newDebugger(t, io.Discard)
+
+	if err := d.Exec("tbreak LOOP"); err != nil {
+		t.Fatal(err)
+	}
+
+	if stop := d.Continue(); stop.Reason != StopBreakpoint || stop.PC != addrLoop {
+		t.Fatalf("stopped for %d at x%04X", stop.Reason, stop.PC)
+	}
+
+	if len(d.Breakpoints()) != 0 {
+		t.Errorf("%d breakpoints left", len(d.Breakpoints()))
+	}
+}
+
+// TestHits checks that trap breakpoints and watchpoints count the
+// stops they cause.
+func TestHits(t *testing.T) {
+	d := newDebugger(t, io.Discard)
+
+	trap := d.AddTrapBreakpoint(0, true)
+	watch := d.AddWatchpoint(addrSaved, addrSaved, WatchWrite)
+	register := d.AddRegisterWatch(registers.RR2, "==", 0)
+
+	var reasons []StopReason
+
+	for stop := d.Continue(); stop.Reason != StopHalt; stop = d.Continue() {
+		if stop.Reason == StopError {
+			t.Fatal(stop.Err)
+		}
+
+		reasons = append(reasons, stop.Reason)
+	}
+
+	expected := []StopReason{StopRegisterWatch, StopWatchpoint, StopTrap, StopTrap}
+
+	if len(reasons) != len(expected) {
+		t.Fatalf("stops %v, expected %v", reasons, expected)
+	}
+
+	for i := range reasons {
+		if reasons[i] != expected[i] {
+			t.Errorf("stops %v, expected %v", reasons, expected)
+		}
+	}
+
+	if trap.Hits != 2 || watch.Hits != 1 || register.Hits != 1 {
+		t.Errorf("%d, %d and %d hits, expected 2, 1 and 1", trap.Hits, watch.Hits, register.Hits)
+	}
+}
+
+// TestStepBack checks that stepping back undoes the registers, memory
+// and calls of the instructions stepped over.
+func TestStepBack(t *testing.T) {
+	d := newDebugger(t, io.Discard)
+	d.AddBreakpoint(addrSub + 1)
+
+	if stop := d.Continue(); stop.Reason != StopBreakpoint {
+		t.Fatalf("stopped for %d at x%04X", stop.Reason, stop.PC)
+	}
+
+	if saved := d.CPU().PeekMemory(addrSaved); saved != 3 {
+		t.Fatalf("SAVED is %d, expected 3", saved)
+	}
+
+	if n := d.StepBack(2); n != 2 {
+		t.Fatalf("stepped back %d instructions, expected 2", n)
+	}
+
+	if pc := d.CPU().Register(registers.RPC); pc != addrSub-4 {
+		t.Errorf("PC x%04X, expected x%04X", pc, addrSub-4)
+	}
+
+	if saved := d.CPU().PeekMemory(addrSaved); saved != 0 {
+		t.Errorf("SAVED is %d, expected 0", saved)
+	}
+
+	if depth := len(d.Stack()); depth != 0 {
+		t.Errorf("%d frames, expected none", depth)
+	}
+}
+
+// TestStepBackCommand checks that "step back" undoes instructions like
+// reverse-step.
+func TestStepBackCommand(t *testing.T) {
+	d := newDebugger(t, io.Discard)
+	d.AddBreakpoint(addrSub + 1)
+
+	if stop := d.Continue(); stop.Reason != StopBreakpoint {
+		t.Fatalf("stopped for %d at x%04X", stop.Reason, stop.PC)
+	}
+
+	for _, test := range []struct {
+		command string
+		pc      uint16
+	}{
+		{"step back 2", addrSub - 4},
+		{"step back", addrSub - 5},
+		{"s back", addrSub - 6},
+	} {
+		if err := d.Exec(test.command); err != nil {
+			t.Fatalf("%s: %v", test.command, err)
+		}
+
+		if pc := d.CPU().Register(registers.RPC); pc != test.pc {
+			t.Errorf("%s: PC x%04X, expected x%04X", test.command, pc, test.pc)
+		}
+	}
+
+	if err := d.Exec("step back x"); err == nil {
+		t.Error("stepped back an invalid count")
+	}
+}
+
+// TestEvaluate checks expressions against the state of a stopped
+// program.
+func TestEvaluate(t *testing.T) {
+	d := newDebugger(t, io.Discard)
+	d.AddBreakpoint(addrHalt)
+
+	if stop := d.Continue(); stop.Reason != StopBreakpoint {
+		t.Fatalf("stopped for %d at x%04X", stop.Reason, stop.PC)
+	}
+
+	tests := []struct {
+		expression string
+		value      int
+	}{
+		{"R1", 3},
+		{"R1 * 2 + 1", 7},
+		{"(R1 + 1) % 3", 1},
+		{"PC", addrHalt},
+		{"x3000", 0x3000},
+		{"-1", -1},
+		{"LOOP + 1", addrLoop + 1},
+		{"MEM[SAVED]", 3},
+		{"R1 == 3 && R2 == 0", 1},
+		{"R1 < 3 || !R2", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			value, err := d.Evaluate(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if value != test.value {
+				t.Errorf("%d, expected %d", value, test.value)
+			}
+		})
+	}
+
+	for _, expression := range []string{"R9", "NOWHERE", "1 / 0", "(R1", "MEM[R1"} {
+		if _, err := d.Evaluate(expression); err == nil {
+			t.Errorf("%s evaluated", expression)
+		}
+	}
+}
+
+// TestExamine checks the output of examine in every format.
+func TestExamine(t *testing.T) {
+	tests := []struct {
+		command string
+		output  string
+	}{
+		{"x/2x MAIN", "x3000 <MAIN>:  x5260  x1261\n"},
+		{"x /2x MAIN", "x3000 <MAIN>:  x5260  x1261\n"},
+		{"x/4xw2 MAIN", "x3000 <MAIN>:  x5260  x1261\nx3002 <LOOP+1>:  x147D  x09FD\n"},
+		{"examine /1d LOOP+2", "x3003 <LOOP+2>:    2557\n"},
+		{"x/1i LOOP+2", "x3003 <LOOP+2>:  BRn LOOP\n"},
+		{"x/2i SUB", "x3008 <SUB>:  ST R1, SAVED\nx3009 <SUB+1>:  RET\n"},
+		{"x/s MSG", "x300B <MSG>:  \"hi\"\n"},
+		{"x/2c MSG", "x300B <MSG>:  'h'       'i'     \n"},
+		{"x/x MAIN, 1", "x3000 <MAIN>:  x5260\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.command, func(t *testing.T) {
+			var out strings.Builder
+
+			d := newDebugger(t, &out)
+
+			if err := d.Exec(test.command); err != nil {
+				t.Fatal(err)
+			}
+
+			if out.String() != test.output {
+				t.Errorf("output %q, expected %q", out.String(), test.output)
+			}
+		})
+	}
+}
+
+// TestParseExamineSpec checks the count, format and width of examine
+// specs.
+func TestParseExamineSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want examineSpec
+	}{
+		{"", examineSpec{1, 'x', 8}},
+		{"16", examineSpec{16, 'x', 8}},
+		{"d", examineSpec{1, 'd', 8}},
+		{"16x4", examineSpec{16, 'x', 4}},
+		{"8xw4", examineSpec{8, 'x', 4}},
+		{"3i", examineSpec{3, 'i', 8}},
+	}
+
+	for _, test := range tests {
+		got, err := parseExamineSpec(test.spec)
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+		} else if got != test.want {
+			t.Errorf("%q: %+v, expected %+v", test.spec, got, test.want)
+		}
+	}
+
+	for _, spec := range []string{"q", "0x", "x0", "4xw", "4x4x"} {
+		if _, err := parseExamineSpec(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
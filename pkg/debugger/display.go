@@ -0,0 +1,112 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Display is an expression printed whenever execution stops.
+type Display struct {
+	// ID identifies the display in commands.
+	ID int
+
+	// Expression is the displayed expression.
+	Expression string
+
+	// e is the parsed expression.
+	e expr
+}
+
+// AddDisplay adds an expression printed whenever execution stops.
+func (d *Debugger) AddDisplay(expression string) (*Display, error) {
+	e, err := parseExpr(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	d.nextDisplay++
+
+	disp := &Display{
+		ID:         d.nextDisplay,
+		Expression: strings.TrimSpace(expression),
+		e:          e,
+	}
+
+	d.displays = append(d.displays, disp)
+
+	return disp, nil
+}
+
+// DeleteDisplay deletes a display, it reports whether it existed.
+func (d *Debugger) DeleteDisplay(id int) bool {
+	for i, disp := range d.displays {
+		if disp.ID == id {
+			d.displays = append(d.displays[:i], d.displays[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Displays returns the displays in the order they were added.
+func (d *Debugger) Displays() []*Display {
+	return d.displays
+}
+
+// printDisplay prints the current value of a display.
+func (d *Debugger) printDisplay(disp *Display) {
+	v, err := disp.e.eval(d)
+	if err != nil {
+		fmt.Fprintf(d.out, "%d: %s = <%v>\n", disp.ID, disp.Expression, err)
+		return
+	}
+
+	fmt.Fprintf(d.out, "%d: %s = x%04X (%d)\n", disp.ID, disp.Expression, uint16(v), v)
+}
+
+// printDisplays prints the current value of every display.
+func (d *Debugger) printDisplays() {
+	for _, disp := range d.displays {
+		d.printDisplay(disp)
+	}
+}
+
+// handleDisplay handles the display command.
+func handleDisplay(d *Debugger, args []string) error {
+	if len(args) == 0 {
+		d.printDisplays()
+		return nil
+	}
+
+	disp, err := d.AddDisplay(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+
+	d.printDisplay(disp)
+
+	return nil
+}
+
+// handleUndisplay handles the undisplay command.
+func handleUndisplay(d *Debugger, args []string) error {
+	if len(args) == 0 {
+		d.displays = nil
+		return nil
+	}
+
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid display %q", arg)
+		}
+
+		if !d.DeleteDisplay(id) {
+			return fmt.Errorf("no display %d", id)
+		}
+	}
+
+	return nil
+}
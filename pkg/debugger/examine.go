@@ -0,0 +1,189 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// examineFormats maps examine format letters to a description.
+var examineFormats = map[byte]string{
+	'x': "hex",
+	'd': "signed decimal",
+	'u': "unsigned decimal",
+	'c': "character",
+	's': "string",
+	'i': "instruction",
+}
+
+// examineSpec is the count, format and width of an examine command.
+type examineSpec struct {
+	// count is the number of words, strings or instructions.
+	count int
+
+	// format is the format letter.
+	format byte
+
+	// width is the number of words per line.
+	width int
+}
+
+// parseExamineSpec parses the /NFW suffix of the examine command, for
+// example x/16x8 shows 16 hex words, 8 per line. The width may be
+// written after a w, as in x/16xw8.
+func parseExamineSpec(spec string) (examineSpec, error) {
+	es := examineSpec{count: 1, format: 'x', width: 8}
+
+	digits := func() (int, bool) {
+		i := 0
+		for i < len(spec) && spec[i] >= '0' && spec[i] <= '9' {
+			i++
+		}
+
+		if i == 0 {
+			return 0, false
+		}
+
+		n, _ := strconv.Atoi(spec[:i])
+		spec = spec[i:]
+
+		return n, true
+	}
+
+	if n, ok := digits(); ok {
+		es.count = n
+	}
+
+	if spec != "" {
+		if _, ok := examineFormats[spec[0]]; !ok {
+			return es, fmt.Errorf("invalid examine format %q", spec[0])
+		}
+
+		es.format = spec[0]
+		spec = spec[1:]
+	}
+
+	if strings.HasPrefix(spec, "w") && len(spec) > 1 {
+		spec = spec[1:]
+	}
+
+	if n, ok := digits(); ok {
+		es.width = n
+	}
+
+	if spec != "" || es.count < 1 || es.width < 1 {
+		return es, fmt.Errorf("invalid examine spec")
+	}
+
+	return es, nil
+}
+
+// handleExamine handles the examine command, x[/NFW] [ADDR[, N]].
+// The spec may also be given apart from the name, as in x /16x. A
+// count following the address overrides the count of the spec.
+func handleExamine(d *Debugger, args []string, spec string) error {
+	if spec == "" && len(args) > 0 && strings.HasPrefix(args[0], "/") {
+		spec, args = args[0][1:], args[1:]
+	}
+
+	es, err := parseExamineSpec(spec)
+	if err != nil {
+		return err
+	}
+
+	address := d.nextExamine
+
+	if len(args) > 0 {
+		addr, count, hasCount := strings.Cut(strings.Join(args, " "), ",")
+
+		address, err = d.parseAddress(strings.TrimSpace(addr))
+		if err != nil {
+			return err
+		}
+
+		if hasCount {
+			n, err := strconv.Atoi(strings.TrimSpace(count))
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid count %q", strings.TrimSpace(count))
+			}
+
+			es.count = n
+		}
+	}
+
+	switch es.format {
+	case 'i':
+		for i := 0; i < es.count; i++ {
+			fmt.Fprintf(d.out, "%s:  %s\n", d.formatAddress(address), d.disassemble(address, d.cpu.PeekMemory(address)))
+			address++
+		}
+	case 's':
+		for i := 0; i < es.count; i++ {
+			start := address
+
+			var sb strings.Builder
+			for char := d.cpu.PeekMemory(address); char != 0; char = d.cpu.PeekMemory(address) {
+				sb.WriteString(escapeChar(char))
+				address++
+			}
+
+			address++
+
+			fmt.Fprintf(d.out, "%s:  \"%s\"\n", d.formatAddress(start), sb.String())
+		}
+	default:
+		for i := 0; i < es.count; i++ {
+			if i%es.width == 0 {
+				if i > 0 {
+					fmt.Fprintln(d.out)
+				}
+
+				fmt.Fprintf(d.out, "%s:", d.formatAddress(address))
+			}
+
+			fmt.Fprintf(d.out, "  %s", formatWord(d.cpu.PeekMemory(address), es.format))
+			address++
+		}
+
+		fmt.Fprintln(d.out)
+	}
+
+	d.nextExamine = address
+
+	return nil
+}
+
+// formatWord renders a word in one of the word formats.
+func formatWord(word uint16, format byte) string {
+	switch format {
+	case 'd':
+		return fmt.Sprintf("%6d", int16(word))
+	case 'u':
+		return fmt.Sprintf("%5d", word)
+	case 'c':
+		return fmt.Sprintf("%-8s", "'"+escapeChar(word)+"'")
+	}
+
+	return fmt.Sprintf("x%04X", word)
+}
+
+// escapeChar renders a character, escaping anything that is not
+// printable ASCII.
+func escapeChar(char uint16) string {
+	switch {
+	case char == '\n':
+		return "\\n"
+	case char == '\t':
+		return "\\t"
+	case char == '\\' || char == '\'' || char == '"':
+		return "\\" + string(rune(char))
+	case char >= 0x20 && char < 0x7F:
+		return string(rune(char))
+	}
+
+	if char > 0xFF {
+		return fmt.Sprintf("\\x%04X", char)
+	}
+
+	return fmt.Sprintf("\\x%02X", char)
+}
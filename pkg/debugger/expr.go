@@ -0,0 +1,398 @@
+package debugger
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// expr is a parsed debugger expression. Registers and memory evaluate
+// to their unsigned 16-bit values, arithmetic is carried out on plain
+// integers, and comparisons and logical operators yield 1 or 0.
+type expr interface {
+	eval(d *Debugger) (int, error)
+}
+
+// numberExpr is a literal number.
+type numberExpr int
+
+// registerExpr reads a register.
+type registerExpr uint16
+
+// memoryExpr reads a word of memory.
+type memoryExpr struct {
+	address expr
+}
+
+// unaryExpr applies a unary operator.
+type unaryExpr struct {
+	op      string
+	operand expr
+}
+
+// binaryExpr applies a binary operator.
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+// labelExpr is the address of a label.
+type labelExpr string
+
+// callExpr applies a builtin function.
+type callExpr struct {
+	name string
+	arg  expr
+}
+
+func (e numberExpr) eval(d *Debugger) (int, error) {
+	return int(e), nil
+}
+
+func (e registerExpr) eval(d *Debugger) (int, error) {
+	return int(d.cpu.Register(uint16(e))), nil
+}
+
+func (e labelExpr) eval(d *Debugger) (int, error) {
+	address, ok := d.symbols.Address(string(e))
+	if !ok {
+		return 0, fmt.Errorf("unknown label %q", string(e))
+	}
+
+	return int(address), nil
+}
+
+func (e memoryExpr) eval(d *Debugger) (int, error) {
+	address, err := e.address.eval(d)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(d.cpu.PeekMemory(uint16(address))), nil
+}
+
+func (e unaryExpr) eval(d *Debugger) (int, error) {
+	v, err := e.operand.eval(d)
+	if err != nil {
+		return 0, err
+	}
+
+	switch e.op {
+	case "-":
+		return -v, nil
+	case "~":
+		return ^v, nil
+	}
+
+	return boolInt(v == 0), nil
+}
+
+func (e binaryExpr) eval(d *Debugger) (int, error) {
+	l, err := e.left.eval(d)
+	if err != nil {
+		return 0, err
+	}
+
+	// the logical operators short circuit.
+	switch e.op {
+	case "&&":
+		if l == 0 {
+			return 0, nil
+		}
+	case "||":
+		if l != 0 {
+			return 1, nil
+		}
+	}
+
+	r, err := e.right.eval(d)
+	if err != nil {
+		return 0, err
+	}
+
+	switch e.op {
+	case "&&", "||":
+		return boolInt(r != 0), nil
+	case "==":
+		return boolInt(l == r), nil
+	case "!=":
+		return boolInt(l != r), nil
+	case "<":
+		return boolInt(l < r), nil
+	case "<=":
+		return boolInt(l <= r), nil
+	case ">":
+		return boolInt(l > r), nil
+	case ">=":
+		return boolInt(l >= r), nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+
+		if e.op == "/" {
+			return l / r, nil
+		}
+
+		return l % r, nil
+	case "&":
+		return l & r, nil
+	case "|":
+		return l | r, nil
+	case "^":
+		return l ^ r, nil
+	}
+
+	return 0, fmt.Errorf("unknown operator %q", e.op)
+}
+
+func (e callExpr) eval(d *Debugger) (int, error) {
+	v, err := e.arg.eval(d)
+	if err != nil {
+		return 0, err
+	}
+
+	// signed interprets a 16-bit value as two's complement.
+	return int(int16(uint16(v))), nil
+}
+
+// boolInt converts a truth value to 1 or 0.
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// precedence lists the binary operators from loosest to tightest
+// binding.
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// functions are the builtin functions of the expression language.
+var functions = map[string]bool{
+	"signed": true,
+}
+
+// exprParser is a recursive descent parser for expressions.
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+// parseExpr parses an expression such as R2 == 0 && MEM[x4000] > 10.
+// Operands are numbers, registers R0-R7 and PC, MEM[address] and
+// signed(value), combined with the usual C operators.
+func parseExpr(s string) (expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens}
+
+	e, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in expression", p.tokens[p.pos])
+	}
+
+	return e, nil
+}
+
+// peek returns the next token, or "" at the end.
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+
+	return ""
+}
+
+// expect consumes the given token.
+func (p *exprParser) expect(tok string) error {
+	if p.peek() != tok {
+		return fmt.Errorf("expected %q in expression", tok)
+	}
+
+	p.pos++
+
+	return nil
+}
+
+// binary parses binary operators of the given precedence level and
+// tighter.
+func (p *exprParser) binary(level int) (expr, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+
+		matched := false
+		for _, candidate := range precedence[level] {
+			if op == candidate {
+				matched = true
+			}
+		}
+
+		if !matched {
+			return left, nil
+		}
+
+		p.pos++
+
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+// unary parses unary operators and operands.
+func (p *exprParser) unary() (expr, error) {
+	switch op := p.peek(); op {
+	case "-", "!", "~":
+		p.pos++
+
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return unaryExpr{op: op, operand: operand}, nil
+	}
+
+	return p.operand()
+}
+
+// operand parses a number, register, label, memory reference,
+// function call or parenthesized expression.
+func (p *exprParser) operand() (expr, error) {
+	tok := p.peek()
+	if tok == "" {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	p.pos++
+
+	switch {
+	case tok == "(":
+		e, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+
+		return e, p.expect(")")
+	case strings.EqualFold(tok, "MEM"):
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+
+		address, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+
+		return memoryExpr{address: address}, p.expect("]")
+	case functions[strings.ToLower(tok)]:
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+
+		arg, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+
+		return callExpr{name: strings.ToLower(tok), arg: arg}, p.expect(")")
+	}
+
+	if r, ok := parseRegister(tok); ok {
+		return registerExpr(r), nil
+	}
+
+	n, err := parseNumber(tok)
+	if err != nil {
+		if c := rune(tok[0]); c == '_' || unicode.IsLetter(c) {
+			return labelExpr(tok), nil
+		}
+
+		return nil, err
+	}
+
+	return numberExpr(n), nil
+}
+
+// tokenize splits an expression into tokens.
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case isWordChar(c) || c == '#':
+			j := i + 1
+			for j < len(s) && isWordChar(rune(s[j])) {
+				j++
+			}
+
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			if i+1 < len(s) {
+				switch two := s[i : i+2]; two {
+				case "&&", "||", "==", "!=", "<=", ">=":
+					tokens = append(tokens, two)
+					i += 2
+
+					continue
+				}
+			}
+
+			if !strings.ContainsRune("()[]+-*/%&|^~!<>", c) {
+				return nil, fmt.Errorf("unexpected %q in expression", c)
+			}
+
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+
+	return tokens, nil
+}
+
+// isWordChar reports whether c can be part of a number or name.
+func isWordChar(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
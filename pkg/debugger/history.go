@@ -0,0 +1,120 @@
+package debugger
+
+import (
+	"lc3/pkg/callstack"
+	"lc3/pkg/disasm"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+)
+
+// HistorySize is the number of instructions that can be stepped back.
+const HistorySize = 1000
+
+// memoryWrite records the value a memory location held before an
+// instruction wrote to it.
+type memoryWrite struct {
+	address uint16
+	old     uint16
+}
+
+// delta records the machine state an instruction changed, so that its
+// effects can be undone. Effects on devices cannot be undone.
+type delta struct {
+	// registers are the registers before the instruction.
+	registers [registers.RCOUNT]uint16
+
+	// writes are the memory writes made by the instruction.
+	writes []memoryWrite
+
+	// frames is the shadow call stack before the instruction, it is
+	// only recorded for instructions that may change it.
+	frames []callstack.Frame
+
+	// framesSaved is set when frames was recorded.
+	framesSaved bool
+}
+
+// history is a bounded ring buffer of deltas, oldest first.
+type history struct {
+	// entries holds the deltas.
+	entries []*delta
+
+	// start is the index of the oldest delta.
+	start int
+}
+
+// push records a delta, evicting the oldest once the buffer is full.
+func (h *history) push(d *delta) {
+	if len(h.entries) < HistorySize {
+		h.entries = append(h.entries, d)
+		return
+	}
+
+	h.entries[h.start] = d
+	h.start = (h.start + 1) % HistorySize
+}
+
+// pop removes and returns the newest delta.
+func (h *history) pop() (*delta, bool) {
+	if len(h.entries) == 0 {
+		return nil, false
+	}
+
+	if len(h.entries) < HistorySize || h.start == 0 {
+		last := h.entries[len(h.entries)-1]
+		h.entries = h.entries[:len(h.entries)-1]
+
+		return last, true
+	}
+
+	// the buffer wrapped around, unroll it so that the newest delta
+	// is last.
+	h.entries = append(h.entries[h.start:], h.entries[:h.start]...)
+	h.start = 0
+
+	return h.pop()
+}
+
+// len returns the number of recorded deltas.
+func (h *history) len() int {
+	return len(h.entries)
+}
+
+// changesStack reports whether an instruction may change the shadow
+// call stack.
+func changesStack(instr uint16) bool {
+	switch disasm.Decode(instr).Opcode {
+	case opcodes.OPJSR, opcodes.OPJMP, opcodes.OPTRAP, opcodes.OPRTI:
+		return true
+	}
+
+	return false
+}
+
+// StepBack undoes up to n instructions, it returns the number that
+// were undone.
+func (d *Debugger) StepBack(n int) int {
+	for i := 0; i < n; i++ {
+		delta, ok := d.history.pop()
+		if !ok {
+			return i
+		}
+
+		for j := len(delta.writes) - 1; j >= 0; j-- {
+			d.cpu.PokeMemory(delta.writes[j].address, delta.writes[j].old)
+		}
+
+		for r, val := range delta.registers {
+			d.cpu.SetRegister(uint16(r), val)
+		}
+
+		if delta.framesSaved {
+			d.stack.Restore(delta.frames)
+		}
+
+		d.cpu.SetHalted(false)
+		d.running = true
+	}
+
+	return n
+}
@@ -0,0 +1,294 @@
+package debugger
+
+import (
+	"fmt"
+	"lc3/pkg/cpu"
+	"lc3/pkg/registers"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// infoTopics maps the topics of the info command and their
+// abbreviations to handlers.
+var infoTopics = map[string]func(d *Debugger){
+	"registers":   infoRegisters,
+	"reg":         infoRegisters,
+	"r":           infoRegisters,
+	"breakpoints": infoBreakpoints,
+	"break":       infoBreakpoints,
+	"b":           infoBreakpoints,
+	"watchpoints": infoBreakpoints,
+	"devices":     infoDevices,
+	"dev":         infoDevices,
+	"stack":       infoStack,
+	"s":           infoStack,
+	"display":     infoDisplay,
+	"checkpoints": infoCheckpoints,
+}
+
+// deviceRegisterNames maps the addresses of memory-mapped registers
+// to their names.
+var deviceRegisterNames = map[uint16]string{
+	registers.MRKBSR:  "KBSR",
+	registers.MRKBDR:  "KBDR",
+	registers.MRRTCUP: "RTCUP",
+	registers.MRRTCHR: "RTCHR",
+	registers.MRRTCMN: "RTCMN",
+	registers.MRRTCSC: "RTCSC",
+	registers.MRMBSR:  "MBSR",
+	registers.MRMBDR:  "MBDR",
+	registers.MRTCCOL: "TCCOL",
+	registers.MRTCROW: "TCROW",
+	registers.MRTCCLR: "TCCLR",
+	registers.MRTCCMD: "TCCMD",
+	registers.MRJOYSR: "JOYSR",
+}
+
+// handleInfo handles the info command.
+func handleInfo(d *Debugger, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: info registers|breakpoints|devices|stack|display|checkpoints")
+	}
+
+	topic, ok := infoTopics[strings.ToLower(args[0])]
+	if !ok {
+		return fmt.Errorf("unknown info topic %q", args[0])
+	}
+
+	topic(d)
+
+	return nil
+}
+
+// newTable returns a writer aligning tab separated columns.
+func (d *Debugger) newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(d.out, 0, 0, 2, ' ', 0)
+}
+
+// infoRegisters shows every register in hex, unsigned and signed,
+// with the condition codes decoded.
+func infoRegisters(d *Debugger) {
+	w := d.newTable()
+
+	fmt.Fprintln(w, "Register\tHex\tUnsigned\tSigned")
+
+	for r := uint16(registers.RR0); r <= registers.RR7; r++ {
+		val := d.cpu.Register(r)
+		fmt.Fprintf(w, "R%d\tx%04X\t%d\t%d\n", r, val, val, int16(val))
+	}
+
+	pc := d.cpu.Register(registers.RPC)
+	fmt.Fprintf(w, "PC\tx%04X\t%d\t\t%s\n", pc, pc, d.symbols.Format(pc))
+
+	cond := d.cpu.Register(registers.RCOND)
+	fmt.Fprintf(w, "CC\tx%04X\t%s\t\tN=%d Z=%d P=%d\n", cond, ConditionCodes(cond), cond>>2&1, cond>>1&1, cond&1)
+
+	w.Flush()
+}
+
+// infoBreakpoints shows every breakpoint and watchpoint.
+func infoBreakpoints(d *Debugger) {
+	type row struct {
+		id                     int
+		kind, disp, what, hits string
+	}
+
+	var rows []row
+
+	for _, bp := range d.Breakpoints() {
+		what := d.formatAddress(bp.Address)
+		if bp.End != bp.Address {
+			what += ".." + d.formatAddress(bp.End)
+		}
+
+		if bp.Condition != "" {
+			what += " if " + bp.Condition
+		}
+
+		if bp.Ignore > 0 {
+			what += fmt.Sprintf(" (ignore next %d hits)", bp.Ignore)
+		}
+
+		disp := "keep"
+		if bp.Temporary {
+			disp = "del"
+		}
+
+		rows = append(rows, row{bp.ID, "breakpoint", disp, what, fmt.Sprint(bp.Hits)})
+	}
+
+	kinds := map[WatchKind]string{WatchWrite: "watchpoint", WatchRead: "rwatchpoint", WatchAccess: "awatchpoint"}
+
+	for _, wp := range d.Watchpoints() {
+		what := d.formatAddress(wp.Start)
+		if wp.End != wp.Start {
+			what += ".." + d.formatAddress(wp.End)
+		}
+
+		rows = append(rows, row{wp.ID, kinds[wp.Kind], "keep", what, fmt.Sprint(wp.Hits)})
+	}
+
+	for _, rw := range d.RegisterWatches() {
+		what := registers.Name(rw.Register)
+		if rw.Op != "" {
+			what += fmt.Sprintf(" %s x%04X", rw.Op, rw.Value)
+		}
+
+		rows = append(rows, row{rw.ID, "watchpoint", "keep", what, fmt.Sprint(rw.Hits)})
+	}
+
+	for _, tb := range d.TrapBreakpoints() {
+		what := "any trap"
+		if !tb.Any {
+			what = fmt.Sprintf("trap x%02X", tb.Vector)
+		}
+
+		rows = append(rows, row{tb.ID, "breakpoint", "keep", what, fmt.Sprint(tb.Hits)})
+	}
+
+	if len(rows) == 0 {
+		fmt.Fprintln(d.out, "No breakpoints or watchpoints.")
+		return
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].id < rows[j].id
+	})
+
+	w := d.newTable()
+
+	fmt.Fprintln(w, "ID\tType\tDisp\tHits\tWhere")
+
+	for _, r := range rows {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", r.id, r.kind, r.disp, r.hits, r.what)
+	}
+
+	w.Flush()
+}
+
+// infoDevices shows the attached devices and their registers.
+func infoDevices(d *Debugger) {
+	devices := d.cpu.Devices()
+
+	addresses := make([]uint16, 0, len(devices))
+	for address := range devices {
+		addresses = append(addresses, address)
+	}
+
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i] < addresses[j]
+	})
+
+	// group the registers of every device in address order.
+	var order []cpu.Device
+	owned := map[cpu.Device][]uint16{}
+
+	for _, address := range addresses {
+		device := devices[address]
+
+		if _, ok := owned[device]; !ok {
+			order = append(order, device)
+		}
+
+		owned[device] = append(owned[device], address)
+	}
+
+	w := d.newTable()
+
+	fmt.Fprintln(w, "Device\tRegisters")
+
+	if _, ok := devices[registers.MRKBSR]; !ok {
+		fmt.Fprintf(w, "Keyboard (built-in)\t%s\n", formatDeviceRegisters([]uint16{registers.MRKBSR, registers.MRKBDR}))
+	}
+
+	for _, device := range order {
+		fmt.Fprintf(w, "%s\t%s\n", deviceName(device), formatDeviceRegisters(owned[device]))
+	}
+
+	w.Flush()
+}
+
+// deviceName derives the name of a device from its type.
+func deviceName(device cpu.Device) string {
+	name := fmt.Sprintf("%T", device)
+
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return strings.TrimPrefix(name, "*")
+}
+
+// formatDeviceRegisters lists register addresses along with their
+// names.
+func formatDeviceRegisters(addresses []uint16) string {
+	parts := make([]string, len(addresses))
+
+	for i, address := range addresses {
+		parts[i] = fmt.Sprintf("x%04X", address)
+
+		if name, ok := deviceRegisterNames[address]; ok {
+			parts[i] += " " + name
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// infoStack shows the frames of the shadow call stack, innermost
+// first, with where they were called from and return to.
+func infoStack(d *Debugger) {
+	frames := d.Stack()
+	pc := d.cpu.Register(registers.RPC)
+
+	w := d.newTable()
+
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+
+		kind := "called"
+		if f.Trap {
+			kind = "trapped"
+		}
+
+		fmt.Fprintf(w, "#%d\t%s\t%s from %s\treturns to %s\n", len(frames)-1-i, d.formatAddress(pc), kind, d.formatAddress(f.Call), d.formatAddress(f.Return))
+
+		pc = f.Call
+	}
+
+	fmt.Fprintf(w, "#%d\t%s\n", len(frames), d.formatAddress(pc))
+
+	w.Flush()
+}
+
+// infoDisplay shows the display expressions.
+func infoDisplay(d *Debugger) {
+	if len(d.displays) == 0 {
+		fmt.Fprintln(d.out, "No display expressions.")
+		return
+	}
+
+	for _, disp := range d.displays {
+		fmt.Fprintf(d.out, "%d: %s\n", disp.ID, disp.Expression)
+	}
+}
+
+// infoCheckpoints lists the checkpoints that can be rewound to.
+func infoCheckpoints(d *Debugger) {
+	if d.checkpoints == nil {
+		fmt.Fprintln(d.out, "No checkpoints are taken.")
+		return
+	}
+
+	checkpoints := d.checkpoints.Checkpoints()
+	if len(checkpoints) == 0 {
+		fmt.Fprintf(d.out, "No checkpoints yet, one is taken every %d instructions.\n", d.checkpointEvery)
+		return
+	}
+
+	for i, cp := range checkpoints {
+		pc := cp.State.Registers[registers.RPC]
+		fmt.Fprintf(d.out, "%-3d after %d instructions at %s\n", len(checkpoints)-i, cp.Instructions, d.formatAddress(pc))
+	}
+}
@@ -0,0 +1,141 @@
+package debugger
+
+import (
+	"fmt"
+	"lc3/pkg/debuginfo"
+	"lc3/pkg/registers"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ListingBefore is the number of instructions shown before the PC.
+	ListingBefore = 3
+
+	// ListingAfter is the number of instructions shown after the PC.
+	ListingAfter = 4
+)
+
+// printListing prints a window of the source around the PC if the
+// program has debug info, or of disassembled instructions otherwise,
+// marking the instruction that executes next.
+func (d *Debugger) printListing() {
+	pc := d.cpu.Register(registers.RPC)
+
+	if location, ok := d.debugInfo.Location(pc); ok {
+		if err := d.printSource(location, true); err == nil {
+			return
+		}
+	}
+
+	for i := -ListingBefore; i <= ListingAfter; i++ {
+		address := pc + uint16(i)
+
+		if label, ok := d.symbols.Name(address); ok {
+			fmt.Fprintf(d.out, "%s:\n", label)
+		}
+
+		marker := "  "
+		if address == pc {
+			marker = "=>"
+		}
+
+		word := d.cpu.PeekMemory(address)
+		fmt.Fprintf(d.out, "%s x%04X:  %04X  %s\n", marker, address, word, d.disassemble(address, word))
+	}
+}
+
+// printSource prints a window of source lines around a location,
+// marking it if current is set.
+func (d *Debugger) printSource(location debuginfo.Location, current bool) error {
+	lines, err := d.sourceLines(location.File)
+	if err != nil {
+		return err
+	}
+
+	if location.Line > len(lines) {
+		return fmt.Errorf("%s has only %d lines", location.File, len(lines))
+	}
+
+	if location.Scope != "" {
+		fmt.Fprintf(d.out, "%s, in %s\n", location, location.Scope)
+	} else {
+		fmt.Fprintf(d.out, "%s\n", location)
+	}
+
+	first := max(location.Line-ListingBefore, 1)
+	last := min(location.Line+ListingAfter, len(lines))
+
+	for n := first; n <= last; n++ {
+		marker := "  "
+		if current && n == location.Line {
+			marker = "=>"
+		}
+
+		fmt.Fprintf(d.out, "%s %4d  %s\n", marker, n, lines[n-1])
+	}
+
+	return nil
+}
+
+// sourceLines returns the lines of a source file, reading it the first
+// time it is needed.
+func (d *Debugger) sourceLines(file string) ([]string, error) {
+	if lines, ok := d.sources[file]; ok {
+		return lines, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n"), "\n")
+	d.sources[file] = lines
+
+	return lines, nil
+}
+
+// parseLocation parses a source location written as FILE:LINE.
+func (d *Debugger) parseLocation(s string) (debuginfo.Location, bool) {
+	file, line, ok := strings.Cut(s, ":")
+	if !ok || file == "" {
+		return debuginfo.Location{}, false
+	}
+
+	n, err := strconv.Atoi(line)
+	if err != nil || n < 1 {
+		return debuginfo.Location{}, false
+	}
+
+	return debuginfo.Location{File: file, Line: n}, true
+}
+
+// handleList handles the list command.
+func handleList(d *Debugger, args []string) error {
+	if len(args) == 0 {
+		d.printListing()
+		return nil
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("usage: list [FILE:LINE]")
+	}
+
+	location, ok := d.parseLocation(args[0])
+	if !ok {
+		return fmt.Errorf("invalid source location %q", args[0])
+	}
+
+	address, ok := d.debugInfo.Address(location.File, location.Line)
+	if !ok {
+		return fmt.Errorf("no code at %s", location)
+	}
+
+	// show the file as named by the debug info.
+	found, _ := d.debugInfo.Location(address)
+	location.File = found.File
+
+	return d.printSource(location, false)
+}
@@ -0,0 +1,151 @@
+package debugger
+
+import (
+	"fmt"
+	"lc3/pkg/cflags"
+	"lc3/pkg/disasm"
+	"lc3/pkg/registers"
+	"lc3/pkg/traps"
+	"strconv"
+	"strings"
+)
+
+// parseRegister parses a register name, R0-R7 or PC.
+func parseRegister(s string) (uint16, bool) {
+	r, ok := registers.Lookup(s)
+
+	return r, ok && r != registers.RCOND
+}
+
+// parseNumber parses a number written in LC3 assembly style: x3000
+// or 0x3000 for hex, #12 or 12 for decimal. Negative decimal numbers
+// are converted to their two's complement form.
+func parseNumber(s string) (uint16, error) {
+	lower := strings.ToLower(s)
+
+	var (
+		n   int64
+		err error
+	)
+
+	switch {
+	case strings.HasPrefix(lower, "0x"):
+		n, err = strconv.ParseInt(lower[2:], 16, 32)
+	case strings.HasPrefix(lower, "x"):
+		n, err = strconv.ParseInt(lower[1:], 16, 32)
+	case strings.HasPrefix(lower, "#"):
+		n, err = strconv.ParseInt(lower[1:], 10, 32)
+	default:
+		n, err = strconv.ParseInt(lower, 10, 32)
+	}
+
+	if err != nil || n < -0x8000 || n > 0xFFFF {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+
+	return uint16(n), nil
+}
+
+// parseAddress parses an address given as a label, a number, a source
+// location such as prog.asm:12 or an expression such as LOOP+2.
+func (d *Debugger) parseAddress(s string) (uint16, error) {
+	if location, ok := d.parseLocation(s); ok {
+		address, ok := d.debugInfo.Address(location.File, location.Line)
+		if !ok {
+			return 0, fmt.Errorf("no code at %s", location)
+		}
+
+		return address, nil
+	}
+
+	if address, ok := d.symbols.Address(s); ok {
+		return address, nil
+	}
+
+	if n, err := parseNumber(s); err == nil {
+		return n, nil
+	}
+
+	v, err := d.Evaluate(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q: %w", s, err)
+	}
+
+	return uint16(v), nil
+}
+
+// formatAddress renders an address along with the nearest preceding
+// label, such as x3012 <LOOP+2>.
+func (d *Debugger) formatAddress(address uint16) string {
+	if label, offset, ok := d.symbols.Nearest(address); ok {
+		if offset == 0 {
+			return fmt.Sprintf("x%04X <%s>", address, label)
+		}
+
+		return fmt.Sprintf("x%04X <%s+%d>", address, label, offset)
+	}
+
+	return fmt.Sprintf("x%04X", address)
+}
+
+// disassemble renders the instruction word at an address, with its
+// PC-relative operand as a label and offset if the symbol table has
+// one before it.
+func (d *Debugger) disassemble(address, word uint16) string {
+	return disasm.FormatWith(address, word, d.symbols.Format)
+}
+
+// parseRange parses a single address or an inclusive range of
+// addresses written as START..END.
+func (d *Debugger) parseRange(s string) (uint16, uint16, error) {
+	first, last, isRange := strings.Cut(s, "..")
+
+	start, err := d.parseAddress(first)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if !isRange {
+		return start, start, nil
+	}
+
+	end, err := d.parseAddress(last)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if end < start {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+
+	return start, end, nil
+}
+
+// ConditionCodes renders the condition flags as N, Z or P.
+func ConditionCodes(cond uint16) string {
+	switch cond {
+	case cflags.FLNEG:
+		return "N"
+	case cflags.FLZRO:
+		return "Z"
+	case cflags.FLPOS:
+		return "P"
+	}
+
+	return "?"
+}
+
+// parseTrapVector parses a trap vector given as a number or as the
+// name of a trap such as PUTS.
+func parseTrapVector(s string) (uint16, error) {
+	if vector, ok := traps.Lookup(s); ok {
+		return vector, nil
+	}
+
+	vector, err := parseNumber(s)
+	if err != nil || vector > 0xFF {
+		return 0, fmt.Errorf("invalid trap vector %q", s)
+	}
+
+	return vector, nil
+}
@@ -0,0 +1,99 @@
+package debugger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Record records the rest of the session to w as a transcript. Every
+// command that succeeded is written as it was typed and everything
+// else, including all output, is written as a comment, so a transcript
+// doubles as a script that replays the session with Source.
+func (d *Debugger) Record(w io.Writer) {
+	d.transcript = &transcript{w: w, console: d.out}
+	d.out = io.MultiWriter(d.out, &d.transcript.pending)
+
+	fmt.Fprintf(&d.transcript.pending, "lc3 debugging session recorded %s\n", time.Now().Format(time.RFC3339))
+	d.transcript.flush()
+}
+
+// console returns where output that is not recorded, such as the
+// prompt, is written to.
+func (d *Debugger) console() io.Writer {
+	if d.transcript != nil {
+		return d.transcript.console
+	}
+
+	return d.out
+}
+
+// transcript is the recording of a session.
+type transcript struct {
+	// w is where the transcript is written to.
+	w io.Writer
+
+	// console is where output went before recording started, it
+	// receives what is not recorded such as the prompt.
+	console io.Writer
+
+	// pending is output that has not been written yet.
+	pending bytes.Buffer
+}
+
+// command records a command line after it was executed along with its
+// output. Failed commands are recorded as comments so that replaying
+// the transcript does not stop at them.
+func (t *transcript) command(line string, err error) {
+	if t == nil {
+		return
+	}
+
+	output := t.pending.String()
+	t.pending.Reset()
+
+	line = strings.TrimSpace(line)
+
+	if err != nil && err != ErrQuit {
+		t.comment(line + "\n")
+	} else {
+		fmt.Fprintln(t.w, line)
+	}
+
+	t.comment(output)
+}
+
+// note records a line as a comment.
+func (t *transcript) note(line string) {
+	if t == nil {
+		return
+	}
+
+	t.flush()
+	t.comment(strings.TrimSpace(line) + "\n")
+}
+
+// flush records pending output.
+func (t *transcript) flush() {
+	if t == nil {
+		return
+	}
+
+	output := t.pending.String()
+	t.pending.Reset()
+
+	t.comment(output)
+}
+
+// comment writes text as comment lines.
+func (t *transcript) comment(text string) {
+	if text == "" {
+		return
+	}
+
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(text, "\n"), "\n") {
+		fmt.Fprintf(t.w, "# %s\n", strings.TrimSuffix(line, "\n"))
+	}
+}
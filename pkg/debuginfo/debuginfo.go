@@ -0,0 +1,234 @@
+// Package debuginfo reads and writes debug info, the sidecar that maps
+// the addresses of a program to the lines of assembly source they were
+// assembled from and the label they fall under, so that tools can show
+// and accept source locations instead of raw addresses.
+package debuginfo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Location is a line of a source file.
+type Location struct {
+	// File is the path of the source file.
+	File string
+
+	// Line is the line number, starting at 1.
+	Line int
+
+	// Scope is the label the line falls under, the last one defined
+	// before it, if any.
+	Scope string
+}
+
+// String renders the location as FILE:LINE.
+func (l Location) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// Info maps addresses to source locations and back.
+type Info struct {
+	// locations maps addresses to the location they were assembled
+	// from.
+	locations map[uint16]Location
+
+	// sorted holds the addresses in order, it is rebuilt lazily after
+	// the info changes.
+	sorted []uint16
+}
+
+// New creates empty debug info.
+func New() *Info {
+	return &Info{
+		locations: map[uint16]Location{},
+	}
+}
+
+// Load reads debug info from a file. Relative source paths are
+// resolved against the directory of the file.
+func Load(filename string) (*Info, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	info, err := Parse(file)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(filename)
+
+	for address, location := range info.locations {
+		if !filepath.IsAbs(location.File) {
+			location.File = filepath.Join(dir, location.File)
+			info.locations[address] = location
+		}
+	}
+
+	return info, nil
+}
+
+// Parse reads debug info, one address per line followed by the line
+// number, the source file and optionally the label scope, separated by
+// tabs:
+//
+//	// Debug info
+//	// Address	Line	File	Scope
+//	3000	4	prog.asm	MAIN
+func Parse(r io.Reader) (*Info, error) {
+	info := New()
+
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 && len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: expected address, line, file and scope", n)
+		}
+
+		address, err := strconv.ParseUint(fields[0], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %q", n, fields[0])
+		}
+
+		number, err := strconv.Atoi(fields[1])
+		if err != nil || number < 1 {
+			return nil, fmt.Errorf("line %d: invalid line number %q", n, fields[1])
+		}
+
+		location := Location{File: fields[2], Line: number}
+		if len(fields) == 4 {
+			location.Scope = fields[3]
+		}
+
+		info.Add(uint16(address), location)
+	}
+
+	return info, scanner.Err()
+}
+
+// Add maps an address to a location.
+func (i *Info) Add(address uint16, location Location) {
+	i.locations[address] = location
+	i.sorted = nil
+}
+
+// Len returns the number of mapped addresses.
+func (i *Info) Len() int {
+	return len(i.locations)
+}
+
+// Location returns the location an address was assembled from. Nil
+// info has no locations.
+func (i *Info) Location(address uint16) (Location, bool) {
+	if i == nil {
+		return Location{}, false
+	}
+
+	location, ok := i.locations[address]
+
+	return location, ok
+}
+
+// Addresses returns the mapped addresses in order. Nil info has no
+// addresses.
+func (i *Info) Addresses() []uint16 {
+	if i == nil {
+		return nil
+	}
+
+	if i.sorted == nil {
+		i.sorted = make([]uint16, 0, len(i.locations))
+		for address := range i.locations {
+			i.sorted = append(i.sorted, address)
+		}
+
+		sort.Slice(i.sorted, func(a, b int) bool {
+			return i.sorted[a] < i.sorted[b]
+		})
+	}
+
+	return i.sorted
+}
+
+// Address returns the first address assembled from a line of a file,
+// or if that line produced no code, from the closest line after it.
+// Files match by path or by base name. Nil info has no addresses.
+func (i *Info) Address(file string, line int) (uint16, bool) {
+	var (
+		best     uint16
+		bestLine int
+		found    bool
+	)
+
+	for _, address := range i.Addresses() {
+		location := i.locations[address]
+
+		if location.Line < line || !sameFile(location.File, file) {
+			continue
+		}
+
+		if !found || location.Line < bestLine {
+			best, bestLine, found = address, location.Line, true
+		}
+	}
+
+	return best, found
+}
+
+// sameFile reports whether the path of a source file matches a path
+// or base name given by a user.
+func sameFile(path, name string) bool {
+	if filepath.Clean(path) == filepath.Clean(name) {
+		return true
+	}
+
+	if filepath.Base(name) == name {
+		return filepath.Base(path) == name
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	other, err := filepath.Abs(name)
+
+	return err == nil && abs == other
+}
+
+// Write writes the debug info.
+func (i *Info) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "// Debug info\n")
+	fmt.Fprintf(bw, "// Address\tLine\tFile\tScope\n")
+
+	for _, address := range i.Addresses() {
+		location := i.locations[address]
+		fmt.Fprintf(bw, "%04X\t%d\t%s", address, location.Line, location.File)
+
+		if location.Scope != "" {
+			fmt.Fprintf(bw, "\t%s", location.Scope)
+		}
+
+		fmt.Fprintf(bw, "\n")
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,53 @@
+package devices
+
+import (
+	"fmt"
+	"time"
+)
+
+// ClockEpoch is the time virtual clocks start at to be reproducible,
+// midnight UTC on 1 January 2000.
+var ClockEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Clock is a virtual clock that advances by a fixed step after every
+// instruction rather than with the host wall clock. Its Now can be
+// given to the devices reading the time, making them independent of
+// how fast the host runs the program. It owns no registers.
+type Clock struct {
+	// now is the current virtual time.
+	now time.Time
+
+	// step is how far the clock advances per instruction.
+	step time.Duration
+}
+
+// NewClock creates a clock starting at start and advancing by step
+// after every instruction.
+func NewClock(start time.Time, step time.Duration) *Clock {
+	return &Clock{now: start, step: step}
+}
+
+// Now returns the current virtual time.
+func (c *Clock) Now() time.Time {
+	return c.now
+}
+
+// Tick advances the clock by a step.
+func (c *Clock) Tick() {
+	c.now = c.now.Add(c.step)
+}
+
+// Addresses returns no registers, the clock is only ticked.
+func (c *Clock) Addresses() []uint16 {
+	return nil
+}
+
+// Read fails, the clock owns no registers.
+func (c *Clock) Read(address uint16) (uint16, error) {
+	return 0, fmt.Errorf("clock: unmapped address %04X", address)
+}
+
+// Write fails, the clock owns no registers.
+func (c *Clock) Write(address uint16, val uint16) error {
+	return fmt.Errorf("clock: unmapped address %04X", address)
+}
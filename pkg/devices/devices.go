@@ -0,0 +1,4 @@
+// Package devices contains memory-mapped peripherals that can
+// be attached to the LC3 CPU. Every device owns a handful of
+// addresses in the device register page (0xFE00-0xFFFF).
+package devices
@@ -0,0 +1,231 @@
+package devices
+
+import (
+	"fmt"
+	"lc3/pkg/registers"
+	"math"
+)
+
+// The operations of the FPU, in the low bits of its operation register.
+const (
+	// FPAdd adds B to A.
+	FPAdd = iota
+
+	// FPSub subtracts B from A.
+	FPSub
+
+	// FPMul multiplies A by B.
+	FPMul
+
+	// FPDiv divides A by B.
+	FPDiv
+)
+
+const (
+	// FPSingle is the bit of the operation register selecting single
+	// precision, the operands and result taking two words, the high one
+	// first. Operations are in half precision otherwise.
+	FPSingle = 1 << 15
+
+	// FPDone is the bit of the status register set once an operation
+	// completed.
+	FPDone = 1 << 15
+
+	// FPInvalid is the bit of the status register set when an
+	// operation of numbers gave NaN.
+	FPInvalid = 1 << 0
+
+	// FPDivideByZero is the bit of the status register set when a
+	// number was divided by zero.
+	FPDivideByZero = 1 << 1
+
+	// FPOverflow is the bit of the status register set when an
+	// operation of finite numbers gave an infinity.
+	FPOverflow = 1 << 2
+)
+
+// FPU is a floating-point coprocessor adding, subtracting, multiplying
+// and dividing IEEE 754 half or single precision numbers. Programs
+// write the operands, then the operation, and read the result and the
+// status.
+type FPU struct {
+	// a and b are the operands, high word first.
+	a, b [2]uint16
+
+	// op is the last operation written.
+	op uint16
+
+	// result is the result of the last operation, high word first.
+	result [2]uint16
+
+	// status is the status of the last operation.
+	status uint16
+}
+
+// NewFPU creates an FPU.
+func NewFPU() *FPU {
+	return &FPU{}
+}
+
+// Addresses returns the registers of the FPU.
+func (f *FPU) Addresses() []uint16 {
+	return []uint16{
+		registers.MRFPA, registers.MRFPAL, registers.MRFPB, registers.MRFPBL,
+		registers.MRFPOP, registers.MRFPR, registers.MRFPRL, registers.MRFPSR,
+	}
+}
+
+// Read reads a register of the FPU.
+func (f *FPU) Read(address uint16) (uint16, error) {
+	switch address {
+	case registers.MRFPA:
+		return f.a[0], nil
+	case registers.MRFPAL:
+		return f.a[1], nil
+	case registers.MRFPB:
+		return f.b[0], nil
+	case registers.MRFPBL:
+		return f.b[1], nil
+	case registers.MRFPOP:
+		return f.op, nil
+	case registers.MRFPR:
+		return f.result[0], nil
+	case registers.MRFPRL:
+		return f.result[1], nil
+	case registers.MRFPSR:
+		return f.status, nil
+	}
+
+	return 0, fmt.Errorf("fpu: unmapped address %04X", address)
+}
+
+// Write writes a register of the FPU, running the operation written to
+// the operation register. The result and status are read only.
+func (f *FPU) Write(address uint16, val uint16) error {
+	switch address {
+	case registers.MRFPA:
+		f.a[0] = val
+	case registers.MRFPAL:
+		f.a[1] = val
+	case registers.MRFPB:
+		f.b[0] = val
+	case registers.MRFPBL:
+		f.b[1] = val
+	case registers.MRFPOP:
+		f.op = val
+		f.run()
+	}
+
+	return nil
+}
+
+// run runs the operation.
+func (f *FPU) run() {
+	var a, b float32
+
+	if f.op&FPSingle != 0 {
+		a = math.Float32frombits(uint32(f.a[0])<<16 | uint32(f.a[1]))
+		b = math.Float32frombits(uint32(f.b[0])<<16 | uint32(f.b[1]))
+	} else {
+		a = HalfToFloat32(f.a[0])
+		b = HalfToFloat32(f.b[0])
+	}
+
+	var r float32
+
+	switch f.op & 0x3 {
+	case FPAdd:
+		r = a + b
+	case FPSub:
+		r = a - b
+	case FPMul:
+		r = a * b
+	case FPDiv:
+		r = a / b
+	}
+
+	if f.op&FPSingle != 0 {
+		bits := math.Float32bits(r)
+		f.result = [2]uint16{uint16(bits >> 16), uint16(bits)}
+	} else {
+		f.result = [2]uint16{Float32ToHalf(r), 0}
+		r = HalfToFloat32(f.result[0])
+	}
+
+	f.status = FPDone
+
+	finite := !isInf(a) && !isInf(b)
+
+	switch {
+	case isNaN(r) && !isNaN(a) && !isNaN(b):
+		f.status |= FPInvalid
+	case f.op&0x3 == FPDiv && b == 0 && a != 0 && finite && !isNaN(a):
+		f.status |= FPDivideByZero
+	case isInf(r) && finite && !isNaN(a) && !isNaN(b):
+		f.status |= FPOverflow
+	}
+}
+
+// isNaN reports whether a number is NaN.
+func isNaN(f float32) bool {
+	return f != f
+}
+
+// isInf reports whether a number is an infinity.
+func isInf(f float32) bool {
+	return math.IsInf(float64(f), 0)
+}
+
+// HalfToFloat32 converts an IEEE 754 half precision number to single
+// precision, which holds it exactly.
+func HalfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1F
+	mant := uint32(h) & 0x3FF
+
+	switch exp {
+	case 0:
+		f := float32(mant) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+
+		return f
+	case 0x1F:
+		return math.Float32frombits(sign | 0x7F800000 | mant<<13)
+	}
+
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
+// Float32ToHalf converts a single precision number to IEEE 754 half
+// precision, rounding to the nearest, ties to even.
+func Float32ToHalf(f float32) uint16 {
+	sign := uint16(math.Float32bits(f)>>16) & 0x8000
+
+	if isNaN(f) {
+		return sign | 0x7E00
+	}
+
+	a := math.Abs(float64(f))
+
+	switch {
+	case a >= 65520:
+		return sign | 0x7C00
+	case a < 1.0/(1<<14):
+		// subnormal, rounding up to the smallest normal number on
+		// its own.
+		return sign | uint16(math.RoundToEven(a*(1<<24)))
+	}
+
+	frac, exp := math.Frexp(a)
+	exp--
+
+	mant := math.RoundToEven((frac*2 - 1) * 1024)
+	if mant == 1024 {
+		mant = 0
+		exp++
+	}
+
+	return sign | uint16(exp+15)<<10 | uint16(mant)
+}
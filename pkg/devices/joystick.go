@@ -0,0 +1,216 @@
+package devices
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"lc3/pkg/registers"
+	"sync"
+)
+
+const (
+	// JoyUp is set in the joystick state while up is pressed.
+	JoyUp = 1 << 0
+
+	// JoyDown is set in the joystick state while down is pressed.
+	JoyDown = 1 << 1
+
+	// JoyLeft is set in the joystick state while left is pressed.
+	JoyLeft = 1 << 2
+
+	// JoyRight is set in the joystick state while right is pressed.
+	JoyRight = 1 << 3
+
+	// JoyA is set in the joystick state while the A button is pressed.
+	JoyA = 1 << 4
+
+	// JoyB is set in the joystick state while the B button is pressed.
+	JoyB = 1 << 5
+
+	// JoyStart is set in the joystick state while start is pressed.
+	JoyStart = 1 << 6
+
+	// JoySelect is set in the joystick state while select is pressed.
+	JoySelect = 1 << 7
+)
+
+// joyKeys maps keyboard keys to joystick buttons.
+var joyKeys = map[byte]uint16{
+	'w':  JoyUp,
+	'a':  JoyLeft,
+	's':  JoyDown,
+	'd':  JoyRight,
+	' ':  JoyA,
+	'j':  JoyA,
+	'k':  JoyB,
+	'\r': JoyStart,
+	'\n': JoyStart,
+	'\t': JoySelect,
+}
+
+// joyArrows maps the final byte of arrow key escape sequences to
+// joystick buttons.
+var joyArrows = map[byte]uint16{
+	'A': JoyUp,
+	'B': JoyDown,
+	'C': JoyRight,
+	'D': JoyLeft,
+}
+
+// Joystick is a game-input device exposing directional and button
+// state through a single register, separate from the character
+// keyboard. Buttons fed from a real gamepad are reported while they
+// are held. Terminals do not report key releases, so buttons fed from
+// the keyboard are latched until the state register is next read.
+type Joystick struct {
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// held are the buttons currently held down.
+	held uint16
+
+	// latched are the buttons tapped since the last read.
+	latched uint16
+}
+
+// NewJoystick creates a new joystick with no buttons pressed.
+func NewJoystick() *Joystick {
+	return &Joystick{}
+}
+
+// Addresses returns the joystick registers.
+func (j *Joystick) Addresses() []uint16 {
+	return []uint16{registers.MRJOYSR}
+}
+
+// Read reads the button state and clears latched buttons.
+func (j *Joystick) Read(address uint16) (uint16, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	state := j.held | j.latched
+	j.latched = 0
+
+	return state, nil
+}
+
+// Write ignores writes, the joystick state is read only.
+func (j *Joystick) Write(address uint16, val uint16) error {
+	return nil
+}
+
+// Set presses or releases buttons.
+func (j *Joystick) Set(buttons uint16, down bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if down {
+		j.held |= buttons
+	} else {
+		j.held &^= buttons
+	}
+}
+
+// Tap presses buttons until the state register is next read.
+func (j *Joystick) Tap(buttons uint16) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.latched |= buttons
+}
+
+// ReadKeys feeds the joystick from keyboard input until r returns an
+// error. Arrow keys and WASD steer, space or j is A, k is B, enter is
+// start and tab is select. Any other key is passed through to w, so
+// it still reaches the character keyboard.
+func (j *Joystick) ReadKeys(r io.Reader, w io.Writer) error {
+	reader := bufio.NewReader(r)
+
+	for {
+		key, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if buttons, ok := joyKeys[key]; ok {
+			j.Tap(buttons)
+			continue
+		}
+
+		if key == 0x1B && reader.Buffered() >= 2 {
+			seq, _ := reader.Peek(2)
+
+			if buttons, ok := joyArrows[seq[1]]; ok && seq[0] == '[' {
+				reader.Discard(2)
+				j.Tap(buttons)
+				continue
+			}
+		}
+
+		if _, err := w.Write([]byte{key}); err != nil {
+			return err
+		}
+	}
+}
+
+const (
+	// jsEventButton is the Linux joystick event type of buttons.
+	jsEventButton = 0x01
+
+	// jsEventAxis is the Linux joystick event type of axes.
+	jsEventAxis = 0x02
+
+	// jsEventInit flags synthetic events describing the initial state.
+	jsEventInit = 0x80
+
+	// jsAxisThreshold is how far an axis must move to count as pressed.
+	jsAxisThreshold = 16384
+)
+
+// jsButtons maps Linux joystick button numbers to joystick buttons,
+// following the common Xbox-style layout.
+var jsButtons = map[uint8]uint16{
+	0: JoyA,
+	1: JoyB,
+	6: JoySelect,
+	7: JoyStart,
+}
+
+// ReadGamepad feeds the joystick from a Linux joystick device such as
+// /dev/input/js0 until r returns an error. The first two axes steer,
+// and the face and menu buttons map to A, B, start and select.
+func (j *Joystick) ReadGamepad(r io.Reader) error {
+	var event struct {
+		Time   uint32
+		Value  int16
+		Type   uint8
+		Number uint8
+	}
+
+	for {
+		if err := binary.Read(r, binary.LittleEndian, &event); err != nil {
+			return err
+		}
+
+		switch event.Type &^ jsEventInit {
+		case jsEventButton:
+			if buttons, ok := jsButtons[event.Number]; ok {
+				j.Set(buttons, event.Value != 0)
+			}
+		case jsEventAxis:
+			var negative, positive uint16
+
+			switch event.Number {
+			case 0:
+				negative, positive = JoyLeft, JoyRight
+			case 1:
+				negative, positive = JoyUp, JoyDown
+			default:
+				continue
+			}
+
+			j.Set(negative, event.Value < -jsAxisThreshold)
+			j.Set(positive, event.Value > jsAxisThreshold)
+		}
+	}
+}
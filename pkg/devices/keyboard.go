@@ -0,0 +1,126 @@
+package devices
+
+import (
+	"io"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+	"lc3/pkg/traps"
+	"sync"
+)
+
+// Keyboard is a keyboard typed on by the host program, such as a web
+// front-end, rather than read from the host console. It owns the
+// keyboard status and data registers and also serves as the console
+// input stream so that GETC and IN see the same keys. It is safe to
+// type on while a program runs.
+type Keyboard struct {
+	// mu guards keys.
+	mu sync.Mutex
+
+	// keys are the keys typed and not yet read.
+	keys []byte
+}
+
+// NewKeyboard creates a keyboard with no keys typed.
+func NewKeyboard() *Keyboard {
+	return &Keyboard{}
+}
+
+// Type queues keys to be read by the program.
+func (k *Keyboard) Type(keys []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys = append(k.keys, keys...)
+}
+
+// Pending returns the number of keys typed and not yet read.
+func (k *Keyboard) Pending() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return len(k.keys)
+}
+
+// next removes the next key, reporting whether there was one.
+func (k *Keyboard) next() (byte, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if len(k.keys) == 0 {
+		return 0, false
+	}
+
+	key := k.keys[0]
+	k.keys = k.keys[1:]
+
+	return key, true
+}
+
+// Blocks reports whether an instruction reads a key from the console,
+// with GETC or IN, while none is typed, so hosts pause the program
+// before running it rather than have it read io.EOF.
+func (k *Keyboard) Blocks(instr uint16) bool {
+	if instr>>12 != opcodes.OPTRAP || (instr&0xFF != traps.GETC && instr&0xFF != traps.IN) {
+		return false
+	}
+
+	return k.Pending() == 0
+}
+
+// Addresses returns the keyboard registers.
+func (k *Keyboard) Addresses() []uint16 {
+	return []uint16{
+		registers.MRKBSR,
+		registers.MRKBDR,
+	}
+}
+
+// Read reads the keyboard status or data register. Reading the data
+// register consumes the key typed first.
+func (k *Keyboard) Read(address uint16) (uint16, error) {
+	if address == registers.MRKBSR {
+		if k.Pending() > 0 {
+			return 1 << 15, nil
+		}
+
+		return 0, nil
+	}
+
+	key, _ := k.next()
+
+	return uint16(key), nil
+}
+
+// Write ignores writes to the keyboard registers.
+func (k *Keyboard) Write(address uint16, val uint16) error {
+	return nil
+}
+
+// Input returns the console input stream of the keyboard, which GETC
+// and IN read from. It reads a key at a time, and io.EOF if none is
+// typed, so hosts check Blocks before running them.
+func (k *Keyboard) Input() io.Reader {
+	return keyboardInput{keyboard: k}
+}
+
+// keyboardInput is the console input stream of a keyboard.
+type keyboardInput struct {
+	keyboard *Keyboard
+}
+
+// Read reads the key typed first, or io.EOF if there is none.
+func (i keyboardInput) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	key, ok := i.keyboard.next()
+	if !ok {
+		return 0, io.EOF
+	}
+
+	b[0] = key
+
+	return 1, nil
+}
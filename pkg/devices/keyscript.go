@@ -0,0 +1,276 @@
+package devices
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lc3/pkg/registers"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyEvent is a single scripted keystroke.
+type KeyEvent struct {
+	// Key is the character that is pressed.
+	Key byte
+
+	// Instructions is the number of instructions to wait after the
+	// previous keystroke was consumed before the key is pressed.
+	Instructions uint64
+
+	// Delay is the wall-clock time to wait after the previous
+	// keystroke was consumed before the key is pressed.
+	Delay time.Duration
+}
+
+// keyNames maps the names accepted by "press" to characters.
+var keyNames = map[string]byte{
+	"enter":     '\n',
+	"return":    '\n',
+	"space":     ' ',
+	"tab":       '\t',
+	"esc":       0x1B,
+	"escape":    0x1B,
+	"backspace": 0x08,
+}
+
+// ParseKeyScript parses a keystroke script. Every line holds one
+// command, optionally preceded by a delay:
+//
+//	# wait 100 instructions, then press 'a'
+//	after 100 press a
+//	after 50ms type "hello\n"
+//	press enter
+//
+// Keys may be written as a single character, a quoted character,
+// a hex code such as x1B, or one of the names enter, space, tab,
+// esc and backspace. A delay given to "type" applies to its first
+// character only.
+func ParseKeyScript(r io.Reader) ([]KeyEvent, error) {
+	var events []KeyEvent
+
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parsed, err := parseKeyLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("key script line %d: %w", line, err)
+		}
+
+		events = append(events, parsed...)
+	}
+
+	return events, scanner.Err()
+}
+
+// parseKeyLine parses a single line of a keystroke script.
+func parseKeyLine(text string) ([]KeyEvent, error) {
+	var delay KeyEvent
+
+	if rest, ok := strings.CutPrefix(text, "after "); ok {
+		amount, rest, _ := strings.Cut(strings.TrimSpace(rest), " ")
+
+		if n, err := strconv.ParseUint(amount, 10, 64); err == nil {
+			delay.Instructions = n
+		} else if d, err := time.ParseDuration(amount); err == nil {
+			delay.Delay = d
+		} else {
+			return nil, fmt.Errorf("invalid delay %q", amount)
+		}
+
+		rest = strings.TrimSpace(rest)
+		rest = strings.TrimPrefix(rest, "instructions ")
+		text = strings.TrimSpace(rest)
+	}
+
+	command, arg, _ := strings.Cut(text, " ")
+	arg = strings.TrimSpace(arg)
+
+	var keys []byte
+
+	switch command {
+	case "press":
+		key, err := parseKey(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = []byte{key}
+	case "type":
+		str, err := strconv.Unquote(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", arg)
+		}
+
+		keys = []byte(str)
+	default:
+		return nil, fmt.Errorf("unknown command %q", command)
+	}
+
+	events := make([]KeyEvent, len(keys))
+	for i, key := range keys {
+		events[i].Key = key
+	}
+
+	if len(events) > 0 {
+		events[0].Instructions = delay.Instructions
+		events[0].Delay = delay.Delay
+	}
+
+	return events, nil
+}
+
+// parseKey parses the key argument of a "press" command.
+func parseKey(arg string) (byte, error) {
+	if key, ok := keyNames[strings.ToLower(arg)]; ok {
+		return key, nil
+	}
+
+	if len(arg) == 1 {
+		return arg[0], nil
+	}
+
+	if strings.HasPrefix(arg, "'") {
+		r, _, tail, err := strconv.UnquoteChar(strings.Trim(arg, "'"), '\'')
+		if err == nil && tail == "" && r < 0x100 {
+			return byte(r), nil
+		}
+	}
+
+	if strings.HasPrefix(arg, "x") || strings.HasPrefix(arg, "X") {
+		n, err := strconv.ParseUint(arg[1:], 16, 8)
+		if err == nil {
+			return byte(n), nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid key %q", arg)
+}
+
+// ScriptedKeyboard is a keyboard that replays a keystroke script
+// rather than reading the host console. It owns the keyboard status
+// and data registers and also serves as the console input stream so
+// that GETC and IN see the same keystrokes.
+type ScriptedKeyboard struct {
+	// events are the keystrokes left to replay.
+	events []KeyEvent
+
+	// now returns the current time.
+	now func() time.Time
+
+	// ticks counts the instructions executed since the previous
+	// keystroke was consumed.
+	ticks uint64
+
+	// since is the time the previous keystroke was consumed.
+	since time.Time
+}
+
+// NewScriptedKeyboard creates a keyboard replaying events. If now is
+// nil, time.Now is used.
+func NewScriptedKeyboard(events []KeyEvent, now func() time.Time) *ScriptedKeyboard {
+	if now == nil {
+		now = time.Now
+	}
+
+	return &ScriptedKeyboard{
+		events: events,
+		now:    now,
+		since:  now(),
+	}
+}
+
+// Addresses returns the keyboard registers.
+func (k *ScriptedKeyboard) Addresses() []uint16 {
+	return []uint16{
+		registers.MRKBSR,
+		registers.MRKBDR,
+	}
+}
+
+// Tick counts an executed instruction.
+func (k *ScriptedKeyboard) Tick() {
+	k.ticks++
+}
+
+// ready reports whether the next keystroke has been pressed.
+func (k *ScriptedKeyboard) ready() bool {
+	if len(k.events) == 0 {
+		return false
+	}
+
+	next := k.events[0]
+
+	return k.ticks >= next.Instructions && k.now().Sub(k.since) >= next.Delay
+}
+
+// consume removes the next keystroke from the script.
+func (k *ScriptedKeyboard) consume() byte {
+	key := k.events[0].Key
+
+	k.events = k.events[1:]
+	k.ticks = 0
+	k.since = k.now()
+
+	return key
+}
+
+// Read reads the keyboard status or data register. Reading the data
+// register consumes the pressed key.
+func (k *ScriptedKeyboard) Read(address uint16) (uint16, error) {
+	if !k.ready() {
+		return 0, nil
+	}
+
+	if address == registers.MRKBSR {
+		return 1 << 15, nil
+	}
+
+	return uint16(k.consume()), nil
+}
+
+// Write ignores writes to the keyboard registers.
+func (k *ScriptedKeyboard) Write(address uint16, val uint16) error {
+	return nil
+}
+
+// Input returns the console input stream of the keyboard, which
+// GETC and IN read from.
+func (k *ScriptedKeyboard) Input() io.Reader {
+	return scriptedInput{keyboard: k}
+}
+
+// scriptedInput is the console input stream of a scripted keyboard.
+type scriptedInput struct {
+	keyboard *ScriptedKeyboard
+}
+
+// Read reads the next keystroke. A blocking read does not wait for
+// instruction delays, since no instructions execute while it blocks,
+// but it does honor wall-clock delays. It returns io.EOF once the
+// script is exhausted.
+func (s scriptedInput) Read(b []byte) (int, error) {
+	k := s.keyboard
+
+	if len(k.events) == 0 {
+		return 0, io.EOF
+	}
+
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	if wait := k.events[0].Delay - k.now().Sub(k.since); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	b[0] = k.consume()
+
+	return 1, nil
+}
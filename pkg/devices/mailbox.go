@@ -0,0 +1,113 @@
+package devices
+
+import (
+	"lc3/pkg/registers"
+	"sync"
+)
+
+const (
+	// MailboxReceiveReady is set in the mailbox status register when
+	// a message is waiting to be read from the data register.
+	MailboxReceiveReady = 1 << 15
+
+	// MailboxSendReady is set in the mailbox status register when
+	// the peer has room for another message.
+	MailboxSendReady = 1 << 14
+)
+
+// mailboxQueues holds the messages in flight between the two ends of
+// a mailbox.
+type mailboxQueues struct {
+	// mu guards the queues.
+	mu sync.Mutex
+
+	// queues holds the messages addressed to each end.
+	queues [2][]uint16
+
+	// depth is the maximum number of messages per queue.
+	depth int
+}
+
+// Mailbox is one end of a mailbox shared by two CPUs in the same
+// process. Writing the data register sends a word to the other end,
+// reading it receives the oldest word sent by the other end. The
+// status register reports whether a word can be received or sent.
+type Mailbox struct {
+	// shared are the queues shared with the other end.
+	shared *mailboxQueues
+
+	// side is the index of the queue this end receives from.
+	side int
+}
+
+// NewMailbox creates a mailbox buffering up to depth words in each
+// direction and returns its two ends, one for each CPU.
+func NewMailbox(depth int) (*Mailbox, *Mailbox) {
+	if depth < 1 {
+		depth = 1
+	}
+
+	shared := &mailboxQueues{depth: depth}
+
+	return &Mailbox{shared: shared, side: 0}, &Mailbox{shared: shared, side: 1}
+}
+
+// Addresses returns the mailbox registers.
+func (m *Mailbox) Addresses() []uint16 {
+	return []uint16{
+		registers.MRMBSR,
+		registers.MRMBDR,
+	}
+}
+
+// Read reads the status register, or receives a word from the data
+// register. Receiving from an empty mailbox reads zero.
+func (m *Mailbox) Read(address uint16) (uint16, error) {
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+
+	incoming := &m.shared.queues[m.side]
+	outgoing := m.shared.queues[1-m.side]
+
+	if address == registers.MRMBSR {
+		var status uint16
+
+		if len(*incoming) > 0 {
+			status |= MailboxReceiveReady
+		}
+
+		if len(outgoing) < m.shared.depth {
+			status |= MailboxSendReady
+		}
+
+		return status, nil
+	}
+
+	if len(*incoming) == 0 {
+		return 0, nil
+	}
+
+	val := (*incoming)[0]
+	*incoming = (*incoming)[1:]
+
+	return val, nil
+}
+
+// Write sends a word to the other end. Writes to a full mailbox and
+// to the status register are dropped.
+func (m *Mailbox) Write(address uint16, val uint16) error {
+	if address != registers.MRMBDR {
+		return nil
+	}
+
+	m.shared.mu.Lock()
+	defer m.shared.mu.Unlock()
+
+	outgoing := &m.shared.queues[1-m.side]
+
+	if len(*outgoing) < m.shared.depth {
+		*outgoing = append(*outgoing, val)
+	}
+
+	return nil
+}
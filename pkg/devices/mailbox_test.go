@@ -0,0 +1,155 @@
+package devices
+
+import (
+	"io"
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"lc3/pkg/registers"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMailbox checks the status and data registers of both ends of a
+// mailbox.
+func TestMailbox(t *testing.T) {
+	a, b := NewMailbox(2)
+
+	status := func(m *Mailbox) uint16 {
+		t.Helper()
+
+		val, err := m.Read(registers.MRMBSR)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return val
+	}
+
+	receive := func(m *Mailbox) uint16 {
+		t.Helper()
+
+		val, err := m.Read(registers.MRMBDR)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return val
+	}
+
+	if s := status(a); s != MailboxSendReady {
+		t.Errorf("empty status x%04X, expected x%04X", s, MailboxSendReady)
+	}
+
+	for _, val := range []uint16{1, 2, 3} {
+		if err := a.Write(registers.MRMBDR, val); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a.Write(registers.MRMBSR, 4)
+
+	if s := status(a); s != 0 {
+		t.Errorf("sender status x%04X with the peer full, expected 0", s)
+	}
+
+	if s := status(b); s != MailboxReceiveReady|MailboxSendReady {
+		t.Errorf("receiver status x%04X, expected x%04X", s, MailboxReceiveReady|MailboxSendReady)
+	}
+
+	for _, expected := range []uint16{1, 2, 0} {
+		if val := receive(b); val != expected {
+			t.Errorf("received %d, expected %d", val, expected)
+		}
+	}
+
+	if s := status(b); s != MailboxSendReady {
+		t.Errorf("drained status x%04X, expected x%04X", s, MailboxSendReady)
+	}
+
+	b.Write(registers.MRMBDR, 5)
+
+	if val := receive(a); val != 5 {
+		t.Errorf("received %d back, expected 5", val)
+	}
+}
+
+// producer sends 5 down to 1 through the mailbox, waiting for room.
+const producer = `
+		.ORIG x3000
+		AND R1, R1, #0
+		ADD R1, R1, #5
+WAIT	LDI R2, SR
+		LD R3, SEND
+		AND R2, R2, R3
+		BRz WAIT
+		STI R1, DR
+		ADD R1, R1, #-1
+		BRp WAIT
+		HALT
+SR		.FILL xFE18
+DR		.FILL xFE1A
+SEND	.FILL x4000
+		.END`
+
+// consumer adds the 5 words it receives through the mailbox into R4.
+const consumer = `
+		.ORIG x3000
+		AND R4, R4, #0
+		AND R1, R1, #0
+		ADD R1, R1, #5
+WAIT	LDI R2, SR
+		BRzp WAIT
+		LDI R3, DR
+		ADD R4, R4, R3
+		ADD R1, R1, #-1
+		BRp WAIT
+		HALT
+SR		.FILL xFE18
+DR		.FILL xFE1A
+		.END`
+
+// TestMailboxCPUs checks that two CPUs running at once pass words
+// through a mailbox holding one at a time.
+func TestMailboxCPUs(t *testing.T) {
+	a, b := NewMailbox(1)
+
+	done := make(chan error, 2)
+
+	var cpus []cpu.CPU
+
+	for _, vm := range []struct {
+		source string
+		end    *Mailbox
+	}{{producer, a}, {consumer, b}} {
+		obj, _, diagnostics, err := asm.Assemble(strings.NewReader(vm.source))
+		if err != nil || len(diagnostics) > 0 {
+			t.Fatalf("%v %v", err, diagnostics)
+		}
+
+		var image [cpu.MemoryMax]uint16
+		copy(image[obj.Origin:], obj.Words)
+
+		c := cpu.NewCPU(cpu.WithOutput(io.Discard), cpu.WithDevice(vm.end))
+		cpus = append(cpus, c)
+
+		go func() {
+			done <- c.Run(image)
+		}()
+	}
+
+	for range cpus {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the CPUs did not halt")
+		}
+	}
+
+	if sum := cpus[1].Register(registers.RR4); sum != 15 {
+		t.Errorf("the consumer added up %d, expected 15", sum)
+	}
+}
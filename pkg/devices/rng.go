@@ -0,0 +1,41 @@
+package devices
+
+import (
+	"fmt"
+	"lc3/pkg/registers"
+	"math/rand"
+)
+
+// RNG is a pseudo-random number generator. Reading its register
+// returns the next number, writing it seeds the generator, so that a
+// program can replay a sequence.
+type RNG struct {
+	// rand generates the numbers.
+	rand *rand.Rand
+}
+
+// NewRNG creates a generator seeded with seed. Generators created with
+// the same seed return the same numbers.
+func NewRNG(seed int64) *RNG {
+	return &RNG{rand: rand.New(rand.NewSource(seed))}
+}
+
+// Addresses returns the generator register.
+func (r *RNG) Addresses() []uint16 {
+	return []uint16{registers.MRRNG}
+}
+
+// Read returns the next number.
+func (r *RNG) Read(address uint16) (uint16, error) {
+	if address != registers.MRRNG {
+		return 0, fmt.Errorf("rng: unmapped address %04X", address)
+	}
+
+	return uint16(r.rand.Uint32()), nil
+}
+
+// Write seeds the generator with the value.
+func (r *RNG) Write(address uint16, val uint16) error {
+	r.rand.Seed(int64(val))
+	return nil
+}
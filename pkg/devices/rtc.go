@@ -0,0 +1,63 @@
+package devices
+
+import (
+	"fmt"
+	"lc3/pkg/registers"
+	"time"
+)
+
+// RTC is a real-time clock exposing the host wall clock and the
+// time elapsed since the machine booted.
+type RTC struct {
+	// now returns the current time.
+	now func() time.Time
+
+	// boot is the time the clock was created.
+	boot time.Time
+}
+
+// NewRTC creates a new real-time clock reading time from now.
+// If now is nil, time.Now is used.
+func NewRTC(now func() time.Time) *RTC {
+	if now == nil {
+		now = time.Now
+	}
+
+	return &RTC{
+		now:  now,
+		boot: now(),
+	}
+}
+
+// Addresses returns the registers owned by the clock.
+func (r *RTC) Addresses() []uint16 {
+	return []uint16{
+		registers.MRRTCUP,
+		registers.MRRTCHR,
+		registers.MRRTCMN,
+		registers.MRRTCSC,
+	}
+}
+
+// Read reads the current value of a clock register.
+func (r *RTC) Read(address uint16) (uint16, error) {
+	now := r.now()
+
+	switch address {
+	case registers.MRRTCUP:
+		return uint16(now.Sub(r.boot) / time.Second), nil
+	case registers.MRRTCHR:
+		return uint16(now.Hour()), nil
+	case registers.MRRTCMN:
+		return uint16(now.Minute()), nil
+	case registers.MRRTCSC:
+		return uint16(now.Second()), nil
+	}
+
+	return 0, fmt.Errorf("rtc: unmapped address %04X", address)
+}
+
+// Write ignores writes, the clock registers are read only.
+func (r *RTC) Write(address uint16, val uint16) error {
+	return nil
+}
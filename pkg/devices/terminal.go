@@ -0,0 +1,123 @@
+package devices
+
+import (
+	"fmt"
+	"io"
+	"lc3/pkg/registers"
+	"lc3/pkg/term"
+)
+
+const (
+	// TermClear clears the screen and homes the cursor.
+	TermClear = 1
+
+	// TermMove moves the cursor to the row and column held in the
+	// row and column registers.
+	TermMove = 2
+
+	// TermColor applies the colors held in the color register.
+	TermColor = 3
+
+	// TermReset resets the colors and other output attributes.
+	TermReset = 4
+
+	// TermHideCursor hides the cursor.
+	TermHideCursor = 5
+
+	// TermShowCursor shows the cursor.
+	TermShowCursor = 6
+
+	// TermClearLine clears the line the cursor is on.
+	TermClearLine = 7
+)
+
+// Terminal is a terminal control device that lets programs position
+// the cursor, clear the screen and set colors without hand-assembling
+// escape sequences. Arguments are written to the column, row and
+// color registers, and a command is executed by writing it to the
+// command register.
+type Terminal struct {
+	// out is where escape sequences are written.
+	out io.Writer
+
+	// col is the value of the column register.
+	col uint16
+
+	// row is the value of the row register.
+	row uint16
+
+	// color is the value of the color register.
+	color uint16
+}
+
+// NewTerminal creates a terminal control device writing to out, which
+// should be the console output of the CPU.
+func NewTerminal(out io.Writer) *Terminal {
+	return &Terminal{
+		out:   out,
+		color: uint16(term.Default)<<8 | uint16(term.Default),
+	}
+}
+
+// Addresses returns the terminal registers.
+func (t *Terminal) Addresses() []uint16 {
+	return []uint16{
+		registers.MRTCCOL,
+		registers.MRTCROW,
+		registers.MRTCCLR,
+		registers.MRTCCMD,
+	}
+}
+
+// Read reads back the argument registers, the command register always
+// reads zero.
+func (t *Terminal) Read(address uint16) (uint16, error) {
+	switch address {
+	case registers.MRTCCOL:
+		return t.col, nil
+	case registers.MRTCROW:
+		return t.row, nil
+	case registers.MRTCCLR:
+		return t.color, nil
+	}
+
+	return 0, nil
+}
+
+// Write sets an argument register or executes a command.
+func (t *Terminal) Write(address uint16, val uint16) error {
+	switch address {
+	case registers.MRTCCOL:
+		t.col = val
+	case registers.MRTCROW:
+		t.row = val
+	case registers.MRTCCLR:
+		t.color = val
+	case registers.MRTCCMD:
+		return t.execute(val)
+	}
+
+	return nil
+}
+
+// execute executes a terminal command.
+func (t *Terminal) execute(cmd uint16) error {
+	switch cmd {
+	case TermClear:
+		return term.Clear(t.out)
+	case TermMove:
+		return term.MoveTo(t.out, int(t.row), int(t.col))
+	case TermColor:
+		return term.SetColor(t.out, term.Color(t.color), term.Color(t.color>>8))
+	case TermReset:
+		return term.Reset(t.out)
+	case TermHideCursor:
+		return term.HideCursor(t.out)
+	case TermShowCursor:
+		return term.ShowCursor(t.out)
+	case TermClearLine:
+		return term.ClearLine(t.out)
+	}
+
+	return fmt.Errorf("terminal: unknown command %d", cmd)
+}
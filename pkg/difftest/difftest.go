@@ -0,0 +1,193 @@
+package difftest
+
+import (
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/disasm"
+	"lc3/pkg/registers"
+	"strings"
+)
+
+// Option configures a comparison.
+type Option func(*comparison)
+
+// comparison holds the settings of a comparison.
+type comparison struct {
+	// checkpoints are the addresses of the instructions after which
+	// the states are compared, every instruction if empty.
+	checkpoints map[uint16]bool
+
+	// every compares the states only every so many instructions.
+	every uint64
+
+	// ignored are the registers left out of the comparison.
+	ignored [8]bool
+
+	// limit is the number of instructions compared at most.
+	limit uint64
+}
+
+// WithCheckpoints compares the states only after the instructions at
+// the given addresses run.
+func WithCheckpoints(addresses ...uint16) Option {
+	return func(c *comparison) {
+		for _, address := range addresses {
+			c.checkpoints[address] = true
+		}
+	}
+}
+
+// WithEvery compares the states only every n instructions.
+func WithEvery(n uint64) Option {
+	return func(c *comparison) {
+		c.every = max(n, 1)
+	}
+}
+
+// WithIgnored leaves registers R0 to R7 out of the comparison, such as
+// R7 for a reference whose traps save a different return address.
+func WithIgnored(rs ...uint16) Option {
+	return func(c *comparison) {
+		for _, r := range rs {
+			if r < 8 {
+				c.ignored[r] = true
+			}
+		}
+	}
+}
+
+// WithLimit stops the comparison after n instructions.
+func WithLimit(n uint64) Option {
+	return func(c *comparison) {
+		c.limit = n
+	}
+}
+
+// Divergence is the first checkpoint at which the VM and the
+// reference disagree.
+type Divergence struct {
+	// Step is the number of the instruction, starting at 0.
+	Step uint64
+
+	// Address is the address of the instruction on the VM.
+	Address uint16
+
+	// Instr is the instruction word on the VM.
+	Instr uint16
+
+	// VM and Reference are the states each left behind.
+	VM, Reference State
+
+	// Agreed is the last step at which the states were compared and
+	// agreed, -1 if there was none.
+	Agreed int64
+
+	// Differences name what differs: PC, R0 to R7 or CC.
+	Differences []string
+}
+
+// Write describes the divergence.
+func (d *Divergence) Write(w io.Writer) error {
+	if d.Agreed >= 0 {
+		if _, err := fmt.Fprintf(w, "same up to step %d\n", d.Agreed); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "step %d, x%04X  %04X  %s, differs in %s:\n  vm:        %s\n  reference: %s\n",
+		d.Step, d.Address, d.Instr, disasm.Format(d.Address, d.Instr), strings.Join(d.Differences, ", "), d.VM, d.Reference)
+
+	return err
+}
+
+// Compare runs image on c and ref in lockstep until c halts, comparing
+// their states at every checkpoint. It returns where they first
+// disagree, or nil, along with the number of instructions run.
+func Compare(image [cpu.MemoryMax]uint16, c cpu.CPU, ref Reference, opts ...Option) (*Divergence, uint64, error) {
+	cmp := &comparison{
+		checkpoints: map[uint16]bool{},
+		every:       1,
+	}
+
+	for _, opt := range opts {
+		opt(cmp)
+	}
+
+	c.Load(image)
+
+	agreed := int64(-1)
+
+	var steps uint64
+
+	for ; !c.Halted() && (cmp.limit == 0 || steps < cmp.limit); steps++ {
+		address := c.Register(registers.RPC)
+		instr := c.PeekMemory(address)
+
+		if err := c.Execute(); err != nil {
+			return nil, steps, fmt.Errorf("vm stopped at x%04X: %w", address, err)
+		}
+
+		want, err := ref.Step()
+		if err != nil {
+			return nil, steps, fmt.Errorf("reference at step %d: %w", steps, err)
+		}
+
+		if (len(cmp.checkpoints) > 0 && !cmp.checkpoints[address]) || (steps+1)%cmp.every != 0 {
+			continue
+		}
+
+		got := stateOf(c)
+
+		if diffs := cmp.differences(got, want); len(diffs) > 0 {
+			return &Divergence{
+				Step:        steps,
+				Address:     address,
+				Instr:       instr,
+				VM:          got,
+				Reference:   want,
+				Agreed:      agreed,
+				Differences: diffs,
+			}, steps, nil
+		}
+
+		agreed = int64(steps)
+	}
+
+	return nil, steps, nil
+}
+
+// stateOf returns the state of a CPU.
+func stateOf(c cpu.CPU) State {
+	s := State{
+		PC:   c.Register(registers.RPC),
+		Cond: c.Register(registers.RCOND),
+	}
+
+	for r := range s.Registers {
+		s.Registers[r] = c.Register(uint16(r))
+	}
+
+	return s
+}
+
+// differences names what differs between two states.
+func (cmp *comparison) differences(a, b State) []string {
+	var diffs []string
+
+	if a.PC != b.PC {
+		diffs = append(diffs, "PC")
+	}
+
+	for r := range a.Registers {
+		if !cmp.ignored[r] && a.Registers[r] != b.Registers[r] {
+			diffs = append(diffs, fmt.Sprintf("R%d", r))
+		}
+	}
+
+	if a.Cond != b.Cond {
+		diffs = append(diffs, "CC")
+	}
+
+	return diffs
+}
@@ -0,0 +1,284 @@
+// Package difftest runs a program on this VM and on a reference
+// simulator in lockstep, comparing the registers and condition codes
+// each leaves behind to find where the VM deviates from the
+// reference.
+package difftest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lc3/pkg/cflags"
+	"lc3/pkg/trace"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// State is the architectural state an instruction leaves behind.
+type State struct {
+	// PC is the address of the next instruction.
+	PC uint16
+
+	// Registers are R0 to R7.
+	Registers [8]uint16
+
+	// Cond is the condition codes, one of the cflags.
+	Cond uint16
+}
+
+// String formats the state like a trace line.
+func (s State) String() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "PC=%04X", s.PC)
+
+	for r, value := range s.Registers {
+		fmt.Fprintf(&sb, " R%d=%04X", r, value)
+	}
+
+	fmt.Fprintf(&sb, " CC=%s", (&trace.Entry{Cond: s.Cond}).Condition())
+
+	return sb.String()
+}
+
+// Reference is a simulator the VM is compared against.
+type Reference interface {
+	// Step runs one instruction and returns the state it leaves.
+	Step() (State, error)
+
+	// Close stops the simulator.
+	Close() error
+}
+
+// Protocol is how a simulator is driven over its standard input and
+// output: the command that runs one instruction and prints the
+// registers, and the command that quits.
+type Protocol struct {
+	// Name names the protocol.
+	Name string
+
+	// Step is the command that runs one instruction, stepping over
+	// traps, and prints the registers.
+	Step string
+
+	// Quit is the command that quits the simulator.
+	Quit string
+}
+
+// protocols maps the names of protocols to the simulators they drive.
+var protocols = map[string]*Protocol{
+	// lc3 is the debugger of this VM, "lc3 debug object", handy to
+	// check the harness itself.
+	"lc3": {Name: "lc3", Step: "step", Quit: "quit"},
+
+	// lc3sim is the simulator of the lc3tools that accompany Patt and
+	// Patel's textbook, "lc3sim object", whose next command steps over
+	// traps.
+	"lc3sim": {Name: "lc3sim", Step: "next", Quit: "quit"},
+}
+
+// LookupProtocol returns the protocol with the given name.
+func LookupProtocol(name string) (*Protocol, bool) {
+	p, ok := protocols[name]
+	return p, ok
+}
+
+// ProtocolNames returns the names of the protocols, sorted.
+func ProtocolNames() []string {
+	names := make([]string, 0, len(protocols))
+	for name := range protocols {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+var (
+	// registerPattern matches a register dump such as R0=x3000 or
+	// R0 x3000.
+	registerPattern = regexp.MustCompile(`\bR([0-7])\s*[=:]?\s*x([0-9A-Fa-f]{1,4})\b`)
+
+	// pcPattern matches the PC, as PC=x3000 or PC x3000.
+	pcPattern = regexp.MustCompile(`\bPC\s*[=:]?\s*x([0-9A-Fa-f]{1,4})\b`)
+
+	// condPattern matches condition codes written as CC=p or CC P.
+	condPattern = regexp.MustCompile(`\bCC\s*[=:]?\s*([NZPnzp])\b`)
+
+	// psrPattern matches a processor status register, whose low
+	// three bits are the condition codes.
+	psrPattern = regexp.MustCompile(`\bPSR\s*[=:]?\s*x([0-9A-Fa-f]{1,4})\b`)
+)
+
+// DefaultTimeout is how long a simulator is given to print the
+// registers after a step.
+const DefaultTimeout = 5 * time.Second
+
+// Simulator is a reference simulator run as a subprocess.
+type Simulator struct {
+	// protocol drives the simulator.
+	protocol *Protocol
+
+	// cmd is the running simulator.
+	cmd *exec.Cmd
+
+	// stdin is the command input of the simulator.
+	stdin io.WriteCloser
+
+	// lines are the lines the simulator prints, closed when it
+	// exits.
+	lines chan string
+
+	// timeout is how long to wait for the registers after a step.
+	timeout time.Duration
+}
+
+// Start starts a simulator running command, which should load the
+// program, driven by protocol.
+func Start(protocol *Protocol, command []string) (*Simulator, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("no simulator command")
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	s := &Simulator{
+		protocol: protocol,
+		cmd:      cmd,
+		stdin:    stdin,
+		lines:    make(chan string, 64),
+		timeout:  DefaultTimeout,
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			s.lines <- scanner.Text()
+		}
+
+		close(s.lines)
+	}()
+
+	return s, nil
+}
+
+// Step sends the step command and reads lines until the simulator has
+// printed the PC, every register and the condition codes.
+func (s *Simulator) Step() (State, error) {
+	var (
+		state State
+		seen  = map[string]bool{}
+	)
+
+	if _, err := fmt.Fprintln(s.stdin, s.protocol.Step); err != nil {
+		return state, err
+	}
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+
+	for len(seen) < 10 {
+		var (
+			line string
+			ok   bool
+		)
+
+		select {
+		case line, ok = <-s.lines:
+			if !ok {
+				return state, fmt.Errorf("%s exited", s.protocol.Name)
+			}
+		case <-timer.C:
+			return state, fmt.Errorf("%s printed no registers within %s", s.protocol.Name, s.timeout)
+		}
+
+		parseState(line, &state, seen)
+	}
+
+	return state, nil
+}
+
+// conditions maps the letters of condition codes to the cflags.
+var conditions = map[string]uint16{
+	"N": cflags.FLNEG,
+	"Z": cflags.FLZRO,
+	"P": cflags.FLPOS,
+}
+
+// parseState reads the PC, registers and condition codes found in a
+// line into state, marking those it found as seen.
+func parseState(line string, state *State, seen map[string]bool) {
+	for _, m := range registerPattern.FindAllStringSubmatch(line, -1) {
+		r, _ := strconv.Atoi(m[1])
+		state.Registers[r] = parseHex(m[2])
+		seen["R"+m[1]] = true
+	}
+
+	if m := pcPattern.FindStringSubmatch(line); m != nil {
+		state.PC = parseHex(m[1])
+		seen["PC"] = true
+	}
+
+	if m := condPattern.FindStringSubmatch(line); m != nil {
+		state.Cond = conditions[strings.ToUpper(m[1])]
+		seen["CC"] = true
+	} else if m := psrPattern.FindStringSubmatch(line); m != nil {
+		state.Cond = parseHex(m[1]) & 0x7
+		seen["CC"] = true
+	}
+}
+
+// parseHex parses up to four hex digits matched by a pattern.
+func parseHex(s string) uint16 {
+	n, _ := strconv.ParseUint(s, 16, 16)
+	return uint16(n)
+}
+
+// Close asks the simulator to quit and waits for it, killing it if it
+// does not exit in time.
+func (s *Simulator) Close() error {
+	if s.protocol.Quit != "" {
+		fmt.Fprintln(s.stdin, s.protocol.Quit)
+	}
+
+	s.stdin.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		for range s.lines {
+		}
+
+		done <- s.cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		if _, ok := err.(*exec.ExitError); ok {
+			return nil
+		}
+
+		return err
+	case <-time.After(s.timeout):
+		s.cmd.Process.Kill()
+		return <-done
+	}
+}
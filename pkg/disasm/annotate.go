@@ -0,0 +1,95 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// hotShare is the share of the instructions run above which a basic
+// block is marked hot.
+const hotShare = 0.1
+
+// WriteProfile writes the program annotated with the number of times
+// each instruction ran and its share of all instructions run, like
+// Write. The instructions of hot basic blocks, which ran at least a
+// tenth of the instructions, are marked with a star, and the hottest
+// block is summed up at the top.
+func (p *Program) WriteProfile(w io.Writer, count func(address uint16) uint64) error {
+	var total uint64
+	for _, line := range p.Lines {
+		if line.Code {
+			total += count(line.Address)
+		}
+	}
+
+	hot := map[uint16]bool{}
+
+	var hottest *Block
+	var hottestCount uint64
+
+	for _, block := range p.ControlFlow().Blocks {
+		var n uint64
+		for _, line := range block.Lines {
+			n += count(line.Address)
+		}
+
+		if n > hottestCount {
+			hottest, hottestCount = block, n
+		}
+
+		if total > 0 && float64(n) >= hotShare*float64(total) {
+			for _, line := range block.Lines {
+				hot[line.Address] = true
+			}
+		}
+	}
+
+	width := len(fmt.Sprint(hottestCount))
+	for _, line := range p.Lines {
+		width = max(width, len(fmt.Sprint(count(line.Address))))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "; %d instructions run\n", total)
+
+	if hottest != nil {
+		name := hottest.Lines[0].Label
+		if name == "" {
+			name = fmt.Sprintf("x%04X", hottest.Start())
+		}
+
+		fmt.Fprintf(tw, "; hottest block %s, x%04X-x%04X, %.1f%%\n", name, hottest.Start(), hottest.End(), percent(hottestCount, total))
+	}
+
+	fmt.Fprintf(tw, "\t\t\t\t.ORIG x%04X\n", p.Origin)
+
+	for _, line := range p.Lines {
+		marker, n, share := "", "", ""
+
+		if line.Code {
+			if hot[line.Address] {
+				marker = "*"
+			}
+
+			n = fmt.Sprintf("%*d", width, count(line.Address))
+			share = fmt.Sprintf("%5.1f%%", percent(count(line.Address), total))
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t; x%04X\n", marker, n, share, line.Label, line.Text, line.Address)
+	}
+
+	fmt.Fprintf(tw, "\t\t\t\t.END\n")
+
+	return tw.Flush()
+}
+
+// percent returns the share of n in total, in percent.
+func percent(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return 100 * float64(n) / float64(total)
+}
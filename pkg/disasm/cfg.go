@@ -0,0 +1,245 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/traps"
+	"strings"
+)
+
+// EdgeKind is the kind of an edge of a control flow graph.
+type EdgeKind int
+
+const (
+	// EdgeNext goes on to the following instruction.
+	EdgeNext EdgeKind = iota
+
+	// EdgeBranch is taken by a branch.
+	EdgeBranch
+
+	// EdgeCall calls a subroutine with JSR, which returns along the
+	// EdgeNext of the call.
+	EdgeCall
+)
+
+// edgeKinds names the kinds of edges.
+var edgeKinds = map[EdgeKind]string{
+	EdgeNext:   "next",
+	EdgeBranch: "branch",
+	EdgeCall:   "call",
+}
+
+// String returns the name of the kind.
+func (k EdgeKind) String() string {
+	return edgeKinds[k]
+}
+
+// Block is a basic block, a run of instructions only entered at the
+// first and only left after the last.
+type Block struct {
+	// Lines are the instructions of the block.
+	Lines []Line
+}
+
+// Start returns the address of the first instruction of the block.
+func (b *Block) Start() uint16 {
+	return b.Lines[0].Address
+}
+
+// End returns the address of the last instruction of the block.
+func (b *Block) End() uint16 {
+	return b.Lines[len(b.Lines)-1].Address
+}
+
+// Edge is a transfer of control from the end of a block to the start of
+// another.
+type Edge struct {
+	// From is the address of the first instruction of the block left.
+	From uint16
+
+	// To is the address of the first instruction of the block entered.
+	To uint16
+
+	// Kind is the kind of transfer.
+	Kind EdgeKind
+
+	// Condition are the condition codes of a conditional branch, as
+	// in nz.
+	Condition string
+}
+
+// Graph is the control flow graph of a program, made of its reachable
+// instructions. Jumps through registers other than returns lead to
+// blocks it cannot know.
+type Graph struct {
+	// Blocks are the basic blocks in address order.
+	Blocks []*Block
+
+	// Edges are the transfers of control between blocks.
+	Edges []Edge
+}
+
+// ControlFlow returns the control flow graph of the program.
+func (p *Program) ControlFlow() *Graph {
+	code := map[uint16]bool{}
+	leaders := map[uint16]bool{p.Origin: true}
+
+	for _, line := range p.Lines {
+		if !line.Code {
+			continue
+		}
+
+		code[line.Address] = true
+
+		i := Decode(line.Words[0])
+		if target, ok := i.Target(line.Address); ok && (i.Opcode == opcodes.OPBR || i.Opcode == opcodes.OPJSR) {
+			leaders[target] = true
+		}
+
+		if endsBlock(i) {
+			leaders[line.Address+1] = true
+		}
+	}
+
+	g := &Graph{}
+
+	var block *Block
+
+	for _, line := range p.Lines {
+		if !line.Code {
+			block = nil
+			continue
+		}
+
+		// a block is left for the next one when it is entered.
+		if block != nil && leaders[line.Address] {
+			g.Edges = append(g.Edges, Edge{From: block.Start(), To: line.Address, Kind: EdgeNext})
+			block = nil
+		}
+
+		if block == nil {
+			block = &Block{}
+			g.Blocks = append(g.Blocks, block)
+		}
+
+		block.Lines = append(block.Lines, line)
+
+		i := Decode(line.Words[0])
+		if !endsBlock(i) {
+			continue
+		}
+
+		next := line.Address + 1
+		target, _ := i.Target(line.Address)
+
+		switch {
+		case i.Opcode == opcodes.OPBR && i.Flags == 0x7:
+			g.Edges = append(g.Edges, Edge{From: block.Start(), To: target, Kind: EdgeBranch})
+		case i.Opcode == opcodes.OPBR:
+			g.Edges = append(g.Edges,
+				Edge{From: block.Start(), To: target, Kind: EdgeBranch, Condition: conditions(i.Flags)},
+				Edge{From: block.Start(), To: next, Kind: EdgeNext},
+			)
+		case i.Name == "JSR":
+			g.Edges = append(g.Edges,
+				Edge{From: block.Start(), To: target, Kind: EdgeCall},
+				Edge{From: block.Start(), To: next, Kind: EdgeNext},
+			)
+		case i.Name == "JSRR":
+			g.Edges = append(g.Edges, Edge{From: block.Start(), To: next, Kind: EdgeNext})
+		}
+
+		block = nil
+	}
+
+	// edges only lead to instructions of the program.
+	edges := g.Edges[:0]
+	for _, e := range g.Edges {
+		if code[e.To] {
+			edges = append(edges, e)
+		}
+	}
+
+	g.Edges = edges
+
+	return g
+}
+
+// endsBlock reports whether an instruction ends a basic block, because
+// it transfers control elsewhere.
+func endsBlock(i Instruction) bool {
+	switch i.Opcode {
+	case opcodes.OPBR, opcodes.OPJMP, opcodes.OPJSR, opcodes.OPRTI:
+		return true
+	case opcodes.OPTRAP:
+		return i.Imm == traps.HALT
+	}
+
+	return false
+}
+
+// conditions renders the condition codes of a branch, as in nz.
+func conditions(flags uint16) string {
+	var b strings.Builder
+	for n, flag := range "nzp" {
+		if flags&(4>>n) != 0 {
+			b.WriteRune(flag)
+		}
+	}
+
+	return b.String()
+}
+
+// WriteDOT writes the graph in the DOT language of Graphviz, one box
+// per block holding its instructions. Branches are solid edges labelled
+// with their condition codes, calls are dashed and the other edges
+// dotted.
+func (g *Graph) WriteDOT(w io.Writer, name string) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "digraph %s {\n", dotQuote(name))
+	fmt.Fprintf(bw, "\tnode [shape=box, fontname=\"monospace\"];\n")
+
+	for _, block := range g.Blocks {
+		var label strings.Builder
+
+		if block.Lines[0].Label != "" {
+			label.WriteString(block.Lines[0].Label + ":\\l")
+		}
+
+		for _, line := range block.Lines {
+			fmt.Fprintf(&label, "x%04X  %s\\l", line.Address, dotEscape(line.Text))
+		}
+
+		fmt.Fprintf(bw, "\t\"x%04X\" [label=\"%s\"];\n", block.Start(), label.String())
+	}
+
+	for _, e := range g.Edges {
+		attrs := "style=dotted"
+
+		switch e.Kind {
+		case EdgeBranch:
+			attrs = fmt.Sprintf("label=%s", dotQuote(e.Condition))
+		case EdgeCall:
+			attrs = "style=dashed, label=\"call\""
+		}
+
+		fmt.Fprintf(bw, "\t\"x%04X\" -> \"x%04X\" [%s];\n", e.From, e.To, attrs)
+	}
+
+	fmt.Fprintf(bw, "}\n")
+
+	return bw.Flush()
+}
+
+// dotQuote quotes a string for DOT.
+func dotQuote(s string) string {
+	return "\"" + dotEscape(s) + "\""
+}
+
+// dotEscape escapes the quotes and backslashes of a string for DOT.
+func dotEscape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(s)
+}
@@ -0,0 +1,63 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// uncovered marks the instructions that never ran, as gcov does.
+const uncovered = "#####"
+
+// Coverage returns the number of instructions of the program that ran
+// at least once and the number of instructions, counting the lines
+// reached by the control flow and any other line that ran.
+func (p *Program) Coverage(count func(address uint16) uint64) (covered, total int) {
+	for _, line := range p.Lines {
+		if !line.Code && count(line.Address) == 0 {
+			continue
+		}
+
+		total++
+
+		if count(line.Address) > 0 {
+			covered++
+		}
+	}
+
+	return covered, total
+}
+
+// WriteCoverage writes the program annotated with the number of times
+// each instruction ran, and ##### for those that never ran, like Write.
+// The share of instructions that ran is summed up at the top.
+func (p *Program) WriteCoverage(w io.Writer, count func(address uint16) uint64) error {
+	covered, total := p.Coverage(count)
+
+	width := len(uncovered)
+	for _, line := range p.Lines {
+		width = max(width, len(fmt.Sprint(count(line.Address))))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "; %d of %d instructions ran, %.1f%%\n", covered, total, percent(uint64(covered), uint64(total)))
+	fmt.Fprintf(tw, "\t\t\t.ORIG x%04X\n", p.Origin)
+
+	for _, line := range p.Lines {
+		n := ""
+
+		switch {
+		case count(line.Address) > 0:
+			n = fmt.Sprintf("%*d", width, count(line.Address))
+		case line.Code:
+			n = fmt.Sprintf("%*s", width, uncovered)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t; x%04X\n", n, line.Label, line.Text, line.Address)
+	}
+
+	fmt.Fprintf(tw, "\t\t\t.END\n")
+
+	return tw.Flush()
+}
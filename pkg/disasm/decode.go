@@ -0,0 +1,139 @@
+package disasm
+
+import (
+	"fmt"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+)
+
+// Instruction is an instruction word split into its fields.
+type Instruction struct {
+	// Word is the instruction word.
+	Word uint16
+
+	// Opcode is the opcode, one of the opcodes.OP constants.
+	Opcode uint16
+
+	// Name is the mnemonic, RET and JSRR for their forms of JMP and
+	// JSR, and BR whatever the condition codes.
+	Name string
+
+	// DR is the destination register, or the source register of ST,
+	// STI and STR.
+	DR uint16
+
+	// SR1 is the first source register, or the base register of JMP,
+	// JSRR, LDR and STR.
+	SR1 uint16
+
+	// SR2 is the second source register of ADD and AND.
+	SR2 uint16
+
+	// Immediate is set if ADD or AND take Imm instead of SR2.
+	Immediate bool
+
+	// Imm is the immediate of ADD and AND, or the trap vector.
+	Imm int
+
+	// Offset is the PC-relative offset, or the offset from the base
+	// register of LDR and STR.
+	Offset int
+
+	// Flags are the condition codes tested by BR, n, z and p from the
+	// highest bit.
+	Flags uint16
+
+	// Valid is set if the word is an instruction the assembler writes
+	// the same way, with its unused bits as the ISA defines them.
+	Valid bool
+}
+
+// Decode splits an instruction word into its fields.
+func Decode(word uint16) Instruction {
+	i := Instruction{
+		Word:   word,
+		Opcode: word >> 12,
+		Name:   opcodes.Name(word >> 12),
+		DR:     (word >> 9) & 0x7,
+		SR1:    (word >> 6) & 0x7,
+		SR2:    word & 0x7,
+		Valid:  true,
+	}
+
+	switch i.Opcode {
+	case opcodes.OPADD, opcodes.OPAND:
+		i.Immediate = (word>>5)&0x1 == 1
+		if i.Immediate {
+			i.Imm = signed(word, 5)
+		}
+
+		i.Valid = i.Immediate || (word>>3)&0x3 == 0
+	case opcodes.OPBR:
+		i.Flags = i.DR
+		i.Offset = signed(word, 9)
+		i.Valid = i.Flags != 0
+	case opcodes.OPLD, opcodes.OPLDI, opcodes.OPLEA, opcodes.OPST, opcodes.OPSTI:
+		i.Offset = signed(word, 9)
+	case opcodes.OPLDR, opcodes.OPSTR:
+		i.Offset = signed(word, 6)
+	case opcodes.OPJMP:
+		if i.SR1 == registers.RR7 {
+			i.Name = "RET"
+		}
+
+		i.Valid = word&0x0E3F == 0
+	case opcodes.OPJSR:
+		if (word>>11)&0x1 == 1 {
+			i.Offset = signed(word, 11)
+		} else {
+			i.Name = "JSRR"
+			i.Valid = word&0x063F == 0
+		}
+	case opcodes.OPNOT:
+		i.Valid = word&0x3F == 0x3F
+	case opcodes.OPRTI:
+		i.Valid = word&0x0FFF == 0
+	case opcodes.OPTRAP:
+		i.Imm = int(word & 0xFF)
+		i.Valid = word&0x0F00 == 0
+	case opcodes.OPRES:
+		i.Valid = false
+	}
+
+	return i
+}
+
+// Relative reports whether the instruction has a PC-relative operand.
+func (i Instruction) Relative() bool {
+	switch i.Opcode {
+	case opcodes.OPBR, opcodes.OPLD, opcodes.OPLDI, opcodes.OPLEA, opcodes.OPST, opcodes.OPSTI:
+		return true
+	case opcodes.OPJSR:
+		return i.Name == "JSR"
+	}
+
+	return false
+}
+
+// Target returns the address the PC-relative operand of the
+// instruction at an address refers to.
+func (i Instruction) Target(address uint16) (uint16, bool) {
+	if !i.Relative() {
+		return 0, false
+	}
+
+	return address + 1 + uint16(i.Offset), true
+}
+
+// String renders the instruction as assembly, with its PC-relative
+// operand as an offset.
+func (i Instruction) String() string {
+	return i.format(0, func(_ uint16, offset int) string {
+		return fmt.Sprintf("#%d", offset)
+	})
+}
+
+// signed returns the low bits of a word as a signed number.
+func signed(word uint16, bits int) int {
+	return int(int16(word<<(16-bits)) >> (16 - bits))
+}
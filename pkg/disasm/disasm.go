@@ -0,0 +1,86 @@
+// Package disasm converts LC3 machine code back into readable
+// assembly language, a single instruction at a time for the debugger,
+// or whole programs that assemble back into the same words.
+package disasm
+
+import (
+	"fmt"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/traps"
+)
+
+// operandFunc renders a PC-relative operand given the address it
+// refers to and its offset.
+type operandFunc func(target uint16, offset int) string
+
+// Format renders the instruction word at an address as assembly.
+// PC-relative operands are rendered as absolute addresses, and words
+// that do not encode an instruction are rendered as .FILL.
+func Format(address, word uint16) string {
+	return Decode(word).format(address, func(target uint16, _ int) string {
+		return fmt.Sprintf("x%04X", target)
+	})
+}
+
+// FormatWith renders the instruction word at an address like Format,
+// with its PC-relative operand rendered by name given the address it
+// refers to, to show it as a label for example.
+func FormatWith(address, word uint16, name func(target uint16) string) string {
+	return Decode(word).format(address, func(target uint16, _ int) string {
+		return name(target)
+	})
+}
+
+// format renders the instruction at an address, with its PC-relative
+// operand rendered by operand.
+func (i Instruction) format(address uint16, operand operandFunc) string {
+	relative := func() string {
+		target, _ := i.Target(address)
+		return operand(target, i.Offset)
+	}
+
+	switch i.Opcode {
+	case opcodes.OPADD, opcodes.OPAND:
+		if i.Immediate {
+			return fmt.Sprintf("%s R%d, R%d, #%d", i.Name, i.DR, i.SR1, i.Imm)
+		}
+
+		return fmt.Sprintf("%s R%d, R%d, R%d", i.Name, i.DR, i.SR1, i.SR2)
+	case opcodes.OPBR:
+		if i.Flags == 0 {
+			return "NOP"
+		}
+
+		return fmt.Sprintf("BR%s %s", conditions(i.Flags), relative())
+	case opcodes.OPJMP:
+		if i.Name == "RET" {
+			return "RET"
+		}
+
+		return fmt.Sprintf("JMP R%d", i.SR1)
+	case opcodes.OPJSR:
+		if i.Name == "JSR" {
+			return fmt.Sprintf("JSR %s", relative())
+		}
+
+		return fmt.Sprintf("JSRR R%d", i.SR1)
+	case opcodes.OPLD, opcodes.OPLDI, opcodes.OPLEA, opcodes.OPST, opcodes.OPSTI:
+		return fmt.Sprintf("%s R%d, %s", i.Name, i.DR, relative())
+	case opcodes.OPLDR, opcodes.OPSTR:
+		return fmt.Sprintf("%s R%d, R%d, #%d", i.Name, i.DR, i.SR1, i.Offset)
+	case opcodes.OPNOT:
+		return fmt.Sprintf("NOT R%d, R%d", i.DR, i.SR1)
+	case opcodes.OPRTI:
+		return "RTI"
+	case opcodes.OPTRAP:
+		// the assembler has an alias for every named trap but
+		// HOSTCALL.
+		if name := traps.Name(uint16(i.Imm)); name != "" && i.Imm != traps.HOSTCALL {
+			return name
+		}
+
+		return fmt.Sprintf("TRAP x%02X", i.Imm)
+	}
+
+	return fmt.Sprintf(".FILL x%04X", i.Word)
+}
@@ -0,0 +1,134 @@
+package disasm
+
+import (
+	"lc3/pkg/opcodes"
+	"lc3/pkg/traps"
+	"strings"
+)
+
+const (
+	// vectorTable is the address of the trap vector table followed by
+	// the interrupt vector table, whose words are the addresses of
+	// routines.
+	vectorTable = 0x0000
+
+	// vectorTableEnd is the address following the vector tables.
+	vectorTableEnd = 0x0200
+)
+
+// reachable follows the control flow of a program from its entry
+// point and the routines of the vector tables it holds, returning
+// which of its words are instructions that can run. The others are
+// data, or code only reached through a register.
+func reachable(origin uint16, words []uint16) []bool {
+	code := make([]bool, len(words))
+	end := int(origin) + len(words)
+
+	entries := []uint16{origin}
+
+	for address := max(int(origin), vectorTable); address < min(end, vectorTableEnd); address++ {
+		entries = append(entries, words[address-int(origin)])
+	}
+
+	for len(entries) > 0 {
+		address := entries[len(entries)-1]
+		entries = entries[:len(entries)-1]
+
+		i := int(address) - int(origin)
+		if i < 0 || i >= len(words) || code[i] || !Decode(words[i]).Valid {
+			continue
+		}
+
+		code[i] = true
+		entries = append(entries, successors(address, words[i])...)
+	}
+
+	return code
+}
+
+// successors returns the addresses of the instructions that may run
+// after the instruction at an address, as far as they are known
+// without running it.
+func successors(address, word uint16) []uint16 {
+	i := Decode(word)
+	next := address + 1
+	target, relative := i.Target(address)
+
+	switch i.Opcode {
+	case opcodes.OPBR:
+		if i.Flags == 0x7 {
+			return []uint16{target}
+		}
+
+		return []uint16{next, target}
+	case opcodes.OPJSR:
+		// subroutines return to the instruction after the call.
+		if relative {
+			return []uint16{next, target}
+		}
+	case opcodes.OPJMP, opcodes.OPRTI:
+		return nil
+	case opcodes.OPTRAP:
+		if i.Imm == traps.HALT {
+			return nil
+		}
+	}
+
+	return []uint16{next}
+}
+
+// stringz returns the number of words of the string starting at the
+// word i of data, including its terminating zero, or 0 if there is
+// none. Strings are at least two printable characters long and end
+// before a labelled address.
+func (p *Program) stringz(words []uint16, data []bool, i int) int {
+	n := i
+	for n < len(words) && data[n] && printable(words[n]) {
+		if n > i && p.Labels[p.Origin+uint16(n)] != "" {
+			return 0
+		}
+
+		n++
+	}
+
+	if n-i < 2 || n >= len(words) || !data[n] || words[n] != 0 || p.Labels[p.Origin+uint16(n)] != "" {
+		return 0
+	}
+
+	return n - i + 1
+}
+
+// printable reports whether a word is a character that reads well in a
+// string.
+func printable(word uint16) bool {
+	return word >= ' ' && word <= '~' || word == '\n' || word == '\t' || word == '\r' || word == 0x1B
+}
+
+// quote renders characters as a string literal of the assembler.
+func quote(words []uint16) string {
+	var b strings.Builder
+
+	b.WriteByte('"')
+
+	for _, word := range words {
+		switch word {
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		case 0x1B:
+			b.WriteString(`\e`)
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(word))
+		default:
+			b.WriteByte(byte(word))
+		}
+	}
+
+	b.WriteByte('"')
+
+	return b.String()
+}
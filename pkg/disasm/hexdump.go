@@ -0,0 +1,95 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// hexdumpWords is the number of words on a line of a hexdump.
+const hexdumpWords = 8
+
+// The markers of the words of a hexdump.
+const (
+	// codeMarker marks instructions reached by the control flow.
+	codeMarker = 'C'
+
+	// stringMarker marks the characters of strings and their
+	// terminating zeros.
+	stringMarker = 'S'
+
+	// dataMarker marks the other words.
+	dataMarker = 'D'
+)
+
+// WriteHexdump writes the words of the program as a hexdump for lab
+// reports and bug reports, eight words to a line:
+//
+//	x3000  E002 F022 F025 0048 0069 0021 0000 1234  CCCSSSSD  ...Hi!..  START, x3003 MSG
+//
+// Each line holds the address of its first word, the words, a marker
+// per word telling instructions (C) from strings (S) and other data
+// (D), the words as ASCII with a dot for those that are not printable,
+// and the labels of its addresses.
+func (p *Program) WriteHexdump(w io.Writer) error {
+	var words []uint16
+	var markers []byte
+
+	for _, line := range p.Lines {
+		marker := byte(dataMarker)
+
+		switch {
+		case line.Code:
+			marker = codeMarker
+		case strings.HasPrefix(line.Text, ".STRINGZ"):
+			marker = stringMarker
+		}
+
+		for _, word := range line.Words {
+			words = append(words, word)
+			markers = append(markers, marker)
+		}
+	}
+
+	fmt.Fprintf(w, "; x%04X-x%04X, %d words: C code, S string, D data\n", p.Origin, p.Origin+uint16(len(words))-1, len(words))
+
+	for i := 0; i < len(words); i += hexdumpWords {
+		n := min(hexdumpWords, len(words)-i)
+		address := p.Origin + uint16(i)
+
+		var hex, ascii strings.Builder
+		var labels []string
+
+		for j := 0; j < hexdumpWords; j++ {
+			if j >= n {
+				hex.WriteString("     ")
+				continue
+			}
+
+			word := words[i+j]
+			fmt.Fprintf(&hex, "%04X ", word)
+
+			if word >= ' ' && word <= '~' {
+				ascii.WriteByte(byte(word))
+			} else {
+				ascii.WriteByte('.')
+			}
+
+			if label, ok := p.Labels[address+uint16(j)]; ok {
+				if j > 0 {
+					label = fmt.Sprintf("x%04X %s", address+uint16(j), label)
+				}
+
+				labels = append(labels, label)
+			}
+		}
+
+		line := fmt.Sprintf("x%04X  %s %-*s  %-*s  %s", address, hex.String(), hexdumpWords, markers[i:i+n], hexdumpWords, ascii.String(), strings.Join(labels, ", "))
+
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
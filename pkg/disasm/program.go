@@ -0,0 +1,212 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"lc3/pkg/debuginfo"
+	"lc3/pkg/symbols"
+)
+
+// Program is a disassembled program, which assembles back into the
+// same words.
+type Program struct {
+	// Origin is the address of the first word.
+	Origin uint16
+
+	// Labels maps the addresses of the program to their labels, the
+	// real ones if known, or labels generated for the addresses the
+	// program refers to.
+	Labels map[uint16]string
+
+	// named holds the addresses whose label is a real one, which data
+	// words may refer to.
+	named map[uint16]bool
+
+	// Lines are the lines of the program, one per instruction or data
+	// directive.
+	Lines []Line
+}
+
+// Line is a line of a disassembled program.
+type Line struct {
+	// Address is the address of the first word of the line.
+	Address uint16
+
+	// Label is the label of the address, if any.
+	Label string
+
+	// Text is the instruction or directive.
+	Text string
+
+	// Words are the words the line stands for.
+	Words []uint16
+
+	// Code is set if the line is an instruction reached by the control
+	// flow of the program.
+	Code bool
+}
+
+// Option configures a disassembly.
+type Option func(d *disassembler)
+
+// disassembler holds what is known about the program disassembled.
+type disassembler struct {
+	// symbols are the labels of the program, if known.
+	symbols *symbols.Table
+
+	// debug maps the program to its source, if known.
+	debug *debuginfo.Info
+}
+
+// WithSymbols names the addresses of the program after the labels of
+// its symbol table.
+func WithSymbols(table *symbols.Table) Option {
+	return func(d *disassembler) {
+		d.symbols = table
+	}
+}
+
+// WithDebugInfo names the addresses of the program after the labels of
+// its debug info, each label naming the first address of its scope.
+func WithDebugInfo(info *debuginfo.Info) Option {
+	return func(d *disassembler) {
+		d.debug = info
+	}
+}
+
+// Disassemble disassembles the words of a program loaded at origin.
+// Its control flow is followed from the origin and from the routines
+// of the vector tables it holds: the words reached are disassembled as
+// instructions, the others as data, strings being recognized as
+// .STRINGZ.
+//
+// Addresses are named after the labels of the symbol table or debug
+// info given, and the other addresses of the program that
+// instructions branch to, call, load from or store to are given
+// labels such as L_3010. Data words holding a named address refer to
+// it by name.
+func Disassemble(origin uint16, words []uint16, opts ...Option) *Program {
+	d := &disassembler{}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	p := &Program{Origin: origin, Labels: map[uint16]string{}, named: map[uint16]bool{}}
+
+	code := reachable(origin, words)
+
+	data := make([]bool, len(words))
+	for i := range code {
+		data[i] = !code[i]
+	}
+
+	for i := range words {
+		address := origin + uint16(i)
+
+		if label, ok := d.label(address); ok {
+			p.Labels[address] = label
+			p.named[address] = true
+		}
+	}
+
+	for i, word := range words {
+		address := origin + uint16(i)
+
+		if !code[i] {
+			continue
+		}
+
+		if target, ok := Decode(word).Target(address); ok && p.contains(target, len(words)) && p.Labels[target] == "" {
+			p.Labels[target] = fmt.Sprintf("L_%04X", target)
+		}
+	}
+
+	for i := 0; i < len(words); {
+		address := origin + uint16(i)
+		word := words[i]
+		line := Line{Address: address, Label: p.Labels[address], Words: words[i : i+1]}
+
+		switch n := p.stringz(words, data, i); {
+		case code[i]:
+			line.Text = Decode(word).format(address, p.operand(len(words)))
+			line.Code = true
+		case n > 0:
+			line.Text = ".STRINGZ " + quote(words[i:i+n-1])
+			line.Words = words[i : i+n]
+		case p.named[word]:
+			// data words holding a named address are pointers to it.
+			line.Text = ".FILL " + p.Labels[word]
+		default:
+			line.Text = fmt.Sprintf(".FILL x%04X", word)
+		}
+
+		p.Lines = append(p.Lines, line)
+		i += len(line.Words)
+	}
+
+	return p
+}
+
+// label returns the real label of an address, from the symbol table or
+// else the debug info. Labels the assembler would not accept, such as
+// those local to macro expansions, are left out.
+func (d *disassembler) label(address uint16) (string, bool) {
+	if name, ok := d.symbols.Name(address); ok && validLabel(name) {
+		return name, true
+	}
+
+	if d.debug == nil {
+		return "", false
+	}
+
+	location, ok := d.debug.Location(address)
+	if !ok || location.Scope == "" {
+		return "", false
+	}
+
+	// a scope starts at the address of its label.
+	previous, ok := d.debug.Location(address - 1)
+	if ok && previous.Scope == location.Scope {
+		return "", false
+	}
+
+	return location.Scope, validLabel(location.Scope)
+}
+
+// validLabel reports whether a name can be written as a label: a letter
+// or underscore followed by letters, digits and underscores.
+func validLabel(name string) bool {
+	for i, c := range name {
+		letter := c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+
+	return name != ""
+}
+
+// contains reports whether an address is one of the n words of the
+// program.
+func (p *Program) contains(address uint16, n int) bool {
+	return int(address) >= int(p.Origin) && int(address) < int(p.Origin)+n
+}
+
+// operand returns the renderer of the PC-relative operands of a program
+// of n words, which refer to its addresses by label and to the others
+// by offset, since they cannot be told apart from constants.
+func (p *Program) operand(n int) operandFunc {
+	return func(target uint16, offset int) string {
+		if label, ok := p.Labels[target]; ok {
+			return label
+		}
+
+		return fmt.Sprintf("#%d", offset)
+	}
+}
+
+// Write writes the program as assembly language, with the address and
+// words of each line in a comment.
+func (p *Program) Write(w io.Writer) error {
+	return p.WriteStyle(w, DefaultStyle)
+}
@@ -0,0 +1,130 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Style describes how a disassembled program is written, so that it
+// can be pasted into the toolchain a course uses.
+type Style struct {
+	// Name names the style.
+	Name string
+
+	// Align pads the labels and instructions into columns, instead of
+	// separating them with a single tab.
+	Align bool
+
+	// LabelLines puts labels on lines of their own, above the line
+	// they name.
+	LabelLines bool
+
+	// Words adds the words of each line to its address comment.
+	Words bool
+
+	// Trace writes address: hex  mnemonic lines instead of a source,
+	// to read or diff rather than to assemble.
+	Trace bool
+}
+
+// styles are the styles known by name.
+var styles = map[string]Style{
+	"lc3": {
+		Name:  "lc3",
+		Align: true,
+		Words: true,
+	},
+	"lc3as": {
+		Name: "lc3as",
+	},
+	"pennsim": {
+		Name:       "pennsim",
+		LabelLines: true,
+	},
+	"trace": {
+		Name:  "trace",
+		Trace: true,
+	},
+}
+
+// DefaultStyle is the style of Program.Write.
+var DefaultStyle = styles["lc3"]
+
+// LookupStyle returns a style by name: lc3 for aligned columns with the
+// address and words of each line in a comment, lc3as or pennsim for the
+// layout of those assemblers, or trace for compact address: hex
+// mnemonic lines.
+func LookupStyle(name string) (Style, bool) {
+	s, ok := styles[strings.ToLower(name)]
+	return s, ok
+}
+
+// StyleNames returns the names of the known styles, sorted.
+func StyleNames() []string {
+	names := make([]string, 0, len(styles))
+	for name := range styles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// WriteStyle writes the program in a style.
+func (p *Program) WriteStyle(w io.Writer, style Style) error {
+	if style.Trace {
+		return p.writeTrace(w)
+	}
+
+	out := w
+
+	var tw *tabwriter.Writer
+	if style.Align {
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		out = tw
+	}
+
+	fmt.Fprintf(out, "\t.ORIG x%04X\n", p.Origin)
+
+	for _, line := range p.Lines {
+		label := line.Label
+		if style.LabelLines && label != "" {
+			fmt.Fprintln(out, label)
+			label = ""
+		}
+
+		fmt.Fprintf(out, "%s\t%s\t; x%04X", label, line.Text, line.Address)
+
+		if style.Words {
+			for _, word := range line.Words {
+				fmt.Fprintf(out, " %04X", word)
+			}
+		}
+
+		fmt.Fprintln(out)
+	}
+
+	fmt.Fprintf(out, "\t.END\n")
+
+	if tw != nil {
+		return tw.Flush()
+	}
+
+	return nil
+}
+
+// writeTrace writes one line per line of the program with its address,
+// first word and text, as in x3000: E01E  LEA R0, MSG.
+func (p *Program) writeTrace(w io.Writer) error {
+	for _, line := range p.Lines {
+		if _, err := fmt.Fprintf(w, "x%04X: %04X  %s\n", line.Address, line.Words[0], line.Text); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
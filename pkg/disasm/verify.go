@@ -0,0 +1,55 @@
+package disasm
+
+import (
+	"bytes"
+	"fmt"
+	"lc3/pkg/asm"
+	"strings"
+)
+
+// Verify disassembles the words of a program loaded at origin,
+// assembles the disassembly and checks that it gives back the same
+// words, so that the assembler and disassembler agree on every
+// instruction. The error names the first line that differs.
+func Verify(origin uint16, words []uint16, opts ...Option) error {
+	program := Disassemble(origin, words, opts...)
+
+	var source bytes.Buffer
+	if err := program.Write(&source); err != nil {
+		return err
+	}
+
+	obj, _, diagnostics, err := asm.Assemble(&source)
+	if err != nil {
+		return err
+	}
+
+	if len(diagnostics) > 0 {
+		return fmt.Errorf("disassembly does not assemble: %v", diagnostics[0])
+	}
+
+	if obj.Origin != origin {
+		return fmt.Errorf("disassembly assembles at x%04X instead of x%04X", obj.Origin, origin)
+	}
+
+	for _, line := range program.Lines {
+		for i, word := range line.Words {
+			address := int(line.Address) + i
+			n := address - int(origin)
+
+			if n >= len(obj.Words) {
+				return fmt.Errorf("x%04X: %s assembles into fewer words", line.Address, strings.TrimSpace(line.Text))
+			}
+
+			if obj.Words[n] != word {
+				return fmt.Errorf("x%04X: %s assembles into %04X instead of %04X", address, line.Text, obj.Words[n], word)
+			}
+		}
+	}
+
+	if len(obj.Words) != len(words) {
+		return fmt.Errorf("disassembly assembles into %d words instead of %d", len(obj.Words), len(words))
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package disasm
+
+import (
+	"lc3/pkg/asm"
+	"strings"
+	"testing"
+)
+
+// TestVerifyWords checks that every word disassembles into a line that
+// assembles back into it.
+func TestVerifyWords(t *testing.T) {
+	for word := 0; word <= 0xFFFF; word++ {
+		if err := Verify(0x3000, []uint16{uint16(word)}); err != nil {
+			t.Errorf("%04X: %v", word, err)
+		}
+	}
+}
+
+// TestVerifyPrograms checks that programs survive a round trip through
+// the disassembler.
+func TestVerifyPrograms(t *testing.T) {
+	sources := map[string]string{
+		"every instruction": `
+			.ORIG x3000
+	MAIN	LEA R0, MSG
+			PUTS
+			AND R1, R1, #0
+			ADD R1, R1, #3
+	LOOP	ADD R0, R1, R2
+			BRp LOOP
+			BRnz DONE
+			JSR SUB
+			LEA R2, SUB
+			JSRR R2
+			LD R2, DATA
+			LDI R3, PTR
+			LDR R4, R0, #-2
+			ST R2, DATA
+			STI R2, PTR
+			STR R2, R6, #31
+			NOT R5, R5
+			TRAP x26
+	DONE	HALT
+	SUB		RET
+			RTI
+	DATA	.FILL xFFFF
+	PTR		.FILL DATA
+	MSG		.STRINGZ "say \"hi\"\n"
+			.BLKW 3
+			.END`,
+		"far data": `
+			.ORIG x3000
+			LD R0, #-5
+			BRnzp #-200
+			.END`,
+	}
+
+	for name, source := range sources {
+		obj, _, diagnostics, err := asm.Assemble(strings.NewReader(source))
+		if err != nil || len(diagnostics) > 0 {
+			t.Fatalf("%s: %v%v", name, err, diagnostics)
+		}
+
+		if err := Verify(obj.Origin, obj.Words); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Package frame multiplexes channels over a single byte stream, such
+// as the stdin and stdout of a headless VM, with a simple framing: a
+// frame is the byte naming its channel, the length of its payload as
+// a 32-bit big-endian number, then the payload.
+//
+//	+---------+-----------------+----------------+
+//	| channel | length (uint32) | payload        |
+//	+---------+-----------------+----------------+
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The channels multiplexed.
+const (
+	// Control carries JSON-RPC requests and their responses.
+	Control byte = 'c'
+
+	// Input carries the keys typed into the console of the program.
+	Input byte = 'i'
+
+	// Output carries the console output of the program.
+	Output byte = 'o'
+
+	// Event carries JSON-RPC notifications of what the VM did.
+	Event byte = 'e'
+)
+
+// MaxPayload caps the bytes of the payload of a frame read.
+const MaxPayload = 16 << 20
+
+// Write writes a frame.
+func Write(w io.Writer, channel byte, payload []byte) error {
+	buf := make([]byte, 5, 5+len(payload))
+	buf[0] = channel
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(payload)))
+
+	_, err := w.Write(append(buf, payload...))
+
+	return err
+}
+
+// Read reads a frame, returning io.EOF if the stream ends before it
+// and io.ErrUnexpectedEOF if it ends within it.
+func Read(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > MaxPayload {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return 0, nil, err
+	}
+
+	return header[0], payload, nil
+}
@@ -0,0 +1,616 @@
+// Package gdbstub implements the GDB remote serial protocol on top of
+// the debugger, so that GDB and IDEs speaking the protocol can attach
+// to a running LC3 virtual machine.
+//
+// The target has ten 16-bit registers, R0 to R7, the PC and the
+// condition codes, transferred in big-endian byte order like words of
+// an object file. Memory addresses are LC3 word addresses and every
+// word is transferred as two bytes, so reading 4 bytes at x3000
+// returns the words at x3000 and x3001.
+package gdbstub
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"lc3/pkg/debugger"
+	"lc3/pkg/registers"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// interrupt is the byte GDB sends to stop a running program.
+const interrupt = 0x03
+
+// packetSize is the size of the largest packet the front-end may send
+// or ask for, as advertised in qSupported.
+const packetSize = 0x4000
+
+// targetXML describes the registers of the target.
+const targetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.lc3.core">
+    <reg name="r0" bitsize="16" type="int16" regnum="0"/>
+    <reg name="r1" bitsize="16" type="int16"/>
+    <reg name="r2" bitsize="16" type="int16"/>
+    <reg name="r3" bitsize="16" type="int16"/>
+    <reg name="r4" bitsize="16" type="int16"/>
+    <reg name="r5" bitsize="16" type="int16"/>
+    <reg name="r6" bitsize="16" type="data_ptr"/>
+    <reg name="r7" bitsize="16" type="code_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+    <reg name="cond" bitsize="16" type="int16"/>
+  </feature>
+</target>
+`
+
+// ListenAndServe listens on the TCP address addr and serves the first
+// debugger front-end that connects until it detaches or kills the
+// program.
+func ListenAndServe(addr string, dbg *debugger.Debugger) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	defer listener.Close()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	return Serve(conn, dbg)
+}
+
+// Serve speaks the remote serial protocol over conn until the front-end
+// detaches, kills the program or closes the connection.
+func Serve(conn io.ReadWriter, dbg *debugger.Debugger) error {
+	s := &session{
+		conn:        conn,
+		dbg:         dbg,
+		packets:     make(chan string, 16),
+		breakpoints: map[string]int{},
+	}
+
+	go s.receive(bufio.NewReader(conn))
+
+	for packet := range s.packets {
+		reply, done := s.handle(packet)
+
+		if err := s.send(reply); err != nil {
+			return err
+		}
+
+		if done {
+			return nil
+		}
+	}
+
+	if s.err == io.EOF {
+		return nil
+	}
+
+	return s.err
+}
+
+// session is a single connection to a front-end.
+type session struct {
+	// conn is the connection to the front-end.
+	conn io.ReadWriter
+
+	// dbg is the debugger controlling the program.
+	dbg *debugger.Debugger
+
+	// packets delivers received packets, it is closed when the
+	// connection ends.
+	packets chan string
+
+	// err is the error that ended the connection.
+	err error
+
+	// mu serializes writes to conn.
+	mu sync.Mutex
+
+	// noAck is set once the front-end turned off acknowledgements.
+	noAck atomic.Bool
+
+	// breakpoints maps "type,address,kind" of inserted breakpoints
+	// and watchpoints to their debugger IDs.
+	breakpoints map[string]int
+}
+
+// receive reads packets from the front-end, acknowledging them and
+// forwarding interrupt requests to the debugger straight away since
+// the program may be running.
+func (s *session) receive(r *bufio.Reader) {
+	defer close(s.packets)
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			s.err = err
+			return
+		}
+
+		switch b {
+		case interrupt:
+			s.dbg.Interrupt()
+		case '$':
+			packet, ok, err := readPacket(r)
+			if err != nil {
+				s.err = err
+				return
+			}
+
+			if !s.noAck.Load() {
+				ack := "+"
+				if !ok {
+					ack = "-"
+				}
+
+				if err := s.write(ack); err != nil {
+					s.err = err
+					return
+				}
+			}
+
+			if ok {
+				s.packets <- packet
+			}
+		}
+	}
+}
+
+// readPacket reads the rest of a packet following its '$', it reports
+// whether the checksum matched.
+func readPacket(r *bufio.Reader) (string, bool, error) {
+	data, err := r.ReadString('#')
+	if err != nil {
+		return "", false, err
+	}
+
+	data = strings.TrimSuffix(data, "#")
+
+	var sum [2]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return "", false, err
+	}
+
+	want, err := strconv.ParseUint(string(sum[:]), 16, 8)
+
+	return unescape(data), err == nil && byte(want) == checksum(data), nil
+}
+
+// checksum returns the checksum of the packet data.
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+
+	return sum
+}
+
+// unescape removes the escaping of binary packet data.
+func unescape(data string) string {
+	if !strings.Contains(data, "}") {
+		return data
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			b.WriteByte(data[i] ^ 0x20)
+			continue
+		}
+
+		b.WriteByte(data[i])
+	}
+
+	return b.String()
+}
+
+// escape escapes the characters that may not appear in packet data.
+func escape(data string) string {
+	var b strings.Builder
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '#', '$', '}', '*':
+			b.WriteByte('}')
+			b.WriteByte(c ^ 0x20)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// send sends a reply packet.
+func (s *session) send(reply string) error {
+	data := escape(reply)
+
+	return s.write(fmt.Sprintf("$%s#%02x", data, checksum(data)))
+}
+
+// write writes raw bytes to the front-end.
+func (s *session) write(str string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := io.WriteString(s.conn, str)
+
+	return err
+}
+
+// handle executes a packet and returns the reply, it reports whether
+// the session is over.
+func (s *session) handle(packet string) (string, bool) {
+	if packet == "" {
+		return "", false
+	}
+
+	args := packet[1:]
+
+	switch packet[0] {
+	case '?':
+		return "S05", false
+	case 'g':
+		return s.readRegisters(), false
+	case 'G':
+		return s.writeRegisters(args), false
+	case 'p':
+		return s.readRegister(args), false
+	case 'P':
+		return s.writeRegister(args), false
+	case 'm':
+		return s.readMemory(args), false
+	case 'M':
+		return s.writeMemory(args), false
+	case 'c':
+		return s.resume(args, false), false
+	case 's':
+		return s.resume(args, true), false
+	case 'Z':
+		return s.insertBreakpoint(args), false
+	case 'z':
+		return s.removeBreakpoint(args), false
+	case 'H', 'T':
+		return "OK", false
+	case 'D':
+		return "OK", true
+	case 'k':
+		return "", true
+	case 'q', 'Q':
+		return s.query(packet), false
+	case 'v':
+		return s.verbose(packet), false
+	}
+
+	return "", false
+}
+
+// query answers general query packets.
+func (s *session) query(packet string) string {
+	name, args, _ := strings.Cut(packet, ":")
+
+	switch name {
+	case "qSupported":
+		return fmt.Sprintf("PacketSize=%x;qXfer:features:read+;QStartNoAckMode+", packetSize)
+	case "QStartNoAckMode":
+		s.noAck.Store(true)
+		return "OK"
+	case "qAttached":
+		return "1"
+	case "qC":
+		return "QC1"
+	case "qfThreadInfo":
+		return "m1"
+	case "qsThreadInfo":
+		return "l"
+	case "qOffsets":
+		return "Text=0;Data=0;Bss=0"
+	case "qXfer":
+		return readFeatures(args)
+	}
+
+	return ""
+}
+
+// readFeatures answers a qXfer:features:read request for the target
+// description.
+func readFeatures(args string) string {
+	annex, ok := strings.CutPrefix(args, "features:read:target.xml:")
+	if !ok {
+		return "E00"
+	}
+
+	offset, length, ok := parsePair(annex)
+	if !ok {
+		return "E01"
+	}
+
+	if offset >= len(targetXML) {
+		return "l"
+	}
+
+	end := offset + length
+	if end >= len(targetXML) {
+		return "l" + targetXML[offset:]
+	}
+
+	return "m" + targetXML[offset:end]
+}
+
+// verbose answers the v packets.
+func (s *session) verbose(packet string) string {
+	switch {
+	case packet == "vCont?":
+		return "vCont;c;C;s;S"
+	case strings.HasPrefix(packet, "vCont;"):
+		action := strings.TrimPrefix(packet, "vCont;")
+		if action == "" {
+			return "E01"
+		}
+
+		switch action[0] {
+		case 'c', 'C':
+			return s.resume("", false)
+		case 's', 'S':
+			return s.resume("", true)
+		}
+	}
+
+	return ""
+}
+
+// readRegisters encodes every register.
+func (s *session) readRegisters() string {
+	var b strings.Builder
+	for r := uint16(0); r < registers.RCOUNT; r++ {
+		fmt.Fprintf(&b, "%04x", s.dbg.CPU().Register(r))
+	}
+
+	return b.String()
+}
+
+// writeRegisters decodes and sets every register.
+func (s *session) writeRegisters(data string) string {
+	words, ok := decodeWords(data)
+	if !ok || len(words) < registers.RCOUNT {
+		return "E01"
+	}
+
+	for r, val := range words[:registers.RCOUNT] {
+		s.dbg.CPU().SetRegister(uint16(r), val)
+	}
+
+	return "OK"
+}
+
+// readRegister encodes a single register.
+func (s *session) readRegister(args string) string {
+	r, err := strconv.ParseUint(args, 16, 16)
+	if err != nil || r >= registers.RCOUNT {
+		return "E01"
+	}
+
+	return fmt.Sprintf("%04x", s.dbg.CPU().Register(uint16(r)))
+}
+
+// writeRegister sets a single register.
+func (s *session) writeRegister(args string) string {
+	reg, data, _ := strings.Cut(args, "=")
+
+	r, err := strconv.ParseUint(reg, 16, 16)
+	if err != nil || r >= registers.RCOUNT {
+		return "E01"
+	}
+
+	words, ok := decodeWords(data)
+	if !ok || len(words) != 1 {
+		return "E01"
+	}
+
+	s.dbg.CPU().SetRegister(uint16(r), words[0])
+
+	return "OK"
+}
+
+// readMemory encodes length bytes of memory starting at a word address.
+// Reads longer than fit in a packet return what fits, which front-ends
+// take as a short read.
+func (s *session) readMemory(args string) string {
+	address, length, ok := parsePair(args)
+	if !ok {
+		return "E01"
+	}
+
+	length = min(length, packetSize/2)
+
+	buf := make([]byte, length)
+	for i := range buf {
+		word := s.dbg.CPU().PeekMemory(uint16(address + i/2))
+
+		if i%2 == 0 {
+			buf[i] = byte(word >> 8)
+		} else {
+			buf[i] = byte(word)
+		}
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+// writeMemory writes bytes of memory starting at a word address.
+func (s *session) writeMemory(args string) string {
+	header, data, _ := strings.Cut(args, ":")
+
+	address, length, ok := parsePair(header)
+	if !ok {
+		return "E01"
+	}
+
+	buf, err := hex.DecodeString(data)
+	if err != nil || len(buf) != length {
+		return "E01"
+	}
+
+	cpu := s.dbg.CPU()
+
+	for i, b := range buf {
+		addr := uint16(address + i/2)
+		word := cpu.PeekMemory(addr)
+
+		if i%2 == 0 {
+			word = word&0x00FF | uint16(b)<<8
+		} else {
+			word = word&0xFF00 | uint16(b)
+		}
+
+		cpu.PokeMemory(addr, word)
+	}
+
+	return "OK"
+}
+
+// resume continues or single steps the program, optionally from a new
+// address, and returns the stop reply.
+func (s *session) resume(args string, step bool) string {
+	if args != "" {
+		address, err := strconv.ParseUint(args, 16, 16)
+		if err != nil {
+			return "E01"
+		}
+
+		s.dbg.CPU().SetRegister(registers.RPC, uint16(address))
+	}
+
+	var stop debugger.Stop
+	if step {
+		stop = s.dbg.Step(1)
+	} else {
+		stop = s.dbg.Continue()
+	}
+
+	return stopReply(stop)
+}
+
+// stopReply encodes why the program stopped.
+func stopReply(stop debugger.Stop) string {
+	switch stop.Reason {
+	case debugger.StopHalt:
+		return "W00"
+	case debugger.StopError:
+		return "S04"
+	case debugger.StopInterrupt:
+		return "S02"
+	case debugger.StopWatchpoint:
+		kind := "watch"
+		switch stop.Watchpoint.Kind {
+		case debugger.WatchRead:
+			kind = "rwatch"
+		case debugger.WatchAccess:
+			kind = "awatch"
+		}
+
+		return fmt.Sprintf("T05%s:%x;%02x:%04x;", kind, stop.Access.Address, registers.RPC, stop.PC)
+	}
+
+	return fmt.Sprintf("T05%02x:%04x;", registers.RPC, stop.PC)
+}
+
+// watchKinds maps Z packet types to watchpoint kinds.
+var watchKinds = map[string]debugger.WatchKind{
+	"2": debugger.WatchWrite,
+	"3": debugger.WatchRead,
+	"4": debugger.WatchAccess,
+}
+
+// insertBreakpoint inserts a breakpoint or watchpoint. Types 0 and 1
+// are breakpoints, 2 to 4 are write, read and access watchpoints whose
+// kind is their length in bytes.
+func (s *session) insertBreakpoint(args string) string {
+	typ, rest, _ := strings.Cut(args, ",")
+
+	address, length, ok := parsePair(rest)
+	if !ok {
+		return "E01"
+	}
+
+	if _, exists := s.breakpoints[args]; exists {
+		return "OK"
+	}
+
+	switch typ {
+	case "0", "1":
+		s.breakpoints[args] = s.dbg.AddBreakpoint(uint16(address)).ID
+	case "2", "3", "4":
+		words := max((length+1)/2, 1)
+		end := uint16(address + words - 1)
+
+		s.breakpoints[args] = s.dbg.AddWatchpoint(uint16(address), end, watchKinds[typ]).ID
+	default:
+		return ""
+	}
+
+	return "OK"
+}
+
+// removeBreakpoint removes a breakpoint or watchpoint inserted before.
+func (s *session) removeBreakpoint(args string) string {
+	id, ok := s.breakpoints[args]
+	if !ok {
+		return "E01"
+	}
+
+	s.dbg.DeleteBreakpoint(id)
+	delete(s.breakpoints, args)
+
+	return "OK"
+}
+
+// parsePair parses the "ADDR,LENGTH" argument of memory, breakpoint
+// and transfer packets, both numbers in hex.
+func parsePair(args string) (int, int, bool) {
+	first, second, ok := strings.Cut(args, ",")
+	if !ok {
+		return 0, 0, false
+	}
+
+	a, err := strconv.ParseUint(first, 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	b, err := strconv.ParseUint(second, 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return int(a), int(b), true
+}
+
+// decodeWords decodes hex data into big-endian words.
+func decodeWords(data string) ([]uint16, bool) {
+	buf, err := hex.DecodeString(data)
+	if err != nil || len(buf)%2 != 0 {
+		return nil, false
+	}
+
+	words := make([]uint16, len(buf)/2)
+	for i := range words {
+		words[i] = uint16(buf[2*i])<<8 | uint16(buf[2*i+1])
+	}
+
+	return words, true
+}
@@ -0,0 +1,212 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/debugger"
+	"net"
+	"testing"
+	"time"
+)
+
+// frontEnd is the front-end side of a session.
+type frontEnd struct {
+	t *testing.T
+
+	// conn is the connection to the stub.
+	conn net.Conn
+
+	// r buffers reads from conn.
+	r *bufio.Reader
+}
+
+// attach serves a debugger of a program adding 1 to R1 and halting,
+// and returns a front-end connected to it.
+func attach(t *testing.T) *frontEnd {
+	t.Helper()
+
+	var image [cpu.MemoryMax]uint16
+	image[0x3000] = 0x1261
+	image[0x3001] = 0xF025
+
+	newCPU := func() cpu.CPU {
+		return cpu.NewCPU(cpu.WithOutput(io.Discard))
+	}
+
+	dbg := debugger.New(newCPU, image, nil, io.Discard)
+
+	stub, conn := net.Pipe()
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	go func() {
+		defer stub.Close()
+		Serve(stub, dbg)
+	}()
+
+	return &frontEnd{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// write writes raw bytes to the stub.
+func (f *frontEnd) write(data string) {
+	f.t.Helper()
+
+	if _, err := io.WriteString(f.conn, data); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// ack reads an acknowledgement, reporting whether it was positive.
+func (f *frontEnd) ack() bool {
+	f.t.Helper()
+
+	b, err := f.r.ReadByte()
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	return b == '+'
+}
+
+// reply reads a reply packet and checks its checksum.
+func (f *frontEnd) reply() string {
+	f.t.Helper()
+
+	if b, err := f.r.ReadByte(); err != nil || b != '$' {
+		f.t.Fatalf("read %q: %v", b, err)
+	}
+
+	data, ok, err := readPacket(f.r)
+	if err != nil || !ok {
+		f.t.Fatalf("reply %q: %v", data, err)
+	}
+
+	return data
+}
+
+// exchange sends a packet and returns the reply.
+func (f *frontEnd) exchange(packet string) string {
+	f.t.Helper()
+
+	data := escape(packet)
+	f.write(fmt.Sprintf("$%s#%02x", data, checksum(data)))
+
+	if !f.ack() {
+		f.t.Fatalf("%s refused", packet)
+	}
+
+	return f.reply()
+}
+
+// TestPackets checks the replies to well-formed and malformed packets.
+func TestPackets(t *testing.T) {
+	tests := []struct {
+		packet string
+		reply  string
+	}{
+		{"qSupported:multiprocess+", "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+"},
+		{"?", "S05"},
+		{"p8", "3000"},
+		{"p1f", "E01"},
+		{"pzz", "E01"},
+		{"m3000,4", "1261f025"},
+		{"m3000", "E01"},
+		{"mzz,2", "E01"},
+		{"m3000,100000000", "E01"},
+		{"M3000,2:zz", "E01"},
+		{"M3000,4:12", "E01"},
+		{"G12", "E01"},
+		{"P8=123", "E01"},
+		{"z0,3000,2", "E01"},
+		{"Z0,3000", "E01"},
+		{"Z9,3000,2", ""},
+		{"vCont;", "E01"},
+		{"vCont;x", ""},
+		{"vFoo", ""},
+		{"qXfer:features:read:other.xml:0,10", "E00"},
+		{"cq", "E01"},
+		{"!", ""},
+		{"", ""},
+	}
+
+	f := attach(t)
+
+	for _, test := range tests {
+		if reply := f.exchange(test.packet); reply != test.reply {
+			t.Errorf("%q: reply %q, expected %q", test.packet, reply, test.reply)
+		}
+	}
+
+	if reply := f.exchange("vCont;s"); reply != "T0508:3001;" {
+		t.Errorf("step: reply %q", reply)
+	}
+}
+
+// TestReadMemoryLimit checks that memory reads are cut to fit in a
+// packet.
+func TestReadMemoryLimit(t *testing.T) {
+	f := attach(t)
+
+	reply := f.exchange("m0,ffffffff")
+
+	if len(reply) != packetSize {
+		t.Errorf("%d characters, expected %d", len(reply), packetSize)
+	}
+}
+
+// TestChecksum checks that packets with bad checksums are refused and
+// not executed, and that the stub carries on after them.
+func TestChecksum(t *testing.T) {
+	f := attach(t)
+
+	for _, packet := range []string{"$k#00", "$k#zz"} {
+		f.write(packet)
+
+		if f.ack() {
+			t.Errorf("%s acknowledged", packet)
+		}
+	}
+
+	if reply := f.exchange("p8"); reply != "3000" {
+		t.Errorf("reply %q after bad checksums", reply)
+	}
+}
+
+// TestNoAckMode checks that acknowledgements stop once turned off.
+func TestNoAckMode(t *testing.T) {
+	f := attach(t)
+
+	if reply := f.exchange("QStartNoAckMode"); reply != "OK" {
+		t.Fatalf("reply %q", reply)
+	}
+
+	f.write("$?#3f")
+
+	if reply := f.reply(); reply != "S05" {
+		t.Errorf("reply %q", reply)
+	}
+}
+
+// TestDetach checks that the session ends when the front-end detaches.
+func TestDetach(t *testing.T) {
+	f := attach(t)
+
+	if reply := f.exchange("D"); reply != "OK" {
+		t.Fatalf("reply %q", reply)
+	}
+
+	if _, err := f.r.ReadByte(); err != io.EOF {
+		t.Errorf("read after detaching: %v", err)
+	}
+}
+
+// TestUnescape checks that escaped bytes in packets are decoded.
+func TestUnescape(t *testing.T) {
+	for _, data := range []string{"a#b", "$}*", "plain"} {
+		if got := unescape(escape(data)); got != data {
+			t.Errorf("%q, expected %q", got, data)
+		}
+	}
+}
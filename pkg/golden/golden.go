@@ -0,0 +1,406 @@
+package golden
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"lc3/pkg/devices"
+	"lc3/pkg/inputgen"
+	"lc3/pkg/profile"
+	"lc3/pkg/registers"
+	"lc3/pkg/stuck"
+	"lc3/pkg/symbols"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Result is the outcome of running a spec.
+type Result struct {
+	// Spec is the spec that was run.
+	Spec *Spec
+
+	// Output is the console output of the program.
+	Output string
+
+	// Expected is the expected console output, from the spec or its
+	// reference solution, nil if it is not checked.
+	Expected *string
+
+	// Instructions counts the instructions the program ran.
+	Instructions uint64
+
+	// Halted reports whether the program halted.
+	Halted bool
+
+	// Failures describe the expectations that were not met, in the
+	// order of the spec.
+	Failures []string
+
+	// Execution is the run of the program.
+	Execution *Execution
+}
+
+// Passed reports whether every expectation was met.
+func (r *Result) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// Name names the spec, with the seed of its input if it was
+// generated.
+func (r *Result) Name() string {
+	if r.Spec.Template != nil {
+		return fmt.Sprintf("%s seed %d", r.Spec.Name, r.Spec.Seed)
+	}
+
+	return r.Spec.Name
+}
+
+// Write writes a line saying whether the spec passed, followed by its
+// failures indented.
+func (r *Result) Write(w io.Writer) error {
+	status := "PASS"
+	if !r.Passed() {
+		status = "FAIL"
+	}
+
+	if _, err := fmt.Fprintf(w, "%s %s (%d instructions)\n", status, r.Name(), r.Instructions); err != nil {
+		return err
+	}
+
+	for _, failure := range r.Failures {
+		if _, err := fmt.Fprintf(w, "\t%s\n", strings.ReplaceAll(failure, "\n", "\n\t")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadObject loads the program filename, assembling it if it is an
+// .asm source, and returns it along with its symbols. The symbols of
+// an .obj image are read from the .sym file next to it, if there is
+// one, and it has no assertions.
+func LoadObject(filename string) (*asm.Object, *symbols.Table, error) {
+	if strings.EqualFold(filepath.Ext(filename), ".asm") {
+		obj, table, diagnostics, err := asm.AssembleFile(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(diagnostics) > 0 {
+			return nil, nil, diagnostics
+		}
+
+		return obj, table, nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	obj, err := asm.ReadObject(file)
+	file.Close()
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	var table *symbols.Table
+
+	sym := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".sym"
+	if _, err := os.Stat(sym); err == nil {
+		if table, err = symbols.Load(sym); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return obj, table, nil
+}
+
+// LoadProgram loads the program filename like LoadObject, and returns
+// its memory image along with its symbols and the assertions of its
+// ASSERT comments.
+func LoadProgram(filename string) ([cpu.MemoryMax]uint16, *symbols.Table, []asm.Assertion, error) {
+	var image [cpu.MemoryMax]uint16
+
+	obj, table, err := LoadObject(filename)
+	if err != nil {
+		return image, nil, nil, err
+	}
+
+	copy(image[obj.Origin:], obj.Words)
+
+	return image, table, obj.Assertions, nil
+}
+
+// Execution is a finished run of a program.
+type Execution struct {
+	// CPU is the CPU the program ran on, left as the program left it.
+	CPU cpu.CPU
+
+	// Symbols are the symbols of the program, nil if it has none.
+	Symbols *symbols.Table
+
+	// Object is the program.
+	Object *asm.Object
+
+	// Profile counts the times each instruction ran.
+	Profile *profile.Profile
+
+	// Output is the console output of the program.
+	Output string
+
+	// Instructions counts the instructions the program ran.
+	Instructions uint64
+
+	// Halted reports whether the program halted.
+	Halted bool
+
+	// Err is the error that stopped the program, if any. It is io.EOF
+	// if the program read more input than it was given.
+	Err error
+
+	// Loop is the loop the program was stopped in, if it got stuck in
+	// one.
+	Loop *stuck.Loop
+
+	// pc is the address of the last instruction run.
+	pc uint16
+}
+
+// Execute loads the program filename, sets the cells of setup and runs
+// it with the keystrokes of input until it halts, fails, gets stuck in
+// a loop or has run limit instructions. An error is returned only if
+// the program cannot be loaded or set up.
+func Execute(filename string, input []devices.KeyEvent, setup []Cell, limit uint64) (*Execution, error) {
+	obj, table, err := LoadObject(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var image [cpu.MemoryMax]uint16
+	copy(image[obj.Origin:], obj.Words)
+
+	for _, cell := range setup {
+		address, err := ResolveAddress(cell.Address, table)
+		if err != nil {
+			return nil, err
+		}
+
+		image[address+cell.Offset] = cell.Value
+	}
+
+	var out bytes.Buffer
+
+	// the clock and the random numbers are virtual and seeded, so that
+	// every run of a program gives the same result.
+	clock := devices.NewClock(devices.ClockEpoch, time.Microsecond)
+	keyboard := devices.NewScriptedKeyboard(append([]devices.KeyEvent(nil), input...), clock.Now)
+
+	c := cpu.NewCPU(
+		cpu.WithDevice(clock),
+		cpu.WithDevice(devices.NewRTC(clock.Now)),
+		cpu.WithDevice(devices.NewRNG(0)),
+		cpu.WithDevice(devices.NewTerminal(&out)),
+		cpu.WithDevice(keyboard),
+		cpu.WithInput(keyboard.Input()),
+		cpu.WithOutput(&out),
+	)
+
+	c.Load(image)
+
+	detector := stuck.New()
+	detector.Record(c)
+
+	e := &Execution{CPU: c, Symbols: table, Object: obj, Profile: profile.New()}
+	e.Profile.Record(c)
+
+	for !c.Halted() && e.Instructions < limit {
+		e.pc = c.Register(registers.RPC)
+
+		if e.Err = c.Execute(); e.Err != nil {
+			break
+		}
+
+		e.Instructions++
+
+		if e.Loop = detector.Loop(); e.Loop != nil {
+			break
+		}
+	}
+
+	e.Output = out.String()
+	e.Halted = c.Halted()
+
+	return e, nil
+}
+
+// Failure describes why an execution stopped without halting, or
+// returns the empty string if it halted.
+func (e *Execution) Failure(limit uint64) string {
+	switch {
+	case e.Halted:
+		return ""
+	case e.Err == io.EOF:
+		return fmt.Sprintf("ran out of input at x%04X", e.pc)
+	case e.Err != nil:
+		return fmt.Sprintf("stopped at x%04X: %v", e.pc, e.Err)
+	case e.Loop != nil:
+		return strings.Join(append([]string{e.Loop.Error() + ":"}, e.Loop.Lines(e.Symbols)...), "\n\t")
+	default:
+		return fmt.Sprintf("did not halt within %d instructions", limit)
+	}
+}
+
+// FailedAssertions checks the assertions of the program once it has
+// halted, describing those that do not hold. Nothing is checked if the
+// program did not halt.
+func (e *Execution) FailedAssertions() []string {
+	if !e.Halted {
+		return nil
+	}
+
+	var failures []string
+
+	for _, assertion := range e.Object.Assertions {
+		got := e.CPU.Register(assertion.Register)
+		if assertion.Memory {
+			got = e.CPU.PeekMemory(assertion.Address)
+		}
+
+		if !assertion.Holds(got) {
+			failures = append(failures, fmt.Sprintf("%s: %s is x%04X", assertion, assertion.Target(), got))
+		}
+	}
+
+	return failures
+}
+
+// Run runs the program of a spec with its input and checks what it
+// leaves behind, along with the assertions of the program. An error is
+// returned if the program cannot be loaded, the spec refers to labels
+// it does not have or its reference solution fails. A program that
+// runs but fails its expectations is reported in the result.
+func Run(spec *Spec) (*Result, error) {
+	input, setup, generated := spec.inputs()
+
+	e, err := Execute(spec.Program, input, setup, spec.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	want := spec.Output
+
+	if want == nil && spec.Reference != "" {
+		reference, err := Execute(spec.Reference, input, setup, spec.Limit)
+		if err != nil {
+			return nil, fmt.Errorf("reference: %w", err)
+		}
+
+		if failure := reference.Failure(spec.Limit); failure != "" {
+			return nil, fmt.Errorf("reference %s %s", spec.Reference, failure)
+		}
+
+		want = &reference.Output
+	}
+
+	result := &Result{
+		Spec:         spec,
+		Output:       e.Output,
+		Expected:     want,
+		Instructions: e.Instructions,
+		Halted:       e.Halted,
+		Execution:    e,
+	}
+
+	if failure := e.Failure(spec.Limit); failure != "" {
+		result.Failures = append(result.Failures, failure)
+	}
+
+	result.Failures = append(result.Failures, e.FailedAssertions()...)
+
+	if want != nil && *want != result.Output {
+		result.Failures = append(result.Failures, fmt.Sprintf("output: expected %q, got %q", *want, result.Output))
+	}
+
+	for _, r := range spec.Registers {
+		if got := e.CPU.Register(r.Register); got != r.Value {
+			result.Failures = append(result.Failures, fmt.Sprintf("%s: expected x%04X, got x%04X", RegisterName(r.Register), r.Value, got))
+		}
+	}
+
+	for _, cell := range spec.Memory {
+		address, err := ResolveAddress(cell.Address, e.Symbols)
+		if err != nil {
+			return nil, err
+		}
+
+		if got := e.CPU.PeekMemory(address + cell.Offset); got != cell.Value {
+			name := cell.Address
+			if cell.Offset > 0 {
+				name = fmt.Sprintf("%s+%d", name, cell.Offset)
+			}
+
+			result.Failures = append(result.Failures, fmt.Sprintf("memory %s: expected x%04X, got x%04X", name, cell.Value, got))
+		}
+	}
+
+	if generated != nil && !result.Passed() {
+		var lines strings.Builder
+		generated.Write(&lines)
+
+		result.Failures = append(result.Failures, "generated input:\n"+strings.TrimSuffix(lines.String(), "\n"))
+	}
+
+	return result, nil
+}
+
+// inputs returns the keystrokes typed into the program of the spec and
+// the cells set before it runs, including those generated from its
+// seed, along with the generated input if there is any.
+func (s *Spec) inputs() ([]devices.KeyEvent, []Cell, *inputgen.Input) {
+	if s.Template == nil {
+		return s.Input, s.Setup, nil
+	}
+
+	generated := s.Template.Generate(s.Seed)
+
+	input := append([]devices.KeyEvent(nil), s.Input...)
+	for i := 0; i < len(generated.Stdin); i++ {
+		input = append(input, devices.KeyEvent{Key: generated.Stdin[i]})
+	}
+
+	setup := append([]Cell(nil), s.Setup...)
+	for _, word := range generated.Memory {
+		setup = append(setup, Cell{Address: word.Address, Offset: word.Offset, Value: word.Value})
+	}
+
+	return input, setup, generated
+}
+
+// ResolveAddress resolves an address written in hex as x4000 or as a
+// label of table.
+func ResolveAddress(s string, table *symbols.Table) (uint16, error) {
+	if strings.HasPrefix(s, "x") || strings.HasPrefix(s, "X") {
+		if address, err := ParseValue(s); err == nil {
+			return address, nil
+		}
+	}
+
+	if address, ok := table.Address(s); ok {
+		return address, nil
+	}
+
+	return 0, fmt.Errorf("%s is neither an address nor a label", s)
+}
+
+// RegisterName names a register as in a spec, R0 to R7 or PC, or CC
+// for the condition codes.
+func RegisterName(r uint16) string {
+	return registers.Name(r)
+}
@@ -0,0 +1,325 @@
+// Package golden runs LC3 programs against golden expectations. A
+// spec file names a program, scripts the input typed into it and
+// gives the output, registers and memory it must leave when it halts.
+package golden
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lc3/pkg/devices"
+	"lc3/pkg/inputgen"
+	"lc3/pkg/registers"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultLimit is the number of instructions a program may run
+// before it is stopped, unless its spec gives another limit.
+const DefaultLimit = 1_000_000
+
+// Spec describes a run of a program and what it must leave behind.
+type Spec struct {
+	// Name names the spec in reports, by default its file name.
+	Name string
+
+	// Program is the path of the program, an .asm source or an .obj
+	// image. A relative path is relative to the directory of the
+	// spec file.
+	Program string
+
+	// Input are the keystrokes typed into the console.
+	Input []devices.KeyEvent
+
+	// Setup are the memory cells set before the program runs.
+	Setup []Cell
+
+	// Template generates random input typed after Input and memory set
+	// after Setup, nil if the input is fixed.
+	Template *inputgen.Template
+
+	// Seed seeds the input generated by Template.
+	Seed int64
+
+	// Reference is the path of a reference solution, run on the same
+	// input to give the expected output unless Output is set.
+	Reference string
+
+	// Output is the expected console output, nil if it is not
+	// checked.
+	Output *string
+
+	// Registers are the expected final values of registers.
+	Registers []Register
+
+	// Memory are the expected final values of memory cells.
+	Memory []Cell
+
+	// Limit is the number of instructions the program may run before
+	// it is stopped.
+	Limit uint64
+}
+
+// Register is the expected final value of a register.
+type Register struct {
+	// Register is the index of the register, R0 to R7 or PC.
+	Register uint16
+
+	// Value is the expected value.
+	Value uint16
+}
+
+// Cell is the value of a memory cell, set before a run or expected
+// after it.
+type Cell struct {
+	// Address is the address of the cell, in hex as x4000 or a
+	// label resolved against the symbols of the program.
+	Address string
+
+	// Offset is added to the address, for the words following a
+	// label.
+	Offset uint16
+
+	// Value is the value of the cell.
+	Value uint16
+}
+
+// LookupRegister returns the index of a register named R0 to R7 or
+// PC, ignoring case.
+func LookupRegister(name string) (uint16, bool) {
+	r, ok := registers.Lookup(name)
+	return r, ok && r != registers.RCOND
+}
+
+// Load reads the spec file filename, resolving the program against
+// its directory.
+func Load(filename string) (*Spec, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	spec, err := parse(file, filepath.Dir(filename))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if spec.Name == "" {
+		spec.Name = filepath.Base(filename)
+	}
+
+	return spec, nil
+}
+
+// Parse parses a spec. Every line holds one directive:
+//
+//	# sums the numbers typed in
+//	program sum.asm
+//	input "3 4\n"
+//	output "7\n"
+//	register R0 #7
+//	memory RESULT x0007
+//	memory x4000 1 2 3
+//	limit 10000
+//
+// Input and output lines are quoted strings that are joined in
+// order, and "keys" reads the input from a key script file instead.
+// A memory line with several values checks the words from the address
+// on, and a set line sets them before the program runs. Values are
+// written in hex as x1F or decimal as #-3 or -3.
+//
+// "generate" adds random input from an inputgen template, seeded by
+// "seed", and "reference" names a program whose output on the same
+// input is expected.
+func Parse(r io.Reader) (*Spec, error) {
+	return parse(r, "")
+}
+
+// parse parses a spec, resolving relative paths against dir.
+func parse(r io.Reader, dir string) (*Spec, error) {
+	spec := &Spec{Limit: DefaultLimit}
+
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if err := spec.parseLine(text, dir); err != nil {
+			return nil, fmt.Errorf("spec line %d: %w", line, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if spec.Program == "" {
+		return nil, fmt.Errorf("spec names no program")
+	}
+
+	return spec, nil
+}
+
+// parseLine parses a single line of a spec, resolving relative paths
+// against dir.
+func (s *Spec) parseLine(text, dir string) error {
+	directive, arg, _ := strings.Cut(text, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch directive {
+	case "name":
+		s.Name = arg
+	case "program":
+		s.Program = resolve(dir, arg)
+	case "input":
+		str, err := strconv.Unquote(arg)
+		if err != nil {
+			return fmt.Errorf("invalid string %s", arg)
+		}
+
+		for i := 0; i < len(str); i++ {
+			s.Input = append(s.Input, devices.KeyEvent{Key: str[i]})
+		}
+	case "keys":
+		file, err := os.Open(resolve(dir, arg))
+		if err != nil {
+			return err
+		}
+
+		events, err := devices.ParseKeyScript(file)
+		file.Close()
+
+		if err != nil {
+			return err
+		}
+
+		s.Input = append(s.Input, events...)
+	case "output":
+		str, err := strconv.Unquote(arg)
+		if err != nil {
+			return fmt.Errorf("invalid string %s", arg)
+		}
+
+		if s.Output == nil {
+			s.Output = new(string)
+		}
+
+		*s.Output += str
+	case "register":
+		fields := strings.Fields(arg)
+		if len(fields) != 2 {
+			return fmt.Errorf("expected a register and a value")
+		}
+
+		r, ok := LookupRegister(fields[0])
+		if !ok {
+			return fmt.Errorf("unknown register %s", fields[0])
+		}
+
+		value, err := ParseValue(fields[1])
+		if err != nil {
+			return err
+		}
+
+		s.Registers = append(s.Registers, Register{Register: r, Value: value})
+	case "memory":
+		cells, err := parseCells(arg)
+		if err != nil {
+			return err
+		}
+
+		s.Memory = append(s.Memory, cells...)
+	case "set":
+		cells, err := parseCells(arg)
+		if err != nil {
+			return err
+		}
+
+		s.Setup = append(s.Setup, cells...)
+	case "generate":
+		t, err := inputgen.Load(resolve(dir, arg))
+		if err != nil {
+			return err
+		}
+
+		s.Template = t
+	case "seed":
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid seed %q", arg)
+		}
+
+		s.Seed = n
+	case "reference":
+		s.Reference = resolve(dir, arg)
+	case "limit":
+		n, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil || n == 0 {
+			return fmt.Errorf("invalid limit %q", arg)
+		}
+
+		s.Limit = n
+	default:
+		return fmt.Errorf("unknown directive %q", directive)
+	}
+
+	return nil
+}
+
+// parseCells parses an address followed by the values of the words
+// from it on.
+func parseCells(arg string) ([]Cell, error) {
+	fields := strings.Fields(arg)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("expected an address and values")
+	}
+
+	var cells []Cell
+
+	for i, field := range fields[1:] {
+		value, err := ParseValue(field)
+		if err != nil {
+			return nil, err
+		}
+
+		cells = append(cells, Cell{Address: fields[0], Offset: uint16(i), Value: value})
+	}
+
+	return cells, nil
+}
+
+// parseValue parses a word written in hex as x1F or in decimal as #-3
+// or -3.
+func ParseValue(s string) (uint16, error) {
+	if rest, ok := strings.CutPrefix(strings.ToLower(s), "x"); ok {
+		n, err := strconv.ParseUint(rest, 16, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value %q", s)
+		}
+
+		return uint16(n), nil
+	}
+
+	n, err := strconv.ParseInt(strings.TrimPrefix(s, "#"), 10, 32)
+	if err != nil || n < -0x8000 || n > 0xFFFF {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+
+	return uint16(n), nil
+}
+
+// resolve resolves a relative path against dir.
+func resolve(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(dir, path)
+}
@@ -0,0 +1,160 @@
+package grade
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"lc3/pkg/golden"
+)
+
+// Report is the score of a program on a rubric.
+type Report struct {
+	// Assignment is the name of the rubric.
+	Assignment string `json:"assignment,omitempty"`
+
+	// Program is the path of the program graded.
+	Program string `json:"program"`
+
+	// Score is the sum of the points awarded.
+	Score float64 `json:"score"`
+
+	// Total is the sum of the points of every criterion.
+	Total float64 `json:"total"`
+
+	// Halted reports whether the program halted.
+	Halted bool `json:"halted"`
+
+	// Instructions counts the instructions the program ran.
+	Instructions uint64 `json:"instructions"`
+
+	// Error says why the program did not run or halt, if it did not.
+	Error string `json:"error,omitempty"`
+
+	// Criteria are the scores on each criterion, in the order of the
+	// rubric.
+	Criteria []Score `json:"criteria"`
+
+	// Execution is the run of the program, nil if it did not run.
+	Execution *golden.Execution `json:"-"`
+}
+
+// Score is the score of a program on a single criterion.
+type Score struct {
+	// Name is the name of the criterion.
+	Name string `json:"name"`
+
+	// Points are the points the criterion is worth.
+	Points float64 `json:"points"`
+
+	// Score are the points awarded.
+	Score float64 `json:"score"`
+
+	// Passed reports whether the check passed.
+	Passed bool `json:"passed"`
+
+	// Message says why the check failed.
+	Message string `json:"message,omitempty"`
+}
+
+// Grade runs the program filename with the input of the rubric and
+// scores what it leaves behind on every criterion. A program that does
+// not load scores nothing, with the reason given in the report.
+func Grade(rubric *Rubric, filename string) *Report {
+	report := newReport(rubric, filename)
+
+	e, err := golden.Execute(filename, rubric.keys(), nil, rubric.Limit)
+	if err != nil {
+		report.fail(err.Error())
+		return report
+	}
+
+	report.Execution = e
+	report.Halted = e.Halted
+	report.Instructions = e.Instructions
+	report.Error = e.Failure(rubric.Limit)
+
+	for i := range rubric.Criteria {
+		score := &report.Criteria[i]
+
+		score.Message = rubric.Criteria[i].evaluate(e)
+		if score.Message == "" {
+			score.Passed = true
+			score.Score = score.Points
+			report.Score += score.Points
+		}
+	}
+
+	return report
+}
+
+// newReport creates a report of a program scoring nothing yet.
+func newReport(rubric *Rubric, filename string) *Report {
+	report := &Report{
+		Assignment: rubric.Name,
+		Program:    filename,
+		Criteria:   make([]Score, len(rubric.Criteria)),
+	}
+
+	for i, c := range rubric.Criteria {
+		report.Criteria[i] = Score{Name: c.Name, Points: c.Points}
+		report.Total += c.Points
+	}
+
+	return report
+}
+
+// fail records that the program did not run, and why.
+func (r *Report) fail(reason string) {
+	r.Error = reason
+
+	for i := range r.Criteria {
+		r.Criteria[i].Message = "the program did not run"
+	}
+}
+
+// evaluate checks a criterion against an execution, returning why it
+// failed or the empty string if it passed.
+func (c *Criterion) evaluate(e *golden.Execution) string {
+	switch {
+	case c.Register != "":
+		r, _ := golden.LookupRegister(c.Register)
+
+		if got := e.CPU.Register(r); got != uint16(*c.Value) {
+			return fmt.Sprintf("%s: expected x%04X, got x%04X", golden.RegisterName(r), uint16(*c.Value), got)
+		}
+	case c.Memory != "":
+		address, err := golden.ResolveAddress(c.Memory, e.Symbols)
+		if err != nil {
+			return err.Error()
+		}
+
+		for i, want := range c.Values {
+			if got := e.CPU.PeekMemory(address + uint16(i)); got != uint16(want) {
+				return fmt.Sprintf("x%04X: expected x%04X, got x%04X", address+uint16(i), uint16(want), got)
+			}
+		}
+	case c.output != nil:
+		if !c.output.MatchString(e.Output) {
+			return fmt.Sprintf("output %q does not match %q", e.Output, c.Output)
+		}
+	case c.MaxInstructions > 0:
+		if !e.Halted {
+			return "the program did not halt"
+		}
+
+		if e.Instructions > c.MaxInstructions {
+			return fmt.Sprintf("ran %d instructions, more than %d", e.Instructions, c.MaxInstructions)
+		}
+	case c.Halts:
+		if !e.Halted {
+			return "the program did not halt"
+		}
+	}
+
+	return ""
+}
+
+// WriteJSON writes the report as a line of JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
@@ -0,0 +1,71 @@
+package grade
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// GradeAll grades programs on a pool of workers, each program on a CPU
+// of its own, calling fn with the reports in the order of filenames as
+// soon as each report and those before it are ready. With workers 0 or
+// less, there is a worker per host CPU. It stops at the first error fn
+// returns.
+func GradeAll(rubric *Rubric, filenames []string, workers int, fn func(*Report) error) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	// every program has a channel of its own, buffered so that workers
+	// never wait for the reports before it to be taken.
+	reports := make([]chan *Report, len(filenames))
+	for i := range reports {
+		reports[i] = make(chan *Report, 1)
+	}
+
+	jobs := make(chan int)
+	stop := make(chan struct{})
+
+	defer close(stop)
+
+	go func() {
+		defer close(jobs)
+
+		for i := range filenames {
+			select {
+			case jobs <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				reports[i] <- safeGrade(rubric, filenames[i])
+			}
+		}()
+	}
+
+	for _, report := range reports {
+		if err := fn(<-report); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// safeGrade grades a program like Grade, turning a panic into a
+// report of a program that did not run, so that one submission cannot
+// bring down the grading of the others.
+func safeGrade(rubric *Rubric, filename string) (report *Report) {
+	defer func() {
+		if v := recover(); v != nil {
+			report = newReport(rubric, filename)
+			report.fail(fmt.Sprintf("grading panicked: %v", v))
+		}
+	}()
+
+	return Grade(rubric, filename)
+}
@@ -0,0 +1,213 @@
+// Package grade scores LC3 programs against a rubric: the input to
+// type into a program and the criteria its final registers, memory,
+// output and instruction count are graded on.
+package grade
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"lc3/pkg/devices"
+	"lc3/pkg/golden"
+	"os"
+	"regexp"
+)
+
+// Rubric describes how programs are graded.
+type Rubric struct {
+	// Name names the assignment in reports.
+	Name string `json:"name,omitempty"`
+
+	// Input is typed into the console of every program.
+	Input string `json:"input,omitempty"`
+
+	// Limit is the number of instructions a program may run before
+	// it is stopped, golden.DefaultLimit if zero.
+	Limit uint64 `json:"limit,omitempty"`
+
+	// Criteria are what programs are scored on.
+	Criteria []Criterion `json:"criteria"`
+}
+
+// Criterion is a single check worth a number of points. Exactly one
+// of Register, Memory, Output, MaxInstructions and Halts is set.
+type Criterion struct {
+	// Name names the criterion in reports.
+	Name string `json:"name"`
+
+	// Points are awarded if the check passes.
+	Points float64 `json:"points"`
+
+	// Register names a register, R0 to R7 or PC, that must hold
+	// Value.
+	Register string `json:"register,omitempty"`
+
+	// Value is the expected value of Register.
+	Value *Word `json:"value,omitempty"`
+
+	// Memory is the address or label of the first of the words that
+	// must hold Values.
+	Memory string `json:"memory,omitempty"`
+
+	// Values are the expected values of the words from Memory on.
+	Values []Word `json:"values,omitempty"`
+
+	// Output is a regular expression the console output must match.
+	Output string `json:"output,omitempty"`
+
+	// MaxInstructions is the most instructions the program may run.
+	MaxInstructions uint64 `json:"maxInstructions,omitempty"`
+
+	// Halts requires the program to halt.
+	Halts bool `json:"halts,omitempty"`
+
+	// output is Output compiled.
+	output *regexp.Regexp
+}
+
+// Word is a word in a rubric, written as a JSON number or as a string
+// in hex as "x1F" or in decimal as "#-3".
+type Word uint16
+
+// UnmarshalJSON reads a word from a number or a string.
+func (w *Word) UnmarshalJSON(data []byte) error {
+	var n int32
+	if err := json.Unmarshal(data, &n); err == nil {
+		if n < -0x8000 || n > 0xFFFF {
+			return fmt.Errorf("value %d does not fit in a word", n)
+		}
+
+		*w = Word(n)
+
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid value %s", data)
+	}
+
+	value, err := golden.ParseValue(s)
+	if err != nil {
+		return err
+	}
+
+	*w = Word(value)
+
+	return nil
+}
+
+// Load reads the rubric file filename.
+func Load(filename string) (*Rubric, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	rubric, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return rubric, nil
+}
+
+// Parse reads a rubric in JSON:
+//
+//	{
+//	  "name": "Lab 3",
+//	  "input": "3 4\n",
+//	  "criteria": [
+//	    {"name": "sum in R0", "points": 2, "register": "R0", "value": 7},
+//	    {"name": "sorted", "points": 3, "memory": "ARRAY", "values": [1, 2, "x0003"]},
+//	    {"name": "prints sum", "points": 2, "output": "Sum: 7\n$"},
+//	    {"name": "fast", "points": 1, "maxInstructions": 500},
+//	    {"name": "halts", "points": 1, "halts": true}
+//	  ]
+//	}
+func Parse(r io.Reader) (*Rubric, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	rubric := &Rubric{}
+	if err := decoder.Decode(rubric); err != nil {
+		return nil, err
+	}
+
+	if rubric.Limit == 0 {
+		rubric.Limit = golden.DefaultLimit
+	}
+
+	for i := range rubric.Criteria {
+		if err := rubric.Criteria[i].check(); err != nil {
+			return nil, fmt.Errorf("criterion %d: %w", i+1, err)
+		}
+	}
+
+	return rubric, nil
+}
+
+// check validates a criterion and compiles its output expression.
+func (c *Criterion) check() error {
+	kinds := 0
+
+	if c.Register != "" {
+		if _, ok := golden.LookupRegister(c.Register); !ok {
+			return fmt.Errorf("unknown register %s", c.Register)
+		}
+
+		if c.Value == nil {
+			return fmt.Errorf("register %s has no value", c.Register)
+		}
+
+		kinds++
+	}
+
+	if c.Memory != "" {
+		if len(c.Values) == 0 {
+			return fmt.Errorf("memory %s has no values", c.Memory)
+		}
+
+		kinds++
+	}
+
+	if c.Output != "" {
+		output, err := regexp.Compile(c.Output)
+		if err != nil {
+			return err
+		}
+
+		c.output = output
+		kinds++
+	}
+
+	if c.MaxInstructions > 0 {
+		kinds++
+	}
+
+	if c.Halts {
+		kinds++
+	}
+
+	if kinds != 1 {
+		return fmt.Errorf("expected exactly one of register, memory, output, maxInstructions and halts")
+	}
+
+	if c.Name == "" {
+		return fmt.Errorf("criterion has no name")
+	}
+
+	return nil
+}
+
+// keys returns the input of the rubric as keystrokes.
+func (r *Rubric) keys() []devices.KeyEvent {
+	events := make([]devices.KeyEvent, len(r.Input))
+	for i := range events {
+		events[i] = devices.KeyEvent{Key: r.Input[i]}
+	}
+
+	return events
+}
@@ -0,0 +1,112 @@
+package grade
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Summary aggregates the reports of a class.
+type Summary struct {
+	// Assignment is the name of the rubric.
+	Assignment string
+
+	// Programs counts the programs graded.
+	Programs int
+
+	// Halted counts the programs that halted.
+	Halted int
+
+	// Total is the points a program can score.
+	Total float64
+
+	// Mean, Median, Min and Max describe the scores.
+	Mean, Median, Min, Max float64
+
+	// Criteria are the criteria with how many programs passed them,
+	// in the order of the rubric.
+	Criteria []CriterionSummary
+}
+
+// CriterionSummary is how a class did on a criterion.
+type CriterionSummary struct {
+	// Name is the name of the criterion.
+	Name string
+
+	// Points are the points the criterion is worth.
+	Points float64
+
+	// Passed counts the programs that passed it.
+	Passed int
+}
+
+// Summarize aggregates reports on the same rubric.
+func Summarize(reports []*Report) *Summary {
+	s := &Summary{Programs: len(reports)}
+	if len(reports) == 0 {
+		return s
+	}
+
+	s.Assignment = reports[0].Assignment
+	s.Total = reports[0].Total
+
+	for _, c := range reports[0].Criteria {
+		s.Criteria = append(s.Criteria, CriterionSummary{Name: c.Name, Points: c.Points})
+	}
+
+	scores := make([]float64, 0, len(reports))
+
+	for _, r := range reports {
+		scores = append(scores, r.Score)
+		s.Mean += r.Score
+
+		if r.Halted {
+			s.Halted++
+		}
+
+		for i, c := range r.Criteria {
+			if c.Passed && i < len(s.Criteria) {
+				s.Criteria[i].Passed++
+			}
+		}
+	}
+
+	sort.Float64s(scores)
+
+	s.Mean /= float64(len(scores))
+	s.Min, s.Max = scores[0], scores[len(scores)-1]
+
+	if n := len(scores); n%2 == 1 {
+		s.Median = scores[n/2]
+	} else {
+		s.Median = (scores[n/2-1] + scores[n/2]) / 2
+	}
+
+	return s
+}
+
+// Write writes the summary as text, the scores followed by a line per
+// criterion with the share of programs that passed it.
+func (s *Summary) Write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%s: %d programs, %d halted\n", s.Assignment, s.Programs, s.Halted); err != nil {
+		return err
+	}
+
+	if s.Programs == 0 {
+		return nil
+	}
+
+	if _, err := fmt.Fprintf(w, "scores out of %g: mean %.2f, median %g, min %g, max %g\n", s.Total, s.Mean, s.Median, s.Min, s.Max); err != nil {
+		return err
+	}
+
+	for _, c := range s.Criteria {
+		share := 100 * float64(c.Passed) / float64(s.Programs)
+
+		if _, err := fmt.Fprintf(w, "%6.1f%%  %d/%d  %s (%g points)\n", share, c.Passed, s.Programs, c.Name, c.Points); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
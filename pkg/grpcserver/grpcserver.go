@@ -0,0 +1,619 @@
+// Package grpcserver serves the Machine service of proto/machine.proto
+// over gRPC, giving IDE plugins and grading infrastructure a strongly
+// typed remote interface to the VM: loading programs, running and
+// stepping them, breakpoints, inspecting and changing their state and
+// streaming traces.
+//
+// The service is served by an http.Handler speaking gRPC over HTTP/2,
+// which net/http only offers over TLS. Messages are encoded with
+// lc3/pkg/protowire, compressed messages are not supported.
+package grpcserver
+
+import (
+	"bytes"
+	"io"
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"lc3/pkg/debugger"
+	"lc3/pkg/devices"
+	"lc3/pkg/protowire"
+	"lc3/pkg/registers"
+	"lc3/pkg/state"
+	"lc3/pkg/trace"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// service is the name of the service in the paths of its methods.
+const service = "/lc3.Machine/"
+
+// The fields of the messages of proto/machine.proto.
+const (
+	loadSource = 1
+	loadObject = 2
+
+	loadDiagnostics = 1
+	loadOrigin      = 2
+
+	stepCount = 1
+
+	stopReason     = 1
+	stopPC         = 2
+	stopBreakpoint = 3
+	stopError      = 4
+	stopOutput     = 5
+
+	breakpointID      = 1
+	breakpointAddress = 2
+
+	breakpointsList = 1
+
+	memoryAddress = 1
+	memoryCount   = 2
+
+	runAddress = 1
+	runWords   = 2
+
+	registerName  = 1
+	registerValue = 2
+
+	inputText = 1
+)
+
+// stopReasons name the reasons a program stops.
+var stopReasons = map[debugger.StopReason]string{
+	debugger.StopStep:          "step",
+	debugger.StopBreakpoint:    "breakpoint",
+	debugger.StopHalt:          "halt",
+	debugger.StopError:         "error",
+	debugger.StopWatchpoint:    "watchpoint",
+	debugger.StopRegisterWatch: "watchpoint",
+	debugger.StopInterrupt:     "interrupt",
+	debugger.StopTrap:          "trap",
+}
+
+// errNotLoaded is answered to calls made before a program is loaded.
+var errNotLoaded = errorf(codeFailedPrecondition, "no program is loaded")
+
+// Server serves the Machine service.
+type Server struct {
+	// mu serializes the calls controlling the machine.
+	mu sync.Mutex
+
+	// dbg controls the loaded program, nil until one is loaded.
+	dbg atomic.Pointer[debugger.Debugger]
+
+	// keyboard is the console input.
+	keyboard *keyboard
+
+	// console collects the console output.
+	console *console
+
+	// tracer receives the entries of a Trace call, if one is running.
+	tracer func(e *trace.Entry)
+
+	// methods maps the paths of the methods to their implementation.
+	methods map[string]any
+}
+
+// New creates a server with no program loaded.
+func New() *Server {
+	s := &Server{keyboard: newKeyboard(), console: &console{}}
+
+	s.methods = map[string]any{
+		"Load":            unary(s.load),
+		"Run":             unary(s.run),
+		"Step":            unary(s.step),
+		"Interrupt":       unary(s.interrupt),
+		"SetBreakpoint":   unary(s.setBreakpoint),
+		"ClearBreakpoint": unary(s.clearBreakpoint),
+		"ListBreakpoints": unary(s.listBreakpoints),
+		"GetState":        unary(s.getState),
+		"ReadMemory":      unary(s.readMemory),
+		"WriteMemory":     unary(s.writeMemory),
+		"SetRegister":     unary(s.setRegister),
+		"SendInput":       unary(s.sendInput),
+		"Trace":           streaming(s.trace),
+	}
+
+	return s
+}
+
+// ServeHTTP serves a gRPC call.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method, ok := s.methods[strings.TrimPrefix(r.URL.Path, service)]
+	if !ok || !strings.HasPrefix(r.URL.Path, service) {
+		method = unary(func([]byte) ([]byte, error) {
+			return nil, errorf(codeUnimplemented, "unknown method %s", r.URL.Path)
+		})
+	}
+
+	serve(w, r, method)
+}
+
+// Load loads a memory image on a fresh machine with the PC at origin,
+// as the Load method does.
+func (s *Server) Load(image [cpu.MemoryMax]uint16, origin uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reset(image, origin)
+}
+
+// reset loads an image on a fresh machine.
+func (s *Server) reset(image [cpu.MemoryMax]uint16, origin uint16) {
+	newCPU := func() cpu.CPU {
+		c := cpu.NewCPU(
+			cpu.WithInput(s.keyboard),
+			cpu.WithOutput(s.console),
+			cpu.WithDevice(devices.NewTerminal(s.console)),
+			cpu.WithDevice(devices.NewRNG(time.Now().UnixNano())),
+			cpu.WithDevice(devices.NewRTC(time.Now)),
+		)
+
+		trace.Record(c, func(e *trace.Entry) {
+			if s.tracer != nil {
+				s.tracer(e)
+			}
+		})
+
+		return c
+	}
+
+	dbg := debugger.New(newCPU, image, nil, io.Discard)
+	dbg.CPU().SetRegister(registers.RPC, origin)
+
+	s.console.take()
+	s.dbg.Store(dbg)
+}
+
+// debugger returns the debugger of the loaded program.
+func (s *Server) debugger() (*debugger.Debugger, error) {
+	dbg := s.dbg.Load()
+	if dbg == nil {
+		return nil, errNotLoaded
+	}
+
+	return dbg, nil
+}
+
+// load loads a program given as source or as an object.
+func (s *Server) load(req []byte) ([]byte, error) {
+	fields, err := decode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj *asm.Object
+
+	switch {
+	case len(fields[loadSource].Bytes) > 0:
+		var diagnostics asm.Diagnostics
+
+		obj, _, diagnostics, err = asm.Assemble(bytes.NewReader(fields[loadSource].Bytes), asm.WithoutIncludes())
+		if len(diagnostics) > 0 {
+			var reply []byte
+			for _, d := range diagnostics {
+				reply = protowire.AppendBytes(reply, loadDiagnostics, []byte(d.Error()))
+			}
+
+			return reply, nil
+		}
+	case len(fields[loadObject].Bytes) > 0:
+		obj, err = asm.ReadObject(bytes.NewReader(fields[loadObject].Bytes))
+	default:
+		return nil, errorf(codeInvalidArgument, "no source or object to load")
+	}
+
+	if err != nil {
+		return nil, errorf(codeInvalidArgument, "%v", err)
+	}
+
+	var image [cpu.MemoryMax]uint16
+	copy(image[obj.Origin:], obj.Words)
+
+	s.Load(image, obj.Origin)
+
+	return protowire.AppendVarint(nil, loadOrigin, uint64(obj.Origin)), nil
+}
+
+// run runs the program until it stops.
+func (s *Server) run(req []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.stopReply(dbg.Continue()), nil
+}
+
+// step runs a number of instructions.
+func (s *Server) step(req []byte) ([]byte, error) {
+	fields, err := decode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.stopReply(dbg.Step(int(max(fields[stepCount].Varint, 1)))), nil
+}
+
+// stopReply encodes where and why the program stopped, with the output
+// written since the last reply.
+func (s *Server) stopReply(stop debugger.Stop) []byte {
+	b := protowire.AppendBytes(nil, stopReason, []byte(stopReasons[stop.Reason]))
+	b = protowire.AppendVarint(b, stopPC, uint64(stop.PC))
+
+	if stop.Breakpoint != nil {
+		b = protowire.AppendVarint(b, stopBreakpoint, uint64(stop.Breakpoint.ID))
+	}
+
+	if stop.Err != nil {
+		b = protowire.AppendBytes(b, stopError, []byte(stop.Err.Error()))
+	}
+
+	if output := s.console.take(); len(output) > 0 {
+		b = protowire.AppendBytes(b, stopOutput, output)
+	}
+
+	return b
+}
+
+// interrupt stops a running program.
+func (s *Server) interrupt(req []byte) ([]byte, error) {
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	dbg.Interrupt()
+
+	return nil, nil
+}
+
+// setBreakpoint sets a breakpoint at an address.
+func (s *Server) setBreakpoint(req []byte) ([]byte, error) {
+	fields, err := decode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	return appendBreakpoint(nil, dbg.AddBreakpoint(uint16(fields[breakpointAddress].Varint))), nil
+}
+
+// appendBreakpoint appends a Breakpoint message.
+func appendBreakpoint(b []byte, bp *debugger.Breakpoint) []byte {
+	b = protowire.AppendVarint(b, breakpointID, uint64(bp.ID))
+	return protowire.AppendVarint(b, breakpointAddress, uint64(bp.Address))
+}
+
+// clearBreakpoint deletes a breakpoint.
+func (s *Server) clearBreakpoint(req []byte) ([]byte, error) {
+	fields, err := decode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	if id := int(fields[breakpointID].Varint); !dbg.DeleteBreakpoint(id) {
+		return nil, errorf(codeNotFound, "no breakpoint %d", id)
+	}
+
+	return nil, nil
+}
+
+// listBreakpoints lists the breakpoints.
+func (s *Server) listBreakpoints(req []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	for _, bp := range dbg.Breakpoints() {
+		b = protowire.AppendBytes(b, breakpointsList, appendBreakpoint(nil, bp))
+	}
+
+	return b, nil
+}
+
+// getState returns the state of the machine.
+func (s *Server) getState(req []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	return state.MarshalProto(dbg.CPU().Snapshot()), nil
+}
+
+// readMemory reads a range of memory.
+func (s *Server) readMemory(req []byte) ([]byte, error) {
+	fields, err := decode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	address := fields[memoryAddress].Varint
+	if address > math.MaxUint16 {
+		return nil, errorf(codeInvalidArgument, "invalid address %d", address)
+	}
+
+	count := min(max(fields[memoryCount].Varint, 1), math.MaxUint16+1-address)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	words := make([]uint16, count)
+	for i := range words {
+		words[i] = dbg.CPU().PeekMemory(uint16(address) + uint16(i))
+	}
+
+	b := protowire.AppendVarint(nil, runAddress, address)
+
+	return protowire.AppendPacked(b, runWords, words), nil
+}
+
+// writeMemory writes a run of words to memory.
+func (s *Server) writeMemory(req []byte) ([]byte, error) {
+	var (
+		address uint64
+		words   []uint16
+	)
+
+	for b := req; len(b) > 0; {
+		f, rest, err := protowire.Consume(b)
+		if err != nil {
+			return nil, errorf(codeInvalidArgument, "%v", err)
+		}
+
+		b = rest
+
+		switch f.Number {
+		case runAddress:
+			address = f.Varint
+		case runWords:
+			values, err := f.Packed()
+			if err != nil {
+				return nil, errorf(codeInvalidArgument, "%v", err)
+			}
+
+			words = append(words, values...)
+		}
+	}
+
+	if address+uint64(len(words)) > math.MaxUint16+1 {
+		return nil, errorf(codeInvalidArgument, "the words run past the end of memory")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, word := range words {
+		dbg.CPU().PokeMemory(uint16(address)+uint16(i), word)
+	}
+
+	return nil, nil
+}
+
+// setRegister sets a register.
+func (s *Server) setRegister(req []byte) ([]byte, error) {
+	fields, err := decode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.ToUpper(string(fields[registerName].Bytes))
+
+	var r uint16
+
+	switch {
+	case name == "PC":
+		r = registers.RPC
+	case len(name) == 2 && name[0] == 'R' && name[1] >= '0' && name[1] <= '7':
+		r = uint16(name[1] - '0')
+	default:
+		return nil, errorf(codeInvalidArgument, "unknown register %q, expected R0 to R7 or PC", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return nil, err
+	}
+
+	dbg.CPU().SetRegister(r, uint16(fields[registerValue].Varint))
+
+	return nil, nil
+}
+
+// sendInput types text into the console.
+func (s *Server) sendInput(req []byte) ([]byte, error) {
+	fields, err := decode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.keyboard.typeKeys(fields[inputText].Bytes)
+
+	return nil, nil
+}
+
+// trace runs the program until it stops, streaming an entry for every
+// instruction. A client going away interrupts the program.
+func (s *Server) trace(req []byte, send func(msg []byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dbg, err := s.debugger()
+	if err != nil {
+		return err
+	}
+
+	var buf []byte
+
+	s.tracer = func(e *trace.Entry) {
+		if err != nil {
+			return
+		}
+
+		buf = e.AppendProto(buf[:0])
+
+		if err = send(buf); err != nil {
+			dbg.Interrupt()
+		}
+	}
+
+	defer func() {
+		s.tracer = nil
+	}()
+
+	dbg.Continue()
+
+	return err
+}
+
+// decode decodes the fields of a message, keeping the last of each.
+func decode(b []byte) (map[int]protowire.Field, error) {
+	fields := map[int]protowire.Field{}
+
+	for len(b) > 0 {
+		f, rest, err := protowire.Consume(b)
+		if err != nil {
+			return nil, errorf(codeInvalidArgument, "%v", err)
+		}
+
+		fields[f.Number] = f
+		b = rest
+	}
+
+	return fields, nil
+}
+
+// keyboard is the console input, fed by SendInput.
+type keyboard struct {
+	// mu guards keys.
+	mu sync.Mutex
+
+	// keys are the keys typed and not yet read.
+	keys []byte
+
+	// typed is signalled when keys are typed.
+	typed chan struct{}
+}
+
+// newKeyboard creates a keyboard with no keys typed.
+func newKeyboard() *keyboard {
+	return &keyboard{typed: make(chan struct{}, 1)}
+}
+
+// Read waits for a key and reads it. Keys are read one at a time so
+// that none are lost to readers that buffer.
+func (k *keyboard) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for {
+		k.mu.Lock()
+
+		if len(k.keys) > 0 {
+			p[0] = k.keys[0]
+			k.keys = k.keys[1:]
+			k.mu.Unlock()
+
+			return 1, nil
+		}
+
+		k.mu.Unlock()
+
+		<-k.typed
+	}
+}
+
+// typeKeys queues keys to be read.
+func (k *keyboard) typeKeys(keys []byte) {
+	k.mu.Lock()
+	k.keys = append(k.keys, keys...)
+	k.mu.Unlock()
+
+	select {
+	case k.typed <- struct{}{}:
+	default:
+	}
+}
+
+// console collects the console output until it is taken.
+type console struct {
+	// mu guards buf.
+	mu sync.Mutex
+
+	// buf holds the output not yet taken.
+	buf bytes.Buffer
+}
+
+// Write collects output.
+func (c *console) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.buf.Write(p)
+}
+
+// take returns the output collected and forgets it.
+func (c *console) take() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := bytes.Clone(c.buf.Bytes())
+	c.buf.Reset()
+
+	return out
+}
@@ -0,0 +1,133 @@
+package grpcserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The status codes of gRPC used by the service.
+const (
+	codeOK                 = 0
+	codeInvalidArgument    = 3
+	codeNotFound           = 5
+	codeFailedPrecondition = 9
+	codeUnimplemented      = 12
+	codeInternal           = 13
+)
+
+// maxMessage caps the bytes of a request message.
+const maxMessage = 4 << 20
+
+// statusError is an error answered with a gRPC status code.
+type statusError struct {
+	// code is the status code.
+	code int
+
+	// message describes the error.
+	message string
+}
+
+// Error returns the message of the error.
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// errorf returns an error answered with a status code.
+func errorf(code int, format string, args ...any) error {
+	return &statusError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// unary is a method answering a request with a message.
+type unary func(req []byte) ([]byte, error)
+
+// streaming is a method answering a request with a stream of messages,
+// passed to send.
+type streaming func(req []byte, send func(msg []byte) error) error
+
+// serve answers a gRPC call over HTTP/2 with the method, which is a
+// unary or a streaming one.
+func serve(w http.ResponseWriter, r *http.Request, method any) {
+	if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requires HTTP/2 and the application/grpc content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(msg []byte) error {
+		frame := make([]byte, 5, 5+len(msg))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+
+		if _, err := w.Write(append(frame, msg...)); err != nil {
+			return err
+		}
+
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		return nil
+	}
+
+	req, err := readMessage(r.Body)
+	if err == nil {
+		switch m := method.(type) {
+		case unary:
+			var reply []byte
+			if reply, err = m(req); err == nil {
+				err = send(reply)
+			}
+		case streaming:
+			err = m(req, send)
+		}
+	}
+
+	code, message := codeOK, ""
+
+	if err != nil {
+		code, message = codeInternal, err.Error()
+
+		if s, ok := err.(*statusError); ok {
+			code = s.code
+		}
+	}
+
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+
+	if message != "" {
+		w.Header().Set("Grpc-Message", url.PathEscape(message))
+	}
+}
+
+// readMessage reads the message of a request, a byte telling whether
+// it is compressed and its length followed by the message.
+func readMessage(r io.Reader) ([]byte, error) {
+	var header [5]byte
+
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, errorf(codeInvalidArgument, "reading the request: %v", err)
+	}
+
+	if header[0] != 0 {
+		return nil, errorf(codeUnimplemented, "compressed messages are not supported")
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > maxMessage {
+		return nil, errorf(codeInvalidArgument, "the request of %d bytes is too large", length)
+	}
+
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, errorf(codeInvalidArgument, "reading the request: %v", err)
+	}
+
+	return msg, nil
+}
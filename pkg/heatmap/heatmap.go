@@ -0,0 +1,161 @@
+// Package heatmap counts the reads and writes a program makes to each
+// word of memory, and renders the counts so that the stack growing,
+// buffers overrunning and data being touched unexpectedly stand out.
+package heatmap
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"lc3/pkg/cpu"
+	"math"
+)
+
+// Map holds the number of reads and writes of every address.
+type Map struct {
+	// reads are the read counts by address.
+	reads [cpu.MemoryMax]uint64
+
+	// writes are the write counts by address.
+	writes [cpu.MemoryMax]uint64
+}
+
+// New creates an empty heat map.
+func New() *Map {
+	return &Map{}
+}
+
+// Record counts the memory accesses made by the instructions a CPU
+// runs. Instruction fetches are not counted.
+func (m *Map) Record(c cpu.CPU) {
+	c.OnMemoryAccess(func(access cpu.MemoryAccess) {
+		if access.Write {
+			m.writes[access.Address]++
+		} else {
+			m.reads[access.Address]++
+		}
+	})
+}
+
+// Reads returns the number of reads of an address.
+func (m *Map) Reads(address uint16) uint64 {
+	return m.reads[address]
+}
+
+// Writes returns the number of writes of an address.
+func (m *Map) Writes(address uint16) uint64 {
+	return m.writes[address]
+}
+
+// max returns the largest number of accesses of an address.
+func (m *Map) max() uint64 {
+	var most uint64
+	for address := range m.reads {
+		most = max(most, m.reads[address]+m.writes[address])
+	}
+
+	return most
+}
+
+// WriteCSV writes the addresses that were accessed with their read and
+// write counts:
+//
+//	address,reads,writes
+//	x3019,0,3
+func (m *Map) WriteCSV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "address,reads,writes\n")
+
+	for address := range m.reads {
+		if m.reads[address] > 0 || m.writes[address] > 0 {
+			fmt.Fprintf(bw, "x%04X,%d,%d\n", address, m.reads[address], m.writes[address])
+		}
+	}
+
+	return bw.Flush()
+}
+
+// shades render the heat of an address in the terminal, from none to
+// the most accessed.
+var shades = []rune{'·', '░', '▒', '▓', '█'}
+
+// rowWords is the number of addresses per line of the terminal
+// rendering.
+const rowWords = 64
+
+// heat returns the heat of n accesses out of the most of any address,
+// on a log scale so that rarely touched words still show, from 0 for
+// none to levels-1 for the most.
+func heat(n, most uint64, levels int) int {
+	if n == 0 || most == 0 {
+		return 0
+	}
+
+	h := 1 + int(float64(levels-2)*math.Log1p(float64(n))/math.Log1p(float64(most))+0.5)
+
+	return min(h, levels-1)
+}
+
+// WriteBlocks renders the heat map with block characters, one line per
+// 64 addresses and only for the lines where memory was accessed, a
+// darker block for more accesses:
+//
+//	x3000  ·······░░█▓·······························
+func (m *Map) WriteBlocks(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	most := m.max()
+
+	if most == 0 {
+		fmt.Fprintf(bw, "no memory was accessed\n")
+		return bw.Flush()
+	}
+
+	fmt.Fprintf(bw, "%c none, %c %d accesses\n", shades[0], shades[len(shades)-1], most)
+
+	for row := 0; row <= math.MaxUint16; row += rowWords {
+		touched := false
+		for address := row; address < row+rowWords; address++ {
+			touched = touched || m.reads[address]+m.writes[address] > 0
+		}
+
+		if !touched {
+			continue
+		}
+
+		fmt.Fprintf(bw, "x%04X  ", row)
+
+		for address := row; address < row+rowWords; address++ {
+			bw.WriteRune(shades[heat(m.reads[address]+m.writes[address], most, len(shades))])
+		}
+
+		fmt.Fprintln(bw)
+	}
+
+	return bw.Flush()
+}
+
+// WritePNG renders the whole memory as a 256 by 256 image, one pixel per
+// address with the high byte of the address as the row, green for
+// reads and red for writes, brighter for more accesses.
+func (m *Map) WritePNG(w io.Writer) error {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	most := m.max()
+
+	level := func(n uint64) uint8 {
+		return uint8(heat(n, most, 256))
+	}
+
+	for address := range m.reads {
+		img.Set(address&0xFF, address>>8, color.RGBA{
+			R: level(m.writes[address]),
+			G: level(m.reads[address]),
+			A: 0xFF,
+		})
+	}
+
+	return png.Encode(w, img)
+}
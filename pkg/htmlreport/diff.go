@@ -0,0 +1,95 @@
+package htmlreport
+
+import "strings"
+
+// maxDiffLines caps the lines compared line by line, beyond which the
+// outputs are shown whole, one removed and the other added.
+const maxDiffLines = 1000
+
+// DiffLine is a line of a diff of the expected and actual output.
+type DiffLine struct {
+	// Kind is ' ' for a line in both, '-' for an expected line
+	// missing from the output and '+' for an unexpected one.
+	Kind byte
+
+	// Text is the text of the line.
+	Text string
+}
+
+// Class returns the CSS class of the line.
+func (l DiffLine) Class() string {
+	switch l.Kind {
+	case '-':
+		return "removed"
+	case '+':
+		return "added"
+	default:
+		return "same"
+	}
+}
+
+// diffLines diffs two texts line by line along their longest common
+// subsequence.
+func diffLines(want, got string) []DiffLine {
+	a, b := splitLines(want), splitLines(got)
+
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		return append(lines('-', a), lines('+', b)...)
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff []DiffLine
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, DiffLine{Kind: ' ', Text: a[i]})
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Kind: '-', Text: a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Kind: '+', Text: b[j]})
+			j++
+		}
+	}
+
+	return append(append(diff, lines('-', a[i:])...), lines('+', b[j:])...)
+}
+
+// splitLines splits a text into lines, ignoring a final newline.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lines makes diff lines of a kind.
+func lines(kind byte, texts []string) []DiffLine {
+	var diff []DiffLine
+
+	for _, text := range texts {
+		diff = append(diff, DiffLine{Kind: kind, Text: text})
+	}
+
+	return diff
+}
@@ -0,0 +1,233 @@
+// Package htmlreport renders the results of a batch of test or grading
+// runs as a static HTML page, with every program's failures, output
+// diff, coverage and hottest instructions, to post for students or
+// keep as a CI artifact.
+package htmlreport
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"lc3/pkg/disasm"
+	"lc3/pkg/golden"
+	"lc3/pkg/grade"
+	"time"
+)
+
+// hotspots is the number of hottest instructions listed per program.
+const hotspots = 5
+
+// Report is the report of a batch of runs.
+type Report struct {
+	// Title is the title of the page.
+	Title string
+
+	// Generated is when the report was made.
+	Generated time.Time
+
+	// Programs are the entries of the programs, in the order they were
+	// added.
+	Programs []*Program
+}
+
+// Program is the entry of a program in a report.
+type Program struct {
+	// Name names the spec or the program.
+	Name string
+
+	// Passed reports whether the program met every expectation or
+	// scored every point.
+	Passed bool
+
+	// Graded is set for graded programs, which have a score.
+	Graded bool
+
+	// Score and Total are the points awarded and the points possible.
+	Score, Total float64
+
+	// Instructions counts the instructions the program ran.
+	Instructions uint64
+
+	// Failures describe what went wrong.
+	Failures []string
+
+	// Output is the console output of the program.
+	Output string
+
+	// Diff is the diff of the expected output and Output, if an output
+	// was expected and differs.
+	Diff []DiffLine
+
+	// Covered and Code count the instructions of the program that ran
+	// and all of them.
+	Covered, Code int
+
+	// Hotspots are the instructions that ran most often.
+	Hotspots []Hotspot
+}
+
+// Coverage returns the share of the instructions that ran, in percent.
+func (p *Program) Coverage() float64 {
+	if p.Code == 0 {
+		return 0
+	}
+
+	return 100 * float64(p.Covered) / float64(p.Code)
+}
+
+// Hotspot is an instruction that ran often.
+type Hotspot struct {
+	// Address is the address of the instruction, with its label.
+	Address string
+
+	// Count is the number of times it ran.
+	Count uint64
+
+	// Share is its share of the instructions run, in percent.
+	Share float64
+
+	// Instruction is its disassembly.
+	Instruction string
+}
+
+// New creates an empty report.
+func New(title string) *Report {
+	return &Report{Title: title, Generated: time.Now()}
+}
+
+// AddResult adds the result of a spec.
+func (r *Report) AddResult(result *golden.Result) {
+	p := &Program{
+		Name:         result.Name(),
+		Passed:       result.Passed(),
+		Instructions: result.Instructions,
+		Failures:     result.Failures,
+		Output:       result.Output,
+	}
+
+	if result.Expected != nil && *result.Expected != result.Output {
+		p.Diff = diffLines(*result.Expected, result.Output)
+	}
+
+	p.profile(result.Execution)
+
+	r.Programs = append(r.Programs, p)
+}
+
+// AddGrade adds the grade of a program.
+func (r *Report) AddGrade(report *grade.Report) {
+	p := &Program{
+		Name:         report.Program,
+		Passed:       report.Score == report.Total,
+		Graded:       true,
+		Score:        report.Score,
+		Total:        report.Total,
+		Instructions: report.Instructions,
+	}
+
+	if report.Error != "" {
+		p.Failures = append(p.Failures, report.Error)
+	}
+
+	for _, score := range report.Criteria {
+		if !score.Passed {
+			p.Failures = append(p.Failures, fmt.Sprintf("%s (%g points): %s", score.Name, score.Points, score.Message))
+		}
+	}
+
+	if report.Execution != nil {
+		p.Output = report.Execution.Output
+		p.profile(report.Execution)
+	}
+
+	r.Programs = append(r.Programs, p)
+}
+
+// profile fills in the coverage and hotspots of an execution.
+func (p *Program) profile(e *golden.Execution) {
+	if e == nil {
+		return
+	}
+
+	program := disasm.Disassemble(e.Object.Origin, e.Object.Words, disasm.WithSymbols(e.Symbols))
+	p.Covered, p.Code = program.Coverage(e.Profile.Count)
+
+	for _, hotspot := range e.Profile.Top(hotspots) {
+		p.Hotspots = append(p.Hotspots, Hotspot{
+			Address:     e.Symbols.Format(hotspot.Address),
+			Count:       hotspot.Count,
+			Share:       100 * float64(hotspot.Count) / float64(e.Profile.Total()),
+			Instruction: disasm.Format(hotspot.Address, e.Profile.Word(hotspot.Address)),
+		})
+	}
+}
+
+// Passed counts the programs that passed.
+func (r *Report) Passed() int {
+	n := 0
+
+	for _, p := range r.Programs {
+		if p.Passed {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Write writes the report as a standalone HTML page.
+func (r *Report) Write(w io.Writer) error {
+	return page.Execute(w, r)
+}
+
+// page is the template of a report.
+var page = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+td.number { text-align: right; }
+.pass { color: #17692b; }
+.fail { color: #b3261e; }
+pre { background: #f6f6f6; padding: 0.6em; overflow-x: auto; }
+.added { background: #e6ffec; }
+.removed { background: #ffebe9; }
+section { border-top: 1px solid #ccc; margin-top: 2em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Passed}} of {{len .Programs}} passed, generated {{.Generated.Format "2006-01-02 15:04:05 MST"}}.</p>
+<table>
+<tr><th>Program</th><th>Result</th>{{if and .Programs (index .Programs 0).Graded}}<th>Score</th>{{end}}<th>Instructions</th><th>Coverage</th></tr>
+{{range $i, $p := .Programs}}<tr>
+<td><a href="#program-{{$i}}">{{$p.Name}}</a></td>
+<td class="{{if $p.Passed}}pass">PASS{{else}}fail">FAIL{{end}}</td>
+{{if $p.Graded}}<td class="number">{{$p.Score}}/{{$p.Total}}</td>{{end}}
+<td class="number">{{$p.Instructions}}</td>
+<td class="number">{{if $p.Code}}{{printf "%.1f" $p.Coverage}}%{{end}}</td>
+</tr>
+{{end}}</table>
+{{range $i, $p := .Programs}}<section id="program-{{$i}}">
+<h2 class="{{if $p.Passed}}pass{{else}}fail{{end}}">{{$p.Name}}</h2>
+{{if $p.Failures}}<ul>
+{{range $p.Failures}}<li><pre>{{.}}</pre></li>
+{{end}}</ul>
+{{end}}{{if $p.Diff}}<h3>Output diff</h3>
+<pre>{{range $p.Diff}}<span class="{{.Class}}">{{printf "%c %s\n" .Kind .Text}}</span>{{end}}</pre>
+{{else if $p.Output}}<h3>Output</h3>
+<pre>{{$p.Output}}</pre>
+{{end}}{{if $p.Code}}<p>{{$p.Covered}} of {{$p.Code}} instructions ran, {{printf "%.1f" $p.Coverage}}%.</p>
+{{end}}{{if $p.Hotspots}}<h3>Hottest instructions</h3>
+<table>
+<tr><th>Address</th><th>Instruction</th><th>Count</th><th>Share</th></tr>
+{{range $p.Hotspots}}<tr><td>{{.Address}}</td><td><code>{{.Instruction}}</code></td><td class="number">{{.Count}}</td><td class="number">{{printf "%.1f" .Share}}%</td></tr>
+{{end}}</table>
+{{end}}</section>
+{{end}}</body>
+</html>
+`))
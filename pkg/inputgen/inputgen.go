@@ -0,0 +1,335 @@
+// Package inputgen generates random inputs for programs from a seed
+// and a template, so that programs can be tested on varied inputs
+// while any failure can be reproduced from its seed.
+//
+// A template describes the keystrokes typed into the console and the
+// words of memory set before the program runs, one per line:
+//
+//	# a number, a word and a table to sort
+//	stdin int 1 99
+//	stdin "\n"
+//	stdin string 3 8 "abcdefghijklmnopqrstuvwxyz"
+//	stdin "\n"
+//	memory DATA int -50 50 10
+//	memory NAME string 1 8 "ABC"
+//
+// stdin types a quoted string as is, a random decimal number between
+// MIN and MAX, or a random string of MIN to MAX characters of an
+// alphabet. memory sets COUNT random words from an address or label
+// on, one by default, or a random zero-terminated string. Numbers are
+// written in decimal, or in hex as x1F.
+package inputgen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Input is an input generated from a template.
+type Input struct {
+	// Stdin is the text typed into the console.
+	Stdin string
+
+	// Memory are the words set before the program runs.
+	Memory []Word
+}
+
+// Word is a word of memory set before the program runs.
+type Word struct {
+	// Address is the address of the first word of the item, in hex as
+	// x4000 or a label of the program.
+	Address string
+
+	// Offset is added to the address.
+	Offset uint16
+
+	// Value is the value of the word.
+	Value uint16
+}
+
+// Write writes the input as the lines of a spec setting it, an input
+// line followed by a set line per item of memory.
+func (in *Input) Write(w io.Writer) error {
+	if in.Stdin != "" {
+		if _, err := fmt.Fprintf(w, "input %s\n", strconv.Quote(in.Stdin)); err != nil {
+			return err
+		}
+	}
+
+	for i, word := range in.Memory {
+		prefix := " "
+		if word.Offset == 0 {
+			prefix = "set " + word.Address + " "
+		}
+
+		suffix := ""
+		if i+1 == len(in.Memory) || in.Memory[i+1].Offset == 0 {
+			suffix = "\n"
+		}
+
+		if _, err := fmt.Fprintf(w, "%sx%04X%s", prefix, word.Value, suffix); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// generator generates a part of an input.
+type generator struct {
+	// memory is the address or label of a memory part, empty for a
+	// part of stdin.
+	memory string
+
+	// literal is the text of a quoted part of stdin.
+	literal string
+
+	// kind is "int" or "string" for random parts, empty for literal
+	// ones.
+	kind string
+
+	// min and max bound an int, or the length of a string.
+	min, max int
+
+	// count is the number of ints set in memory.
+	count int
+
+	// alphabet holds the characters of a string.
+	alphabet string
+}
+
+// Template generates inputs.
+type Template struct {
+	// generators generate the parts of an input in order.
+	generators []generator
+}
+
+// Load reads a template file.
+func Load(filename string) (*Template, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	t, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return t, nil
+}
+
+// Parse parses a template.
+func Parse(r io.Reader) (*Template, error) {
+	t := &Template{}
+
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		g, err := parseLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("template line %d: %w", line, err)
+		}
+
+		t.generators = append(t.generators, g)
+	}
+
+	return t, scanner.Err()
+}
+
+// parseLine parses a line of a template.
+func parseLine(text string) (generator, error) {
+	var g generator
+
+	directive, arg, _ := strings.Cut(text, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch directive {
+	case "stdin":
+		if strings.HasPrefix(arg, `"`) {
+			literal, err := strconv.Unquote(arg)
+			if err != nil {
+				return g, fmt.Errorf("invalid string %s", arg)
+			}
+
+			g.literal = literal
+
+			return g, nil
+		}
+	case "memory":
+		g.memory, arg, _ = strings.Cut(arg, " ")
+		if g.memory == "" {
+			return g, fmt.Errorf("expected an address")
+		}
+	default:
+		return g, fmt.Errorf("unknown directive %q", directive)
+	}
+
+	g.kind, arg, _ = strings.Cut(strings.TrimSpace(arg), " ")
+
+	switch g.kind {
+	case "int":
+		return g, g.parseInt(strings.Fields(arg))
+	case "string":
+		return g, g.parseString(arg)
+	default:
+		return g, fmt.Errorf("expected int or string, got %q", g.kind)
+	}
+}
+
+// parseInt parses the range of an int and, in memory, their count.
+func (g *generator) parseInt(fields []string) error {
+	if len(fields) < 2 || len(fields) > 3 || len(fields) == 3 && g.memory == "" {
+		return fmt.Errorf("expected MIN MAX, and a COUNT in memory")
+	}
+
+	if err := g.parseRange(fields[0], fields[1]); err != nil {
+		return err
+	}
+
+	if g.min < -0x8000 || g.max > 0xFFFF {
+		return fmt.Errorf("range %d to %d does not fit in a word", g.min, g.max)
+	}
+
+	g.count = 1
+
+	if len(fields) == 3 {
+		n, err := parseNumber(fields[2])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid count %q", fields[2])
+		}
+
+		g.count = n
+	}
+
+	return nil
+}
+
+// parseString parses the length range and the quoted alphabet of a
+// string.
+func (g *generator) parseString(arg string) error {
+	fields := strings.SplitN(arg, " ", 3)
+	if len(fields) != 3 {
+		return fmt.Errorf("expected MIN MAX and an alphabet")
+	}
+
+	if err := g.parseRange(fields[0], fields[1]); err != nil {
+		return err
+	}
+
+	if g.min < 0 {
+		return fmt.Errorf("negative length %d", g.min)
+	}
+
+	alphabet, err := strconv.Unquote(strings.TrimSpace(fields[2]))
+	if err != nil || alphabet == "" {
+		return fmt.Errorf("invalid alphabet %s", fields[2])
+	}
+
+	g.alphabet = alphabet
+
+	return nil
+}
+
+// parseRange parses the bounds of a range.
+func (g *generator) parseRange(min, max string) error {
+	var err error
+
+	if g.min, err = parseNumber(min); err != nil {
+		return err
+	}
+
+	if g.max, err = parseNumber(max); err != nil {
+		return err
+	}
+
+	if g.min > g.max {
+		return fmt.Errorf("empty range %d to %d", g.min, g.max)
+	}
+
+	return nil
+}
+
+// parseNumber parses a number in decimal, or in hex as x1F.
+func parseNumber(s string) (int, error) {
+	if rest, ok := strings.CutPrefix(strings.ToLower(s), "x"); ok {
+		n, err := strconv.ParseInt(rest, 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", s)
+		}
+
+		return int(n), nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "#"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+
+	return n, nil
+}
+
+// Generate generates the input of a seed. The same seed always
+// generates the same input.
+func (t *Template) Generate(seed int64) *Input {
+	rng := rand.New(rand.NewSource(seed))
+	in := &Input{}
+
+	var stdin strings.Builder
+
+	for _, g := range t.generators {
+		switch {
+		case g.kind == "" && g.memory == "":
+			stdin.WriteString(g.literal)
+		case g.memory == "" && g.kind == "int":
+			stdin.WriteString(strconv.Itoa(g.intn(rng)))
+		case g.memory == "":
+			stdin.WriteString(g.string(rng))
+		case g.kind == "int":
+			for i := 0; i < g.count; i++ {
+				in.Memory = append(in.Memory, Word{Address: g.memory, Offset: uint16(i), Value: uint16(g.intn(rng))})
+			}
+		default:
+			s := g.string(rng)
+			for i := 0; i <= len(s); i++ {
+				var value uint16
+				if i < len(s) {
+					value = uint16(s[i])
+				}
+
+				in.Memory = append(in.Memory, Word{Address: g.memory, Offset: uint16(i), Value: value})
+			}
+		}
+	}
+
+	in.Stdin = stdin.String()
+
+	return in
+}
+
+// intn draws a number in the range of the generator.
+func (g *generator) intn(rng *rand.Rand) int {
+	return g.min + rng.Intn(g.max-g.min+1)
+}
+
+// string draws a string of a length in the range of the generator.
+func (g *generator) string(rng *rand.Rand) string {
+	b := make([]byte, g.intn(rng))
+	for i := range b {
+		b[i] = g.alphabet[rng.Intn(len(g.alphabet))]
+	}
+
+	return string(b)
+}
@@ -0,0 +1,342 @@
+// Package kernel implements a Jupyter kernel for LC3 assembly, so that
+// architecture can be taught from notebooks. Every cell is assembled
+// and run in a sandbox, its output streamed to the notebook and the
+// registers it leaves shown as its result. Cells without an .ORIG
+// directive are taken as instructions run from x3000 up to a HALT
+// appended to them.
+//
+// The kernel speaks version 5.3 of the Jupyter messaging protocol over
+// ZeroMQ sockets served by lc3/pkg/zmtp, on the ports of the
+// connection file Jupyter starts it with.
+package kernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"lc3/pkg/sandbox"
+	"lc3/pkg/zmtp"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// ConnectionInfo is the connection file Jupyter starts a kernel with.
+type ConnectionInfo struct {
+	// Transport is the transport of the sockets, only tcp is supported.
+	Transport string `json:"transport"`
+
+	// IP is the address the sockets listen on.
+	IP string `json:"ip"`
+
+	// ShellPort is the port of the shell socket.
+	ShellPort int `json:"shell_port"`
+
+	// IOPubPort is the port of the IOPub socket.
+	IOPubPort int `json:"iopub_port"`
+
+	// StdinPort is the port of the stdin socket.
+	StdinPort int `json:"stdin_port"`
+
+	// ControlPort is the port of the control socket.
+	ControlPort int `json:"control_port"`
+
+	// HBPort is the port of the heartbeat socket.
+	HBPort int `json:"hb_port"`
+
+	// SignatureScheme is the scheme messages are signed with, only
+	// hmac-sha256 is supported.
+	SignatureScheme string `json:"signature_scheme"`
+
+	// Key is the key messages are signed with, none if empty.
+	Key string `json:"key"`
+}
+
+// ReadConnectionFile reads a connection file.
+func ReadConnectionFile(filename string) (*ConnectionInfo, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var info ConnectionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("invalid connection file: %w", err)
+	}
+
+	if info.Transport != "tcp" {
+		return nil, fmt.Errorf("unsupported transport %s", info.Transport)
+	}
+
+	if info.Key != "" && info.SignatureScheme != "hmac-sha256" {
+		return nil, fmt.Errorf("unsupported signature scheme %s", info.SignatureScheme)
+	}
+
+	return &info, nil
+}
+
+// Kernel is a running kernel.
+type Kernel struct {
+	// shell receives the requests of the notebook.
+	shell *zmtp.Socket
+
+	// control receives shutdown and interrupt requests.
+	control *zmtp.Socket
+
+	// stdin asks the notebook for input, which cells do not.
+	stdin *zmtp.Socket
+
+	// iopub publishes the output and status of the kernel.
+	iopub *zmtp.Socket
+
+	// heartbeat echoes the pings of the notebook.
+	heartbeat *zmtp.Socket
+
+	// signer signs the messages.
+	signer signer
+
+	// session identifies the session of the kernel.
+	session string
+
+	// runner runs the cells.
+	runner *runner
+
+	// done is closed once the kernel is shut down.
+	done chan struct{}
+
+	// shutdown closes done once.
+	shutdown sync.Once
+}
+
+// New creates a kernel listening on the sockets of a connection file,
+// running cells in a sandbox configured by the options.
+func New(info *ConnectionInfo, opts ...sandbox.Option) (*Kernel, error) {
+	k := &Kernel{
+		signer:  signer{key: []byte(info.Key)},
+		session: newID(),
+		runner:  newRunner(opts),
+		done:    make(chan struct{}),
+	}
+
+	sockets := []struct {
+		socket **zmtp.Socket
+		kind   string
+		port   int
+	}{
+		{&k.shell, zmtp.Router, info.ShellPort},
+		{&k.control, zmtp.Router, info.ControlPort},
+		{&k.stdin, zmtp.Router, info.StdinPort},
+		{&k.iopub, zmtp.Pub, info.IOPubPort},
+		{&k.heartbeat, zmtp.Rep, info.HBPort},
+	}
+
+	for _, s := range sockets {
+		socket, err := zmtp.Listen(s.kind, fmt.Sprintf("%s:%d", info.IP, s.port))
+		if err != nil {
+			k.Close()
+			return nil, err
+		}
+
+		*s.socket = socket
+	}
+
+	return k, nil
+}
+
+// Serve serves the notebook until it shuts the kernel down.
+func (k *Kernel) Serve() error {
+	defer k.Close()
+
+	go k.echo()
+	go k.serve(k.control)
+	go k.serve(k.shell)
+
+	k.publish(nil, "status", map[string]string{"execution_state": "starting"})
+
+	<-k.done
+
+	return nil
+}
+
+// Close closes the sockets of the kernel.
+func (k *Kernel) Close() error {
+	for _, socket := range []*zmtp.Socket{k.shell, k.control, k.stdin, k.iopub, k.heartbeat} {
+		if socket != nil {
+			socket.Close()
+		}
+	}
+
+	return nil
+}
+
+// echo echoes the heartbeats of the notebook.
+func (k *Kernel) echo() {
+	for {
+		ping, err := k.heartbeat.Recv()
+		if err != nil {
+			return
+		}
+
+		k.heartbeat.Reply(ping, ping.Frames)
+	}
+}
+
+// serve handles the requests received on a socket.
+func (k *Kernel) serve(socket *zmtp.Socket) {
+	for {
+		received, err := socket.Recv()
+		if err != nil {
+			return
+		}
+
+		req, err := k.signer.decode(received.Frames)
+		if err != nil {
+			slog.Warn("dropped a message", "component", "kernel", "err", err)
+			continue
+		}
+
+		k.publish(req, "status", map[string]string{"execution_state": "busy"})
+
+		msgType, content := k.handle(req)
+		if msgType != "" {
+			k.reply(socket, received, req, msgType, content)
+		}
+
+		k.publish(req, "status", map[string]string{"execution_state": "idle"})
+
+		if req.header.MsgType == "shutdown_request" {
+			k.shutdown.Do(func() { close(k.done) })
+			return
+		}
+	}
+}
+
+// handle handles a request, returning the type and content of the
+// reply, or no type for requests that are not answered.
+func (k *Kernel) handle(req *message) (string, any) {
+	switch req.header.MsgType {
+	case "kernel_info_request":
+		return "kernel_info_reply", map[string]any{
+			"status":                 "ok",
+			"protocol_version":       protocolVersion,
+			"implementation":         "lc3",
+			"implementation_version": "1.0",
+			"language_info": map[string]any{
+				"name":           "lc3",
+				"mimetype":       "text/x-lc3asm",
+				"file_extension": ".asm",
+			},
+			"banner":     "LC-3 assembly, every cell is assembled and run",
+			"help_links": []any{},
+		}
+	case "execute_request":
+		return "execute_reply", k.execute(req)
+	case "is_complete_request":
+		return "is_complete_reply", map[string]string{"status": "complete"}
+	case "complete_request":
+		var content struct {
+			CursorPos int `json:"cursor_pos"`
+		}
+		json.Unmarshal(req.content, &content)
+
+		return "complete_reply", map[string]any{
+			"status":       "ok",
+			"matches":      []string{},
+			"cursor_start": content.CursorPos,
+			"cursor_end":   content.CursorPos,
+			"metadata":     map[string]any{},
+		}
+	case "inspect_request":
+		return "inspect_reply", map[string]any{"status": "ok", "found": false, "data": map[string]any{}, "metadata": map[string]any{}}
+	case "history_request":
+		return "history_reply", map[string]any{"status": "ok", "history": []any{}}
+	case "comm_info_request":
+		return "comm_info_reply", map[string]any{"status": "ok", "comms": map[string]any{}}
+	case "interrupt_request":
+		// cells are bounded by the limits of the sandbox, so there is
+		// nothing to interrupt.
+		return "interrupt_reply", map[string]any{"status": "ok"}
+	case "shutdown_request":
+		var content struct {
+			Restart bool `json:"restart"`
+		}
+		json.Unmarshal(req.content, &content)
+
+		return "shutdown_reply", map[string]any{"status": "ok", "restart": content.Restart}
+	}
+
+	return "", nil
+}
+
+// execute runs a cell, publishing its input, output and result, and
+// returns the content of the reply.
+func (k *Kernel) execute(req *message) any {
+	var content struct {
+		Code   string `json:"code"`
+		Silent bool   `json:"silent"`
+	}
+
+	if err := json.Unmarshal(req.content, &content); err != nil {
+		return map[string]any{"status": "error", "ename": "InvalidRequest", "evalue": err.Error(), "traceback": []string{}}
+	}
+
+	count := k.runner.count(content.Silent)
+
+	if !content.Silent {
+		k.publish(req, "execute_input", map[string]any{"code": content.Code, "execution_count": count})
+	}
+
+	cell := k.runner.run(content.Code)
+
+	if len(cell.diagnostics) > 0 {
+		errContent := map[string]any{
+			"ename":     "AssemblyError",
+			"evalue":    "the cell does not assemble",
+			"traceback": cell.diagnostics,
+		}
+
+		k.publish(req, "error", errContent)
+
+		errContent["status"] = "error"
+		errContent["execution_count"] = count
+
+		return errContent
+	}
+
+	if content.Silent {
+		return map[string]any{"status": "ok", "execution_count": count, "user_expressions": map[string]any{}}
+	}
+
+	if cell.output != "" {
+		k.publish(req, "stream", map[string]string{"name": "stdout", "text": cell.output})
+	}
+
+	k.publish(req, "execute_result", map[string]any{
+		"execution_count": count,
+		"data":            map[string]string{"text/plain": cell.result},
+		"metadata":        map[string]any{},
+	})
+
+	return map[string]any{"status": "ok", "execution_count": count, "user_expressions": map[string]any{}}
+}
+
+// reply sends the reply to a request.
+func (k *Kernel) reply(socket *zmtp.Socket, received zmtp.Message, req *message, msgType string, content any) {
+	frames, err := k.signer.encode(k.session, msgType, req.identities, req, content)
+	if err != nil {
+		slog.Error("failed to encode a message", "component", "kernel", "type", msgType, "err", err)
+		return
+	}
+
+	socket.Reply(received, frames)
+}
+
+// publish publishes a message on IOPub, answering req if not nil.
+func (k *Kernel) publish(req *message, msgType string, content any) {
+	frames, err := k.signer.encode(k.session, msgType, [][]byte{[]byte("kernel." + k.session + "." + msgType)}, req, content)
+	if err != nil {
+		slog.Error("failed to encode a message", "component", "kernel", "type", msgType, "err", err)
+		return
+	}
+
+	k.iopub.Send(frames)
+}
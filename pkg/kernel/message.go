@@ -0,0 +1,140 @@
+package kernel
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// protocolVersion is the version of the messaging protocol spoken.
+const protocolVersion = "5.3"
+
+// delimiter separates the identities of a message from its parts.
+var delimiter = []byte("<IDS|MSG>")
+
+// Header is the header of a message.
+type Header struct {
+	// MsgID identifies the message.
+	MsgID string `json:"msg_id"`
+
+	// Session identifies the session of the sender.
+	Session string `json:"session"`
+
+	// Username is the user of the sender.
+	Username string `json:"username"`
+
+	// Date is when the message was created.
+	Date string `json:"date"`
+
+	// MsgType is the type of the message, such as execute_request.
+	MsgType string `json:"msg_type"`
+
+	// Version is the version of the protocol.
+	Version string `json:"version"`
+}
+
+// message is a message of the Jupyter messaging protocol.
+type message struct {
+	// identities route the message back to its sender.
+	identities [][]byte
+
+	// header is the header of the message.
+	header Header
+
+	// rawHeader is the header as sent, for the replies to the message.
+	rawHeader json.RawMessage
+
+	// content is the content of the message.
+	content json.RawMessage
+}
+
+// signer signs and verifies messages with HMAC-SHA256, or not at all
+// if it has no key.
+type signer struct {
+	// key is the key of the signatures.
+	key []byte
+}
+
+// sign returns the signature of the parts of a message.
+func (s signer) sign(parts ...[]byte) []byte {
+	if len(s.key) == 0 {
+		return nil
+	}
+
+	mac := hmac.New(sha256.New, s.key)
+	for _, part := range parts {
+		mac.Write(part)
+	}
+
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// decode decodes the frames of a message, checking its signature.
+func (s signer) decode(frames [][]byte) (*message, error) {
+	i := 0
+	for i < len(frames) && !bytes.Equal(frames[i], delimiter) {
+		i++
+	}
+
+	if len(frames) < i+6 {
+		return nil, fmt.Errorf("malformed message of %d frames", len(frames))
+	}
+
+	signature, parts := frames[i+1], frames[i+2:i+6]
+
+	if len(s.key) > 0 && !hmac.Equal(signature, s.sign(parts...)) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	m := &message{identities: frames[:i], rawHeader: parts[0], content: parts[3]}
+
+	if err := json.Unmarshal(parts[0], &m.header); err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+
+	return m, nil
+}
+
+// encode encodes a message of a type answering parent, if any, in a
+// session, routed by identities.
+func (s signer) encode(session, msgType string, identities [][]byte, parent *message, content any) ([][]byte, error) {
+	header, err := json.Marshal(Header{
+		MsgID:    newID(),
+		Session:  session,
+		Username: "lc3",
+		Date:     time.Now().UTC().Format(time.RFC3339Nano),
+		MsgType:  msgType,
+		Version:  protocolVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	parentHeader, metadata := []byte("{}"), []byte("{}")
+	if parent != nil {
+		parentHeader = parent.rawHeader
+	}
+
+	frames := append([][]byte{}, identities...)
+	frames = append(frames, delimiter, s.sign(header, parentHeader, metadata, body), header, parentHeader, metadata, body)
+
+	return frames, nil
+}
+
+// newID returns a random message or session ID.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
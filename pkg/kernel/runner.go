@@ -0,0 +1,111 @@
+package kernel
+
+import (
+	"fmt"
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"lc3/pkg/debugger"
+	"lc3/pkg/registers"
+	"lc3/pkg/sandbox"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// origPattern matches sources with an .ORIG directive.
+var origPattern = regexp.MustCompile(`(?im)^\s*(\w+\s+)?\.ORIG\b`)
+
+// cell is the outcome of running a cell.
+type cell struct {
+	// diagnostics are the reasons the cell does not assemble.
+	diagnostics []string
+
+	// output is the console output of the program.
+	output string
+
+	// result describes how the program ended and its registers.
+	result string
+}
+
+// runner runs cells in a sandbox.
+type runner struct {
+	// opts configure the sandbox.
+	opts []sandbox.Option
+
+	// mu guards executions.
+	mu sync.Mutex
+
+	// executions counts the cells run, but for silent ones.
+	executions int
+}
+
+// newRunner creates a runner running cells with the options.
+func newRunner(opts []sandbox.Option) *runner {
+	return &runner{opts: opts}
+}
+
+// count returns the execution count of the next cell, counting it
+// unless it is silent.
+func (r *runner) count(silent bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !silent {
+		r.executions++
+	}
+
+	return r.executions
+}
+
+// run assembles and runs a cell.
+func (r *runner) run(code string) *cell {
+	wrapped := !origPattern.MatchString(code)
+	if wrapped {
+		code = ".ORIG x3000\n" + code + "\nHALT\n.END\n"
+	}
+
+	obj, _, diagnostics, err := asm.Assemble(strings.NewReader(code))
+	if err == nil && len(diagnostics) == 0 {
+		return r.runObject(obj)
+	}
+
+	c := &cell{}
+
+	for _, d := range diagnostics {
+		// number the lines of the cell rather than of the wrapped source.
+		if wrapped && d.Line > 1 {
+			d.Line--
+		}
+
+		c.diagnostics = append(c.diagnostics, d.Error())
+	}
+
+	if len(c.diagnostics) == 0 {
+		c.diagnostics = []string{err.Error()}
+	}
+
+	return c
+}
+
+// runObject runs an assembled cell.
+func (r *runner) runObject(obj *asm.Object) *cell {
+	var image [cpu.MemoryMax]uint16
+	copy(image[obj.Origin:], obj.Words)
+
+	outcome := sandbox.Run(image, append(r.opts, sandbox.WithSetup(func(c cpu.CPU) {
+		c.SetRegister(registers.RPC, obj.Origin)
+	}))...)
+
+	var result strings.Builder
+
+	for reg := uint16(registers.RR0); reg <= registers.RR7; reg++ {
+		val := outcome.CPU.Register(reg)
+		fmt.Fprintf(&result, "R%d  x%04X  %6d\n", reg, val, int16(val))
+	}
+
+	fmt.Fprintf(&result, "PC  x%04X\n", outcome.CPU.Register(registers.RPC))
+	fmt.Fprintf(&result, "CC  %s\n", debugger.ConditionCodes(outcome.CPU.Register(registers.RCOND)))
+	fmt.Fprintf(&result, "%s after %d instructions", outcome.Status(), outcome.Instructions)
+
+	return &cell{output: outcome.Output, result: result.String()}
+}
@@ -0,0 +1,113 @@
+// Package lc3test lets Go tests run LC3 programs and check what they
+// leave behind in a line:
+//
+//	func TestEcho(t *testing.T) {
+//		lc3test.Run(t, "testdata/echo.asm",
+//			lc3test.WithStdin("hello\n"),
+//			lc3test.ExpectOutput("hello!\n"),
+//			lc3test.ExpectRegister(lc3test.R1, 5),
+//			lc3test.ExpectMemory("COUNT", 5),
+//		)
+//	}
+//
+// Programs run as under lc3 test, deterministically, with a virtual
+// clock and a seeded random number generator.
+package lc3test
+
+import (
+	"lc3/pkg/devices"
+	"lc3/pkg/golden"
+	"lc3/pkg/registers"
+	"testing"
+)
+
+// Register is a register a test can check.
+type Register uint16
+
+// The registers a test can check.
+const (
+	R0 Register = registers.RR0
+	R1 Register = registers.RR1
+	R2 Register = registers.RR2
+	R3 Register = registers.RR3
+	R4 Register = registers.RR4
+	R5 Register = registers.RR5
+	R6 Register = registers.RR6
+	R7 Register = registers.RR7
+	PC Register = registers.RPC
+)
+
+// Option configures a run or adds an expectation to it.
+type Option func(*golden.Spec)
+
+// WithStdin types s into the console of the program.
+func WithStdin(s string) Option {
+	return func(spec *golden.Spec) {
+		for i := 0; i < len(s); i++ {
+			spec.Input = append(spec.Input, devices.KeyEvent{Key: s[i]})
+		}
+	}
+}
+
+// WithLimit stops the program, failing the test, if it has not halted
+// after n instructions, golden.DefaultLimit by default.
+func WithLimit(n uint64) Option {
+	return func(spec *golden.Spec) {
+		spec.Limit = n
+	}
+}
+
+// ExpectOutput expects the program to write exactly s to the console.
+func ExpectOutput(s string) Option {
+	return func(spec *golden.Spec) {
+		spec.Output = &s
+	}
+}
+
+// ExpectRegister expects a register to hold value when the program
+// halts. Negative values are taken in two's complement.
+func ExpectRegister(r Register, value int) Option {
+	return func(spec *golden.Spec) {
+		spec.Registers = append(spec.Registers, golden.Register{Register: uint16(r), Value: uint16(value)})
+	}
+}
+
+// ExpectMemory expects the words from address on to hold values when
+// the program halts. The address is written in hex as x4000 or is a
+// label of the program.
+func ExpectMemory(address string, values ...int) Option {
+	return func(spec *golden.Spec) {
+		for i, value := range values {
+			spec.Memory = append(spec.Memory, golden.Cell{Address: address, Offset: uint16(i), Value: uint16(value)})
+		}
+	}
+}
+
+// Run runs the program, an .asm source or an .obj image, and reports
+// every expectation it misses as an error of t. It stops the test if
+// the program cannot be loaded. The result is returned for further
+// checks.
+func Run(t testing.TB, program string, opts ...Option) *golden.Result {
+	t.Helper()
+
+	spec := &golden.Spec{
+		Name:    program,
+		Program: program,
+		Limit:   golden.DefaultLimit,
+	}
+
+	for _, opt := range opts {
+		opt(spec)
+	}
+
+	result, err := golden.Run(spec)
+	if err != nil {
+		t.Fatalf("%s: %v", program, err)
+	}
+
+	for _, failure := range result.Failures {
+		t.Errorf("%s: %s", program, failure)
+	}
+
+	return result
+}
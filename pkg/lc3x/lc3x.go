@@ -0,0 +1,358 @@
+// Package lc3x is an experimental LC-3 with 32-bit registers and
+// memory words and an address space as large as its memory bus, to
+// explore how the ISA scales. It is kept apart from pkg/cpu, which
+// stays true to the specification, and shares none of its devices or
+// tools.
+//
+// Instructions keep the LC-3 encodings in the low 16 bits of their
+// word. Immediates and offsets are sign-extended to 32 bits, LDR, STR
+// and JMP reach the whole address space through their registers, and
+// LDI and STI through 32-bit pointers, while PC-relative offsets keep
+// their reach. The condition codes follow bit 31. GETC, OUT, PUTS, IN,
+// PUTSP, with four characters to a word, and HALT are handled by the
+// machine.
+package lc3x
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"lc3/pkg/cflags"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/traps"
+)
+
+// DefaultMemory is the number of words of the memory of the lc3 lc3x
+// command unless configured otherwise.
+const DefaultMemory = 1 << 20
+
+// MemoryBus is the memory of a machine, addressed by word.
+type MemoryBus interface {
+	// Read reads the word at an address.
+	Read(address uint32) (uint32, error)
+
+	// Write writes the word at an address.
+	Write(address, value uint32) error
+
+	// Size returns the number of words addressable.
+	Size() uint32
+}
+
+// RAM is a memory bus of words, failing accesses past its end.
+type RAM []uint32
+
+// NewRAM creates a memory of a number of words.
+func NewRAM(words uint32) RAM {
+	return make(RAM, words)
+}
+
+// Read reads the word at an address.
+func (r RAM) Read(address uint32) (uint32, error) {
+	if address >= uint32(len(r)) {
+		return 0, fmt.Errorf("read of x%08X past the end of memory", address)
+	}
+
+	return r[address], nil
+}
+
+// Write writes the word at an address.
+func (r RAM) Write(address, value uint32) error {
+	if address >= uint32(len(r)) {
+		return fmt.Errorf("write of x%08X past the end of memory", address)
+	}
+
+	r[address] = value
+
+	return nil
+}
+
+// Size returns the number of words of the memory.
+func (r RAM) Size() uint32 {
+	return uint32(len(r))
+}
+
+// Machine is an LC-3 with 32-bit registers.
+type Machine struct {
+	// bus is the memory.
+	bus MemoryBus
+
+	// registers are R0 to R7.
+	registers [8]uint32
+
+	// pc is the program counter.
+	pc uint32
+
+	// cond are the condition codes.
+	cond uint16
+
+	// halted is set once the program halted.
+	halted bool
+
+	// in is read by GETC and IN.
+	in io.Reader
+
+	// out is written by OUT, PUTS, IN and PUTSP.
+	out io.Writer
+}
+
+// Option configures a machine.
+type Option func(m *Machine)
+
+// WithInput reads the console from r, which the machine reads a byte
+// at a time.
+func WithInput(r io.Reader) Option {
+	return func(m *Machine) {
+		m.in = r
+	}
+}
+
+// WithOutput writes the console to w.
+func WithOutput(w io.Writer) Option {
+	return func(m *Machine) {
+		m.out = w
+	}
+}
+
+// New creates a machine on a memory bus, its PC at x3000 and the
+// console discarded unless configured otherwise.
+func New(bus MemoryBus, opts ...Option) *Machine {
+	m := &Machine{
+		bus:  bus,
+		pc:   0x3000,
+		cond: cflags.FLZRO,
+		in:   eofReader{},
+		out:  io.Discard,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// eofReader is the console of machines without input.
+type eofReader struct{}
+
+// Read reads io.EOF.
+func (eofReader) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+// Load writes words to memory from an address, such as the 16-bit
+// words of an LC-3 object, zero-extended.
+func (m *Machine) Load(origin uint32, words []uint16) error {
+	for i, word := range words {
+		if err := m.bus.Write(origin+uint32(i), uint32(word)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Register returns R0 to R7.
+func (m *Machine) Register(r int) uint32 {
+	return m.registers[r]
+}
+
+// SetRegister sets R0 to R7.
+func (m *Machine) SetRegister(r int, value uint32) {
+	m.registers[r] = value
+}
+
+// PC returns the program counter.
+func (m *Machine) PC() uint32 {
+	return m.pc
+}
+
+// SetPC sets the program counter.
+func (m *Machine) SetPC(pc uint32) {
+	m.pc = pc
+}
+
+// Halted reports whether the program halted.
+func (m *Machine) Halted() bool {
+	return m.halted
+}
+
+// Run runs the program until it halts or fails.
+func (m *Machine) Run() error {
+	for !m.halted {
+		if err := m.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sext sign-extends the low bits of an instruction to 32 bits.
+func sext(x uint32, bits uint) uint32 {
+	return uint32(int32(x<<(32-bits)) >> (32 - bits))
+}
+
+// Step runs one instruction.
+func (m *Machine) Step() error {
+	word, err := m.bus.Read(m.pc)
+	if err != nil {
+		return err
+	}
+
+	m.pc++
+
+	instr := word & 0xFFFF
+	dr := int(instr>>9) & 0x7
+	sr1 := int(instr>>6) & 0x7
+
+	switch instr >> 12 {
+	case opcodes.OPADD, opcodes.OPAND:
+		operand := m.registers[instr&0x7]
+		if instr&0x20 != 0 {
+			operand = sext(instr, 5)
+		}
+
+		if instr>>12 == opcodes.OPADD {
+			m.set(dr, m.registers[sr1]+operand)
+		} else {
+			m.set(dr, m.registers[sr1]&operand)
+		}
+	case opcodes.OPNOT:
+		m.set(dr, ^m.registers[sr1])
+	case opcodes.OPBR:
+		if uint16(instr>>9)&0x7&m.cond != 0 {
+			m.pc += sext(instr, 9)
+		}
+	case opcodes.OPJMP:
+		m.pc = m.registers[sr1]
+	case opcodes.OPJSR:
+		target := m.pc + sext(instr, 11)
+		if instr&0x800 == 0 {
+			target = m.registers[sr1]
+		}
+
+		m.registers[7], m.pc = m.pc, target
+	case opcodes.OPLD:
+		return m.load(dr, m.pc+sext(instr, 9))
+	case opcodes.OPLDR:
+		return m.load(dr, m.registers[sr1]+sext(instr, 6))
+	case opcodes.OPLDI:
+		pointer, err := m.bus.Read(m.pc + sext(instr, 9))
+		if err != nil {
+			return err
+		}
+
+		return m.load(dr, pointer)
+	case opcodes.OPLEA:
+		m.registers[dr] = m.pc + sext(instr, 9)
+	case opcodes.OPST:
+		return m.bus.Write(m.pc+sext(instr, 9), m.registers[dr])
+	case opcodes.OPSTR:
+		return m.bus.Write(m.registers[sr1]+sext(instr, 6), m.registers[dr])
+	case opcodes.OPSTI:
+		pointer, err := m.bus.Read(m.pc + sext(instr, 9))
+		if err != nil {
+			return err
+		}
+
+		return m.bus.Write(pointer, m.registers[dr])
+	case opcodes.OPTRAP:
+		m.registers[7] = m.pc
+		return m.trap(instr & 0xFF)
+	default:
+		return fmt.Errorf("illegal instruction %04X at x%08X", instr, m.pc-1)
+	}
+
+	return nil
+}
+
+// set sets a register and the condition codes.
+func (m *Machine) set(r int, value uint32) {
+	m.registers[r] = value
+
+	switch {
+	case value == 0:
+		m.cond = cflags.FLZRO
+	case int32(value) < 0:
+		m.cond = cflags.FLNEG
+	default:
+		m.cond = cflags.FLPOS
+	}
+}
+
+// load loads a register from memory.
+func (m *Machine) load(r int, address uint32) error {
+	value, err := m.bus.Read(address)
+	if err != nil {
+		return err
+	}
+
+	m.set(r, value)
+
+	return nil
+}
+
+// errNoTrap stops programs calling traps the machine does not handle.
+var errNoTrap = errors.New("unhandled trap")
+
+// trap runs a trap.
+func (m *Machine) trap(vector uint32) error {
+	switch vector {
+	case traps.GETC, traps.IN:
+		if vector == traps.IN {
+			fmt.Fprint(m.out, "Enter a character: ")
+		}
+
+		var key [1]byte
+		if _, err := io.ReadFull(m.in, key[:]); err != nil {
+			return err
+		}
+
+		if vector == traps.IN {
+			m.out.Write(key[:])
+		}
+
+		m.set(0, uint32(key[0]))
+	case traps.OUT:
+		_, err := m.out.Write([]byte{byte(m.registers[0])})
+		return err
+	case traps.PUTS, traps.PUTSP:
+		return m.puts(vector == traps.PUTSP)
+	case traps.HALT:
+		m.halted = true
+	default:
+		return fmt.Errorf("%w x%02X", errNoTrap, vector)
+	}
+
+	return nil
+}
+
+// puts writes the string at R0, a character to a word or, packed, four
+// to a word from the low byte.
+func (m *Machine) puts(packed bool) error {
+	var text []byte
+
+	for address := m.registers[0]; ; address++ {
+		word, err := m.bus.Read(address)
+		if err != nil {
+			return err
+		}
+
+		if word == 0 {
+			break
+		}
+
+		if !packed {
+			text = append(text, byte(word))
+			continue
+		}
+
+		for ; word != 0; word >>= 8 {
+			text = append(text, byte(word))
+		}
+	}
+
+	_, err := m.out.Write(text)
+
+	return err
+}
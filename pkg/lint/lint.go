@@ -0,0 +1,169 @@
+// Package lint warns about suspicious behavior of a running program,
+// the kind that works by accident or fails far from its cause: reading
+// the keyboard data register without checking the status register,
+// storing into code that ran, pushing the stack into code and returning
+// through a clobbered R7.
+//
+// Code is what the program ran so far, so that stores into the data
+// next to it are left alone. Each rule warns once per instruction, so
+// that a loop repeating a mistake warns about it once.
+package lint
+
+import (
+	"fmt"
+	"lc3/pkg/callstack"
+	"lc3/pkg/cpu"
+	"lc3/pkg/disasm"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+)
+
+// Rule is a kind of suspicious behavior.
+type Rule int
+
+const (
+	// UncheckedKeyboard is a read of KBDR without a read of KBSR
+	// reporting a key since the last one.
+	UncheckedKeyboard Rule = iota
+
+	// CodeWrite is a store into an instruction that ran.
+	CodeWrite
+
+	// StackInCode is R6 moving onto an instruction that ran.
+	StackInCode
+
+	// ClobberedReturn is a RET to somewhere other than after the
+	// call of the subroutine returning.
+	ClobberedReturn
+)
+
+// names are the names of the rules.
+var names = [...]string{
+	UncheckedKeyboard: "unchecked-keyboard",
+	CodeWrite:         "code-write",
+	StackInCode:       "stack-in-code",
+	ClobberedReturn:   "clobbered-return",
+}
+
+// String returns the name of the rule.
+func (r Rule) String() string {
+	if int(r) < len(names) {
+		return names[r]
+	}
+
+	return fmt.Sprintf("rule %d", int(r))
+}
+
+// Warning is suspicious behavior of an instruction.
+type Warning struct {
+	// Rule is the rule the instruction broke.
+	Rule Rule
+
+	// PC is the address of the instruction.
+	PC uint16
+
+	// Message describes what the instruction did.
+	Message string
+}
+
+// Error describes the warning.
+func (w *Warning) Error() string {
+	return fmt.Sprintf("x%04X: %s (%s)", w.PC, w.Message, w.Rule)
+}
+
+// Linter watches a CPU for suspicious behavior.
+type Linter struct {
+	// report is called with every warning.
+	report func(*Warning)
+
+	// code marks the addresses of the instructions that ran.
+	code [cpu.MemoryMax]bool
+
+	// warned marks the rules each address warned about.
+	warned map[uint16]uint8
+
+	// keyReady is set once KBSR reported a key that KBDR was not read
+	// for yet.
+	keyReady bool
+
+	// stack follows the calls, to check where they return to.
+	stack *callstack.Stack
+
+	// sp is R6 after the last instruction.
+	sp uint16
+}
+
+// New creates a linter calling report with every warning.
+func New(report func(*Warning)) *Linter {
+	return &Linter{
+		report: report,
+		warned: map[uint16]uint8{},
+		stack:  callstack.New(),
+	}
+}
+
+// Record watches the instructions run by a CPU.
+func (l *Linter) Record(c cpu.CPU) {
+	l.sp = c.Register(registers.RR6)
+
+	c.OnMemoryAccess(func(access cpu.MemoryAccess) {
+		switch {
+		case access.Write && l.code[access.Address]:
+			l.warn(CodeWrite, access.PC, "store into the instruction at x%04X, which already ran", access.Address)
+		case !access.Write && access.Address == registers.MRKBSR:
+			l.keyReady = access.Value&(1<<15) != 0
+		case !access.Write && access.Address == registers.MRKBDR:
+			if !l.keyReady {
+				l.warn(UncheckedKeyboard, access.PC, "read of KBDR without KBSR reporting a key")
+			}
+
+			l.keyReady = false
+		}
+	})
+
+	c.OnInstruction(func(pc, instr uint16) {
+		l.code[pc] = true
+
+		next := c.Register(registers.RPC)
+
+		if instr>>12 == opcodes.OPJMP && disasm.Decode(instr).Name == "RET" {
+			l.checkReturn(pc, next)
+		}
+
+		l.stack.Observe(pc, instr, next)
+
+		if sp := c.Register(registers.RR6); sp != l.sp {
+			l.sp = sp
+
+			if l.code[sp] {
+				l.warn(StackInCode, pc, "stack pointer R6 moved to x%04X, into code that ran", sp)
+			}
+		}
+	})
+}
+
+// checkReturn warns if a RET at pc to next does not return after the
+// innermost call.
+func (l *Linter) checkReturn(pc, next uint16) {
+	if l.stack.Depth() == 0 {
+		return
+	}
+
+	frame := l.stack.Frame(l.stack.Depth() - 1)
+
+	if next != frame.Return {
+		l.warn(ClobberedReturn, pc, "RET to x%04X rather than x%04X after the call at x%04X, R7 was clobbered", next, frame.Return, frame.Call)
+	}
+}
+
+// warn reports a warning unless the instruction at pc already broke
+// the rule.
+func (l *Linter) warn(rule Rule, pc uint16, format string, args ...any) {
+	if l.warned[pc]&(1<<rule) != 0 {
+		return
+	}
+
+	l.warned[pc] |= 1 << rule
+
+	l.report(&Warning{Rule: rule, PC: pc, Message: fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,61 @@
+package lsp
+
+import "strings"
+
+// docs are the hover texts of the instructions and directives, in
+// Markdown: the forms of the operands followed by what it does.
+var docs = map[string]string{
+	"ADD":  "`ADD DR, SR1, SR2` or `ADD DR, SR1, imm5`\n\nAdds SR2 or the immediate -16..15 to SR1 into DR. Sets the condition codes.",
+	"AND":  "`AND DR, SR1, SR2` or `AND DR, SR1, imm5`\n\nBitwise and of SR1 with SR2 or the immediate -16..15 into DR. Sets the condition codes.",
+	"NOT":  "`NOT DR, SR`\n\nBitwise complement of SR into DR. Sets the condition codes.",
+	"BR":   "`BR[n][z][p] LABEL`\n\nBranches to LABEL, within -256..255 words, if one of the given condition codes is set. BR and BRnzp always branch.",
+	"JMP":  "`JMP BaseR`\n\nJumps to the address in BaseR.",
+	"RET":  "`RET`\n\nReturns from a subroutine, the same as `JMP R7`.",
+	"JSR":  "`JSR LABEL`\n\nCalls the subroutine at LABEL, within -1024..1023 words, saving the return address in R7.",
+	"JSRR": "`JSRR BaseR`\n\nCalls the subroutine at the address in BaseR, saving the return address in R7.",
+	"LD":   "`LD DR, LABEL`\n\nLoads the word at LABEL, within -256..255 words, into DR. Sets the condition codes.",
+	"LDI":  "`LDI DR, LABEL`\n\nLoads the word at the address stored at LABEL into DR. Sets the condition codes.",
+	"LDR":  "`LDR DR, BaseR, offset6`\n\nLoads the word at BaseR plus the offset -32..31 into DR. Sets the condition codes.",
+	"LEA":  "`LEA DR, LABEL`\n\nLoads the address of LABEL, within -256..255 words, into DR.",
+	"ST":   "`ST SR, LABEL`\n\nStores SR at LABEL, within -256..255 words.",
+	"STI":  "`STI SR, LABEL`\n\nStores SR at the address stored at LABEL.",
+	"STR":  "`STR SR, BaseR, offset6`\n\nStores SR at BaseR plus the offset -32..31.",
+	"RTI":  "`RTI`\n\nReturns from an interrupt or exception, restoring the PC and PSR from the supervisor stack.",
+	"TRAP": "`TRAP trapvect8`\n\nCalls the trap routine whose address is in the trap vector table at the vector, saving the return address in R7.",
+
+	"GETC":  "`GETC`, `TRAP x20`\n\nReads a character from the keyboard into R0, without echoing it.",
+	"OUT":   "`OUT`, `TRAP x21`\n\nWrites the character in R0 to the console.",
+	"PUTS":  "`PUTS`, `TRAP x22`\n\nWrites the string of one character per word starting at the address in R0.",
+	"IN":    "`IN`, `TRAP x23`\n\nPrompts for a character, reads it into R0 and echoes it.",
+	"PUTSP": "`PUTSP`, `TRAP x24`\n\nWrites the string of two characters per word starting at the address in R0.",
+	"HALT":  "`HALT`, `TRAP x25`\n\nStops the program.",
+
+	".ORIG":     "`.ORIG address`\n\nStarts a block of the program loaded at the address.",
+	".END":      "`.END`\n\nEnds the program, the lines after it are ignored.",
+	".FILL":     "`.FILL value[, value...]`\n\nReserves words initialized to the values, which may be labels.",
+	".WORDS":    "`.WORDS value[, value...]`\n\nThe same as `.FILL`.",
+	".ARRAY":    "`.ARRAY count[, value]`\n\nReserves count words initialized to the value, or to zero.",
+	".BLKW":     "`.BLKW count`\n\nReserves count words initialized to zero.",
+	".STRINGZ":  "`.STRINGZ \"text\"`\n\nReserves the characters of the text, one per word, followed by a zero word.",
+	".EQU":      "`NAME .EQU value`\n\nDefines a constant, which takes no room in the program.",
+	".MACRO":    "`.MACRO NAME [param...]`\n\nStarts the definition of a macro, whose body ends at `.ENDM`.",
+	".ENDM":     "`.ENDM`\n\nEnds the definition of a macro.",
+	".INCLUDE":  "`.INCLUDE \"file\"`\n\nAssembles the lines of the file in place of the directive.",
+	".EXTERNAL": "`.EXTERNAL NAME[, NAME...]`\n\nDeclares symbols defined by other objects, resolved when linking.",
+	".GLOBAL":   "`.GLOBAL LABEL[, LABEL...]`\n\nExports labels to the other objects linked with this one.",
+}
+
+// lookupDoc returns the hover text of an instruction or directive, in
+// any case. Branches are documented together, whatever their
+// condition codes.
+func lookupDoc(word string) (string, bool) {
+	word = strings.ToUpper(word)
+
+	if strings.HasPrefix(word, "BR") && strings.Trim(word[2:], "NZP") == "" {
+		return docs["BR"], true
+	}
+
+	doc, ok := docs[word]
+
+	return doc, ok
+}
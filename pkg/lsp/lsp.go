@@ -0,0 +1,466 @@
+// Package lsp implements the Language Server Protocol for LC3 assembly
+// on top of the assembler, so that editors show the documentation of
+// instructions on hover, jump to the definitions of labels, list the
+// symbols of a file and report assembler errors when it is saved.
+//
+// Documents are synchronized in full on every change. They are
+// analyzed as a whole with the assembler, included files being read
+// from disk.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"lc3/pkg/asm"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Serve speaks the Language Server Protocol over conn until the client
+// exits, assembling documents with the options.
+func Serve(conn io.ReadWriter, opts ...asm.Option) error {
+	s := &server{
+		in:        bufio.NewReader(conn),
+		out:       conn,
+		opts:      opts,
+		documents: map[string]string{},
+	}
+
+	return s.serve()
+}
+
+// server is a single connection to a client.
+type server struct {
+	// in reads messages from the client.
+	in *bufio.Reader
+
+	// out writes responses and notifications to the client.
+	out io.Writer
+
+	// opts configure the assembler.
+	opts []asm.Option
+
+	// documents maps the URIs of the open documents to their text.
+	documents map[string]string
+}
+
+// serve handles messages until the client exits.
+func (s *server) serve() error {
+	for {
+		msg, err := readMessage(s.in)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		result, err := s.handle(msg)
+
+		// notifications are not answered.
+		if msg.ID == nil {
+			continue
+		}
+
+		if err := s.respond(msg, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+// handle executes a message and returns the result of a request.
+func (s *server) handle(msg *message) (any, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync": map[string]any{
+					"openClose": true,
+					"change":    syncFull,
+					"save":      map[string]any{"includeText": true},
+				},
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]any{"name": "lc3"},
+		}, nil
+	case "initialized", "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+
+		s.documents[params.TextDocument.URI] = params.TextDocument.Text
+
+		return nil, s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+
+		if n := len(params.ContentChanges); n > 0 {
+			s.documents[params.TextDocument.URI] = params.ContentChanges[n-1].Text
+		}
+
+		return nil, nil
+	case "textDocument/didSave":
+		var params didSaveParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+
+		if params.Text != nil {
+			s.documents[params.TextDocument.URI] = *params.Text
+		}
+
+		return nil, s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didClose":
+		var params didSaveParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+
+		delete(s.documents, params.TextDocument.URI)
+
+		return nil, s.notify("textDocument/publishDiagnostics", map[string]any{
+			"uri":         params.TextDocument.URI,
+			"diagnostics": []diagnostic{},
+		})
+	case "textDocument/hover":
+		return s.handleHover(msg)
+	case "textDocument/definition":
+		return s.handleDefinition(msg)
+	case "textDocument/documentSymbol":
+		return s.handleDocumentSymbol(msg)
+	}
+
+	if msg.ID == nil {
+		// unknown notifications, such as $/cancelRequest, are ignored.
+		return nil, nil
+	}
+
+	return nil, &responseError{Code: methodNotFound, Message: fmt.Sprintf("unsupported method %q", msg.Method)}
+}
+
+// respond answers a request with its result or error.
+func (s *server) respond(msg *message, result any, err error) error {
+	resp := response{JSONRPC: "2.0", ID: msg.ID}
+
+	if err != nil {
+		e, ok := err.(*responseError)
+		if !ok {
+			e = &responseError{Code: requestFailed, Message: err.Error()}
+		}
+
+		resp.Error = e
+	} else {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+
+		resp.Result = data
+	}
+
+	return writeMessage(s.out, resp)
+}
+
+// notify sends a notification to the client.
+func (s *server) notify(method string, params any) error {
+	return writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// Error returns the message of the error.
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// analyze assembles an open document.
+func (s *server) analyze(uri string) (*asm.Analysis, error) {
+	text, ok := s.documents[uri]
+	if !ok {
+		return nil, fmt.Errorf("document %s is not open", uri)
+	}
+
+	return asm.Analyze(uriPath(uri), strings.NewReader(text), s.opts...)
+}
+
+// publishDiagnostics assembles a document and sends its errors. Errors
+// in the files it includes are shown on them.
+func (s *server) publishDiagnostics(uri string) error {
+	analysis, err := s.analyze(uri)
+	if err != nil {
+		return err
+	}
+
+	files := map[string][]diagnostic{uri: {}}
+
+	for _, d := range analysis.Diagnostics {
+		file := uri
+		if d.File != "" && d.File != uriPath(uri) {
+			file = pathURI(d.File)
+		}
+
+		// errors about a whole file are shown on its first line.
+		start, end := position{}, position{Character: len(d.Source)}
+		if d.Line > 0 {
+			start.Line, end.Line = d.Line-1, d.Line-1
+		}
+
+		if d.Column > 0 {
+			start.Character, end.Character = d.Column-1, d.Column-1+max(d.Length, 1)
+		}
+
+		message := d.Message
+		if d.Hint != "" {
+			message += "\n" + d.Hint
+		}
+
+		files[file] = append(files[file], diagnostic{
+			Range:    span{Start: start, End: end},
+			Severity: severityError,
+			Code:     d.Code,
+			Source:   "lc3",
+			Message:  message,
+		})
+	}
+
+	for file, diagnostics := range files {
+		err := s.notify("textDocument/publishDiagnostics", map[string]any{
+			"uri":         file,
+			"diagnostics": diagnostics,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleHover shows the documentation of the instruction or directive
+// under the cursor, or the value of a label, constant or macro.
+func (s *server) handleHover(msg *message) (any, error) {
+	var params textDocumentPositionParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil, err
+	}
+
+	word, r := s.wordAt(params)
+	if word == "" {
+		return nil, nil
+	}
+
+	text, ok := lookupDoc(word)
+
+	if !ok {
+		analysis, err := s.analyze(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+
+		def, found := findDefinition(analysis, word)
+		if !found {
+			return nil, nil
+		}
+
+		text = describe(def)
+	}
+
+	return hover{Contents: markupContent{Kind: "markdown", Value: text}, Range: r}, nil
+}
+
+// handleDefinition finds the definition of the label, constant or macro
+// under the cursor.
+func (s *server) handleDefinition(msg *message) (any, error) {
+	var params textDocumentPositionParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil, err
+	}
+
+	word, _ := s.wordAt(params)
+	if word == "" {
+		return nil, nil
+	}
+
+	analysis, err := s.analyze(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	def, ok := findDefinition(analysis, word)
+	if !ok {
+		return nil, nil
+	}
+
+	uri := params.TextDocument.URI
+	if def.File != uriPath(uri) {
+		uri = pathURI(def.File)
+	}
+
+	return location{URI: uri, Range: definitionRange(def)}, nil
+}
+
+// handleDocumentSymbol lists the labels, constants and macros defined
+// by a document.
+func (s *server) handleDocumentSymbol(msg *message) (any, error) {
+	var params struct {
+		TextDocument textDocumentIdentifier `json:"textDocument"`
+	}
+
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil, err
+	}
+
+	analysis, err := s.analyze(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := map[asm.DefinitionKind]int{
+		asm.DefLabel:    symbolFunction,
+		asm.DefConstant: symbolConstant,
+		asm.DefMacro:    symbolMethod,
+	}
+
+	symbols := []documentSymbol{}
+
+	for _, def := range analysis.Definitions {
+		// the definitions of included files belong to them.
+		if def.File != uriPath(params.TextDocument.URI) {
+			continue
+		}
+
+		r := definitionRange(def)
+
+		symbols = append(symbols, documentSymbol{
+			Name:           def.Name,
+			Detail:         detail(def),
+			Kind:           kinds[def.Kind],
+			Range:          r,
+			SelectionRange: r,
+		})
+	}
+
+	return symbols, nil
+}
+
+// wordAt returns the word under the cursor and its range, a label,
+// mnemonic or directive, without the colon ending a label.
+func (s *server) wordAt(params textDocumentPositionParams) (string, span) {
+	text, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return "", span{}
+	}
+
+	lines := strings.Split(text, "\n")
+	if params.Position.Line < 0 || params.Position.Line >= len(lines) {
+		return "", span{}
+	}
+
+	line := lines[params.Position.Line]
+
+	start := min(max(params.Position.Character, 0), len(line))
+	for start > 0 && isWordByte(line[start-1]) {
+		start--
+	}
+
+	end := start
+	for end < len(line) && isWordByte(line[end]) {
+		end++
+	}
+
+	r := span{
+		Start: position{Line: params.Position.Line, Character: start},
+		End:   position{Line: params.Position.Line, Character: end},
+	}
+
+	return line[start:end], r
+}
+
+// isWordByte reports whether a character can be part of a label,
+// mnemonic or directive.
+func isWordByte(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+// findDefinition looks up a name defined by an analyzed document.
+func findDefinition(analysis *asm.Analysis, name string) (asm.Definition, bool) {
+	for _, def := range analysis.Definitions {
+		if def.Name == name {
+			return def, true
+		}
+	}
+
+	return asm.Definition{}, false
+}
+
+// describe returns the hover text of a definition.
+func describe(def asm.Definition) string {
+	switch def.Kind {
+	case asm.DefLabel:
+		return fmt.Sprintf("label `%s` at x%04X", def.Name, def.Value)
+	case asm.DefConstant:
+		return fmt.Sprintf("constant `%s` = %d (x%04X)", def.Name, def.Value, uint16(def.Value))
+	}
+
+	if def.Value == 1 {
+		return fmt.Sprintf("macro `%s` with 1 parameter", def.Name)
+	}
+
+	return fmt.Sprintf("macro `%s` with %d parameters", def.Name, def.Value)
+}
+
+// detail returns the value of a definition shown in the outline.
+func detail(def asm.Definition) string {
+	switch def.Kind {
+	case asm.DefLabel:
+		return fmt.Sprintf("x%04X", def.Value)
+	case asm.DefConstant:
+		return fmt.Sprintf("%d", def.Value)
+	}
+
+	return fmt.Sprintf("macro(%d)", def.Value)
+}
+
+// definitionRange returns the range of the name of a definition.
+func definitionRange(def asm.Definition) span {
+	start := position{Line: def.Line - 1, Character: def.Column - 1}
+	end := start
+	end.Character += len(def.Name)
+
+	return span{Start: start, End: end}
+}
+
+// uriPath returns the path of a file URI, or the URI itself if it does
+// not name a file.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+
+	return filepath.FromSlash(u.Path)
+}
+
+// pathURI returns the file URI of a path.
+func pathURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
@@ -0,0 +1,194 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// message is a request or notification sent by the client.
+type message struct {
+	// ID identifies a request, it is missing for a notification.
+	ID json.RawMessage `json:"id,omitempty"`
+
+	// Method names the request or notification.
+	Method string `json:"method"`
+
+	// Params are the method specific parameters.
+	Params json.RawMessage `json:"params"`
+}
+
+// response answers a request.
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *responseError  `json:"error,omitempty"`
+}
+
+// responseError is the error of a failed request.
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// notification is a message sent by the server on its own accord.
+type notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+const (
+	// methodNotFound is the error code of an unsupported request.
+	methodNotFound = -32601
+
+	// requestFailed is the error code of a request that failed.
+	requestFailed = -32803
+)
+
+// readMessage reads a single message framed by a Content-Length
+// header.
+func readMessage(r *bufio.Reader) (*message, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	msg := &message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// writeMessage writes a single message framed by a Content-Length
+// header.
+func writeMessage(w io.Writer, msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+
+	_, err = w.Write(body)
+
+	return err
+}
+
+// position is a position in a document, with the line and character
+// starting at 0.
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+// span is a range of a document, named so as not to shadow range.
+type span struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+// location is a range of a document.
+type location struct {
+	URI   string `json:"uri"`
+	Range span   `json:"range"`
+}
+
+// textDocumentIdentifier names a document.
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+// textDocumentPositionParams are the parameters of the requests about
+// a position in a document.
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+}
+
+// didOpenParams are the parameters of textDocument/didOpen.
+type didOpenParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+}
+
+// didChangeParams are the parameters of textDocument/didChange, whose
+// changes carry the whole text of the document.
+type didChangeParams struct {
+	TextDocument   textDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+// didSaveParams are the parameters of textDocument/didSave, the text is
+// only given by clients asked to include it.
+type didSaveParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Text         *string                `json:"text"`
+}
+
+// diagnostic is an error in a document.
+type diagnostic struct {
+	Range    span   `json:"range"`
+	Severity int    `json:"severity"`
+	Code     string `json:"code,omitempty"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+// hover is the text shown over a word.
+type hover struct {
+	Contents markupContent `json:"contents"`
+	Range    span          `json:"range"`
+}
+
+// markupContent is Markdown text.
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// documentSymbol is a name defined by a document.
+type documentSymbol struct {
+	Name           string `json:"name"`
+	Detail         string `json:"detail,omitempty"`
+	Kind           int    `json:"kind"`
+	Range          span   `json:"range"`
+	SelectionRange span   `json:"selectionRange"`
+}
+
+const (
+	// severityError is the severity of errors.
+	severityError = 1
+
+	// syncFull is the document sync kind sending the whole text on
+	// every change.
+	syncFull = 1
+
+	// symbolMethod, symbolFunction and symbolConstant are the kinds of
+	// document symbols of macros, labels and constants.
+	symbolMethod   = 6
+	symbolFunction = 12
+	symbolConstant = 14
+)
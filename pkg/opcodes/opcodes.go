@@ -3,6 +3,8 @@
 // over a set of parameters.
 package opcodes
 
+import "strings"
+
 const (
 	// OPBR specifies the "branch" opcode.
 	OPBR = iota
@@ -52,3 +54,114 @@ const (
 	// OPTRAP specifies the "executes trap" opcode.
 	OPTRAP
 )
+
+// names are the mnemonics of the opcodes of the LC-3.
+var names = [...]string{
+	OPBR:   "BR",
+	OPADD:  "ADD",
+	OPLD:   "LD",
+	OPST:   "ST",
+	OPJSR:  "JSR",
+	OPAND:  "AND",
+	OPLDR:  "LDR",
+	OPSTR:  "STR",
+	OPRTI:  "RTI",
+	OPNOT:  "NOT",
+	OPLDI:  "LDI",
+	OPSTI:  "STI",
+	OPJMP:  "JMP",
+	OPRES:  "RES",
+	OPLEA:  "LEA",
+	OPTRAP: "TRAP",
+}
+
+// aliases are the mnemonics of the forms of instructions that share
+// the opcode of another.
+var aliases = map[string]uint16{
+	"RET":  OPJMP,
+	"JSRR": OPJSR,
+}
+
+// Name returns the mnemonic of an opcode of the LC-3, such as ADD, or
+// "" if op is not an opcode.
+func Name(op uint16) string {
+	if int(op) < len(names) {
+		return names[op]
+	}
+
+	return ""
+}
+
+// Names returns the mnemonics of the opcodes of the LC-3, in the order
+// of the opcodes.
+func Names() []string {
+	return append([]string(nil), names[:]...)
+}
+
+// Lookup returns the opcode of a mnemonic of the LC-3, ignoring case.
+// RET and JSRR give the opcodes of JMP and JSR, and BR with condition
+// codes, as BRnz, the opcode of BR.
+func Lookup(mnemonic string) (uint16, bool) {
+	upper := strings.ToUpper(mnemonic)
+
+	for op, name := range names {
+		if name == upper {
+			return uint16(op), true
+		}
+	}
+
+	if op, ok := aliases[upper]; ok {
+		return op, true
+	}
+
+	if flags, ok := strings.CutPrefix(upper, "BR"); ok && strings.Trim(flags, "NZP") == "" {
+		return OPBR, true
+	}
+
+	return 0, false
+}
+
+// The opcodes of the LC-3b that differ from the LC-3.
+const (
+	// OPLDB specifies the "load byte" opcode.
+	OPLDB = OPLD
+
+	// OPSTB specifies the "store byte" opcode.
+	OPSTB = OPST
+
+	// OPLDW specifies the "load word" opcode.
+	OPLDW = OPLDR
+
+	// OPSTW specifies the "store word" opcode.
+	OPSTW = OPSTR
+
+	// OPXOR specifies the "bitwise exclusive or" opcode, NOT being
+	// XOR with -1.
+	OPXOR = OPNOT
+
+	// OPSHF specifies the "shift" opcode.
+	OPSHF = OPRES
+)
+
+// The opcodes of the LC-2 that differ from the LC-3.
+const (
+	// OPJSRR specifies the "jump to subroutine register" opcode, JMPR
+	// without its link bit.
+	OPJSRR = OPJMP
+
+	// OPRET specifies the "return from subroutine" opcode.
+	OPRET = OPRES
+)
+
+// The functions of the multiply and divide extension, on OPRES, in
+// bits 4 and 3 of its instructions.
+const (
+	// FNMUL multiplies, keeping the low 16 bits of the product.
+	FNMUL = iota
+
+	// FNDIV divides, truncating toward zero.
+	FNDIV
+
+	// FNMOD takes the remainder, of the sign of the dividend.
+	FNMOD
+)
@@ -0,0 +1,359 @@
+// Package otlp exports OpenTelemetry spans to a collector over
+// OTLP/HTTP in its JSON encoding, so that the activity of the VM shows
+// up in the observability stack of a deployment. Spans are batched and
+// sent in the background; spans that cannot be sent are dropped.
+package otlp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The kinds of spans.
+const (
+	KindInternal = 1
+	KindServer   = 2
+)
+
+// The batching of spans.
+const (
+	// batchSize is the number of spans sent at once.
+	batchSize = 512
+
+	// flushEvery is how often spans are sent.
+	flushEvery = 5 * time.Second
+
+	// maxQueued is the number of spans queued past which new spans
+	// are dropped.
+	maxQueued = 8 * batchSize
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// SpanID identifies a span.
+type SpanID [8]byte
+
+// NewTraceID returns a random trace ID.
+func NewTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+
+	return id
+}
+
+// NewSpanID returns a random span ID.
+func NewSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+
+	return id
+}
+
+// ParseTraceparent parses a W3C traceparent header, such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", returning
+// the trace and the span it names as the parent.
+func ParseTraceparent(header string) (TraceID, SpanID, bool) {
+	var (
+		trace TraceID
+		span  SpanID
+	)
+
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return trace, span, false
+	}
+
+	if n, err := hex.Decode(trace[:], []byte(parts[1])); err != nil || n != len(trace) || trace == (TraceID{}) {
+		return trace, span, false
+	}
+
+	if n, err := hex.Decode(span[:], []byte(parts[2])); err != nil || n != len(span) || span == (SpanID{}) {
+		return trace, span, false
+	}
+
+	return trace, span, true
+}
+
+// Span is an operation of a trace.
+type Span struct {
+	// Trace is the trace the span is part of.
+	Trace TraceID
+
+	// ID identifies the span.
+	ID SpanID
+
+	// Parent is the span the span is part of, zero for root spans.
+	Parent SpanID
+
+	// Name names the operation.
+	Name string
+
+	// Kind is KindInternal or KindServer.
+	Kind int
+
+	// Start and End are when the operation started and ended.
+	Start, End time.Time
+
+	// Attributes describe the operation, with values that are strings,
+	// booleans, integers or floats.
+	Attributes map[string]any
+
+	// Error describes why the operation failed, if it did.
+	Error string
+}
+
+// Exporter sends spans to a collector.
+type Exporter struct {
+	// url is the URL spans are posted to.
+	url string
+
+	// service names the service in the resource of the spans.
+	service string
+
+	// client posts the spans.
+	client *http.Client
+
+	// mu guards queued.
+	mu sync.Mutex
+
+	// queued are the spans not yet sent.
+	queued []Span
+
+	// flush is signaled when a batch is full.
+	flush chan struct{}
+
+	// done is closed to stop the exporter.
+	done chan struct{}
+
+	// stopped is closed once the exporter sent its last spans.
+	stopped chan struct{}
+}
+
+// NewExporter creates an exporter sending spans to the collector at
+// endpoint, such as http://localhost:4318, under a service name.
+func NewExporter(endpoint, service string) *Exporter {
+	e := &Exporter{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		flush:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go e.loop()
+
+	return e
+}
+
+// Export queues spans to be sent.
+func (e *Exporter) Export(spans ...Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if room := maxQueued - len(e.queued); len(spans) > room {
+		spans = spans[:max(room, 0)]
+	}
+
+	e.queued = append(e.queued, spans...)
+
+	if len(e.queued) >= batchSize {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close sends the spans queued and stops the exporter.
+func (e *Exporter) Close() {
+	close(e.done)
+	<-e.stopped
+}
+
+// loop sends the spans queued in batches until the exporter is closed.
+func (e *Exporter) loop() {
+	defer close(e.stopped)
+
+	ticker := time.NewTicker(flushEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.flush:
+		case <-e.done:
+			e.send()
+			return
+		}
+
+		e.send()
+	}
+}
+
+// send sends the spans queued.
+func (e *Exporter) send() {
+	for {
+		e.mu.Lock()
+		n := min(len(e.queued), batchSize)
+		batch := e.queued[:n:n]
+		e.queued = e.queued[n:]
+		e.mu.Unlock()
+
+		if n == 0 {
+			return
+		}
+
+		if err := e.post(batch); err != nil {
+			slog.Warn("failed to export spans", "component", "otlp", "spans", n, "err", err)
+		}
+	}
+}
+
+// post posts a batch of spans.
+func (e *Exporter) post(spans []Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+
+	return nil
+}
+
+// The messages of the OTLP JSON encoding.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []jsonSpan `json:"spans"`
+	}
+
+	scope struct {
+		Name string `json:"name"`
+	}
+
+	jsonSpan struct {
+		TraceID      string     `json:"traceId"`
+		SpanID       string     `json:"spanId"`
+		ParentSpanID string     `json:"parentSpanId,omitempty"`
+		Name         string     `json:"name"`
+		Kind         int        `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []keyValue `json:"attributes,omitempty"`
+		Status       *status    `json:"status,omitempty"`
+	}
+
+	keyValue struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+
+	status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// statusError is the status code of failed spans.
+const statusError = 2
+
+// request encodes spans as an export request.
+func (e *Exporter) request(spans []Span) exportRequest {
+	encoded := make([]jsonSpan, len(spans))
+
+	for i, span := range spans {
+		encoded[i] = jsonSpan{
+			TraceID:    hex.EncodeToString(span.Trace[:]),
+			SpanID:     hex.EncodeToString(span.ID[:]),
+			Name:       span.Name,
+			Kind:       span.Kind,
+			Start:      strconv.FormatInt(span.Start.UnixNano(), 10),
+			End:        strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes: attributes(span.Attributes),
+		}
+
+		if span.Parent != (SpanID{}) {
+			encoded[i].ParentSpanID = hex.EncodeToString(span.Parent[:])
+		}
+
+		if span.Error != "" {
+			encoded[i].Status = &status{Code: statusError, Message: span.Error}
+		}
+	}
+
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: attributes(map[string]any{"service.name": e.service})},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "lc3"}, Spans: encoded}},
+	}}}
+}
+
+// attributes encodes attributes as key values, sorted by key.
+func attributes(attrs map[string]any) []keyValue {
+	kvs := make([]keyValue, 0, len(attrs))
+
+	for key, value := range attrs {
+		var v map[string]any
+
+		switch value := value.(type) {
+		case string:
+			v = map[string]any{"stringValue": value}
+		case bool:
+			v = map[string]any{"boolValue": value}
+		case int:
+			v = map[string]any{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = map[string]any{"intValue": strconv.FormatInt(value, 10)}
+		case uint16:
+			v = map[string]any{"intValue": strconv.Itoa(int(value))}
+		case uint64:
+			v = map[string]any{"intValue": strconv.FormatUint(value, 10)}
+		case float64:
+			v = map[string]any{"doubleValue": value}
+		default:
+			v = map[string]any{"stringValue": fmt.Sprint(value)}
+		}
+
+		kvs = append(kvs, keyValue{Key: key, Value: v})
+	}
+
+	sort.Slice(kvs, func(i, j int) bool {
+		return kvs[i].Key < kvs[j].Key
+	})
+
+	return kvs
+}
@@ -0,0 +1,746 @@
+// Package pennsim runs the command scripts of PennSim, the simulator
+// many LC-3 courses grade with, so that their automation works against
+// this VM unchanged. A script assembles and loads programs, sets
+// breakpoints and registers, runs to the breakpoints and checks the
+// registers and memory it reaches:
+//
+//	as prog.asm
+//	ld prog.obj
+//	break set DONE
+//	continue
+//	check R0 x0005
+//
+// Traps are handled by the VM, so the lc3os.obj scripts load is loaded
+// like any other program but not needed. Loading a program points the
+// PC at its origin, where PennSim would boot the operating system.
+package pennsim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lc3/pkg/asm"
+	"lc3/pkg/cflags"
+	"lc3/pkg/cpu"
+	"lc3/pkg/debugger"
+	"lc3/pkg/devices"
+	"lc3/pkg/disasm"
+	"lc3/pkg/registers"
+	"lc3/pkg/symbols"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errQuit is returned by the quit command to end a script.
+var errQuit = fmt.Errorf("quit")
+
+// Session runs PennSim commands against a machine.
+type Session struct {
+	// out is where the results of commands are written.
+	out io.Writer
+
+	// console is where the program writes.
+	console io.Writer
+
+	// input is the console input of the program.
+	input *input
+
+	// dbg runs the program.
+	dbg *debugger.Debugger
+
+	// image is the memory the machine is reset to, with the programs
+	// loaded since.
+	image [cpu.MemoryMax]uint16
+
+	// table holds the labels of the programs loaded.
+	table *symbols.Table
+
+	// count is the number of instructions run since the reset.
+	count uint64
+
+	// checks counts the checks made.
+	checks int
+
+	// failed counts the checks that failed.
+	failed int
+
+	// errors counts the commands that failed.
+	errors int
+}
+
+// Option configures a session.
+type Option func(s *Session)
+
+// WithConsole sets where the program writes and the console input it
+// reads until a script gives an input file, by default nowhere and
+// none.
+func WithConsole(in io.Reader, out io.Writer) Option {
+	return func(s *Session) {
+		s.input.r = in
+		s.console = out
+	}
+}
+
+// New creates a session writing the results of commands to out.
+func New(out io.Writer, opts ...Option) *Session {
+	s := &Session{
+		out:     out,
+		console: io.Discard,
+		input:   &input{r: strings.NewReader("")},
+		table:   symbols.New(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.reset()
+
+	return s
+}
+
+// input is the console input, switched to a file by the input
+// command.
+type input struct {
+	// r is the stream read.
+	r io.Reader
+}
+
+// Read reads a byte at a time, so that readers that buffer do not take
+// input from the next reads.
+func (in *input) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	return in.r.Read(p[:1])
+}
+
+// reset clears the machine, keeping the breakpoints.
+func (s *Session) reset() {
+	var breaks []uint16
+	if s.dbg != nil {
+		for _, bp := range s.dbg.Breakpoints() {
+			breaks = append(breaks, bp.Address)
+		}
+	}
+
+	newCPU := func() cpu.CPU {
+		c := cpu.NewCPU(
+			cpu.WithInput(s.input),
+			cpu.WithOutput(s.console),
+			cpu.WithDevice(devices.NewTerminal(s.console)),
+			cpu.WithDevice(devices.NewRNG(time.Now().UnixNano())),
+			cpu.WithDevice(devices.NewRTC(time.Now)),
+		)
+
+		c.OnInstruction(func(pc, instr uint16) {
+			s.count++
+		})
+
+		return c
+	}
+
+	s.count = 0
+	s.dbg = debugger.New(newCPU, s.image, nil, io.Discard)
+	s.dbg.SetSymbols(s.table)
+
+	for _, address := range breaks {
+		s.dbg.AddBreakpoint(address)
+	}
+}
+
+// revive lets a program that halted or failed run again from where it
+// stopped, once a script loads a program or sets a register or word.
+func (s *Session) revive() {
+	if s.dbg.Running() {
+		return
+	}
+
+	snapshot := s.dbg.CPU().Snapshot()
+	snapshot.Halted = false
+
+	count := s.count
+	s.reset()
+
+	s.dbg.CPU().Restore(snapshot)
+	s.count = count
+}
+
+// Checks returns the number of checks made and of those that failed.
+func (s *Session) Checks() (made, failed int) {
+	return s.checks, s.failed
+}
+
+// Errors returns the number of commands that failed.
+func (s *Session) Errors() int {
+	return s.errors
+}
+
+// RunFile runs the commands of a script file.
+func (s *Session) RunFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	err = s.Run(file)
+	if err == errQuit {
+		return nil
+	}
+
+	return err
+}
+
+// Run runs the commands read from r, one per line, skipping blank
+// lines and comments starting with #. A command that fails is reported
+// and counted, and the script goes on as in PennSim.
+func (s *Session) Run(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := s.Exec(line); err != nil {
+			if err == errQuit {
+				return err
+			}
+
+			s.errors++
+			fmt.Fprintf(s.out, "Error: %s: %v\n", line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// commands maps the names of the commands and their abbreviations to
+// their implementation, set in init since script runs commands.
+var commands map[string]func(s *Session, args []string) error
+
+func init() {
+	commands = map[string]func(s *Session, args []string) error{
+		"as":       (*Session).assemble,
+		"ld":       (*Session).load,
+		"load":     (*Session).load,
+		"break":    (*Session).breakpoint,
+		"b":        (*Session).breakpoint,
+		"continue": (*Session).cont,
+		"c":        (*Session).cont,
+		"step":     (*Session).step,
+		"s":        (*Session).step,
+		"next":     (*Session).next,
+		"n":        (*Session).next,
+		"finish":   (*Session).finish,
+		"set":      (*Session).set,
+		"check":    (*Session).check,
+		"reset":    (*Session).resetCommand,
+		"input":    (*Session).inputFile,
+		"script":   (*Session).script,
+		"print":    (*Session).print,
+		"p":        (*Session).print,
+		"list":     (*Session).list,
+		"l":        (*Session).list,
+		"clear":    (*Session).nothing,
+		"trace":    (*Session).nothing,
+		"quit":     (*Session).quit,
+		"q":        (*Session).quit,
+	}
+}
+
+// Exec runs a command.
+func (s *Session) Exec(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	cmd, ok := commands[strings.ToLower(fields[0])]
+	if !ok {
+		return fmt.Errorf("unknown command %s", fields[0])
+	}
+
+	return cmd(s, fields[1:])
+}
+
+// wantArgs checks the number of arguments of a command.
+func wantArgs(args []string, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("got %d arguments, want %d", len(args), n)
+	}
+
+	return nil
+}
+
+// assemble assembles a source file into an object and symbol file
+// next to it.
+func (s *Session) assemble(args []string) error {
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		args = args[1:]
+	}
+
+	if err := wantArgs(args, 1); err != nil {
+		return err
+	}
+
+	dialect, _ := asm.LookupDialect("pennsim")
+
+	obj, table, diagnostics, err := asm.AssembleFile(args[0], asm.WithDialect(dialect), asm.WithIncludePath(filepath.Dir(args[0])))
+	if err == nil && len(diagnostics) > 0 {
+		err = diagnostics
+	}
+
+	if err != nil {
+		return err
+	}
+
+	base := strings.TrimSuffix(args[0], filepath.Ext(args[0]))
+
+	if err := writeFile(base+".obj", func(w io.Writer) error {
+		_, err := obj.WriteTo(w)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	if err := writeFile(base+".sym", table.Write); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(s.out, "Assembled %s into %s.obj\n", args[0], base)
+
+	return nil
+}
+
+// writeFile creates a file with what write writes.
+func writeFile(filename string, write func(w io.Writer) error) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// load loads an object file into memory, with the labels of the
+// symbol file next to it, and points the PC at its origin.
+func (s *Session) load(args []string) error {
+	if err := wantArgs(args, 1); err != nil {
+		return err
+	}
+
+	filename := args[0]
+	if filepath.Ext(filename) == "" {
+		filename += ".obj"
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	obj, err := asm.ReadObject(file)
+	if err != nil {
+		return err
+	}
+
+	s.revive()
+	c := s.dbg.CPU()
+
+	for i, word := range obj.Words {
+		address := obj.Origin + uint16(i)
+
+		s.image[address] = word
+		c.PokeMemory(address, word)
+	}
+
+	c.SetRegister(registers.RPC, obj.Origin)
+
+	if table, err := symbols.Load(strings.TrimSuffix(filename, filepath.Ext(filename)) + ".sym"); err == nil {
+		for _, label := range table.Labels() {
+			address, _ := table.Address(label)
+			s.table.Add(label, address)
+		}
+	}
+
+	fmt.Fprintf(s.out, "Loaded %s at x%04X\n", filename, obj.Origin)
+
+	return nil
+}
+
+// breakpoint sets, clears or lists breakpoints.
+func (s *Session) breakpoint(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected set, clear or list")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "set", "s":
+		if err := wantArgs(args[1:], 1); err != nil {
+			return err
+		}
+
+		address, err := s.value(args[1])
+		if err != nil {
+			return err
+		}
+
+		s.dbg.AddBreakpoint(address)
+		fmt.Fprintf(s.out, "Breakpoint set at %s\n", s.table.Format(address))
+	case "clear", "c":
+		if err := wantArgs(args[1:], 1); err != nil {
+			return err
+		}
+
+		all := strings.EqualFold(args[1], "all")
+
+		var address uint16
+		if !all {
+			var err error
+			if address, err = s.value(args[1]); err != nil {
+				return err
+			}
+		}
+
+		for _, bp := range s.dbg.Breakpoints() {
+			if all || bp.Address == address {
+				s.dbg.DeleteBreakpoint(bp.ID)
+			}
+		}
+	case "list", "l":
+		for _, bp := range s.dbg.Breakpoints() {
+			fmt.Fprintln(s.out, s.table.Format(bp.Address))
+		}
+	default:
+		return fmt.Errorf("expected set, clear or list, got %s", args[0])
+	}
+
+	return nil
+}
+
+// report describes where the program stopped.
+func (s *Session) report(stop debugger.Stop) error {
+	switch stop.Reason {
+	case debugger.StopHalt:
+		fmt.Fprintln(s.out, "Halted")
+	case debugger.StopError:
+		return stop.Err
+	case debugger.StopBreakpoint:
+		fmt.Fprintf(s.out, "Hit breakpoint at %s\n", s.table.Format(stop.PC))
+	}
+
+	return nil
+}
+
+// cont runs until a breakpoint or the program halts.
+func (s *Session) cont(args []string) error {
+	if err := wantArgs(args, 0); err != nil {
+		return err
+	}
+
+	return s.report(s.dbg.Continue())
+}
+
+// step runs an instruction, or as many as given.
+func (s *Session) step(args []string) error {
+	n := 1
+
+	if len(args) > 0 {
+		count, err := strconv.Atoi(args[0])
+		if err != nil || count < 1 {
+			return fmt.Errorf("invalid count %s", args[0])
+		}
+
+		n = count
+	}
+
+	return s.report(s.dbg.Step(n))
+}
+
+// next runs an instruction, running subroutines called to their end.
+func (s *Session) next(args []string) error {
+	if err := wantArgs(args, 0); err != nil {
+		return err
+	}
+
+	return s.report(s.dbg.Next())
+}
+
+// finish runs until the current subroutine returns.
+func (s *Session) finish(args []string) error {
+	if err := wantArgs(args, 0); err != nil {
+		return err
+	}
+
+	return s.report(s.dbg.Finish())
+}
+
+// location is a register or a memory address named in set and check.
+type location struct {
+	// register is the register, if it names one.
+	register uint16
+
+	// isRegister is set if it names a register.
+	isRegister bool
+
+	// address is the memory address otherwise.
+	address uint16
+}
+
+// locate returns the register or address a name refers to: R0 to R7,
+// PC, PSR, the condition codes of which are kept, or an address or
+// label.
+func (s *Session) locate(name string) (location, error) {
+	switch upper := strings.ToUpper(name); {
+	case upper == "PC":
+		return location{register: registers.RPC, isRegister: true}, nil
+	case upper == "PSR":
+		return location{register: registers.RCOND, isRegister: true}, nil
+	case len(upper) == 2 && upper[0] == 'R' && upper[1] >= '0' && upper[1] <= '7':
+		return location{register: uint16(upper[1] - '0'), isRegister: true}, nil
+	}
+
+	address, err := s.value(name)
+	if err != nil {
+		return location{}, err
+	}
+
+	return location{address: address}, nil
+}
+
+// get returns the value at a location.
+func (s *Session) get(l location) uint16 {
+	if l.isRegister {
+		return s.dbg.CPU().Register(l.register)
+	}
+
+	return s.dbg.CPU().PeekMemory(l.address)
+}
+
+// conditions keeps the condition codes of a PSR.
+const conditions = cflags.FLNEG | cflags.FLZRO | cflags.FLPOS
+
+// set sets a register or the word at an address.
+func (s *Session) set(args []string) error {
+	if err := wantArgs(args, 2); err != nil {
+		return err
+	}
+
+	l, err := s.locate(args[0])
+	if err != nil {
+		return err
+	}
+
+	val, err := s.value(args[1])
+	if err != nil {
+		return err
+	}
+
+	s.revive()
+	c := s.dbg.CPU()
+
+	switch {
+	case l.isRegister && l.register == registers.RCOND:
+		c.SetRegister(l.register, val&conditions)
+	case l.isRegister:
+		c.SetRegister(l.register, val)
+	default:
+		s.image[l.address] = val
+		c.PokeMemory(l.address, val)
+	}
+
+	return nil
+}
+
+// check compares a register, the word at an address or the number of
+// instructions run with a value, printing TRUE or FALSE.
+func (s *Session) check(args []string) error {
+	if err := wantArgs(args, 2); err != nil {
+		return err
+	}
+
+	s.checks++
+
+	if name := strings.ToLower(args[0]); name == "count" || name == "icount" {
+		want, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid count %s", args[1])
+		}
+
+		s.verdict(s.count == want, fmt.Sprintf("%d instructions ran", s.count))
+
+		return nil
+	}
+
+	l, err := s.locate(args[0])
+	if err != nil {
+		return err
+	}
+
+	want, err := s.value(args[1])
+	if err != nil {
+		return err
+	}
+
+	got := s.get(l)
+	if l.isRegister && l.register == registers.RCOND {
+		want &= conditions
+	}
+
+	s.verdict(got == want, fmt.Sprintf("%s is x%04X", args[0], got))
+
+	return nil
+}
+
+// verdict reports the result of a check, counting failures.
+func (s *Session) verdict(ok bool, actual string) {
+	if ok {
+		fmt.Fprintln(s.out, "TRUE")
+		return
+	}
+
+	s.failed++
+	fmt.Fprintf(s.out, "FALSE (%s)\n", actual)
+}
+
+// resetCommand clears the memory, registers and labels.
+func (s *Session) resetCommand(args []string) error {
+	if err := wantArgs(args, 0); err != nil {
+		return err
+	}
+
+	s.image = [cpu.MemoryMax]uint16{}
+	s.table = symbols.New()
+	s.reset()
+
+	return nil
+}
+
+// inputFile makes the program read its console input from a file.
+func (s *Session) inputFile(args []string) error {
+	if err := wantArgs(args, 1); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	s.input.r = strings.NewReader(string(data))
+
+	return nil
+}
+
+// script runs the commands of another script.
+func (s *Session) script(args []string) error {
+	if err := wantArgs(args, 1); err != nil {
+		return err
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return s.Run(file)
+}
+
+// print prints the registers.
+func (s *Session) print(args []string) error {
+	c := s.dbg.CPU()
+
+	fields := make([]string, 0, registers.RR7+1)
+	for r := uint16(registers.RR0); r <= registers.RR7; r++ {
+		fields = append(fields, fmt.Sprintf("R%d x%04X", r, c.Register(r)))
+	}
+
+	fmt.Fprintln(s.out, strings.Join(fields, "  "))
+	fmt.Fprintf(s.out, "PC x%04X  PSR x%04X\n", c.Register(registers.RPC), c.Register(registers.RCOND))
+
+	return nil
+}
+
+// list disassembles the instructions from the PC, or from an address.
+func (s *Session) list(args []string) error {
+	c := s.dbg.CPU()
+	address := c.Register(registers.RPC)
+
+	if len(args) > 0 {
+		a, err := s.value(args[0])
+		if err != nil {
+			return err
+		}
+
+		address = a
+	}
+
+	for i := 0; i < 10; i++ {
+		label, _ := s.table.Name(address)
+		fmt.Fprintf(s.out, "x%04X  %-12s x%04X  %s\n", address, label, c.PeekMemory(address), disasm.Format(address, c.PeekMemory(address)))
+		address++
+	}
+
+	return nil
+}
+
+// nothing accepts the commands that only change what PennSim shows.
+func (s *Session) nothing(args []string) error {
+	return nil
+}
+
+// quit ends the script.
+func (s *Session) quit(args []string) error {
+	return errQuit
+}
+
+// value parses a label, or a number as PennSim writes them, x3000 in
+// hex and #10 or 10 in decimal.
+func (s *Session) value(text string) (uint16, error) {
+	if address, ok := s.table.Address(text); ok {
+		return address, nil
+	}
+
+	var (
+		n   int64
+		err error
+	)
+
+	switch {
+	case len(text) > 1 && (text[0] == 'x' || text[0] == 'X'):
+		n, err = strconv.ParseInt(text[1:], 16, 32)
+	case len(text) > 2 && (text[:2] == "0x" || text[:2] == "0X"):
+		n, err = strconv.ParseInt(text[2:], 16, 32)
+	case strings.HasPrefix(text, "#"):
+		n, err = strconv.ParseInt(text[1:], 10, 32)
+	default:
+		n, err = strconv.ParseInt(text, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%s is neither a number nor a label", text)
+		}
+	}
+
+	if err != nil || n < math.MinInt16 || n > math.MaxUint16 {
+		return 0, fmt.Errorf("invalid value %s", text)
+	}
+
+	return uint16(n), nil
+}
@@ -0,0 +1,204 @@
+// Package plugin runs devices as plugin processes, so that third
+// parties can add devices such as printers, displays or robots
+// without recompiling the VM.
+//
+// A plugin is a program serving JSON-RPC 1.0 on its standard input and
+// output, a request per line, as net/rpc/jsonrpc does. It serves three
+// methods, passed and returning a single object:
+//
+//	Device.Addresses  {}                               {"addresses": [65040, 65042]}
+//	Device.Read       {"address": 65040}               {"value": 1}
+//	Device.Write      {"address": 65042, "value": 72}  {}
+//
+// such as, for a read:
+//
+//	{"method": "Device.Read", "params": [{"address": 65040}], "id": 3}
+//	{"id": 3, "result": {"value": 1}, "error": null}
+//
+// Errors are reported in the error field and fail the instruction
+// that accessed the device. What a plugin writes to its standard error
+// is passed through. Plugins written in Go serve a device with Serve.
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Empty is the argument of Device.Addresses and the reply of
+// Device.Write.
+type Empty struct{}
+
+// AddressesReply is the reply of Device.Addresses.
+type AddressesReply struct {
+	// Addresses are the addresses of the registers of the device.
+	Addresses []uint16 `json:"addresses"`
+}
+
+// ReadArgs are the arguments of Device.Read.
+type ReadArgs struct {
+	// Address is the address of the register read.
+	Address uint16 `json:"address"`
+}
+
+// ReadReply is the reply of Device.Read.
+type ReadReply struct {
+	// Value is the value of the register.
+	Value uint16 `json:"value"`
+}
+
+// WriteArgs are the arguments of Device.Write.
+type WriteArgs struct {
+	// Address is the address of the register written.
+	Address uint16 `json:"address"`
+
+	// Value is the value written.
+	Value uint16 `json:"value"`
+}
+
+// Device is a device served by a plugin process.
+type Device struct {
+	// name is the command of the plugin, for errors.
+	name string
+
+	// cmd is the plugin process.
+	cmd *exec.Cmd
+
+	// client calls the plugin.
+	client *rpc.Client
+
+	// addresses are the addresses of the registers of the device.
+	addresses []uint16
+}
+
+// Start starts the plugin run by command, a program and its arguments
+// separated by spaces, and asks for the addresses of its device.
+func Start(command string) (*Device, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no plugin command")
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting plugin %s: %w", args[0], err)
+	}
+
+	d := &Device{
+		name:   args[0],
+		cmd:    cmd,
+		client: jsonrpc.NewClient(pipe{stdout, stdin}),
+	}
+
+	var reply AddressesReply
+	if err := d.client.Call("Device.Addresses", Empty{}, &reply); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("plugin %s: %w", d.name, err)
+	}
+
+	d.addresses = reply.Addresses
+
+	return d, nil
+}
+
+// Addresses returns the addresses of the registers of the device.
+func (d *Device) Addresses() []uint16 {
+	return d.addresses
+}
+
+// Read reads a register of the device.
+func (d *Device) Read(address uint16) (uint16, error) {
+	var reply ReadReply
+	if err := d.client.Call("Device.Read", ReadArgs{Address: address}, &reply); err != nil {
+		return 0, fmt.Errorf("plugin %s: %w", d.name, err)
+	}
+
+	return reply.Value, nil
+}
+
+// Write writes a register of the device.
+func (d *Device) Write(address uint16, val uint16) error {
+	if err := d.client.Call("Device.Write", WriteArgs{Address: address, Value: val}, &Empty{}); err != nil {
+		return fmt.Errorf("plugin %s: %w", d.name, err)
+	}
+
+	return nil
+}
+
+// Close stops the plugin, closing its standard input and waiting for
+// it to exit.
+func (d *Device) Close() error {
+	d.client.Close()
+
+	return d.cmd.Wait()
+}
+
+// pipe joins the output and the input of a process into a connection.
+type pipe struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+// Close closes both ends of the pipe.
+func (p pipe) Close() error {
+	p.WriteCloser.Close()
+
+	return p.ReadCloser.Close()
+}
+
+// service serves a device as the Device service.
+type service struct {
+	// device is the device served.
+	device cpu.Device
+}
+
+// Addresses answers with the addresses of the registers of the device.
+func (s *service) Addresses(_ Empty, reply *AddressesReply) error {
+	reply.Addresses = s.device.Addresses()
+	return nil
+}
+
+// Read answers with the value of a register.
+func (s *service) Read(args ReadArgs, reply *ReadReply) error {
+	val, err := s.device.Read(args.Address)
+	reply.Value = val
+
+	return err
+}
+
+// Write writes a register.
+func (s *service) Write(args WriteArgs, _ *Empty) error {
+	return s.device.Write(args.Address, args.Value)
+}
+
+// Serve serves a device on the standard input and output until the VM
+// closes them, for plugins written in Go.
+func Serve(device cpu.Device) error {
+	server := rpc.NewServer()
+
+	if err := server.RegisterName("Device", &service{device: device}); err != nil {
+		return err
+	}
+
+	server.ServeCodec(jsonrpc.NewServerCodec(pipe{os.Stdin, os.Stdout}))
+
+	return nil
+}
@@ -0,0 +1,219 @@
+// Package pprof writes the instructions a program ran, by call stack,
+// as a profile in the format of pprof, so that go tool pprof and its
+// web interface and flame graphs can explore where LC-3 programs spend
+// their time.
+package pprof
+
+import (
+	"compress/gzip"
+	"io"
+	"lc3/pkg/debuginfo"
+	"lc3/pkg/stacks"
+)
+
+// Fields of the messages of profile.proto.
+const (
+	profileSampleType = 1
+	profileSample     = 2
+	profileMapping    = 3
+	profileLocation   = 4
+	profileFunction   = 5
+	profileStrings    = 6
+	profilePeriodType = 11
+	profilePeriod     = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocation = 1
+	sampleValue    = 2
+
+	mappingID          = 1
+	mappingLimit       = 3
+	mappingFilename    = 5
+	mappingFunctions   = 7
+	mappingFilenames   = 8
+	mappingLineNumbers = 9
+
+	locationID      = 1
+	locationMapping = 2
+	locationAddress = 3
+	locationLine    = 4
+
+	lineFunction = 1
+	lineLine     = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+// memorySize is the size of the single mapping of the profile, the
+// whole memory.
+const memorySize = 0x10000
+
+// writer builds a profile, numbering its strings, locations and
+// functions as they are first used.
+type writer struct {
+	// strings are the strings of the profile, the empty one first.
+	strings []string
+
+	// stringIDs maps the strings to their index.
+	stringIDs map[string]uint64
+
+	// locations are the encoded locations.
+	locations []message
+
+	// locationIDs maps frames to the ID of their location.
+	locationIDs map[stacks.Frame]uint64
+
+	// functions are the encoded functions.
+	functions []message
+
+	// functionIDs maps entries to the ID of their function.
+	functionIDs map[uint16]uint64
+
+	// name names the entry of a function.
+	name func(address uint16) string
+
+	// info maps addresses to source lines, if there is any.
+	info *debuginfo.Info
+}
+
+// Write writes the samples as a gzip-compressed pprof profile with one
+// sample type, instructions. Functions are named by name, and when info
+// is not nil, locations get the source file and line they were
+// assembled from.
+func Write(w io.Writer, samples *stacks.Samples, name func(address uint16) string, info *debuginfo.Info) error {
+	b := &writer{
+		strings:     []string{""},
+		stringIDs:   map[string]uint64{"": 0},
+		locationIDs: map[stacks.Frame]uint64{},
+		functionIDs: map[uint16]uint64{},
+		name:        name,
+		info:        info,
+	}
+
+	var profile message
+
+	profile.bytes(profileSampleType, b.valueType("instructions", "count"))
+	profile.bytes(profilePeriodType, b.valueType("instructions", "count"))
+	profile.uint(profilePeriod, 1)
+
+	var mapping message
+	mapping.uint(mappingID, 1)
+	mapping.uint(mappingLimit, memorySize)
+	mapping.uint(mappingFilename, b.string("lc3"))
+	mapping.bool(mappingFunctions, true)
+	mapping.bool(mappingFilenames, info != nil)
+	mapping.bool(mappingLineNumbers, info != nil)
+	profile.bytes(profileMapping, mapping)
+
+	samples.Each(func(stack []stacks.Frame, count uint64) {
+		ids := make([]uint64, len(stack))
+		for i, frame := range stack {
+			ids[i] = b.location(frame)
+		}
+
+		var sample message
+		sample.packed(sampleLocation, ids)
+		sample.packed(sampleValue, []uint64{count})
+		profile.bytes(profileSample, sample)
+	})
+
+	for _, location := range b.locations {
+		profile.bytes(profileLocation, location)
+	}
+
+	for _, function := range b.functions {
+		profile.bytes(profileFunction, function)
+	}
+
+	for _, s := range b.strings {
+		profile.bytes(profileStrings, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+
+	if _, err := zw.Write(profile); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// string returns the index of a string, adding it to the table.
+func (b *writer) string(s string) uint64 {
+	id, ok := b.stringIDs[s]
+	if !ok {
+		id = uint64(len(b.strings))
+		b.strings = append(b.strings, s)
+		b.stringIDs[s] = id
+	}
+
+	return id
+}
+
+// valueType encodes the type and unit of sample values.
+func (b *writer) valueType(typ, unit string) message {
+	var m message
+	m.uint(valueTypeType, b.string(typ))
+	m.uint(valueTypeUnit, b.string(unit))
+
+	return m
+}
+
+// location returns the ID of the location of a frame, adding it.
+func (b *writer) location(frame stacks.Frame) uint64 {
+	if id, ok := b.locationIDs[frame]; ok {
+		return id
+	}
+
+	id := uint64(len(b.locations) + 1)
+	b.locationIDs[frame] = id
+
+	var line message
+	line.uint(lineFunction, b.function(frame.Function))
+
+	if source, ok := b.info.Location(frame.Address); ok {
+		line.uint(lineLine, uint64(source.Line))
+	}
+
+	var m message
+	m.uint(locationID, id)
+	m.uint(locationMapping, 1)
+	m.uint(locationAddress, uint64(frame.Address))
+	m.bytes(locationLine, line)
+
+	b.locations = append(b.locations, m)
+
+	return id
+}
+
+// function returns the ID of the function at an entry, adding it.
+func (b *writer) function(entry uint16) uint64 {
+	if id, ok := b.functionIDs[entry]; ok {
+		return id
+	}
+
+	id := uint64(len(b.functions) + 1)
+	b.functionIDs[entry] = id
+
+	name := b.string(b.name(entry))
+
+	var m message
+	m.uint(functionID, id)
+	m.uint(functionName, name)
+	m.uint(functionSystemName, name)
+
+	if source, ok := b.info.Location(entry); ok {
+		m.uint(functionFilename, b.string(source.File))
+		m.uint(functionStartLine, uint64(source.Line))
+	}
+
+	b.functions = append(b.functions, m)
+
+	return id
+}
@@ -0,0 +1,46 @@
+package pprof
+
+import "encoding/binary"
+
+// message encodes a protocol buffer message, the few wire types the
+// profile format needs.
+type message []byte
+
+// tag appends the key of a field.
+func (m *message) tag(field, wireType int) {
+	*m = binary.AppendUvarint(*m, uint64(field<<3|wireType))
+}
+
+// uint appends a varint field, left out when zero.
+func (m *message) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+
+	m.tag(field, 0)
+	*m = binary.AppendUvarint(*m, v)
+}
+
+// bool appends a boolean field, left out when false.
+func (m *message) bool(field int, v bool) {
+	if v {
+		m.uint(field, 1)
+	}
+}
+
+// bytes appends a length-delimited field.
+func (m *message) bytes(field int, b []byte) {
+	m.tag(field, 2)
+	*m = binary.AppendUvarint(*m, uint64(len(b)))
+	*m = append(*m, b...)
+}
+
+// packed appends a packed repeated varint field.
+func (m *message) packed(field int, vs []uint64) {
+	var b []byte
+	for _, v := range vs {
+		b = binary.AppendUvarint(b, v)
+	}
+
+	m.bytes(field, b)
+}
@@ -0,0 +1,148 @@
+package profile
+
+import (
+	"fmt"
+	"io"
+	"lc3/pkg/cflags"
+	"lc3/pkg/cpu"
+	"lc3/pkg/disasm"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+	"sort"
+	"strconv"
+)
+
+// Branch is how a branch instruction behaved.
+type Branch struct {
+	// Address is the address of the branch.
+	Address uint16
+
+	// Instr is the branch instruction.
+	Instr uint16
+
+	// Taken is the number of times it branched.
+	Taken uint64
+
+	// NotTaken is the number of times it went on to the next address.
+	NotTaken uint64
+
+	// Conditions counts the condition codes it saw, negative, zero and
+	// positive, in that order.
+	Conditions [3]uint64
+}
+
+// Runs returns the number of times the branch ran.
+func (b *Branch) Runs() uint64 {
+	return b.Taken + b.NotTaken
+}
+
+// Branches collects how the branches of a program behave.
+type Branches struct {
+	// sites are the branches that ran by address.
+	sites map[uint16]*Branch
+}
+
+// NewBranches creates empty branch statistics.
+func NewBranches() *Branches {
+	return &Branches{sites: map[uint16]*Branch{}}
+}
+
+// Record collects how the branches run by a CPU behave.
+func (b *Branches) Record(c cpu.CPU) {
+	c.OnInstruction(func(pc, instr uint16) {
+		if instr>>12 != opcodes.OPBR {
+			return
+		}
+
+		site, ok := b.sites[pc]
+		if !ok {
+			site = &Branch{Address: pc}
+			b.sites[pc] = site
+		}
+
+		site.Instr = instr
+
+		// branches leave the condition codes alone, so they are still
+		// the ones the branch tested.
+		cond := c.Register(registers.RCOND)
+
+		if (instr>>9)&0x7&cond != 0 {
+			site.Taken++
+		} else {
+			site.NotTaken++
+		}
+
+		switch {
+		case cond&cflags.FLNEG != 0:
+			site.Conditions[0]++
+		case cond&cflags.FLZRO != 0:
+			site.Conditions[1]++
+		case cond&cflags.FLPOS != 0:
+			site.Conditions[2]++
+		}
+	})
+}
+
+// Sites returns the branches that ran, in address order.
+func (b *Branches) Sites() []Branch {
+	sites := make([]Branch, 0, len(b.sites))
+	for _, site := range b.sites {
+		sites = append(sites, *site)
+	}
+
+	sort.Slice(sites, func(i, j int) bool {
+		return sites[i].Address < sites[j].Address
+	})
+
+	return sites
+}
+
+// Write writes every branch that ran with the number of times it was
+// taken and not taken, and how often it saw each condition code:
+//
+//	Address  Instruction  Runs  Taken  Not taken  Taken%  n  z  p
+//	x300A    BRp x3004       3      2          1   66.7%  0  1  2
+func (b *Branches) Write(w io.Writer) error {
+	rows := [][]string{{"Address", "Instruction", "Runs", "Taken", "Not taken", "Taken%", "n", "z", "p"}}
+
+	for _, site := range b.Sites() {
+		rows = append(rows, []string{
+			fmt.Sprintf("x%04X", site.Address),
+			disasm.Format(site.Address, site.Instr),
+			strconv.FormatUint(site.Runs(), 10),
+			strconv.FormatUint(site.Taken, 10),
+			strconv.FormatUint(site.NotTaken, 10),
+			fmt.Sprintf("%.1f%%", 100*float64(site.Taken)/float64(site.Runs())),
+			strconv.FormatUint(site.Conditions[0], 10),
+			strconv.FormatUint(site.Conditions[1], 10),
+			strconv.FormatUint(site.Conditions[2], 10),
+		})
+	}
+
+	if len(rows) == 1 {
+		_, err := fmt.Fprintln(w, "no branches ran")
+		return err
+	}
+
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+
+	for _, row := range rows {
+		// the address and instruction are aligned left, the numbers
+		// right.
+		line := fmt.Sprintf("%-*s  %-*s", widths[0], row[0], widths[1], row[1])
+		for i := 2; i < len(row); i++ {
+			line += fmt.Sprintf("  %*s", widths[i], row[i])
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
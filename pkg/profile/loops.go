@@ -0,0 +1,114 @@
+package profile
+
+import (
+	"fmt"
+	"io"
+	"lc3/pkg/disasm"
+	"lc3/pkg/opcodes"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// Loop is a loop of a program closed by a branch back to its head.
+type Loop struct {
+	// Start is the address of the head of the loop, which the branch
+	// goes back to.
+	Start uint16
+
+	// End is the address of the branch closing the loop.
+	End uint16
+
+	// Iterations is the number of times the branch went back.
+	Iterations uint64
+
+	// Entries is the number of times the loop was entered from
+	// outside.
+	Entries uint64
+
+	// Instructions is the number of instructions run between the head
+	// and the branch, including those of nested loops.
+	Instructions uint64
+}
+
+// Trips returns the average number of times the body of the loop ran
+// each time it was entered.
+func (l Loop) Trips() float64 {
+	if l.Entries == 0 {
+		return float64(l.Iterations)
+	}
+
+	return float64(l.Iterations+l.Entries) / float64(l.Entries)
+}
+
+// Loops finds the loops of the profiled program, the branches that went
+// back at least once to an address at or before them, most expensive
+// first. word returns the instruction at an address.
+func (p *Profile) Loops(word func(address uint16) uint16) []Loop {
+	var loops []Loop
+
+	for _, address := range p.Addresses() {
+		taken := p.taken[address]
+		if taken == 0 || word(address)>>12 != opcodes.OPBR {
+			continue
+		}
+
+		target, ok := disasm.Decode(word(address)).Target(address)
+		if !ok || target > address {
+			continue
+		}
+
+		loop := Loop{Start: target, End: address, Iterations: taken}
+
+		// the head runs once per iteration and once per entry.
+		if head := p.counts[target]; head > taken {
+			loop.Entries = head - taken
+		}
+
+		for a := int(target); a <= int(address); a++ {
+			loop.Instructions += p.counts[a]
+		}
+
+		loops = append(loops, loop)
+	}
+
+	sort.SliceStable(loops, func(i, j int) bool {
+		return loops[i].Instructions > loops[j].Instructions
+	})
+
+	return loops
+}
+
+// WriteLoops writes the loops of the profiled program with their address
+// range, the number of times they were entered, their average trip count
+// and their share of the instructions run:
+//
+//	Range          Entries  Trips  Instructions   Share
+//	x3004-x300B          1  100.0           800   82.3%
+func (p *Profile) WriteLoops(w io.Writer, word func(address uint16) uint16) error {
+	loops := p.Loops(word)
+
+	if len(loops) == 0 {
+		_, err := fmt.Fprintln(w, "no loops ran")
+		return err
+	}
+
+	// numbers are right-aligned to the widest one.
+	entries, instructions := len("Entries"), len("Instructions")
+	for _, loop := range loops {
+		entries = max(entries, len(strconv.FormatUint(loop.Entries, 10)))
+		instructions = max(instructions, len(strconv.FormatUint(loop.Instructions, 10)))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Range\t%*s\t%7s\t%*s\t%6s\n", entries, "Entries", "Trips", instructions, "Instructions", "Share")
+
+	for _, loop := range loops {
+		share := 100 * float64(loop.Instructions) / float64(p.total)
+
+		fmt.Fprintf(tw, "x%04X-x%04X\t%*d\t%7.1f\t%*d\t%5.1f%%\n", loop.Start, loop.End, entries, loop.Entries, loop.Trips(), instructions, loop.Instructions, share)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,172 @@
+// Package profile counts how many times each instruction of a program
+// runs, and reads and writes these counts so that tools can annotate
+// the program with them after the run.
+package profile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/registers"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Profile holds the number of times the instruction at each address
+// ran.
+type Profile struct {
+	// counts are the execution counts by address.
+	counts [cpu.MemoryMax]uint64
+
+	// total is the number of instructions run.
+	total uint64
+
+	// words are the instruction words last run at each address.
+	words [cpu.MemoryMax]uint16
+
+	// taken are the number of times the instruction at each address
+	// jumped away rather than going on to the next address.
+	taken [cpu.MemoryMax]uint64
+}
+
+// New creates an empty profile.
+func New() *Profile {
+	return &Profile{}
+}
+
+// Record counts the instructions run by a CPU into the profile.
+func (p *Profile) Record(c cpu.CPU) {
+	c.OnInstruction(func(pc, instr uint16) {
+		p.words[pc] = instr
+		p.Add(pc, 1)
+
+		if c.Register(registers.RPC) != pc+1 {
+			p.AddTaken(pc, 1)
+		}
+	})
+}
+
+// Add adds to the count of an address.
+func (p *Profile) Add(address uint16, n uint64) {
+	p.counts[address] += n
+	p.total += n
+}
+
+// AddTaken adds to the number of times the instruction at an address
+// jumped away.
+func (p *Profile) AddTaken(address uint16, n uint64) {
+	p.taken[address] += n
+}
+
+// Taken returns the number of times the instruction at an address
+// jumped away rather than going on to the next address, as a taken
+// branch.
+func (p *Profile) Taken(address uint16) uint64 {
+	return p.taken[address]
+}
+
+// Count returns the number of times the instruction at an address ran.
+func (p *Profile) Count(address uint16) uint64 {
+	return p.counts[address]
+}
+
+// Word returns the instruction word last recorded at an address, zero
+// for a profile that was loaded rather than recorded.
+func (p *Profile) Word(address uint16) uint16 {
+	return p.words[address]
+}
+
+// Total returns the number of instructions run.
+func (p *Profile) Total() uint64 {
+	return p.total
+}
+
+// Addresses returns the addresses of the instructions that ran, in
+// order.
+func (p *Profile) Addresses() []uint16 {
+	var addresses []uint16
+
+	for address, count := range p.counts {
+		if count > 0 {
+			addresses = append(addresses, uint16(address))
+		}
+	}
+
+	return addresses
+}
+
+// Load reads a profile from a file.
+func Load(filename string) (*Profile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse reads a profile, one address per line followed by its count
+// and the number of times it jumped away, separated by tabs. Profiles
+// without the last column are accepted too.
+//
+//	// Profile
+//	// Address	Count	Taken
+//	3004	120	0
+func Parse(r io.Reader) (*Profile, error) {
+	p := New()
+
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected address, count and taken", n)
+		}
+
+		address, err := strconv.ParseUint(fields[0], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %q", n, fields[0])
+		}
+
+		count, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid count %q", n, fields[1])
+		}
+
+		p.Add(uint16(address), count)
+
+		if len(fields) == 3 {
+			taken, err := strconv.ParseUint(fields[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid taken count %q", n, fields[2])
+			}
+
+			p.AddTaken(uint16(address), taken)
+		}
+	}
+
+	return p, scanner.Err()
+}
+
+// Write writes the profile.
+func (p *Profile) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "// Profile\n")
+	fmt.Fprintf(bw, "// Address\tCount\tTaken\n")
+
+	for _, address := range p.Addresses() {
+		fmt.Fprintf(bw, "%04X\t%d\t%d\n", address, p.counts[address], p.taken[address])
+	}
+
+	return bw.Flush()
+}
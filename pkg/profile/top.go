@@ -0,0 +1,67 @@
+package profile
+
+import (
+	"fmt"
+	"io"
+	"lc3/pkg/disasm"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// Hotspot is an address and the number of times its instruction ran.
+type Hotspot struct {
+	// Address is the address of the instruction.
+	Address uint16
+
+	// Count is the number of times it ran.
+	Count uint64
+}
+
+// Top returns the n addresses whose instructions ran most often,
+// hottest first and lower addresses first among equals.
+func (p *Profile) Top(n int) []Hotspot {
+	var hotspots []Hotspot
+
+	for _, address := range p.Addresses() {
+		hotspots = append(hotspots, Hotspot{Address: address, Count: p.counts[address]})
+	}
+
+	sort.SliceStable(hotspots, func(i, j int) bool {
+		return hotspots[i].Count > hotspots[j].Count
+	})
+
+	if n < len(hotspots) {
+		hotspots = hotspots[:n]
+	}
+
+	return hotspots
+}
+
+// WriteTop writes the n hottest addresses with their count, their share
+// of the instructions run and the disassembly of the word at the
+// address, which word returns:
+//
+//	Count   Share  Address  Instruction
+//	  120   31.6%  x3004    ADD R0, R1, #15
+func (p *Profile) WriteTop(w io.Writer, n int, word func(address uint16) uint16) error {
+	hotspots := p.Top(n)
+
+	// counts are right-aligned to the widest one.
+	width := len("Count")
+	for _, hotspot := range hotspots {
+		width = max(width, len(strconv.FormatUint(hotspot.Count, 10)))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "%*s\t%6s\tAddress\tInstruction\n", width, "Count", "Share")
+
+	for _, hotspot := range hotspots {
+		share := 100 * float64(hotspot.Count) / float64(p.total)
+
+		fmt.Fprintf(tw, "%*d\t%5.1f%%\tx%04X\t%s\n", width, hotspot.Count, share, hotspot.Address, disasm.Format(hotspot.Address, word(hotspot.Address)))
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,140 @@
+// Package protowire encodes and decodes the Protocol Buffers wire
+// format, as much of it as the messages of proto/lc3.proto use:
+// varints and length-delimited fields.
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The wire types of fields.
+const (
+	// VarintType is the wire type of integers and booleans.
+	VarintType = 0
+
+	// BytesType is the wire type of messages, strings and packed
+	// repeated fields.
+	BytesType = 2
+)
+
+// ErrTruncated is returned for data that ends within a field.
+var ErrTruncated = errors.New("truncated protobuf message")
+
+// AppendTag appends the tag of a field.
+func AppendTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// AppendVarint appends a varint field, leaving it out if it is zero as
+// proto3 does.
+func AppendVarint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	return binary.AppendUvarint(AppendTag(b, field, VarintType), v)
+}
+
+// AppendBool appends a boolean field, leaving it out if it is false.
+func AppendBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+
+	return AppendVarint(b, field, 1)
+}
+
+// AppendBytes appends a length-delimited field.
+func AppendBytes(b []byte, field int, v []byte) []byte {
+	b = AppendTag(b, field, BytesType)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+
+	return append(b, v...)
+}
+
+// AppendPacked appends a packed repeated field of varints, leaving it
+// out if it is empty.
+func AppendPacked(b []byte, field int, values []uint16) []byte {
+	if len(values) == 0 {
+		return b
+	}
+
+	var packed []byte
+	for _, v := range values {
+		packed = binary.AppendUvarint(packed, uint64(v))
+	}
+
+	return AppendBytes(b, field, packed)
+}
+
+// Field is a field read from a message.
+type Field struct {
+	// Number is the number of the field.
+	Number int
+
+	// Type is the wire type of the field.
+	Type int
+
+	// Varint is the value of a varint field.
+	Varint uint64
+
+	// Bytes is the value of a length-delimited field.
+	Bytes []byte
+}
+
+// Consume reads the field at the start of b and returns it along with
+// the rest of b. Fields of other wire types than varints and
+// length-delimited ones are not supported.
+func Consume(b []byte) (Field, []byte, error) {
+	tag, n := binary.Uvarint(b)
+	if n <= 0 {
+		return Field{}, nil, ErrTruncated
+	}
+
+	f := Field{Number: int(tag >> 3), Type: int(tag & 7)}
+	b = b[n:]
+
+	switch f.Type {
+	case VarintType:
+		if f.Varint, n = binary.Uvarint(b); n <= 0 {
+			return Field{}, nil, ErrTruncated
+		}
+
+		return f, b[n:], nil
+	case BytesType:
+		length, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < length {
+			return Field{}, nil, ErrTruncated
+		}
+
+		f.Bytes = b[n : n+int(length)]
+
+		return f, b[n+int(length):], nil
+	default:
+		return Field{}, nil, fmt.Errorf("unsupported wire type %d of field %d", f.Type, f.Number)
+	}
+}
+
+// Packed decodes a packed repeated field of varints, or a single
+// unpacked one, into words.
+func (f Field) Packed() ([]uint16, error) {
+	if f.Type == VarintType {
+		return []uint16{uint16(f.Varint)}, nil
+	}
+
+	var values []uint16
+
+	for b := f.Bytes; len(b) > 0; {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, ErrTruncated
+		}
+
+		values = append(values, uint16(v))
+		b = b[n:]
+	}
+
+	return values, nil
+}
@@ -0,0 +1,477 @@
+// Package readline reads lines typed at a terminal with line editing,
+// a history kept across sessions and tab completion, for interactive
+// prompts such as the debugger's. The terminal is in raw mode only
+// while a line is read, so programs sharing it read it as before.
+//
+// The keys are those of Emacs mode in GNU Readline: the arrows, Home,
+// End and Delete, Ctrl-A and Ctrl-E to move to the start and end,
+// Ctrl-B and Ctrl-F by a character, Ctrl-P and Ctrl-N through the
+// history, Ctrl-K, Ctrl-U and Ctrl-W to delete to the end, to the start
+// and the word before the cursor, Ctrl-L to clear the screen and Ctrl-D
+// to delete the character under the cursor, or to end the input on an
+// empty line. Tab completes the word before the cursor, listing the
+// candidates when pressed twice.
+package readline
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lc3/pkg/term"
+	"os"
+	"strings"
+)
+
+// DefaultHistorySize is the number of lines kept in the history unless
+// configured otherwise.
+const DefaultHistorySize = 1000
+
+// The control keys.
+const (
+	keyCtrlA     = 0x01
+	keyCtrlB     = 0x02
+	keyCtrlC     = 0x03
+	keyCtrlD     = 0x04
+	keyCtrlE     = 0x05
+	keyCtrlF     = 0x06
+	keyCtrlH     = 0x08
+	keyTab       = 0x09
+	keyLF        = 0x0A
+	keyCtrlK     = 0x0B
+	keyCtrlL     = 0x0C
+	keyCR        = 0x0D
+	keyCtrlN     = 0x0E
+	keyCtrlP     = 0x10
+	keyCtrlU     = 0x15
+	keyCtrlW     = 0x17
+	keyEscape    = 0x1B
+	keyBackspace = 0x7F
+)
+
+// Completer returns the candidates completing the last word of the
+// line before the cursor, which may be empty.
+type Completer func(line string) []string
+
+// Editor reads lines typed at a terminal.
+type Editor struct {
+	// fd is the file descriptor of the terminal.
+	fd int
+
+	// in reads the keys typed.
+	in *bufio.Reader
+
+	// out receives the prompt and the line as it is edited.
+	out io.Writer
+
+	// complete completes words, if set.
+	complete Completer
+
+	// history are the lines read, oldest first.
+	history []string
+
+	// historySize caps the lines in the history.
+	historySize int
+
+	// historyFile keeps the history across sessions, if set.
+	historyFile string
+}
+
+// Option configures an editor.
+type Option func(e *Editor)
+
+// WithCompleter completes the word before the cursor on Tab.
+func WithCompleter(complete Completer) Option {
+	return func(e *Editor) {
+		e.complete = complete
+	}
+}
+
+// WithHistorySize keeps the last n lines in the history,
+// DefaultHistorySize by default.
+func WithHistorySize(n int) Option {
+	return func(e *Editor) {
+		e.historySize = max(n, 1)
+	}
+}
+
+// New creates an editor reading keys from in, typed at the terminal
+// with file descriptor fd, and echoing the line to out.
+func New(fd int, in *bufio.Reader, out io.Writer, opts ...Option) *Editor {
+	e := &Editor{
+		fd:          fd,
+		in:          in,
+		out:         out,
+		historySize: DefaultHistorySize,
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
+
+// LoadHistory loads the history from a file of one line per line and
+// appends the lines read from then on to it, so that the history is
+// kept across sessions. A missing file is created with the first line.
+func (e *Editor) LoadHistory(filename string) error {
+	e.historyFile = filename
+
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for _, line := range lines {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+
+	if len(e.history) <= e.historySize {
+		return nil
+	}
+
+	// rewrite the file once it grew past the history kept.
+	e.history = e.history[len(e.history)-e.historySize:]
+
+	return os.WriteFile(filename, []byte(strings.Join(e.history, "\n")+"\n"), 0o600)
+}
+
+// History returns the lines in the history, oldest first.
+func (e *Editor) History() []string {
+	return e.history
+}
+
+// remember adds a line to the history, unless it is blank or repeats
+// the last one.
+func (e *Editor) remember(line string) {
+	line = strings.TrimSpace(line)
+
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+
+	e.history = append(e.history, line)
+	if len(e.history) > e.historySize {
+		e.history = e.history[1:]
+	}
+
+	if e.historyFile == "" {
+		return
+	}
+
+	file, err := os.OpenFile(e.historyFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintln(file, line)
+	file.Close()
+}
+
+// ReadLine prints a prompt and reads a line, without its newline. It
+// returns io.EOF once the input ends or Ctrl-D is typed on an empty
+// line, leaving the cursor after the prompt.
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	state, err := term.MakeRaw(e.fd)
+	if err != nil {
+		// not a terminal after all, read the line as it comes.
+		fmt.Fprint(e.out, prompt)
+
+		line, err := e.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	defer term.Restore(e.fd, state)
+
+	l := &line{editor: e, prompt: prompt, recalled: len(e.history)}
+	l.redraw()
+
+	for {
+		key, err := e.in.ReadByte()
+		if err != nil {
+			if len(l.buf) > 0 {
+				fmt.Fprint(e.out, "\r\n")
+				break
+			}
+
+			return "", err
+		}
+
+		if key != keyTab {
+			l.tabs = 0
+		}
+
+		switch key {
+		case keyCR, keyLF:
+			l.end()
+			fmt.Fprint(e.out, "\r\n")
+
+			text := string(l.buf)
+			e.remember(text)
+
+			return text, nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			l.buf, l.pos = nil, 0
+			l.redraw()
+		case keyCtrlD:
+			if len(l.buf) == 0 {
+				return "", io.EOF
+			}
+
+			l.deleteForward()
+		case keyCtrlA:
+			l.move(0)
+		case keyCtrlE:
+			l.end()
+		case keyCtrlB:
+			l.move(l.pos - 1)
+		case keyCtrlF:
+			l.move(l.pos + 1)
+		case keyCtrlP:
+			l.recall(l.recalled - 1)
+		case keyCtrlN:
+			l.recall(l.recalled + 1)
+		case keyCtrlK:
+			l.buf = l.buf[:l.pos]
+			l.redraw()
+		case keyCtrlU:
+			l.buf = append([]byte{}, l.buf[l.pos:]...)
+			l.pos = 0
+			l.redraw()
+		case keyCtrlW:
+			l.deleteWord()
+		case keyCtrlL:
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+			l.redraw()
+		case keyCtrlH, keyBackspace:
+			if l.pos > 0 {
+				l.pos--
+				l.deleteForward()
+			}
+		case keyTab:
+			l.tabs++
+			l.completeWord()
+		case keyEscape:
+			l.escape()
+		default:
+			if key >= ' ' {
+				l.insert(key)
+			}
+		}
+	}
+
+	text := string(l.buf)
+	e.remember(text)
+
+	return text, nil
+}
+
+// line is a line being edited.
+type line struct {
+	// editor reads the line.
+	editor *Editor
+
+	// prompt is printed before the line.
+	prompt string
+
+	// buf is the text of the line.
+	buf []byte
+
+	// pos is the position of the cursor in buf.
+	pos int
+
+	// recalled is the index in the history of the line shown, the
+	// length of the history for the line being typed.
+	recalled int
+
+	// typed is the line being typed, kept while the history is shown.
+	typed []byte
+
+	// tabs counts the tabs typed in a row.
+	tabs int
+}
+
+// redraw prints the prompt and the line again, leaving the cursor at
+// its position.
+func (l *line) redraw() {
+	fmt.Fprintf(l.editor.out, "\r%s%s\x1b[K", l.prompt, l.buf)
+
+	if back := len(l.buf) - l.pos; back > 0 {
+		fmt.Fprintf(l.editor.out, "\x1b[%dD", back)
+	}
+}
+
+// move moves the cursor to a position within the line.
+func (l *line) move(pos int) {
+	l.pos = min(max(pos, 0), len(l.buf))
+	l.redraw()
+}
+
+// end moves the cursor to the end of the line.
+func (l *line) end() {
+	l.move(len(l.buf))
+}
+
+// insert inserts a character at the cursor.
+func (l *line) insert(key byte) {
+	l.buf = append(l.buf[:l.pos], append([]byte{key}, l.buf[l.pos:]...)...)
+	l.pos++
+	l.redraw()
+}
+
+// insertString inserts text at the cursor.
+func (l *line) insertString(text string) {
+	l.buf = append(l.buf[:l.pos], append([]byte(text), l.buf[l.pos:]...)...)
+	l.pos += len(text)
+	l.redraw()
+}
+
+// deleteForward deletes the character under the cursor.
+func (l *line) deleteForward() {
+	if l.pos < len(l.buf) {
+		l.buf = append(l.buf[:l.pos], l.buf[l.pos+1:]...)
+	}
+
+	l.redraw()
+}
+
+// deleteWord deletes the word before the cursor.
+func (l *line) deleteWord() {
+	start := l.pos
+	for start > 0 && l.buf[start-1] == ' ' {
+		start--
+	}
+
+	for start > 0 && l.buf[start-1] != ' ' {
+		start--
+	}
+
+	l.buf = append(l.buf[:start], l.buf[l.pos:]...)
+	l.pos = start
+	l.redraw()
+}
+
+// recall shows the line at an index of the history, or the line being
+// typed past its end.
+func (l *line) recall(index int) {
+	history := l.editor.history
+	if index < 0 || index > len(history) || index == l.recalled {
+		return
+	}
+
+	if l.recalled == len(history) {
+		l.typed = l.buf
+	}
+
+	if index == len(history) {
+		l.buf = l.typed
+	} else {
+		l.buf = []byte(history[index])
+	}
+
+	l.recalled = index
+	l.pos = len(l.buf)
+	l.redraw()
+}
+
+// escape handles the escape sequences of the arrows, Home, End and
+// Delete.
+func (l *line) escape() {
+	in := l.editor.in
+
+	next, err := in.ReadByte()
+	if err != nil || (next != '[' && next != 'O') {
+		return
+	}
+
+	key, err := in.ReadByte()
+	if err != nil {
+		return
+	}
+
+	// sequences such as ESC [ 3 ~ end with a tilde.
+	if key >= '0' && key <= '9' {
+		for {
+			b, err := in.ReadByte()
+			if err != nil || b == '~' {
+				break
+			}
+		}
+	}
+
+	switch key {
+	case 'A':
+		l.recall(l.recalled - 1)
+	case 'B':
+		l.recall(l.recalled + 1)
+	case 'C':
+		l.move(l.pos + 1)
+	case 'D':
+		l.move(l.pos - 1)
+	case 'H', '1', '7':
+		l.move(0)
+	case 'F', '4', '8':
+		l.end()
+	case '3':
+		l.deleteForward()
+	}
+}
+
+// completeWord completes the word before the cursor with the longest
+// prefix shared by its candidates, listing them on the second tab if
+// there is more than one.
+func (l *line) completeWord() {
+	if l.editor.complete == nil {
+		return
+	}
+
+	before := string(l.buf[:l.pos])
+	word := before[strings.LastIndexByte(before, ' ')+1:]
+
+	candidates := l.editor.complete(before)
+	if len(candidates) == 0 {
+		return
+	}
+
+	if len(candidates) == 1 {
+		l.insertString(strings.TrimPrefix(candidates[0], word) + " ")
+		return
+	}
+
+	if prefix := commonPrefix(candidates); len(prefix) > len(word) {
+		l.insertString(strings.TrimPrefix(prefix, word))
+		return
+	}
+
+	if l.tabs < 2 {
+		return
+	}
+
+	fmt.Fprintf(l.editor.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	l.redraw()
+}
+
+// commonPrefix returns the longest prefix shared by words.
+func commonPrefix(words []string) string {
+	prefix := words[0]
+
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	return prefix
+}
@@ -5,6 +5,8 @@
 // has 10 total registers.
 package registers
 
+import "strings"
+
 const (
 	// RR0 is the 0-index general purpose register.
 	RR0 = iota
@@ -40,6 +42,44 @@ const (
 	RCOUNT
 )
 
+// names are the names of the registers.
+var names = [RCOUNT]string{
+	RR0:   "R0",
+	RR1:   "R1",
+	RR2:   "R2",
+	RR3:   "R3",
+	RR4:   "R4",
+	RR5:   "R5",
+	RR6:   "R6",
+	RR7:   "R7",
+	RPC:   "PC",
+	RCOND: "CC",
+}
+
+// Name returns the name of a register, R0 to R7, PC or CC for the
+// condition codes, or "" if r is not a register.
+func Name(r uint16) string {
+	if r < RCOUNT {
+		return names[r]
+	}
+
+	return ""
+}
+
+// Lookup returns the index of a register named R0 to R7, PC or CC,
+// ignoring case.
+func Lookup(name string) (uint16, bool) {
+	upper := strings.ToUpper(name)
+
+	for r, n := range names {
+		if n == upper {
+			return uint16(r), true
+		}
+	}
+
+	return 0, false
+}
+
 var registers [RCOUNT]uint16
 
 // Get returns the registers that are available for
@@ -56,4 +96,102 @@ const (
 	// MRKBDR is a memory mapped register used to interact with the
 	// keyboard data.
 	MRKBDR = 0xFE02
+
+	// MRRTCUP is a memory mapped register holding the number of
+	// seconds since the machine booted, wrapping at 16 bits.
+	MRRTCUP = 0xFE10
+
+	// MRRTCHR is a memory mapped register holding the hour of the
+	// host wall clock.
+	MRRTCHR = 0xFE12
+
+	// MRRTCMN is a memory mapped register holding the minute of the
+	// host wall clock.
+	MRRTCMN = 0xFE14
+
+	// MRRTCSC is a memory mapped register holding the second of the
+	// host wall clock.
+	MRRTCSC = 0xFE16
+
+	// MRMBSR is a memory mapped register used to interact with the
+	// mailbox status.
+	MRMBSR = 0xFE18
+
+	// MRMBDR is a memory mapped register used to interact with the
+	// mailbox data.
+	MRMBDR = 0xFE1A
+
+	// MRTCCOL is a memory mapped register holding the terminal cursor
+	// column used by the move command.
+	MRTCCOL = 0xFE1C
+
+	// MRTCROW is a memory mapped register holding the terminal cursor
+	// row used by the move command.
+	MRTCROW = 0xFE1E
+
+	// MRTCCLR is a memory mapped register holding the terminal colors
+	// used by the color command, foreground in the low byte and
+	// background in the high byte.
+	MRTCCLR = 0xFE20
+
+	// MRTCCMD is a memory mapped register that executes a terminal
+	// control command when written.
+	MRTCCMD = 0xFE22
+
+	// MRJOYSR is a memory mapped register used to interact with the
+	// joystick button state.
+	MRJOYSR = 0xFE24
+
+	// MRRNG is a memory mapped register holding a pseudo-random
+	// number, a new one on every read, and seeding the generator when
+	// written.
+	MRRNG = 0xFE26
+
+	// MRMMUCR is a memory mapped register controlling the MMU, bit 15
+	// turning address translation on.
+	MRMMUCR = 0xFE28
+
+	// MRPTBR is a memory mapped register holding the physical address
+	// of the page table of the MMU.
+	MRPTBR = 0xFE2A
+
+	// MRMMUFA is a memory mapped register holding the virtual address
+	// of the last page fault.
+	MRMMUFA = 0xFE2C
+
+	// MRMMUPC is a memory mapped register holding the address of the
+	// instruction of the last page fault, which RTI resumes.
+	MRMMUPC = 0xFE2E
+
+	// MRFPA is a memory mapped register holding operand A of the FPU,
+	// or its high word in single precision.
+	MRFPA = 0xFE34
+
+	// MRFPAL is a memory mapped register holding the low word of
+	// operand A of the FPU in single precision.
+	MRFPAL = 0xFE36
+
+	// MRFPB is a memory mapped register holding operand B of the FPU,
+	// or its high word in single precision.
+	MRFPB = 0xFE38
+
+	// MRFPBL is a memory mapped register holding the low word of
+	// operand B of the FPU in single precision.
+	MRFPBL = 0xFE3A
+
+	// MRFPOP is a memory mapped register running an operation of the
+	// FPU when written.
+	MRFPOP = 0xFE3C
+
+	// MRFPR is a memory mapped register holding the result of the FPU,
+	// or its high word in single precision.
+	MRFPR = 0xFE3E
+
+	// MRFPRL is a memory mapped register holding the low word of the
+	// result of the FPU in single precision.
+	MRFPRL = 0xFE40
+
+	// MRFPSR is a memory mapped register holding the status of the
+	// last operation of the FPU.
+	MRFPSR = 0xFE42
 )
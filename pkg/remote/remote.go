@@ -0,0 +1,280 @@
+// Package remote exposes a debugging session over WebSocket, so that
+// browser based front-ends can drive and observe it.
+//
+// Every text message sent by a client is a debugger command line, the
+// message "interrupt" stops a running program instead. Clients that
+// connect with ?mode=observe may only watch. The server sends JSON
+// messages to every client:
+//
+//	{"type": "output", "text": "Breakpoint 1 at x3004 <SUB>\n..."}
+//	{"type": "console", "text": "Hello, World!"}
+//	{"type": "error", "text": "unknown command \"foo\", try \"help\""}
+//	{"type": "state", "running": true, "pc": "x3004", "location": "SUB", ...}
+//
+// A state message is sent when a client connects and after every
+// command, so that all clients follow the session.
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"lc3/pkg/debugger"
+	"lc3/pkg/registers"
+	"lc3/pkg/websocket"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Interrupt is the message that stops a running program.
+const Interrupt = "interrupt"
+
+// Message is a message sent to clients.
+type Message struct {
+	// Type is "output", "console", "error" or "state".
+	Type string `json:"type"`
+
+	// Text is the output or error of a command, or output of the
+	// program.
+	Text string `json:"text,omitempty"`
+
+	// State is the state of the session for state messages.
+	*State
+}
+
+// State summarizes the state of a debugging session.
+type State struct {
+	// Running is set if the program can be resumed.
+	Running bool `json:"running"`
+
+	// PC is the program counter.
+	PC string `json:"pc"`
+
+	// Location is the PC rendered as LABEL+OFFSET.
+	Location string `json:"location"`
+
+	// Registers maps register names, including PC and CC, to values.
+	Registers map[string]string `json:"registers"`
+
+	// Stack lists the locations of the active calls, innermost first.
+	Stack []string `json:"stack"`
+}
+
+// Server serves a debugging session to WebSocket clients.
+type Server struct {
+	// dbg is the debugger controlling the program.
+	dbg *debugger.Debugger
+
+	// commandMu serializes commands.
+	commandMu sync.Mutex
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// clients are the connected clients.
+	clients map[*websocket.Conn]bool
+
+	// output collects the output of the current command.
+	output bytes.Buffer
+}
+
+// NewServer creates a server, which serves the session once its
+// debugger has been set.
+func NewServer() *Server {
+	return &Server{
+		clients: map[*websocket.Conn]bool{},
+	}
+}
+
+// SetDebugger sets the debugger of the session, which should write its
+// output to Output.
+func (s *Server) SetDebugger(dbg *debugger.Debugger) {
+	s.dbg = dbg
+}
+
+// Output returns the writer the debugger writes command output to.
+func (s *Server) Output() io.Writer {
+	return sessionOutput{s}
+}
+
+// Console returns a writer broadcasting program output to clients.
+func (s *Server) Console() io.Writer {
+	return consoleOutput{s}
+}
+
+// ServeHTTP upgrades a request to a WebSocket connection and serves
+// the session on it until the client disconnects.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	observer := r.URL.Query().Get("mode") == "observe"
+
+	s.mu.Lock()
+	s.clients[conn] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, conn)
+		s.mu.Unlock()
+	}()
+
+	s.commandMu.Lock()
+	state := s.state()
+	s.commandMu.Unlock()
+
+	if err := send(conn, Message{Type: "state", State: state}); err != nil {
+		return
+	}
+
+	// commands run in order on a worker, so that the client can still
+	// interrupt a command that takes long.
+	lines := make(chan string, 16)
+	defer close(lines)
+
+	go func() {
+		for line := range lines {
+			if s.Exec(line) == debugger.ErrQuit {
+				conn.Close()
+			}
+		}
+	}()
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			if err != websocket.ErrClosed && err != io.EOF {
+				slog.Warn("remote debugging client failed", "component", "remote", "err", err)
+			}
+
+			return
+		}
+
+		line := strings.TrimSpace(string(data))
+
+		switch {
+		case observer:
+			send(conn, Message{Type: "error", Text: "observers cannot run commands"})
+		case line == Interrupt:
+			s.dbg.Interrupt()
+		default:
+			lines <- line
+		}
+	}
+}
+
+// Exec executes a command line and broadcasts its output and the new
+// state of the session to every client. It returns the error of the
+// command.
+func (s *Server) Exec(line string) error {
+	s.commandMu.Lock()
+	defer s.commandMu.Unlock()
+
+	err := s.dbg.Exec(line)
+
+	s.mu.Lock()
+	output := s.output.String()
+	s.output.Reset()
+	s.mu.Unlock()
+
+	if output != "" {
+		s.broadcast(Message{Type: "output", Text: output})
+	}
+
+	if err != nil && err != debugger.ErrQuit {
+		s.broadcast(Message{Type: "error", Text: err.Error()})
+	}
+
+	s.broadcast(Message{Type: "state", State: s.state()})
+
+	return err
+}
+
+// state summarizes the state of the session, the caller must hold
+// commandMu.
+func (s *Server) state() *State {
+	cpu := s.dbg.CPU()
+	table := s.dbg.Symbols()
+
+	pc := cpu.Register(registers.RPC)
+
+	state := &State{
+		Running:   s.dbg.Running(),
+		PC:        fmt.Sprintf("x%04X", pc),
+		Location:  table.Format(pc),
+		Registers: map[string]string{},
+	}
+
+	for r := uint16(registers.RR0); r <= registers.RR7; r++ {
+		state.Registers[fmt.Sprintf("R%d", r)] = fmt.Sprintf("x%04X", cpu.Register(r))
+	}
+
+	state.Registers["PC"] = state.PC
+	state.Registers["CC"] = debugger.ConditionCodes(cpu.Register(registers.RCOND))
+
+	state.Stack = append(state.Stack, state.Location)
+
+	frames := s.dbg.Stack()
+	for i := len(frames) - 1; i >= 0; i-- {
+		state.Stack = append(state.Stack, table.Format(frames[i].Call))
+	}
+
+	return state
+}
+
+// broadcast sends a message to every client.
+func (s *Server) broadcast(msg Message) {
+	s.mu.Lock()
+	clients := make([]*websocket.Conn, 0, len(s.clients))
+	for conn := range s.clients {
+		clients = append(clients, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range clients {
+		send(conn, msg)
+	}
+}
+
+// send sends a message to a single client.
+func send(conn *websocket.Conn, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return conn.WriteMessage(data)
+}
+
+// sessionOutput collects debugger output until the command completes.
+type sessionOutput struct {
+	server *Server
+}
+
+// Write appends to the output of the current command.
+func (o sessionOutput) Write(b []byte) (int, error) {
+	o.server.mu.Lock()
+	defer o.server.mu.Unlock()
+
+	return o.server.output.Write(b)
+}
+
+// consoleOutput broadcasts program output.
+type consoleOutput struct {
+	server *Server
+}
+
+// Write broadcasts b as console output.
+func (o consoleOutput) Write(b []byte) (int, error) {
+	o.server.broadcast(Message{Type: "console", Text: string(b)})
+
+	return len(b), nil
+}
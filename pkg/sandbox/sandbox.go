@@ -0,0 +1,433 @@
+// Package sandbox runs untrusted programs, such as student code
+// submitted to a service, with caps on the instructions they run, the
+// output they write, the I/O they make and the wall-clock time they
+// take. A run that hits a cap is stopped and reported as such, apart
+// from programs that fail on their own.
+package sandbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/devices"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+	"lc3/pkg/traps"
+	"time"
+)
+
+// Limit is a cap on a run.
+type Limit int
+
+const (
+	// None is reported by runs that hit no limit.
+	None Limit = iota
+
+	// Instructions caps the instructions run.
+	Instructions
+
+	// Output caps the bytes written to the console.
+	Output
+
+	// IO caps the console traps, host calls and device accesses.
+	IO
+
+	// Time caps the wall-clock time of the run.
+	Time
+)
+
+// limitNames are the names of the limits.
+var limitNames = map[Limit]string{
+	None:         "none",
+	Instructions: "instructions",
+	Output:       "output",
+	IO:           "io",
+	Time:         "time",
+}
+
+// String names the limit.
+func (l Limit) String() string {
+	return limitNames[l]
+}
+
+// The limits of a run unless configured otherwise.
+const (
+	DefaultMaxInstructions = 10_000_000
+	DefaultMaxOutput       = 64 << 10
+	DefaultMaxIO           = 100_000
+	DefaultTimeout         = 5 * time.Second
+)
+
+// checkEvery is how many instructions run between checks of the clock.
+const checkEvery = 1024
+
+// ioTraps are the trap vectors that reach the host.
+var ioTraps = map[uint16]bool{
+	traps.GETC:     true,
+	traps.OUT:      true,
+	traps.PUTS:     true,
+	traps.IN:       true,
+	traps.PUTSP:    true,
+	traps.HOSTCALL: true,
+}
+
+// errOutputLimit stops a program writing past the output limit.
+var errOutputLimit = errors.New("output limit exceeded")
+
+// ErrPaused is read by the consoles of paused runs, so that programs
+// waiting for a key stop.
+var ErrPaused = errors.New("run paused")
+
+// sandbox holds the limits and the setup of a run.
+type sandbox struct {
+	// maxInstructions caps the instructions run, 0 for no cap.
+	maxInstructions uint64
+
+	// maxOutput caps the bytes of output, 0 for no cap.
+	maxOutput int
+
+	// maxIO caps the I/O operations, 0 for no cap.
+	maxIO uint64
+
+	// timeout caps the wall-clock time, 0 for no cap.
+	timeout time.Duration
+
+	// input are the keystrokes typed into the program.
+	input []devices.KeyEvent
+
+	// console is read by console traps past the input, if set.
+	console io.Reader
+
+	// echo receives the output as it is written, if set.
+	echo io.Writer
+
+	// cpuOptions configure the CPU further.
+	cpuOptions []cpu.Option
+
+	// setups are called with the CPU before the program runs.
+	setups []func(c cpu.CPU)
+
+	// pause stops the program once closed, if set.
+	pause <-chan struct{}
+
+	// trapHooks are called after every trap.
+	trapHooks []func(vector uint16, start time.Time, err error)
+}
+
+// Option configures a run.
+type Option func(s *sandbox)
+
+// WithMaxInstructions stops the program after n instructions,
+// DefaultMaxInstructions by default. 0 lifts the cap.
+func WithMaxInstructions(n uint64) Option {
+	return func(s *sandbox) {
+		s.maxInstructions = n
+	}
+}
+
+// WithMaxOutput stops the program once it writes more than n bytes to
+// the console, DefaultMaxOutput by default. 0 lifts the cap.
+func WithMaxOutput(n int) Option {
+	return func(s *sandbox) {
+		s.maxOutput = n
+	}
+}
+
+// WithMaxIO stops the program once it makes more than n console
+// traps, host calls and device accesses, DefaultMaxIO by default. 0
+// lifts the cap.
+func WithMaxIO(n uint64) Option {
+	return func(s *sandbox) {
+		s.maxIO = n
+	}
+}
+
+// WithTimeout stops the program after running for d, DefaultTimeout by
+// default. 0 lifts the cap.
+func WithTimeout(d time.Duration) Option {
+	return func(s *sandbox) {
+		s.timeout = d
+	}
+}
+
+// WithInput types s into the console of the program, which reads
+// io.EOF past its end instead of waiting for more.
+func WithInput(input string) Option {
+	return func(s *sandbox) {
+		for i := 0; i < len(input); i++ {
+			s.input = append(s.input, devices.KeyEvent{Key: input[i]})
+		}
+	}
+}
+
+// WithConsole makes GETC and IN wait for keys from in once the input
+// is typed, rather than reading io.EOF, and copies the output within
+// the output limit to out as the program writes it, for programs run
+// interactively. Programs polling the keyboard registers see only the
+// input.
+func WithConsole(in io.Reader, out io.Writer) Option {
+	return func(s *sandbox) {
+		s.console = in
+		s.echo = out
+	}
+}
+
+// WithCPUOptions configures the CPU further, to attach devices or
+// register host calls.
+func WithCPUOptions(opts ...cpu.Option) Option {
+	return func(s *sandbox) {
+		s.cpuOptions = append(s.cpuOptions, opts...)
+	}
+}
+
+// WithSetup calls fn with the CPU before the program runs, to attach
+// profilers or tracers or to point the PC at another origin.
+func WithSetup(fn func(c cpu.CPU)) Option {
+	return func(s *sandbox) {
+		s.setups = append(s.setups, fn)
+	}
+}
+
+// WithPause stops the program before its next instruction once pause
+// is closed, reporting the run as paused so that it can be resumed
+// from the state of its CPU. A program waiting for a key from the
+// console is stopped before the trap reading it once the console reads
+// ErrPaused.
+func WithPause(pause <-chan struct{}) Option {
+	return func(s *sandbox) {
+		s.pause = pause
+	}
+}
+
+// WithTrapHook calls fn after every trap with its vector, when it
+// started and the error it failed with, if any, to time the traps.
+func WithTrapHook(fn func(vector uint16, start time.Time, err error)) Option {
+	return func(s *sandbox) {
+		s.trapHooks = append(s.trapHooks, fn)
+	}
+}
+
+// RunResult is the outcome of a run.
+type RunResult struct {
+	// Halted reports whether the program halted.
+	Halted bool
+
+	// Paused reports whether the program was paused.
+	Paused bool
+
+	// Exceeded is the limit that stopped the program, None if no limit
+	// did.
+	Exceeded Limit
+
+	// Err is the error that stopped the program, if it failed on its
+	// own. It is io.EOF if the program read past its input.
+	Err error
+
+	// Instructions counts the instructions the program ran.
+	Instructions uint64
+
+	// Output is the console output of the program, up to the output
+	// limit.
+	Output string
+
+	// IO counts the console traps, host calls and device accesses the
+	// program made.
+	IO uint64
+
+	// Elapsed is the wall-clock time of the run.
+	Elapsed time.Duration
+
+	// CPU is the CPU the program ran on, left as the program left it.
+	CPU cpu.CPU
+}
+
+// Status describes how the run ended, as halted, paused, failed: ERROR
+// or exceeded the output limit.
+func (r *RunResult) Status() string {
+	switch {
+	case r.Halted:
+		return "halted"
+	case r.Paused:
+		return "paused"
+	case r.Exceeded != None:
+		return fmt.Sprintf("exceeded the %s limit", r.Exceeded)
+	case r.Err != nil:
+		return fmt.Sprintf("failed: %v", r.Err)
+	default:
+		return "stopped"
+	}
+}
+
+// limitedWriter collects output up to a maximum number of bytes.
+type limitedWriter struct {
+	// buf holds the output.
+	buf bytes.Buffer
+
+	// max is the maximum number of bytes, 0 for no maximum.
+	max int
+
+	// echo receives the bytes kept, if set.
+	echo io.Writer
+}
+
+// Write writes as much of p as fits, failing with errOutputLimit if it
+// does not all fit.
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	var err error
+
+	if w.max > 0 && w.buf.Len()+len(p) > w.max {
+		p, err = p[:w.max-w.buf.Len()], errOutputLimit
+	}
+
+	w.buf.Write(p)
+
+	if w.echo != nil {
+		w.echo.Write(p)
+	}
+
+	return len(p), err
+}
+
+// Run runs a memory image from x3000, or wherever a setup points the
+// PC, within the limits.
+func Run(image [cpu.MemoryMax]uint16, opts ...Option) *RunResult {
+	s := &sandbox{
+		maxInstructions: DefaultMaxInstructions,
+		maxOutput:       DefaultMaxOutput,
+		maxIO:           DefaultMaxIO,
+		timeout:         DefaultTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	out := &limitedWriter{max: s.maxOutput, echo: s.echo}
+	keyboard := devices.NewScriptedKeyboard(s.input, nil)
+
+	input := keyboard.Input()
+	if s.console != nil {
+		input = io.MultiReader(input, s.console)
+	}
+
+	c := cpu.NewCPU(append([]cpu.Option{
+		cpu.WithDevice(devices.NewTerminal(out)),
+		cpu.WithDevice(keyboard),
+		cpu.WithInput(input),
+		cpu.WithOutput(out),
+	}, s.cpuOptions...)...)
+
+	result := &RunResult{CPU: c}
+
+	owned := c.Devices()
+
+	c.OnMemoryAccess(func(access cpu.MemoryAccess) {
+		if _, ok := owned[access.Address]; ok {
+			result.IO++
+		}
+	})
+
+	c.OnInstruction(func(pc, instr uint16) {
+		if instr>>12 == opcodes.OPTRAP && ioTraps[instr&0xFF] {
+			result.IO++
+		}
+	})
+
+	c.Load(image)
+
+	for _, setup := range s.setups {
+		setup(c)
+	}
+
+	start := time.Now()
+
+	for !c.Halted() {
+		if s.maxInstructions > 0 && result.Instructions >= s.maxInstructions {
+			result.Exceeded = Instructions
+			break
+		}
+
+		if s.timeout > 0 && result.Instructions%checkEvery == 0 && time.Since(start) > s.timeout {
+			result.Exceeded = Time
+			break
+		}
+
+		if s.paused() {
+			result.Paused = true
+			break
+		}
+
+		pc, r7 := c.Register(registers.RPC), c.Register(registers.RR7)
+
+		var (
+			vector    uint16
+			trapStart time.Time
+		)
+
+		if len(s.trapHooks) > 0 {
+			if instr := c.PeekMemory(pc); instr>>12 == opcodes.OPTRAP {
+				vector, trapStart = instr&0xFF, time.Now()
+			}
+		}
+
+		err := c.Execute()
+
+		if !trapStart.IsZero() {
+			for _, hook := range s.trapHooks {
+				hook(vector, trapStart, err)
+			}
+		}
+
+		if errors.Is(err, ErrPaused) {
+			// undo the trap waiting for the key, so that it runs again
+			// once resumed.
+			c.SetRegister(registers.RPC, pc)
+			c.SetRegister(registers.RR7, r7)
+
+			result.Paused = true
+
+			break
+		}
+
+		if errors.Is(err, errOutputLimit) {
+			result.Exceeded = Output
+			break
+		}
+
+		if err != nil && s.timeout > 0 && time.Since(start) > s.timeout {
+			// the program was waiting for keys past its time.
+			result.Exceeded = Time
+			break
+		}
+
+		if err != nil {
+			result.Err = err
+			break
+		}
+
+		result.Instructions++
+
+		if s.maxIO > 0 && result.IO > s.maxIO {
+			result.Exceeded = IO
+			break
+		}
+	}
+
+	result.Elapsed = time.Since(start)
+	result.Halted = c.Halted()
+	result.Output = out.buf.String()
+
+	return result
+}
+
+// paused reports whether the run was paused.
+func (s *sandbox) paused() bool {
+	select {
+	case <-s.pause:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,440 @@
+// Package script runs instrumentation scripts written in Starlark
+// against a running CPU. A script registers functions called after
+// every instruction or memory access, functions handling traps in
+// place of the built-in handlers and functions called when the run
+// ends, and inspects and changes the machine through the functions it
+// is given.
+package script
+
+import (
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/disasm"
+	"lc3/pkg/registers"
+	"lc3/pkg/starlark"
+	"os"
+	"strings"
+)
+
+// Script is a loaded script with the hooks it registered.
+type Script struct {
+	// thread runs the functions of the script.
+	thread *starlark.Thread
+
+	// out is where output writes.
+	out io.Writer
+
+	// instructionHooks are called after every instruction.
+	instructionHooks []starlark.Value
+
+	// memoryHooks are called after every memory access.
+	memoryHooks []starlark.Value
+
+	// traps maps trap vectors to their handlers.
+	traps map[uint16]starlark.Value
+
+	// exitHooks are called when the run ends.
+	exitHooks []starlark.Value
+
+	// cpu is the CPU the script is attached to.
+	cpu cpu.CPU
+
+	// err is the first error of a hook.
+	err error
+}
+
+// Load runs the script in a file, writing what it prints to stderr
+// and what it outputs to out, the console of the program.
+func Load(filename string, out io.Writer) (*Script, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(filename, string(src), out)
+}
+
+// New runs a script, writing what it prints to stderr and what it
+// outputs to out.
+func New(filename, src string, out io.Writer) (*Script, error) {
+	s := &Script{
+		thread: starlark.NewThread(),
+		out:    out,
+		traps:  map[uint16]starlark.Value{},
+	}
+
+	if _, err := s.thread.ExecFile(filename, src, s.predeclared()); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Options returns the options installing the trap handlers of the
+// script into a CPU.
+func (s *Script) Options() []cpu.Option {
+	var opts []cpu.Option
+
+	for vector, fn := range s.traps {
+		fn := fn
+
+		opts = append(opts, cpu.WithTrap(vector, func(c cpu.CPU) error {
+			_, err := s.thread.Call(fn)
+			return err
+		}))
+	}
+
+	return opts
+}
+
+// Attach calls the hooks of the script from a CPU, which the functions
+// of the script then act on. An error of a hook halts the CPU and is
+// reported by Err.
+func (s *Script) Attach(c cpu.CPU) {
+	s.cpu = c
+
+	if len(s.instructionHooks) > 0 {
+		c.OnInstruction(func(pc, instr uint16) {
+			for _, fn := range s.instructionHooks {
+				s.call(fn, starlark.Int(pc), starlark.Int(instr))
+			}
+		})
+	}
+
+	if len(s.memoryHooks) > 0 {
+		c.OnMemoryAccess(func(access cpu.MemoryAccess) {
+			for _, fn := range s.memoryHooks {
+				s.call(fn, starlark.Int(access.PC), starlark.Int(access.Address), starlark.Int(access.Value), starlark.Bool(access.Write))
+			}
+		})
+	}
+}
+
+// call calls a hook unless one has failed, halting the CPU on failure.
+func (s *Script) call(fn starlark.Value, args ...starlark.Value) {
+	if s.err != nil {
+		return
+	}
+
+	if _, err := s.thread.Call(fn, args...); err != nil {
+		s.err = err
+		s.cpu.SetHalted(true)
+	}
+}
+
+// Err returns the error of the first hook that failed, if any.
+func (s *Script) Err() error {
+	return s.err
+}
+
+// Exit calls the functions the script registered for the end of the
+// run.
+func (s *Script) Exit() error {
+	for _, fn := range s.exitHooks {
+		if _, err := s.thread.Call(fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// predeclared returns the functions scripts are given.
+func (s *Script) predeclared() map[string]starlark.Value {
+	fns := map[string]func(args starlark.Tuple) (starlark.Value, error){
+		"on_instruction": s.register(&s.instructionHooks),
+		"on_memory":      s.register(&s.memoryHooks),
+		"on_exit":        s.register(&s.exitHooks),
+		"on_trap":        s.onTrap,
+		"reg":            s.reg,
+		"set_reg":        s.setReg,
+		"peek":           s.peek,
+		"poke":           s.poke,
+		"read_string":    s.readString,
+		"output":         s.output,
+		"disasm":         s.disasm,
+		"halt":           s.halt,
+	}
+
+	predeclared := make(map[string]starlark.Value, len(fns))
+	for name, fn := range fns {
+		predeclared[name] = starlark.NewBuiltin(name, func(args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if len(kwargs) > 0 {
+				return nil, fmt.Errorf("unexpected keyword argument %s", kwargs[0][0])
+			}
+
+			return fn(args)
+		})
+	}
+
+	return predeclared
+}
+
+// wantArgs checks the number of arguments of a call.
+func wantArgs(args starlark.Tuple, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("got %d arguments, want %d", len(args), n)
+	}
+
+	return nil
+}
+
+// register returns a function adding a hook to a list.
+func (s *Script) register(hooks *[]starlark.Value) func(args starlark.Tuple) (starlark.Value, error) {
+	return func(args starlark.Tuple) (starlark.Value, error) {
+		if err := wantArgs(args, 1); err != nil {
+			return nil, err
+		}
+
+		*hooks = append(*hooks, args[0])
+
+		return args[0], nil
+	}
+}
+
+// onTrap registers the handler of a trap vector.
+func (s *Script) onTrap(args starlark.Tuple) (starlark.Value, error) {
+	if err := wantArgs(args, 2); err != nil {
+		return nil, err
+	}
+
+	vector, err := word(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if vector > 0xFF {
+		return nil, fmt.Errorf("trap vector x%X out of range", vector)
+	}
+
+	s.traps[vector] = args[1]
+
+	return args[1], nil
+}
+
+// word returns an int argument as a word, accepting negative values
+// as their two's complement.
+func word(v starlark.Value) (uint16, error) {
+	n, err := starlark.AsInt(v)
+	if err != nil {
+		return 0, err
+	}
+
+	if n < -0x8000 || n > 0xFFFF {
+		return 0, fmt.Errorf("%d does not fit in a word", n)
+	}
+
+	return uint16(n), nil
+}
+
+// lookupRegister returns the register an argument names, 0 to 7,
+// "R0" to "R7", "PC" or "COND".
+func lookupRegister(v starlark.Value) (uint16, error) {
+	if n, err := starlark.AsInt(v); err == nil {
+		if n < 0 || n > 7 {
+			return 0, fmt.Errorf("no register %d", n)
+		}
+
+		return uint16(n), nil
+	}
+
+	name, err := starlark.AsString(v)
+	if err != nil {
+		return 0, fmt.Errorf("register must be int or string, not %s", v.Type())
+	}
+
+	switch name := strings.ToUpper(name); {
+	case name == "PC":
+		return registers.RPC, nil
+	case name == "COND":
+		return registers.RCOND, nil
+	case len(name) == 2 && name[0] == 'R' && name[1] >= '0' && name[1] <= '7':
+		return uint16(name[1] - '0'), nil
+	}
+
+	return 0, fmt.Errorf("no register %s", name)
+}
+
+// attached returns the CPU the script is attached to.
+func (s *Script) attached() (cpu.CPU, error) {
+	if s.cpu == nil {
+		return nil, fmt.Errorf("no program is running")
+	}
+
+	return s.cpu, nil
+}
+
+// reg returns the value of a register.
+func (s *Script) reg(args starlark.Tuple) (starlark.Value, error) {
+	if err := wantArgs(args, 1); err != nil {
+		return nil, err
+	}
+
+	c, err := s.attached()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := lookupRegister(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return starlark.Int(c.Register(r)), nil
+}
+
+// setReg sets the value of a register.
+func (s *Script) setReg(args starlark.Tuple) (starlark.Value, error) {
+	if err := wantArgs(args, 2); err != nil {
+		return nil, err
+	}
+
+	c, err := s.attached()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := lookupRegister(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := word(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetRegister(r, val)
+
+	return starlark.None, nil
+}
+
+// peek returns the word at an address without accessing devices.
+func (s *Script) peek(args starlark.Tuple) (starlark.Value, error) {
+	if err := wantArgs(args, 1); err != nil {
+		return nil, err
+	}
+
+	c, err := s.attached()
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := word(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return starlark.Int(c.PeekMemory(address)), nil
+}
+
+// poke sets the word at an address without accessing devices.
+func (s *Script) poke(args starlark.Tuple) (starlark.Value, error) {
+	if err := wantArgs(args, 2); err != nil {
+		return nil, err
+	}
+
+	c, err := s.attached()
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := word(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := word(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	c.PokeMemory(address, val)
+
+	return starlark.None, nil
+}
+
+// readString returns the string at an address, a character per word
+// up to a zero as PUTS prints it.
+func (s *Script) readString(args starlark.Tuple) (starlark.Value, error) {
+	if err := wantArgs(args, 1); err != nil {
+		return nil, err
+	}
+
+	c, err := s.attached()
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := word(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+
+	for {
+		ch := c.PeekMemory(address)
+		if ch == 0 {
+			break
+		}
+
+		sb.WriteByte(byte(ch))
+
+		address++
+		if address == 0 {
+			break
+		}
+	}
+
+	return starlark.String(sb.String()), nil
+}
+
+// output writes text to the console of the program.
+func (s *Script) output(args starlark.Tuple) (starlark.Value, error) {
+	if err := wantArgs(args, 1); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.WriteString(s.out, starlark.Str(args[0])); err != nil {
+		return nil, err
+	}
+
+	return starlark.None, nil
+}
+
+// disasm disassembles the instruction at an address.
+func (s *Script) disasm(args starlark.Tuple) (starlark.Value, error) {
+	if err := wantArgs(args, 1); err != nil {
+		return nil, err
+	}
+
+	c, err := s.attached()
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := word(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return starlark.String(disasm.Format(address, c.PeekMemory(address))), nil
+}
+
+// halt halts the program after the current instruction.
+func (s *Script) halt(args starlark.Tuple) (starlark.Value, error) {
+	if err := wantArgs(args, 0); err != nil {
+		return nil, err
+	}
+
+	c, err := s.attached()
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetHalted(true)
+
+	return starlark.None, nil
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"lc3/pkg/websocket"
+	"sync"
+)
+
+// console is the console of an interactive run, fed keys by WebSocket
+// clients and writing the output to them.
+type console struct {
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// keys are the keys typed and not yet read.
+	keys []byte
+
+	// typed is signalled when keys are typed.
+	typed chan struct{}
+
+	// done is closed once the console takes no more keys.
+	done chan struct{}
+
+	// closed reports whether done is closed.
+	closed bool
+
+	// err is read once done is closed.
+	err error
+
+	// output is the output written so far, replayed to clients that
+	// connect late.
+	output []byte
+
+	// clients are the connected clients.
+	clients map[*websocket.Conn]bool
+
+	// run is the run sent to clients once it ended, nil until then.
+	run []byte
+}
+
+// newConsole creates a console with no keys typed.
+func newConsole() *console {
+	return &console{
+		typed:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		clients: map[*websocket.Conn]bool{},
+	}
+}
+
+// Read waits for a key and reads it, or reads the error the console
+// was closed with. Keys are read one at a time so that none are lost to
+// readers that buffer.
+func (c *console) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for {
+		c.mu.Lock()
+
+		if len(c.keys) > 0 {
+			p[0] = c.keys[0]
+			c.keys = c.keys[1:]
+			c.mu.Unlock()
+
+			return 1, nil
+		}
+
+		c.mu.Unlock()
+
+		select {
+		case <-c.typed:
+		case <-c.done:
+			return 0, c.err
+		}
+	}
+}
+
+// Write writes output to the clients.
+func (c *console) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.output = append(c.output, p...)
+
+	for conn := range c.clients {
+		if conn.WriteBinary(p) != nil {
+			delete(c.clients, conn)
+		}
+	}
+
+	return len(p), nil
+}
+
+// outputSince returns a copy of the output written past offset bytes.
+func (c *console) outputSince(offset int) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return bytes.Clone(c.output[min(offset, len(c.output)):])
+}
+
+// typeKeys queues keys to be read.
+func (c *console) typeKeys(keys []byte) {
+	c.mu.Lock()
+	c.keys = append(c.keys, keys...)
+	c.mu.Unlock()
+
+	select {
+	case c.typed <- struct{}{}:
+	default:
+	}
+}
+
+// close makes reads past the keys typed read io.EOF.
+func (c *console) close() {
+	c.closeWith(io.EOF)
+}
+
+// closeWith makes reads past the keys typed read err.
+func (c *console) closeWith(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		c.err = err
+		close(c.done)
+	}
+}
+
+// end sends the run to the clients once it ended and disconnects them.
+func (c *console) end(run []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.run = run
+
+	for conn := range c.clients {
+		conn.WriteMessage(run)
+		conn.Close()
+	}
+
+	clear(c.clients)
+}
+
+// attach sends a client the output so far and the output written
+// from then on, or the run and disconnects it if the run ended.
+func (c *console) attach(conn *websocket.Conn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.output) > 0 {
+		if err := conn.WriteBinary(c.output); err != nil {
+			return err
+		}
+	}
+
+	if c.run != nil {
+		conn.WriteMessage(c.run)
+		return conn.Close()
+	}
+
+	c.clients[conn] = true
+
+	return nil
+}
+
+// detach stops writing the output to a client.
+func (c *console) detach(conn *websocket.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.clients, conn)
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"lc3/pkg/cpu"
+	"lc3/pkg/debugger"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The intervals between the state events of a stream.
+const (
+	// DefaultEventInterval is the interval unless a stream asks for
+	// another.
+	DefaultEventInterval = 250 * time.Millisecond
+
+	// minEventInterval is the shortest interval a stream may ask for.
+	minEventInterval = 50 * time.Millisecond
+)
+
+// sampleEvery is how many instructions run between samples of the
+// registers of a running program.
+const sampleEvery = 1024
+
+// liveState is the state of an interactive run as it runs, sampled by
+// the goroutine running it for the event streams of the run.
+type liveState struct {
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// registers are the registers when last sampled.
+	registers [registers.RCOUNT]uint16
+
+	// instructions counts the instructions run when last sampled.
+	instructions uint64
+}
+
+// record samples the registers of a CPU as the program starts, every
+// sampleEvery instructions and before every trap, so that programs
+// waiting for keys show where they wait.
+func (l *liveState) record(c cpu.CPU) {
+	var n uint64
+
+	l.store(c, n)
+
+	c.OnInstruction(func(pc, instr uint16) {
+		n++
+
+		if n%sampleEvery == 0 || c.PeekMemory(c.Register(registers.RPC))>>12 == opcodes.OPTRAP {
+			l.store(c, n)
+		}
+	})
+}
+
+// store samples the registers of a CPU that ran some instructions.
+func (l *liveState) store(c cpu.CPU, instructions uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for r := range l.registers {
+		l.registers[r] = c.Register(uint16(r))
+	}
+
+	l.instructions = instructions
+}
+
+// stateEvent is the data of a state event.
+type stateEvent struct {
+	// PC is the program counter.
+	PC uint16 `json:"pc"`
+
+	// Registers are R0 to R7.
+	Registers [8]uint16 `json:"registers"`
+
+	// CC are the condition codes, N, Z or P.
+	CC string `json:"cc"`
+
+	// Instructions counts the instructions run.
+	Instructions uint64 `json:"instructions"`
+}
+
+// newStateEvent returns the state event of registers.
+func newStateEvent(regs []uint16, instructions uint64) stateEvent {
+	event := stateEvent{
+		PC:           regs[registers.RPC],
+		CC:           debugger.ConditionCodes(regs[registers.RCOND]),
+		Instructions: instructions,
+	}
+
+	copy(event.Registers[:], regs[registers.RR0:registers.RR7+1])
+
+	return event
+}
+
+// sample returns the state event of the registers last sampled.
+func (l *liveState) sample() stateEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return newStateEvent(l.registers[:], l.instructions)
+}
+
+// handleEvents streams the state and the console output of a run as
+// server-sent events until it ends: state events with the registers
+// every ?interval=, 250ms by default, output events with the output
+// written since the last, and an end event with the run.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	res, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	interval := DefaultEventInterval
+
+	if value := r.URL.Query().Get("interval"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid interval: %w", err))
+			return
+		}
+
+		interval = max(d, minEventInterval)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	sent := 0
+
+	for res.running {
+		output := res.console.outputSince(sent)
+		sent += len(output)
+
+		if len(output) > 0 {
+			writeEvent(w, "output", string(output))
+		}
+
+		writeEvent(w, "state", res.live.sample())
+
+		if rc.Flush() != nil {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-res.ended:
+		case <-r.Context().Done():
+			return
+		}
+
+		s.mu.Lock()
+		latest, ok := s.results[res.run.ID]
+		if ok {
+			res = *latest
+		}
+		s.mu.Unlock()
+
+		if !ok {
+			// the run is no longer kept.
+			return
+		}
+	}
+
+	if sent < len(res.run.Output) {
+		writeEvent(w, "output", res.run.Output[sent:])
+	}
+
+	writeEvent(w, "state", newStateEvent(res.state.Registers[:], res.run.Instructions))
+	writeEvent(w, "end", res.run)
+	rc.Flush()
+}
+
+// writeEvent writes a server-sent event with data in JSON.
+func writeEvent(w http.ResponseWriter, event string, data any) {
+	encoded, _ := json.Marshal(data)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/sandbox"
+	"lc3/pkg/traps"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the buckets of request
+// latencies, in seconds.
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// trapCounts counts the traps a run makes by vector.
+type trapCounts [256]uint64
+
+// record counts the traps a CPU makes.
+func (t *trapCounts) record(c cpu.CPU) {
+	c.OnInstruction(func(pc, instr uint16) {
+		if instr>>12 == opcodes.OPTRAP {
+			t[instr&0xFF]++
+		}
+	})
+}
+
+// histogram counts observations in buckets.
+type histogram struct {
+	// counts are the observations in each bucket, not cumulative, and
+	// above the last bucket.
+	counts []uint64
+
+	// sum is the sum of the observations.
+	sum float64
+}
+
+// metrics are the counters of a server, exported in the Prometheus
+// text format.
+type metrics struct {
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// started counts the runs started.
+	started uint64
+
+	// completed counts the runs ended by outcome.
+	completed map[string]uint64
+
+	// instructions counts the instructions run.
+	instructions uint64
+
+	// limits counts the runs stopped by each limit.
+	limits map[string]uint64
+
+	// traps counts the traps made by vector.
+	traps trapCounts
+
+	// latencies are the latencies of the requests by route.
+	latencies map[string]*histogram
+}
+
+// newMetrics creates metrics with nothing counted.
+func newMetrics() *metrics {
+	return &metrics{
+		completed: map[string]uint64{},
+		limits:    map[string]uint64{},
+		latencies: map[string]*histogram{},
+	}
+}
+
+// start counts a run started.
+func (m *metrics) start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.started++
+}
+
+// complete counts a run ended, with the traps it made.
+func (m *metrics) complete(outcome *sandbox.RunResult, traps *trapCounts) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case outcome.Halted:
+		m.completed["halted"]++
+	case outcome.Paused:
+		m.completed["paused"]++
+	case outcome.Exceeded != sandbox.None:
+		m.completed["exceeded"]++
+		m.limits[outcome.Exceeded.String()]++
+	case outcome.Err != nil:
+		m.completed["failed"]++
+	default:
+		m.completed["stopped"]++
+	}
+
+	m.instructions += outcome.Instructions
+
+	for vector, n := range traps {
+		m.traps[vector] += n
+	}
+}
+
+// observe counts a request to a route taking d.
+func (m *metrics) observe(route string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.latencies[route]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		m.latencies[route] = h
+	}
+
+	seconds := d.Seconds()
+
+	h.counts[sort.SearchFloat64s(latencyBuckets, seconds)]++
+	h.sum += seconds
+}
+
+// instrument wraps the handler of a route to observe its latencies.
+func (m *metrics) instrument(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		handler(w, r)
+		m.observe(route, time.Since(start))
+	}
+}
+
+// write writes the metrics in the Prometheus text format.
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP lc3_runs_started_total Runs started.\n")
+	fmt.Fprintf(w, "# TYPE lc3_runs_started_total counter\n")
+	fmt.Fprintf(w, "lc3_runs_started_total %d\n", m.started)
+
+	fmt.Fprintf(w, "# HELP lc3_runs_completed_total Runs ended, by outcome.\n")
+	fmt.Fprintf(w, "# TYPE lc3_runs_completed_total counter\n")
+
+	for _, outcome := range sortedKeys(m.completed) {
+		fmt.Fprintf(w, "lc3_runs_completed_total{outcome=%q} %d\n", outcome, m.completed[outcome])
+	}
+
+	fmt.Fprintf(w, "# HELP lc3_instructions_total Instructions run.\n")
+	fmt.Fprintf(w, "# TYPE lc3_instructions_total counter\n")
+	fmt.Fprintf(w, "lc3_instructions_total %d\n", m.instructions)
+
+	fmt.Fprintf(w, "# HELP lc3_limits_exceeded_total Runs stopped by a limit, by limit.\n")
+	fmt.Fprintf(w, "# TYPE lc3_limits_exceeded_total counter\n")
+
+	for _, limit := range sortedKeys(m.limits) {
+		fmt.Fprintf(w, "lc3_limits_exceeded_total{limit=%q} %d\n", limit, m.limits[limit])
+	}
+
+	fmt.Fprintf(w, "# HELP lc3_traps_total Traps made by programs, by trap.\n")
+	fmt.Fprintf(w, "# TYPE lc3_traps_total counter\n")
+
+	for vector, n := range m.traps {
+		if n == 0 {
+			continue
+		}
+
+		name := traps.Name(uint16(vector))
+		if name == "" {
+			name = fmt.Sprintf("x%02X", vector)
+		}
+
+		fmt.Fprintf(w, "lc3_traps_total{trap=%q} %d\n", name, n)
+	}
+
+	fmt.Fprintf(w, "# HELP lc3_request_duration_seconds Latencies of the requests, by route.\n")
+	fmt.Fprintf(w, "# TYPE lc3_request_duration_seconds histogram\n")
+
+	for _, route := range sortedKeys(m.latencies) {
+		h := m.latencies[route]
+
+		var count uint64
+
+		for i, bound := range latencyBuckets {
+			count += h.counts[i]
+			fmt.Fprintf(w, "lc3_request_duration_seconds_bucket{route=%q,le=%q} %d\n", route, strconv.FormatFloat(bound, 'g', -1, 64), count)
+		}
+
+		count += h.counts[len(latencyBuckets)]
+
+		fmt.Fprintf(w, "lc3_request_duration_seconds_bucket{route=%q,le=\"+Inf\"} %d\n", route, count)
+		fmt.Fprintf(w, "lc3_request_duration_seconds_sum{route=%q} %g\n", route, h.sum)
+		fmt.Fprintf(w, "lc3_request_duration_seconds_count{route=%q} %d\n", route, count)
+	}
+}
+
+// sortedKeys returns the keys of a map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,696 @@
+// Package service runs programs submitted over HTTP in a sandbox, so
+// that courses and web front-ends can run them server-side:
+//
+//	POST /runs                 runs a program, answering with the run
+//	GET  /runs/{id}            the run: how it ended, its counts and output
+//	GET  /runs/{id}/output     the console output as text
+//	GET  /runs/{id}/state      the final state as a JSON snapshot, or with
+//	                           ?format=binary or ?format=proto in those formats
+//	GET  /runs/{id}/profile    the profile of the run, if one was asked for
+//	GET  /runs/{id}/console    the console of an interactive run, as a WebSocket
+//	GET  /runs/{id}/events     the state and output of a run as server-sent
+//	                           events, every ?interval= until it ends
+//	POST /runs/{id}/pause      pauses an interactive run, answering with the run
+//	GET  /metrics              the metrics of the server, for Prometheus
+//
+// A run is submitted as JSON, with the program as assembly source or an
+// object file in base64, the keys typed into it and limits lower than
+// those of the server:
+//
+//	{"source": ".ORIG x3000\n...", "stdin": "hello\n",
+//	 "limits": {"instructions": 100000, "timeout": "1s"}, "profile": true}
+//
+// Interactive runs are answered at once with the status running, the
+// program running in the background with its console on a WebSocket:
+// messages from clients are typed as keys, the output is sent to them
+// as binary messages as it is written, and the run as a text message
+// once it ends, when the connection is closed.
+//
+// Paused runs end with the status paused, their state exported by
+// /runs/{id}/state. A run submitted with such a state in place of a
+// program resumes it, on this server or another, so that machines can
+// be moved between servers or continued locally with lc3 resume:
+//
+//	{"state": {"registers": {...}, "memory": [...]}, "interactive": true}
+//
+// Errors are answered as {"error": "...", "diagnostics": [...]}, the
+// diagnostics listing why a source does not assemble.
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"lc3/pkg/otlp"
+	"lc3/pkg/profile"
+	"lc3/pkg/registers"
+	"lc3/pkg/sandbox"
+	"lc3/pkg/state"
+	"lc3/pkg/websocket"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRequest caps the bytes of a request.
+const maxRequest = 1 << 20
+
+// DefaultRetention is the number of runs kept unless configured
+// otherwise.
+const DefaultRetention = 100
+
+// Request is a program to run.
+type Request struct {
+	// Source is the assembly source of the program.
+	Source string `json:"source,omitempty"`
+
+	// Object is the program as an .obj file, if no source is given.
+	Object []byte `json:"object,omitempty"`
+
+	// State is the state of a paused run to resume, as its JSON
+	// snapshot, if no source or object is given.
+	State *cpu.Snapshot `json:"state,omitempty"`
+
+	// Stdin is typed into the console of the program.
+	Stdin string `json:"stdin,omitempty"`
+
+	// Limits caps the run below the limits of the server.
+	Limits Limits `json:"limits"`
+
+	// Profile counts how many times each instruction runs.
+	Profile bool `json:"profile,omitempty"`
+
+	// Interactive runs the program in the background, reading keys
+	// from its console past Stdin until it hits the timeout.
+	Interactive bool `json:"interactive,omitempty"`
+}
+
+// Limits caps a run, 0 standing for the limit of the server.
+type Limits struct {
+	// Instructions caps the instructions run.
+	Instructions uint64 `json:"instructions,omitempty"`
+
+	// Output caps the bytes written to the console.
+	Output int `json:"output,omitempty"`
+
+	// IO caps the console traps, host calls and device accesses.
+	IO uint64 `json:"io,omitempty"`
+
+	// Timeout caps the wall-clock time, as a duration such as "500ms".
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Run is the outcome of a run.
+type Run struct {
+	// ID identifies the run in the URLs of its results.
+	ID string `json:"id"`
+
+	// Status describes how the run ended, as sandbox.RunResult.Status,
+	// or is running.
+	Status string `json:"status"`
+
+	// Halted reports whether the program halted.
+	Halted bool `json:"halted"`
+
+	// Exceeded is the limit that stopped the program, if any.
+	Exceeded string `json:"exceeded,omitempty"`
+
+	// Error is the error that stopped the program, if any.
+	Error string `json:"error,omitempty"`
+
+	// Instructions counts the instructions run.
+	Instructions uint64 `json:"instructions"`
+
+	// IO counts the console traps, host calls and device accesses.
+	IO uint64 `json:"io"`
+
+	// Elapsed is the wall-clock time of the run, such as "1.2ms".
+	Elapsed string `json:"elapsed"`
+
+	// Output is the console output.
+	Output string `json:"output"`
+
+	// PC is the address the program stopped at.
+	PC uint16 `json:"pc"`
+
+	// Registers are R0 to R7 as the program left them.
+	Registers [8]uint16 `json:"registers"`
+}
+
+// result is a run kept along with its state and profile.
+type result struct {
+	// run is the outcome of the run.
+	run *Run
+
+	// state is the state of the machine after the run.
+	state *cpu.Snapshot
+
+	// profile is the profile of the run, if one was asked for.
+	profile *profile.Profile
+
+	// console is the console of an interactive run.
+	console *console
+
+	// traps counts the traps the run made.
+	traps *trapCounts
+
+	// running reports whether the run has not ended.
+	running bool
+
+	// pause is closed to pause an interactive run.
+	pause chan struct{}
+
+	// ended is closed once an interactive run ended.
+	ended chan struct{}
+
+	// live is the state of an interactive run as it runs.
+	live *liveState
+
+	// trace collects the spans of the run, if they are exported.
+	trace *runTrace
+}
+
+// Server serves runs over HTTP.
+type Server struct {
+	// maxInstructions caps the instructions of every run.
+	maxInstructions uint64
+
+	// maxOutput caps the bytes of output of every run.
+	maxOutput int
+
+	// maxIO caps the I/O operations of every run.
+	maxIO uint64
+
+	// timeout caps the wall-clock time of every run.
+	timeout time.Duration
+
+	// retention is the number of runs kept.
+	retention int
+
+	// mux routes the requests.
+	mux *http.ServeMux
+
+	// metrics count the runs and requests.
+	metrics *metrics
+
+	// exporter exports the spans of the runs, if set.
+	exporter *otlp.Exporter
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// results are the runs kept by ID.
+	results map[string]*result
+
+	// order are the IDs of the runs kept, oldest first.
+	order []string
+}
+
+// Option configures a server.
+type Option func(s *Server)
+
+// WithLimits sets the limits of every run, which requests may lower
+// but not raise. Limits of 0 keep the sandbox defaults.
+func WithLimits(instructions uint64, output int, io uint64, timeout time.Duration) Option {
+	return func(s *Server) {
+		if instructions > 0 {
+			s.maxInstructions = instructions
+		}
+
+		if output > 0 {
+			s.maxOutput = output
+		}
+
+		if io > 0 {
+			s.maxIO = io
+		}
+
+		if timeout > 0 {
+			s.timeout = timeout
+		}
+	}
+}
+
+// WithRetention keeps the results of the last n runs,
+// DefaultRetention by default.
+func WithRetention(n int) Option {
+	return func(s *Server) {
+		s.retention = max(n, 1)
+	}
+}
+
+// WithTracing exports OpenTelemetry spans of the runs, their traps and
+// their device accesses, parented to the spans of the requests that
+// carry a traceparent header.
+func WithTracing(exporter *otlp.Exporter) Option {
+	return func(s *Server) {
+		s.exporter = exporter
+	}
+}
+
+// New creates a server.
+func New(opts ...Option) *Server {
+	s := &Server{
+		maxInstructions: sandbox.DefaultMaxInstructions,
+		maxOutput:       sandbox.DefaultMaxOutput,
+		maxIO:           sandbox.DefaultMaxIO,
+		timeout:         sandbox.DefaultTimeout,
+		retention:       DefaultRetention,
+		mux:             http.NewServeMux(),
+		metrics:         newMetrics(),
+		results:         map[string]*result{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.handle("POST /runs", s.handleRun)
+	s.handle("GET /runs/{id}", s.handleResult)
+	s.handle("GET /runs/{id}/output", s.handleOutput)
+	s.handle("GET /runs/{id}/state", s.handleState)
+	s.handle("GET /runs/{id}/profile", s.handleProfile)
+	s.handle("GET /runs/{id}/console", s.handleConsole)
+	s.handle("GET /runs/{id}/events", s.handleEvents)
+	s.handle("POST /runs/{id}/pause", s.handlePause)
+	s.handle("GET /metrics", s.handleMetrics)
+
+	return s
+}
+
+// handle routes requests matching a pattern to a handler, observing
+// their latencies.
+func (s *Server) handle(pattern string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, s.metrics.instrument(pattern, handler))
+}
+
+// ServeHTTP serves a request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// errorResponse is the body of errors.
+type errorResponse struct {
+	// Error describes the error.
+	Error string `json:"error"`
+
+	// Diagnostics are the reasons a source does not assemble.
+	Diagnostics []string `json:"diagnostics,omitempty"`
+}
+
+// handleRun runs a program and answers with the run.
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	var req Request
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequest)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	image, setup, diagnostics, err := load(&req)
+	if len(diagnostics) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: "the program does not assemble", Diagnostics: diagnostics})
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	opts, timeout, err := s.options(req.Limits)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	res := &result{run: &Run{ID: newID()}, traps: &trapCounts{}}
+
+	opts = append(opts, sandbox.WithSetup(res.traps.record))
+
+	if res.trace = s.newTrace(r); res.trace != nil {
+		opts = append(opts, res.trace.options()...)
+	}
+
+	if req.Profile {
+		res.profile = profile.New()
+		opts = append(opts, sandbox.WithSetup(res.profile.Record))
+	}
+
+	opts = append(opts, sandbox.WithInput(req.Stdin), sandbox.WithSetup(setup))
+
+	s.metrics.start()
+
+	if req.Interactive {
+		res.console = newConsole()
+		res.pause = make(chan struct{})
+		res.ended = make(chan struct{})
+		res.live = &liveState{}
+		res.running = true
+		res.run.Status = "running"
+
+		s.keep(res)
+
+		go s.interact(res, image, timeout, append(opts, sandbox.WithPause(res.pause), sandbox.WithSetup(res.live.record)))
+
+		w.Header().Set("Location", "/runs/"+res.run.ID)
+		writeJSON(w, http.StatusCreated, res.run)
+
+		return
+	}
+
+	s.finish(res, sandbox.Run(image, opts...))
+	s.keep(res)
+
+	w.Header().Set("Location", "/runs/"+res.run.ID)
+	writeJSON(w, http.StatusCreated, res.run)
+}
+
+// interact runs a program on the console of its result until it ends
+// or hits the timeout, then sends the run to the console's clients.
+func (s *Server) interact(res *result, image [cpu.MemoryMax]uint16, timeout time.Duration, opts []sandbox.Option) {
+	timer := time.AfterFunc(timeout, res.console.close)
+	defer timer.Stop()
+
+	outcome := sandbox.Run(image, append(opts, sandbox.WithConsole(res.console, res.console))...)
+
+	s.mu.Lock()
+	s.finish(res, outcome)
+	res.running = false
+	s.mu.Unlock()
+
+	close(res.ended)
+
+	run, _ := json.Marshal(res.run)
+	res.console.end(run)
+}
+
+// finish records the outcome of a run in its result and the metrics.
+func (s *Server) finish(res *result, outcome *sandbox.RunResult) {
+	s.metrics.complete(outcome, res.traps)
+
+	res.state = outcome.CPU.Snapshot()
+	res.run = &Run{
+		ID:           res.run.ID,
+		Status:       outcome.Status(),
+		Halted:       outcome.Halted,
+		Instructions: outcome.Instructions,
+		IO:           outcome.IO,
+		Elapsed:      outcome.Elapsed.String(),
+		Output:       outcome.Output,
+		PC:           res.state.Registers[registers.RPC],
+	}
+
+	copy(res.run.Registers[:], res.state.Registers[registers.RR0:registers.RR7+1])
+
+	if outcome.Exceeded != sandbox.None {
+		res.run.Exceeded = outcome.Exceeded.String()
+	}
+
+	if outcome.Err != nil {
+		res.run.Error = outcome.Err.Error()
+	}
+
+	if res.trace != nil {
+		res.trace.end(res.run)
+	}
+}
+
+// load returns the memory image of a request and the setup pointing
+// the PC at the origin of its program, or restoring the state it
+// resumes.
+func load(req *Request) ([cpu.MemoryMax]uint16, func(c cpu.CPU), []string, error) {
+	var image [cpu.MemoryMax]uint16
+
+	if req.State != nil {
+		if req.Source != "" || len(req.Object) > 0 {
+			return image, nil, nil, errors.New("give either a program or a state")
+		}
+
+		return req.State.Memory, func(c cpu.CPU) { c.Restore(req.State) }, nil, nil
+	}
+
+	obj, diagnostics, err := program(req)
+	if len(diagnostics) > 0 || err != nil {
+		return image, nil, diagnostics, err
+	}
+
+	copy(image[obj.Origin:], obj.Words)
+
+	return image, func(c cpu.CPU) { c.SetRegister(registers.RPC, obj.Origin) }, nil, nil
+}
+
+// program assembles the source of a request or reads its object.
+func program(req *Request) (*asm.Object, []string, error) {
+	switch {
+	case req.Source != "" && len(req.Object) > 0:
+		return nil, nil, errors.New("give either a source or an object")
+	case req.Source != "":
+		obj, _, diagnostics, err := asm.Assemble(strings.NewReader(req.Source), asm.WithoutIncludes())
+		if len(diagnostics) > 0 {
+			list := make([]string, len(diagnostics))
+			for i, d := range diagnostics {
+				list[i] = d.Error()
+			}
+
+			return nil, list, nil
+		}
+
+		return obj, nil, err
+	case len(req.Object) > 0:
+		obj, err := asm.ReadObject(bytes.NewReader(req.Object))
+		return obj, nil, err
+	default:
+		return nil, nil, errors.New("no source, object or state to run")
+	}
+}
+
+// options returns the sandbox options of a run and its timeout,
+// capping the limits asked for at those of the server.
+func (s *Server) options(limits Limits) ([]sandbox.Option, time.Duration, error) {
+	timeout := s.timeout
+
+	if limits.Timeout != "" {
+		d, err := time.ParseDuration(limits.Timeout)
+		if err != nil || d <= 0 {
+			return nil, 0, fmt.Errorf("invalid timeout %q", limits.Timeout)
+		}
+
+		timeout = min(d, timeout)
+	}
+
+	return []sandbox.Option{
+		sandbox.WithMaxInstructions(cap64(s.maxInstructions, limits.Instructions)),
+		sandbox.WithMaxOutput(int(cap64(uint64(s.maxOutput), uint64(limits.Output)))),
+		sandbox.WithMaxIO(cap64(s.maxIO, limits.IO)),
+		sandbox.WithTimeout(timeout),
+	}, timeout, nil
+}
+
+// cap64 lowers a limit to n, unless n is 0.
+func cap64(limit, n uint64) uint64 {
+	if n == 0 {
+		return limit
+	}
+
+	return min(limit, n)
+}
+
+// keep keeps a result, dropping the oldest if there are too many.
+func (s *Server) keep(res *result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.results[res.run.ID] = res
+	s.order = append(s.order, res.run.ID)
+
+	if len(s.order) > s.retention {
+		delete(s.results, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// lookup returns a copy of the result of the run in the URL, answering
+// with an error if there is none.
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) (result, bool) {
+	s.mu.Lock()
+	res, ok := s.results[r.PathValue("id")]
+
+	var copied result
+	if ok {
+		copied = *res
+	}
+
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no run %s", r.PathValue("id")))
+	}
+
+	return copied, ok
+}
+
+// lookupEnded returns a copy of the result of the run in the URL,
+// answering with an error if there is none or it is still running.
+func (s *Server) lookupEnded(w http.ResponseWriter, r *http.Request) (result, bool) {
+	res, ok := s.lookup(w, r)
+
+	if ok && res.running {
+		writeError(w, http.StatusConflict, fmt.Errorf("run %s is still running", res.run.ID))
+		return res, false
+	}
+
+	return res, ok
+}
+
+// handleResult answers with a run.
+func (s *Server) handleResult(w http.ResponseWriter, r *http.Request) {
+	if res, ok := s.lookup(w, r); ok {
+		writeJSON(w, http.StatusOK, res.run)
+	}
+}
+
+// handleOutput answers with the console output of a run.
+func (s *Server) handleOutput(w http.ResponseWriter, r *http.Request) {
+	if res, ok := s.lookup(w, r); ok {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, res.run.Output)
+	}
+}
+
+// handleState answers with the final state of a run, as JSON or in
+// the format asked for.
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	res, ok := s.lookupEnded(w, r)
+	if !ok {
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		writeJSON(w, http.StatusOK, res.state)
+	case "binary":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		res.state.WriteTo(w)
+	case "proto":
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(state.MarshalProto(res.state))
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown format %s, expected json, binary or proto", format))
+	}
+}
+
+// handleProfile answers with the profile of a run, in the format of
+// lc3 -profile.
+func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
+	res, ok := s.lookupEnded(w, r)
+	if !ok {
+		return
+	}
+
+	if res.profile == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("run %s was not profiled", res.run.ID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	res.profile.Write(w)
+}
+
+// handleConsole connects a WebSocket client to the console of an
+// interactive run, typing the messages it sends until the run ends.
+func (s *Server) handleConsole(w http.ResponseWriter, r *http.Request) {
+	res, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	if res.console == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("run %s is not interactive", res.run.ID))
+		return
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	if err := res.console.attach(conn); err != nil {
+		return
+	}
+
+	defer res.console.detach(conn)
+
+	for {
+		keys, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		res.console.typeKeys(keys)
+	}
+}
+
+// handlePause pauses an interactive run and answers with the run once
+// it stopped, or with how it ended if it already did.
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	res, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	if res.console == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("run %s is not interactive", res.run.ID))
+		return
+	}
+
+	s.mu.Lock()
+	select {
+	case <-res.pause:
+	default:
+		close(res.pause)
+	}
+	s.mu.Unlock()
+
+	res.console.closeWith(sandbox.ErrPaused)
+	<-res.ended
+
+	if res, ok := s.lookup(w, r); ok {
+		writeJSON(w, http.StatusOK, res.run)
+	}
+}
+
+// handleMetrics answers with the metrics in the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.write(w)
+}
+
+// writeJSON answers with a value in JSON.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with an error.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// newID returns a random ID for a run, so that runs cannot be guessed
+// by others sharing the service.
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
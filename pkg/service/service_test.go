@@ -0,0 +1,181 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// hello prints a greeting and halts.
+const hello = `
+	.ORIG x3000
+	LEA R0, MSG
+	PUTS
+	HALT
+MSG	.STRINGZ "hello"
+	.END`
+
+// loop never ends.
+const loop = `
+	.ORIG x3000
+	BR #-1
+	.END`
+
+// post posts a body to a server and returns the recorded response.
+func post(s *Server, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+
+	return w
+}
+
+// get gets a path from a server and returns the recorded response.
+func get(s *Server, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	return w
+}
+
+// submit posts a request as JSON, failing the test unless it is
+// answered with status, and returns the run it answered with.
+func submit(t *testing.T, s *Server, req any, status int) Run {
+	t.Helper()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := post(s, "/runs", string(body))
+	if w.Code != status {
+		t.Fatalf("status %d, expected %d: %s", w.Code, status, w.Body)
+	}
+
+	var run Run
+	if status == http.StatusCreated {
+		if err := json.Unmarshal(w.Body.Bytes(), &run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return run
+}
+
+// TestRun checks a run and the results kept of it.
+func TestRun(t *testing.T) {
+	s := New()
+
+	run := submit(t, s, Request{Source: hello}, http.StatusCreated)
+
+	if !run.Halted || run.Status != "halted" || run.Output != "hello" {
+		t.Errorf("run %+v", run)
+	}
+
+	if w := get(s, "/runs/"+run.ID+"/output"); w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Errorf("output %d %q", w.Code, w.Body)
+	}
+
+	if w := get(s, "/runs/"+run.ID+"/state?format=yaml"); w.Code != http.StatusBadRequest {
+		t.Errorf("state in an unknown format answered with %d", w.Code)
+	}
+
+	if w := get(s, "/runs/"+run.ID+"/profile"); w.Code != http.StatusNotFound {
+		t.Errorf("profile of a run without one answered with %d", w.Code)
+	}
+
+	if w := get(s, "/runs/nowhere"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown run answered with %d", w.Code)
+	}
+}
+
+// TestResume checks that the state of a run resumes where it stopped.
+func TestResume(t *testing.T) {
+	s := New()
+
+	run := submit(t, s, Request{Source: hello, Limits: Limits{Instructions: 1}}, http.StatusCreated)
+
+	w := get(s, "/runs/"+run.ID+"/state")
+	if w.Code != http.StatusOK {
+		t.Fatalf("state answered with %d", w.Code)
+	}
+
+	resumed := submit(t, s, json.RawMessage(`{"state": `+w.Body.String()+`}`), http.StatusCreated)
+
+	if !resumed.Halted || resumed.Output != "hello" {
+		t.Errorf("resumed run %+v", resumed)
+	}
+}
+
+// TestLimits checks that runs stop at the instruction and time limits,
+// the server's or lower ones asked for.
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   *Server
+		limits   Limits
+		exceeded string
+	}{
+		{name: "instructions", server: New(), limits: Limits{Instructions: 1000}, exceeded: "instructions"},
+		{name: "server instructions", server: New(WithLimits(500, 0, 0, 0)), limits: Limits{Instructions: 1000}, exceeded: "instructions"},
+		{name: "timeout", server: New(WithLimits(1<<62, 0, 0, 0)), limits: Limits{Timeout: "20ms"}, exceeded: "time"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			run := submit(t, test.server, Request{Source: loop, Limits: test.limits}, http.StatusCreated)
+
+			if run.Halted || run.Exceeded != test.exceeded {
+				t.Errorf("run %+v, expected it to exceed the %s limit", run, test.exceeded)
+			}
+
+			if test.limits.Instructions > 0 && run.Instructions > test.limits.Instructions {
+				t.Errorf("%d instructions, expected at most %d", run.Instructions, test.limits.Instructions)
+			}
+		})
+	}
+}
+
+// TestBadRequests checks that malformed, oversized and unrunnable
+// requests are answered with an error, and no run.
+func TestBadRequests(t *testing.T) {
+	tests := map[string]struct {
+		body   string
+		status int
+	}{
+		"not json":          {`{"source": `, http.StatusBadRequest},
+		"wrong type":        {`{"source": 12}`, http.StatusBadRequest},
+		"too large":         {`{"source": "` + strings.Repeat(" ", maxRequest) + `"}`, http.StatusBadRequest},
+		"nothing to run":    {`{}`, http.StatusBadRequest},
+		"source and object": {`{"source": "x", "object": "MAA="}`, http.StatusBadRequest},
+		"bad object":        {`{"object": "MA=="}`, http.StatusBadRequest},
+		"bad timeout":       {`{"source": "\t.ORIG x3000\n\tHALT\n\t.END", "limits": {"timeout": "-1s"}}`, http.StatusBadRequest},
+		"does not assemble": {`{"source": "\t.ORIG x3000\n\tFROB R9\n\t.END"}`, http.StatusUnprocessableEntity},
+		"state and source":  {`{"source": "x", "state": {}}`, http.StatusBadRequest},
+		"state huge repeat": {`{"state": {"memory": [{"address": 0, "repeat": 9223372036854775807, "value": 1}]}}`, http.StatusBadRequest},
+		"state past end":    {`{"state": {"memory": [{"address": 65535, "words": [1, 2]}]}}`, http.StatusBadRequest},
+		"state register":    {`{"state": {"registers": {"R9": 1}}}`, http.StatusBadRequest},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := New()
+
+			w := post(s, "/runs", test.body)
+			if w.Code != test.status {
+				t.Fatalf("status %d, expected %d: %s", w.Code, test.status, w.Body)
+			}
+
+			var resp errorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error == "" {
+				t.Errorf("body %s: %v", w.Body, err)
+			}
+
+			if len(s.results) != 0 {
+				t.Errorf("%d runs kept", len(s.results))
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"fmt"
+	"lc3/pkg/cpu"
+	"lc3/pkg/otlp"
+	"lc3/pkg/sandbox"
+	"lc3/pkg/traps"
+	"net/http"
+	"time"
+)
+
+// maxSpans caps the trap and device spans of a run, past which they
+// are only counted.
+const maxSpans = 1000
+
+// runTrace collects the spans of a run: one for the run, parented to
+// the span of the request if it carried a traceparent header, and
+// spans for the traps it made and the device registers it accessed.
+type runTrace struct {
+	// exporter sends the spans once the run ends.
+	exporter *otlp.Exporter
+
+	// run is the span of the run.
+	run otlp.Span
+
+	// children are the spans of the traps and device accesses.
+	children []otlp.Span
+
+	// dropped counts the spans past maxSpans.
+	dropped int
+}
+
+// newTrace starts the trace of a run submitted by a request, or returns
+// nil if the server does not export spans.
+func (s *Server) newTrace(r *http.Request) *runTrace {
+	if s.exporter == nil {
+		return nil
+	}
+
+	t := &runTrace{
+		exporter: s.exporter,
+		run: otlp.Span{
+			Trace: otlp.NewTraceID(),
+			ID:    otlp.NewSpanID(),
+			Name:  "lc3.run",
+			Kind:  otlp.KindInternal,
+			Start: time.Now(),
+		},
+	}
+
+	if trace, parent, ok := otlp.ParseTraceparent(r.Header.Get("traceparent")); ok {
+		t.run.Trace, t.run.Parent = trace, parent
+	}
+
+	return t
+}
+
+// options returns the sandbox options recording the traps and device
+// accesses of the run.
+func (t *runTrace) options() []sandbox.Option {
+	return []sandbox.Option{
+		sandbox.WithTrapHook(t.trap),
+		sandbox.WithSetup(t.record),
+	}
+}
+
+// child adds a span of the run, unless it has too many.
+func (t *runTrace) child(name string, start, end time.Time, attrs map[string]any, err error) {
+	if len(t.children) >= maxSpans {
+		t.dropped++
+		return
+	}
+
+	span := otlp.Span{
+		Trace:      t.run.Trace,
+		ID:         otlp.NewSpanID(),
+		Parent:     t.run.ID,
+		Name:       name,
+		Kind:       otlp.KindInternal,
+		Start:      start,
+		End:        end,
+		Attributes: attrs,
+	}
+
+	if err != nil {
+		span.Error = err.Error()
+	}
+
+	t.children = append(t.children, span)
+}
+
+// trap adds the span of a trap.
+func (t *runTrace) trap(vector uint16, start time.Time, err error) {
+	name := traps.Name(vector)
+	if name == "" {
+		name = fmt.Sprintf("x%02X", vector)
+	}
+
+	t.child("lc3.trap "+name, start, time.Now(), map[string]any{"lc3.trap.vector": vector}, err)
+}
+
+// record adds the spans of the accesses to the device registers of a
+// CPU.
+func (t *runTrace) record(c cpu.CPU) {
+	owned := c.Devices()
+
+	c.OnMemoryAccess(func(access cpu.MemoryAccess) {
+		if _, ok := owned[access.Address]; !ok {
+			return
+		}
+
+		name := "lc3.device read"
+		if access.Write {
+			name = "lc3.device write"
+		}
+
+		now := time.Now()
+
+		t.child(name, now, now, map[string]any{
+			"lc3.device.address": fmt.Sprintf("x%04X", access.Address),
+			"lc3.device.value":   access.Value,
+			"lc3.pc":             fmt.Sprintf("x%04X", access.PC),
+		}, nil)
+	})
+}
+
+// end ends the span of the run with how it ended and exports the
+// spans.
+func (t *runTrace) end(run *Run) {
+	t.run.End = time.Now()
+	t.run.Attributes = map[string]any{
+		"lc3.run.id":       run.ID,
+		"lc3.status":       run.Status,
+		"lc3.halted":       run.Halted,
+		"lc3.instructions": run.Instructions,
+		"lc3.io":           run.IO,
+		"lc3.pc":           fmt.Sprintf("x%04X", run.PC),
+	}
+
+	if run.Exceeded != "" {
+		t.run.Attributes["lc3.exceeded"] = run.Exceeded
+	}
+
+	if t.dropped > 0 {
+		t.run.Attributes["lc3.spans.dropped"] = t.dropped
+	}
+
+	t.run.Error = run.Error
+
+	t.exporter.Export(append([]otlp.Span{t.run}, t.children...)...)
+}
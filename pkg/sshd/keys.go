@@ -0,0 +1,299 @@
+package sshd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// opensshMagic begins the keys of the OpenSSH private key format.
+const opensshMagic = "openssh-key-v1\x00"
+
+// PublicKey is a public key clients may authenticate with.
+type PublicKey struct {
+	// Type is the type of the key, such as ssh-ed25519.
+	Type string
+
+	// Blob is the key in the wire format.
+	Blob []byte
+
+	// Comment is the comment following the key, usually naming its
+	// owner.
+	Comment string
+}
+
+// ParseAuthorizedKeys parses keys in the format of OpenSSH authorized
+// keys files, a key per line. Options before the keys are not
+// supported, Ed25519 and RSA keys are.
+func ParseAuthorizedKeys(data []byte) ([]PublicKey, error) {
+	var keys []PublicKey
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: missing key", i+1)
+		}
+
+		if fields[0] != "ssh-ed25519" && fields[0] != "ssh-rsa" {
+			return nil, fmt.Errorf("line %d: unsupported key type %s", i+1, fields[0])
+		}
+
+		blob, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		r := &reader{buf: blob}
+		if r.string() != fields[0] {
+			return nil, fmt.Errorf("line %d: malformed %s key", i+1, fields[0])
+		}
+
+		keys = append(keys, PublicKey{
+			Type:    fields[0],
+			Blob:    blob,
+			Comment: strings.Join(fields[2:], " "),
+		})
+	}
+
+	return keys, nil
+}
+
+// LoadAuthorizedKeys reads the keys of an authorized keys file.
+func LoadAuthorizedKeys(filename string) ([]PublicKey, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := ParseAuthorizedKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return keys, nil
+}
+
+// verify checks the signature of data by the key in a blob, made with
+// an algorithm.
+func verify(blob []byte, algorithm string, data, signature []byte) error {
+	key := &reader{buf: blob}
+	sig := &reader{buf: signature}
+
+	keyType := key.string()
+	sigType := sig.string()
+	sigBlob := sig.bytes()
+
+	if key.err != nil || sig.err != nil {
+		return errors.New("malformed key or signature")
+	}
+
+	if sigType != algorithm {
+		return fmt.Errorf("signature of type %s, expected %s", sigType, algorithm)
+	}
+
+	switch {
+	case keyType == "ssh-ed25519" && algorithm == "ssh-ed25519":
+		pub := key.bytes()
+		if key.err != nil || len(pub) != ed25519.PublicKeySize {
+			return errors.New("malformed ssh-ed25519 key")
+		}
+
+		if !ed25519.Verify(pub, data, sigBlob) {
+			return errors.New("bad signature")
+		}
+
+		return nil
+	case keyType == "ssh-rsa" && (algorithm == "rsa-sha2-256" || algorithm == "rsa-sha2-512"):
+		e := key.mpint()
+		n := key.mpint()
+
+		if key.err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return errors.New("malformed ssh-rsa key")
+		}
+
+		pub := &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+		// signatures may come without their leading zeros.
+		if size := pub.Size(); len(sigBlob) < size {
+			sigBlob = append(make([]byte, size-len(sigBlob)), sigBlob...)
+		}
+
+		if algorithm == "rsa-sha2-256" {
+			sum := sha256.Sum256(data)
+			return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sigBlob)
+		}
+
+		sum := sha512.Sum512(data)
+
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA512, sum[:], sigBlob)
+	}
+
+	return fmt.Errorf("unsupported signature algorithm %s for %s key", algorithm, keyType)
+}
+
+// marshalEd25519 returns an Ed25519 public key in the wire format.
+func marshalEd25519(key ed25519.PublicKey) []byte {
+	return (&writer{}).string("ssh-ed25519").bytes(key).buf
+}
+
+// Fingerprint returns the SHA256 fingerprint of a host key, as OpenSSH
+// shows it when first connecting.
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(marshalEd25519(key))
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// LoadHostKey reads an Ed25519 host key from a file in the OpenSSH or
+// PKCS #8 format, generating the key and writing it in the OpenSSH
+// format if the file does not exist.
+func LoadHostKey(filename string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return generateHostKey(filename)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parseHostKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return key, nil
+}
+
+// generateHostKey generates a host key and writes it to a file.
+func generateHostKey(filename string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: marshalOpenSSH(key)})
+
+	if err := os.WriteFile(filename, data, 0o600); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// parseHostKey parses an unencrypted Ed25519 private key.
+func parseHostKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		if key, ok := key.(ed25519.PrivateKey); ok {
+			return key, nil
+		}
+
+		return nil, fmt.Errorf("unsupported key type %T, expected Ed25519", key)
+	case "OPENSSH PRIVATE KEY":
+		return parseOpenSSH(block.Bytes)
+	}
+
+	return nil, fmt.Errorf("unsupported PEM type %s", block.Type)
+}
+
+// parseOpenSSH parses a key in the OpenSSH private key format.
+func parseOpenSSH(data []byte) (ed25519.PrivateKey, error) {
+	if !bytes.HasPrefix(data, []byte(opensshMagic)) {
+		return nil, errors.New("not an OpenSSH private key")
+	}
+
+	r := &reader{buf: data[len(opensshMagic):]}
+	cipherName := r.string()
+	r.string()
+	r.string()
+	n := r.uint32()
+	r.bytes()
+	private := &reader{buf: r.bytes()}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if cipherName != "none" {
+		return nil, errors.New("encrypted keys are not supported")
+	}
+
+	if n != 1 {
+		return nil, fmt.Errorf("%d keys in file, expected 1", n)
+	}
+
+	check1, check2 := private.uint32(), private.uint32()
+	keyType := private.string()
+	private.bytes()
+	key := private.bytes()
+
+	if private.err != nil {
+		return nil, private.err
+	}
+
+	if check1 != check2 {
+		return nil, errors.New("malformed private key")
+	}
+
+	if keyType != "ssh-ed25519" || len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("unsupported key type %s, expected ssh-ed25519", keyType)
+	}
+
+	return ed25519.PrivateKey(key), nil
+}
+
+// marshalOpenSSH returns a key in the OpenSSH private key format.
+func marshalOpenSSH(key ed25519.PrivateKey) []byte {
+	pub := key.Public().(ed25519.PublicKey)
+
+	var check [4]byte
+	rand.Read(check[:])
+
+	private := (&writer{}).
+		uint32(binary.BigEndian.Uint32(check[:])).
+		uint32(binary.BigEndian.Uint32(check[:])).
+		string("ssh-ed25519").
+		bytes(pub).
+		bytes(key).
+		string("lc3 host key")
+
+	for i := byte(1); len(private.buf)%8 != 0; i++ {
+		private.byte(i)
+	}
+
+	w := &writer{buf: []byte(opensshMagic)}
+
+	return w.string("none").
+		string("none").
+		string("").
+		uint32(1).
+		bytes(marshalEd25519(pub)).
+		bytes(private.buf).buf
+}
@@ -0,0 +1,148 @@
+package sshd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newKey generates an Ed25519 key, failing the test on errors.
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+// authorizedLine renders a key as a line of an authorized keys file.
+func authorizedLine(key ed25519.PrivateKey, comment string) string {
+	blob := marshalEd25519(key.Public().(ed25519.PublicKey))
+	return "ssh-ed25519 " + base64.StdEncoding.EncodeToString(blob) + " " + comment
+}
+
+// TestParseAuthorizedKeys checks the keys and errors of authorized keys
+// files.
+func TestParseAuthorizedKeys(t *testing.T) {
+	key := newKey(t)
+	line := authorizedLine(key, "me@host")
+	blob := marshalEd25519(key.Public().(ed25519.PublicKey))
+
+	keys, err := ParseAuthorizedKeys([]byte("# keys\n\n" + line + "\n  " + line + " again\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("%d keys, expected 2", len(keys))
+	}
+
+	if keys[0].Type != "ssh-ed25519" || !bytes.Equal(keys[0].Blob, blob) || keys[0].Comment != "me@host" {
+		t.Errorf("key %+v", keys[0])
+	}
+
+	if keys[1].Comment != "me@host again" {
+		t.Errorf("comment %q", keys[1].Comment)
+	}
+
+	tests := map[string]string{
+		"missing key":  "ssh-ed25519\n",
+		"unsupported":  "ssh-dss AAAA\n",
+		"bad base64":   "ssh-ed25519 !!!\n",
+		"wrong type":   "ssh-rsa " + strings.Fields(line)[1] + "\n",
+		"line numbers": "\n" + line + "\nssh-ed25519\n",
+	}
+
+	for name, data := range tests {
+		if _, err := ParseAuthorizedKeys([]byte(data)); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+
+	if _, err := ParseAuthorizedKeys([]byte(tests["line numbers"])); err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("error %v, expected one on line 3", err)
+	}
+}
+
+// TestHostKey checks that a generated host key is read back, and that
+// keys in the PKCS #8 format are read too.
+func TestHostKey(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "host_key")
+
+	generated, err := LoadHostKey(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadHostKey(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !generated.Equal(loaded) {
+		t.Error("loaded a different key than generated")
+	}
+
+	key := newKey(t)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs8 := filepath.Join(t.TempDir(), "pkcs8")
+	if err := os.WriteFile(pkcs8, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded, err := LoadHostKey(pkcs8); err != nil || !key.Equal(loaded) {
+		t.Errorf("PKCS #8 key not loaded: %v", err)
+	}
+
+	if _, err := parseHostKey([]byte("not a key")); err == nil {
+		t.Error("parsed a file without a key")
+	}
+}
+
+// TestVerify checks signatures of Ed25519 keys.
+func TestVerify(t *testing.T) {
+	key := newKey(t)
+	blob := marshalEd25519(key.Public().(ed25519.PublicKey))
+	data := []byte("session data")
+	signature := (&writer{}).string("ssh-ed25519").bytes(ed25519.Sign(key, data)).buf
+
+	if err := verify(blob, "ssh-ed25519", data, signature); err != nil {
+		t.Errorf("good signature: %v", err)
+	}
+
+	if err := verify(blob, "ssh-ed25519", []byte("other data"), signature); err == nil {
+		t.Error("signature of other data verified")
+	}
+
+	if err := verify(blob, "rsa-sha2-256", data, signature); err == nil {
+		t.Error("signature of another algorithm verified")
+	}
+
+	if err := verify(blob[:10], "ssh-ed25519", data, signature); err == nil {
+		t.Error("truncated key verified")
+	}
+}
+
+// TestFingerprint checks the format of fingerprints.
+func TestFingerprint(t *testing.T) {
+	fingerprint := Fingerprint(newKey(t).Public().(ed25519.PublicKey))
+
+	if !strings.HasPrefix(fingerprint, "SHA256:") || len(fingerprint) != len("SHA256:")+43 {
+		t.Errorf("fingerprint %s", fingerprint)
+	}
+}
@@ -0,0 +1,265 @@
+// Package sshd serves interactive sessions over SSH, so that consoles
+// can be reached with any SSH client. It implements the part of the
+// protocol a terminal needs: curve25519-sha256 key exchange, an
+// Ed25519 host key, AES-CTR with HMAC-SHA2, password and public key
+// authentication, and session channels with a shell.
+package sshd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+)
+
+// maxAuthAttempts is the number of failed authentications after which
+// a connection is closed.
+const maxAuthAttempts = 6
+
+// Handler serves a session once the client asked for a shell. The
+// session exits with status 0 when it returns, if it was not exited.
+type Handler func(s *Session)
+
+// Server accepts SSH connections.
+type Server struct {
+	// hostKey identifies the server.
+	hostKey ed25519.PrivateKey
+
+	// handler serves the sessions.
+	handler Handler
+
+	// password is the password clients may authenticate with, if not
+	// empty.
+	password string
+
+	// keys are the keys clients may authenticate with.
+	keys []PublicKey
+
+	// logger logs connections, or is nil.
+	logger *slog.Logger
+}
+
+// Option configures a server.
+type Option func(*Server)
+
+// WithPassword lets clients authenticate with a password.
+func WithPassword(password string) Option {
+	return func(s *Server) {
+		s.password = password
+	}
+}
+
+// WithAuthorizedKeys lets clients authenticate with keys.
+func WithAuthorizedKeys(keys []PublicKey) Option {
+	return func(s *Server) {
+		s.keys = append(s.keys, keys...)
+	}
+}
+
+// WithLogger logs connections and their errors.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// NewServer creates a server identified by a host key, serving
+// sessions with a handler. Clients must authenticate with one of the
+// passwords or keys given as options.
+func NewServer(hostKey ed25519.PrivateKey, handler Handler, opts ...Option) (*Server, error) {
+	s := &Server{hostKey: hostKey, handler: handler}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.password == "" && len(s.keys) == 0 {
+		return nil, errors.New("no password or authorized keys to authenticate clients with")
+	}
+
+	return s, nil
+}
+
+// log logs a message about a connection at a level if the server
+// has a logger.
+func (s *Server) log(level slog.Level, msg string, conn net.Conn, args ...any) {
+	if s.logger != nil {
+		s.logger.Log(context.Background(), level, msg, append([]any{"remote", conn.RemoteAddr().String()}, args...)...)
+	}
+}
+
+// Serve accepts connections on a listener and serves each in its own
+// goroutine, until the listener fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			if err := s.ServeConn(conn); err != nil && !errors.Is(err, io.EOF) {
+				s.log(slog.LevelWarn, "connection failed", conn, "err", err)
+			}
+		}()
+	}
+}
+
+// ListenAndServe listens on a TCP address and serves the connections.
+func (s *Server) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	defer l.Close()
+
+	return s.Serve(l)
+}
+
+// ServeConn serves a connection until it is closed.
+func (s *Server) ServeConn(netConn net.Conn) error {
+	defer netConn.Close()
+
+	t, err := newTransport(netConn, s.hostKey)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.authenticate(t)
+	if err != nil {
+		return err
+	}
+
+	s.log(slog.LevelInfo, "logged in", netConn, "user", user)
+	defer s.log(slog.LevelInfo, "logged out", netConn, "user", user)
+
+	c := &conn{server: s, t: t, user: user, channels: map[uint32]*Session{}}
+	defer c.closeAll()
+
+	return c.serve()
+}
+
+// authenticate runs the authentication protocol, returning the name of
+// the user once authenticated.
+func (s *Server) authenticate(t *transport) (string, error) {
+	payload, err := t.readPacket()
+	if err != nil {
+		return "", err
+	}
+
+	r := &reader{buf: payload[1:]}
+	if payload[0] != msgServiceRequest || r.string() != "ssh-userauth" {
+		t.disconnect(disconnectServiceUnavail, "expected ssh-userauth")
+		return "", errors.New("no authentication requested")
+	}
+
+	if err := t.writePacket(newMessage(msgServiceAccept).string("ssh-userauth").buf); err != nil {
+		return "", err
+	}
+
+	var methods []string
+	if len(s.keys) > 0 {
+		methods = append(methods, "publickey")
+	}
+
+	if s.password != "" {
+		methods = append(methods, "password")
+	}
+
+	failures := 0
+
+	for {
+		payload, err := t.readPacket()
+		if err != nil {
+			return "", err
+		}
+
+		if payload[0] != msgUserAuthRequest {
+			continue
+		}
+
+		r := &reader{buf: payload[1:]}
+		user, service, method := r.string(), r.string(), r.string()
+
+		if r.err != nil {
+			return "", r.err
+		}
+
+		var ok bool
+
+		switch {
+		case service != "ssh-connection":
+		case method == "password" && s.password != "":
+			r.bool()
+			password := r.bytes()
+			ok = r.err == nil && subtle.ConstantTimeCompare(password, []byte(s.password)) == 1
+		case method == "publickey" && len(s.keys) > 0:
+			signed := r.bool()
+			algorithm := r.string()
+			blob := r.bytes()
+			signature := r.bytes()
+
+			if (signed && r.err != nil) || !s.authorized(blob) {
+				break
+			}
+
+			if !signed {
+				// the client asks whether the key would do before
+				// signing with it.
+				if err := t.writePacket(newMessage(msgUserAuthPKOK).string(algorithm).bytes(blob).buf); err != nil {
+					return "", err
+				}
+
+				continue
+			}
+
+			data := (&writer{}).
+				bytes(t.sessionID).
+				byte(msgUserAuthRequest).
+				string(user).
+				string(service).
+				string("publickey").
+				bool(true).
+				string(algorithm).
+				bytes(blob).buf
+
+			ok = verify(blob, algorithm, data, signature) == nil
+		}
+
+		if ok {
+			return user, t.writePacket([]byte{msgUserAuthSuccess})
+		}
+
+		// the none method clients start with is not a failed attempt.
+		if method != "none" {
+			failures++
+		}
+
+		if failures >= maxAuthAttempts {
+			t.disconnect(disconnectNoMoreAuth, "too many authentication failures")
+			return "", fmt.Errorf("%s failed to authenticate", user)
+		}
+
+		if err := t.writePacket(newMessage(msgUserAuthFailure).nameList(methods...).bool(false).buf); err != nil {
+			return "", err
+		}
+	}
+}
+
+// authorized reports whether a key is one clients may authenticate
+// with.
+func (s *Server) authorized(blob []byte) bool {
+	for _, key := range s.keys {
+		if bytes.Equal(key.Blob, blob) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,361 @@
+package sshd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clientVersion is the version string of the test client.
+const clientVersion = "SSH-2.0-test"
+
+// client is the client side of a connection, enough of it to log in
+// and run a shell.
+type client struct {
+	t *testing.T
+
+	// conn is the connection.
+	conn net.Conn
+
+	// r buffers reads from conn.
+	r *bufio.Reader
+
+	// in and out are the packets from and to the server.
+	in, out direction
+
+	// sessionID is the exchange hash of the key exchange.
+	sessionID []byte
+}
+
+// dial connects to a server identified by a host key and exchanges
+// keys with it.
+func dial(t *testing.T, address string, hostKey ed25519.PublicKey) *client {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	if _, err := io.WriteString(conn, clientVersion+"\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := c.r.ReadString('\n')
+	if err != nil || strings.TrimRight(version, "\r\n") != serverVersion {
+		t.Fatalf("version %q: %v", version, err)
+	}
+
+	clientInit := kexInit()
+	c.send(clientInit)
+	serverInit := c.expect(msgKexInit)
+
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.send(newMessage(msgKexECDHInit).bytes(private.PublicKey().Bytes()).buf)
+
+	r := c.reader(c.expect(msgKexECDHReply))
+	hostKeyBlob, serverPublic, signature := r.bytes(), r.bytes(), r.bytes()
+
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	if !bytes.Equal(hostKeyBlob, marshalEd25519(hostKey)) {
+		t.Fatal("server sent another host key")
+	}
+
+	peer, err := ecdh.X25519().NewPublicKey(serverPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := private.ECDH(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := sha256.New()
+	h.Write((&writer{}).
+		string(clientVersion).
+		string(serverVersion).
+		bytes(clientInit).
+		bytes(serverInit).
+		bytes(hostKeyBlob).
+		bytes(private.PublicKey().Bytes()).
+		bytes(serverPublic).
+		mpint(secret).buf)
+	c.sessionID = h.Sum(nil)
+
+	if err := verify(hostKeyBlob, "ssh-ed25519", c.sessionID, signature); err != nil {
+		t.Fatalf("host signature: %v", err)
+	}
+
+	c.expect(msgNewKeys)
+	c.send([]byte{msgNewKeys})
+
+	k := (&writer{}).mpint(secret).buf
+	derive := func(letter byte, n int) []byte {
+		return deriveKey(k, c.sessionID, c.sessionID, letter, n)
+	}
+
+	if c.out.stream, c.out.mac, err = newKeys(cipherAlgorithms[0], macAlgorithms[0], derive, 'A', 'C', 'E'); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.in.stream, c.in.mac, err = newKeys(cipherAlgorithms[0], macAlgorithms[0], derive, 'B', 'D', 'F'); err != nil {
+		t.Fatal(err)
+	}
+
+	c.send(newMessage(msgServiceRequest).string("ssh-userauth").buf)
+	c.expect(msgServiceAccept)
+
+	return c
+}
+
+// send sends a payload.
+func (c *client) send(payload []byte) {
+	c.t.Helper()
+
+	if err := c.out.write(c.conn, payload); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// receive returns the next payload.
+func (c *client) receive() []byte {
+	c.t.Helper()
+
+	payload, err := c.in.read(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return payload
+}
+
+// expect returns the next payload, which must be of a type.
+func (c *client) expect(msg byte) []byte {
+	c.t.Helper()
+
+	payload := c.receive()
+	if payload[0] != msg {
+		c.t.Fatalf("message %d, expected %d", payload[0], msg)
+	}
+
+	return payload
+}
+
+// reader returns a reader of the fields of a payload.
+func (c *client) reader(payload []byte) *reader {
+	return &reader{buf: payload[1:]}
+}
+
+// password authenticates with a password, reporting whether it was
+// accepted.
+func (c *client) password(user, password string) bool {
+	c.t.Helper()
+
+	c.send(newMessage(msgUserAuthRequest).string(user).string("ssh-connection").string("password").bool(false).string(password).buf)
+
+	return c.receive()[0] == msgUserAuthSuccess
+}
+
+// publicKey authenticates with a key, reporting whether it was
+// accepted.
+func (c *client) publicKey(user string, key ed25519.PrivateKey) bool {
+	c.t.Helper()
+
+	blob := marshalEd25519(key.Public().(ed25519.PublicKey))
+
+	data := (&writer{}).
+		bytes(c.sessionID).
+		byte(msgUserAuthRequest).
+		string(user).
+		string("ssh-connection").
+		string("publickey").
+		bool(true).
+		string("ssh-ed25519").
+		bytes(blob).buf
+
+	signature := (&writer{}).string("ssh-ed25519").bytes(ed25519.Sign(key, data)).buf
+
+	c.send(newMessage(msgUserAuthRequest).
+		string(user).
+		string("ssh-connection").
+		string("publickey").
+		bool(true).
+		string("ssh-ed25519").
+		bytes(blob).
+		bytes(signature).buf)
+
+	return c.receive()[0] == msgUserAuthSuccess
+}
+
+// shell opens a session with a terminal and a shell, sends input and
+// returns the output and exit status of the session.
+func (c *client) shell(input string) (string, uint32) {
+	c.t.Helper()
+
+	c.send(newMessage(msgChannelOpen).string("session").uint32(0).uint32(1 << 20).uint32(maxData).buf)
+	id := c.reader(c.expect(msgChannelOpenConfirm)).uint32()
+
+	c.send(newMessage(msgChannelRequest).uint32(id).string("pty-req").bool(true).string("xterm").uint32(80).uint32(24).uint32(0).uint32(0).string("").buf)
+	c.expect(msgChannelSuccess)
+
+	c.send(newMessage(msgChannelRequest).uint32(id).string("shell").bool(true).buf)
+	c.expect(msgChannelSuccess)
+
+	c.send(newMessage(msgChannelData).uint32(id).string(input).buf)
+	c.send(newMessage(msgChannelEOF).uint32(id).buf)
+
+	var (
+		output strings.Builder
+		status uint32
+	)
+
+	for {
+		payload := c.receive()
+		r := c.reader(payload)
+		r.uint32()
+
+		switch payload[0] {
+		case msgChannelData:
+			output.Write(r.bytes())
+		case msgChannelRequest:
+			if r.string() == "exit-status" {
+				r.bool()
+				status = r.uint32()
+			}
+		case msgChannelClose:
+			return output.String(), status
+		}
+	}
+}
+
+// serve starts a server on a local port, returning its address and
+// host key.
+func serve(t *testing.T, opts ...Option) (string, ed25519.PublicKey) {
+	t.Helper()
+
+	hostKey := newKey(t)
+
+	handler := func(s *Session) {
+		term, pty := s.Terminal()
+		fmt.Fprintf(s, "hello %s on %s %v\n", s.User(), term, pty)
+		io.Copy(s, s)
+	}
+
+	server, err := NewServer(hostKey, handler, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	go server.Serve(l)
+
+	return l.Addr().String(), hostKey.Public().(ed25519.PublicKey)
+}
+
+// TestPasswordSession checks a session logged into with a password.
+func TestPasswordSession(t *testing.T) {
+	address, hostKey := serve(t, WithPassword("secret"))
+
+	c := dial(t, address, hostKey)
+
+	if c.password("me", "wrong") {
+		t.Fatal("wrong password accepted")
+	}
+
+	if !c.password("me", "secret") {
+		t.Fatal("password refused")
+	}
+
+	output, status := c.shell("echo")
+
+	if output != "hello me on xterm true\necho" || status != 0 {
+		t.Errorf("output %q and status %d", output, status)
+	}
+}
+
+// TestPublicKeySession checks a session logged into with a key, and
+// that other keys are refused.
+func TestPublicKeySession(t *testing.T) {
+	key := newKey(t)
+
+	keys, err := ParseAuthorizedKeys([]byte(authorizedLine(key, "me")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address, hostKey := serve(t, WithAuthorizedKeys(keys))
+
+	c := dial(t, address, hostKey)
+
+	if c.publicKey("me", newKey(t)) {
+		t.Fatal("unknown key accepted")
+	}
+
+	if c.password("me", "") {
+		t.Fatal("password accepted without one")
+	}
+
+	if !c.publicKey("me", key) {
+		t.Fatal("key refused")
+	}
+
+	if output, _ := c.shell(""); output != "hello me on xterm true\n" {
+		t.Errorf("output %q", output)
+	}
+}
+
+// TestAuthAttempts checks that the connection is closed after too many
+// failed authentications.
+func TestAuthAttempts(t *testing.T) {
+	address, hostKey := serve(t, WithPassword("secret"))
+
+	c := dial(t, address, hostKey)
+
+	for i := 0; i < maxAuthAttempts-1; i++ {
+		if c.password("me", "wrong") {
+			t.Fatal("wrong password accepted")
+		}
+	}
+
+	c.send(newMessage(msgUserAuthRequest).string("me").string("ssh-connection").string("password").bool(false).string("wrong").buf)
+
+	if msg := c.receive()[0]; msg != msgDisconnect {
+		t.Errorf("message %d, expected a disconnect", msg)
+	}
+}
+
+// TestNewServer checks that servers need a way to authenticate
+// clients.
+func TestNewServer(t *testing.T) {
+	if _, err := NewServer(newKey(t), func(*Session) {}); err == nil {
+		t.Error("created a server without passwords or keys")
+	}
+}
@@ -0,0 +1,396 @@
+package sshd
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// The flow control of the channels.
+const (
+	// windowSize is the amount of data a client may send before it
+	// is read.
+	windowSize = 64 * 1024
+
+	// maxData is the size of the largest data message accepted.
+	maxData = 32 * 1024
+)
+
+// errClosed is returned when writing to closed sessions.
+var errClosed = errors.New("session closed")
+
+// conn is an authenticated connection serving channels.
+type conn struct {
+	// server is the server of the connection.
+	server *Server
+
+	// t is the transport of the connection.
+	t *transport
+
+	// user is the name of the authenticated user.
+	user string
+
+	// mu guards channels and nextID.
+	mu sync.Mutex
+
+	// channels maps the ids of the open channels to their sessions.
+	channels map[uint32]*Session
+
+	// nextID is the id of the next channel.
+	nextID uint32
+}
+
+// serve dispatches the messages of the connection until it fails.
+func (c *conn) serve() error {
+	for {
+		payload, err := c.t.readPacket()
+		if err != nil {
+			return err
+		}
+
+		r := &reader{buf: payload[1:]}
+
+		switch payload[0] {
+		case msgGlobalRequest:
+			r.string()
+			if r.bool() {
+				err = c.t.writePacket([]byte{msgRequestFailure})
+			}
+		case msgChannelOpen:
+			err = c.open(r)
+		case msgChannelWindowAdjust, msgChannelData, msgChannelExtendedData, msgChannelEOF, msgChannelClose, msgChannelRequest:
+			if s := c.channel(r.uint32()); s != nil {
+				err = s.handle(payload[0], r)
+			}
+		default:
+			err = c.t.writePacket(newMessage(msgUnimplemented).uint32(c.t.in.seq - 1).buf)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// channel returns the session of a channel id, or nil if it is not
+// open.
+func (c *conn) channel(id uint32) *Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.channels[id]
+}
+
+// open opens a channel if it is a session.
+func (c *conn) open(r *reader) error {
+	channelType := r.string()
+	peer := r.uint32()
+	window := r.uint32()
+	maxPacket := r.uint32()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	if channelType != "session" {
+		return c.t.writePacket(newMessage(msgChannelOpenFailure).
+			uint32(peer).
+			uint32(openUnknownChannelType).
+			string("only session channels are supported").
+			string("").buf)
+	}
+
+	c.mu.Lock()
+	s := &Session{
+		conn:       c,
+		id:         c.nextID,
+		peer:       peer,
+		peerWindow: window,
+		maxPacket:  min(maxPacket, maxData),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	c.channels[s.id] = s
+	c.nextID++
+	c.mu.Unlock()
+
+	return c.t.writePacket(newMessage(msgChannelOpenConfirm).
+		uint32(peer).
+		uint32(s.id).
+		uint32(windowSize).
+		uint32(maxData).buf)
+}
+
+// closeAll closes the sessions of a connection that ended.
+func (c *conn) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, s := range c.channels {
+		s.mu.Lock()
+		s.closed = true
+		s.sentClose = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+
+		delete(c.channels, id)
+	}
+}
+
+// Session is a session channel, reading what the client types and
+// writing to its terminal.
+type Session struct {
+	// conn is the connection of the session.
+	conn *conn
+
+	// id is the channel id of the session, and peer that of the
+	// client.
+	id, peer uint32
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// cond is signaled when data arrives, the window of the client
+	// grows or the session closes.
+	cond *sync.Cond
+
+	// in is the data received and not yet read.
+	in []byte
+
+	// consumed is the amount of data read since the window was last
+	// adjusted.
+	consumed uint32
+
+	// peerWindow is the amount of data the client accepts.
+	peerWindow uint32
+
+	// maxPacket is the size of the largest data message the client
+	// accepts.
+	maxPacket uint32
+
+	// eof is set once the client sent all its data.
+	eof bool
+
+	// closed is set once the client closed the channel.
+	closed bool
+
+	// sentClose is set once the server closed the channel.
+	sentClose bool
+
+	// pty is set if the client requested a terminal.
+	pty bool
+
+	// term is the terminal type of the client.
+	term string
+
+	// started is set once the client requested a shell.
+	started bool
+}
+
+// handle handles a channel message.
+func (s *Session) handle(msg byte, r *reader) error {
+	switch msg {
+	case msgChannelWindowAdjust:
+		n := r.uint32()
+
+		s.mu.Lock()
+		s.peerWindow += n
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	case msgChannelData:
+		data := r.bytes()
+
+		s.mu.Lock()
+		s.in = append(s.in, data...)
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	case msgChannelEOF:
+		s.mu.Lock()
+		s.eof = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	case msgChannelClose:
+		s.mu.Lock()
+		s.closed = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+
+		err := s.Close()
+
+		s.conn.mu.Lock()
+		delete(s.conn.channels, s.id)
+		s.conn.mu.Unlock()
+
+		return err
+	case msgChannelRequest:
+		return s.request(r)
+	}
+
+	return r.err
+}
+
+// request handles a channel request.
+func (s *Session) request(r *reader) error {
+	requestType := r.string()
+	wantReply := r.bool()
+
+	ok := false
+
+	s.mu.Lock()
+
+	switch requestType {
+	case "pty-req":
+		s.term = r.string()
+		s.pty = r.err == nil
+		ok = s.pty
+	case "shell":
+		ok = !s.started
+	}
+
+	start := ok && requestType == "shell"
+	if start {
+		s.started = true
+	}
+
+	s.mu.Unlock()
+
+	if wantReply {
+		reply := byte(msgChannelFailure)
+		if ok {
+			reply = msgChannelSuccess
+		}
+
+		if err := s.conn.t.writePacket(newMessage(reply).uint32(s.peer).buf); err != nil {
+			return err
+		}
+	}
+
+	if start {
+		go func() {
+			s.conn.server.handler(s)
+			s.Exit(0)
+		}()
+	}
+
+	return nil
+}
+
+// User returns the name the client authenticated as.
+func (s *Session) User() string {
+	return s.conn.user
+}
+
+// Terminal returns the terminal type of the client, and whether it
+// requested a terminal at all. Clients with a terminal send carriage
+// returns for the Enter key and expect them before line feeds.
+func (s *Session) Terminal() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.term, s.pty
+}
+
+// Read reads what the client sent, returning io.EOF once it sent
+// everything or the session closed.
+func (s *Session) Read(p []byte) (int, error) {
+	s.mu.Lock()
+
+	for len(s.in) == 0 && !s.eof && !s.closed {
+		s.cond.Wait()
+	}
+
+	if len(s.in) == 0 {
+		s.mu.Unlock()
+		return 0, io.EOF
+	}
+
+	n := copy(p, s.in)
+	s.in = s.in[n:]
+	s.consumed += uint32(n)
+
+	var adjust uint32
+	if s.consumed >= windowSize/2 && !s.sentClose {
+		adjust, s.consumed = s.consumed, 0
+	}
+
+	s.mu.Unlock()
+
+	if adjust > 0 {
+		if err := s.conn.t.writePacket(newMessage(msgChannelWindowAdjust).uint32(s.peer).uint32(adjust).buf); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// Write writes to the client, waiting while it does not accept more.
+func (s *Session) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		s.mu.Lock()
+
+		for s.peerWindow == 0 && !s.sentClose {
+			s.cond.Wait()
+		}
+
+		if s.sentClose {
+			s.mu.Unlock()
+			return written, errClosed
+		}
+
+		n := min(uint32(len(p)), s.peerWindow, s.maxPacket)
+		s.peerWindow -= n
+
+		s.mu.Unlock()
+
+		if err := s.conn.t.writePacket(newMessage(msgChannelData).uint32(s.peer).bytes(p[:n]).buf); err != nil {
+			return written, err
+		}
+
+		written += int(n)
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Exit closes the session with an exit status, which the client may
+// exit with.
+func (s *Session) Exit(status uint32) error {
+	s.mu.Lock()
+	closed := s.sentClose
+	s.mu.Unlock()
+
+	if closed {
+		return nil
+	}
+
+	request := newMessage(msgChannelRequest).uint32(s.peer).string("exit-status").bool(false).uint32(status).buf
+	if err := s.conn.t.writePacket(request); err != nil {
+		return err
+	}
+
+	return s.Close()
+}
+
+// Close closes the session.
+func (s *Session) Close() error {
+	s.mu.Lock()
+
+	if s.sentClose {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.sentClose = true
+	s.cond.Broadcast()
+
+	s.mu.Unlock()
+
+	if err := s.conn.t.writePacket(newMessage(msgChannelEOF).uint32(s.peer).buf); err != nil {
+		return err
+	}
+
+	return s.conn.t.writePacket(newMessage(msgChannelClose).uint32(s.peer).buf)
+}
@@ -0,0 +1,566 @@
+package sshd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"sync"
+)
+
+// serverVersion is the version string the server identifies with.
+const serverVersion = "SSH-2.0-lc3"
+
+// maxPacket is the size of the largest packet accepted.
+const maxPacket = 256 * 1024
+
+// The algorithms supported, in order of preference.
+var (
+	kexAlgorithms     = []string{"curve25519-sha256", "curve25519-sha256@libssh.org"}
+	hostKeyAlgorithms = []string{"ssh-ed25519"}
+	cipherAlgorithms  = []string{"aes128-ctr", "aes192-ctr", "aes256-ctr"}
+	macAlgorithms     = []string{"hmac-sha2-256", "hmac-sha2-512"}
+
+	// signatureAlgorithms are those clients may authenticate with.
+	signatureAlgorithms = []string{"ssh-ed25519", "rsa-sha2-256", "rsa-sha2-512"}
+)
+
+// cipherKeySizes maps the ciphers to the sizes of their keys.
+var cipherKeySizes = map[string]int{
+	"aes128-ctr": 16,
+	"aes192-ctr": 24,
+	"aes256-ctr": 32,
+}
+
+// macHashes maps the MACs to their hash functions.
+var macHashes = map[string]func() hash.Hash{
+	"hmac-sha2-256": sha256.New,
+	"hmac-sha2-512": sha512.New,
+}
+
+// direction is one direction of the binary packet protocol.
+type direction struct {
+	// seq is the sequence number of the next packet.
+	seq uint32
+
+	// stream encrypts the packets, or is nil before the first key
+	// exchange.
+	stream cipher.Stream
+
+	// mac authenticates the packets when stream is set.
+	mac hash.Hash
+}
+
+// blockSize returns the size packets are padded to a multiple of.
+func (d *direction) blockSize() int {
+	if d.stream == nil {
+		return 8
+	}
+
+	return aes.BlockSize
+}
+
+// write writes a packet carrying a payload.
+func (d *direction) write(w io.Writer, payload []byte) error {
+	blockSize := d.blockSize()
+
+	padding := blockSize - (5+len(payload))%blockSize
+	if padding < 4 {
+		padding += blockSize
+	}
+
+	packet := make([]byte, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(packet, uint32(1+len(payload)+padding))
+	packet[4] = byte(padding)
+	copy(packet[5:], payload)
+
+	if _, err := rand.Read(packet[5+len(payload):]); err != nil {
+		return err
+	}
+
+	var sum []byte
+
+	if d.stream != nil {
+		d.mac.Reset()
+		binary.Write(d.mac, binary.BigEndian, d.seq)
+		d.mac.Write(packet)
+		sum = d.mac.Sum(nil)
+
+		d.stream.XORKeyStream(packet, packet)
+	}
+
+	d.seq++
+
+	_, err := w.Write(append(packet, sum...))
+
+	return err
+}
+
+// read reads a packet, returning its payload.
+func (d *direction) read(r io.Reader) ([]byte, error) {
+	blockSize := d.blockSize()
+
+	first := make([]byte, blockSize)
+	if _, err := io.ReadFull(r, first); err != nil {
+		return nil, err
+	}
+
+	if d.stream != nil {
+		d.stream.XORKeyStream(first, first)
+	}
+
+	length := binary.BigEndian.Uint32(first)
+	if length < 5 || length > maxPacket || (length+4)%uint32(blockSize) != 0 {
+		return nil, fmt.Errorf("bad packet length %d", length)
+	}
+
+	packet := make([]byte, 4+length)
+	copy(packet, first)
+
+	if _, err := io.ReadFull(r, packet[blockSize:]); err != nil {
+		return nil, err
+	}
+
+	if d.stream != nil {
+		d.stream.XORKeyStream(packet[blockSize:], packet[blockSize:])
+
+		sum := make([]byte, d.mac.Size())
+		if _, err := io.ReadFull(r, sum); err != nil {
+			return nil, err
+		}
+
+		d.mac.Reset()
+		binary.Write(d.mac, binary.BigEndian, d.seq)
+		d.mac.Write(packet)
+
+		if subtle.ConstantTimeCompare(sum, d.mac.Sum(nil)) != 1 {
+			return nil, errors.New("packet authentication failed")
+		}
+	}
+
+	d.seq++
+
+	padding := uint32(packet[4])
+	if padding+1 >= length {
+		return nil, fmt.Errorf("bad padding length %d", padding)
+	}
+
+	return packet[5 : 4+length-padding], nil
+}
+
+// transport is the transport layer of a connection: the version
+// exchange and the binary packets, encrypted by key exchanges.
+type transport struct {
+	// conn is the connection.
+	conn net.Conn
+
+	// r buffers reads from conn.
+	r *bufio.Reader
+
+	// hostKey identifies the server.
+	hostKey ed25519.PrivateKey
+
+	// clientVersion is the version string of the client.
+	clientVersion []byte
+
+	// sessionID is the exchange hash of the first key exchange.
+	sessionID []byte
+
+	// in decodes packets from the client, only ever read by the
+	// goroutine serving the connection.
+	in direction
+
+	// writeMu guards out and is held through key exchanges, so that
+	// nothing else is sent during them.
+	writeMu sync.Mutex
+
+	// out encodes packets to the client.
+	out direction
+}
+
+// newTransport exchanges versions and keys with a client.
+func newTransport(conn net.Conn, hostKey ed25519.PrivateKey) (*transport, error) {
+	t := &transport{conn: conn, r: bufio.NewReader(conn), hostKey: hostKey}
+
+	if _, err := io.WriteString(conn, serverVersion+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	if err := t.readVersion(); err != nil {
+		return nil, err
+	}
+
+	if err := t.keyExchange(nil); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// readVersion reads the version string of the client, skipping the
+// lines it may send before.
+func (t *transport) readVersion() error {
+	for i := 0; i < 20; i++ {
+		line, err := t.r.ReadSlice('\n')
+		if err != nil {
+			return fmt.Errorf("reading version: %w", err)
+		}
+
+		line = bytes.TrimRight(line, "\r\n")
+		if !bytes.HasPrefix(line, []byte("SSH-")) {
+			continue
+		}
+
+		if !bytes.HasPrefix(line, []byte("SSH-2.0-")) && !bytes.HasPrefix(line, []byte("SSH-1.99-")) {
+			return fmt.Errorf("unsupported version %q", line)
+		}
+
+		t.clientVersion = append([]byte(nil), line...)
+
+		return nil
+	}
+
+	return errors.New("no version received")
+}
+
+// writePacket sends a payload.
+func (t *transport) writePacket(payload []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	return t.out.write(t.conn, payload)
+}
+
+// readPacket returns the next payload, handling the transport messages
+// and the key exchanges the client starts.
+func (t *transport) readPacket() ([]byte, error) {
+	for {
+		payload, err := t.in.read(t.r)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(payload) == 0 {
+			return nil, errors.New("empty packet")
+		}
+
+		switch payload[0] {
+		case msgIgnore, msgDebug, msgUnimplemented:
+			continue
+		case msgDisconnect:
+			return nil, io.EOF
+		case msgKexInit:
+			if err := t.keyExchange(payload); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		return payload, nil
+	}
+}
+
+// readKexPacket reads the next packet of a key exchange, which must be
+// of a type.
+func (t *transport) readKexPacket(msg byte) ([]byte, error) {
+	for {
+		payload, err := t.in.read(t.r)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(payload) == 0 {
+			return nil, errors.New("empty packet")
+		}
+
+		switch payload[0] {
+		case msgIgnore, msgDebug, msgUnimplemented:
+			continue
+		case msgDisconnect:
+			return nil, io.EOF
+		case msg:
+			return payload, nil
+		}
+
+		return nil, fmt.Errorf("unexpected message %d during key exchange", payload[0])
+	}
+}
+
+// disconnect tells the client why the connection ends.
+func (t *transport) disconnect(reason uint32, description string) {
+	t.writePacket(newMessage(msgDisconnect).uint32(reason).string(description).string("").buf)
+}
+
+// algorithms are the algorithms negotiated by a key exchange.
+type algorithms struct {
+	// kex is the key exchange method.
+	kex string
+
+	// in and out are the ciphers from and to the client.
+	in, out string
+
+	// macIn and macOut are the MACs from and to the client.
+	macIn, macOut string
+
+	// extInfo is set if the client accepts extension negotiation.
+	extInfo bool
+}
+
+// negotiate picks the first algorithm of the client the server
+// supports.
+func negotiate(kind string, client, server []string) (string, error) {
+	for _, c := range client {
+		for _, s := range server {
+			if c == s {
+				return c, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no common %s algorithm", kind)
+}
+
+// kexInit returns the key exchange init message of the server.
+func kexInit() []byte {
+	cookie := make([]byte, 16)
+	rand.Read(cookie)
+
+	w := newMessage(msgKexInit)
+	w.buf = append(w.buf, cookie...)
+
+	return w.nameList(kexAlgorithms...).
+		nameList(hostKeyAlgorithms...).
+		nameList(cipherAlgorithms...).
+		nameList(cipherAlgorithms...).
+		nameList(macAlgorithms...).
+		nameList(macAlgorithms...).
+		nameList("none").
+		nameList("none").
+		nameList().
+		nameList().
+		bool(false).
+		uint32(0).buf
+}
+
+// parseKexInit negotiates the algorithms offered in the key exchange
+// init message of the client. It also reports whether the client sent
+// a guess of the first key exchange packet that must be ignored.
+func parseKexInit(payload []byte) (*algorithms, bool, error) {
+	r := &reader{buf: payload[1:]}
+	if len(r.buf) < 16 {
+		return nil, false, errShort
+	}
+
+	r.buf = r.buf[16:]
+
+	kex := r.nameList()
+	hostKey := r.nameList()
+	cipherIn, cipherOut := r.nameList(), r.nameList()
+	macIn, macOut := r.nameList(), r.nameList()
+	compressionIn, compressionOut := r.nameList(), r.nameList()
+	r.nameList()
+	r.nameList()
+	guessed := r.bool()
+
+	if r.err != nil {
+		return nil, false, r.err
+	}
+
+	var (
+		algs algorithms
+		err  error
+		host string
+	)
+
+	negotiations := []struct {
+		kind   string
+		client []string
+		server []string
+		result *string
+	}{
+		{"key exchange", kex, kexAlgorithms, &algs.kex},
+		{"host key", hostKey, hostKeyAlgorithms, &host},
+		{"cipher", cipherIn, cipherAlgorithms, &algs.in},
+		{"cipher", cipherOut, cipherAlgorithms, &algs.out},
+		{"MAC", macIn, macAlgorithms, &algs.macIn},
+		{"MAC", macOut, macAlgorithms, &algs.macOut},
+		{"compression", compressionIn, []string{"none"}, new(string)},
+		{"compression", compressionOut, []string{"none"}, new(string)},
+	}
+
+	for _, n := range negotiations {
+		if *n.result, err = negotiate(n.kind, n.client, n.server); err != nil {
+			return nil, false, err
+		}
+	}
+
+	for _, name := range kex {
+		algs.extInfo = algs.extInfo || name == "ext-info-c"
+	}
+
+	wrongGuess := guessed && (kex[0] != algs.kex || hostKey[0] != host)
+
+	return &algs, wrongGuess, nil
+}
+
+// keyExchange runs a key exchange, started by the client with its init
+// message, or by the server if that is nil, and switches to its keys.
+func (t *transport) keyExchange(clientInit []byte) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	serverInit := kexInit()
+	if err := t.out.write(t.conn, serverInit); err != nil {
+		return err
+	}
+
+	if clientInit == nil {
+		var err error
+		if clientInit, err = t.readKexPacket(msgKexInit); err != nil {
+			return err
+		}
+	}
+
+	algs, wrongGuess, err := parseKexInit(clientInit)
+	if err != nil {
+		return err
+	}
+
+	if wrongGuess {
+		if _, err := t.in.read(t.r); err != nil {
+			return err
+		}
+	}
+
+	payload, err := t.readKexPacket(msgKexECDHInit)
+	if err != nil {
+		return err
+	}
+
+	r := &reader{buf: payload[1:]}
+	clientPublic := r.bytes()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	peer, err := ecdh.X25519().NewPublicKey(clientPublic)
+	if err != nil {
+		return err
+	}
+
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	secret, err := private.ECDH(peer)
+	if err != nil {
+		return err
+	}
+
+	hostKeyBlob := marshalEd25519(t.hostKey.Public().(ed25519.PublicKey))
+	serverPublic := private.PublicKey().Bytes()
+
+	h := sha256.New()
+	h.Write((&writer{}).
+		bytes(t.clientVersion).
+		string(serverVersion).
+		bytes(clientInit).
+		bytes(serverInit).
+		bytes(hostKeyBlob).
+		bytes(clientPublic).
+		bytes(serverPublic).
+		mpint(secret).buf)
+	exchangeHash := h.Sum(nil)
+
+	first := t.sessionID == nil
+	if first {
+		t.sessionID = exchangeHash
+	}
+
+	signature := (&writer{}).string("ssh-ed25519").bytes(ed25519.Sign(t.hostKey, exchangeHash)).buf
+
+	reply := newMessage(msgKexECDHReply).bytes(hostKeyBlob).bytes(serverPublic).bytes(signature).buf
+	if err := t.out.write(t.conn, reply); err != nil {
+		return err
+	}
+
+	if err := t.out.write(t.conn, []byte{msgNewKeys}); err != nil {
+		return err
+	}
+
+	k := (&writer{}).mpint(secret).buf
+	derive := func(letter byte, n int) []byte {
+		return deriveKey(k, exchangeHash, t.sessionID, letter, n)
+	}
+
+	if t.out.stream, t.out.mac, err = newKeys(algs.out, algs.macOut, derive, 'B', 'D', 'F'); err != nil {
+		return err
+	}
+
+	// clients only sign with RSA keys using SHA-2 if told the server
+	// verifies such signatures.
+	if first && algs.extInfo {
+		extInfo := newMessage(msgExtInfo).uint32(1).string("server-sig-algs").nameList(signatureAlgorithms...).buf
+		if err := t.out.write(t.conn, extInfo); err != nil {
+			return err
+		}
+	}
+
+	if _, err := t.readKexPacket(msgNewKeys); err != nil {
+		return err
+	}
+
+	t.in.stream, t.in.mac, err = newKeys(algs.in, algs.macIn, derive, 'A', 'C', 'E')
+
+	return err
+}
+
+// newKeys creates the cipher and MAC of a direction from the letters
+// deriving its IV, encryption key and integrity key.
+func newKeys(cipherName, macName string, derive func(letter byte, n int) []byte, iv, key, integrity byte) (cipher.Stream, hash.Hash, error) {
+	block, err := aes.NewCipher(derive(key, cipherKeySizes[cipherName]))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newHash := macHashes[macName]
+
+	return cipher.NewCTR(block, derive(iv, aes.BlockSize)), hmac.New(newHash, derive(integrity, newHash().Size())), nil
+}
+
+// deriveKey derives n bytes of key from the shared secret k, encoded as
+// an mpint, and the exchange hash.
+func deriveKey(k, exchangeHash, sessionID []byte, letter byte, n int) []byte {
+	h := sha256.New()
+	h.Write(k)
+	h.Write(exchangeHash)
+	h.Write([]byte{letter})
+	h.Write(sessionID)
+	key := h.Sum(nil)
+
+	for len(key) < n {
+		h.Reset()
+		h.Write(k)
+		h.Write(exchangeHash)
+		h.Write(key)
+		key = h.Sum(key)
+	}
+
+	return key[:n]
+}
@@ -0,0 +1,194 @@
+package sshd
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// The message numbers used.
+const (
+	msgDisconnect          = 1
+	msgIgnore              = 2
+	msgUnimplemented       = 3
+	msgDebug               = 4
+	msgServiceRequest      = 5
+	msgServiceAccept       = 6
+	msgExtInfo             = 7
+	msgKexInit             = 20
+	msgNewKeys             = 21
+	msgKexECDHInit         = 30
+	msgKexECDHReply        = 31
+	msgUserAuthRequest     = 50
+	msgUserAuthFailure     = 51
+	msgUserAuthSuccess     = 52
+	msgUserAuthPKOK        = 60
+	msgGlobalRequest       = 80
+	msgRequestFailure      = 82
+	msgChannelOpen         = 90
+	msgChannelOpenConfirm  = 91
+	msgChannelOpenFailure  = 92
+	msgChannelWindowAdjust = 93
+	msgChannelData         = 94
+	msgChannelExtendedData = 95
+	msgChannelEOF          = 96
+	msgChannelClose        = 97
+	msgChannelRequest      = 98
+	msgChannelSuccess      = 99
+	msgChannelFailure      = 100
+)
+
+// The reason codes of disconnects and failed channel opens.
+const (
+	disconnectServiceUnavail = 7
+	disconnectNoMoreAuth     = 14
+	openUnknownChannelType   = 3
+)
+
+// errShort is returned for messages that end before their fields.
+var errShort = errors.New("message too short")
+
+// reader decodes the fields of a message, remembering the first error
+// so that fields can be read in a row and the error checked once.
+type reader struct {
+	// buf is what remains of the message.
+	buf []byte
+
+	// err is the first error.
+	err error
+}
+
+// byte reads a byte.
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.buf) < 1 {
+		r.err = errShort
+		return 0
+	}
+
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+
+	return b
+}
+
+// bool reads a boolean.
+func (r *reader) bool() bool {
+	return r.byte() != 0
+}
+
+// uint32 reads a 32-bit integer.
+func (r *reader) uint32() uint32 {
+	if r.err != nil || len(r.buf) < 4 {
+		r.err = errShort
+		return 0
+	}
+
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+
+	return v
+}
+
+// bytes reads a string as bytes.
+func (r *reader) bytes() []byte {
+	n := r.uint32()
+	if r.err != nil || uint32(len(r.buf)) < n {
+		r.err = errShort
+		return nil
+	}
+
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+
+	return b
+}
+
+// string reads a string.
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+// nameList reads a comma-separated list of names.
+func (r *reader) nameList() []string {
+	s := r.string()
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, ",")
+}
+
+// mpint reads a multiple precision integer, which must not be negative.
+func (r *reader) mpint() *big.Int {
+	b := r.bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		r.err = errors.New("negative integer")
+	}
+
+	return new(big.Int).SetBytes(b)
+}
+
+// writer encodes the fields of a message.
+type writer struct {
+	// buf is the message so far.
+	buf []byte
+}
+
+// newMessage starts a message of a type.
+func newMessage(msg byte) *writer {
+	return &writer{buf: []byte{msg}}
+}
+
+// byte writes a byte.
+func (w *writer) byte(b byte) *writer {
+	w.buf = append(w.buf, b)
+	return w
+}
+
+// bool writes a boolean.
+func (w *writer) bool(b bool) *writer {
+	if b {
+		return w.byte(1)
+	}
+
+	return w.byte(0)
+}
+
+// uint32 writes a 32-bit integer.
+func (w *writer) uint32(v uint32) *writer {
+	w.buf = binary.BigEndian.AppendUint32(w.buf, v)
+	return w
+}
+
+// bytes writes bytes as a string.
+func (w *writer) bytes(b []byte) *writer {
+	w.uint32(uint32(len(b)))
+	w.buf = append(w.buf, b...)
+
+	return w
+}
+
+// string writes a string.
+func (w *writer) string(s string) *writer {
+	return w.bytes([]byte(s))
+}
+
+// nameList writes a comma-separated list of names.
+func (w *writer) nameList(names ...string) *writer {
+	return w.string(strings.Join(names, ","))
+}
+
+// mpint writes an unsigned integer given by its big-endian bytes as a
+// multiple precision integer.
+func (w *writer) mpint(b []byte) *writer {
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+
+	return w.bytes(b)
+}
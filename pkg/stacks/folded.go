@@ -0,0 +1,45 @@
+package stacks
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteFolded writes the samples as folded stacks, the input of
+// flamegraph.pl and speedscope: one line per chain of functions, the
+// outermost first, separated by semicolons and followed by the number
+// of instructions run in it. name names the entry of a function.
+//
+//	MAIN 14
+//	MAIN;PRINT 15
+//	MAIN;PRINT;SPACE 9
+func (s *Samples) WriteFolded(w io.Writer, name func(address uint16) string) error {
+	counts := map[string]uint64{}
+
+	s.Each(func(stack []Frame, count uint64) {
+		names := make([]string, len(stack))
+		for i, frame := range stack {
+			names[len(stack)-1-i] = strings.ReplaceAll(name(frame.Function), ";", ":")
+		}
+
+		counts[strings.Join(names, ";")] += count
+	})
+
+	folded := make([]string, 0, len(counts))
+	for stack := range counts {
+		folded = append(folded, stack)
+	}
+
+	sort.Strings(folded)
+
+	bw := bufio.NewWriter(w)
+
+	for _, stack := range folded {
+		fmt.Fprintf(bw, "%s %d\n", stack, counts[stack])
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,127 @@
+// Package stacks counts the instructions a program runs by the call
+// stack they run in, following calls and returns with a shadow call
+// stack, for flame graphs and profilers that show where time goes
+// across nested subroutines.
+package stacks
+
+import (
+	"encoding/binary"
+	"lc3/pkg/callstack"
+	"lc3/pkg/cpu"
+	"lc3/pkg/registers"
+	"sort"
+)
+
+// Frame is a place in a call stack.
+type Frame struct {
+	// Address is the address of the instruction running, or of the
+	// call for the frames of callers.
+	Address uint16
+
+	// Function is the entry of the subroutine the address belongs to,
+	// or of the program itself.
+	Function uint16
+}
+
+// Samples counts instructions by call stack.
+type Samples struct {
+	// counts are the instructions run by stack, encoded by encode.
+	counts map[string]uint64
+
+	// total is the number of instructions run.
+	total uint64
+}
+
+// New creates empty samples.
+func New() *Samples {
+	return &Samples{counts: map[string]uint64{}}
+}
+
+// Record counts the instructions a CPU runs by their call stack. The
+// first instruction it runs is the entry of the program.
+func (s *Samples) Record(c cpu.CPU) {
+	stack := callstack.New()
+
+	var (
+		root    uint16
+		started bool
+		frames  []Frame
+		key     []byte
+	)
+
+	c.OnInstruction(func(pc, instr uint16) {
+		if !started {
+			root, started = pc, true
+		}
+
+		// the running instruction first, then the calls leading to it,
+		// each in the function of the frame outside it.
+		frames = frames[:0]
+
+		function := root
+		if depth := stack.Depth(); depth > 0 {
+			function = stack.Frame(depth - 1).Target
+		}
+
+		frames = append(frames, Frame{Address: pc, Function: function})
+
+		for i := stack.Depth() - 1; i >= 0; i-- {
+			caller := root
+			if i > 0 {
+				caller = stack.Frame(i - 1).Target
+			}
+
+			frames = append(frames, Frame{Address: stack.Frame(i).Call, Function: caller})
+		}
+
+		key = encode(key[:0], frames)
+		s.counts[string(key)]++
+		s.total++
+
+		stack.Observe(pc, instr, c.Register(registers.RPC))
+	})
+}
+
+// encode appends the frames of a stack to a key.
+func encode(key []byte, frames []Frame) []byte {
+	for _, f := range frames {
+		key = binary.BigEndian.AppendUint16(key, f.Address)
+		key = binary.BigEndian.AppendUint16(key, f.Function)
+	}
+
+	return key
+}
+
+// decode returns the frames of a key.
+func decode(key string) []Frame {
+	frames := make([]Frame, 0, len(key)/4)
+
+	for i := 0; i+4 <= len(key); i += 4 {
+		frames = append(frames, Frame{
+			Address:  uint16(key[i])<<8 | uint16(key[i+1]),
+			Function: uint16(key[i+2])<<8 | uint16(key[i+3]),
+		})
+	}
+
+	return frames
+}
+
+// Total returns the number of instructions run.
+func (s *Samples) Total() uint64 {
+	return s.total
+}
+
+// Each calls fn with every stack, the running instruction first, and
+// the number of instructions run in it, in a stable order.
+func (s *Samples) Each(fn func(stack []Frame, count uint64)) {
+	keys := make([]string, 0, len(s.counts))
+	for key := range s.counts {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fn(decode(key), s.counts[key])
+	}
+}
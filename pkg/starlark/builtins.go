@@ -0,0 +1,1201 @@
+package starlark
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// universe returns the builtins of a thread.
+func (t *Thread) universe() map[string]Value {
+	fns := map[string]func(args Tuple, kwargs []Tuple) (Value, error){
+		"print": t.print,
+		"sorted": func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 1, 1, "key", "reverse"); err != nil {
+				return nil, err
+			}
+
+			elems, err := elements(args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			key, reverse := keyword(kwargs, "key"), keyword(kwargs, "reverse")
+			if key == nil || key == None {
+				return NewList(elems), sortValues(elems, reverse != nil && Truth(reverse))
+			}
+
+			// sort the keys, carrying the elements along.
+			keys := make([]Value, len(elems))
+			for i, elem := range elems {
+				k, err := t.Call(key, elem)
+				if err != nil {
+					return nil, err
+				}
+
+				keys[i] = Tuple{k, Int(i)}
+			}
+
+			if err := sortValues(keys, reverse != nil && Truth(reverse)); err != nil {
+				return nil, err
+			}
+
+			sorted := make([]Value, len(elems))
+			for i, k := range keys {
+				sorted[i] = elems[k.(Tuple)[1].(Int)]
+			}
+
+			return NewList(sorted), nil
+		},
+		"len":       builtinLen,
+		"range":     builtinRange,
+		"str":       builtinStr,
+		"repr":      builtinRepr,
+		"int":       builtinInt,
+		"hex":       builtinHex,
+		"bool":      builtinBool,
+		"list":      builtinList,
+		"tuple":     builtinTuple,
+		"dict":      builtinDict,
+		"reversed":  builtinReversed,
+		"min":       func(args Tuple, kwargs []Tuple) (Value, error) { return extreme(args, kwargs, -1) },
+		"max":       func(args Tuple, kwargs []Tuple) (Value, error) { return extreme(args, kwargs, 1) },
+		"abs":       builtinAbs,
+		"chr":       builtinChr,
+		"ord":       builtinOrd,
+		"enumerate": builtinEnumerate,
+		"zip":       builtinZip,
+		"type":      builtinType,
+		"fail":      builtinFail,
+		"any":       func(args Tuple, kwargs []Tuple) (Value, error) { return truthOf(args, kwargs, true) },
+		"all":       func(args Tuple, kwargs []Tuple) (Value, error) { return truthOf(args, kwargs, false) },
+	}
+
+	builtins := make(map[string]Value, len(fns))
+	for name, fn := range fns {
+		builtins[name] = NewBuiltin(name, fn)
+	}
+
+	return builtins
+}
+
+// checkArgs checks the number of positional arguments of a call and
+// the names of its keyword arguments.
+func checkArgs(args Tuple, kwargs []Tuple, lo, hi int, names ...string) error {
+	switch {
+	case len(args) < lo:
+		return fmt.Errorf("got %d arguments, want at least %d", len(args), lo)
+	case hi >= 0 && len(args) > hi:
+		return fmt.Errorf("got %d arguments, want at most %d", len(args), hi)
+	}
+
+next:
+	for _, kv := range kwargs {
+		for _, name := range names {
+			if string(kv[0].(String)) == name {
+				continue next
+			}
+		}
+
+		return fmt.Errorf("unexpected keyword argument %s", kv[0].(String))
+	}
+
+	return nil
+}
+
+// keyword returns the value of a keyword argument, nil if absent.
+func keyword(kwargs []Tuple, name string) Value {
+	for _, kv := range kwargs {
+		if string(kv[0].(String)) == name {
+			return kv[1]
+		}
+	}
+
+	return nil
+}
+
+// print writes its arguments separated by spaces, or by sep.
+func (t *Thread) print(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 0, -1, "sep"); err != nil {
+		return nil, err
+	}
+
+	sep := " "
+	if v := keyword(kwargs, "sep"); v != nil {
+		s, err := AsString(v)
+		if err != nil {
+			return nil, err
+		}
+
+		sep = s
+	}
+
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = Str(arg)
+	}
+
+	fmt.Fprintln(t.out, strings.Join(parts, sep))
+
+	return None, nil
+}
+
+func builtinLen(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, 1); err != nil {
+		return nil, err
+	}
+
+	switch x := args[0].(type) {
+	case String:
+		return Int(len(x)), nil
+	case Tuple:
+		return Int(len(x)), nil
+	case *List:
+		return Int(len(x.Elems)), nil
+	case *Dict:
+		return Int(x.Len()), nil
+	}
+
+	return nil, fmt.Errorf("%s has no len", args[0].Type())
+}
+
+// maxRange caps the length of ranges, which are lists here.
+const maxRange = 1 << 24
+
+func builtinRange(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, 3); err != nil {
+		return nil, err
+	}
+
+	bounds := make([]int, len(args))
+	for i, arg := range args {
+		n, err := AsInt(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		bounds[i] = n
+	}
+
+	start, stop, step := 0, bounds[0], 1
+	if len(bounds) > 1 {
+		start, stop = bounds[0], bounds[1]
+	}
+
+	if len(bounds) > 2 {
+		step = bounds[2]
+	}
+
+	if step == 0 {
+		return nil, fmt.Errorf("step is zero")
+	}
+
+	var elems []Value
+	for i := start; step > 0 && i < stop || step < 0 && i > stop; i += step {
+		if len(elems) == maxRange {
+			return nil, fmt.Errorf("range longer than %d", maxRange)
+		}
+
+		elems = append(elems, Int(i))
+	}
+
+	return NewList(elems), nil
+}
+
+func builtinStr(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, 1); err != nil {
+		return nil, err
+	}
+
+	return String(Str(args[0])), nil
+}
+
+func builtinRepr(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, 1); err != nil {
+		return nil, err
+	}
+
+	return String(Repr(args[0])), nil
+}
+
+func builtinInt(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, 2, "base"); err != nil {
+		return nil, err
+	}
+
+	baseArg := keyword(kwargs, "base")
+	if len(args) == 2 {
+		baseArg = args[1]
+	}
+
+	switch x := args[0].(type) {
+	case Int:
+		if baseArg == nil {
+			return x, nil
+		}
+	case Bool:
+		if baseArg == nil {
+			return boolInt(x), nil
+		}
+	case String:
+		base := 10
+		if baseArg != nil {
+			b, err := AsInt(baseArg)
+			if err != nil {
+				return nil, err
+			}
+
+			base = b
+		}
+
+		s := strings.ReplaceAll(strings.TrimSpace(string(x)), "_", "")
+
+		n, err := strconv.ParseInt(s, base, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid literal with base %d: %q", base, string(x))
+		}
+
+		return Int(n), nil
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to int", args[0].Type())
+}
+
+func builtinHex(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, 1); err != nil {
+		return nil, err
+	}
+
+	n, err := AsInt(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 {
+		return String(fmt.Sprintf("-0x%x", -n)), nil
+	}
+
+	return String(fmt.Sprintf("0x%x", n)), nil
+}
+
+func builtinBool(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 0, 1); err != nil {
+		return nil, err
+	}
+
+	return Bool(len(args) == 1 && Truth(args[0])), nil
+}
+
+func builtinList(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 0, 1); err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return NewList(nil), nil
+	}
+
+	elems, err := iterate(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return NewList(append([]Value(nil), elems...)), nil
+}
+
+func builtinTuple(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 0, 1); err != nil {
+		return nil, err
+	}
+
+	if len(args) == 0 {
+		return Tuple{}, nil
+	}
+
+	elems, err := iterate(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return append(Tuple{}, elems...), nil
+}
+
+// iterate returns the elements of an iterable, or the characters of a
+// string, for the conversions.
+func iterate(v Value) ([]Value, error) {
+	s, ok := v.(String)
+	if !ok {
+		return elements(v)
+	}
+
+	elems := make([]Value, len(s))
+	for i := range s {
+		elems[i] = s[i : i+1]
+	}
+
+	return elems, nil
+}
+
+func builtinDict(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 0, 1); err != nil {
+		return nil, err
+	}
+
+	d := NewDict()
+
+	if len(args) == 1 {
+		if err := update(d, args[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, kv := range kwargs {
+		d.Set(kv[0], kv[1])
+	}
+
+	return d, nil
+}
+
+// update sets the keys of a dict from another dict or from pairs.
+func update(d *Dict, from Value) error {
+	if src, ok := from.(*Dict); ok {
+		for _, k := range src.keys {
+			v, _, _ := src.Get(k)
+			d.Set(k, v)
+		}
+
+		return nil
+	}
+
+	pairs, err := elements(from)
+	if err != nil {
+		return err
+	}
+
+	for _, pair := range pairs {
+		kv, err := elements(pair)
+		if err != nil || len(kv) != 2 {
+			return fmt.Errorf("dict update sequence element is not a pair")
+		}
+
+		if err := d.Set(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func builtinReversed(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, 1); err != nil {
+		return nil, err
+	}
+
+	elems, err := iterate(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	reversed := make([]Value, len(elems))
+	for i, elem := range elems {
+		reversed[len(elems)-1-i] = elem
+	}
+
+	return NewList(reversed), nil
+}
+
+// extreme returns the least element if sign is -1, or the greatest if
+// sign is 1, of an iterable or of the arguments.
+func extreme(args Tuple, kwargs []Tuple, sign int) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, -1); err != nil {
+		return nil, err
+	}
+
+	elems := []Value(args)
+	if len(args) == 1 {
+		var err error
+		if elems, err = elements(args[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("empty sequence")
+	}
+
+	best := elems[0]
+	for _, elem := range elems[1:] {
+		c, err := compare(elem, best)
+		if err != nil {
+			return nil, err
+		}
+
+		if c == sign {
+			best = elem
+		}
+	}
+
+	return best, nil
+}
+
+func builtinAbs(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, 1); err != nil {
+		return nil, err
+	}
+
+	n, err := AsInt(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 {
+		n = -n
+	}
+
+	return Int(n), nil
+}
+
+func builtinChr(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, 1); err != nil {
+		return nil, err
+	}
+
+	n, err := AsInt(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 || n > 0xff {
+		return nil, fmt.Errorf("%d is not a byte", n)
+	}
+
+	return String([]byte{byte(n)}), nil
+}
+
+func builtinOrd(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, 1); err != nil {
+		return nil, err
+	}
+
+	s, err := AsString(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s) != 1 {
+		return nil, fmt.Errorf("string of length %d, want 1", len(s))
+	}
+
+	return Int(s[0]), nil
+}
+
+func builtinEnumerate(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, 2, "start"); err != nil {
+		return nil, err
+	}
+
+	start := keyword(kwargs, "start")
+	if len(args) == 2 {
+		start = args[1]
+	}
+
+	first := 0
+	if start != nil {
+		n, err := AsInt(start)
+		if err != nil {
+			return nil, err
+		}
+
+		first = n
+	}
+
+	elems, err := iterate(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := make([]Value, len(elems))
+	for i, elem := range elems {
+		pairs[i] = Tuple{Int(first + i), elem}
+	}
+
+	return NewList(pairs), nil
+}
+
+func builtinZip(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 0, -1); err != nil {
+		return nil, err
+	}
+
+	var seqs [][]Value
+
+	for _, arg := range args {
+		elems, err := iterate(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		seqs = append(seqs, elems)
+	}
+
+	var tuples []Value
+
+	for i := 0; len(seqs) > 0; i++ {
+		t := make(Tuple, len(seqs))
+
+		for j, seq := range seqs {
+			if i >= len(seq) {
+				return NewList(tuples), nil
+			}
+
+			t[j] = seq[i]
+		}
+
+		tuples = append(tuples, t)
+	}
+
+	return NewList(tuples), nil
+}
+
+func builtinType(args Tuple, kwargs []Tuple) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, 1); err != nil {
+		return nil, err
+	}
+
+	return String(args[0].Type()), nil
+}
+
+func builtinFail(args Tuple, kwargs []Tuple) (Value, error) {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = Str(arg)
+	}
+
+	return nil, fmt.Errorf("%s", strings.Join(parts, " "))
+}
+
+// truthOf reports whether any element is true if want is true, or
+// whether all are if it is false.
+func truthOf(args Tuple, kwargs []Tuple, want bool) (Value, error) {
+	if err := checkArgs(args, kwargs, 1, 1); err != nil {
+		return nil, err
+	}
+
+	elems, err := elements(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, elem := range elems {
+		if Truth(elem) == want {
+			return Bool(want), nil
+		}
+	}
+
+	return Bool(!want), nil
+}
+
+// format formats values as the % operator does, with the conversions
+// d, i, x, X, o, c, s and r and their flags and widths.
+func format(template string, v Value) (string, error) {
+	args := []Value{v}
+	if t, ok := v.(Tuple); ok {
+		args = t
+	}
+
+	var sb strings.Builder
+
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+
+		j := i + 1
+		for j < len(template) && strings.IndexByte("-+ #0123456789", template[j]) >= 0 {
+			j++
+		}
+
+		if j == len(template) {
+			return "", fmt.Errorf("incomplete format")
+		}
+
+		spec, verb := template[i+1:j], template[j]
+		i = j
+
+		if verb == '%' {
+			sb.WriteByte('%')
+			continue
+		}
+
+		if len(args) == 0 {
+			return "", fmt.Errorf("not enough arguments for format string")
+		}
+
+		arg := args[0]
+		args = args[1:]
+
+		s, err := formatValue(spec, verb, arg)
+		if err != nil {
+			return "", err
+		}
+
+		sb.WriteString(s)
+	}
+
+	if len(args) > 0 {
+		return "", fmt.Errorf("too many arguments for format string")
+	}
+
+	return sb.String(), nil
+}
+
+// formatValue formats a value with printf-style flags and a verb.
+func formatValue(spec string, verb byte, v Value) (string, error) {
+	switch verb {
+	case 's':
+		return fmt.Sprintf("%"+spec+"s", Str(v)), nil
+	case 'r':
+		return fmt.Sprintf("%"+spec+"s", Repr(v)), nil
+	case 'c':
+		if s, ok := v.(String); ok && len(s) == 1 {
+			return fmt.Sprintf("%"+spec+"s", s), nil
+		}
+
+		n, err := AsInt(v)
+		if err != nil || n < 0 || n > 0xff {
+			return "", fmt.Errorf("%%c requires a byte or a string of length 1")
+		}
+
+		return fmt.Sprintf("%"+spec+"c", rune(n)), nil
+	case 'd', 'i', 'x', 'X', 'o':
+		if b, ok := v.(Bool); ok {
+			v = boolInt(b)
+		}
+
+		n, err := AsInt(v)
+		if err != nil {
+			return "", fmt.Errorf("%%%c format: %w", verb, err)
+		}
+
+		if verb == 'i' {
+			verb = 'd'
+		}
+
+		return fmt.Sprintf("%"+spec+string(verb), n), nil
+	}
+
+	return "", fmt.Errorf("unsupported format character %q", verb)
+}
+
+// method returns a method of a value bound to it.
+func method(recv Value, name string) (Value, bool) {
+	var fn func(args Tuple, kwargs []Tuple) (Value, error)
+
+	switch x := recv.(type) {
+	case *List:
+		fn = listMethod(x, name)
+	case *Dict:
+		fn = dictMethod(x, name)
+	case String:
+		fn = stringMethod(x, name)
+	}
+
+	if fn == nil {
+		return nil, false
+	}
+
+	return &Builtin{name: name, fn: fn, recv: recv}, true
+}
+
+// listMethod returns a method of a list, nil if it has none of the
+// name.
+func listMethod(l *List, name string) func(args Tuple, kwargs []Tuple) (Value, error) {
+	switch name {
+	case "append":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 1, 1); err != nil {
+				return nil, err
+			}
+
+			l.Elems = append(l.Elems, args[0])
+
+			return None, nil
+		}
+	case "extend":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 1, 1); err != nil {
+				return nil, err
+			}
+
+			elems, err := elements(args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			l.Elems = append(l.Elems, elems...)
+
+			return None, nil
+		}
+	case "insert":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 2, 2); err != nil {
+				return nil, err
+			}
+
+			i, err := AsInt(args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			if i < 0 {
+				i += len(l.Elems)
+			}
+
+			i = min(max(i, 0), len(l.Elems))
+
+			l.Elems = append(l.Elems[:i], append([]Value{args[1]}, l.Elems[i:]...)...)
+
+			return None, nil
+		}
+	case "pop":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 0, 1); err != nil {
+				return nil, err
+			}
+
+			i := len(l.Elems) - 1
+			if len(args) == 1 {
+				n, err := AsInt(args[0])
+				if err != nil {
+					return nil, err
+				}
+
+				i = n
+				if i < 0 {
+					i += len(l.Elems)
+				}
+			}
+
+			if i < 0 || i >= len(l.Elems) {
+				return nil, fmt.Errorf("index out of range")
+			}
+
+			v := l.Elems[i]
+			l.Elems = append(l.Elems[:i], l.Elems[i+1:]...)
+
+			return v, nil
+		}
+	case "index", "remove":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 1, 1); err != nil {
+				return nil, err
+			}
+
+			for i, elem := range l.Elems {
+				if !equal(elem, args[0]) {
+					continue
+				}
+
+				if name == "index" {
+					return Int(i), nil
+				}
+
+				l.Elems = append(l.Elems[:i], l.Elems[i+1:]...)
+
+				return None, nil
+			}
+
+			return nil, fmt.Errorf("%s not in list", Repr(args[0]))
+		}
+	case "clear":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 0, 0); err != nil {
+				return nil, err
+			}
+
+			l.Elems = nil
+
+			return None, nil
+		}
+	}
+
+	return nil
+}
+
+// dictMethod returns a method of a dict, nil if it has none of the
+// name.
+func dictMethod(d *Dict, name string) func(args Tuple, kwargs []Tuple) (Value, error) {
+	switch name {
+	case "get":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 1, 2); err != nil {
+				return nil, err
+			}
+
+			v, found, err := d.Get(args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			if found {
+				return v, nil
+			}
+
+			if len(args) == 2 {
+				return args[1], nil
+			}
+
+			return None, nil
+		}
+	case "setdefault":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 1, 2); err != nil {
+				return nil, err
+			}
+
+			v, found, err := d.Get(args[0])
+			if err != nil || found {
+				return v, err
+			}
+
+			v = None
+			if len(args) == 2 {
+				v = args[1]
+			}
+
+			return v, d.Set(args[0], v)
+		}
+	case "pop":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 1, 2); err != nil {
+				return nil, err
+			}
+
+			v, found, err := d.Delete(args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			switch {
+			case found:
+				return v, nil
+			case len(args) == 2:
+				return args[1], nil
+			}
+
+			return nil, fmt.Errorf("key %s not in dict", Repr(args[0]))
+		}
+	case "keys", "values", "items":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 0, 0); err != nil {
+				return nil, err
+			}
+
+			elems := make([]Value, len(d.keys))
+			for i, k := range d.keys {
+				v, _, _ := d.Get(k)
+
+				switch name {
+				case "keys":
+					elems[i] = k
+				case "values":
+					elems[i] = v
+				default:
+					elems[i] = Tuple{k, v}
+				}
+			}
+
+			return NewList(elems), nil
+		}
+	case "update":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 0, 1); err != nil {
+				return nil, err
+			}
+
+			if len(args) == 1 {
+				if err := update(d, args[0]); err != nil {
+					return nil, err
+				}
+			}
+
+			for _, kv := range kwargs {
+				d.Set(kv[0], kv[1])
+			}
+
+			return None, nil
+		}
+	case "clear":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 0, 0); err != nil {
+				return nil, err
+			}
+
+			d.keys, d.values = nil, map[string]Value{}
+
+			return None, nil
+		}
+	}
+
+	return nil
+}
+
+// stringMethod returns a method of a string, nil if it has none of
+// the name.
+func stringMethod(s String, name string) func(args Tuple, kwargs []Tuple) (Value, error) {
+	str := string(s)
+
+	// unary wraps methods taking no arguments.
+	unary := func(fn func(string) string) func(args Tuple, kwargs []Tuple) (Value, error) {
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 0, 0); err != nil {
+				return nil, err
+			}
+
+			return String(fn(str)), nil
+		}
+	}
+
+	// strip wraps the methods trimming characters, spaces by default.
+	strip := func(fn func(string, string) string) func(args Tuple, kwargs []Tuple) (Value, error) {
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 0, 1); err != nil {
+				return nil, err
+			}
+
+			cutset := " \t\n\r\v\f"
+			if len(args) == 1 && args[0] != None {
+				c, err := AsString(args[0])
+				if err != nil {
+					return nil, err
+				}
+
+				cutset = c
+			}
+
+			return String(fn(str, cutset)), nil
+		}
+	}
+
+	// test wraps the methods testing a substring.
+	test := func(fn func(string, string) bool) func(args Tuple, kwargs []Tuple) (Value, error) {
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 1, 1); err != nil {
+				return nil, err
+			}
+
+			subs := []Value{args[0]}
+			if t, ok := args[0].(Tuple); ok {
+				subs = t
+			}
+
+			for _, sub := range subs {
+				sub, err := AsString(sub)
+				if err != nil {
+					return nil, err
+				}
+
+				if fn(str, sub) {
+					return True, nil
+				}
+			}
+
+			return False, nil
+		}
+	}
+
+	switch name {
+	case "upper":
+		return unary(strings.ToUpper)
+	case "lower":
+		return unary(strings.ToLower)
+	case "strip":
+		return strip(strings.Trim)
+	case "lstrip":
+		return strip(strings.TrimLeft)
+	case "rstrip":
+		return strip(strings.TrimRight)
+	case "startswith":
+		return test(strings.HasPrefix)
+	case "endswith":
+		return test(strings.HasSuffix)
+	case "find", "count", "index":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 1, 1); err != nil {
+				return nil, err
+			}
+
+			sub, err := AsString(args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			if name == "count" {
+				return Int(strings.Count(str, sub)), nil
+			}
+
+			i := strings.Index(str, sub)
+			if i < 0 && name == "index" {
+				return nil, fmt.Errorf("substring not found")
+			}
+
+			return Int(i), nil
+		}
+	case "replace":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 2, 3); err != nil {
+				return nil, err
+			}
+
+			old, err := AsString(args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			repl, err := AsString(args[1])
+			if err != nil {
+				return nil, err
+			}
+
+			n := -1
+			if len(args) == 3 {
+				if n, err = AsInt(args[2]); err != nil {
+					return nil, err
+				}
+			}
+
+			return String(strings.Replace(str, old, repl, n)), nil
+		}
+	case "split":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 0, 1); err != nil {
+				return nil, err
+			}
+
+			var parts []string
+			if len(args) == 0 || args[0] == None {
+				parts = strings.Fields(str)
+			} else {
+				sep, err := AsString(args[0])
+				if err != nil {
+					return nil, err
+				}
+
+				if sep == "" {
+					return nil, fmt.Errorf("empty separator")
+				}
+
+				parts = strings.Split(str, sep)
+			}
+
+			elems := make([]Value, len(parts))
+			for i, part := range parts {
+				elems[i] = String(part)
+			}
+
+			return NewList(elems), nil
+		}
+	case "join":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			if err := checkArgs(args, kwargs, 1, 1); err != nil {
+				return nil, err
+			}
+
+			elems, err := elements(args[0])
+			if err != nil {
+				return nil, err
+			}
+
+			parts := make([]string, len(elems))
+			for i, elem := range elems {
+				if parts[i], err = AsString(elem); err != nil {
+					return nil, err
+				}
+			}
+
+			return String(strings.Join(parts, str)), nil
+		}
+	case "format":
+		return func(args Tuple, kwargs []Tuple) (Value, error) {
+			return formatBraces(str, args, kwargs)
+		}
+	}
+
+	return nil
+}
+
+// formatBraces formats values as str.format does, replacing {}, {n}
+// and {name} fields, optionally with a spec such as {:04x}.
+func formatBraces(template string, args Tuple, kwargs []Tuple) (Value, error) {
+	var sb strings.Builder
+
+	auto := 0
+
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+
+		switch {
+		case strings.HasPrefix(template[i:], "{{"), strings.HasPrefix(template[i:], "}}"):
+			sb.WriteByte(c)
+			i++
+
+			continue
+		case c != '{':
+			sb.WriteByte(c)
+			continue
+		}
+
+		end := strings.IndexByte(template[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unmatched '{' in format")
+		}
+
+		field, spec, _ := strings.Cut(template[i+1:i+end], ":")
+		i += end
+
+		var v Value
+
+		switch n, err := strconv.Atoi(field); {
+		case field == "":
+			if auto >= len(args) {
+				return nil, fmt.Errorf("not enough arguments for format string")
+			}
+
+			v = args[auto]
+			auto++
+		case err == nil:
+			if n < 0 || n >= len(args) {
+				return nil, fmt.Errorf("format index %d out of range", n)
+			}
+
+			v = args[n]
+		default:
+			if v = keyword(kwargs, field); v == nil {
+				return nil, fmt.Errorf("no argument named %s", field)
+			}
+		}
+
+		if spec == "" {
+			sb.WriteString(Str(v))
+			continue
+		}
+
+		verb := spec[len(spec)-1]
+		if strings.IndexByte("dxXoscr", verb) < 0 {
+			verb = 's'
+		} else {
+			spec = spec[:len(spec)-1]
+		}
+
+		s, err := formatValue(strings.Replace(spec, ">", "", 1), verb, v)
+		if err != nil {
+			return nil, err
+		}
+
+		sb.WriteString(s)
+	}
+
+	return String(sb.String()), nil
+}
@@ -0,0 +1,1186 @@
+// Package starlark interprets the subset of the Starlark language that
+// instrumentation scripts need: ints, strings, lists, tuples, dicts,
+// functions, if and for statements and list comprehensions, without
+// floats, sets, lambdas, while loops or load.
+package starlark
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxDepth caps the depth of nested calls.
+const maxDepth = 200
+
+// Error is an error of a script, where it happened.
+type Error struct {
+	// Pos is where the error happened.
+	Pos Position
+
+	// Msg describes the error.
+	Msg string
+}
+
+// Error renders the error with its position.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// at returns an error at a position, keeping the position of errors
+// that have one and nil for no error.
+func at(pos Position, err error) error {
+	var e *Error
+	if err == nil || errors.As(err, &e) {
+		return err
+	}
+
+	return &Error{Pos: pos, Msg: err.Error()}
+}
+
+// flow is how control leaves a statement.
+type flow int
+
+// The flows.
+const (
+	flowNext flow = iota
+	flowReturn
+	flowBreak
+	flowContinue
+)
+
+// Thread runs scripts and the functions they define, printing to its
+// output.
+type Thread struct {
+	// out is where print writes.
+	out io.Writer
+
+	// depth is the depth of the calls in progress.
+	depth int
+
+	// builtins are the functions every script may call.
+	builtins map[string]Value
+}
+
+// Option configures a thread.
+type Option func(t *Thread)
+
+// WithPrint makes print write to w rather than to stderr.
+func WithPrint(w io.Writer) Option {
+	return func(t *Thread) {
+		t.out = w
+	}
+}
+
+// NewThread creates a thread.
+func NewThread(opts ...Option) *Thread {
+	t := &Thread{out: os.Stderr}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	t.builtins = t.universe()
+
+	return t
+}
+
+// frame holds the variables of a script or of a call.
+type frame struct {
+	// thread runs the frame.
+	thread *Thread
+
+	// globals are the variables of the script.
+	globals map[string]Value
+
+	// predeclared are the names the script is given, after the
+	// builtins.
+	predeclared map[string]Value
+
+	// fn is the function called, nil for the script.
+	fn *funcDecl
+
+	// locals are the variables of the call.
+	locals map[string]Value
+
+	// parent is the frame the function was defined in.
+	parent *frame
+
+	// result is the value returned.
+	result Value
+}
+
+// ExecFile runs a script, with the names of predeclared available to
+// it, and returns its global variables.
+func (t *Thread) ExecFile(filename, src string, predeclared map[string]Value) (map[string]Value, error) {
+	stmts, err := parse(filename, src)
+	if err != nil {
+		return nil, err
+	}
+
+	fr := &frame{thread: t, globals: map[string]Value{}, predeclared: predeclared}
+
+	if _, err := execBlock(fr, stmts); err != nil {
+		return nil, err
+	}
+
+	return fr.globals, nil
+}
+
+// Call calls a function with positional arguments.
+func (t *Thread) Call(fn Value, args ...Value) (Value, error) {
+	return t.call(Position{}, fn, args, nil)
+}
+
+// call calls a function, at a position for errors.
+func (t *Thread) call(pos Position, fn Value, args Tuple, kwargs []Tuple) (Value, error) {
+	switch fn := fn.(type) {
+	case *Builtin:
+		v, err := fn.fn(args, kwargs)
+		if err != nil {
+			return nil, at(pos, fmt.Errorf("%s: %w", fn.name, err))
+		}
+
+		return v, nil
+	case *Function:
+		if t.depth >= maxDepth {
+			return nil, &Error{Pos: pos, Msg: "calls nested too deeply"}
+		}
+
+		fr, err := fn.bind(args, kwargs)
+		if err != nil {
+			return nil, at(pos, err)
+		}
+
+		t.depth++
+		_, err = execBlock(fr, fn.decl.body)
+		t.depth--
+
+		if err != nil {
+			return nil, err
+		}
+
+		return fr.result, nil
+	}
+
+	return nil, &Error{Pos: pos, Msg: fmt.Sprintf("%s is not callable", fn.Type())}
+}
+
+// bind binds the arguments of a call to the parameters of a function.
+func (f *Function) bind(args Tuple, kwargs []Tuple) (*frame, error) {
+	d := f.decl
+
+	fr := &frame{
+		thread:      f.env.thread,
+		globals:     f.env.globals,
+		predeclared: f.env.predeclared,
+		fn:          d,
+		locals:      map[string]Value{},
+		parent:      f.env,
+		result:      None,
+	}
+
+	named := d.params
+	if d.kwargs {
+		named = named[:len(named)-1]
+	}
+
+	if d.args {
+		named = named[:len(named)-1]
+	}
+
+	for i, arg := range args {
+		if i >= len(named) {
+			if !d.args {
+				return nil, fmt.Errorf("%s takes %d arguments, got %d", d.name, len(named), len(args))
+			}
+
+			break
+		}
+
+		fr.locals[named[i]] = arg
+	}
+
+	if d.args {
+		var extra Tuple
+		if len(args) > len(named) {
+			extra = append(extra, args[len(named):]...)
+		}
+
+		fr.locals[d.params[len(named)]] = extra
+	}
+
+	var rest *Dict
+	if d.kwargs {
+		rest = NewDict()
+		fr.locals[d.params[len(d.params)-1]] = rest
+	}
+
+	for _, kv := range kwargs {
+		name := string(kv[0].(String))
+
+		found := false
+		for _, p := range named {
+			if p == name {
+				found = true
+			}
+		}
+
+		switch {
+		case found:
+			if _, dup := fr.locals[name]; dup {
+				return nil, fmt.Errorf("%s got multiple values for %s", d.name, name)
+			}
+
+			fr.locals[name] = kv[1]
+		case rest != nil:
+			rest.Set(kv[0], kv[1])
+		default:
+			return nil, fmt.Errorf("%s got an unexpected argument %s", d.name, name)
+		}
+	}
+
+	first := len(named) - len(f.defaults)
+
+	for i, p := range named {
+		if _, ok := fr.locals[p]; ok {
+			continue
+		}
+
+		if i < first {
+			return nil, fmt.Errorf("%s is missing argument %s", d.name, p)
+		}
+
+		fr.locals[p] = f.defaults[i-first]
+	}
+
+	return fr, nil
+}
+
+// lookup returns the value of a name.
+func (fr *frame) lookup(name string) (Value, error) {
+	for f := fr; f != nil && f.fn != nil; f = f.parent {
+		if !f.fn.locals[name] {
+			continue
+		}
+
+		if v, ok := f.locals[name]; ok {
+			return v, nil
+		}
+
+		return nil, fmt.Errorf("local variable %s referenced before assignment", name)
+	}
+
+	if v, ok := fr.globals[name]; ok {
+		return v, nil
+	}
+
+	if v, ok := fr.predeclared[name]; ok {
+		return v, nil
+	}
+
+	if v, ok := fr.thread.builtins[name]; ok {
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("undefined: %s", name)
+}
+
+// set assigns a variable of the frame.
+func (fr *frame) set(name string, v Value) {
+	if fr.fn != nil {
+		fr.locals[name] = v
+	} else {
+		fr.globals[name] = v
+	}
+}
+
+// execBlock executes statements until control leaves them.
+func execBlock(fr *frame, stmts []stmt) (flow, error) {
+	for _, s := range stmts {
+		f, err := s.exec(fr)
+		if err != nil || f != flowNext {
+			return f, err
+		}
+	}
+
+	return flowNext, nil
+}
+
+func (s *exprStmt) exec(fr *frame) (flow, error) {
+	_, err := s.x.eval(fr)
+	return flowNext, err
+}
+
+func (s *assignStmt) exec(fr *frame) (flow, error) {
+	if s.op == "=" {
+		v, err := s.value.eval(fr)
+		if err != nil {
+			return flowNext, err
+		}
+
+		return flowNext, assign(fr, s.target, v)
+	}
+
+	// the operands of an augmented assignment are evaluated once.
+	var (
+		container, key Value
+		old            Value
+		err            error
+	)
+
+	if index, ok := s.target.(*indexExpr); ok {
+		if container, err = index.x.eval(fr); err != nil {
+			return flowNext, err
+		}
+
+		if key, err = index.index.eval(fr); err != nil {
+			return flowNext, err
+		}
+
+		if old, err = getIndex(container, key); err != nil {
+			return flowNext, at(index.pos, err)
+		}
+	} else if old, err = s.target.eval(fr); err != nil {
+		return flowNext, err
+	}
+
+	y, err := s.value.eval(fr)
+	if err != nil {
+		return flowNext, err
+	}
+
+	var v Value
+
+	// += extends lists in place.
+	if list, ok := old.(*List); ok && s.op == "+" {
+		elems, err := elements(y)
+		if err != nil {
+			return flowNext, at(s.pos, err)
+		}
+
+		list.Elems = append(list.Elems, elems...)
+		v = list
+	} else if v, err = binary(s.op, old, y); err != nil {
+		return flowNext, at(s.pos, err)
+	}
+
+	if container != nil {
+		return flowNext, at(s.pos, setIndex(container, key, v))
+	}
+
+	return flowNext, assign(fr, s.target, v)
+}
+
+// assign assigns a value to a target.
+func assign(fr *frame, target expr, v Value) error {
+	switch t := target.(type) {
+	case *nameExpr:
+		fr.set(t.name, v)
+		return nil
+	case *indexExpr:
+		container, err := t.x.eval(fr)
+		if err != nil {
+			return err
+		}
+
+		key, err := t.index.eval(fr)
+		if err != nil {
+			return err
+		}
+
+		if err := setIndex(container, key, v); err != nil {
+			return at(t.pos, err)
+		}
+
+		return nil
+	case *tupleExpr:
+		return assignElems(fr, t.pos, t.elems, v)
+	case *listExpr:
+		return assignElems(fr, t.pos, t.elems, v)
+	}
+
+	return &Error{Pos: target.position(), Msg: "cannot assign to this expression"}
+}
+
+// assignElems unpacks a sequence into targets.
+func assignElems(fr *frame, pos Position, targets []expr, v Value) error {
+	elems, err := elements(v)
+	if err != nil {
+		return at(pos, err)
+	}
+
+	if len(elems) != len(targets) {
+		return &Error{Pos: pos, Msg: fmt.Sprintf("cannot unpack %d values into %d variables", len(elems), len(targets))}
+	}
+
+	for i, target := range targets {
+		if err := assign(fr, target, elems[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *defStmt) exec(fr *frame) (flow, error) {
+	fn := &Function{decl: s.fn, env: fr}
+
+	for _, d := range s.fn.defaults {
+		v, err := d.eval(fr)
+		if err != nil {
+			return flowNext, err
+		}
+
+		fn.defaults = append(fn.defaults, v)
+	}
+
+	fr.set(s.fn.name, fn)
+
+	return flowNext, nil
+}
+
+func (s *ifStmt) exec(fr *frame) (flow, error) {
+	cond, err := s.cond.eval(fr)
+	if err != nil {
+		return flowNext, err
+	}
+
+	if Truth(cond) {
+		return execBlock(fr, s.body)
+	}
+
+	return execBlock(fr, s.orElse)
+}
+
+func (s *forStmt) exec(fr *frame) (flow, error) {
+	x, err := s.x.eval(fr)
+	if err != nil {
+		return flowNext, err
+	}
+
+	elems, err := elements(x)
+	if err != nil {
+		return flowNext, at(s.x.position(), err)
+	}
+
+	for _, elem := range elems {
+		if err := assign(fr, s.vars, elem); err != nil {
+			return flowNext, err
+		}
+
+		f, err := execBlock(fr, s.body)
+		if err != nil {
+			return flowNext, err
+		}
+
+		switch f {
+		case flowBreak:
+			return flowNext, nil
+		case flowReturn:
+			return f, nil
+		}
+	}
+
+	return flowNext, nil
+}
+
+func (s *returnStmt) exec(fr *frame) (flow, error) {
+	fr.result = None
+
+	if s.value != nil {
+		v, err := s.value.eval(fr)
+		if err != nil {
+			return flowNext, err
+		}
+
+		fr.result = v
+	}
+
+	return flowReturn, nil
+}
+
+func (s *branchStmt) exec(fr *frame) (flow, error) {
+	switch s.keyword {
+	case "break":
+		return flowBreak, nil
+	case "continue":
+		return flowContinue, nil
+	}
+
+	return flowNext, nil
+}
+
+func (e *nameExpr) eval(fr *frame) (Value, error) {
+	v, err := fr.lookup(e.name)
+	if err != nil {
+		return nil, at(e.pos, err)
+	}
+
+	return v, nil
+}
+
+func (e *literal) eval(fr *frame) (Value, error) {
+	return e.value, nil
+}
+
+// evalElems evaluates expressions in order.
+func evalElems(fr *frame, exprs []expr) ([]Value, error) {
+	values := make([]Value, len(exprs))
+
+	for i, x := range exprs {
+		v, err := x.eval(fr)
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = v
+	}
+
+	return values, nil
+}
+
+func (e *listExpr) eval(fr *frame) (Value, error) {
+	elems, err := evalElems(fr, e.elems)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewList(elems), nil
+}
+
+func (e *tupleExpr) eval(fr *frame) (Value, error) {
+	elems, err := evalElems(fr, e.elems)
+	if err != nil {
+		return nil, err
+	}
+
+	return Tuple(elems), nil
+}
+
+func (e *dictExpr) eval(fr *frame) (Value, error) {
+	d := NewDict()
+
+	for i := range e.keys {
+		k, err := e.keys[i].eval(fr)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := e.values[i].eval(fr)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := d.Set(k, v); err != nil {
+			return nil, at(e.keys[i].position(), err)
+		}
+	}
+
+	return d, nil
+}
+
+func (e *comprehension) eval(fr *frame) (Value, error) {
+	list := NewList(nil)
+
+	return list, e.run(fr, e.clauses, list)
+}
+
+// run runs the clauses of a comprehension, adding the values of its
+// body to a list.
+func (e *comprehension) run(fr *frame, clauses []clause, list *List) error {
+	if len(clauses) == 0 {
+		v, err := e.body.eval(fr)
+		if err != nil {
+			return err
+		}
+
+		list.Elems = append(list.Elems, v)
+
+		return nil
+	}
+
+	c := clauses[0]
+
+	x, err := c.x.eval(fr)
+	if err != nil {
+		return err
+	}
+
+	if c.vars == nil {
+		if Truth(x) {
+			return e.run(fr, clauses[1:], list)
+		}
+
+		return nil
+	}
+
+	elems, err := elements(x)
+	if err != nil {
+		return at(c.x.position(), err)
+	}
+
+	for _, elem := range elems {
+		if err := assign(fr, c.vars, elem); err != nil {
+			return err
+		}
+
+		if err := e.run(fr, clauses[1:], list); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *unaryExpr) eval(fr *frame) (Value, error) {
+	x, err := e.x.eval(fr)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.op == "not" {
+		return Bool(!Truth(x)), nil
+	}
+
+	n, ok := x.(Int)
+	if !ok {
+		return nil, &Error{Pos: e.pos, Msg: fmt.Sprintf("unary %s on %s", e.op, x.Type())}
+	}
+
+	switch e.op {
+	case "-":
+		return -n, nil
+	case "~":
+		return ^n, nil
+	}
+
+	return n, nil
+}
+
+func (e *binaryExpr) eval(fr *frame) (Value, error) {
+	x, err := e.x.eval(fr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "and":
+		if !Truth(x) {
+			return x, nil
+		}
+
+		return e.y.eval(fr)
+	case "or":
+		if Truth(x) {
+			return x, nil
+		}
+
+		return e.y.eval(fr)
+	}
+
+	y, err := e.y.eval(fr)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := binary(e.op, x, y)
+	if err != nil {
+		return nil, at(e.pos, err)
+	}
+
+	return v, nil
+}
+
+// binary applies a binary operator other than and and or.
+func binary(op string, x, y Value) (Value, error) {
+	switch op {
+	case "==":
+		return Bool(equal(x, y)), nil
+	case "!=":
+		return Bool(!equal(x, y)), nil
+	case "<", ">", "<=", ">=":
+		c, err := compare(x, y)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "<":
+			return Bool(c < 0), nil
+		case ">":
+			return Bool(c > 0), nil
+		case "<=":
+			return Bool(c <= 0), nil
+		}
+
+		return Bool(c >= 0), nil
+	case "in", "not in":
+		found, err := contains(y, x)
+		if err != nil {
+			return nil, err
+		}
+
+		return Bool(found == (op == "in")), nil
+	}
+
+	switch x := x.(type) {
+	case Int:
+		switch y := y.(type) {
+		case Int:
+			return intBinary(op, x, y)
+		case String:
+			if op == "*" {
+				return String(strings.Repeat(string(y), max(int(x), 0))), nil
+			}
+		case *List:
+			if op == "*" {
+				return NewList(repeat(y.Elems, x)), nil
+			}
+		case Tuple:
+			if op == "*" {
+				return Tuple(repeat(y, x)), nil
+			}
+		}
+	case String:
+		switch op {
+		case "+":
+			if y, ok := y.(String); ok {
+				return x + y, nil
+			}
+		case "*":
+			if y, ok := y.(Int); ok {
+				return String(strings.Repeat(string(x), max(int(y), 0))), nil
+			}
+		case "%":
+			s, err := format(string(x), y)
+			return String(s), err
+		}
+	case *List:
+		switch op {
+		case "+":
+			if y, ok := y.(*List); ok {
+				return NewList(append(append([]Value(nil), x.Elems...), y.Elems...)), nil
+			}
+		case "*":
+			if y, ok := y.(Int); ok {
+				return NewList(repeat(x.Elems, y)), nil
+			}
+		}
+	case Tuple:
+		switch op {
+		case "+":
+			if y, ok := y.(Tuple); ok {
+				return append(append(Tuple(nil), x...), y...), nil
+			}
+		case "*":
+			if y, ok := y.(Int); ok {
+				return Tuple(repeat(x, y)), nil
+			}
+		}
+	case *Dict:
+		if y, ok := y.(*Dict); ok && op == "|" {
+			d := NewDict()
+
+			for _, src := range []*Dict{x, y} {
+				for _, k := range src.keys {
+					v, _, _ := src.Get(k)
+					d.Set(k, v)
+				}
+			}
+
+			return d, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported operation %s %s %s", x.Type(), op, y.Type())
+}
+
+// intBinary applies an arithmetic or bitwise operator to integers.
+func intBinary(op string, x, y Int) (Value, error) {
+	switch op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		return nil, fmt.Errorf("floating-point division is not supported, use //")
+	case "//", "%":
+		if y == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+
+		q, r := x/y, x%y
+
+		// round the quotient towards negative infinity, as Starlark does.
+		if r != 0 && (r < 0) != (y < 0) {
+			q--
+			r += y
+		}
+
+		if op == "//" {
+			return q, nil
+		}
+
+		return r, nil
+	case "&":
+		return x & y, nil
+	case "|":
+		return x | y, nil
+	case "^":
+		return x ^ y, nil
+	case "<<", ">>":
+		if y < 0 || y >= 64 {
+			return nil, fmt.Errorf("invalid shift count %d", y)
+		}
+
+		if op == "<<" {
+			return x << y, nil
+		}
+
+		return x >> y, nil
+	}
+
+	return nil, fmt.Errorf("unsupported operation int %s int", op)
+}
+
+// repeat repeats the elements of a sequence n times.
+func repeat(elems []Value, n Int) []Value {
+	var out []Value
+
+	for i := Int(0); i < n; i++ {
+		out = append(out, elems...)
+	}
+
+	return out
+}
+
+// contains reports whether a container holds a value, or a string a
+// substring.
+func contains(container, x Value) (bool, error) {
+	switch c := container.(type) {
+	case String:
+		s, ok := x.(String)
+		if !ok {
+			return false, fmt.Errorf("'in <string>' requires string as left operand, not %s", x.Type())
+		}
+
+		return strings.Contains(string(c), string(s)), nil
+	case *Dict:
+		_, found, err := c.Get(x)
+		return found, err
+	}
+
+	elems, err := elements(container)
+	if err != nil {
+		return false, err
+	}
+
+	for _, elem := range elems {
+		if equal(elem, x) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (e *condExpr) eval(fr *frame) (Value, error) {
+	cond, err := e.cond.eval(fr)
+	if err != nil {
+		return nil, err
+	}
+
+	if Truth(cond) {
+		return e.ifTrue.eval(fr)
+	}
+
+	return e.ifNot.eval(fr)
+}
+
+func (e *callExpr) eval(fr *frame) (Value, error) {
+	fn, err := e.fn.eval(fr)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		args   Tuple
+		kwargs []Tuple
+	)
+
+	for _, arg := range e.args {
+		v, err := arg.x.eval(fr)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case arg.star == "*":
+			elems, err := elements(v)
+			if err != nil {
+				return nil, at(arg.x.position(), err)
+			}
+
+			args = append(args, elems...)
+		case arg.star == "**":
+			d, ok := v.(*Dict)
+			if !ok {
+				return nil, &Error{Pos: arg.x.position(), Msg: fmt.Sprintf("** argument must be a dict, not %s", v.Type())}
+			}
+
+			for _, k := range d.keys {
+				if _, ok := k.(String); !ok {
+					return nil, &Error{Pos: arg.x.position(), Msg: "keywords must be strings"}
+				}
+
+				kv, _, _ := d.Get(k)
+				kwargs = append(kwargs, Tuple{k, kv})
+			}
+		case arg.name != "":
+			kwargs = append(kwargs, Tuple{String(arg.name), v})
+		default:
+			args = append(args, v)
+		}
+	}
+
+	return fr.thread.call(e.pos, fn, args, kwargs)
+}
+
+func (e *indexExpr) eval(fr *frame) (Value, error) {
+	x, err := e.x.eval(fr)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := e.index.eval(fr)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := getIndex(x, index)
+	if err != nil {
+		return nil, at(e.pos, err)
+	}
+
+	return v, nil
+}
+
+// getIndex returns an element of a sequence or the value of a key.
+func getIndex(x, index Value) (Value, error) {
+	if d, ok := x.(*Dict); ok {
+		v, found, err := d.Get(index)
+		if err != nil {
+			return nil, err
+		}
+
+		if !found {
+			return nil, fmt.Errorf("key %s not in dict", Repr(index))
+		}
+
+		return v, nil
+	}
+
+	n, ok := index.(Int)
+	if !ok {
+		return nil, fmt.Errorf("%s index must be int, not %s", x.Type(), index.Type())
+	}
+
+	var length int
+
+	switch x := x.(type) {
+	case String:
+		length = len(x)
+	case Tuple:
+		length = len(x)
+	case *List:
+		length = len(x.Elems)
+	default:
+		return nil, fmt.Errorf("%s is not indexable", x.Type())
+	}
+
+	i := int(n)
+	if i < 0 {
+		i += length
+	}
+
+	if i < 0 || i >= length {
+		return nil, fmt.Errorf("index %d out of range for %s of length %d", n, x.Type(), length)
+	}
+
+	switch x := x.(type) {
+	case String:
+		return x[i : i+1], nil
+	case Tuple:
+		return x[i], nil
+	}
+
+	return x.(*List).Elems[i], nil
+}
+
+// setIndex sets an element of a list or the value of a key.
+func setIndex(x, index, v Value) error {
+	switch x := x.(type) {
+	case *Dict:
+		return x.Set(index, v)
+	case *List:
+		n, ok := index.(Int)
+		if !ok {
+			return fmt.Errorf("list index must be int, not %s", index.Type())
+		}
+
+		i := int(n)
+		if i < 0 {
+			i += len(x.Elems)
+		}
+
+		if i < 0 || i >= len(x.Elems) {
+			return fmt.Errorf("index %d out of range for list of length %d", n, len(x.Elems))
+		}
+
+		x.Elems[i] = v
+
+		return nil
+	}
+
+	return fmt.Errorf("%s does not support item assignment", x.Type())
+}
+
+func (e *sliceExpr) eval(fr *frame) (Value, error) {
+	x, err := e.x.eval(fr)
+	if err != nil {
+		return nil, err
+	}
+
+	var bounds [3]Value
+
+	for i, b := range []expr{e.start, e.stop, e.step} {
+		bounds[i] = None
+
+		if b != nil {
+			if bounds[i], err = b.eval(fr); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	v, err := slice(x, bounds[0], bounds[1], bounds[2])
+	if err != nil {
+		return nil, at(e.pos, err)
+	}
+
+	return v, nil
+}
+
+// slice slices a sequence as x[start:stop:step] does.
+func slice(x, start, stop, step Value) (Value, error) {
+	var length int
+
+	switch x := x.(type) {
+	case String:
+		length = len(x)
+	case Tuple:
+		length = len(x)
+	case *List:
+		length = len(x.Elems)
+	default:
+		return nil, fmt.Errorf("%s cannot be sliced", x.Type())
+	}
+
+	s := 1
+	if step != None {
+		n, ok := step.(Int)
+		if !ok || n == 0 {
+			return nil, fmt.Errorf("invalid slice step %s", Repr(step))
+		}
+
+		s = int(n)
+	}
+
+	bound := func(v Value, def int) (int, error) {
+		if v == None {
+			return def, nil
+		}
+
+		n, ok := v.(Int)
+		if !ok {
+			return 0, fmt.Errorf("slice bound must be int, not %s", v.Type())
+		}
+
+		i := int(n)
+		if i < 0 {
+			i += length
+		}
+
+		lo, hi := 0, length
+		if s < 0 {
+			lo, hi = -1, length-1
+		}
+
+		return min(max(i, lo), hi), nil
+	}
+
+	first, last := 0, length
+	if s < 0 {
+		first, last = length-1, -1
+	}
+
+	i, err := bound(start, first)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := bound(stop, last)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []int
+	for ; s > 0 && i < j || s < 0 && i > j; i += s {
+		indexes = append(indexes, i)
+	}
+
+	switch x := x.(type) {
+	case String:
+		b := make([]byte, len(indexes))
+		for k, i := range indexes {
+			b[k] = x[i]
+		}
+
+		return String(b), nil
+	case Tuple:
+		t := make(Tuple, len(indexes))
+		for k, i := range indexes {
+			t[k] = x[i]
+		}
+
+		return t, nil
+	}
+
+	elems := x.(*List).Elems
+
+	l := make([]Value, len(indexes))
+	for k, i := range indexes {
+		l[k] = elems[i]
+	}
+
+	return NewList(l), nil
+}
+
+func (e *dotExpr) eval(fr *frame) (Value, error) {
+	x, err := e.x.eval(fr)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := method(x, e.name)
+	if !ok {
+		return nil, &Error{Pos: e.pos, Msg: fmt.Sprintf("%s has no attribute %s", x.Type(), e.name)}
+	}
+
+	return m, nil
+}
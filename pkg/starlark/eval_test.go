@@ -0,0 +1,203 @@
+package starlark
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestExpressions checks the values of expressions.
+func TestExpressions(t *testing.T) {
+	tests := []struct {
+		expression string
+		repr       string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"(1 + 2) * 3", "9"},
+		{"7 // 2", "3"},
+		{"-7 // 2", "-4"},
+		{"7 % 3", "1"},
+		{"-7 % 3", "2"},
+		{"1 << 4 | 1", "17"},
+		{"0xFF & ~0x0F ^ 1", "241"},
+		{"1 < 2 and 2 < 3", "True"},
+		{"not 1 == 1 or None", "None"},
+		{"0 or 'x'", `"x"`},
+		{"1 if 0 else 2", "2"},
+		{"'ab' + 'c' * 2", `"abcc"`},
+		{"'b' in 'abc'", "True"},
+		{"3 not in [1, 2]", "True"},
+		{"[1, 2] + [3]", "[1, 2, 3]"},
+		{"(1, 2)[1]", "2"},
+		{"(1,)", "(1,)"},
+		{"'hello'[1:4]", `"ell"`},
+		{"[1, 2, 3, 4][::-1]", "[4, 3, 2, 1]"},
+		{"{'a': 1, 'b': 2}['b']", "2"},
+		{"len({'a': 1})", "1"},
+		{"[x * x for x in range(5) if x % 2]", "[1, 9]"},
+		{"[(x, y) for x in range(2) for y in ['a', 'b']]", `[(0, "a"), (0, "b"), (1, "a"), (1, "b")]`},
+		{"sorted([3, 1, 2], reverse = True)", "[3, 2, 1]"},
+		{"sorted(['bb', 'a', 'ccc'], key = len)", `["a", "bb", "ccc"]`},
+		{"min(4, 2, 3)", "2"},
+		{"max([4, 2, 3])", "4"},
+		{"hex(255)", `"0xff"`},
+		{"int('-12')", "-12"},
+		{"int('ff', 16)", "255"},
+		{"str(12) + repr('a')", `"12\"a\""`},
+		{"chr(ord('a') + 1)", `"b"`},
+		{"list(enumerate('ab'))", `[(0, "a"), (1, "b")]`},
+		{"list(zip([1, 2], 'ab'))", `[(1, "a"), (2, "b")]`},
+		{"any([0, 1]), all([0, 1])", "(True, False)"},
+		{"type({})", `"dict"`},
+		{"'a,b,,c'.split(',')", `["a", "b", "", "c"]`},
+		{"'-'.join(['x', 'y'])", `"x-y"`},
+		{"'{} is {}'.format('x', 1)", `"x is 1"`},
+		{"'  pad '.strip().upper()", `"PAD"`},
+		{"'banana'.count('a')", "3"},
+		{"{'a': 1}.get('b', 2)", "2"},
+		{"list(reversed(range(3)))", "[2, 1, 0]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			globals, err := NewThread().ExecFile("test.star", "result = "+test.expression+"\n", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if repr := Repr(globals["result"]); repr != test.repr {
+				t.Errorf("%s, expected %s", repr, test.repr)
+			}
+		})
+	}
+}
+
+// TestStatements checks the control flow of scripts.
+func TestStatements(t *testing.T) {
+	src := `
+def fib(n):
+    if n < 2:
+        return n
+    return fib(n - 1) + fib(n - 2)
+
+def greet(name, greeting = "hello", *rest, **options):
+    return greeting + " " + name + str(len(rest)) + str(len(options))
+
+def counter():
+    counts = {}
+    def add(key):
+        counts[key] = counts.get(key, 0) + 1
+        return counts[key]
+    return add
+
+evens = []
+for i in range(10):
+    if i == 7:
+        break
+    if i % 2:
+        continue
+    evens.append(i)
+
+add = counter()
+add("a")
+add("b")
+twice = add("a")
+
+total = 0
+for k, v in {"x": 1, "y": 2}.items():
+    total += v
+
+a, b = 1, 2
+a, b = b, a
+
+result = [fib(10), greet("you"), greet("me", "hi", 1, 2, x = 3), evens, twice, total, a, b]
+`
+
+	globals, err := NewThread().ExecFile("test.star", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[55, "hello you00", "hi me21", [0, 2, 4, 6], 2, 3, 2, 1]`
+
+	if repr := Repr(globals["result"]); repr != expected {
+		t.Errorf("%s, expected %s", repr, expected)
+	}
+}
+
+// TestPrintAndPredeclared checks that print writes to the output of the
+// thread, and that scripts can call the predeclared builtins and be
+// called back.
+func TestPrintAndPredeclared(t *testing.T) {
+	var out strings.Builder
+
+	var seen []Value
+
+	record := NewBuiltin("record", func(args Tuple, kwargs []Tuple) (Value, error) {
+		seen = append(seen, args...)
+		return None, nil
+	})
+
+	thread := NewThread(WithPrint(&out))
+
+	globals, err := thread.ExecFile("test.star", `
+print("a", 1, [2], sep = "-")
+record("x", 3)
+
+def on_halt(pc):
+    return pc + 1
+`, map[string]Value{"record": record})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "a-1-[2]\n" {
+		t.Errorf("printed %q", out.String())
+	}
+
+	if len(seen) != 2 || seen[0] != String("x") || seen[1] != Int(3) {
+		t.Errorf("recorded %v", seen)
+	}
+
+	v, err := thread.Call(globals["on_halt"], Int(0x3000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != Int(0x3001) {
+		t.Errorf("on_halt returned %s", Repr(v))
+	}
+}
+
+// TestErrors checks that scripts fail with the position and cause of
+// their errors.
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src     string
+		message string
+	}{
+		{"x = 1 +\n", "test.star:1:"},
+		{"x = y\n", "undefined"},
+		{"x = 1 // 0\n", "division by zero"},
+		{"x = [1][2]\n", "out of range"},
+		{"x = {}['a']\n", "key"},
+		{"x = 1 + 'a'\n", "unsupported"},
+		{"def f():\n    return f()\nf()\n", "nested too deeply"},
+		{"fail('boom')\n", "boom"},
+		{"x = len(1, 2)\n", "arguments"},
+		{"while True:\n    pass\n", "test.star:1:"},
+		{"x = 1\n  y = 2\n", "test.star:2:"},
+	}
+
+	for _, test := range tests {
+		t.Run(strings.TrimSpace(test.src), func(t *testing.T) {
+			_, err := NewThread().ExecFile("test.star", test.src, nil)
+			if err == nil {
+				t.Fatal("ran")
+			}
+
+			if !strings.Contains(err.Error(), test.message) {
+				t.Errorf("error %q, expected %q", err, test.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package starlark
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokenKind is the kind of a token.
+type tokenKind int
+
+// The kinds of tokens.
+const (
+	tokEOF tokenKind = iota
+	tokNewline
+	tokIndent
+	tokDedent
+	tokName
+	tokInt
+	tokString
+	tokOp
+)
+
+// keywords are the names reserved by the language, including those
+// of Python it rejects.
+var keywords = map[string]bool{
+	"and": true, "break": true, "continue": true, "def": true, "elif": true, "else": true,
+	"for": true, "if": true, "in": true, "lambda": true, "load": true, "not": true,
+	"or": true, "pass": true, "return": true, "while": true,
+	"None": true, "True": true, "False": true,
+}
+
+// operators are the operators and punctuation, longest first so that
+// they are matched greedily.
+var operators = []string{
+	"//=", "<<=", ">>=", "**",
+	"==", "!=", "<=", ">=", "//", "<<", ">>", "+=", "-=", "*=", "%=", "&=", "|=", "^=",
+	"+", "-", "*", "/", "%", "&", "|", "^", "~", "<", ">", "=",
+	"(", ")", "[", "]", "{", "}", ",", ":", ".", ";",
+}
+
+// Position is a position in a script.
+type Position struct {
+	// File is the name of the script.
+	File string
+
+	// Line is the line, starting at 1.
+	Line int
+
+	// Col is the column, starting at 1.
+	Col int
+}
+
+// String renders the position as file:line:col.
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// token is a token of a script.
+type token struct {
+	// kind is the kind of the token.
+	kind tokenKind
+
+	// text is the name, operator or keyword, or the value of a string.
+	text string
+
+	// num is the value of an integer.
+	num int64
+
+	// pos is where the token starts.
+	pos Position
+}
+
+// lexer splits a script into tokens, turning indentation into indent
+// and dedent tokens.
+type lexer struct {
+	// src is the script.
+	src string
+
+	// file is the name of the script.
+	file string
+
+	// i is the offset of the next character.
+	i int
+
+	// line and col are the position of the next character.
+	line, col int
+
+	// indents are the indentations of the enclosing blocks.
+	indents []int
+
+	// depth counts the brackets open, within which lines continue.
+	depth int
+
+	// tokens are the tokens read.
+	tokens []token
+}
+
+// tokenize splits a script into tokens.
+func tokenize(file, src string) ([]token, error) {
+	l := &lexer{src: strings.ReplaceAll(src, "\r\n", "\n"), file: file, line: 1, col: 1, indents: []int{0}}
+
+	if err := l.run(); err != nil {
+		return nil, err
+	}
+
+	return l.tokens, nil
+}
+
+// pos returns the position of the next character.
+func (l *lexer) pos() Position {
+	return Position{l.file, l.line, l.col}
+}
+
+// errorf returns an error at a position.
+func (l *lexer) errorf(pos Position, format string, args ...any) error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// advance consumes n characters.
+func (l *lexer) advance(n int) {
+	for ; n > 0 && l.i < len(l.src); n-- {
+		if l.src[l.i] == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+
+		l.i++
+	}
+}
+
+// emit appends a token.
+func (l *lexer) emit(kind tokenKind, text string, pos Position) {
+	l.tokens = append(l.tokens, token{kind: kind, text: text, pos: pos})
+}
+
+// run reads the tokens of every line.
+func (l *lexer) run() error {
+	atLineStart := true
+
+	for {
+		if atLineStart && l.depth == 0 {
+			if err := l.indentation(); err != nil {
+				return err
+			}
+
+			if l.i >= len(l.src) {
+				break
+			}
+
+			atLineStart = false
+		}
+
+		if l.i >= len(l.src) {
+			break
+		}
+
+		c := l.src[l.i]
+		pos := l.pos()
+
+		switch {
+		case c == ' ' || c == '\t':
+			l.advance(1)
+		case c == '#':
+			for l.i < len(l.src) && l.src[l.i] != '\n' {
+				l.advance(1)
+			}
+		case c == '\\' && l.i+1 < len(l.src) && l.src[l.i+1] == '\n':
+			l.advance(2)
+		case c == '\n':
+			if l.depth == 0 {
+				l.emit(tokNewline, "", pos)
+				atLineStart = true
+			}
+
+			l.advance(1)
+		case c == '"' || c == '\'':
+			s, err := l.str()
+			if err != nil {
+				return err
+			}
+
+			l.tokens = append(l.tokens, token{kind: tokString, text: s, pos: pos})
+		case c >= '0' && c <= '9':
+			if err := l.number(); err != nil {
+				return err
+			}
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := l.i
+			for l.i < len(l.src) && isNameChar(l.src[l.i]) {
+				l.advance(1)
+			}
+
+			l.emit(tokName, l.src[start:l.i], pos)
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(l.src[l.i:], o) {
+					op = o
+					break
+				}
+			}
+
+			if op == "" {
+				return l.errorf(pos, "unexpected character %q", c)
+			}
+
+			switch op {
+			case "(", "[", "{":
+				l.depth++
+			case ")", "]", "}":
+				if l.depth > 0 {
+					l.depth--
+				}
+			}
+
+			l.advance(len(op))
+			l.emit(tokOp, op, pos)
+		}
+	}
+
+	pos := l.pos()
+
+	if n := len(l.tokens); n > 0 && l.tokens[n-1].kind != tokNewline {
+		l.emit(tokNewline, "", pos)
+	}
+
+	for len(l.indents) > 1 {
+		l.indents = l.indents[:len(l.indents)-1]
+		l.emit(tokDedent, "", pos)
+	}
+
+	l.emit(tokEOF, "", pos)
+
+	return nil
+}
+
+// indentation measures the indentation of the next line that is not
+// blank, emitting indent and dedent tokens.
+func (l *lexer) indentation() error {
+	for l.i < len(l.src) {
+		width, j := 0, l.i
+		for ; j < len(l.src) && (l.src[j] == ' ' || l.src[j] == '\t'); j++ {
+			if l.src[j] == '\t' {
+				width += 8 - width%8
+			} else {
+				width++
+			}
+		}
+
+		if j < len(l.src) && l.src[j] != '\n' && l.src[j] != '#' {
+			l.advance(j - l.i)
+
+			pos := l.pos()
+			top := l.indents[len(l.indents)-1]
+
+			switch {
+			case width > top:
+				l.indents = append(l.indents, width)
+				l.emit(tokIndent, "", pos)
+			case width < top:
+				for width < l.indents[len(l.indents)-1] {
+					l.indents = l.indents[:len(l.indents)-1]
+					l.emit(tokDedent, "", pos)
+				}
+
+				if width != l.indents[len(l.indents)-1] {
+					return l.errorf(pos, "unindent does not match any outer indentation level")
+				}
+			}
+
+			return nil
+		}
+
+		// skip the blank or comment line.
+		for j < len(l.src) && l.src[j] != '\n' {
+			j++
+		}
+
+		l.advance(j + 1 - l.i)
+	}
+
+	return nil
+}
+
+// isNameChar reports whether c may appear in a name.
+func isNameChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// number reads an integer in decimal, or in hex, octal or binary with
+// a 0x, 0o or 0b prefix.
+func (l *lexer) number() error {
+	pos := l.pos()
+	start := l.i
+
+	for l.i < len(l.src) && isNameChar(l.src[l.i]) {
+		l.advance(1)
+	}
+
+	text := l.src[start:l.i]
+
+	n, err := strconv.ParseInt(strings.ReplaceAll(text, "_", ""), 0, 64)
+	if err != nil || len(text) > 1 && text[0] == '0' && text[1] >= '0' && text[1] <= '9' {
+		return l.errorf(pos, "invalid integer %s", text)
+	}
+
+	l.tokens = append(l.tokens, token{kind: tokInt, text: text, num: n, pos: pos})
+
+	return nil
+}
+
+// str reads a string, quoted once or thrice, decoding its escapes.
+func (l *lexer) str() (string, error) {
+	pos := l.pos()
+	quote := l.src[l.i : l.i+1]
+
+	if strings.HasPrefix(l.src[l.i:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+
+	l.advance(len(quote))
+
+	var sb strings.Builder
+
+	for {
+		if l.i >= len(l.src) || len(quote) == 1 && l.src[l.i] == '\n' {
+			return "", l.errorf(pos, "unterminated string")
+		}
+
+		if strings.HasPrefix(l.src[l.i:], quote) {
+			l.advance(len(quote))
+			return sb.String(), nil
+		}
+
+		c := l.src[l.i]
+		if c != '\\' {
+			sb.WriteByte(c)
+			l.advance(1)
+
+			continue
+		}
+
+		if l.i+1 >= len(l.src) {
+			return "", l.errorf(pos, "unterminated string")
+		}
+
+		e := l.src[l.i+1]
+		l.advance(2)
+
+		switch e {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		case '0':
+			sb.WriteByte(0)
+		case '\\', '\'', '"':
+			sb.WriteByte(e)
+		case '\n':
+		case 'x':
+			if l.i+2 > len(l.src) {
+				return "", l.errorf(pos, "invalid \\x escape")
+			}
+
+			b, err := strconv.ParseUint(l.src[l.i:l.i+2], 16, 8)
+			if err != nil {
+				return "", l.errorf(pos, "invalid \\x escape")
+			}
+
+			sb.WriteByte(byte(b))
+			l.advance(2)
+		default:
+			sb.WriteByte('\\')
+			sb.WriteByte(e)
+		}
+	}
+}
@@ -0,0 +1,1168 @@
+package starlark
+
+import (
+	"fmt"
+)
+
+// expr is an expression.
+type expr interface {
+	// eval evaluates the expression in a frame.
+	eval(fr *frame) (Value, error)
+
+	// position returns where the expression starts.
+	position() Position
+}
+
+// stmt is a statement.
+type stmt interface {
+	// exec executes the statement in a frame.
+	exec(fr *frame) (flow, error)
+}
+
+// The expressions.
+type (
+	// nameExpr is a name.
+	nameExpr struct {
+		pos  Position
+		name string
+	}
+
+	// literal is a constant.
+	literal struct {
+		pos   Position
+		value Value
+	}
+
+	// listExpr is a list display.
+	listExpr struct {
+		pos   Position
+		elems []expr
+	}
+
+	// tupleExpr is a tuple display.
+	tupleExpr struct {
+		pos   Position
+		elems []expr
+	}
+
+	// dictExpr is a dict display.
+	dictExpr struct {
+		pos          Position
+		keys, values []expr
+	}
+
+	// comprehension is a list comprehension.
+	comprehension struct {
+		pos     Position
+		body    expr
+		clauses []clause
+	}
+
+	// unaryExpr applies -, +, ~ or not.
+	unaryExpr struct {
+		pos Position
+		op  string
+		x   expr
+	}
+
+	// binaryExpr applies a binary operator, including and, or and the
+	// comparisons.
+	binaryExpr struct {
+		pos  Position
+		op   string
+		x, y expr
+	}
+
+	// condExpr is x if cond else y.
+	condExpr struct {
+		pos                 Position
+		cond, ifTrue, ifNot expr
+	}
+
+	// callExpr calls a function.
+	callExpr struct {
+		pos  Position
+		fn   expr
+		args []argument
+	}
+
+	// indexExpr indexes a sequence or dict.
+	indexExpr struct {
+		pos      Position
+		x, index expr
+	}
+
+	// sliceExpr slices a sequence, with absent bounds nil.
+	sliceExpr struct {
+		pos                  Position
+		x, start, stop, step expr
+	}
+
+	// dotExpr selects a method.
+	dotExpr struct {
+		pos  Position
+		x    expr
+		name string
+	}
+)
+
+// clause is a for or if clause of a comprehension.
+type clause struct {
+	// vars are the variables of a for clause, nil for an if clause.
+	vars expr
+
+	// x is the iterable of a for clause or the condition of an if.
+	x expr
+}
+
+// argument is an argument of a call.
+type argument struct {
+	// name is the name of a keyword argument.
+	name string
+
+	// star is "*" or "**" for unpacked arguments.
+	star string
+
+	// x is the value.
+	x expr
+}
+
+func (e *nameExpr) position() Position      { return e.pos }
+func (e *literal) position() Position       { return e.pos }
+func (e *listExpr) position() Position      { return e.pos }
+func (e *tupleExpr) position() Position     { return e.pos }
+func (e *dictExpr) position() Position      { return e.pos }
+func (e *comprehension) position() Position { return e.pos }
+func (e *unaryExpr) position() Position     { return e.pos }
+func (e *binaryExpr) position() Position    { return e.pos }
+func (e *condExpr) position() Position      { return e.pos }
+func (e *callExpr) position() Position      { return e.pos }
+func (e *indexExpr) position() Position     { return e.pos }
+func (e *sliceExpr) position() Position     { return e.pos }
+func (e *dotExpr) position() Position       { return e.pos }
+
+// The statements.
+type (
+	// exprStmt evaluates an expression for its effects.
+	exprStmt struct {
+		x expr
+	}
+
+	// assignStmt assigns a value to targets, or updates one with an
+	// operator such as +=.
+	assignStmt struct {
+		pos    Position
+		op     string
+		target expr
+		value  expr
+	}
+
+	// defStmt defines a function.
+	defStmt struct {
+		pos Position
+		fn  *funcDecl
+	}
+
+	// ifStmt is an if statement, with elif as nested ifs.
+	ifStmt struct {
+		cond         expr
+		body, orElse []stmt
+	}
+
+	// forStmt iterates over a sequence.
+	forStmt struct {
+		pos  Position
+		vars expr
+		x    expr
+		body []stmt
+	}
+
+	// returnStmt returns from a function.
+	returnStmt struct {
+		pos   Position
+		value expr
+	}
+
+	// branchStmt is pass, break or continue.
+	branchStmt struct {
+		pos     Position
+		keyword string
+	}
+)
+
+// funcDecl declares a function.
+type funcDecl struct {
+	// name is the name of the function.
+	name string
+
+	// params are the names of the parameters, with args and kwargs
+	// last if any.
+	params []string
+
+	// defaults are the default values of the last parameters before
+	// args and kwargs.
+	defaults []expr
+
+	// args and kwargs are set if the last parameters collect the
+	// extra positional and keyword arguments.
+	args, kwargs bool
+
+	// body is the body of the function.
+	body []stmt
+
+	// locals are the names the function binds.
+	locals map[string]bool
+}
+
+// parser parses tokens into statements.
+type parser struct {
+	// tokens are the tokens of the script.
+	tokens []token
+
+	// i is the index of the next token.
+	i int
+
+	// fn is the function being parsed, nil at the top level.
+	fn *funcDecl
+}
+
+// parse parses a script.
+func parse(file, src string) ([]stmt, error) {
+	tokens, err := tokenize(file, src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	var stmts []stmt
+
+	for p.peek().kind != tokEOF {
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+
+		stmts = append(stmts, s...)
+	}
+
+	return stmts, nil
+}
+
+// peek returns the next token.
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+// next consumes the next token.
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+
+	return t
+}
+
+// is reports whether the next token is an operator or keyword.
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokOp || t.kind == tokName) && t.text == text
+}
+
+// accept consumes the next token if it is an operator or keyword.
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.i++
+		return true
+	}
+
+	return false
+}
+
+// expect consumes an operator or keyword, failing if it is missing.
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.unexpected(fmt.Sprintf("%q", text))
+	}
+
+	return nil
+}
+
+// unexpected reports the next token, when something else was expected.
+func (p *parser) unexpected(want string) error {
+	t := p.peek()
+
+	got := fmt.Sprintf("%q", t.text)
+
+	switch t.kind {
+	case tokEOF:
+		got = "end of file"
+	case tokNewline:
+		got = "end of line"
+	case tokIndent:
+		got = "indentation"
+	case tokDedent:
+		got = "unindent"
+	case tokString:
+		got = "string"
+	}
+
+	return &Error{Pos: t.pos, Msg: fmt.Sprintf("expected %s, got %s", want, got)}
+}
+
+// bind records that the function being parsed binds the names of a
+// target.
+func (p *parser) bind(target expr) {
+	if p.fn == nil {
+		return
+	}
+
+	switch t := target.(type) {
+	case *nameExpr:
+		p.fn.locals[t.name] = true
+	case *tupleExpr:
+		for _, e := range t.elems {
+			p.bind(e)
+		}
+	case *listExpr:
+		for _, e := range t.elems {
+			p.bind(e)
+		}
+	}
+}
+
+// statement parses a statement, or the simple statements of a line.
+func (p *parser) statement() ([]stmt, error) {
+	t := p.peek()
+
+	if t.kind == tokName {
+		switch t.text {
+		case "def":
+			s, err := p.def()
+			return []stmt{s}, err
+		case "if":
+			p.next()
+			s, err := p.ifStatement()
+			return []stmt{s}, err
+		case "for":
+			s, err := p.forStatement()
+			return []stmt{s}, err
+		case "while", "lambda", "load":
+			return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("%s is not supported", t.text)}
+		}
+	}
+
+	var stmts []stmt
+
+	for {
+		s, err := p.simple()
+		if err != nil {
+			return nil, err
+		}
+
+		stmts = append(stmts, s)
+
+		if !p.accept(";") || p.peek().kind == tokNewline {
+			break
+		}
+	}
+
+	if p.next().kind != tokNewline {
+		p.i--
+		return nil, p.unexpected("end of line")
+	}
+
+	return stmts, nil
+}
+
+// simple parses a simple statement.
+func (p *parser) simple() (stmt, error) {
+	t := p.peek()
+
+	if t.kind == tokName {
+		switch t.text {
+		case "pass", "break", "continue":
+			p.next()
+			return &branchStmt{t.pos, t.text}, nil
+		case "return":
+			p.next()
+
+			if p.fn == nil {
+				return nil, &Error{Pos: t.pos, Msg: "return outside of a function"}
+			}
+
+			if k := p.peek().kind; k == tokNewline || p.is(";") {
+				return &returnStmt{t.pos, nil}, nil
+			}
+
+			value, err := p.exprList()
+			if err != nil {
+				return nil, err
+			}
+
+			return &returnStmt{t.pos, value}, nil
+		}
+	}
+
+	x, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.peek()
+	if op.kind != tokOp {
+		return &exprStmt{x}, nil
+	}
+
+	switch op.text {
+	case "=":
+		p.next()
+
+		if err := checkTarget(x); err != nil {
+			return nil, err
+		}
+
+		value, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+
+		p.bind(x)
+
+		return &assignStmt{op.pos, "=", x, value}, nil
+	case "+=", "-=", "*=", "//=", "%=", "&=", "|=", "^=", "<<=", ">>=":
+		p.next()
+
+		switch x.(type) {
+		case *nameExpr, *indexExpr:
+		default:
+			return nil, &Error{Pos: x.position(), Msg: "invalid target of augmented assignment"}
+		}
+
+		value, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+
+		p.bind(x)
+
+		return &assignStmt{op.pos, op.text[:len(op.text)-1], x, value}, nil
+	}
+
+	return &exprStmt{x}, nil
+}
+
+// checkTarget checks that an expression can be assigned to.
+func checkTarget(x expr) error {
+	switch t := x.(type) {
+	case *nameExpr, *indexExpr:
+		return nil
+	case *tupleExpr:
+		for _, e := range t.elems {
+			if err := checkTarget(e); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case *listExpr:
+		for _, e := range t.elems {
+			if err := checkTarget(e); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return &Error{Pos: x.position(), Msg: "cannot assign to this expression"}
+}
+
+// def parses a function definition.
+func (p *parser) def() (stmt, error) {
+	pos := p.next().pos
+
+	name := p.next()
+	if name.kind != tokName || keywords[name.text] {
+		p.i--
+		return nil, p.unexpected("function name")
+	}
+
+	fn := &funcDecl{name: name.text, locals: map[string]bool{}}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	for !p.accept(")") {
+		if len(fn.params) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+
+			if p.accept(")") {
+				break
+			}
+		}
+
+		star := ""
+		if p.accept("**") {
+			star = "**"
+		} else if p.accept("*") {
+			star = "*"
+		}
+
+		param := p.next()
+		if param.kind != tokName || keywords[param.text] {
+			p.i--
+			return nil, p.unexpected("parameter name")
+		}
+
+		if fn.kwargs || star == "*" && fn.args {
+			return nil, &Error{Pos: param.pos, Msg: "invalid parameters"}
+		}
+
+		if fn.locals[param.text] {
+			return nil, &Error{Pos: param.pos, Msg: fmt.Sprintf("duplicate parameter %s", param.text)}
+		}
+
+		fn.params = append(fn.params, param.text)
+		fn.locals[param.text] = true
+
+		switch star {
+		case "*":
+			fn.args = true
+		case "**":
+			fn.kwargs = true
+		default:
+			if fn.args {
+				return nil, &Error{Pos: param.pos, Msg: "parameters after *args are not supported"}
+			}
+
+			if p.accept("=") {
+				d, err := p.test()
+				if err != nil {
+					return nil, err
+				}
+
+				fn.defaults = append(fn.defaults, d)
+			} else if len(fn.defaults) > 0 {
+				return nil, &Error{Pos: param.pos, Msg: "parameter without a default follows one with a default"}
+			}
+		}
+	}
+
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	p.bind(&nameExpr{pos, fn.name})
+
+	outer := p.fn
+	p.fn = fn
+
+	body, err := p.suite()
+
+	p.fn = outer
+
+	if err != nil {
+		return nil, err
+	}
+
+	fn.body = body
+
+	return &defStmt{pos, fn}, nil
+}
+
+// ifStatement parses an if statement after its if or elif.
+func (p *parser) ifStatement() (stmt, error) {
+	cond, err := p.test()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	body, err := p.suite()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ifStmt{cond: cond, body: body}
+
+	switch {
+	case p.accept("elif"):
+		elif, err := p.ifStatement()
+		if err != nil {
+			return nil, err
+		}
+
+		s.orElse = []stmt{elif}
+	case p.accept("else"):
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+
+		if s.orElse, err = p.suite(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// forStatement parses a for loop.
+func (p *parser) forStatement() (stmt, error) {
+	pos := p.next().pos
+
+	vars, err := p.targets()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+
+	x, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	body, err := p.suite()
+	if err != nil {
+		return nil, err
+	}
+
+	return &forStmt{pos, vars, x, body}, nil
+}
+
+// targets parses the variables of a for loop or clause.
+func (p *parser) targets() (expr, error) {
+	pos := p.peek().pos
+
+	var elems []expr
+
+	for {
+		x, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkTarget(x); err != nil {
+			return nil, err
+		}
+
+		elems = append(elems, x)
+
+		if !p.accept(",") || p.is("in") {
+			break
+		}
+	}
+
+	var vars expr = elems[0]
+	if len(elems) > 1 {
+		vars = &tupleExpr{pos, elems}
+	}
+
+	p.bind(vars)
+
+	return vars, nil
+}
+
+// suite parses the body of a compound statement, on the same line or
+// as an indented block.
+func (p *parser) suite() ([]stmt, error) {
+	if p.peek().kind != tokNewline {
+		return p.statement()
+	}
+
+	p.next()
+
+	if p.peek().kind != tokIndent {
+		return nil, p.unexpected("an indented block")
+	}
+
+	p.next()
+
+	var body []stmt
+
+	for p.peek().kind != tokDedent && p.peek().kind != tokEOF {
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+
+		body = append(body, s...)
+	}
+
+	p.next()
+
+	return body, nil
+}
+
+// exprList parses expressions separated by commas, a tuple if there
+// is more than one or a trailing comma.
+func (p *parser) exprList() (expr, error) {
+	pos := p.peek().pos
+
+	x, err := p.test()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.is(",") {
+		return x, nil
+	}
+
+	elems := []expr{x}
+
+	for p.accept(",") {
+		if !p.startsExpr() {
+			break
+		}
+
+		x, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+
+		elems = append(elems, x)
+	}
+
+	return &tupleExpr{pos, elems}, nil
+}
+
+// startsExpr reports whether the next token may start an expression.
+func (p *parser) startsExpr() bool {
+	t := p.peek()
+
+	switch t.kind {
+	case tokInt, tokString:
+		return true
+	case tokName:
+		return !keywords[t.text] || t.text == "None" || t.text == "True" || t.text == "False" || t.text == "not"
+	case tokOp:
+		switch t.text {
+		case "(", "[", "{", "-", "+", "~":
+			return true
+		}
+	}
+
+	return false
+}
+
+// test parses an expression, including conditional ones.
+func (p *parser) test() (expr, error) {
+	if p.is("lambda") {
+		return nil, &Error{Pos: p.peek().pos, Msg: "lambda is not supported"}
+	}
+
+	x, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.is("if") {
+		return x, nil
+	}
+
+	pos := p.next().pos
+
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("else"); err != nil {
+		return nil, err
+	}
+
+	ifNot, err := p.test()
+	if err != nil {
+		return nil, err
+	}
+
+	return &condExpr{pos, cond, x, ifNot}, nil
+}
+
+// precedence lists the binary operators from the loosest binding.
+var precedence = [][]string{
+	{"or"},
+	{"and"},
+	{"not"},
+	{"==", "!=", "<", ">", "<=", ">=", "in", "not in"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "//", "%"},
+}
+
+// binaryOp returns the binary operator of a level at the next token.
+func (p *parser) binaryOp(level int) (string, bool) {
+	t := p.peek()
+	if t.kind != tokOp && t.kind != tokName {
+		return "", false
+	}
+
+	for _, op := range precedence[level] {
+		if op == "not in" {
+			if t.text == "not" && p.tokens[p.i+1].kind == tokName && p.tokens[p.i+1].text == "in" {
+				return op, true
+			}
+
+			continue
+		}
+
+		if t.text == op && op != "not" {
+			return op, true
+		}
+	}
+
+	return "", false
+}
+
+// binary parses the binary operators of a level and tighter ones.
+func (p *parser) binary(level int) (expr, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+
+	if precedence[level][0] == "not" {
+		if p.is("not") {
+			pos := p.next().pos
+
+			x, err := p.binary(level)
+			if err != nil {
+				return nil, err
+			}
+
+			return &unaryExpr{pos, "not", x}, nil
+		}
+
+		return p.binary(level + 1)
+	}
+
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, ok := p.binaryOp(level)
+		if !ok {
+			return x, nil
+		}
+
+		pos := p.next().pos
+		if op == "not in" {
+			p.next()
+		}
+
+		y, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		x = &binaryExpr{pos, op, x, y}
+	}
+}
+
+// unary parses unary operators and the expressions they apply to.
+func (p *parser) unary() (expr, error) {
+	t := p.peek()
+
+	if t.kind == tokOp && (t.text == "-" || t.text == "+" || t.text == "~") {
+		p.next()
+
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &unaryExpr{t.pos, t.text, x}, nil
+	}
+
+	return p.primary()
+}
+
+// primary parses an operand followed by calls, indexes and selectors.
+func (p *parser) primary() (expr, error) {
+	x, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+
+		switch {
+		case p.accept("("):
+			args, err := p.arguments()
+			if err != nil {
+				return nil, err
+			}
+
+			x = &callExpr{t.pos, x, args}
+		case p.accept("["):
+			if x, err = p.subscript(t.pos, x); err != nil {
+				return nil, err
+			}
+		case p.accept("."):
+			name := p.next()
+			if name.kind != tokName {
+				p.i--
+				return nil, p.unexpected("name")
+			}
+
+			x = &dotExpr{name.pos, x, name.text}
+		default:
+			return x, nil
+		}
+	}
+}
+
+// arguments parses the arguments of a call after its parenthesis.
+func (p *parser) arguments() ([]argument, error) {
+	var args []argument
+
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+
+			if p.accept(")") {
+				break
+			}
+		}
+
+		var arg argument
+
+		switch {
+		case p.accept("**"):
+			arg.star = "**"
+		case p.accept("*"):
+			arg.star = "*"
+		case p.peek().kind == tokName && p.tokens[p.i+1].kind == tokOp && p.tokens[p.i+1].text == "=":
+			arg.name = p.next().text
+			p.next()
+		}
+
+		x, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+
+		arg.x = x
+		args = append(args, arg)
+	}
+
+	return args, nil
+}
+
+// subscript parses an index or slice after its bracket.
+func (p *parser) subscript(pos Position, x expr) (expr, error) {
+	var parts [3]expr
+
+	n := 0
+
+	for {
+		if !p.is(":") && !p.is("]") {
+			e, err := p.test()
+			if err != nil {
+				return nil, err
+			}
+
+			parts[n] = e
+		}
+
+		if p.accept("]") {
+			break
+		}
+
+		if n == 2 || !p.accept(":") {
+			return nil, p.unexpected(`":" or "]"`)
+		}
+
+		n++
+	}
+
+	if n == 0 {
+		if parts[0] == nil {
+			return nil, &Error{Pos: pos, Msg: "missing index"}
+		}
+
+		return &indexExpr{pos, x, parts[0]}, nil
+	}
+
+	return &sliceExpr{pos, x, parts[0], parts[1], parts[2]}, nil
+}
+
+// operand parses a name, literal or display.
+func (p *parser) operand() (expr, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokInt:
+		return &literal{t.pos, Int(t.num)}, nil
+	case tokString:
+		s := t.text
+
+		// adjacent strings are concatenated.
+		for p.peek().kind == tokString {
+			s += p.next().text
+		}
+
+		return &literal{t.pos, String(s)}, nil
+	case tokName:
+		switch t.text {
+		case "None":
+			return &literal{t.pos, None}, nil
+		case "True":
+			return &literal{t.pos, True}, nil
+		case "False":
+			return &literal{t.pos, False}, nil
+		}
+
+		if keywords[t.text] {
+			break
+		}
+
+		return &nameExpr{t.pos, t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			if p.accept(")") {
+				return &tupleExpr{t.pos, nil}, nil
+			}
+
+			x, err := p.exprList()
+			if err != nil {
+				return nil, err
+			}
+
+			return x, p.expect(")")
+		case "[":
+			return p.list(t.pos)
+		case "{":
+			return p.dict(t.pos)
+		}
+	}
+
+	p.i--
+
+	return nil, p.unexpected("an expression")
+}
+
+// list parses a list display or comprehension after its bracket.
+func (p *parser) list(pos Position) (expr, error) {
+	var elems []expr
+
+	for !p.accept("]") {
+		if len(elems) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+
+			if p.accept("]") {
+				break
+			}
+		}
+
+		x, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(elems) == 0 && p.is("for") {
+			return p.comprehension(pos, x)
+		}
+
+		elems = append(elems, x)
+	}
+
+	return &listExpr{pos, elems}, nil
+}
+
+// comprehension parses the clauses of a list comprehension.
+func (p *parser) comprehension(pos Position, body expr) (expr, error) {
+	c := &comprehension{pos: pos, body: body}
+
+	for !p.accept("]") {
+		switch {
+		case p.accept("for"):
+			vars, err := p.targets()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := p.expect("in"); err != nil {
+				return nil, err
+			}
+
+			x, err := p.binary(0)
+			if err != nil {
+				return nil, err
+			}
+
+			c.clauses = append(c.clauses, clause{vars, x})
+		case p.accept("if"):
+			x, err := p.binary(0)
+			if err != nil {
+				return nil, err
+			}
+
+			c.clauses = append(c.clauses, clause{nil, x})
+		default:
+			return nil, p.unexpected(`"for", "if" or "]"`)
+		}
+	}
+
+	return c, nil
+}
+
+// dict parses a dict display after its brace.
+func (p *parser) dict(pos Position) (expr, error) {
+	d := &dictExpr{pos: pos}
+
+	for !p.accept("}") {
+		if len(d.keys) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+
+			if p.accept("}") {
+				break
+			}
+		}
+
+		k, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+
+		v, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+
+		d.keys = append(d.keys, k)
+		d.values = append(d.values, v)
+	}
+
+	return d, nil
+}
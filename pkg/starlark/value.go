@@ -0,0 +1,469 @@
+package starlark
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Value is a value of a script: None, a Bool, Int, String, Tuple,
+// *List, *Dict, *Function or *Builtin.
+type Value interface {
+	// Type names the type of the value.
+	Type() string
+}
+
+// NoneType is the type of None.
+type NoneType struct{}
+
+// None is the absence of a value.
+var None = NoneType{}
+
+// Bool is a boolean.
+type Bool bool
+
+// The booleans.
+const (
+	True  = Bool(true)
+	False = Bool(false)
+)
+
+// Int is an integer, of 64 bits rather than unbounded as in Starlark.
+type Int int64
+
+// String is a string of bytes.
+type String string
+
+// Tuple is an immutable sequence.
+type Tuple []Value
+
+// List is a mutable sequence.
+type List struct {
+	// Elems are the elements of the list.
+	Elems []Value
+}
+
+// Dict maps keys to values, remembering the order keys were added in.
+type Dict struct {
+	// keys are the keys in the order they were added.
+	keys []Value
+
+	// values maps the hashes of the keys to their values.
+	values map[string]Value
+}
+
+// Function is a function defined by a script.
+type Function struct {
+	// decl declares the function.
+	decl *funcDecl
+
+	// defaults are the default values of its last parameters.
+	defaults []Value
+
+	// env is the frame the function was defined in.
+	env *frame
+}
+
+// Builtin is a function implemented in Go.
+type Builtin struct {
+	// name is the name of the function.
+	name string
+
+	// fn implements the function.
+	fn func(args Tuple, kwargs []Tuple) (Value, error)
+
+	// recv is the value a method is bound to, nil for functions.
+	recv Value
+}
+
+func (NoneType) Type() string  { return "NoneType" }
+func (Bool) Type() string      { return "bool" }
+func (Int) Type() string       { return "int" }
+func (String) Type() string    { return "string" }
+func (Tuple) Type() string     { return "tuple" }
+func (*List) Type() string     { return "list" }
+func (*Dict) Type() string     { return "dict" }
+func (*Function) Type() string { return "function" }
+func (*Builtin) Type() string  { return "builtin_function_or_method" }
+
+// NewBuiltin returns a function implemented in Go, called with the
+// positional arguments and the name and value pairs of the keyword
+// arguments.
+func NewBuiltin(name string, fn func(args Tuple, kwargs []Tuple) (Value, error)) *Builtin {
+	return &Builtin{name: name, fn: fn}
+}
+
+// Name returns the name of the function.
+func (b *Builtin) Name() string {
+	return b.name
+}
+
+// Name returns the name of the function.
+func (f *Function) Name() string {
+	return f.decl.name
+}
+
+// NewList returns a list of elements.
+func NewList(elems []Value) *List {
+	return &List{Elems: elems}
+}
+
+// NewDict returns an empty dict.
+func NewDict() *Dict {
+	return &Dict{values: map[string]Value{}}
+}
+
+// hash returns the key a value is stored under in a dict, failing for
+// values that may change.
+func hash(v Value) (string, error) {
+	switch v := v.(type) {
+	case NoneType, Bool, Int, String:
+		return v.Type() + ":" + Repr(v), nil
+	case Tuple:
+		parts := make([]string, len(v))
+		for i, elem := range v {
+			h, err := hash(elem)
+			if err != nil {
+				return "", err
+			}
+
+			parts[i] = h
+		}
+
+		return "tuple:(" + strings.Join(parts, ",") + ")", nil
+	}
+
+	return "", fmt.Errorf("unhashable type: %s", v.Type())
+}
+
+// Get returns the value of a key.
+func (d *Dict) Get(key Value) (Value, bool, error) {
+	h, err := hash(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	v, ok := d.values[h]
+
+	return v, ok, nil
+}
+
+// Set sets the value of a key.
+func (d *Dict) Set(key, value Value) error {
+	h, err := hash(key)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := d.values[h]; !ok {
+		d.keys = append(d.keys, key)
+	}
+
+	d.values[h] = value
+
+	return nil
+}
+
+// Delete deletes a key, returning its value.
+func (d *Dict) Delete(key Value) (Value, bool, error) {
+	h, err := hash(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	v, ok := d.values[h]
+	if !ok {
+		return nil, false, nil
+	}
+
+	delete(d.values, h)
+
+	for i, k := range d.keys {
+		if kh, _ := hash(k); kh == h {
+			d.keys = append(d.keys[:i:i], d.keys[i+1:]...)
+			break
+		}
+	}
+
+	return v, true, nil
+}
+
+// Keys returns the keys in the order they were added.
+func (d *Dict) Keys() []Value {
+	return append([]Value(nil), d.keys...)
+}
+
+// Len returns the number of keys.
+func (d *Dict) Len() int {
+	return len(d.keys)
+}
+
+// Truth reports whether a value is true in a condition.
+func Truth(v Value) bool {
+	switch v := v.(type) {
+	case NoneType:
+		return false
+	case Bool:
+		return bool(v)
+	case Int:
+		return v != 0
+	case String:
+		return v != ""
+	case Tuple:
+		return len(v) > 0
+	case *List:
+		return len(v.Elems) > 0
+	case *Dict:
+		return v.Len() > 0
+	}
+
+	return true
+}
+
+// Repr renders a value as it would be written in a script.
+func Repr(v Value) string {
+	var sb strings.Builder
+	writeValue(&sb, v, true)
+
+	return sb.String()
+}
+
+// Str renders a value as str does, strings without quotes.
+func Str(v Value) string {
+	if s, ok := v.(String); ok {
+		return string(s)
+	}
+
+	return Repr(v)
+}
+
+// writeValue writes a value, quoting strings if quote is set.
+func writeValue(sb *strings.Builder, v Value, quote bool) {
+	switch v := v.(type) {
+	case NoneType:
+		sb.WriteString("None")
+	case Bool:
+		if v {
+			sb.WriteString("True")
+		} else {
+			sb.WriteString("False")
+		}
+	case Int:
+		sb.WriteString(strconv.FormatInt(int64(v), 10))
+	case String:
+		if quote {
+			sb.WriteString(strconv.Quote(string(v)))
+		} else {
+			sb.WriteString(string(v))
+		}
+	case Tuple:
+		sb.WriteByte('(')
+		writeElems(sb, v)
+
+		if len(v) == 1 {
+			sb.WriteByte(',')
+		}
+
+		sb.WriteByte(')')
+	case *List:
+		sb.WriteByte('[')
+		writeElems(sb, v.Elems)
+		sb.WriteByte(']')
+	case *Dict:
+		sb.WriteByte('{')
+
+		for i, k := range v.keys {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+
+			val, _, _ := v.Get(k)
+			writeValue(sb, k, true)
+			sb.WriteString(": ")
+			writeValue(sb, val, true)
+		}
+
+		sb.WriteByte('}')
+	case *Function:
+		fmt.Fprintf(sb, "<function %s>", v.decl.name)
+	case *Builtin:
+		fmt.Fprintf(sb, "<built-in function %s>", v.name)
+	default:
+		fmt.Fprintf(sb, "<%s>", v.Type())
+	}
+}
+
+// writeElems writes the elements of a sequence separated by commas.
+func writeElems(sb *strings.Builder, elems []Value) {
+	for i, elem := range elems {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+
+		writeValue(sb, elem, true)
+	}
+}
+
+// equal reports whether two values are equal.
+func equal(x, y Value) bool {
+	switch x := x.(type) {
+	case Tuple:
+		y, ok := y.(Tuple)
+		return ok && equalElems(x, y)
+	case *List:
+		y, ok := y.(*List)
+		return ok && equalElems(x.Elems, y.Elems)
+	case *Dict:
+		y, ok := y.(*Dict)
+		if !ok || x.Len() != y.Len() {
+			return false
+		}
+
+		for _, k := range x.keys {
+			xv, _, _ := x.Get(k)
+
+			yv, found, _ := y.Get(k)
+			if !found || !equal(xv, yv) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return x == y
+}
+
+// equalElems reports whether two sequences are equal.
+func equalElems(x, y []Value) bool {
+	if len(x) != len(y) {
+		return false
+	}
+
+	for i := range x {
+		if !equal(x[i], y[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compare orders two ints, strings or sequences of them.
+func compare(x, y Value) (int, error) {
+	switch x := x.(type) {
+	case Int:
+		if y, ok := y.(Int); ok {
+			return cmpOrdered(x, y), nil
+		}
+	case String:
+		if y, ok := y.(String); ok {
+			return cmpOrdered(x, y), nil
+		}
+	case Bool:
+		if y, ok := y.(Bool); ok {
+			return cmpOrdered(boolInt(x), boolInt(y)), nil
+		}
+	case Tuple:
+		if y, ok := y.(Tuple); ok {
+			return compareElems(x, y)
+		}
+	case *List:
+		if y, ok := y.(*List); ok {
+			return compareElems(x.Elems, y.Elems)
+		}
+	}
+
+	return 0, fmt.Errorf("cannot compare %s and %s", x.Type(), y.Type())
+}
+
+// cmpOrdered orders two ordered values.
+func cmpOrdered[T Int | String](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+
+	return 0
+}
+
+// boolInt converts a boolean to 0 or 1.
+func boolInt(b Bool) Int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// compareElems orders two sequences lexicographically.
+func compareElems(x, y []Value) (int, error) {
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if equal(x[i], y[i]) {
+			continue
+		}
+
+		return compare(x[i], y[i])
+	}
+
+	return cmpOrdered(Int(len(x)), Int(len(y))), nil
+}
+
+// sortValues sorts values in place, failing if they cannot be
+// compared.
+func sortValues(values []Value, reverse bool) error {
+	var err error
+
+	sort.SliceStable(values, func(i, j int) bool {
+		c, cerr := compare(values[i], values[j])
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+
+		if reverse {
+			return c > 0
+		}
+
+		return c < 0
+	})
+
+	return err
+}
+
+// elements returns the elements of an iterable value, a copy so that
+// iterating is not disturbed by changes.
+func elements(v Value) ([]Value, error) {
+	switch v := v.(type) {
+	case Tuple:
+		return v, nil
+	case *List:
+		return append([]Value(nil), v.Elems...), nil
+	case *Dict:
+		return v.Keys(), nil
+	}
+
+	return nil, fmt.Errorf("%s is not iterable", v.Type())
+}
+
+// AsInt returns the value of an Int, failing for other values.
+func AsInt(v Value) (int, error) {
+	n, ok := v.(Int)
+	if !ok {
+		return 0, fmt.Errorf("got %s, want int", v.Type())
+	}
+
+	return int(n), nil
+}
+
+// AsString returns the value of a String, failing for other values.
+func AsString(v Value) (string, error) {
+	s, ok := v.(String)
+	if !ok {
+		return "", fmt.Errorf("got %s, want string", v.Type())
+	}
+
+	return string(s), nil
+}
@@ -0,0 +1,175 @@
+// Package state compares, exports and imports the states of machines
+// saved as snapshots.
+package state
+
+import (
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/disasm"
+	"lc3/pkg/registers"
+	"lc3/pkg/symbols"
+	"math"
+	"strings"
+)
+
+// maxRangeWords is the number of words of a range written unless every
+// word is asked for.
+const maxRangeWords = 16
+
+// Diff is what changed between two states.
+type Diff struct {
+	// Registers are the registers that changed, in order.
+	Registers []RegisterChange
+
+	// Halted reports whether the program halted in between.
+	Halted bool
+
+	// Ranges are the runs of memory that changed, in order.
+	Ranges []Range
+}
+
+// RegisterChange is a register that changed.
+type RegisterChange struct {
+	// Register is the index of the register, registers.RR0 to
+	// registers.RCOND.
+	Register uint16
+
+	// Old and New are its values.
+	Old, New uint16
+}
+
+// Range is a run of consecutive words of memory that changed.
+type Range struct {
+	// Start is the address of the first word.
+	Start uint16
+
+	// Old and New are the words before and after.
+	Old, New []uint16
+}
+
+// End returns the address of the last word of the range.
+func (r *Range) End() uint16 {
+	return r.Start + uint16(len(r.New)) - 1
+}
+
+// Compare finds what changed from state a to state b.
+func Compare(a, b *cpu.Snapshot) *Diff {
+	d := &Diff{Halted: !a.Halted && b.Halted}
+
+	for r := range a.Registers {
+		if a.Registers[r] != b.Registers[r] {
+			d.Registers = append(d.Registers, RegisterChange{Register: uint16(r), Old: a.Registers[r], New: b.Registers[r]})
+		}
+	}
+
+	for i := 0; i <= math.MaxUint16; i++ {
+		if a.Memory[i] == b.Memory[i] {
+			continue
+		}
+
+		start := i
+		for i <= math.MaxUint16 && a.Memory[i] != b.Memory[i] {
+			i++
+		}
+
+		d.Ranges = append(d.Ranges, Range{Start: uint16(start), Old: a.Memory[start:i], New: b.Memory[start:i]})
+	}
+
+	return d
+}
+
+// Words counts the words of memory that changed.
+func (d *Diff) Words() int {
+	n := 0
+	for _, r := range d.Ranges {
+		n += len(r.New)
+	}
+
+	return n
+}
+
+// Empty reports whether nothing changed.
+func (d *Diff) Empty() bool {
+	return len(d.Registers) == 0 && len(d.Ranges) == 0 && !d.Halted
+}
+
+// Write writes the diff as text: the registers that changed, then every
+// range of memory that changed with each word before and after, its
+// label, its character and its disassembly. Unless all is set, only the
+// first words of long ranges are written.
+func (d *Diff) Write(w io.Writer, table *symbols.Table, all bool) error {
+	if d.Empty() {
+		_, err := fmt.Fprintln(w, "no differences")
+		return err
+	}
+
+	if d.Halted {
+		fmt.Fprintln(w, "the program halted")
+	}
+
+	if len(d.Registers) > 0 {
+		fmt.Fprintln(w, "registers:")
+	}
+
+	for _, r := range d.Registers {
+		if r.Register == registers.RCOND {
+			fmt.Fprintf(w, "  COND %s -> %s\n", conditions(r.Old), conditions(r.New))
+			continue
+		}
+
+		fmt.Fprintf(w, "  %-4s x%04X -> x%04X\n", registers.Name(r.Register), r.Old, r.New)
+	}
+
+	if len(d.Ranges) > 0 {
+		fmt.Fprintf(w, "memory: %d words changed in %d ranges\n", d.Words(), len(d.Ranges))
+	}
+
+	for _, r := range d.Ranges {
+		fmt.Fprintf(w, "x%04X-x%04X %s, %d words:\n", r.Start, r.End(), table.Format(r.Start), len(r.New))
+
+		n := len(r.New)
+		if !all {
+			n = min(n, maxRangeWords)
+		}
+
+		for i := 0; i < n; i++ {
+			address := r.Start + uint16(i)
+			line := fmt.Sprintf("  x%04X %-12s x%04X -> x%04X  %-4s %s", address, table.Format(address), r.Old[i], r.New[i], char(r.New[i]), disasm.Format(address, r.New[i]))
+
+			fmt.Fprintln(w, strings.TrimRight(line, " "))
+		}
+
+		if n < len(r.New) {
+			fmt.Fprintf(w, "  ... %d more words\n", len(r.New)-n)
+		}
+	}
+
+	return nil
+}
+
+// conditions names the condition codes set, as N, Z and P.
+func conditions(cond uint16) string {
+	s := ""
+
+	for i, name := range "PZN" {
+		if cond&(1<<i) != 0 {
+			s = string(name) + s
+		}
+	}
+
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// char renders a word as a quoted character if it is printable.
+func char(word uint16) string {
+	if word < 0x20 || word >= 0x7F {
+		return ""
+	}
+
+	return fmt.Sprintf("'%c'", word)
+}
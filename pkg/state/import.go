@@ -0,0 +1,335 @@
+package state
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"lc3/pkg/cflags"
+	"lc3/pkg/cpu"
+	"lc3/pkg/registers"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ImportFormat is a format of the memory dumps of other simulators.
+type ImportFormat int
+
+const (
+	// ImportListing is a listing of one address and word per line, such
+	// as those copied from the memory views of PennSim and Complx,
+	// "x3000 x1234" or "x3000: x1234 ADD R1, R0, R4", with anything
+	// after the word ignored. Lines such as "R1 x0005",
+	// "PC x3000", "PSR x8002" or "CC z" set registers.
+	ImportListing ImportFormat = iota
+
+	// ImportReadmemh is a Verilog $readmemh file, as PennSim writes
+	// with dump -readmemh: hex words, with @3000 setting the address
+	// of the words after it.
+	ImportReadmemh
+
+	// ImportCOE is a Xilinx coefficient file, as PennSim writes with
+	// dump -coe: a memory_initialization_vector of words in the
+	// memory_initialization_radix, loaded from an origin.
+	ImportCOE
+)
+
+// importFormats names the import formats.
+var importFormats = map[ImportFormat]string{
+	ImportListing:  "listing",
+	ImportReadmemh: "readmemh",
+	ImportCOE:      "coe",
+}
+
+// String returns the name of the format.
+func (f ImportFormat) String() string {
+	return importFormats[f]
+}
+
+// LookupImportFormat returns an import format by name, listing,
+// readmemh or coe.
+func LookupImportFormat(name string) (ImportFormat, bool) {
+	for f, n := range importFormats {
+		if strings.EqualFold(n, name) {
+			return f, true
+		}
+	}
+
+	return 0, false
+}
+
+// ImportFormatNames returns the names of the import formats, sorted.
+func ImportFormatNames() []string {
+	names := make([]string, 0, len(importFormats))
+	for _, name := range importFormats {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Import reads a memory dump in a format into a state, setting the
+// words and registers it holds and leaving the others as they were.
+// Words of formats without addresses, like COE, are loaded from
+// origin.
+func Import(s *cpu.Snapshot, r io.Reader, format ImportFormat, origin uint16) error {
+	switch format {
+	case ImportListing:
+		return importListing(s, r)
+	case ImportReadmemh:
+		return importReadmemh(s, r)
+	case ImportCOE:
+		return importCOE(s, r, origin)
+	default:
+		return fmt.Errorf("unknown import format %d", format)
+	}
+}
+
+// importListing reads a listing of addresses and words.
+func importListing(s *cpu.Snapshot, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.FieldsFunc(stripComment(scanner.Text(), ";", "//"), func(c rune) bool {
+			return c == ' ' || c == '\t' || c == ':' || c == '=' || c == ','
+		})
+
+		if len(fields) < 2 {
+			continue
+		}
+
+		if err := importRegister(s, fields[0], fields[1]); !errors.Is(err, errNotRegister) {
+			if err != nil {
+				return fmt.Errorf("line %d: %w", n, err)
+			}
+
+			continue
+		}
+
+		address, err := parseWord(fields[0])
+		if err != nil {
+			// headers and other lines that do not start with an
+			// address are skipped.
+			continue
+		}
+
+		value, err := parseWord(fields[1])
+		if err != nil {
+			return fmt.Errorf("line %d: the word at x%04X: %w", n, address, err)
+		}
+
+		s.Memory[address] = value
+	}
+
+	return scanner.Err()
+}
+
+// errNotRegister is returned by importRegister for lines that do not
+// set a register.
+var errNotRegister = errors.New("not a register")
+
+// importRegister sets a register named in a listing, R0 to R7, PC, PSR
+// or CC.
+func importRegister(s *cpu.Snapshot, name, value string) error {
+	name = strings.ToUpper(name)
+
+	if name == "CC" {
+		cond, ok := parseConditions(value)
+		if !ok {
+			return fmt.Errorf("invalid condition codes %q", value)
+		}
+
+		s.Registers[registers.RCOND] = cond
+
+		return nil
+	}
+
+	var r uint16
+
+	switch {
+	case name == "PC":
+		r = registers.RPC
+	case name == "PSR":
+		r = registers.RCOND
+	case len(name) == 2 && name[0] == 'R' && name[1] >= '0' && name[1] <= '7':
+		r = uint16(name[1] - '0')
+	default:
+		return errNotRegister
+	}
+
+	word, err := parseWord(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if name == "PSR" {
+		// the condition codes are the low bits of the PSR.
+		word &= cflags.FLNEG | cflags.FLZRO | cflags.FLPOS
+	}
+
+	s.Registers[r] = word
+
+	return nil
+}
+
+// parseConditions parses condition codes such as z or N.
+func parseConditions(s string) (uint16, bool) {
+	var cond uint16
+
+	for _, c := range strings.ToUpper(s) {
+		switch c {
+		case 'N':
+			cond |= cflags.FLNEG
+		case 'Z':
+			cond |= cflags.FLZRO
+		case 'P':
+			cond |= cflags.FLPOS
+		default:
+			return 0, false
+		}
+	}
+
+	return cond, cond != 0
+}
+
+// importReadmemh reads a Verilog $readmemh file.
+func importReadmemh(s *cpu.Snapshot, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+
+	address := 0
+
+	for n := 1; scanner.Scan(); n++ {
+		for _, field := range strings.Fields(stripComment(scanner.Text(), "//")) {
+			if strings.HasPrefix(field, "@") {
+				a, err := strconv.ParseUint(field[1:], 16, 16)
+				if err != nil {
+					return fmt.Errorf("line %d: invalid address %s", n, field)
+				}
+
+				address = int(a)
+
+				continue
+			}
+
+			word, err := strconv.ParseUint(field, 16, 16)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid word %s", n, field)
+			}
+
+			if address > math.MaxUint16 {
+				return fmt.Errorf("line %d: the words run past xFFFF", n)
+			}
+
+			s.Memory[address] = uint16(word)
+			address++
+		}
+	}
+
+	return scanner.Err()
+}
+
+// importCOE reads a Xilinx coefficient file into memory from origin.
+func importCOE(s *cpu.Snapshot, r io.Reader, origin uint16) error {
+	var text strings.Builder
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// comments take up whole lines starting with a semicolon,
+		// which otherwise ends statements.
+		if line := strings.TrimSpace(scanner.Text()); !strings.HasPrefix(line, ";") {
+			text.WriteString(line + "\n")
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	radix := 10
+	found := false
+
+	for _, statement := range strings.Split(text.String(), ";") {
+		key, value, ok := strings.Cut(statement, "=")
+		if !ok {
+			continue
+		}
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "memory_initialization_radix":
+			r, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || r != 2 && r != 10 && r != 16 {
+				return fmt.Errorf("invalid radix %s", strings.TrimSpace(value))
+			}
+
+			radix = r
+		case "memory_initialization_vector":
+			found = true
+
+			address := int(origin)
+
+			for _, field := range strings.FieldsFunc(value, func(c rune) bool {
+				return c == ',' || c == ' ' || c == '\t' || c == '\n' || c == '\r'
+			}) {
+				word, err := strconv.ParseUint(field, radix, 16)
+				if err != nil {
+					return fmt.Errorf("invalid word %s in radix %d", field, radix)
+				}
+
+				if address > math.MaxUint16 {
+					return fmt.Errorf("the words run past xFFFF")
+				}
+
+				s.Memory[address] = uint16(word)
+				address++
+			}
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("no memory_initialization_vector")
+	}
+
+	return nil
+}
+
+// parseWord parses a word written as x3000, 0x3000, #12288 or 3000 in
+// hex.
+func parseWord(s string) (uint16, error) {
+	base := 16
+
+	switch {
+	case strings.HasPrefix(s, "x") || strings.HasPrefix(s, "X"):
+		s, base = s[1:], 16
+	case strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X"):
+		s, base = s[2:], 16
+	case strings.HasPrefix(s, "#"):
+		n, err := strconv.ParseInt(s[1:], 10, 32)
+		if err != nil || n < math.MinInt16 || n > math.MaxUint16 {
+			return 0, fmt.Errorf("invalid word %s", s)
+		}
+
+		return uint16(n), nil
+	}
+
+	n, err := strconv.ParseUint(s, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid word %s", s)
+	}
+
+	return uint16(n), nil
+}
+
+// stripComment cuts a line at the first of the comment markers.
+func stripComment(line string, markers ...string) string {
+	for _, marker := range markers {
+		if i := strings.Index(line, marker); i >= 0 {
+			line = line[:i]
+		}
+	}
+
+	return line
+}
@@ -0,0 +1,132 @@
+package state
+
+import (
+	"fmt"
+	"lc3/pkg/cpu"
+	"lc3/pkg/protowire"
+	"lc3/pkg/registers"
+	"math"
+)
+
+// The fields of the State and MemoryRun messages of proto/lc3.proto.
+const (
+	stateRegisters = 1
+	stateCond      = 2
+	stateHalted    = 3
+	stateMemory    = 4
+
+	runAddress = 1
+	runWords   = 2
+)
+
+// zeroGap is the shortest run of zeros that splits memory into runs.
+const zeroGap = 4
+
+// MarshalProto encodes a state as the State message of proto/lc3.proto.
+func MarshalProto(s *cpu.Snapshot) []byte {
+	var b []byte
+
+	b = protowire.AppendPacked(b, stateRegisters, s.Registers[:registers.RCOND])
+	b = protowire.AppendVarint(b, stateCond, uint64(s.Registers[registers.RCOND]))
+	b = protowire.AppendBool(b, stateHalted, s.Halted)
+
+	for i := 0; i <= math.MaxUint16; {
+		if s.Memory[i] == 0 {
+			i++
+			continue
+		}
+
+		start, end := i, i+1
+		for i = end; i <= math.MaxUint16 && i-end < zeroGap; i++ {
+			if s.Memory[i] != 0 {
+				end = i + 1
+			}
+		}
+
+		var run []byte
+		run = protowire.AppendVarint(run, runAddress, uint64(start))
+		run = protowire.AppendPacked(run, runWords, s.Memory[start:end])
+
+		b = protowire.AppendBytes(b, stateMemory, run)
+		i = end
+	}
+
+	return b
+}
+
+// UnmarshalProto decodes a State message of proto/lc3.proto. Fields it
+// does not know are skipped.
+func UnmarshalProto(b []byte) (*cpu.Snapshot, error) {
+	s := &cpu.Snapshot{}
+	r := 0
+
+	for len(b) > 0 {
+		f, rest, err := protowire.Consume(b)
+		if err != nil {
+			return nil, err
+		}
+
+		b = rest
+
+		switch f.Number {
+		case stateRegisters:
+			values, err := f.Packed()
+			if err != nil {
+				return nil, err
+			}
+
+			if r+len(values) > registers.RCOND {
+				return nil, fmt.Errorf("too many registers")
+			}
+
+			r += copy(s.Registers[r:], values)
+		case stateCond:
+			s.Registers[registers.RCOND] = uint16(f.Varint)
+		case stateHalted:
+			s.Halted = f.Varint != 0
+		case stateMemory:
+			if err := unmarshalRun(f.Bytes, s); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// unmarshalRun decodes a MemoryRun message into the memory of a state.
+func unmarshalRun(b []byte, s *cpu.Snapshot) error {
+	var (
+		address uint64
+		words   []uint16
+	)
+
+	for len(b) > 0 {
+		f, rest, err := protowire.Consume(b)
+		if err != nil {
+			return err
+		}
+
+		b = rest
+
+		switch f.Number {
+		case runAddress:
+			address = f.Varint
+		case runWords:
+			values, err := f.Packed()
+			if err != nil {
+				return err
+			}
+
+			words = append(words, values...)
+		}
+	}
+
+	if address+uint64(len(words)) > math.MaxUint16+1 {
+		return fmt.Errorf("run at x%04X runs past the end of memory", address)
+	}
+
+	copy(s.Memory[address:], words)
+
+	return nil
+}
@@ -0,0 +1,175 @@
+// Package stuck detects programs caught in a loop that can never end,
+// so that they can be stopped as soon as they get there instead of
+// running until an instruction limit.
+//
+// A program is stuck once the PC, registers and condition codes repeat
+// without the program having made progress in between. Progress is a
+// write that changes memory, an access to a device, whose registers
+// may change on their own, or a trap, which may read input. Without
+// any, every instruction is a function of the state before it, so a
+// state that repeats repeats forever.
+package stuck
+
+import (
+	"fmt"
+	"lc3/pkg/cpu"
+	"lc3/pkg/disasm"
+	"lc3/pkg/opcodes"
+	"lc3/pkg/registers"
+	"lc3/pkg/symbols"
+	"sort"
+)
+
+// Instruction is an instruction of a loop.
+type Instruction struct {
+	// Address is the address of the instruction.
+	Address uint16
+
+	// Word is the instruction word.
+	Word uint16
+}
+
+// Loop is a loop a program is stuck in.
+type Loop struct {
+	// Address is the lowest address of the loop, usually its head.
+	Address uint16
+
+	// Period is the number of instructions run per iteration.
+	Period uint64
+
+	// Instructions are the instructions of the loop, ordered by
+	// address.
+	Instructions []Instruction
+}
+
+// Error describes where the program is stuck.
+func (l *Loop) Error() string {
+	return fmt.Sprintf("program appears stuck in a non-progressing loop at x%04X", l.Address)
+}
+
+// Lines disassembles the loop, one instruction per line, labelled
+// from table, which may be nil.
+func (l *Loop) Lines(table *symbols.Table) []string {
+	lines := make([]string, 0, len(l.Instructions))
+
+	for _, i := range l.Instructions {
+		label, _ := table.Name(i.Address)
+		lines = append(lines, fmt.Sprintf("x%04X  %-8s %s", i.Address, label, disasm.Format(i.Address, i.Word)))
+	}
+
+	return lines
+}
+
+// Detector watches a CPU for a loop it is stuck in.
+//
+// States are compared with Brent's algorithm: the state is saved at
+// every power of two instructions since the last progress and
+// compared with every state after it, which finds a loop within
+// about twice its length plus the instructions before it.
+type Detector struct {
+	// saved is the state compared against.
+	saved [registers.RCOUNT]uint16
+
+	// steps counts the instructions since the state was saved.
+	steps uint64
+
+	// power is the number of steps after which the state is saved
+	// again.
+	power uint64
+
+	// progress is set when the program made progress since the
+	// state was last looked at.
+	progress bool
+
+	// words are the instructions run since the state was saved.
+	words map[uint16]uint16
+
+	// loop is the loop found, nil until one is.
+	loop *Loop
+}
+
+// New creates a detector.
+func New() *Detector {
+	return &Detector{progress: true}
+}
+
+// Record watches the instructions run by a CPU.
+func (d *Detector) Record(c cpu.CPU) {
+	devices := c.Devices()
+
+	c.OnMemoryAccess(func(access cpu.MemoryAccess) {
+		if _, ok := devices[access.Address]; ok || access.Write && access.Value != access.Old {
+			d.progress = true
+		}
+	})
+
+	c.OnInstruction(func(pc, instr uint16) {
+		if d.loop != nil {
+			return
+		}
+
+		if instr>>12 == opcodes.OPTRAP {
+			d.progress = true
+		}
+
+		var state [registers.RCOUNT]uint16
+		for r := range state {
+			state[r] = c.Register(uint16(r))
+		}
+
+		d.observe(state, pc, instr)
+	})
+}
+
+// observe looks at the state an instruction left.
+func (d *Detector) observe(state [registers.RCOUNT]uint16, pc, instr uint16) {
+	if d.progress {
+		d.progress = false
+		d.save(state, 1)
+
+		return
+	}
+
+	d.words[pc] = instr
+	d.steps++
+
+	if state == d.saved {
+		d.loop = newLoop(d.words, d.steps)
+		return
+	}
+
+	if d.steps == d.power {
+		d.save(state, d.power*2)
+	}
+}
+
+// save saves the state, to be saved again after power instructions.
+func (d *Detector) save(state [registers.RCOUNT]uint16, power uint64) {
+	d.saved = state
+	d.steps = 0
+	d.power = power
+	d.words = map[uint16]uint16{}
+}
+
+// newLoop creates a loop of the instructions run in one iteration.
+func newLoop(words map[uint16]uint16, period uint64) *Loop {
+	loop := &Loop{Period: period}
+
+	for address, word := range words {
+		loop.Instructions = append(loop.Instructions, Instruction{Address: address, Word: word})
+	}
+
+	sort.Slice(loop.Instructions, func(i, j int) bool {
+		return loop.Instructions[i].Address < loop.Instructions[j].Address
+	})
+
+	loop.Address = loop.Instructions[0].Address
+
+	return loop
+}
+
+// Loop returns the loop the program is stuck in, or nil if it has not
+// been found stuck.
+func (d *Detector) Loop() *Loop {
+	return d.loop
+}
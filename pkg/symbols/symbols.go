@@ -0,0 +1,198 @@
+// Package symbols reads and writes symbol tables in the .sym
+// format produced by lc3as, mapping labels to addresses so that
+// tools can show and accept names instead of raw addresses.
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Table maps labels to addresses and back.
+type Table struct {
+	// addresses maps labels to addresses.
+	addresses map[string]uint16
+
+	// sorted holds the labels ordered by address, it is rebuilt
+	// lazily after the table changes.
+	sorted []string
+}
+
+// New creates an empty symbol table.
+func New() *Table {
+	return &Table{
+		addresses: map[string]uint16{},
+	}
+}
+
+// Load reads a symbol table from a file.
+func Load(filename string) (*Table, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse reads a symbol table in lc3as format:
+//
+//	// Symbol table
+//	// Scope level 0:
+//	//	Symbol Name       Page Address
+//	//	----------------  ------------
+//	//	LOOP              3002
+func Parse(r io.Reader) (*Table, error) {
+	t := New()
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "//"))
+		if len(fields) != 2 || fields[0] == "Symbol" || strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+
+		address, err := strconv.ParseUint(fields[1], 16, 16)
+		if err != nil {
+			continue
+		}
+
+		t.Add(fields[0], uint16(address))
+	}
+
+	return t, scanner.Err()
+}
+
+// Add adds a label.
+func (t *Table) Add(name string, address uint16) {
+	t.addresses[name] = address
+	t.sorted = nil
+}
+
+// Len returns the number of labels.
+func (t *Table) Len() int {
+	return len(t.addresses)
+}
+
+// Address returns the address of a label. Labels are matched
+// exactly first, then ignoring case. A nil table has no labels.
+func (t *Table) Address(name string) (uint16, bool) {
+	if t == nil {
+		return 0, false
+	}
+
+	if address, ok := t.addresses[name]; ok {
+		return address, true
+	}
+
+	for label, address := range t.addresses {
+		if strings.EqualFold(label, name) {
+			return address, true
+		}
+	}
+
+	return 0, false
+}
+
+// Name returns the label at exactly the address. A nil table has no
+// labels.
+func (t *Table) Name(address uint16) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+
+	for _, label := range t.Labels() {
+		if t.addresses[label] == address {
+			return label, true
+		}
+	}
+
+	return "", false
+}
+
+// Labels returns the labels ordered by address, then by name. A nil
+// table has no labels.
+func (t *Table) Labels() []string {
+	if t == nil {
+		return nil
+	}
+
+	if t.sorted == nil {
+		t.sorted = make([]string, 0, len(t.addresses))
+		for label := range t.addresses {
+			t.sorted = append(t.sorted, label)
+		}
+
+		sort.Slice(t.sorted, func(i, j int) bool {
+			a, b := t.addresses[t.sorted[i]], t.addresses[t.sorted[j]]
+			if a != b {
+				return a < b
+			}
+
+			return t.sorted[i] < t.sorted[j]
+		})
+	}
+
+	return t.sorted
+}
+
+// Nearest returns the closest label at or before the address along
+// with the distance from it. A nil table has no labels.
+func (t *Table) Nearest(address uint16) (string, uint16, bool) {
+	labels := t.Labels()
+
+	i := sort.Search(len(labels), func(i int) bool {
+		return t.addresses[labels[i]] > address
+	})
+
+	if i == 0 {
+		return "", 0, false
+	}
+
+	label := labels[i-1]
+
+	return label, address - t.addresses[label], true
+}
+
+// Format renders an address as LABEL or LABEL+OFFSET using the nearest
+// preceding label, or as a hex address if there is none. A nil table
+// always renders hex addresses.
+func (t *Table) Format(address uint16) string {
+	if t != nil {
+		if label, offset, ok := t.Nearest(address); ok {
+			if offset == 0 {
+				return label
+			}
+
+			return fmt.Sprintf("%s+%d", label, offset)
+		}
+	}
+
+	return fmt.Sprintf("x%04X", address)
+}
+
+// Write writes the table in lc3as format.
+func (t *Table) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "// Symbol table\n")
+	fmt.Fprintf(bw, "// Scope level 0:\n")
+	fmt.Fprintf(bw, "//\tSymbol Name       Page Address\n")
+	fmt.Fprintf(bw, "//\t----------------  ------------\n")
+
+	for _, label := range t.Labels() {
+		fmt.Fprintf(bw, "//\t%-16s  %04X\n", label, t.addresses[label])
+	}
+
+	fmt.Fprintln(bw)
+
+	return bw.Flush()
+}
@@ -0,0 +1,13 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+package term
+
+import "syscall"
+
+const (
+	// ioctlGetTermios reads the terminal attributes.
+	ioctlGetTermios = syscall.TIOCGETA
+
+	// ioctlSetTermios writes the terminal attributes.
+	ioctlSetTermios = syscall.TIOCSETA
+)
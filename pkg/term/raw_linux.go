@@ -0,0 +1,11 @@
+package term
+
+import "syscall"
+
+const (
+	// ioctlGetTermios reads the terminal attributes.
+	ioctlGetTermios = syscall.TCGETS
+
+	// ioctlSetTermios writes the terminal attributes.
+	ioctlSetTermios = syscall.TCSETS
+)
@@ -0,0 +1,37 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || windows)
+
+package term
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// State is the saved state of a terminal.
+type State struct{}
+
+// IsTerminal reports whether fd refers to a terminal.
+func IsTerminal(fd int) bool {
+	return false
+}
+
+// makeRaw is not supported on this platform.
+func makeRaw(fd int) (*State, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// restore is not supported on this platform.
+func restore(fd int, state *State) error {
+	return errors.ErrUnsupported
+}
+
+// EnableVirtualTerminal is not needed on this platform.
+func EnableVirtualTerminal(fd int) error {
+	return nil
+}
+
+// NewReader returns f, read as it is.
+func NewReader(f *os.File) io.Reader {
+	return f
+}
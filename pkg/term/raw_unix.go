@@ -0,0 +1,70 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package term
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// State is the saved state of a terminal.
+type State struct {
+	termios syscall.Termios
+}
+
+// IsTerminal reports whether fd refers to a terminal.
+func IsTerminal(fd int) bool {
+	var termios syscall.Termios
+	return ioctl(fd, ioctlGetTermios, &termios) == nil
+}
+
+// makeRaw disables line buffering and echo on the terminal so that
+// keystrokes are delivered as soon as they are typed. Signals such
+// as Ctrl+C are still generated. It returns the previous state of
+// the terminal so that it can be restored.
+func makeRaw(fd int) (*State, error) {
+	var state State
+
+	if err := ioctl(fd, ioctlGetTermios, &state.termios); err != nil {
+		return nil, err
+	}
+
+	raw := state.termios
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	if err := ioctl(fd, ioctlSetTermios, &raw); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// restore restores the terminal to a previously saved state.
+func restore(fd int, state *State) error {
+	return ioctl(fd, ioctlSetTermios, &state.termios)
+}
+
+// EnableVirtualTerminal does nothing, terminals handle ANSI escape
+// sequences already.
+func EnableVirtualTerminal(fd int) error {
+	return nil
+}
+
+// NewReader returns f, terminals read Enter as a newline already.
+func NewReader(f *os.File) io.Reader {
+	return f
+}
+
+// ioctl performs a terminal ioctl.
+func ioctl(fd int, req uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
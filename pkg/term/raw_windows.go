@@ -0,0 +1,144 @@
+//go:build windows
+
+package term
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// The console modes, as in the Win32 console API.
+const (
+	// enableProcessedInput handles Ctrl+C as a signal.
+	enableProcessedInput = 0x0001
+
+	// enableLineInput delivers input a line at a time.
+	enableLineInput = 0x0002
+
+	// enableEchoInput echoes the keys typed.
+	enableEchoInput = 0x0004
+
+	// enableVirtualTerminalInput delivers keys such as the arrows as
+	// ANSI escape sequences.
+	enableVirtualTerminalInput = 0x0200
+
+	// enableProcessedOutput handles control characters in the output.
+	enableProcessedOutput = 0x0001
+
+	// enableVirtualTerminalProcessing handles ANSI escape sequences in
+	// the output.
+	enableVirtualTerminalProcessing = 0x0004
+)
+
+// setConsoleMode is SetConsoleMode from kernel32.dll, which the syscall
+// package lacks.
+var setConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// State is the saved state of a terminal.
+type State struct {
+	mode uint32
+}
+
+// IsTerminal reports whether fd refers to a console.
+func IsTerminal(fd int) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(fd), &mode) == nil
+}
+
+// makeRaw disables line input and echo on the console so that
+// keystrokes are delivered as soon as they are typed, with keys such as
+// the arrows delivered as ANSI escape sequences. Ctrl+C still interrupts
+// the program. It returns the previous state of the console so that it
+// can be restored.
+func makeRaw(fd int) (*State, error) {
+	var state State
+
+	if err := syscall.GetConsoleMode(syscall.Handle(fd), &state.mode); err != nil {
+		return nil, err
+	}
+
+	raw := state.mode&^(enableLineInput|enableEchoInput) | enableProcessedInput | enableVirtualTerminalInput
+
+	if err := setMode(fd, raw); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// restore restores the console to a previously saved state.
+func restore(fd int, state *State) error {
+	return setMode(fd, state.mode)
+}
+
+// EnableVirtualTerminal makes the console behind fd handle the ANSI
+// escape sequences written to it, for the cursor movement, clearing and
+// color of this package.
+func EnableVirtualTerminal(fd int) error {
+	var mode uint32
+
+	if err := syscall.GetConsoleMode(syscall.Handle(fd), &mode); err != nil {
+		return err
+	}
+
+	return setMode(fd, mode|enableProcessedOutput|enableVirtualTerminalProcessing)
+}
+
+// setMode sets the mode of a console.
+func setMode(fd int, mode uint32) error {
+	if ok, _, err := setConsoleMode.Call(uintptr(fd), uintptr(mode)); ok == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// NewReader returns a reader of the keys typed at a console, with
+// Enter read as a newline as on other systems rather than as a carriage
+// return, followed by a line feed outside of raw mode. Files that are
+// not consoles are read as they are.
+func NewReader(f *os.File) io.Reader {
+	if !IsTerminal(int(f.Fd())) {
+		return f
+	}
+
+	return &consoleReader{f: f}
+}
+
+// consoleReader translates the line endings of a console.
+type consoleReader struct {
+	// f is the console.
+	f *os.File
+
+	// cr is set when the last key read was a carriage return.
+	cr bool
+}
+
+// Read reads keys, reading a carriage return as a newline and dropping
+// the line feed following it.
+func (r *consoleReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.f.Read(p)
+
+		kept := 0
+
+		for _, b := range p[:n] {
+			switch {
+			case b == '\r':
+				p[kept] = '\n'
+				kept++
+			case b == '\n' && r.cr:
+			default:
+				p[kept] = b
+				kept++
+			}
+
+			r.cr = b == '\r'
+		}
+
+		if kept > 0 || err != nil || n == 0 {
+			return kept, err
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package term
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+var (
+	// rawMu guards raw.
+	rawMu sync.Mutex
+
+	// raw holds the terminals in raw mode by file descriptor, with the
+	// state they were in before, so that they can be restored however
+	// the process ends.
+	raw = map[int]*State{}
+
+	// interrupt is called in place of ending the process on the next
+	// Ctrl+C, if set.
+	interrupt atomic.Pointer[func()]
+)
+
+// MakeRaw puts the terminal into raw mode, see makeRaw, and returns its
+// previous state so that it can be restored. The terminal is restored
+// to the state it was in before the first of nested calls by
+// RestoreAll, on a signal once Guard is set up or on a panic in main.
+func MakeRaw(fd int) (*State, error) {
+	state, err := makeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	rawMu.Lock()
+	defer rawMu.Unlock()
+
+	if _, ok := raw[fd]; !ok {
+		raw[fd] = state
+	}
+
+	return state, nil
+}
+
+// Restore restores the terminal to a state MakeRaw returned.
+func Restore(fd int, state *State) error {
+	rawMu.Lock()
+	if raw[fd] == state {
+		delete(raw, fd)
+	}
+	rawMu.Unlock()
+
+	return restore(fd, state)
+}
+
+// RestoreAll restores every terminal still in raw mode to the state it
+// was in before.
+func RestoreAll() {
+	rawMu.Lock()
+	defer rawMu.Unlock()
+
+	for fd, state := range raw {
+		restore(fd, state)
+		delete(raw, fd)
+	}
+}
+
+// OnInterrupt makes the next Ctrl+C call f in place of ending the
+// process, once Guard is set up, and returns a function undoing it. A
+// Ctrl+C typed after f was called, before OnInterrupt is called again,
+// ends the process, so that a program that does not notice it can still
+// be quit.
+func OnInterrupt(f func()) func() {
+	interrupt.Store(&f)
+
+	return func() {
+		interrupt.CompareAndSwap(&f, nil)
+	}
+}
+
+// Guard restores the terminals in raw mode when the process is
+// interrupted or terminated, before it exits with the status of the
+// signal, unless OnInterrupt took over the interrupt. It returns a
+// function for main to defer that restores them when it returns or
+// panics, so that no way out leaves a terminal in raw mode. Panics in
+// other goroutines and os.Exit bypass it, and so callers restore the
+// terminal before exiting.
+func Guard() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+
+		for sig == os.Interrupt {
+			f := interrupt.Swap(nil)
+			if f == nil {
+				break
+			}
+
+			(*f)()
+			sig = <-signals
+		}
+
+		RestoreAll()
+
+		// exit as shells report a process killed by the signal.
+		if sig == syscall.SIGTERM {
+			os.Exit(128 + 15)
+		}
+
+		os.Exit(128 + 2)
+	}()
+
+	return func() {
+		r := recover()
+		RestoreAll()
+
+		if r != nil {
+			panic(r)
+		}
+	}
+}
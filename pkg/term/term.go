@@ -0,0 +1,99 @@
+// Package term contains helpers for driving the host terminal,
+// putting it into raw mode and emitting ANSI escape sequences for
+// cursor movement, clearing and color. Terminals are driven through
+// termios on Unix systems and the console API on Windows.
+package term
+
+import (
+	"fmt"
+	"io"
+)
+
+// Color is an ANSI terminal color. Colors 0-7 are the normal colors,
+// 8-15 their bright variants.
+type Color uint8
+
+const (
+	// Black is the black color.
+	Black Color = iota
+
+	// Red is the red color.
+	Red
+
+	// Green is the green color.
+	Green
+
+	// Yellow is the yellow color.
+	Yellow
+
+	// Blue is the blue color.
+	Blue
+
+	// Magenta is the magenta color.
+	Magenta
+
+	// Cyan is the cyan color.
+	Cyan
+
+	// White is the white color.
+	White
+
+	// Default selects the default color of the terminal.
+	Default Color = 0xFF
+)
+
+// Clear clears the screen and moves the cursor to the top left.
+func Clear(w io.Writer) error {
+	_, err := io.WriteString(w, "\x1b[2J\x1b[H")
+	return err
+}
+
+// ClearLine clears the line the cursor is on.
+func ClearLine(w io.Writer) error {
+	_, err := io.WriteString(w, "\x1b[2K")
+	return err
+}
+
+// MoveTo moves the cursor to the zero based row and column.
+func MoveTo(w io.Writer, row, col int) error {
+	_, err := fmt.Fprintf(w, "\x1b[%d;%dH", row+1, col+1)
+	return err
+}
+
+// SetColor sets the foreground and background colors of subsequent
+// output.
+func SetColor(w io.Writer, fg, bg Color) error {
+	_, err := fmt.Fprintf(w, "\x1b[%d;%dm", colorCode(fg, 30), colorCode(bg, 40))
+	return err
+}
+
+// colorCode returns the SGR parameter selecting c, base is 30 for
+// foreground colors and 40 for background colors.
+func colorCode(c Color, base int) int {
+	switch {
+	case c == Default:
+		return base + 9
+	case c >= 8:
+		return base + 60 + int(c&7)
+	}
+
+	return base + int(c)
+}
+
+// Reset resets all output attributes.
+func Reset(w io.Writer) error {
+	_, err := io.WriteString(w, "\x1b[0m")
+	return err
+}
+
+// HideCursor hides the cursor.
+func HideCursor(w io.Writer) error {
+	_, err := io.WriteString(w, "\x1b[?25l")
+	return err
+}
+
+// ShowCursor shows the cursor.
+func ShowCursor(w io.Writer) error {
+	_, err := io.WriteString(w, "\x1b[?25h")
+	return err
+}
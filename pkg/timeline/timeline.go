@@ -0,0 +1,132 @@
+// Package timeline writes the subroutine calls of a running program as
+// Chrome trace events, one nested duration event per call, so that a
+// run can be explored in chrome://tracing or Perfetto. Time is counted
+// in instructions, one microsecond each.
+package timeline
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"lc3/pkg/callstack"
+	"lc3/pkg/cpu"
+	"lc3/pkg/registers"
+)
+
+// Writer streams the calls of the programs run by CPUs as Chrome trace
+// events in the JSON object format.
+type Writer struct {
+	// w buffers the events.
+	w *bufio.Writer
+
+	// name names the entry of a subroutine.
+	name func(address uint16) string
+
+	// step counts the instructions run.
+	step uint64
+
+	// open counts the events begun and not yet ended.
+	open int
+
+	// events counts the events written.
+	events int
+
+	// err is the first error writing the events, which stops them.
+	err error
+}
+
+// NewWriter creates a writer of events to w, naming subroutines by
+// name.
+func NewWriter(w io.Writer, name func(address uint16) string) *Writer {
+	t := &Writer{w: bufio.NewWriter(w), name: name}
+
+	fmt.Fprintf(t.w, "{\"displayTimeUnit\": \"ns\", \"traceEvents\": [\n")
+
+	return t
+}
+
+// Record writes the calls made by the program a CPU runs. The program
+// itself is the outermost event, named after the first instruction it
+// runs, and the events of a CPU recorded before are ended.
+func (t *Writer) Record(c cpu.CPU) {
+	stack := callstack.New()
+	started := false
+
+	c.OnInstruction(func(pc, instr uint16) {
+		if !started {
+			t.endAll()
+			t.event("B", pc, "program")
+			started = true
+		}
+
+		t.step++
+
+		depth := stack.Depth()
+		stack.Observe(pc, instr, c.Register(registers.RPC))
+
+		for d := stack.Depth(); d < depth; d++ {
+			t.event("E", 0, "")
+		}
+
+		if stack.Depth() > depth {
+			frame := stack.Frame(depth)
+
+			category := "call"
+			if frame.Trap {
+				category = "trap"
+			}
+
+			t.event("B", frame.Target, category)
+		}
+	})
+}
+
+// event writes an event at the current step, beginning the subroutine
+// at entry for B and ending the innermost one for E.
+func (t *Writer) event(phase string, entry uint16, category string) {
+	if t.err != nil {
+		return
+	}
+
+	if t.events > 0 {
+		t.w.WriteString(",\n")
+	}
+
+	t.events++
+
+	if phase == "E" {
+		t.open--
+		_, t.err = fmt.Fprintf(t.w, "{\"ph\": \"E\", \"ts\": %d, \"pid\": 1, \"tid\": 1}", t.step)
+
+		return
+	}
+
+	t.open++
+
+	name, _ := json.Marshal(t.name(entry))
+
+	_, t.err = fmt.Fprintf(t.w, "{\"name\": %s, \"cat\": %q, \"ph\": \"B\", \"ts\": %d, \"pid\": 1, \"tid\": 1, \"args\": {\"entry\": \"x%04X\"}}",
+		name, category, t.step, entry)
+}
+
+// endAll ends every open event.
+func (t *Writer) endAll() {
+	for t.open > 0 {
+		t.event("E", 0, "")
+	}
+}
+
+// Close ends the open events and the JSON, and writes out the buffered
+// events, returning the first error writing them.
+func (t *Writer) Close() error {
+	t.endAll()
+
+	if t.err != nil {
+		return t.err
+	}
+
+	fmt.Fprintf(t.w, "\n]}\n")
+
+	return t.w.Flush()
+}
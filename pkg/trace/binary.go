@@ -0,0 +1,283 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+)
+
+// binaryMagic starts a binary trace, followed by its version.
+const binaryMagic = "LC3T"
+
+// binaryVersion is the version of the binary encoding.
+const binaryVersion = 1
+
+// Each entry of a binary trace holds only what changed since the
+// previous one, all numbers big-endian:
+//
+//	byte     registers changed, bit r set for Rr
+//	byte     condition flags in bits 0-2, bit 3 set if the PC follows,
+//	         bit 4 set if memory accesses follow, bit 5 set if steps
+//	         were left out by a filter
+//	[uvarint number of steps left out before this one]
+//	[word]   PC, unless it is the one after the previous PC
+//	word     instruction
+//	word...  each changed register, R0 first
+//	[uvarint count, then per access a byte set for writes, the
+//	 address, the value and for writes the old value]
+//
+// so that most instructions take six bytes.
+const (
+	// binaryPC is set when the PC is written.
+	binaryPC = 1 << 3
+
+	// binaryMemory is set when memory accesses are written.
+	binaryMemory = 1 << 4
+
+	// binarySkip is set when steps were left out.
+	binarySkip = 1 << 5
+
+	// binaryCond masks the condition flags.
+	binaryCond = 0x7
+)
+
+// binaryEncoder writes entries in the binary encoding, remembering the
+// previous entry to write only the changes.
+type binaryEncoder struct {
+	// prev is the previous entry.
+	prev Entry
+
+	// started is set once an entry has been written.
+	started bool
+
+	// buf holds an entry while it is encoded.
+	buf []byte
+}
+
+// newBinaryEncoder creates a binary encoder, writing the header of the
+// trace.
+func newBinaryEncoder(w io.Writer) encoder {
+	w.Write([]byte{binaryMagic[0], binaryMagic[1], binaryMagic[2], binaryMagic[3], binaryVersion})
+
+	return &binaryEncoder{}
+}
+
+// encode writes the changes of an entry.
+func (b *binaryEncoder) encode(w io.Writer, e *Entry) error {
+	var changed byte
+	for r, value := range e.Registers {
+		if value != b.prev.Registers[r] {
+			changed |= 1 << r
+		}
+	}
+
+	flags := byte(e.Cond & binaryCond)
+
+	sequential := b.started && e.PC == b.prev.PC+1
+	if !sequential {
+		flags |= binaryPC
+	}
+
+	if len(e.Accesses) > 0 {
+		flags |= binaryMemory
+	}
+
+	step := uint64(0)
+	if b.started {
+		step = b.prev.Step + 1
+	}
+
+	if e.Step != step {
+		flags |= binarySkip
+	}
+
+	buf := append(b.buf[:0], changed, flags)
+
+	if e.Step != step {
+		buf = binary.AppendUvarint(buf, e.Step-step)
+	}
+
+	if !sequential {
+		buf = binary.BigEndian.AppendUint16(buf, e.PC)
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, e.Instr)
+
+	for r, value := range e.Registers {
+		if changed&(1<<r) != 0 {
+			buf = binary.BigEndian.AppendUint16(buf, value)
+		}
+	}
+
+	if len(e.Accesses) > 0 {
+		buf = binary.AppendUvarint(buf, uint64(len(e.Accesses)))
+
+		for _, access := range e.Accesses {
+			write := byte(0)
+			if access.Write {
+				write = 1
+			}
+
+			buf = append(buf, write)
+			buf = binary.BigEndian.AppendUint16(buf, access.Address)
+			buf = binary.BigEndian.AppendUint16(buf, access.Value)
+
+			if access.Write {
+				buf = binary.BigEndian.AppendUint16(buf, access.Old)
+			}
+		}
+	}
+
+	b.buf, b.prev, b.started = buf, *e, true
+	b.prev.Accesses = nil
+
+	_, err := w.Write(buf)
+
+	return err
+}
+
+// binaryDecoder reads the entries of a binary trace.
+type binaryDecoder struct {
+	// r buffers the trace.
+	r *bufio.Reader
+
+	// prev is the previous entry, which the next one changes.
+	prev Entry
+
+	// started is set once an entry has been read.
+	started bool
+}
+
+// newBinaryDecoder creates a decoder of a binary trace, checking its
+// header.
+func newBinaryDecoder(r *bufio.Reader) (decoder, error) {
+	header := make([]byte, len(binaryMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(binaryMagic)]) != binaryMagic {
+		return nil, fmt.Errorf("not a binary trace")
+	}
+
+	if header[len(binaryMagic)] != binaryVersion {
+		return nil, fmt.Errorf("unsupported binary trace version %d", header[len(binaryMagic)])
+	}
+
+	return &binaryDecoder{r: r}, nil
+}
+
+// decode reads the next entry.
+func (t *binaryDecoder) decode() (*Entry, error) {
+	changed, err := t.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	flags, err := t.r.ReadByte()
+	if err != nil {
+		return nil, truncated(err)
+	}
+
+	e := t.prev
+	e.Accesses = nil
+	e.Cond = uint16(flags & binaryCond)
+
+	if t.started {
+		e.Step++
+		e.PC++
+	}
+
+	if flags&binarySkip != 0 {
+		skipped, err := binary.ReadUvarint(t.r)
+		if err != nil {
+			return nil, truncated(err)
+		}
+
+		e.Step += skipped
+	}
+
+	if flags&binaryPC != 0 {
+		if e.PC, err = t.word(); err != nil {
+			return nil, err
+		}
+	} else if !t.started {
+		return nil, fmt.Errorf("the first entry of the trace has no PC")
+	}
+
+	if e.Instr, err = t.word(); err != nil {
+		return nil, err
+	}
+
+	for r := range e.Registers {
+		if changed&(1<<r) != 0 {
+			if e.Registers[r], err = t.word(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if flags&binaryMemory != 0 {
+		if e.Accesses, err = t.accesses(e.PC); err != nil {
+			return nil, err
+		}
+	}
+
+	t.prev, t.started = e, true
+
+	return &e, nil
+}
+
+// accesses reads the memory accesses of the instruction at pc.
+func (t *binaryDecoder) accesses(pc uint16) ([]cpu.MemoryAccess, error) {
+	n, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		return nil, truncated(err)
+	}
+
+	accesses := make([]cpu.MemoryAccess, n)
+
+	for i := range accesses {
+		write, err := t.r.ReadByte()
+		if err != nil {
+			return nil, truncated(err)
+		}
+
+		accesses[i].PC, accesses[i].Write = pc, write != 0
+
+		if accesses[i].Address, err = t.word(); err != nil {
+			return nil, err
+		}
+
+		if accesses[i].Value, err = t.word(); err != nil {
+			return nil, err
+		}
+
+		if accesses[i].Write {
+			if accesses[i].Old, err = t.word(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return accesses, nil
+}
+
+// word reads a big-endian word.
+func (t *binaryDecoder) word() (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(t.r, b[:]); err != nil {
+		return 0, truncated(err)
+	}
+
+	return binary.BigEndian.Uint16(b[:]), nil
+}
+
+// truncated reports the end of the trace in the middle of an entry as
+// an error rather than its end.
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("truncated trace")
+	}
+
+	return err
+}
@@ -0,0 +1,98 @@
+package trace
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/symbols"
+	"strconv"
+	"strings"
+)
+
+// CellWriter writes the values of some memory cells after every
+// instruction as CSV, one row per step, for plotting how the variables
+// of a program evolve.
+type CellWriter struct {
+	// w writes the rows.
+	w *csv.Writer
+
+	// addresses are the addresses of the cells.
+	addresses []uint16
+
+	// step counts the instructions run.
+	step uint64
+
+	// row holds a row while it is written.
+	row []string
+}
+
+// ParseCells parses a comma-separated list of cells given by hex
+// address or by label, as x4000,COUNT, returning their addresses and
+// names.
+func ParseCells(list string, table *symbols.Table) ([]uint16, []string, error) {
+	var (
+		addresses []uint16
+		names     []string
+	)
+
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		address, err := parseAddress(item)
+		if err != nil {
+			var ok bool
+			if address, ok = table.Address(item); !ok {
+				return nil, nil, fmt.Errorf("%s is neither an address nor a label", item)
+			}
+		}
+
+		addresses = append(addresses, address)
+		names = append(names, item)
+	}
+
+	if len(addresses) == 0 {
+		return nil, nil, fmt.Errorf("no cells to log")
+	}
+
+	return addresses, names, nil
+}
+
+// NewCellWriter creates a writer of the cells at addresses to w, with a
+// header naming the step, the PC and the cells by names. Values are
+// written in signed decimal:
+//
+//	step,pc,COUNT,x4001
+//	0,x3000,0,0
+func NewCellWriter(w io.Writer, addresses []uint16, names []string) *CellWriter {
+	t := &CellWriter{w: csv.NewWriter(w), addresses: addresses}
+
+	t.w.Write(append([]string{"step", "pc"}, names...))
+
+	return t
+}
+
+// Record writes the cells after every instruction a CPU runs. Reading
+// them does not touch devices.
+func (t *CellWriter) Record(c cpu.CPU) {
+	c.OnInstruction(func(pc, _ uint16) {
+		t.row = append(t.row[:0], strconv.FormatUint(t.step, 10), fmt.Sprintf("x%04X", pc))
+
+		for _, address := range t.addresses {
+			t.row = append(t.row, strconv.Itoa(int(int16(c.PeekMemory(address)))))
+		}
+
+		t.w.Write(t.row)
+		t.step++
+	})
+}
+
+// Flush writes out the buffered rows, returning the first error writing
+// them.
+func (t *CellWriter) Flush() error {
+	t.w.Flush()
+	return t.w.Error()
+}
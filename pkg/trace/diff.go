@@ -0,0 +1,163 @@
+package trace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Divergence is the first step at which two traces differ.
+type Divergence struct {
+	// Step is the step at which they differ.
+	Step uint64
+
+	// A and B are the entries of each trace at the step, nil for a
+	// trace that ended before it.
+	A, B *Entry
+
+	// Last is the last entry both traces agree on, nil if they differ
+	// from the first step.
+	Last *Entry
+
+	// memory is set when both traces hold memory accesses, which are
+	// then compared too.
+	memory bool
+}
+
+// Diff reads two traces in step and returns where they first differ in
+// the address, word, registers or condition codes of an instruction,
+// or in the memory it wrote when both traces hold memory accesses. It
+// returns nil and the number of steps if the traces are the same.
+func Diff(a, b *Reader) (*Divergence, uint64, error) {
+	memory := a.Format() != FormatText && b.Format() != FormatText
+
+	var (
+		last  *Entry
+		steps uint64
+	)
+
+	for {
+		ea, err := next(a)
+		if err != nil {
+			return nil, steps, err
+		}
+
+		eb, err := next(b)
+		if err != nil {
+			return nil, steps, err
+		}
+
+		if ea == nil && eb == nil {
+			return nil, steps, nil
+		}
+
+		d := &Divergence{Step: steps, A: ea, B: eb, Last: last, memory: memory}
+		if ea == nil || eb == nil || len(d.Differences()) > 0 {
+			return d, steps, nil
+		}
+
+		last = ea
+		steps++
+	}
+}
+
+// next returns the next entry of a trace, or nil at its end.
+func next(r *Reader) (*Entry, error) {
+	e, err := r.Next()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+
+	return e, err
+}
+
+// Differences names what differs between the entries: PC, instruction,
+// R0 to R7, CC or memory.
+func (d *Divergence) Differences() []string {
+	if d.A == nil || d.B == nil {
+		return []string{"length"}
+	}
+
+	var diffs []string
+
+	if d.A.PC != d.B.PC {
+		diffs = append(diffs, "PC")
+	}
+
+	if d.A.Instr != d.B.Instr {
+		diffs = append(diffs, "instruction")
+	}
+
+	for r := range d.A.Registers {
+		if d.A.Registers[r] != d.B.Registers[r] {
+			diffs = append(diffs, fmt.Sprintf("R%d", r))
+		}
+	}
+
+	if d.A.Cond != d.B.Cond {
+		diffs = append(diffs, "CC")
+	}
+
+	if d.memory && !sameWrites(d.A, d.B) {
+		diffs = append(diffs, "memory")
+	}
+
+	return diffs
+}
+
+// sameWrites reports whether two entries wrote the same values to the
+// same addresses.
+func sameWrites(a, b *Entry) bool {
+	var wa, wb []string
+
+	for _, access := range a.Accesses {
+		if access.Write {
+			wa = append(wa, fmt.Sprintf("%04X=%04X", access.Address, access.Value))
+		}
+	}
+
+	for _, access := range b.Accesses {
+		if access.Write {
+			wb = append(wb, fmt.Sprintf("%04X=%04X", access.Address, access.Value))
+		}
+	}
+
+	return fmt.Sprint(wa) == fmt.Sprint(wb)
+}
+
+// Write describes the divergence: the last step both traces agree on
+// and the entry of each trace at the step they differ.
+func (d *Divergence) Write(w io.Writer, nameA, nameB string) error {
+	bw := bufio.NewWriter(w)
+	w = bw
+
+	if d.Last != nil {
+		fmt.Fprintf(w, "same up to step %d:\n  %s\n", d.Last.Step, d.Last)
+	}
+
+	fmt.Fprintf(w, "step %d differs in %s:\n", d.Step, strings.Join(d.Differences(), ", "))
+
+	for _, side := range []struct {
+		name  string
+		entry *Entry
+	}{{nameA, d.A}, {nameB, d.B}} {
+		if side.entry == nil {
+			fmt.Fprintf(w, "  %s: ended\n", side.name)
+			continue
+		}
+
+		fmt.Fprintf(w, "  %s: %s\n", side.name, side.entry)
+
+		if d.memory {
+			for _, access := range side.entry.Accesses {
+				if access.Write {
+					fmt.Fprintf(w, "  %s: wrote %04X to x%04X\n", side.name, access.Value, access.Address)
+				}
+			}
+		}
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,143 @@
+package trace
+
+import (
+	"fmt"
+	"lc3/pkg/disasm"
+	"lc3/pkg/opcodes"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Filter selects the instructions to trace: those in one of its
+// address ranges, or whose mnemonic is one of its names. An empty
+// filter selects every instruction.
+type Filter struct {
+	// Ranges are the address ranges traced, both ends included.
+	Ranges [][2]uint16
+
+	// Names are the mnemonics traced in upper case, as ADD, BRZ, RET
+	// or PUTS, with BR and TRAP standing for every branch and trap.
+	Names map[string]bool
+}
+
+// ParseFilter parses a filter written as a comma-separated list of
+// addresses, address ranges and mnemonics, as in x3000-x31FF,TRAP.
+func ParseFilter(s string) (*Filter, error) {
+	f := &Filter{Names: map[string]bool{}}
+	known := mnemonics()
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if first, last, ok := strings.Cut(item, "-"); ok {
+			start, err := parseAddress(first)
+			if err != nil {
+				return nil, err
+			}
+
+			end, err := parseAddress(last)
+			if err != nil {
+				return nil, err
+			}
+
+			if end < start {
+				return nil, fmt.Errorf("range %s ends before it starts", item)
+			}
+
+			f.Ranges = append(f.Ranges, [2]uint16{start, end})
+
+			continue
+		}
+
+		if address, err := parseAddress(item); err == nil {
+			f.Ranges = append(f.Ranges, [2]uint16{address, address})
+			continue
+		}
+
+		name := strings.ToUpper(item)
+		if !known[name] {
+			return nil, fmt.Errorf("%s is neither an address nor a mnemonic", item)
+		}
+
+		f.Names[name] = true
+	}
+
+	return f, nil
+}
+
+// parseAddress parses an address written in hex as x3000.
+func parseAddress(s string) (uint16, error) {
+	if !strings.HasPrefix(s, "x") && !strings.HasPrefix(s, "X") {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+
+	n, err := strconv.ParseUint(s[1:], 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+
+	return uint16(n), nil
+}
+
+// names returns the upper-case names an instruction matches: its
+// mnemonic, the name of its opcode, and BR or TRAP for branches and
+// traps.
+func names(instr uint16) []string {
+	i := disasm.Decode(instr)
+	mnemonic, _, _ := strings.Cut(disasm.Format(0, instr), " ")
+
+	names := []string{strings.ToUpper(mnemonic), i.Name}
+
+	switch i.Opcode {
+	case opcodes.OPBR:
+		names = append(names, "BR")
+	case opcodes.OPTRAP:
+		names = append(names, "TRAP")
+	}
+
+	return names
+}
+
+// mnemonics returns the names instructions match.
+func mnemonics() map[string]bool {
+	known := map[string]bool{}
+
+	for word := 0; word <= math.MaxUint16; word++ {
+		if !disasm.Decode(uint16(word)).Valid {
+			continue
+		}
+
+		for _, name := range names(uint16(word)) {
+			known[name] = true
+		}
+	}
+
+	return known
+}
+
+// Match reports whether the filter selects an entry.
+func (f *Filter) Match(e *Entry) bool {
+	if len(f.Ranges) == 0 && len(f.Names) == 0 {
+		return true
+	}
+
+	for _, r := range f.Ranges {
+		if e.PC >= r[0] && e.PC <= r[1] {
+			return true
+		}
+	}
+
+	if len(f.Names) > 0 {
+		for _, name := range names(e.Instr) {
+			if f.Names[name] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,142 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"lc3/pkg/disasm"
+	"sort"
+	"strings"
+)
+
+// Format is the encoding of a trace.
+type Format int
+
+const (
+	// FormatText writes one line of text per instruction.
+	FormatText Format = iota
+
+	// FormatJSON writes one JSON object per line and instruction, as
+	// JSON Lines.
+	FormatJSON
+
+	// FormatBinary writes the changes each instruction makes in a few
+	// bytes, for long runs. See binary.go.
+	FormatBinary
+
+	// FormatProto writes the Trace message of proto/lc3.proto, for
+	// tools in other languages. See proto.go.
+	FormatProto
+)
+
+// formats names the formats of traces.
+var formats = map[Format]string{
+	FormatText:   "text",
+	FormatJSON:   "jsonl",
+	FormatBinary: "binary",
+	FormatProto:  "proto",
+}
+
+// encoder writes the entries of a trace.
+type encoder interface {
+	// encode writes an entry.
+	encode(w io.Writer, e *Entry) error
+}
+
+// encoders create the encoder of each format, writing its header if it
+// has one.
+var encoders = map[Format]func(w io.Writer) encoder{
+	FormatText:   func(io.Writer) encoder { return textEncoder{} },
+	FormatJSON:   func(io.Writer) encoder { return jsonEncoder{} },
+	FormatBinary: newBinaryEncoder,
+	FormatProto:  func(io.Writer) encoder { return &protoEncoder{} },
+}
+
+// String returns the name of the format.
+func (f Format) String() string {
+	return formats[f]
+}
+
+// LookupFormat returns a format by name, text, jsonl, binary or proto.
+func LookupFormat(name string) (Format, bool) {
+	for f, n := range formats {
+		if strings.EqualFold(n, name) {
+			return f, true
+		}
+	}
+
+	return 0, false
+}
+
+// FormatNames returns the names of the formats, sorted.
+func FormatNames() []string {
+	names := make([]string, 0, len(formats))
+	for _, name := range formats {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// textEncoder writes entries as lines of text.
+type textEncoder struct{}
+
+// encode writes an entry as a line of text.
+func (textEncoder) encode(w io.Writer, e *Entry) error {
+	_, err := fmt.Fprintln(w, e.String())
+	return err
+}
+
+// jsonAccess is a memory access in JSON.
+type jsonAccess struct {
+	Address uint16  `json:"addr"`
+	Value   uint16  `json:"value"`
+	Old     *uint16 `json:"old,omitempty"`
+	Write   bool    `json:"write"`
+}
+
+// jsonEntry is an entry in JSON, with numbers rather than hex strings
+// so that jq and pandas can compute with them.
+type jsonEntry struct {
+	Step      uint64       `json:"step"`
+	PC        uint16       `json:"pc"`
+	Instr     uint16       `json:"instr"`
+	Op        string       `json:"op"`
+	Text      string       `json:"text"`
+	Registers [8]uint16    `json:"regs"`
+	Cond      string       `json:"cc"`
+	Memory    []jsonAccess `json:"mem"`
+}
+
+// jsonEncoder writes entries as JSON Lines.
+type jsonEncoder struct{}
+
+// encode writes an entry as a JSON object on a line of its own:
+//
+//	{"step":0,"pc":12288,"instr":57374,"op":"LEA","text":"LEA R0, x301F",
+//	 "regs":[12319,0,0,0,0,0,0,0],"cc":"p","mem":[]}
+func (jsonEncoder) encode(w io.Writer, e *Entry) error {
+	out := jsonEntry{
+		Step:      e.Step,
+		PC:        e.PC,
+		Instr:     e.Instr,
+		Op:        disasm.Decode(e.Instr).Name,
+		Text:      disasm.Format(e.PC, e.Instr),
+		Registers: e.Registers,
+		Cond:      e.Condition(),
+		Memory:    make([]jsonAccess, len(e.Accesses)),
+	}
+
+	for i, access := range e.Accesses {
+		out.Memory[i] = jsonAccess{Address: access.Address, Value: access.Value, Write: access.Write}
+
+		if access.Write {
+			old := access.Old
+			out.Memory[i].Old = &old
+		}
+	}
+
+	return json.NewEncoder(w).Encode(out)
+}
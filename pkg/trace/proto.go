@@ -0,0 +1,178 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/protowire"
+)
+
+// A protobuf trace is the Trace message of proto/lc3.proto, written
+// one TraceEntry at a time as field 1, so it starts with the byte x0A.
+const protoEntries = 1
+
+// The fields of the TraceEntry and MemoryAccess messages.
+const (
+	entryStep        = 1
+	entryPC          = 2
+	entryInstruction = 3
+	entryRegisters   = 4
+	entryCond        = 5
+	entryAccesses    = 6
+
+	accessAddress = 1
+	accessValue   = 2
+	accessOld     = 3
+	accessWrite   = 4
+)
+
+// protoEncoder writes entries as protobuf messages.
+type protoEncoder struct {
+	// buf holds an entry while it is encoded.
+	buf []byte
+}
+
+// encode writes an entry as a TraceEntry field of a Trace.
+func (p *protoEncoder) encode(w io.Writer, e *Entry) error {
+	p.buf = e.AppendProto(p.buf[:0])
+
+	_, err := w.Write(protowire.AppendBytes(nil, protoEntries, p.buf))
+
+	return err
+}
+
+// AppendProto appends the entry encoded as a TraceEntry message of
+// proto/lc3.proto.
+func (e *Entry) AppendProto(b []byte) []byte {
+	b = protowire.AppendVarint(b, entryStep, e.Step)
+	b = protowire.AppendVarint(b, entryPC, uint64(e.PC))
+	b = protowire.AppendVarint(b, entryInstruction, uint64(e.Instr))
+	b = protowire.AppendPacked(b, entryRegisters, e.Registers[:])
+	b = protowire.AppendVarint(b, entryCond, uint64(e.Cond))
+
+	for _, access := range e.Accesses {
+		var a []byte
+		a = protowire.AppendVarint(a, accessAddress, uint64(access.Address))
+		a = protowire.AppendVarint(a, accessValue, uint64(access.Value))
+		a = protowire.AppendVarint(a, accessOld, uint64(access.Old))
+		a = protowire.AppendBool(a, accessWrite, access.Write)
+
+		b = protowire.AppendBytes(b, entryAccesses, a)
+	}
+
+	return b
+}
+
+// protoDecoder reads the entries of a protobuf trace.
+type protoDecoder struct {
+	// r buffers the trace.
+	r *bufio.Reader
+}
+
+// decode reads the next TraceEntry field of the trace, skipping any
+// other field.
+func (t *protoDecoder) decode() (*Entry, error) {
+	for {
+		tag, err := binary.ReadUvarint(t.r)
+		if err != nil {
+			return nil, err
+		}
+
+		if tag&7 != protowire.BytesType {
+			return nil, fmt.Errorf("unsupported wire type %d in trace", tag&7)
+		}
+
+		length, err := binary.ReadUvarint(t.r)
+		if err != nil {
+			return nil, truncated(err)
+		}
+
+		b := make([]byte, length)
+		if _, err := io.ReadFull(t.r, b); err != nil {
+			return nil, truncated(err)
+		}
+
+		if tag>>3 == protoEntries {
+			return decodeProtoEntry(b)
+		}
+	}
+}
+
+// decodeProtoEntry decodes a TraceEntry message.
+func decodeProtoEntry(b []byte) (*Entry, error) {
+	e := &Entry{}
+	r := 0
+
+	for len(b) > 0 {
+		f, rest, err := protowire.Consume(b)
+		if err != nil {
+			return nil, err
+		}
+
+		b = rest
+
+		switch f.Number {
+		case entryStep:
+			e.Step = f.Varint
+		case entryPC:
+			e.PC = uint16(f.Varint)
+		case entryInstruction:
+			e.Instr = uint16(f.Varint)
+		case entryRegisters:
+			values, err := f.Packed()
+			if err != nil {
+				return nil, err
+			}
+
+			if r+len(values) > len(e.Registers) {
+				return nil, fmt.Errorf("step %d: too many registers", e.Step)
+			}
+
+			r += copy(e.Registers[r:], values)
+		case entryCond:
+			e.Cond = uint16(f.Varint)
+		case entryAccesses:
+			access, err := decodeProtoAccess(f.Bytes)
+			if err != nil {
+				return nil, err
+			}
+
+			e.Accesses = append(e.Accesses, access)
+		}
+	}
+
+	for i := range e.Accesses {
+		e.Accesses[i].PC = e.PC
+	}
+
+	return e, nil
+}
+
+// decodeProtoAccess decodes a MemoryAccess message.
+func decodeProtoAccess(b []byte) (cpu.MemoryAccess, error) {
+	var access cpu.MemoryAccess
+
+	for len(b) > 0 {
+		f, rest, err := protowire.Consume(b)
+		if err != nil {
+			return access, err
+		}
+
+		b = rest
+
+		switch f.Number {
+		case accessAddress:
+			access.Address = uint16(f.Varint)
+		case accessValue:
+			access.Value = uint16(f.Varint)
+		case accessOld:
+			access.Old = uint16(f.Varint)
+		case accessWrite:
+			access.Write = f.Varint != 0
+		}
+	}
+
+	return access, nil
+}
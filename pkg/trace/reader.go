@@ -0,0 +1,187 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"lc3/pkg/cflags"
+	"lc3/pkg/cpu"
+	"lc3/pkg/protowire"
+	"strconv"
+	"strings"
+)
+
+// decoder reads the entries of a trace.
+type decoder interface {
+	// decode reads the next entry, or returns io.EOF at the end of the
+	// trace.
+	decode() (*Entry, error)
+}
+
+// Reader reads the entries of a trace in any of its formats. Text
+// traces do not hold memory accesses.
+type Reader struct {
+	// format is the format of the trace.
+	format Format
+
+	// decoder decodes the entries.
+	decoder decoder
+}
+
+// NewReader creates a reader of a trace, telling its format from its
+// first bytes.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	head, _ := br.Peek(len(binaryMagic))
+
+	switch {
+	case string(head) == binaryMagic:
+		d, err := newBinaryDecoder(br)
+		return &Reader{format: FormatBinary, decoder: d}, err
+	case len(head) > 0 && head[0] == '{':
+		return &Reader{format: FormatJSON, decoder: &jsonDecoder{d: json.NewDecoder(br)}}, nil
+	case len(head) > 0 && head[0] == 'x':
+		return &Reader{format: FormatText, decoder: &textDecoder{s: bufio.NewScanner(br)}}, nil
+	case len(head) > 0 && head[0] == protoEntries<<3|protowire.BytesType:
+		return &Reader{format: FormatProto, decoder: &protoDecoder{r: br}}, nil
+	case len(head) == 0:
+		return nil, fmt.Errorf("empty trace")
+	}
+
+	return nil, fmt.Errorf("unknown trace format")
+}
+
+// Format returns the format of the trace.
+func (t *Reader) Format() Format {
+	return t.format
+}
+
+// Next returns the next entry, or io.EOF at the end of the trace.
+func (t *Reader) Next() (*Entry, error) {
+	return t.decoder.decode()
+}
+
+// parseCondition parses condition flags written as n, z or p.
+func parseCondition(s string) (uint16, error) {
+	var cond uint16
+
+	for _, c := range s {
+		switch c {
+		case 'n':
+			cond |= cflags.FLNEG
+		case 'z':
+			cond |= cflags.FLZRO
+		case 'p':
+			cond |= cflags.FLPOS
+		default:
+			return 0, fmt.Errorf("invalid condition codes %q", s)
+		}
+	}
+
+	return cond, nil
+}
+
+// textDecoder reads the entries of a text trace.
+type textDecoder struct {
+	// s splits the trace into lines.
+	s *bufio.Scanner
+
+	// step counts the entries read.
+	step uint64
+}
+
+// decode parses the next line of the trace.
+func (t *textDecoder) decode() (*Entry, error) {
+	if !t.s.Scan() {
+		if err := t.s.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, io.EOF
+	}
+
+	line := t.s.Text()
+	e := &Entry{Step: t.step}
+
+	// the disassembly between the word and the registers is left out.
+	i := strings.Index(line, " R0=")
+	fields := strings.Fields(line)
+
+	if i < 0 || len(fields) < 2 || !strings.HasPrefix(fields[0], "x") {
+		return nil, fmt.Errorf("step %d: invalid trace line %q", t.step, line)
+	}
+
+	pc, err := strconv.ParseUint(fields[0][1:], 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("step %d: invalid address %q", t.step, fields[0])
+	}
+
+	instr, err := strconv.ParseUint(fields[1], 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("step %d: invalid instruction %q", t.step, fields[1])
+	}
+
+	e.PC, e.Instr = uint16(pc), uint16(instr)
+
+	for _, field := range strings.Fields(line[i:]) {
+		name, value, _ := strings.Cut(field, "=")
+
+		if name == "CC" {
+			if e.Cond, err = parseCondition(value); err != nil {
+				return nil, fmt.Errorf("step %d: %v", t.step, err)
+			}
+
+			continue
+		}
+
+		r, err := strconv.Atoi(strings.TrimPrefix(name, "R"))
+		if err != nil || r < 0 || r >= len(e.Registers) {
+			return nil, fmt.Errorf("step %d: invalid register %q", t.step, field)
+		}
+
+		n, err := strconv.ParseUint(value, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: invalid register %q", t.step, field)
+		}
+
+		e.Registers[r] = uint16(n)
+	}
+
+	t.step++
+
+	return e, nil
+}
+
+// jsonDecoder reads the entries of a JSON Lines trace.
+type jsonDecoder struct {
+	// d decodes the objects of the trace.
+	d *json.Decoder
+}
+
+// decode parses the next object of the trace.
+func (t *jsonDecoder) decode() (*Entry, error) {
+	var in jsonEntry
+	if err := t.d.Decode(&in); err != nil {
+		return nil, err
+	}
+
+	cond, err := parseCondition(in.Cond)
+	if err != nil {
+		return nil, fmt.Errorf("step %d: %v", in.Step, err)
+	}
+
+	e := &Entry{Step: in.Step, PC: in.PC, Instr: in.Instr, Registers: in.Registers, Cond: cond}
+
+	for _, access := range in.Memory {
+		a := cpu.MemoryAccess{PC: in.PC, Address: access.Address, Value: access.Value, Write: access.Write}
+		if access.Old != nil {
+			a.Old = *access.Old
+		}
+
+		e.Accesses = append(e.Accesses, a)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,66 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// State is the state of the machine a trace reconstructs, as far as the
+// trace shows it.
+type State struct {
+	// Last is the last instruction run, nil before the first.
+	Last *Entry
+
+	// Memory holds the words written so far by their address.
+	Memory map[uint16]uint16
+}
+
+// NewState creates the state before the first instruction.
+func NewState() *State {
+	return &State{Memory: map[uint16]uint16{}}
+}
+
+// Apply runs an entry of the trace on the state.
+func (s *State) Apply(e *Entry) {
+	s.Last = e
+
+	for _, access := range e.Accesses {
+		if access.Write {
+			s.Memory[access.Address] = access.Value
+		}
+	}
+}
+
+// Write writes the state: the last instruction, the registers and
+// condition codes it left and the memory written so far.
+func (s *State) Write(w io.Writer) error {
+	if s.Last == nil {
+		_, err := fmt.Fprintln(w, "no instruction has run")
+		return err
+	}
+
+	fmt.Fprintf(w, "after step %d\n", s.Last.Step)
+	fmt.Fprintln(w, s.Last.String())
+
+	addresses := make([]uint16, 0, len(s.Memory))
+	for address := range s.Memory {
+		addresses = append(addresses, address)
+	}
+
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i] < addresses[j]
+	})
+
+	if len(addresses) > 0 {
+		fmt.Fprintln(w, "memory written:")
+	}
+
+	for _, address := range addresses {
+		if _, err := fmt.Fprintf(w, "x%04X  %04X\n", address, s.Memory[address]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
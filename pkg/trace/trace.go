@@ -0,0 +1,177 @@
+// Package trace records every instruction a program runs with the
+// registers it leaves behind, the basis of the tools that look at how
+// a program ran rather than where it ended.
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lc3/pkg/cflags"
+	"lc3/pkg/cpu"
+	"lc3/pkg/disasm"
+	"lc3/pkg/registers"
+	"strings"
+)
+
+// Entry is an executed instruction.
+type Entry struct {
+	// Step counts the instructions run before this one.
+	Step uint64
+
+	// PC is the address of the instruction.
+	PC uint16
+
+	// Instr is the word of the instruction.
+	Instr uint16
+
+	// Registers are R0 to R7 after the instruction ran.
+	Registers [8]uint16
+
+	// Cond is the condition flags after the instruction ran.
+	Cond uint16
+
+	// Accesses are the memory reads and writes the instruction made.
+	Accesses []cpu.MemoryAccess
+}
+
+// Condition returns the condition flags as n, z or p.
+func (e *Entry) Condition() string {
+	var b strings.Builder
+
+	for _, flag := range []struct {
+		bit  uint16
+		name byte
+	}{{cflags.FLNEG, 'n'}, {cflags.FLZRO, 'z'}, {cflags.FLPOS, 'p'}} {
+		if e.Cond&flag.bit != 0 {
+			b.WriteByte(flag.name)
+		}
+	}
+
+	return b.String()
+}
+
+// String renders the entry as one line of a text trace:
+//
+//	x3000  E01E  LEA R0, x3020        R0=3020 R1=0000 ... R7=0000 CC=p
+func (e *Entry) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "x%04X  %04X  %-20s", e.PC, e.Instr, disasm.Format(e.PC, e.Instr))
+
+	for r, value := range e.Registers {
+		fmt.Fprintf(&b, " R%d=%04X", r, value)
+	}
+
+	fmt.Fprintf(&b, " CC=%s", e.Condition())
+
+	return b.String()
+}
+
+// Writer writes a trace of the instructions run by CPUs, one line per
+// instruction.
+type Writer struct {
+	// w buffers the trace.
+	w *bufio.Writer
+
+	// format is the encoding of the trace.
+	format Format
+
+	// encoder encodes the entries.
+	encoder encoder
+
+	// filter selects the entries written, nil for all of them.
+	filter *Filter
+
+	// step counts the instructions traced.
+	step uint64
+
+	// err is the first error writing the trace, which stops it.
+	err error
+}
+
+// Option configures a Writer.
+type Option func(*Writer)
+
+// WithFormat encodes the trace in a format, text by default.
+func WithFormat(f Format) Option {
+	return func(t *Writer) {
+		t.format = f
+	}
+}
+
+// WithFilter traces only the instructions a filter selects. The steps
+// of the others are still counted.
+func WithFilter(f *Filter) Option {
+	return func(t *Writer) {
+		t.filter = f
+	}
+}
+
+// NewWriter creates a writer tracing to w.
+func NewWriter(w io.Writer, opts ...Option) *Writer {
+	t := &Writer{w: bufio.NewWriter(w), format: FormatText}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	t.encoder = encoders[t.format](t.w)
+
+	return t
+}
+
+// Record traces the instructions run by a CPU.
+func (t *Writer) Record(c cpu.CPU) {
+	Record(c, func(e *Entry) {
+		e.Step = t.step
+		t.Write(e)
+	})
+}
+
+// Record calls fn with an entry for every instruction run by a CPU,
+// numbering them from 0.
+func Record(c cpu.CPU, fn func(e *Entry)) {
+	var accesses []cpu.MemoryAccess
+	var step uint64
+
+	c.OnMemoryAccess(func(access cpu.MemoryAccess) {
+		accesses = append(accesses, access)
+	})
+
+	c.OnInstruction(func(pc, instr uint16) {
+		e := Entry{Step: step, PC: pc, Instr: instr, Cond: c.Register(registers.RCOND), Accesses: accesses}
+
+		for r := range e.Registers {
+			e.Registers[r] = c.Register(uint16(r))
+		}
+
+		fn(&e)
+
+		step++
+		accesses = nil
+	})
+}
+
+// Write adds an entry to the trace.
+func (t *Writer) Write(e *Entry) error {
+	t.step++
+
+	if t.err != nil || (t.filter != nil && !t.filter.Match(e)) {
+		return t.err
+	}
+
+	t.err = t.encoder.encode(t.w, e)
+
+	return t.err
+}
+
+// Flush writes out the buffered trace, returning the first error
+// writing it.
+func (t *Writer) Flush() error {
+	if t.err != nil {
+		return t.err
+	}
+
+	return t.w.Flush()
+}
@@ -2,6 +2,11 @@
 // by a user.
 package traps
 
+import (
+	"sort"
+	"strings"
+)
+
 const (
 	// GETC gets a character from the keyboard.
 	GETC = 0x20
@@ -21,4 +26,56 @@ const (
 
 	// HALT halts execution and prints a message to the console.
 	HALT = 0x25
+
+	// HOSTCALL invokes a host function registered by the embedder,
+	// the call number is taken from R0 and the arguments from R1-R5.
+	HOSTCALL = 0x40
 )
+
+// names are the names of the traps, the aliases of the assembler and
+// HOSTCALL.
+var names = map[uint16]string{
+	GETC:     "GETC",
+	OUT:      "OUT",
+	PUTS:     "PUTS",
+	IN:       "IN",
+	PUTSP:    "PUTSP",
+	HALT:     "HALT",
+	HOSTCALL: "HOSTCALL",
+}
+
+// Name returns the name of a trap vector, such as HALT, or "" if the
+// vector has none.
+func Name(vector uint16) string {
+	return names[vector]
+}
+
+// Names returns the names of the traps, in the order of their vectors.
+func Names() []string {
+	vectors := make([]int, 0, len(names))
+	for vector := range names {
+		vectors = append(vectors, int(vector))
+	}
+
+	sort.Ints(vectors)
+
+	list := make([]string, len(vectors))
+	for i, vector := range vectors {
+		list[i] = names[uint16(vector)]
+	}
+
+	return list
+}
+
+// Lookup returns the vector of a trap name, ignoring case.
+func Lookup(name string) (uint16, bool) {
+	upper := strings.ToUpper(name)
+
+	for vector, n := range names {
+		if n == upper {
+			return vector, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,443 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"lc3/pkg/debugger"
+	"lc3/pkg/devices"
+	"lc3/pkg/disasm"
+	"lc3/pkg/registers"
+	"lc3/pkg/symbols"
+	"lc3/pkg/websocket"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// viewRows is the number of memory rows shown.
+const viewRows = 32
+
+// command is a command sent by the page.
+type command struct {
+	// Cmd names the command.
+	Cmd string `json:"cmd"`
+
+	// Source is the program to load.
+	Source string `json:"source"`
+
+	// Address is the address of a breakpoint or of the memory shown.
+	Address *uint16 `json:"address"`
+
+	// Text is the keys to type.
+	Text string `json:"text"`
+}
+
+// State is the state of the machine sent to the page.
+type State struct {
+	// Type is "state".
+	Type string `json:"type"`
+
+	// Status is empty, ready, running, waiting for input, halted or
+	// error.
+	Status string `json:"status"`
+
+	// Message tells why the program stopped, if it did.
+	Message string `json:"message,omitempty"`
+
+	// PC is the address of the next instruction.
+	PC uint16 `json:"pc"`
+
+	// Registers are R0 to R7.
+	Registers [8]uint16 `json:"registers"`
+
+	// CC are the condition codes, N, Z or P.
+	CC string `json:"cc"`
+
+	// Breakpoints are the addresses of the breakpoints, in order.
+	Breakpoints []uint16 `json:"breakpoints"`
+
+	// Memory are the rows of memory shown.
+	Memory []Row `json:"memory"`
+}
+
+// Row is a word of memory shown with its disassembly.
+type Row struct {
+	// Address is the address of the word.
+	Address uint16 `json:"address"`
+
+	// Word is its value.
+	Word uint16 `json:"word"`
+
+	// Label is the label at the address, if any.
+	Label string `json:"label,omitempty"`
+
+	// Instruction is the word disassembled.
+	Instruction string `json:"instruction"`
+}
+
+// message is a console, diagnostics or error message sent to the page.
+type message struct {
+	// Type is "console", "diagnostics" or "error".
+	Type string `json:"type"`
+
+	// Text is the output of the program or the error of a command.
+	Text string `json:"text,omitempty"`
+
+	// Diagnostics are the reasons a program does not assemble.
+	Diagnostics []string `json:"diagnostics,omitempty"`
+}
+
+// session is a machine driven by a page.
+type session struct {
+	// conn is the connection to the page.
+	conn *websocket.Conn
+
+	// maxInstructions caps the instructions of a run.
+	maxInstructions uint64
+
+	// keyboard is the keyboard the page types on.
+	keyboard *devices.Keyboard
+
+	// running is set while the program runs.
+	running atomic.Bool
+
+	// stopped is set to stop the run.
+	stopped atomic.Bool
+
+	// mu guards the fields below.
+	mu sync.Mutex
+
+	// cpu is the machine.
+	cpu cpu.CPU
+
+	// image is the program loaded, kept to reset it.
+	image *[cpu.MemoryMax]uint16
+
+	// origin is the address the program starts at.
+	origin uint16
+
+	// table holds the labels of the program.
+	table *symbols.Table
+
+	// breakpoints are the addresses runs stop at.
+	breakpoints map[uint16]bool
+
+	// view is the first address shown, nil to follow the PC.
+	view *uint16
+
+	// status is the status of the machine, as State.Status.
+	status string
+
+	// message tells why the program stopped.
+	message string
+
+	// resume resumes a run once keys are typed, if it waits for them.
+	resume bool
+}
+
+// handleSession serves a machine to a page until it disconnects.
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	ses := &session{
+		conn:            conn,
+		maxInstructions: s.maxInstructions,
+		keyboard:        devices.NewKeyboard(),
+		breakpoints:     map[uint16]bool{},
+		table:           symbols.New(),
+		status:          "empty",
+	}
+
+	ses.reset()
+	ses.sendState()
+
+	defer ses.stopped.Store(true)
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var cmd command
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			ses.fail(fmt.Errorf("invalid command: %v", err))
+			continue
+		}
+
+		ses.exec(&cmd)
+	}
+}
+
+// exec executes a command. Only stop and keys are taken while the
+// program runs.
+func (s *session) exec(cmd *command) {
+	switch cmd.Cmd {
+	case "stop":
+		s.stopped.Store(true)
+		return
+	case "keys":
+		s.keyboard.Type([]byte(cmd.Text))
+
+		s.mu.Lock()
+		resume := s.resume
+		s.resume = false
+		s.mu.Unlock()
+
+		if resume {
+			s.start(s.maxInstructions)
+		}
+
+		return
+	}
+
+	if s.running.Load() {
+		s.fail(fmt.Errorf("stop the program first"))
+		return
+	}
+
+	switch cmd.Cmd {
+	case "load":
+		s.load(cmd.Source)
+	case "run":
+		s.start(s.maxInstructions)
+	case "step":
+		s.start(1)
+	case "reset":
+		s.mu.Lock()
+		s.reset()
+		s.mu.Unlock()
+
+		s.sendState()
+	case "break":
+		if cmd.Address != nil {
+			s.mu.Lock()
+			s.breakpoints[*cmd.Address] = !s.breakpoints[*cmd.Address]
+			if !s.breakpoints[*cmd.Address] {
+				delete(s.breakpoints, *cmd.Address)
+			}
+			s.mu.Unlock()
+		}
+
+		s.sendState()
+	case "view":
+		s.mu.Lock()
+		s.view = cmd.Address
+		s.mu.Unlock()
+
+		s.sendState()
+	default:
+		s.fail(fmt.Errorf("unknown command %q", cmd.Cmd))
+	}
+}
+
+// load assembles a program and loads it, or sends the diagnostics.
+func (s *session) load(source string) {
+	obj, table, diagnostics, err := asm.Assemble(strings.NewReader(source), asm.WithoutIncludes())
+
+	list := make([]string, len(diagnostics))
+	for i, d := range diagnostics {
+		list[i] = d.Error()
+	}
+
+	if err != nil && len(list) == 0 {
+		list = append(list, err.Error())
+	}
+
+	s.send(message{Type: "diagnostics", Diagnostics: list})
+
+	if len(list) > 0 {
+		return
+	}
+
+	var image [cpu.MemoryMax]uint16
+	copy(image[obj.Origin:], obj.Words)
+
+	s.mu.Lock()
+	s.image, s.origin, s.table = &image, obj.Origin, table
+	s.breakpoints = map[uint16]bool{}
+	s.view = nil
+	s.reset()
+	s.mu.Unlock()
+
+	s.sendState()
+}
+
+// reset creates a fresh machine with the program loaded, the caller
+// holding mu.
+func (s *session) reset() {
+	s.cpu = cpu.NewCPU(
+		cpu.WithDevice(s.keyboard),
+		cpu.WithInput(s.keyboard.Input()),
+		cpu.WithOutput(console{s}),
+		cpu.WithDevice(devices.NewTerminal(console{s})),
+		cpu.WithDevice(devices.NewRNG(time.Now().UnixNano())),
+		cpu.WithDevice(devices.NewRTC(time.Now)),
+	)
+
+	s.resume = false
+	s.message = ""
+
+	if s.image == nil {
+		return
+	}
+
+	s.cpu.Load(*s.image)
+	s.cpu.SetRegister(registers.RPC, s.origin)
+	s.status = "ready"
+}
+
+// start runs up to n instructions in the background.
+func (s *session) start(n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.image == nil {
+		s.fail(fmt.Errorf("load a program first"))
+		return
+	}
+
+	s.running.Store(true)
+	s.stopped.Store(false)
+	s.status = "running"
+	s.message = ""
+
+	go s.run(n)
+}
+
+// run runs up to n instructions, stopping at breakpoints, before
+// reading keys that are not typed yet or when stopped, then sends the
+// state.
+func (s *session) run(n uint64) {
+	s.sendState()
+
+	s.mu.Lock()
+
+	var ran uint64
+
+	for s.status == "running" {
+		pc := s.cpu.Register(registers.RPC)
+
+		switch {
+		case s.cpu.Halted():
+			s.status = "halted"
+		case s.stopped.Load():
+			s.status, s.message = "ready", "stopped"
+		case ran == n:
+			s.status = "ready"
+
+			if n > 1 {
+				s.message = fmt.Sprintf("paused after %d instructions", ran)
+			}
+		case ran > 0 && s.breakpoints[pc]:
+			s.status, s.message = "ready", fmt.Sprintf("breakpoint at %s", s.table.Format(pc))
+		case s.keyboard.Blocks(s.cpu.PeekMemory(pc)):
+			s.status = "waiting for input"
+			s.resume = n > 1
+		default:
+			if err := s.cpu.Execute(); err != nil {
+				s.status, s.message = "error", err.Error()
+			}
+
+			ran++
+		}
+	}
+
+	s.running.Store(false)
+	s.mu.Unlock()
+
+	s.sendState()
+}
+
+// state returns the state of the machine, the caller holding mu.
+func (s *session) state() *State {
+	state := &State{
+		Type:        "state",
+		Status:      s.status,
+		Message:     s.message,
+		PC:          s.cpu.Register(registers.RPC),
+		CC:          debugger.ConditionCodes(s.cpu.Register(registers.RCOND)),
+		Breakpoints: []uint16{},
+	}
+
+	for r := range state.Registers {
+		state.Registers[r] = s.cpu.Register(uint16(r))
+	}
+
+	for address := range s.breakpoints {
+		state.Breakpoints = append(state.Breakpoints, address)
+	}
+
+	sort.Slice(state.Breakpoints, func(i, j int) bool {
+		return state.Breakpoints[i] < state.Breakpoints[j]
+	})
+
+	start := state.PC - min(state.PC, 4)
+	if s.view != nil {
+		start = *s.view
+	}
+
+	start = min(start, math.MaxUint16+1-viewRows)
+
+	for i := uint16(0); i < viewRows; i++ {
+		address := start + i
+		word := s.cpu.PeekMemory(address)
+		label, _ := s.table.Name(address)
+
+		state.Memory = append(state.Memory, Row{
+			Address:     address,
+			Word:        word,
+			Label:       label,
+			Instruction: disasm.Format(address, word),
+		})
+	}
+
+	return state
+}
+
+// sendState sends the state of the machine.
+func (s *session) sendState() {
+	s.mu.Lock()
+	state := s.state()
+	s.mu.Unlock()
+
+	s.send(state)
+}
+
+// fail sends the error of a command.
+func (s *session) fail(err error) {
+	s.send(message{Type: "error", Text: err.Error()})
+}
+
+// send sends a message to the page.
+func (s *session) send(msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return s.conn.WriteMessage(data)
+}
+
+// console sends the output of the program to the page.
+type console struct {
+	session *session
+}
+
+// Write sends output as a console message.
+func (c console) Write(p []byte) (int, error) {
+	c.session.send(message{Type: "console", Text: string(p)})
+
+	return len(p), nil
+}
@@ -0,0 +1,127 @@
+// The page talks to its machine over the WebSocket at session, next to
+// the page, sending commands and showing the state it is sent.
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+
+const hex = (n) => "x" + n.toString(16).toUpperCase().padStart(4, "0");
+
+const url = new URL("session", location.href);
+url.protocol = location.protocol === "https:" ? "wss:" : "ws:";
+
+const socket = new WebSocket(url);
+
+let state = null;
+
+const send = (cmd) => socket.send(JSON.stringify(cmd));
+
+socket.onopen = () => send({ cmd: "load", source: $("source").value });
+
+socket.onclose = () => {
+  $("status").textContent = "disconnected, reload the page";
+};
+
+socket.onmessage = (event) => {
+  const msg = JSON.parse(event.data);
+
+  switch (msg.type) {
+    case "state":
+      state = msg;
+      render();
+      break;
+    case "console":
+      $("console").textContent += msg.text;
+      $("console").scrollTop = $("console").scrollHeight;
+      break;
+    case "diagnostics":
+      $("diagnostics").replaceChildren(...(msg.diagnostics || []).map((text) => {
+        const li = document.createElement("li");
+        li.textContent = text;
+        return li;
+      }));
+      break;
+    case "error":
+      $("status").textContent = msg.text;
+      break;
+  }
+};
+
+// render shows the state of the machine.
+function render() {
+  $("status").textContent = state.status + (state.message ? ": " + state.message : "");
+
+  const registers = state.registers.map((value, r) => ["R" + r, value]);
+  registers.push(["PC", state.pc]);
+
+  $("registers").replaceChildren(...registers.map(([name, value]) => row([name, hex(value), value << 16 >> 16])),
+    row(["CC", state.cc, ""]));
+
+  const breakpoints = new Set(state.breakpoints);
+
+  $("rows").replaceChildren(...state.memory.map((word) => {
+    const tr = row(["", hex(word.address), word.label || "", hex(word.word), word.instruction]);
+
+    if (breakpoints.has(word.address)) {
+      tr.firstChild.className = "breakpoint";
+    }
+
+    if (word.address === state.pc) {
+      tr.className = "pc";
+    }
+
+    tr.title = "Click to toggle a breakpoint";
+    tr.onclick = () => send({ cmd: "break", address: word.address });
+
+    return tr;
+  }));
+}
+
+// row creates a table row of cells.
+function row(cells) {
+  const tr = document.createElement("tr");
+
+  for (const text of cells) {
+    const td = document.createElement("td");
+    td.textContent = text;
+    tr.appendChild(td);
+  }
+
+  return tr;
+}
+
+$("load").onclick = () => {
+  $("console").textContent = "";
+  send({ cmd: "load", source: $("source").value });
+};
+
+$("reset").onclick = () => {
+  $("console").textContent = "";
+  send({ cmd: "reset" });
+};
+
+for (const cmd of ["run", "step", "stop"]) {
+  $(cmd).onclick = () => send({ cmd });
+}
+
+$("address").onchange = () => {
+  const text = $("address").value.trim().replace(/^0?x/i, "");
+
+  if (text === "") {
+    send({ cmd: "view" });
+  } else if (/^[0-9a-f]{1,4}$/i.test(text)) {
+    send({ cmd: "view", address: parseInt(text, 16) });
+  }
+};
+
+$("console").onkeydown = (event) => {
+  let key = event.key.length === 1 ? event.key : { Enter: "\n", Backspace: "\b", Tab: "\t", Escape: "\x1b" }[event.key];
+
+  if (event.ctrlKey && event.key.length === 1) {
+    key = String.fromCharCode(event.key.toUpperCase().charCodeAt(0) & 0x1f);
+  }
+
+  if (key !== undefined) {
+    event.preventDefault();
+    send({ cmd: "keys", text: key });
+  }
+};
@@ -0,0 +1,81 @@
+// Package ui serves a simulator for the browser, showing the
+// registers, memory with its disassembly and the console of a program
+// with controls to run, step and set breakpoints, so that classes can
+// use the VM with nothing to install.
+//
+// The page opens a WebSocket to /session, a fresh machine living as
+// long as the connection. Clients send commands as JSON:
+//
+//	{"cmd": "load", "source": ".ORIG x3000\n..."}   assembles and loads a program
+//	{"cmd": "run"}                                  runs until a breakpoint or HALT
+//	{"cmd": "step"}                                 runs an instruction
+//	{"cmd": "stop"}                                 stops a run
+//	{"cmd": "reset"}                                reloads the program
+//	{"cmd": "break", "address": 12292}              toggles a breakpoint
+//	{"cmd": "view", "address": 12288}               shows memory from an address,
+//	                                                or around the PC without one
+//	{"cmd": "keys", "text": "y"}                    types on the keyboard
+//
+// and the server answers with messages of the types "state",
+// "console", "diagnostics" and "error".
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// DefaultMaxInstructions is the number of instructions a run executes
+// before pausing, unless configured otherwise, so that programs stuck
+// in a loop do not spin forever.
+const DefaultMaxInstructions = 10_000_000
+
+// static holds the page of the simulator.
+//
+//go:embed static
+var static embed.FS
+
+// Server serves the simulator.
+type Server struct {
+	// maxInstructions caps the instructions of a run.
+	maxInstructions uint64
+
+	// mux routes the requests.
+	mux *http.ServeMux
+}
+
+// Option configures a server.
+type Option func(s *Server)
+
+// WithMaxInstructions pauses runs after n instructions,
+// DefaultMaxInstructions by default.
+func WithMaxInstructions(n uint64) Option {
+	return func(s *Server) {
+		s.maxInstructions = max(n, 1)
+	}
+}
+
+// New creates a server.
+func New(opts ...Option) *Server {
+	s := &Server{
+		maxInstructions: DefaultMaxInstructions,
+		mux:             http.NewServeMux(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	page, _ := fs.Sub(static, "static")
+
+	s.mux.HandleFunc("GET /session", s.handleSession)
+	s.mux.Handle("GET /", http.FileServer(http.FS(page)))
+
+	return s
+}
+
+// ServeHTTP serves a request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
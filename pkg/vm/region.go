@@ -0,0 +1,66 @@
+package vm
+
+// Region is a range of memory the host reads, such as a framebuffer or
+// a table of sprites. It tracks writes made by the program, so the
+// host reads it only when it changed.
+type Region struct {
+	// m is the machine the region belongs to.
+	m *Machine
+
+	// start is the first address of the region.
+	start uint16
+
+	// end is the last address of the region.
+	end uint16
+
+	// changed is set when the program writes to the region.
+	changed bool
+}
+
+// Map maps the memory from start to end inclusive as a region, which
+// counts as changed until first read.
+func (m *Machine) Map(start, end uint16) *Region {
+	r := &Region{m: m, start: start, end: max(start, end), changed: true}
+	m.regions = append(m.regions, r)
+
+	return r
+}
+
+// Unmap stops tracking writes to a region.
+func (m *Machine) Unmap(r *Region) {
+	for i, region := range m.regions {
+		if region == r {
+			m.regions = append(m.regions[:i], m.regions[i+1:]...)
+			return
+		}
+	}
+}
+
+// Len returns the number of words in the region.
+func (r *Region) Len() int {
+	return int(r.end) - int(r.start) + 1
+}
+
+// Changed reports whether the program wrote to the region since it
+// was last read, including writes that left it as it was.
+func (r *Region) Changed() bool {
+	return r.changed
+}
+
+// Read copies the region into dst, grown to fit, without touching
+// devices, and returns it.
+func (r *Region) Read(dst []uint16) []uint16 {
+	if cap(dst) < r.Len() {
+		dst = make([]uint16, r.Len())
+	}
+
+	dst = dst[:r.Len()]
+
+	for i := range dst {
+		dst[i] = r.m.cpu.PeekMemory(r.start + uint16(i))
+	}
+
+	r.changed = false
+
+	return dst
+}
@@ -0,0 +1,201 @@
+// Package vm embeds the VM in host applications, such as games using
+// LC3 as an in-game scriptable computer. The host ticks the machine a
+// number of instructions per frame, exchanges console input and output
+// through in-memory queues rather than stdio, and reads regions of
+// memory, such as a framebuffer the program draws into, only when the
+// program changed them:
+//
+//	m := vm.New()
+//	m.Load(obj)
+//	screen := m.Map(0xC000, 0xFDFF)
+//
+//	for range frames {
+//		m.Type(keys)
+//		if _, err := m.Tick(10_000); err != nil { ... }
+//		if screen.Changed() {
+//			pixels = screen.Read(pixels)
+//		}
+//		log.Print(string(m.Output()))
+//	}
+//
+// A machine is not safe for concurrent use, apart from Type.
+package vm
+
+import (
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"lc3/pkg/devices"
+	"lc3/pkg/registers"
+)
+
+// Machine is a VM driven by a host application.
+type Machine struct {
+	// cpu is the machine.
+	cpu cpu.CPU
+
+	// keyboard holds the keys typed by the host.
+	keyboard *devices.Keyboard
+
+	// output holds the console output not yet taken by the host.
+	output *queue
+
+	// regions are the regions of memory mapped by the host.
+	regions []*Region
+}
+
+// Option configures a machine.
+type Option func(m *machineOptions)
+
+// machineOptions holds the options of a machine.
+type machineOptions struct {
+	// cpuOptions configure the CPU further.
+	cpuOptions []cpu.Option
+}
+
+// WithCPUOptions configures the CPU further, to attach devices or
+// register host calls the program calls into the game with.
+func WithCPUOptions(opts ...cpu.Option) Option {
+	return func(m *machineOptions) {
+		m.cpuOptions = append(m.cpuOptions, opts...)
+	}
+}
+
+// New creates a machine with nothing loaded and the PC at x3000.
+func New(opts ...Option) *Machine {
+	var options machineOptions
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	m := &Machine{
+		keyboard: devices.NewKeyboard(),
+		output:   &queue{},
+	}
+
+	m.cpu = cpu.NewCPU(append([]cpu.Option{
+		cpu.WithDevice(m.keyboard),
+		cpu.WithInput(m.keyboard.Input()),
+		cpu.WithOutput(m.output),
+	}, options.cpuOptions...)...)
+
+	m.cpu.OnMemoryAccess(func(access cpu.MemoryAccess) {
+		if !access.Write {
+			return
+		}
+
+		for _, r := range m.regions {
+			if access.Address >= r.start && access.Address <= r.end {
+				r.changed = true
+			}
+		}
+	})
+
+	return m
+}
+
+// Load loads an object into memory and points the PC at its origin.
+func (m *Machine) Load(obj *asm.Object) {
+	var image [cpu.MemoryMax]uint16
+	copy(image[obj.Origin:], obj.Words)
+
+	m.LoadImage(image, obj.Origin)
+}
+
+// LoadImage loads a memory image and points the PC at origin.
+func (m *Machine) LoadImage(image [cpu.MemoryMax]uint16, origin uint16) {
+	m.cpu.Load(image)
+	m.cpu.SetRegister(registers.RPC, origin)
+	m.cpu.SetHalted(false)
+
+	for _, r := range m.regions {
+		r.changed = true
+	}
+}
+
+// Tick runs up to n instructions and returns how many ran. It returns
+// early once the program halts, fails or waits for a key that is not
+// typed yet, in which case the next tick resumes it.
+func (m *Machine) Tick(n int) (int, error) {
+	for ran := 0; ran < n; ran++ {
+		if m.cpu.Halted() || m.Waiting() {
+			return ran, nil
+		}
+
+		if err := m.cpu.Execute(); err != nil {
+			return ran, err
+		}
+	}
+
+	return n, nil
+}
+
+// Halted reports whether the program has halted.
+func (m *Machine) Halted() bool {
+	return m.cpu.Halted()
+}
+
+// Waiting reports whether the program waits for a key with GETC or IN.
+// Programs polling the keyboard registers keep running instead.
+func (m *Machine) Waiting() bool {
+	return m.keyboard.Blocks(m.cpu.PeekMemory(m.cpu.Register(registers.RPC)))
+}
+
+// Type queues keys for the program to read. It is safe to call while
+// the machine ticks on another goroutine.
+func (m *Machine) Type(keys []byte) {
+	m.keyboard.Type(keys)
+}
+
+// Output takes the console output written since it was last taken.
+func (m *Machine) Output() []byte {
+	return m.output.take()
+}
+
+// Register returns the value of a register.
+func (m *Machine) Register(r uint16) uint16 {
+	return m.cpu.Register(r)
+}
+
+// SetRegister sets the value of a register.
+func (m *Machine) SetRegister(r, val uint16) {
+	m.cpu.SetRegister(r, val)
+}
+
+// Peek reads a word of memory without touching devices.
+func (m *Machine) Peek(address uint16) uint16 {
+	return m.cpu.PeekMemory(address)
+}
+
+// Poke writes a word of memory without touching devices, such as the
+// state of the game the program reads.
+func (m *Machine) Poke(address, val uint16) {
+	m.cpu.PokeMemory(address, val)
+}
+
+// CPU returns the CPU of the machine, for anything this package does
+// not cover.
+func (m *Machine) CPU() cpu.CPU {
+	return m.cpu
+}
+
+// queue is the console output not yet taken.
+type queue struct {
+	// buf holds the output.
+	buf []byte
+}
+
+// Write queues output.
+func (q *queue) Write(p []byte) (int, error) {
+	q.buf = append(q.buf, p...)
+
+	return len(p), nil
+}
+
+// take removes and returns the output queued.
+func (q *queue) take() []byte {
+	out := q.buf
+	q.buf = nil
+
+	return out
+}
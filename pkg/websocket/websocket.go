@@ -0,0 +1,244 @@
+// Package websocket implements the server side of the WebSocket
+// protocol (RFC 6455), enough to exchange messages with browsers
+// without depending on anything beyond the standard library.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// MaxMessageSize is the size of the largest message accepted from
+// a client.
+const MaxMessageSize = 1 << 20
+
+// acceptGUID is appended to the key of the client to compute the
+// accept header of the handshake.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// frame opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// ErrClosed is returned once the client closed the connection.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// IsUpgrade reports whether a request asks for a WebSocket connection.
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// Conn is a WebSocket connection.
+type Conn struct {
+	// conn is the underlying network connection.
+	conn net.Conn
+
+	// r reads frames from conn.
+	r *bufio.Reader
+
+	// mu serializes writes to conn.
+	mu sync.Mutex
+}
+
+// Upgrade performs the opening handshake of a WebSocket request and
+// takes over its connection. On failure an error response has already
+// been written.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, fmt.Errorf("websocket: not an upgrade request")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusBadRequest)
+		return nil, fmt.Errorf("websocket: unsupported handshake")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be upgraded", http.StatusInternalServerError)
+		return nil, fmt.Errorf("websocket: response does not support hijacking")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n")
+	fmt.Fprintf(rw, "Upgrade: websocket\r\nConnection: Upgrade\r\n")
+	fmt.Fprintf(rw, "Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{conn: conn, r: rw.Reader}, nil
+}
+
+// ReadMessage reads the next text or binary message, answering pings
+// on the way. It returns ErrClosed once the client closes the
+// connection.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, nil)
+			return nil, ErrClosed
+		}
+
+		message = append(message, payload...)
+		if len(message) > MaxMessageSize {
+			return nil, fmt.Errorf("websocket: message exceeds %d bytes", MaxMessageSize)
+		}
+
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// readFrame reads a single frame, unmasking its payload.
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	op := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if length > MaxMessageSize {
+		return false, 0, nil, fmt.Errorf("websocket: frame exceeds %d bytes", MaxMessageSize)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return fin, op, payload, nil
+}
+
+// WriteMessage sends a text message.
+func (c *Conn) WriteMessage(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// WriteBinary sends a binary message.
+func (c *Conn) WriteBinary(data []byte) error {
+	return c.writeFrame(opBinary, data)
+}
+
+// writeFrame writes a single unmasked frame.
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := []byte{0x80 | op, 0}
+
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	c.writeFrame(opClose, nil)
+
+	return c.conn.Close()
+}
+
+// headerContains reports whether a comma separated header contains
+// a token, ignoring case.
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,171 @@
+// Package workload holds representative LC-3 programs, run by the
+// benchmarks of the VM and to profile it for profile-guided builds.
+package workload
+
+import (
+	"fmt"
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"strings"
+)
+
+// Sources are the programs by name.
+var Sources = map[string]string{
+	// dispatch spins through register operations.
+	"dispatch": `
+		.ORIG x3000
+LOOP	ADD R1, R1, #1
+		AND R2, R1, #7
+		NOT R3, R2
+		BRnzp LOOP
+		.END`,
+
+	// memory loads and stores through every addressing mode.
+	"memory": `
+		.ORIG x3000
+		LEA R4, DATA
+LOOP	LD R1, DATA
+		ST R1, DATA
+		LDR R2, R4, #1
+		STR R2, R4, #1
+		LDI R3, PTR
+		STI R3, PTR
+		BRnzp LOOP
+DATA	.FILL x1234
+		.FILL x5678
+PTR		.FILL DATA
+		.END`,
+
+	// trap writes a character to the console forever.
+	"trap": `
+		.ORIG x3000
+		LD R0, CHAR
+LOOP	OUT
+		BRnzp LOOP
+CHAR	.FILL x41
+		.END`,
+
+	// sieve finds the primes below 1000 with the sieve of
+	// Eratosthenes.
+	"sieve": `
+		.ORIG x3000
+		LEA R6, FLAGS
+		LD R5, SIZE
+		NOT R5, R5
+		ADD R5, R5, #1		; R5 = -SIZE
+		AND R1, R1, #0
+		ADD R1, R1, #2		; R1 = candidate
+OUTER	ADD R0, R1, R5
+		BRzp DONE
+		ADD R2, R6, R1
+		LDR R0, R2, #0
+		BRnp NEXT			; already crossed out
+		ADD R3, R1, R1		; R3 = multiple
+INNER	ADD R0, R3, R5
+		BRzp NEXT
+		ADD R2, R6, R3
+		AND R0, R0, #0
+		ADD R0, R0, #1
+		STR R0, R2, #0
+		ADD R3, R3, R1
+		BRnzp INNER
+NEXT	ADD R1, R1, #1
+		BRnzp OUTER
+DONE	HALT
+SIZE	.FILL #1000
+FLAGS	.BLKW #1000
+		.END`,
+
+	// strcpy copies a string word by word, 100 times.
+	"strcpy": `
+		.ORIG x3000
+		LD R5, TIMES
+AGAIN	LEA R1, SRC
+		LEA R2, DST
+COPY	LDR R0, R1, #0
+		STR R0, R2, #0
+		BRz COPIED
+		ADD R1, R1, #1
+		ADD R2, R2, #1
+		BRnzp COPY
+COPIED	ADD R5, R5, #-1
+		BRp AGAIN
+		HALT
+TIMES	.FILL #100
+SRC		.STRINGZ "The quick brown fox jumps over the lazy dog, again and again."
+DST		.BLKW #64
+		.END`,
+
+	// fib computes the 15th Fibonacci number recursively, saving
+	// registers on a stack.
+	"fib": `
+		.ORIG x3000
+		LD R6, STACK
+		AND R0, R0, #0
+		ADD R0, R0, #15
+		JSR FIB
+		HALT
+FIB		ADD R6, R6, #-3		; R1 = fib(R0)
+		STR R7, R6, #0
+		STR R0, R6, #1
+		STR R2, R6, #2
+		ADD R1, R0, #0
+		ADD R2, R0, #-2
+		BRn RETURN
+		ADD R0, R0, #-1
+		JSR FIB
+		ADD R2, R1, #0
+		ADD R0, R0, #-1
+		JSR FIB
+		ADD R1, R1, R2
+RETURN	LDR R2, R6, #2
+		LDR R0, R6, #1
+		LDR R7, R6, #0
+		ADD R6, R6, #3
+		RET
+STACK	.FILL xFD00
+		.END`,
+
+	// print writes a line to the console 50 times.
+	"print": `
+		.ORIG x3000
+		LD R1, TIMES
+LOOP	LEA R0, LINE
+		PUTS
+		LD R0, NEWLINE
+		OUT
+		ADD R1, R1, #-1
+		BRp LOOP
+		HALT
+TIMES	.FILL #50
+NEWLINE	.FILL x0A
+LINE	.STRINGZ "The quick brown fox jumps over the lazy dog."
+		.END`,
+}
+
+// Halting are the names of the programs that halt, in the order they
+// are profiled. The others run forever.
+var Halting = []string{"sieve", "strcpy", "fib", "print"}
+
+// Image assembles a program into a memory image.
+func Image(name string) ([cpu.MemoryMax]uint16, error) {
+	var memory [cpu.MemoryMax]uint16
+
+	source, ok := Sources[name]
+	if !ok {
+		return memory, fmt.Errorf("no workload %s", name)
+	}
+
+	obj, _, diagnostics, err := asm.Assemble(strings.NewReader(source))
+	if err != nil {
+		return memory, fmt.Errorf("%s: %w", name, err)
+	}
+
+	if len(diagnostics) > 0 {
+		return memory, fmt.Errorf("%s: %v", name, diagnostics)
+	}
+
+	copy(memory[obj.Origin:], obj.Words)
+
+	return memory, nil
+}
@@ -0,0 +1,67 @@
+package workload
+
+import (
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/registers"
+	"strings"
+	"testing"
+)
+
+// TestHalting checks that the programs that should halt do, fib with
+// the 15th Fibonacci number in R1.
+func TestHalting(t *testing.T) {
+	for _, name := range Halting {
+		memory, err := Image(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out strings.Builder
+
+		c := cpu.NewCPU(cpu.WithInput(strings.NewReader("")), cpu.WithOutput(&out))
+
+		if err := c.Run(memory); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !c.Halted() {
+			t.Errorf("%s did not halt", name)
+		}
+
+		if name == "fib" && c.Register(registers.RR1) != 610 {
+			t.Errorf("fib: R1 = %d, expected 610", c.Register(registers.RR1))
+		}
+
+		if name == "print" && out.Len() != 50*45 {
+			t.Errorf("print: wrote %d bytes, expected %d", out.Len(), 50*45)
+		}
+	}
+}
+
+// BenchmarkWorkloads runs every program that halts once per iteration,
+// the mix the VM is profiled on for profile-guided builds.
+func BenchmarkWorkloads(b *testing.B) {
+	var images [][cpu.MemoryMax]uint16
+
+	for _, name := range Halting {
+		memory, err := Image(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		images = append(images, memory)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, memory := range images {
+			c := cpu.NewCPU(cpu.WithInput(strings.NewReader("")), cpu.WithOutput(io.Discard))
+
+			if err := c.Run(memory); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,427 @@
+// Package zmtp implements enough of ZMTP 3.0, the wire protocol of
+// ZeroMQ, to serve ROUTER, PUB and REP sockets to peers such as
+// Jupyter clients, over TCP with the NULL security mechanism and
+// without depending on libzmq.
+//
+// A socket listens for peers and exchanges messages, lists of frames,
+// with them. ROUTER and REP sockets receive messages and reply to the
+// peer that sent them, PUB sockets send messages to the peers that
+// subscribed to them.
+package zmtp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// The socket types served.
+const (
+	Router = "ROUTER"
+	Pub    = "PUB"
+	Rep    = "REP"
+)
+
+// frame flags.
+const (
+	flagMore    = 0x01
+	flagLong    = 0x02
+	flagCommand = 0x04
+)
+
+// maxFrame caps the bytes of a frame received.
+const maxFrame = 64 << 20
+
+// ErrClosed is returned by Recv once the socket is closed.
+var ErrClosed = errors.New("zmtp: socket closed")
+
+// Message is a message received from a peer.
+type Message struct {
+	// Frames are the frames of the message.
+	Frames [][]byte
+
+	// peer is the peer that sent the message.
+	peer *peer
+}
+
+// Socket is a listening socket.
+type Socket struct {
+	// kind is the socket type.
+	kind string
+
+	// listener accepts peers.
+	listener net.Listener
+
+	// incoming are the messages received.
+	incoming chan Message
+
+	// done is closed when the socket is closed.
+	done chan struct{}
+
+	// mu guards peers.
+	mu sync.Mutex
+
+	// peers are the peers connected.
+	peers map[*peer]bool
+}
+
+// Listen listens for peers of a socket of a kind on a TCP address.
+func Listen(kind, address string) (*Socket, error) {
+	switch kind {
+	case Router, Pub, Rep:
+	default:
+		return nil, fmt.Errorf("zmtp: unsupported socket type %s", kind)
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Socket{
+		kind:     kind,
+		listener: listener,
+		incoming: make(chan Message, 64),
+		done:     make(chan struct{}),
+		peers:    map[*peer]bool{},
+	}
+
+	go s.accept()
+
+	return s, nil
+}
+
+// Addr returns the address the socket listens on.
+func (s *Socket) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// accept accepts peers until the socket is closed.
+func (s *Socket) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.serve(conn)
+	}
+}
+
+// serve handshakes with a peer and receives its messages until it
+// disconnects.
+func (s *Socket) serve(conn net.Conn) {
+	p := &peer{conn: conn, r: bufio.NewReader(conn)}
+
+	defer conn.Close()
+
+	if err := p.handshake(s.kind); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.peers[p] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.peers, p)
+		s.mu.Unlock()
+	}()
+
+	for {
+		frames, err := p.receive()
+		if err != nil {
+			return
+		}
+
+		if s.kind == Pub {
+			// version 3.0 peers subscribe with messages starting with
+			// 1, and unsubscribe with 0.
+			if len(frames) == 1 && len(frames[0]) > 0 {
+				p.subscribe(frames[0][0] == 1, frames[0][1:])
+			}
+
+			continue
+		}
+
+		select {
+		case s.incoming <- Message{Frames: frames, peer: p}:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Recv receives the next message of a ROUTER or REP socket.
+func (s *Socket) Recv() (Message, error) {
+	select {
+	case m := <-s.incoming:
+		return m, nil
+	case <-s.done:
+		return Message{}, ErrClosed
+	}
+}
+
+// Reply sends a message to the peer a message came from.
+func (s *Socket) Reply(to Message, frames [][]byte) error {
+	return to.peer.send(frames)
+}
+
+// Send sends a message to every peer of a PUB socket subscribed to a
+// prefix of its first frame.
+func (s *Socket) Send(frames [][]byte) {
+	s.mu.Lock()
+	peers := make([]*peer, 0, len(s.peers))
+	for p := range s.peers {
+		peers = append(peers, p)
+	}
+	s.mu.Unlock()
+
+	for _, p := range peers {
+		if len(frames) > 0 && p.subscribed(frames[0]) {
+			p.send(frames)
+		}
+	}
+}
+
+// Close stops listening and disconnects the peers.
+func (s *Socket) Close() error {
+	close(s.done)
+
+	s.mu.Lock()
+	for p := range s.peers {
+		p.conn.Close()
+	}
+	s.mu.Unlock()
+
+	return s.listener.Close()
+}
+
+// peer is a connected peer.
+type peer struct {
+	// conn is the connection to the peer.
+	conn net.Conn
+
+	// r reads frames from conn.
+	r *bufio.Reader
+
+	// mu serializes writes to conn and guards topics.
+	mu sync.Mutex
+
+	// topics are the prefixes the peer subscribed to.
+	topics [][]byte
+}
+
+// handshake exchanges greetings and READY commands with a peer.
+func (p *peer) handshake(kind string) error {
+	greeting := make([]byte, 64)
+	greeting[0], greeting[9] = 0xFF, 0x7F
+	greeting[10], greeting[11] = 3, 0
+	copy(greeting[12:32], "NULL")
+
+	if _, err := p.conn.Write(greeting); err != nil {
+		return err
+	}
+
+	theirs := make([]byte, 64)
+	if _, err := io.ReadFull(p.r, theirs); err != nil {
+		return err
+	}
+
+	if theirs[0] != 0xFF || theirs[9]&1 != 1 || theirs[10] < 3 {
+		return fmt.Errorf("zmtp: peer does not speak ZMTP 3")
+	}
+
+	if string(bytes.TrimRight(theirs[12:32], "\x00")) != "NULL" {
+		return fmt.Errorf("zmtp: unsupported security mechanism")
+	}
+
+	if err := p.writeFrame(flagCommand, command("READY", "Socket-Type", kind)); err != nil {
+		return err
+	}
+
+	flags, body, err := p.readFrame()
+	if err != nil {
+		return err
+	}
+
+	if flags&flagCommand == 0 || !bytes.HasPrefix(body, []byte("\x05READY")) {
+		return fmt.Errorf("zmtp: expected a READY command")
+	}
+
+	return nil
+}
+
+// command encodes a command with properties given as name and value
+// pairs.
+func command(name string, properties ...string) []byte {
+	body := append([]byte{byte(len(name))}, name...)
+
+	for i := 0; i+1 < len(properties); i += 2 {
+		body = append(body, byte(len(properties[i])))
+		body = append(body, properties[i]...)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(properties[i+1])))
+		body = append(body, properties[i+1]...)
+	}
+
+	return body
+}
+
+// receive receives the frames of the next message, handling the
+// commands sent in between.
+func (p *peer) receive() ([][]byte, error) {
+	var frames [][]byte
+
+	for {
+		flags, body, err := p.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		if flags&flagCommand != 0 {
+			p.handleCommand(body)
+			continue
+		}
+
+		frames = append(frames, body)
+
+		if flags&flagMore == 0 {
+			return frames, nil
+		}
+	}
+}
+
+// handleCommand handles the SUBSCRIBE and CANCEL commands of version
+// 3.1 peers, ignoring others.
+func (p *peer) handleCommand(body []byte) {
+	if len(body) == 0 || len(body) < 1+int(body[0]) {
+		return
+	}
+
+	name, data := string(body[1:1+body[0]]), body[1+body[0]:]
+
+	switch name {
+	case "SUBSCRIBE":
+		p.subscribe(true, data)
+	case "CANCEL":
+		p.subscribe(false, data)
+	}
+}
+
+// subscribe adds or removes a subscription to a prefix.
+func (p *peer) subscribe(add bool, topic []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if add {
+		p.topics = append(p.topics, bytes.Clone(topic))
+		return
+	}
+
+	for i, t := range p.topics {
+		if bytes.Equal(t, topic) {
+			p.topics = append(p.topics[:i], p.topics[i+1:]...)
+			return
+		}
+	}
+}
+
+// subscribed reports whether the peer subscribed to a prefix of topic.
+func (p *peer) subscribed(topic []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, t := range p.topics {
+		if bytes.HasPrefix(topic, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// send sends a message.
+func (p *peer) send(frames [][]byte) error {
+	var buf []byte
+
+	for i, frame := range frames {
+		var flags byte
+		if i < len(frames)-1 {
+			flags |= flagMore
+		}
+
+		buf = appendFrame(buf, flags, frame)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := p.conn.Write(buf)
+
+	return err
+}
+
+// writeFrame writes a single frame.
+func (p *peer) writeFrame(flags byte, body []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := p.conn.Write(appendFrame(nil, flags, body))
+
+	return err
+}
+
+// appendFrame appends a frame to a buffer.
+func appendFrame(buf []byte, flags byte, body []byte) []byte {
+	if len(body) > 255 {
+		buf = append(buf, flags|flagLong)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(body)))
+	} else {
+		buf = append(buf, flags, byte(len(body)))
+	}
+
+	return append(buf, body...)
+}
+
+// readFrame reads a single frame.
+func (p *peer) readFrame() (byte, []byte, error) {
+	flags, err := p.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var size uint64
+
+	if flags&flagLong != 0 {
+		var b [8]byte
+		if _, err := io.ReadFull(p.r, b[:]); err != nil {
+			return 0, nil, err
+		}
+
+		size = binary.BigEndian.Uint64(b[:])
+	} else {
+		b, err := p.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		size = uint64(b)
+	}
+
+	if size > maxFrame {
+		return 0, nil, fmt.Errorf("zmtp: frame of %d bytes is too large", size)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(p.r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return flags, body, nil
+}
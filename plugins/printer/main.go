@@ -0,0 +1,73 @@
+// Command printer is an example device plugin, a line printer writing
+// the characters a program prints to a file, or to standard error if
+// none is given, since standard output talks to the VM:
+//
+//	lc3 -plugin "printer listing.txt" program.obj
+//
+// Its status register at xFE30 always has bit 15 set, ready to print,
+// and writing a character to its data register at xFE32 prints it.
+package main
+
+import (
+	"io"
+	"lc3/pkg/plugin"
+	"log"
+	"os"
+)
+
+// The registers of the printer.
+const (
+	statusRegister = 0xFE30
+	dataRegister   = 0xFE32
+)
+
+// printer is a line printer.
+type printer struct {
+	// out is where the characters are printed.
+	out io.Writer
+}
+
+// Addresses returns the printer registers.
+func (p *printer) Addresses() []uint16 {
+	return []uint16{statusRegister, dataRegister}
+}
+
+// Read reads the status register, always ready, or 0 for the data
+// register.
+func (p *printer) Read(address uint16) (uint16, error) {
+	if address == statusRegister {
+		return 1 << 15, nil
+	}
+
+	return 0, nil
+}
+
+// Write prints a character written to the data register.
+func (p *printer) Write(address uint16, val uint16) error {
+	if address != dataRegister {
+		return nil
+	}
+
+	_, err := p.out.Write([]byte{byte(val)})
+
+	return err
+}
+
+func main() {
+	p := &printer{out: os.Stderr}
+
+	if len(os.Args) > 1 {
+		file, err := os.Create(os.Args[1])
+		if err != nil {
+			log.Fatalf("failed to create the listing: %v", err)
+		}
+
+		defer file.Close()
+
+		p.out = file
+	}
+
+	if err := plugin.Serve(p); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+#!/bin/sh
+# bench.sh compares the CPU benchmarks of a base commit with those of
+# the working tree using benchstat, and exits with 1 if any benchmark
+# got significantly slower by more than a threshold.
+#
+#   scripts/bench.sh [base]
+#
+# base defaults to main. COUNT sets the runs of each benchmark,
+# THRESHOLD the slowdown in percent tolerated, BENCH the benchmarks
+# run, and BENCHSTAT the benchstat command.
+set -eu
+
+base=${1:-main}
+count=${COUNT:-10}
+threshold=${THRESHOLD:-5}
+bench=${BENCH:-.}
+benchstat=${BENCHSTAT:-go run golang.org/x/perf/cmd/benchstat@latest}
+
+root=$(git rev-parse --show-toplevel)
+tmp=$(mktemp -d)
+
+cleanup() {
+	git -C "$root" worktree remove --force "$tmp/base" >/dev/null 2>&1 || true
+	rm -rf "$tmp"
+}
+trap cleanup EXIT
+
+git -C "$root" worktree add --quiet --detach "$tmp/base" "$base"
+
+echo "benchmarking $base" >&2
+(cd "$tmp/base" && go test ./pkg/cpu -run '^$' -bench "$bench" -count "$count") >"$tmp/base.txt"
+
+echo "benchmarking the working tree" >&2
+(cd "$root" && go test ./pkg/cpu -run '^$' -bench "$bench" -count "$count") >"$tmp/head.txt"
+
+$benchstat "$tmp/base.txt" "$tmp/head.txt" | tee "$tmp/stat.txt"
+
+# benchstat prints a table per unit under a header naming it. A row of
+# the sec/op table whose change is significant, p < 0.05, and above the
+# threshold is a regression.
+awk -v threshold="$threshold" '
+/│/ { timing = /sec\/op/; next }
+timing && /\(p=/ {
+	if (!match($0, /[+-][0-9.]+%/)) next
+	change = substr($0, RSTART, RLENGTH - 1) + 0
+
+	match($0, /p=[0-9.]+/)
+	p = substr($0, RSTART + 2, RLENGTH - 2) + 0
+
+	if (p < 0.05 && change > threshold) {
+		printf "regression: %s is %.2f%% slower\n", $1, change
+		failed = 1
+	}
+}
+END { exit failed }
+' "$tmp/stat.txt"
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"lc3/pkg/otlp"
+	"lc3/pkg/sandbox"
+	"lc3/pkg/service"
+	"lc3/pkg/ui"
+	"net/http"
+	"os"
+)
+
+// serveCommand serves the execution service over HTTP, so that
+// courses and web front-ends can run programs server-side, and with
+// -ui the simulator for the browser, "lc3 serve [-listen address]
+// [-max-instructions n] [-max-output n] [-max-io n] [-timeout d]
+// [-keep n] [-ui] [-otlp url]".
+func serveCommand(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := flags.String("listen", "localhost:8080", "serve HTTP on `address`")
+	maxInstructions := flags.Uint64("max-instructions", sandbox.DefaultMaxInstructions, "stop runs after `n` instructions")
+	maxOutput := flags.Int("max-output", sandbox.DefaultMaxOutput, "stop runs writing more than `n` bytes")
+	maxIO := flags.Uint64("max-io", sandbox.DefaultMaxIO, "stop runs making more than `n` console traps, host calls and device accesses")
+	timeout := flags.Duration("timeout", sandbox.DefaultTimeout, "stop runs after `duration`")
+	keep := flags.Int("keep", service.DefaultRetention, "keep the results of the last `n` runs")
+	withUI := flags.Bool("ui", false, "serve the simulator for the browser on /ui/")
+	otlpEndpoint := flags.String("otlp", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "export OpenTelemetry spans of the runs to the OTLP/HTTP collector at `url`, such as http://localhost:4318")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 serve [flags]\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	opts := []service.Option{
+		service.WithLimits(*maxInstructions, *maxOutput, *maxIO, *timeout),
+		service.WithRetention(*keep),
+	}
+
+	if *otlpEndpoint != "" {
+		exporter := otlp.NewExporter(*otlpEndpoint, "lc3")
+		defer exporter.Close()
+
+		opts = append(opts, service.WithTracing(exporter))
+
+		logger("server").Info("exporting spans", "endpoint", *otlpEndpoint)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", service.New(opts...))
+
+	logger("server").Info("serving runs", "url", "http://"+*listen+"/runs")
+
+	if *withUI {
+		mux.Handle("/ui/", http.StripPrefix("/ui", ui.New(ui.WithMaxInstructions(*maxInstructions))))
+
+		logger("server").Info("serving the simulator", "url", "http://"+*listen+"/ui/")
+	}
+
+	if err := http.ListenAndServe(*listen, mux); err != nil {
+		fatalf("failed to serve: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/golden"
+	"lc3/pkg/registers"
+	"lc3/pkg/state"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// saveCommand runs an image and saves the state of the machine to a
+// snapshot file once it has run a number of instructions, reached an
+// address or halted, "lc3 save [-o snapshot] [-after n] [-at address]
+// image".
+func saveCommand(args []string) {
+	flags := flag.NewFlagSet("save", flag.ExitOnError)
+	output := flags.String("o", "", "write the snapshot to `file`, in JSON if it ends in .json or protobuf if it ends in .pb, by default the image name with a .snap extension")
+	after := flags.Uint64("after", 0, "save after running `n` instructions")
+	at := flags.String("at", "", "save when the program reaches `address`, in hex as x3010 or a label")
+	sym := flags.String("sym", "", "load labels from a symbol table `file`, by default the image name with a .sym extension")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 save [flags] image-file\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	image := flags.Arg(0)
+
+	if *output == "" {
+		*output = strings.TrimSuffix(image, filepath.Ext(image)) + ".snap"
+	}
+
+	memory, err := readImage(image)
+	if err != nil {
+		fatalf("failed to load image: %s, %v", image, err)
+	}
+
+	c := cpu.NewCPU(cpuOptions(loadSetup())...)
+	c.Load(memory)
+
+	runToSave(c, *output, *after, stopAddress(image, *sym, *at))
+}
+
+// resumeCommand restores the state of a snapshot and continues running
+// the program where it left off, until it halts or, given -after or
+// -at, saves it again, "lc3 resume [-o snapshot] [-after n] [-at
+// address] snapshot".
+func resumeCommand(args []string) {
+	flags := flag.NewFlagSet("resume", flag.ExitOnError)
+	output := flags.String("o", "", "save the snapshot to `file`, by default over the snapshot resumed")
+	after := flags.Uint64("after", 0, "save again after running `n` more instructions")
+	at := flags.String("at", "", "save again when the program reaches `address`, in hex as x3010 or a label")
+	sym := flags.String("sym", "", "load labels from a symbol table `file`, by default the snapshot name with a .sym extension")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 resume [flags] snapshot-file\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	filename := flags.Arg(0)
+
+	if *output == "" {
+		*output = filename
+	}
+
+	snapshot, err := readSnapshot(filename)
+	if err != nil {
+		fatalf("failed to load snapshot: %v", err)
+	}
+
+	if snapshot.Halted {
+		fatalf("%s: the program has already halted", filename)
+	}
+
+	c := cpu.NewCPU(cpuOptions(loadSetup())...)
+	c.Restore(snapshot)
+
+	if *after == 0 && *at == "" {
+		runUntil(c, 0, nil)
+		return
+	}
+
+	runToSave(c, *output, *after, stopAddress(filename, *sym, *at))
+}
+
+// stopAddress resolves the address given with -at against the symbols
+// next to filename or in sym, returning nil if none was given.
+func stopAddress(filename, sym, at string) *uint16 {
+	if at == "" {
+		return nil
+	}
+
+	table, err := findSymbols(filename, sym)
+	if err != nil {
+		fatalf("failed to load symbols: %v", err)
+	}
+
+	address, err := golden.ResolveAddress(at, table)
+	if err != nil {
+		fatal(err)
+	}
+
+	return &address
+}
+
+// runToSave runs the CPU like runUntil and then saves its state to
+// output.
+func runToSave(c cpu.CPU, output string, after uint64, address *uint16) {
+	n := runUntil(c, after, address)
+
+	writeSnapshot(output, c.Snapshot())
+
+	status := "halted"
+	if !c.Halted() {
+		status = fmt.Sprintf("stopped at x%04X", c.Register(registers.RPC))
+	}
+
+	logger("snapshot").Info("saved snapshot", "file", output, "instructions", n, "status", status)
+}
+
+// runUntil runs the CPU until it halts, has run after instructions or
+// is about to run the instruction at address, and returns the number of
+// instructions it ran. A limit of 0 and a nil address do not stop it.
+func runUntil(c cpu.CPU, after uint64, address *uint16) uint64 {
+	restore := enableRawMode()
+	defer restore()
+
+	var n uint64
+
+	for !c.Halted() {
+		if err := c.Execute(); err != nil {
+			restore()
+			fatalf("Execution failed %v", err)
+		}
+
+		n++
+
+		if after > 0 && n >= after || address != nil && c.Register(registers.RPC) == *address {
+			break
+		}
+	}
+
+	return n
+}
+
+// readSnapshot reads a snapshot file, in JSON if its name ends in
+// .json or as a protobuf State message if it ends in .pb.
+func readSnapshot(filename string) (*cpu.Snapshot, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	snapshot := &cpu.Snapshot{}
+
+	switch snapshotFormat(filename) {
+	case ".json":
+		err = json.NewDecoder(file).Decode(snapshot)
+	case ".pb":
+		var data []byte
+		if data, err = io.ReadAll(file); err == nil {
+			snapshot, err = state.UnmarshalProto(data)
+		}
+	default:
+		snapshot, err = cpu.ReadSnapshot(file)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return snapshot, nil
+}
+
+// writeSnapshot writes a snapshot file, in JSON if its name ends in
+// .json or as a protobuf State message if it ends in .pb.
+func writeSnapshot(filename string, snapshot *cpu.Snapshot) {
+	writeFile(filename, func(w io.Writer) error {
+		switch snapshotFormat(filename) {
+		case ".json":
+			return json.NewEncoder(w).Encode(snapshot)
+		case ".pb":
+			_, err := w.Write(state.MarshalProto(snapshot))
+			return err
+		default:
+			_, err := snapshot.WriteTo(w)
+			return err
+		}
+	})
+}
+
+// snapshotFormat returns the extension of a snapshot file in lower
+// case, which tells its format.
+func snapshotFormat(filename string) string {
+	return strings.ToLower(filepath.Ext(filename))
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"io"
+	"lc3/pkg/cpu"
+	"lc3/pkg/debugger"
+	"lc3/pkg/devices"
+	"lc3/pkg/sshd"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backlogSize is how much recent console output sessions are shown
+// when they connect.
+const backlogSize = 4096
+
+// detachKey is the key, Ctrl-], that leaves the shared console.
+const detachKey = 0x1D
+
+// sshCommand serves the console of a long-running machine over SSH, or
+// with -debug a debugger on a machine of its own to every session,
+// "lc3 ssh [-listen address] [-hostkey file] [-authorized-keys file]
+// [-password-file file] [-debug] image".
+func sshCommand(args []string) {
+	flags := flag.NewFlagSet("ssh", flag.ExitOnError)
+	listen := flags.String("listen", "localhost:2222", "serve SSH on `address`")
+	hostKeyFile := flags.String("hostkey", "lc3_host_key", "identify the server with the Ed25519 key in `file`, generated if it does not exist")
+	authorizedKeys := flags.String("authorized-keys", "", "let clients log in with the keys listed in `file`, in the OpenSSH authorized_keys format")
+	passwordFile := flags.String("password-file", "", "let clients log in with the password in `file`")
+	debug := flags.Bool("debug", false, "give every session the debugger on a machine of its own instead of the shared console")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 ssh [flags] image-file\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	image, err := readImage(flags.Arg(0))
+	if err != nil {
+		fatalf("failed to load image: %s, %v", flags.Arg(0), err)
+	}
+
+	hostKey, err := sshd.LoadHostKey(*hostKeyFile)
+	if err != nil {
+		fatalf("failed to load host key: %v", err)
+	}
+
+	opts := []sshd.Option{sshd.WithLogger(logger("sshd"))}
+
+	if *authorizedKeys != "" {
+		keys, err := sshd.LoadAuthorizedKeys(*authorizedKeys)
+		if err != nil {
+			fatalf("failed to load authorized keys: %v", err)
+		}
+
+		opts = append(opts, sshd.WithAuthorizedKeys(keys))
+	}
+
+	if *passwordFile != "" {
+		password, err := os.ReadFile(*passwordFile)
+		if err != nil {
+			fatalf("failed to read password: %v", err)
+		}
+
+		opts = append(opts, sshd.WithPassword(strings.TrimRight(string(password), "\r\n")))
+	}
+
+	handler := debugSession(image)
+	if !*debug {
+		console := newSharedConsole()
+		go console.run(image)
+
+		handler = console.serve
+	}
+
+	server, err := sshd.NewServer(hostKey, handler, opts...)
+	if err != nil {
+		fatalf("failed to serve SSH: %v, give -authorized-keys or -password-file", err)
+	}
+
+	logger("sshd").Info("serving over SSH", "image", flags.Arg(0), "address", *listen, "hostkey", sshd.Fingerprint(hostKey.Public().(ed25519.PublicKey)))
+
+	if err := server.ListenAndServe(*listen); err != nil {
+		fatalf("SSH server failed %v", err)
+	}
+}
+
+// sshCPU creates a CPU with its console on a session or the shared
+// console.
+func sshCPU(in io.Reader, out io.Writer) cpu.CPU {
+	return cpu.NewCPU(
+		cpu.WithInput(in),
+		cpu.WithOutput(out),
+		cpu.WithDevice(devices.NewTerminal(out)),
+		cpu.WithDevice(devices.NewRNG(time.Now().UnixNano())),
+		cpu.WithDevice(devices.NewRTC(time.Now)),
+	)
+}
+
+// terminalWriter writes to the terminal of a session, ending lines
+// with carriage returns as terminals expect.
+type terminalWriter struct {
+	// w is the session.
+	w io.Writer
+}
+
+// Write writes p with its line feeds preceded by carriage returns.
+func (t terminalWriter) Write(p []byte) (int, error) {
+	if _, err := t.w.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// sessionOutput returns the writer for the output to a session.
+func sessionOutput(s *sshd.Session) io.Writer {
+	if _, pty := s.Terminal(); pty {
+		return terminalWriter{s}
+	}
+
+	return s
+}
+
+// sharedConsole is the console of the machine every session shares:
+// what it outputs is written to every session and what any session
+// types is its input.
+type sharedConsole struct {
+	// mu guards sessions and backlog.
+	mu sync.Mutex
+
+	// sessions are the outputs of the attached sessions.
+	sessions map[*sshd.Session]io.Writer
+
+	// backlog is the most recent output.
+	backlog []byte
+
+	// keys are the keys typed and not yet read.
+	keys chan byte
+}
+
+// newSharedConsole creates a console with no session attached.
+func newSharedConsole() *sharedConsole {
+	return &sharedConsole{
+		sessions: map[*sshd.Session]io.Writer{},
+		keys:     make(chan byte, 256),
+	}
+}
+
+// Read waits for a key. Keys are read one at a time so that none are
+// lost to readers that buffer.
+func (c *sharedConsole) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	p[0] = <-c.keys
+
+	return 1, nil
+}
+
+// Write writes to every session, detaching those that fail.
+func (c *sharedConsole) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.backlog = append(c.backlog, p...)
+	if len(c.backlog) > backlogSize {
+		c.backlog = c.backlog[len(c.backlog)-backlogSize:]
+	}
+
+	for s, out := range c.sessions {
+		if _, err := out.Write(p); err != nil {
+			delete(c.sessions, s)
+		}
+	}
+
+	return len(p), nil
+}
+
+// run runs the machine, starting the program again whenever it halts
+// or fails.
+func (c *sharedConsole) run(image [cpu.MemoryMax]uint16) {
+	for {
+		machine := sshCPU(c, c)
+
+		if err := machine.Run(image); err != nil {
+			fmt.Fprintf(c, "\n[program failed: %v, restarting]\n", err)
+		} else {
+			fmt.Fprintf(c, "\n[program halted, restarting]\n")
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+// serve attaches a session to the console until it disconnects or
+// types Ctrl-].
+func (c *sharedConsole) serve(s *sshd.Session) {
+	out := sessionOutput(s)
+	_, pty := s.Terminal()
+
+	fmt.Fprintf(out, "Attached to the shared console, press Ctrl-] to leave.\n")
+
+	c.mu.Lock()
+	out.Write(c.backlog)
+	c.sessions[s] = out
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.sessions, s)
+		c.mu.Unlock()
+	}()
+
+	buf := make([]byte, 256)
+
+	for {
+		n, err := s.Read(buf)
+
+		for _, key := range buf[:n] {
+			if key == detachKey {
+				s.Exit(0)
+				return
+			}
+
+			// terminals send a carriage return for Enter.
+			if pty && key == '\r' {
+				key = '\n'
+			}
+
+			c.keys <- key
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// debugSession returns the handler giving each session the debugger
+// on a machine of its own.
+func debugSession(image [cpu.MemoryMax]uint16) sshd.Handler {
+	return func(s *sshd.Session) {
+		out := sessionOutput(s)
+
+		var (
+			in     io.Reader = s
+			editor *lineEditor
+		)
+
+		if _, pty := s.Terminal(); pty {
+			// the terminal leaves editing lines to the server.
+			editor = &lineEditor{keys: make(chan []byte, 64), echo: out}
+			in = editor
+		}
+
+		input := bufio.NewReader(in)
+
+		dbg := debugger.New(func() cpu.CPU { return sshCPU(input, out) }, image, input, out)
+
+		if editor != nil {
+			go editor.pump(s, dbg.Interrupt)
+		}
+
+		if err := dbg.Run(); err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+			s.Exit(1)
+
+			return
+		}
+
+		s.Exit(0)
+	}
+}
+
+// lineEditor reads lines typed on a terminal in raw mode, echoing and
+// editing them as a terminal in cooked mode would.
+type lineEditor struct {
+	// keys are the keys typed, closed when the input ends.
+	keys chan []byte
+
+	// echo is the terminal output.
+	echo io.Writer
+
+	// pending are keys received and not yet edited.
+	pending []byte
+
+	// line is the line being typed.
+	line []byte
+
+	// ready is what remains of the last line completed.
+	ready []byte
+
+	// eof is set once Ctrl-D was typed on an empty line.
+	eof bool
+}
+
+// pump sends the keys typed to the editor, calling interrupt as soon
+// as Ctrl-C is typed, even while no line is read.
+func (e *lineEditor) pump(in io.Reader, interrupt func()) {
+	defer close(e.keys)
+
+	buf := make([]byte, 256)
+
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			if bytes.IndexByte(buf[:n], 0x03) >= 0 {
+				interrupt()
+			}
+
+			e.keys <- append([]byte(nil), buf[:n]...)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read reads from the last line completed, waiting for one.
+func (e *lineEditor) Read(p []byte) (int, error) {
+	for len(e.ready) == 0 {
+		if e.eof {
+			return 0, io.EOF
+		}
+
+		if err := e.edit(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, e.ready)
+	e.ready = e.ready[n:]
+
+	return n, nil
+}
+
+// edit edits keys until a line is complete.
+func (e *lineEditor) edit() error {
+	for {
+		if len(e.pending) == 0 {
+			keys, ok := <-e.keys
+			if !ok {
+				return io.EOF
+			}
+
+			e.pending = keys
+		}
+
+		key := e.pending[0]
+		e.pending = e.pending[1:]
+
+		switch key {
+		case '\r', '\n':
+			e.ready = append(e.line, '\n')
+			e.line = nil
+			e.echo.Write([]byte("\n"))
+
+			return nil
+		case 0x7F, '\b':
+			if len(e.line) > 0 {
+				e.line = e.line[:len(e.line)-1]
+				e.echo.Write([]byte("\b \b"))
+			}
+		case 0x03:
+			e.line = nil
+			e.echo.Write([]byte("^C\n"))
+		case 0x04:
+			if len(e.line) == 0 {
+				e.eof = true
+				return nil
+			}
+		default:
+			if key >= ' ' {
+				e.line = append(e.line, key)
+				e.echo.Write([]byte{key})
+			}
+		}
+	}
+}
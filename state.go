@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"lc3/pkg/cpu"
+	"lc3/pkg/golden"
+	"lc3/pkg/registers"
+	"lc3/pkg/state"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// stateCommands maps the subcommands of lc3 state to their
+// implementation.
+var stateCommands = map[string]func(args []string){
+	"diff":   stateDiffCommand,
+	"import": stateImportCommand,
+}
+
+// stateCommand works with snapshot files, "lc3 state command [flags]
+// file".
+func stateCommand(args []string) {
+	if len(args) > 0 {
+		if cmd, ok := stateCommands[args[0]]; ok {
+			cmd(args[1:])
+			return
+		}
+	}
+
+	names := make([]string, 0, len(stateCommands))
+	for name := range stateCommands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "lc3 state [%s] [flags] file...\n", strings.Join(names, " | "))
+	os.Exit(2)
+}
+
+// stateDiffCommand reports the registers and memory that changed from
+// one snapshot to another, "lc3 state diff [-all] a.snap b.snap".
+func stateDiffCommand(args []string) {
+	flags := flag.NewFlagSet("state diff", flag.ExitOnError)
+	sym := flags.String("sym", "", "load labels from a symbol table `file`, by default the first snapshot name with a .sym extension")
+	all := flags.Bool("all", false, "show every word of long ranges of changed memory")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 state diff [flags] snapshot-file snapshot-file\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	a, err := readSnapshot(flags.Arg(0))
+	if err != nil {
+		fatalf("failed to load snapshot: %v", err)
+	}
+
+	b, err := readSnapshot(flags.Arg(1))
+	if err != nil {
+		fatalf("failed to load snapshot: %v", err)
+	}
+
+	table, err := findSymbols(flags.Arg(0), *sym)
+	if err != nil {
+		fatalf("failed to load symbols: %v", err)
+	}
+
+	if err := state.Compare(a, b).Write(os.Stdout, table, *all); err != nil {
+		fatal(err)
+	}
+}
+
+// importFormats maps the extensions of memory dumps to their format,
+// the others being listings.
+var importFormats = map[string]state.ImportFormat{
+	".hex": state.ImportReadmemh,
+	".mem": state.ImportReadmemh,
+	".coe": state.ImportCOE,
+}
+
+// stateImportCommand converts memory dumps of other simulators such as
+// PennSim and Complx into a snapshot that lc3 resume runs, "lc3 state
+// import [-o snapshot] [-format format] [-origin address] [-pc address]
+// dump...".
+func stateImportCommand(args []string) {
+	flags := flag.NewFlagSet("state import", flag.ExitOnError)
+	output := flags.String("o", "", "write the snapshot to `file`, in JSON if it ends in .json or protobuf if it ends in .pb, by default the first dump name with a .snap extension")
+	formatName := flags.String("format", "", "read the dumps in a `format`: "+strings.Join(state.ImportFormatNames(), ", ")+", by default readmemh for .hex and .mem files, coe for .coe files and listing for the others")
+	origin := flags.String("origin", "x0000", "load the words of coe dumps from `address`")
+	pc := flags.String("pc", "", "start the program at `address`, by default the PC of the dumps or x3000")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 state import [flags] dump-file...\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(flags.Arg(0), filepath.Ext(flags.Arg(0))) + ".snap"
+	}
+
+	start, err := golden.ResolveAddress(*origin, nil)
+	if err != nil {
+		fatal(err)
+	}
+
+	forced, ok := state.LookupImportFormat(*formatName)
+	if *formatName != "" && !ok {
+		fatalf("unknown format %s, expected one of %s", *formatName, strings.Join(state.ImportFormatNames(), ", "))
+	}
+
+	snapshot := cpu.NewCPU().Snapshot()
+
+	for _, filename := range flags.Args() {
+		format := importFormats[strings.ToLower(filepath.Ext(filename))]
+		if ok {
+			format = forced
+		}
+
+		if err := importDump(snapshot, filename, format, start); err != nil {
+			fatalf("failed to import %v", err)
+		}
+	}
+
+	if *pc != "" {
+		address, err := golden.ResolveAddress(*pc, nil)
+		if err != nil {
+			fatal(err)
+		}
+
+		snapshot.Registers[registers.RPC] = address
+	}
+
+	writeSnapshot(*output, snapshot)
+
+	logger("snapshot").Info("saved state, resume it with lc3 resume", "file", *output)
+}
+
+// importDump reads a memory dump into a snapshot.
+func importDump(snapshot *cpu.Snapshot, filename string, format state.ImportFormat, origin uint16) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if err := state.Import(snapshot, file, format, origin); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return nil
+}
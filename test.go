@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"lc3/pkg/golden"
+	"lc3/pkg/htmlreport"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// testCommand runs programs against the golden expectations of spec
+// files, or of every .spec file in a directory, "lc3 test [-v]
+// spec...". An .asm source given instead of a spec is checked against
+// its ASSERT comments alone. Specs generating their input run -runs
+// times on seeds counting up from -seed. It exits with status 1 if any
+// spec fails.
+func testCommand(args []string) {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "report the specs that pass as well as those that fail")
+	htmlFile := flags.String("html", "", "write an HTML report of every spec to `file`")
+	program := flags.String("program", "", "run `file` instead of the program each spec names")
+	seed := flags.Int64("seed", 0, "generate input from seed `n` instead of the seed each spec gives")
+	runs := flags.Int("runs", 1, "run specs that generate their input `n` times, on consecutive seeds")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 test [flags] [spec-file | asm-file | directory] ...\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var filenames []string
+
+	for _, arg := range flags.Args() {
+		if stat, err := os.Stat(arg); err == nil && stat.IsDir() {
+			matches, err := filepath.Glob(filepath.Join(arg, "*.spec"))
+			if err != nil {
+				fatal(err)
+			}
+
+			filenames = append(filenames, matches...)
+			continue
+		}
+
+		filenames = append(filenames, arg)
+	}
+
+	seeded := false
+
+	flags.Visit(func(f *flag.Flag) {
+		seeded = seeded || f.Name == "seed"
+	})
+
+	var specs []*golden.Spec
+
+	failed := 0
+
+	for _, filename := range filenames {
+		spec, err := loadSpec(filename)
+		if err != nil {
+			fmt.Printf("FAIL %s\n\t%v\n", filename, err)
+			failed++
+			continue
+		}
+
+		if *program != "" {
+			spec.Program = *program
+		}
+
+		if seeded {
+			spec.Seed = *seed
+		}
+
+		specs = append(specs, spec)
+
+		for i := 1; spec.Template != nil && i < *runs; i++ {
+			next := *spec
+			next.Seed = spec.Seed + int64(i)
+			specs = append(specs, &next)
+		}
+	}
+
+	total := failed + len(specs)
+	report := htmlreport.New("lc3 test")
+
+	for _, spec := range specs {
+		result, err := golden.Run(spec)
+		if err != nil {
+			fmt.Printf("FAIL %s\n\t%v\n", spec.Name, err)
+			failed++
+			continue
+		}
+
+		if !result.Passed() {
+			failed++
+		}
+
+		if *htmlFile != "" {
+			report.AddResult(result)
+		}
+
+		if *verbose || !result.Passed() {
+			result.Write(os.Stdout)
+		}
+	}
+
+	if *htmlFile != "" {
+		writeFile(*htmlFile, report.Write)
+	}
+
+	if failed > 0 {
+		fmt.Printf("FAIL: %d of %d specs failed\n", failed, total)
+		os.Exit(1)
+	}
+
+	fmt.Printf("ok: %d specs passed\n", len(specs))
+}
+
+// loadSpec loads a spec file, or makes a spec with no expectations of
+// its own for an .asm source, which then only has to halt with its
+// assertions holding.
+func loadSpec(filename string) (*golden.Spec, error) {
+	if strings.EqualFold(filepath.Ext(filename), ".asm") {
+		return &golden.Spec{Name: filename, Program: filename, Limit: golden.DefaultLimit}, nil
+	}
+
+	return golden.Load(filename)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"lc3/pkg/trace"
+	"os"
+	"sort"
+	"strings"
+)
+
+// traceCommands maps the subcommands of lc3 trace to their
+// implementation.
+var traceCommands = map[string]func(args []string){
+	"diff":   traceDiffCommand,
+	"replay": traceReplayCommand,
+}
+
+// traceCommand works with the traces written by lc3 -trace, "lc3 trace
+// command [flags] trace".
+func traceCommand(args []string) {
+	if len(args) > 0 {
+		if cmd, ok := traceCommands[args[0]]; ok {
+			cmd(args[1:])
+			return
+		}
+	}
+
+	names := make([]string, 0, len(traceCommands))
+	for name := range traceCommands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "lc3 trace [%s] [flags] trace-file\n", strings.Join(names, " | "))
+	os.Exit(2)
+}
+
+// traceReplayCommand pretty-prints a trace, or the state of the machine
+// after one of its steps, "lc3 trace replay [-from step] [-to
+// step] [-at step] trace".
+func traceReplayCommand(args []string) {
+	flags := flag.NewFlagSet("trace replay", flag.ExitOnError)
+	from := flags.Uint64("from", 0, "print the instructions from `step` on")
+	to := flags.Int64("to", -1, "print the instructions up to `step`, by default the last one")
+	at := flags.Int64("at", -1, "print the registers and memory written after `step` instead of the instructions")
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 trace replay [flags] [trace-file]\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	reader, file := openTrace(flags.Arg(0))
+	defer file.Close()
+
+	writer := trace.NewWriter(os.Stdout)
+	state := trace.NewState()
+
+	for {
+		e, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			fatalf("%s: %v", flags.Arg(0), err)
+		}
+
+		if *at >= 0 {
+			state.Apply(e)
+
+			if e.Step == uint64(*at) {
+				break
+			}
+
+			continue
+		}
+
+		if *to >= 0 && e.Step > uint64(*to) {
+			break
+		}
+
+		if e.Step >= *from {
+			writer.Write(e)
+		}
+	}
+
+	if *at >= 0 {
+		if state.Last == nil || state.Last.Step != uint64(*at) {
+			fatalf("%s: the trace has no step %d", flags.Arg(0), *at)
+		}
+
+		if err := state.Write(os.Stdout); err != nil {
+			fatal(err)
+		}
+
+		return
+	}
+
+	if err := writer.Flush(); err != nil {
+		fatal(err)
+	}
+}
+
+// traceDiffCommand compares two traces and reports the first step at
+// which they differ, exiting with 1 if they do, "lc3 trace diff a b".
+func traceDiffCommand(args []string) {
+	flags := flag.NewFlagSet("trace diff", flag.ExitOnError)
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "lc3 trace diff [trace-file] [trace-file]\n")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	a, fileA := openTrace(flags.Arg(0))
+	defer fileA.Close()
+
+	b, fileB := openTrace(flags.Arg(1))
+	defer fileB.Close()
+
+	divergence, steps, err := trace.Diff(a, b)
+	if err != nil {
+		fatal(err)
+	}
+
+	if divergence == nil {
+		fmt.Printf("the traces are the same for all %d steps\n", steps)
+		return
+	}
+
+	if err := divergence.Write(os.Stdout, flags.Arg(0), flags.Arg(1)); err != nil {
+		fatal(err)
+	}
+
+	os.Exit(1)
+}
+
+// openTrace opens a trace in any format, exiting if it cannot.
+func openTrace(filename string) (*trace.Reader, *os.File) {
+	file, err := os.Open(filename)
+	if err != nil {
+		fatalf("failed to open trace: %v", err)
+	}
+
+	reader, err := trace.NewReader(file)
+	if err != nil {
+		fatalf("%s: %v", filename, err)
+	}
+
+	return reader, file
+}
@@ -0,0 +1,358 @@
+//go:build js && wasm
+
+// Command wasm runs the VM in a browser, for LC3 playgrounds that need
+// no server. Built with
+//
+//	GOOS=js GOARCH=wasm go build -o lc3.wasm ./wasm
+//
+// and started with the wasm_exec.js of the Go distribution, it sets a
+// global lc3 object:
+//
+//	lc3.load(program)        loads an object file given as a Uint8Array, or
+//	                         assembles and loads source text, returning the
+//	                         assembler's errors as an array of strings
+//	lc3.run(limit)           runs until the program halts, has run limit
+//	                         instructions if given or is stopped, resolving
+//	                         to {halted, instructions}
+//	lc3.step()               runs an instruction, resolving likewise
+//	lc3.stop()               stops a run before its next instruction
+//	lc3.onOutput(fn)         calls fn with the text the program writes
+//	lc3.sendKey(key)         types a string or a key code on the keyboard
+//	lc3.registers()          returns {R0, ..., R7, PC, cond, halted}
+//	lc3.memory(address, n)   returns n words of memory from an address
+//
+// Runs and steps return promises since the program may wait for keys.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"lc3/pkg/asm"
+	"lc3/pkg/cpu"
+	"lc3/pkg/devices"
+	"lc3/pkg/golden"
+	"lc3/pkg/registers"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall/js"
+	"time"
+)
+
+// yieldEvery is the number of instructions a run executes before
+// letting the browser handle events, such as the keys typed and the
+// output written, so that long runs do not freeze the page.
+const yieldEvery = 1 << 16
+
+// keyboard is the console input, fed keys by sendKey.
+type keyboard struct {
+	// mu guards keys.
+	mu sync.Mutex
+
+	// keys are the keys typed and not yet read.
+	keys []byte
+
+	// typed is signalled when keys are typed.
+	typed chan struct{}
+}
+
+// Read waits for a key and reads it. Keys are read one at a time so
+// that none are lost to readers that buffer.
+func (k *keyboard) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for {
+		k.mu.Lock()
+
+		if len(k.keys) > 0 {
+			p[0] = k.keys[0]
+			k.keys = k.keys[1:]
+			k.mu.Unlock()
+
+			return 1, nil
+		}
+
+		k.mu.Unlock()
+
+		<-k.typed
+	}
+}
+
+// typeKeys queues keys to be read.
+func (k *keyboard) typeKeys(keys []byte) {
+	k.mu.Lock()
+	k.keys = append(k.keys, keys...)
+	k.mu.Unlock()
+
+	select {
+	case k.typed <- struct{}{}:
+	default:
+	}
+}
+
+// console is the console output, passed to the function given to
+// onOutput.
+type console struct {
+	// fn is the function called with the output, if any.
+	fn js.Value
+}
+
+// Write passes the output to the output function.
+func (c *console) Write(p []byte) (int, error) {
+	if c.fn.Type() == js.TypeFunction {
+		c.fn.Invoke(string(p))
+	}
+
+	return len(p), nil
+}
+
+// machine is the VM driven from JavaScript.
+type machine struct {
+	// mu serializes runs and steps.
+	mu sync.Mutex
+
+	// cpu is the CPU of the loaded program.
+	cpu cpu.CPU
+
+	// keyboard is the console input.
+	keyboard *keyboard
+
+	// console is the console output.
+	console *console
+
+	// stopped is set by stop to end the current run.
+	stopped atomic.Bool
+}
+
+// newMachine creates a machine with nothing loaded.
+func newMachine() *machine {
+	m := &machine{keyboard: &keyboard{typed: make(chan struct{}, 1)}, console: &console{}}
+	m.reset()
+
+	return m
+}
+
+// reset creates a fresh CPU with the console and devices attached.
+func (m *machine) reset() {
+	m.cpu = cpu.NewCPU(
+		cpu.WithInput(m.keyboard),
+		cpu.WithOutput(m.console),
+		cpu.WithDevice(devices.NewTerminal(m.console)),
+		cpu.WithDevice(devices.NewRNG(time.Now().UnixNano())),
+		cpu.WithDevice(devices.NewRTC(time.Now)),
+	)
+}
+
+// load loads an object file or assembles and loads source text, and
+// points the PC at its origin.
+func (m *machine) load(this js.Value, args []js.Value) any {
+	if len(args) != 1 {
+		return errorList(errors.New("load takes an object or source"))
+	}
+
+	var (
+		obj *asm.Object
+		err error
+	)
+
+	if args[0].Type() == js.TypeString {
+		var diagnostics asm.Diagnostics
+
+		obj, _, diagnostics, err = asm.Assemble(strings.NewReader(args[0].String()))
+		if err == nil && len(diagnostics) > 0 {
+			list := make([]any, len(diagnostics))
+			for i, d := range diagnostics {
+				list[i] = d.Error()
+			}
+
+			return list
+		}
+	} else {
+		data := make([]byte, args[0].Get("length").Int())
+		js.CopyBytesToGo(data, args[0])
+
+		obj, err = asm.ReadObject(bytes.NewReader(data))
+	}
+
+	if err != nil {
+		return errorList(err)
+	}
+
+	var image [cpu.MemoryMax]uint16
+	copy(image[obj.Origin:], obj.Words)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reset()
+	m.cpu.Load(image)
+	m.cpu.SetRegister(registers.RPC, obj.Origin)
+
+	return []any{}
+}
+
+// errorList returns an error as the array of errors load returns.
+func errorList(err error) []any {
+	return []any{err.Error()}
+}
+
+// run runs the program until it halts, has run the number of
+// instructions given or is stopped.
+func (m *machine) run(this js.Value, args []js.Value) any {
+	limit := 0
+	if len(args) > 0 && args[0].Type() == js.TypeNumber {
+		limit = args[0].Int()
+	}
+
+	return m.execute(limit)
+}
+
+// step runs an instruction.
+func (m *machine) step(this js.Value, args []js.Value) any {
+	return m.execute(1)
+}
+
+// execute runs up to limit instructions, or until the program halts
+// if limit is 0, in the background, and returns a promise resolved
+// once it is done.
+func (m *machine) execute(limit int) any {
+	return newPromise(func() (any, error) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.stopped.Store(false)
+
+		n := 0
+		for !m.cpu.Halted() && !m.stopped.Load() && (limit == 0 || n < limit) {
+			if err := m.cpu.Execute(); err != nil {
+				return nil, err
+			}
+
+			n++
+
+			if n%yieldEvery == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		return map[string]any{"halted": m.cpu.Halted(), "instructions": n}, nil
+	})
+}
+
+// stop stops the current run before its next instruction.
+func (m *machine) stop(this js.Value, args []js.Value) any {
+	m.stopped.Store(true)
+	return nil
+}
+
+// onOutput sets the function called with the output of the program.
+func (m *machine) onOutput(this js.Value, args []js.Value) any {
+	if len(args) > 0 {
+		m.console.fn = args[0]
+	}
+
+	return nil
+}
+
+// sendKey types a string, or a single key given by its code.
+func (m *machine) sendKey(this js.Value, args []js.Value) any {
+	if len(args) == 0 {
+		return nil
+	}
+
+	var keys []byte
+
+	if args[0].Type() == js.TypeNumber {
+		keys = []byte{byte(args[0].Int())}
+	} else {
+		keys = []byte(args[0].String())
+	}
+
+	m.keyboard.typeKeys(keys)
+
+	return nil
+}
+
+// registers returns the registers, condition codes and whether the
+// program halted.
+func (m *machine) registers(this js.Value, args []js.Value) any {
+	out := map[string]any{
+		"PC":     int(m.cpu.Register(registers.RPC)),
+		"cond":   int(m.cpu.Register(registers.RCOND)),
+		"halted": m.cpu.Halted(),
+	}
+
+	for r := uint16(registers.RR0); r <= registers.RR7; r++ {
+		out[golden.RegisterName(r)] = int(m.cpu.Register(r))
+	}
+
+	return out
+}
+
+// memory returns n words of memory from an address, 1 by default.
+func (m *machine) memory(this js.Value, args []js.Value) any {
+	if len(args) == 0 {
+		return []any{}
+	}
+
+	address := args[0].Int()
+
+	n := 1
+	if len(args) > 1 {
+		n = args[1].Int()
+	}
+
+	words := make([]any, 0, n)
+	for i := 0; i < n && address+i <= math.MaxUint16; i++ {
+		words = append(words, int(m.cpu.PeekMemory(uint16(address+i))))
+	}
+
+	return words
+}
+
+// newPromise returns a promise resolved with what fn returns, run in
+// the background, or rejected with its error.
+func newPromise(fn func() (any, error)) js.Value {
+	var executor js.Func
+
+	executor = js.FuncOf(func(this js.Value, args []js.Value) any {
+		resolve, reject := args[0], args[1]
+
+		go func() {
+			defer executor.Release()
+
+			value, err := fn()
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+
+			resolve.Invoke(value)
+		}()
+
+		return nil
+	})
+
+	return js.Global().Get("Promise").New(executor)
+}
+
+func main() {
+	m := newMachine()
+
+	js.Global().Set("lc3", map[string]any{
+		"load":      js.FuncOf(m.load),
+		"run":       js.FuncOf(m.run),
+		"step":      js.FuncOf(m.step),
+		"stop":      js.FuncOf(m.stop),
+		"onOutput":  js.FuncOf(m.onOutput),
+		"sendKey":   js.FuncOf(m.sendKey),
+		"registers": js.FuncOf(m.registers),
+		"memory":    js.FuncOf(m.memory),
+	})
+
+	// the functions are called until the page goes away.
+	select {}
+}